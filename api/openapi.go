@@ -0,0 +1,356 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// operation describes an endpoint. Request and response bodies are given as values of the API types,
+// whose schemas are generated from their JSON encoding.
+type operation struct {
+	method  string
+	path    string
+	id      string
+	summary string
+	// params are path parameters
+	params   []string
+	request  interface{}
+	response interface{}
+	// status is the response for success, with errors given in errors
+	status int
+	errors map[int]string
+	// basicAuth is set for endpoints that take the secret as a basic auth password
+	basicAuth bool
+	// scope is the scope an API key needs to be given in place of the secret, if keys are accepted
+	scope string
+}
+
+var operations = []operation{
+	{
+		method:   http.MethodPost,
+		path:     "/activity",
+		id:       "reportActivity",
+		summary:  "Report activity on a resource, such as a deploy or an SSH login, so its hold isn't flagged as stale",
+		request:  ActivityRequest{},
+		response: ActivityResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body is invalid", http.StatusUnauthorized: "The secret is wrong", http.StatusNotFound: "The resource does not exist"},
+		scope:    "reserve",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/deployments",
+		id:       "finishDeployment",
+		summary:  "Report that a deployment finished, releasing the reservations linked to it",
+		request:  DeploymentRequest{},
+		response: DeploymentResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body is invalid", http.StatusUnauthorized: "The secret is wrong"},
+		scope:    "reserve",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/incidents",
+		id:       "declareIncident",
+		summary:  "Declare an incident, handing the incident resources to its commander if it is severe enough",
+		request:  IncidentRequest{},
+		response: IncidentResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body is invalid or the commander is unknown", http.StatusUnauthorized: "The secret is wrong"},
+		scope:    "admin",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/resets",
+		id:       "finishReset",
+		summary:  "Report that a resource's reset finished, giving it to the next person in line if it succeeded",
+		request:  ResetRequest{},
+		response: ResetResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body is invalid", http.StatusUnauthorized: "The secret is wrong"},
+		scope:    "reserve",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/gitlab/acquire",
+		id:       "gitlabAcquire",
+		summary:  "Join the queue for a resource as a GitLab CI job. Poll until acquired is true.",
+		request:  GitLabJobRequest{},
+		response: GitLabAcquireResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body is invalid", http.StatusUnauthorized: "The secret is wrong", http.StatusForbidden: "The resource requires approval", http.StatusConflict: "The resource is under maintenance or unhealthy"},
+		scope:    "reserve",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/gitlab/release",
+		id:       "gitlabRelease",
+		summary:  "Leave the queue for a resource as a GitLab CI job",
+		request:  GitLabJobRequest{},
+		response: GitLabReleaseResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body is invalid", http.StatusUnauthorized: "The secret is wrong", http.StatusNotFound: "The resource does not exist"},
+		scope:    "reserve",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/automation/reserve",
+		id:       "automationReserve",
+		summary:  "Join the queue for a resource on behalf of an automation. Calling it again is harmless.",
+		request:  AutomationRequest{},
+		response: AutomationReserveResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body is invalid", http.StatusUnauthorized: "The secret is wrong", http.StatusNotFound: "The resource does not exist", http.StatusForbidden: "The resource requires approval", http.StatusConflict: "The resource is under maintenance or unhealthy"},
+		scope:    "reserve",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/automation/release",
+		id:       "automationRelease",
+		summary:  "Leave the queue for a resource on behalf of an automation",
+		request:  AutomationRequest{},
+		response: AutomationReleaseResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body is invalid", http.StatusUnauthorized: "The secret is wrong", http.StatusNotFound: "The resource does not exist"},
+		scope:    "reserve",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/forget",
+		id:       "forgetUser",
+		summary:  "Delete everything kept about a user, for when they ask to be forgotten, and report what was deleted",
+		request:  ForgetRequest{},
+		response: ForgetResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body is invalid", http.StatusUnauthorized: "The API key is wrong"},
+		scope:    "admin",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/import",
+		id:       "importResources",
+		summary:  "Import resources in bulk from a CSV file, reporting what became of each row",
+		request:  ImportRequest{},
+		response: ImportResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The body or the CSV file is invalid", http.StatusUnauthorized: "The API key is wrong"},
+		scope:    "admin",
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/ide/status",
+		id:       "ideStatus",
+		summary:  "Summarize the calling user's reservations. Authenticate with the personal token from the `ide token` command as the secret.",
+		response: IDEStatusResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusUnauthorized: "The token is wrong"},
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/timeline/{env}/{name}",
+		id:       "timeline",
+		summary:  "List the holds of a resource over the last week, or the number of days given by the days query parameter. With format=svg, they are drawn as a timeline image instead.",
+		params:   []string{"env", "name"},
+		response: TimelineResponse{},
+		status:   http.StatusOK,
+		errors:   map[int]string{http.StatusBadRequest: "The path or days are invalid", http.StatusUnauthorized: "The secret is wrong", http.StatusNotFound: "The resource does not exist"},
+		scope:    "read",
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/terraform/{env}/{name}",
+		id:        "terraformLock",
+		summary:   "Lock a resource for Terraform. Terraform's http backend sends this as LOCK.",
+		params:    []string{"env", "name"},
+		request:   TerraformLockInfo{},
+		status:    http.StatusOK,
+		errors:    map[int]string{http.StatusUnauthorized: "The password is wrong", http.StatusLocked: "The resource is held by someone else, who is described in the body"},
+		basicAuth: true,
+		scope:     "reserve",
+	},
+	{
+		method:    http.MethodDelete,
+		path:      "/terraform/{env}/{name}",
+		id:        "terraformUnlock",
+		summary:   "Unlock a resource for Terraform. Terraform's http backend sends this as UNLOCK.",
+		params:    []string{"env", "name"},
+		request:   TerraformLockInfo{},
+		status:    http.StatusOK,
+		errors:    map[int]string{http.StatusUnauthorized: "The password is wrong"},
+		basicAuth: true,
+		scope:     "reserve",
+	},
+}
+
+// Spec returns the OpenAPI 3 document describing the API
+func Spec() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+
+	for _, op := range operations {
+		o := map[string]interface{}{
+			"operationId": op.id,
+			"summary":     op.summary,
+			"security":    security(op),
+		}
+		if len(op.params) > 0 {
+			params := []interface{}{}
+			for _, p := range op.params {
+				params = append(params, map[string]interface{}{
+					"name":     p,
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+			}
+			o["parameters"] = params
+		}
+		if op.request != nil {
+			o["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(ref(schemas, op.request)),
+			}
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(op.status)}
+		if op.response != nil {
+			success["content"] = jsonContent(ref(schemas, op.response))
+		}
+		responses := map[string]interface{}{
+			strconv.Itoa(op.status): success,
+		}
+		for status, desc := range op.errors {
+			resp := map[string]interface{}{"description": desc}
+			if status == http.StatusLocked {
+				resp["content"] = jsonContent(ref(schemas, TerraformLockInfo{}))
+			}
+			responses[strconv.Itoa(status)] = resp
+		}
+		if op.scope != "" {
+			o["x-api-key-scope"] = op.scope
+			forbidden := "The API key doesn't have the " + op.scope + " scope"
+			if desc, ok := op.errors[http.StatusForbidden]; ok {
+				forbidden = desc + ", or the API key doesn't have the " + op.scope + " scope"
+			}
+			responses[strconv.Itoa(http.StatusForbidden)] = map[string]interface{}{"description": forbidden}
+			responses[strconv.Itoa(http.StatusTooManyRequests)] = map[string]interface{}{"description": "The API key is over its rate limit. Retry-After says when to try again."}
+		}
+		o["responses"] = responses
+
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]interface{}{}
+		}
+		paths[op.path][strings.ToLower(op.method)] = o
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "reservebot",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"secret": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "header",
+					"name": SecretHeader,
+				},
+				"basic": map[string]interface{}{
+					"type":   "http",
+					"scheme": "basic",
+				},
+			},
+		},
+	}
+}
+
+// SpecHandler serves the OpenAPI document as JSON
+func SpecHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Spec())
+	})
+}
+
+func security(op operation) []interface{} {
+	if op.basicAuth {
+		return []interface{}{map[string]interface{}{"basic": []string{}}}
+	}
+	return []interface{}{map[string]interface{}{"secret": []string{}}}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// ref adds the schema of a struct to the components and returns a reference to it
+func ref(schemas map[string]interface{}, v interface{}) map[string]interface{} {
+	t := reflect.TypeOf(v)
+	if _, ok := schemas[t.Name()]; !ok {
+		schemas[t.Name()] = schema(t)
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema generates the JSON schema of a type from how encoding/json encodes it
+func schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schema(t.Elem())}
+	case t.Kind() == reflect.Ptr:
+		return schema(t.Elem())
+	case t.Kind() != reflect.Struct:
+		return map[string]interface{}{}
+	}
+
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schema(f.Type)
+
+		omitempty := false
+		for _, opt := range tag[1:] {
+			omitempty = omitempty || opt == "omitempty"
+		}
+		if !omitempty {
+			required = append(required, name)
+		}
+	}
+
+	ret := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		ret["required"] = required
+	}
+	return ret
+}
@@ -0,0 +1,283 @@
+// Package api defines the bodies of reservebot's HTTP API and describes the API as an OpenAPI
+// document. The handler serves these types and the client package sends them, so they can't drift.
+package api
+
+import (
+	"time"
+)
+
+// SecretHeader carries the shared secret that API requests must present. Clients that can't set
+// headers may send the secret as the basic auth password instead.
+const SecretHeader = "X-Reservebot-Secret"
+
+// DeploymentStatusSuccess is the status a deployment reports when it succeeded
+const DeploymentStatusSuccess = "success"
+
+// DeploymentRequest reports that a deployment finished
+type DeploymentRequest struct {
+	// Deployment is the ID given to `reserve --deploy`
+	Deployment string `json:"deployment"`
+	// Status is success, or anything describing a failure
+	Status string `json:"status"`
+}
+
+type DeploymentResponse struct {
+	// Released is how many reservations were linked to the deployment and released
+	Released int `json:"released"`
+}
+
+// ResetStatusSuccess is the status a reset reports when it succeeded
+const ResetStatusSuccess = "success"
+
+// ResetHookRequest is posted to a resource's reset hook when its holder releases it. A hook that answers
+// 202 Accepted reports the outcome later with a ResetRequest. Any other 2xx answer means the reset is
+// done.
+type ResetHookRequest struct {
+	// Resource is formatted as env|name
+	Resource      string `json:"resource"`
+	ReservationID string `json:"reservation_id"`
+	// User is the ID of the user who released the resource
+	User string `json:"user"`
+}
+
+// ResetRequest reports that the reset of a resource finished
+type ResetRequest struct {
+	// Resource is formatted as env|name
+	Resource string `json:"resource"`
+	// ReservationID is the reservation_id the hook was called with. If given, the report is ignored
+	// unless the reset is still for that reservation.
+	ReservationID string `json:"reservation_id,omitempty"`
+	// Status is success, or anything describing a failure
+	Status string `json:"status"`
+}
+
+type ResetResponse struct {
+	// Finished is false if no reset of the resource was pending, e.g. because it was already reported
+	Finished bool `json:"finished"`
+}
+
+// ActivityRequest reports activity on a resource, which shows that whoever holds it is still using it
+type ActivityRequest struct {
+	// Resource is formatted as env|name
+	Resource string `json:"resource"`
+	// Source describes the activity, such as deploy or ssh
+	Source string `json:"source,omitempty"`
+}
+
+type ActivityResponse struct {
+	// Holder is the ID of the user holding the resource, if anyone is
+	Holder string `json:"holder,omitempty"`
+}
+
+// IncidentRequest declares an incident, such as from an incident management tool
+type IncidentRequest struct {
+	// Severity is the severity of the incident, where 1 is the most severe
+	Severity int `json:"severity"`
+	// Commander is the Slack user ID of the incident commander
+	Commander string `json:"commander"`
+	// Title describes the incident
+	Title string `json:"title,omitempty"`
+}
+
+type IncidentResponse struct {
+	// Preempted are the resources handed to the commander, formatted as env|name. It is empty if the
+	// incident isn't severe enough.
+	Preempted []string `json:"preempted"`
+}
+
+// GitLabJobRequest identifies a GitLab CI job and the resource it wants. The fields correspond to
+// GitLab's predefined CI variables.
+type GitLabJobRequest struct {
+	// Resource is formatted as env|name
+	Resource string `json:"resource"`
+	// JobID is CI_JOB_ID
+	JobID string `json:"job_id"`
+	// JobURL is CI_JOB_URL
+	JobURL string `json:"job_url,omitempty"`
+	// Project is CI_PROJECT_PATH
+	Project string `json:"project,omitempty"`
+	// Ref is CI_COMMIT_REF_NAME
+	Ref string `json:"ref,omitempty"`
+	// User is GITLAB_USER_LOGIN
+	User string `json:"user,omitempty"`
+}
+
+type GitLabAcquireResponse struct {
+	ReservationID string `json:"reservation_id"`
+	// Position is the job's one-based position in the queue
+	Position int `json:"position"`
+	// Acquired is set once the job holds the resource. Jobs poll acquire until it is.
+	Acquired bool `json:"acquired"`
+}
+
+type GitLabReleaseResponse struct {
+	// Released is false if the job wasn't in the queue, e.g. because it was already released
+	Released bool `json:"released"`
+}
+
+// AutomationRequest reserves or releases a resource on behalf of an automation, such as an autoscaler
+// or a nightly job started by a monitoring system, or a service account
+type AutomationRequest struct {
+	// Resource is formatted as env|name
+	Resource string `json:"resource"`
+	// Identity names the automation, such as autoscaler. It holds reservations under this name. It is
+	// ignored for service accounts, which hold them under their own.
+	Identity string `json:"identity,omitempty"`
+	// Run identifies the run, such as a pipeline number
+	Run string `json:"run,omitempty"`
+	// Reason describes the run, such as nightly perf run, and is shown to people waiting
+	Reason string `json:"reason,omitempty"`
+	// URL links to the run, such as a dashboard or alert
+	URL string `json:"url,omitempty"`
+	// For is how long the run holds the resource at most, such as 4h, in case it never releases it
+	For string `json:"for,omitempty"`
+}
+
+type AutomationReserveResponse struct {
+	ReservationID string `json:"reservation_id"`
+	// Position is the automation's one-based position in the queue
+	Position int `json:"position"`
+	// Holding is set if the automation holds the resource. Otherwise it waits in line like anyone else.
+	Holding bool `json:"holding"`
+}
+
+type AutomationReleaseResponse struct {
+	// Released is false if the automation wasn't in the queue, e.g. because it was already released
+	Released bool `json:"released"`
+}
+
+// ForgetRequest deletes everything kept about a user, for when they ask to be forgotten
+type ForgetRequest struct {
+	// User is the Slack user ID of the user
+	User string `json:"user"`
+}
+
+// ForgetResponse reports what was deleted about a user
+type ForgetResponse struct {
+	User string `json:"user"`
+	// Queues are the resources the user was taken out of line for, formatted as env|name
+	Queues []string `json:"queues"`
+	// Resources are those the user was removed from as a watcher, approver, owner or requester
+	Resources []string `json:"resources"`
+	// Holds, Usage, Snapshots and Events count the past holds, usage records, snapshots and recent
+	// events the user was deleted from
+	Holds      int  `json:"holds"`
+	Usage      int  `json:"usage"`
+	Snapshots  int  `json:"snapshots"`
+	Events     int  `json:"events"`
+	DefaultEnv bool `json:"default_env"`
+	// StatusFilter is set if the user's saved status filter was deleted
+	StatusFilter bool `json:"status_filter"`
+	// MilestonesOff is set if the user's choice not to be told about milestones in line was deleted
+	MilestonesOff bool `json:"milestones_off"`
+	// Token is set if the Slack token the user granted for status sync was deleted
+	Token bool `json:"token"`
+	// Scheduled counts the commands the user had waiting to run later, which were cancelled
+	Scheduled int `json:"scheduled"`
+	// Notifications counts the DMs to the user that were waiting to be delivered
+	Notifications int `json:"notifications"`
+	// Retained describes what the bot couldn't delete, such as audit entries already written to the log
+	Retained []string `json:"retained"`
+}
+
+// ImportRequest imports resources in bulk from a CSV file, like the `import resources` command
+type ImportRequest struct {
+	// CSV is the file's contents. Its header names the columns: name, and env, owner, description and
+	// capacity if they are given.
+	CSV string `json:"csv"`
+	// DryRun checks the rows without importing them
+	DryRun bool `json:"dry_run"`
+}
+
+// ImportResponse reports what became of each row of an import
+type ImportResponse struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Failed    int `json:"failed"`
+	// DryRun is set if nothing was imported, and the results are what would have become of the rows
+	DryRun bool        `json:"dry_run"`
+	Rows   []ImportRow `json:"rows"`
+}
+
+// Results of importing a row
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportFailed    = "failed"
+)
+
+// ImportRow is what became of a row of an import
+type ImportRow struct {
+	// Row is the row's number in the file, counting from 1 after the header
+	Row      int    `json:"row"`
+	Resource string `json:"resource,omitempty"`
+	// Result is ImportCreated, ImportUpdated, ImportUnchanged or ImportFailed
+	Result string `json:"result"`
+	// Error says why the row failed
+	Error string `json:"error,omitempty"`
+}
+
+// IDEStatusResponse summarizes the calling user's reservations for an editor's status bar
+type IDEStatusResponse struct {
+	// User is the Slack user ID the token belongs to
+	User string `json:"user"`
+	// Summary is a short description for the status bar, e.g. "You hold staging (1 waiting)"
+	Summary string    `json:"summary"`
+	Holding []IDEHold `json:"holding"`
+	Waiting []IDEWait `json:"waiting"`
+}
+
+// IDEHold is a resource the user holds
+type IDEHold struct {
+	// Resource is formatted as env|name
+	Resource string `json:"resource"`
+	// Waiting is how many users are in line behind the user
+	Waiting int `json:"waiting"`
+	// Expires is when the hold is released automatically, if it has a duration
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// IDEWait is a resource the user is in line for
+type IDEWait struct {
+	// Resource is formatted as env|name
+	Resource string `json:"resource"`
+	// Position is the user's one-based position in the queue
+	Position int `json:"position"`
+	// Holder is the name of the user holding the resource
+	Holder string `json:"holder"`
+}
+
+// TerraformLockInfo is the lock description Terraform's http backend sends when locking and unlocking,
+// and expects back when a lock is refused
+type TerraformLockInfo struct {
+	ID        string    `json:"ID"`
+	Operation string    `json:"Operation"`
+	Info      string    `json:"Info"`
+	Who       string    `json:"Who"`
+	Version   string    `json:"Version"`
+	Created   time.Time `json:"Created"`
+	Path      string    `json:"Path"`
+}
+
+// TimelineResponse lists the holds of a resource over a period, oldest first, to show when it is
+// contended. A hold that hasn't ended yet ends at To.
+type TimelineResponse struct {
+	// Resource is formatted as env|name
+	Resource string         `json:"resource"`
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Holds    []TimelineHold `json:"holds"`
+}
+
+// TimelineHold is a hold of a resource in a timeline
+type TimelineHold struct {
+	// User is the Slack user ID of the holder, or the name of an external holder such as a CI job
+	User  string    `json:"user"`
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Current is set for the hold that hasn't ended yet
+	Current bool `json:"current,omitempty"`
+}
@@ -0,0 +1,213 @@
+// Package client is a typed Go client for reservebot's HTTP API, described at /openapi.json
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/api"
+)
+
+// Error is returned when the API responds with an error status
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("reservebot returned %d: %s", e.StatusCode, e.Message)
+}
+
+// LockedError is returned when a Terraform lock is refused because someone else holds the resource
+type LockedError struct {
+	Holder *api.TerraformLockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("resource is held by %s", e.Holder.Who)
+}
+
+// Client calls the API of a reservebot instance
+type Client struct {
+	baseURL string
+	secret  string
+	client  *http.Client
+}
+
+// New returns a client for the reservebot at baseURL, e.g. https://reservebot.example.com, which
+// authenticates with the shared secret
+func New(baseURL, secret string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// FinishDeployment reports that a deployment finished, releasing the reservations linked to it.
+// status is api.DeploymentStatusSuccess or anything describing a failure.
+func (c *Client) FinishDeployment(deployment, status string) (*api.DeploymentResponse, error) {
+	req := &api.DeploymentRequest{
+		Deployment: deployment,
+		Status:     status,
+	}
+	resp := &api.DeploymentResponse{}
+	if err := c.do(http.MethodPost, "/deployments", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// GitLabAcquire joins the queue for a resource as a GitLab CI job. Call it until Acquired is true.
+func (c *Client) GitLabAcquire(req *api.GitLabJobRequest) (*api.GitLabAcquireResponse, error) {
+	resp := &api.GitLabAcquireResponse{}
+	if err := c.do(http.MethodPost, "/gitlab/acquire", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// GitLabRelease leaves the queue for a resource as a GitLab CI job
+func (c *Client) GitLabRelease(req *api.GitLabJobRequest) (*api.GitLabReleaseResponse, error) {
+	resp := &api.GitLabReleaseResponse{}
+	if err := c.do(http.MethodPost, "/gitlab/release", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// AutomationReserve joins the queue for a resource on behalf of an automation
+func (c *Client) AutomationReserve(req *api.AutomationRequest) (*api.AutomationReserveResponse, error) {
+	resp := &api.AutomationReserveResponse{}
+	if err := c.do(http.MethodPost, "/automation/reserve", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// AutomationRelease leaves the queue for a resource on behalf of an automation
+func (c *Client) AutomationRelease(req *api.AutomationRequest) (*api.AutomationReleaseResponse, error) {
+	resp := &api.AutomationReleaseResponse{}
+	if err := c.do(http.MethodPost, "/automation/release", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ForgetUser deletes everything kept about a user and reports what was deleted. The client must be
+// created with an admin API key.
+func (c *Client) ForgetUser(userID string) (*api.ForgetResponse, error) {
+	resp := &api.ForgetResponse{}
+	if err := c.do(http.MethodPost, "/forget", &api.ForgetRequest{User: userID}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ImportResources imports resources in bulk from the contents of a CSV file and reports what became of
+// each row. With dryRun, the rows are only checked. The client must be created with an admin API key.
+func (c *Client) ImportResources(csv string, dryRun bool) (*api.ImportResponse, error) {
+	resp := &api.ImportResponse{}
+	if err := c.do(http.MethodPost, "/import", &api.ImportRequest{CSV: csv, DryRun: dryRun}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// IDEStatus summarizes the reservations of the user whose personal token from the `ide token` command
+// the client was created with
+func (c *Client) IDEStatus() (*api.IDEStatusResponse, error) {
+	resp := &api.IDEStatusResponse{}
+	if err := c.do(http.MethodGet, "/ide/status", nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// TerraformLock locks a resource the way Terraform's http backend does. A *LockedError describing the
+// holder is returned if the resource is held by someone else.
+func (c *Client) TerraformLock(env, name string, lock *api.TerraformLockInfo) error {
+	return c.do(http.MethodPost, terraformPath(env, name), lock, nil)
+}
+
+// TerraformUnlock releases a Terraform lock
+func (c *Client) TerraformUnlock(env, name string, lock *api.TerraformLockInfo) error {
+	return c.do(http.MethodDelete, terraformPath(env, name), lock, nil)
+}
+
+func terraformPath(env, name string) string {
+	return resourcePath("/terraform/", env, name)
+}
+
+// Timeline lists the holds of a resource over the last number of days
+func (c *Client) Timeline(env, name string, days int) (*api.TimelineResponse, error) {
+	resp := &api.TimelineResponse{}
+	path := resourcePath("/timeline/", env, name) + "?days=" + strconv.Itoa(days)
+	if err := c.do(http.MethodGet, path, nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// resourcePath returns the path of a resource under a prefix, as /<env>/<name>, or /<name> for a
+// resource without an env
+func resourcePath(prefix, env, name string) string {
+	if env == "" {
+		return prefix + url.PathEscape(name)
+	}
+	return prefix + url.PathEscape(env) + "/" + url.PathEscape(name)
+}
+
+// do sends a JSON request, if in is given, and decodes the JSON response into out, if given
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(api.SecretHeader, c.secret)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusLocked {
+		holder := &api.TerraformLockInfo{}
+		if err := json.NewDecoder(resp.Body).Decode(holder); err != nil {
+			return err
+		}
+		return &LockedError{Holder: holder}
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return &Error{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(msg)),
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,110 @@
+// Command databench checks that a storage backend conforms to data.Manager, then runs the benchmarks
+// and a synthetic load test against it. Running it against Redis removes every resource in the
+// selected database, so point it at a database that isn't used by the bot.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/data/bench"
+	"github.com/ameliagapin/reservebot/data/managertest"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	backend   string
+	resources int
+	users     int
+	ops       int
+	workers   int
+	seed      int64
+	skipCheck bool
+	skipBench bool
+	skipLoad  bool
+	redisAddr string
+	redisPass string
+	redisDB   int
+)
+
+func main() {
+	flag.StringVar(&backend, "backend", "memory", "Backend to test: memory or redis")
+	flag.IntVar(&resources, "resources", 100, "Number of resources")
+	flag.IntVar(&users, "users", 50, "Number of users")
+	flag.IntVar(&ops, "ops", 10000, "Number of operations in the load test")
+	flag.IntVar(&workers, "workers", 4, "Number of concurrent workers in the load test")
+	flag.Int64Var(&seed, "seed", 1, "Random seed for the load test")
+	flag.BoolVar(&skipCheck, "skip-conformance", false, "Skip the conformance checks")
+	flag.BoolVar(&skipBench, "skip-bench", false, "Skip the per-operation benchmarks")
+	flag.BoolVar(&skipLoad, "skip-load", false, "Skip the load test")
+
+	flag.StringVar(&redisAddr, "redis-address", util.LookupEnvOrString("REDIS_ADDRESS", "localhost:6379"), "Redis Database Address")
+	flag.StringVar(&redisPass, "redis-pw", util.LookupEnvOrString("REDIS_PASS", ""), "Redis Database Password")
+	flag.IntVar(&redisDB, "redis-database", util.LookupEnvOrInt("REDIS_DB", 15), "Redis Database")
+
+	flag.Parse()
+
+	var factory bench.Factory
+	switch backend {
+	case "memory":
+		factory = func() (data.Manager, error) {
+			return data.NewMemory(), nil
+		}
+	case "redis":
+		factory = func() (data.Manager, error) {
+			m := data.NewRedis(redisAddr, redisPass, redisDB)
+			return m, reset(m)
+		}
+	default:
+		log.Errorf("Unknown backend %q", backend)
+		os.Exit(1)
+	}
+
+	if !skipCheck {
+		if err := managertest.Check(managertest.Factory(factory)); err != nil {
+			log.Errorf("%s", err)
+			os.Exit(1)
+		}
+		fmt.Println("Conformance checks passed")
+	}
+
+	if !skipBench {
+		benchmarks := bench.Benchmarks(factory, resources)
+		for i, res := range bench.Run(benchmarks) {
+			fmt.Printf("%-20s %s\n", benchmarks[i].Name, res)
+		}
+	}
+
+	if !skipLoad {
+		m, err := factory()
+		if err != nil {
+			log.Errorf("%+v", err)
+			os.Exit(1)
+		}
+		res, err := bench.Load(m, bench.Config{
+			Resources: resources,
+			Users:     users,
+			Ops:       ops,
+			Workers:   workers,
+			Seed:      seed,
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			os.Exit(1)
+		}
+		fmt.Println(res)
+	}
+}
+
+// reset removes all resources so that each run starts empty
+func reset(m data.Manager) error {
+	for _, r := range m.GetResources() {
+		if err := m.RemoveResource(r.Name, r.Env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Package command parses messages sent to the bot into structured commands. Messages are split into
+// tokens, matched against the grammar of each command, and either returned as a Command or rejected
+// with an Error that explains what was wrong.
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	emojiRegex  = regexp.MustCompile(`^:[a-z0-9_+'-]+:$`)
+	ticketRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]+-[0-9]+$`)
+)
+
+// Command is a parsed message
+type Command struct {
+	// Action identifies the command, e.g. "reserve" or "removeme"
+	Action string
+	// Args are the positional arguments of commands that take them
+	Args []string
+	// Resources are the resources given to commands that take a comma separated list
+	Resources []string
+	// Mentions are the IDs of mentioned users
+	Mentions []string
+	// Emoji is a trailing emoji, for commands that accept one
+	Emoji string
+	// Duration is the value of a trailing `for <duration>`, for commands that accept one
+	Duration time.Duration
+	// Ticket is a trailing ticket ID such as JIRA-123, for commands that accept one
+	Ticket string
+	// At is the value of a trailing `at <time>`, as given, for commands that can be scheduled
+	At string
+	// Flags maps the name of each flag given to its value, which is empty for boolean flags
+	Flags map[string]string
+}
+
+// HasFlag returns whether the named flag was given
+func (c *Command) HasFlag(name string) bool {
+	_, ok := c.Flags[name]
+	return ok
+}
+
+// Rest joins the positional arguments from index i onwards
+func (c *Command) Rest(i int) string {
+	if i >= len(c.Args) {
+		return ""
+	}
+	return strings.Join(c.Args[i:], " ")
+}
+
+// Error is a message that could not be parsed
+type Error struct {
+	// Unknown is set when the message isn't any known command
+	Unknown bool
+	// Reason explains what is wrong
+	Reason string
+	// Token is the part of the message that caused the error, if any
+	Token string
+	// Usage shows how the command should be written
+	Usage string
+	// Name is the name of the command that was misused, if it is known
+	Name string
+}
+
+func (e *Error) Error() string {
+	msg := e.Reason
+	if e.Token != "" {
+		msg += fmt.Sprintf(": `%s`", e.Token)
+	}
+	if e.Usage != "" {
+		msg += fmt.Sprintf(". Usage: `%s`", e.Usage)
+	}
+	return msg
+}
+
+// IsEmoji returns whether text is an emoji code such as :database:
+func IsEmoji(text string) bool {
+	return emojiRegex.MatchString(text)
+}
+
+// IsTicket returns whether text is a ticket ID such as JIRA-123
+func IsTicket(text string) bool {
+	return ticketRegex.MatchString(text)
+}
+
+// Parse parses a message. A mention of the bot at the start of the message is ignored.
+func Parse(text string) (*Command, error) {
+	tokens, err := Tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) > 0 && tokens[0].Kind == Mention {
+		tokens = tokens[1:]
+	}
+
+	candidates := match(tokens)
+	if len(candidates) == 0 {
+		return nil, &Error{Unknown: true, Reason: "unknown command"}
+	}
+
+	// Commands sharing keywords are tried in order and the first to parse wins
+	var last error
+	for _, s := range candidates {
+		cmd, err := s.parse(tokens[len(s.keywords):])
+		if err == nil {
+			return cmd, nil
+		}
+		last = err
+	}
+	return nil, last
+}
+
+// match returns the commands whose keywords begin the message, preferring those with the most keywords
+func match(tokens []Token) []*spec {
+	ret := []*spec{}
+	longest := 0
+	for _, s := range grammar {
+		if !s.matches(tokens) || len(s.keywords) < longest {
+			continue
+		}
+		if len(s.keywords) > longest {
+			ret = nil
+			longest = len(s.keywords)
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
@@ -0,0 +1,274 @@
+package command_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ameliagapin/reservebot/command"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want *command.Command
+	}{
+		// quoting and backticks
+		{
+			text: "reserve `dev|db`, `dev|api`",
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db", "dev|api"}},
+		},
+		{
+			text: `reserve "dev|my db"`,
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|my db"}},
+		},
+		{
+			text: `maintenance dev|db 14:00 1h "db upgrade"`,
+			want: &command.Command{Action: "maintenance", Args: []string{"dev|db", "14:00", "1h", "db upgrade"}},
+		},
+		{
+			text: "label dev|db “in use”",
+			want: &command.Command{Action: "label", Args: []string{"dev|db", "in use"}},
+		},
+		// flags
+		{
+			text: "reserve dev|db --key=abc",
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db"}, Flags: map[string]string{"key": "abc"}},
+		},
+		{
+			text: "reserve dev|db --key abc --priority 2",
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db"}, Flags: map[string]string{"key": "abc", "priority": "2"}},
+		},
+		{
+			text: `reserve --by "tomorrow 5pm" --drop dev|db`,
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db"}, Flags: map[string]string{"by": "tomorrow 5pm", "drop": ""}},
+		},
+		{
+			text: "release dev|db --key",
+			want: &command.Command{Action: "release", Resources: []string{"dev|db"}, Flags: map[string]string{"key": ""}},
+		},
+		{
+			text: "create dev|db --shared :database:",
+			want: &command.Command{Action: "create", Resources: []string{"dev|db"}, Emoji: ":database:", Flags: map[string]string{"shared": ""}},
+		},
+		{
+			text: "STATUS --all",
+			want: &command.Command{Action: "all_status", Flags: map[string]string{"all": ""}},
+		},
+		// a leading mention is the bot, other mentions are arguments
+		{
+			text: "<@UBOT> status",
+			want: &command.Command{Action: "all_status"},
+		},
+		{
+			text: "kick <@U2>",
+			want: &command.Command{Action: "kick", Mentions: []string{"U2"}},
+		},
+		{
+			text: "incident SEV1 <@U2> checkout is down",
+			want: &command.Command{Action: "incident", Args: []string{"SEV1", "U2", "checkout", "is", "down"}, Mentions: []string{"U2"}},
+		},
+		// durations, at and tickets
+		{
+			text: "reserve dev|db for 2h",
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db"}, Duration: 2 * time.Hour},
+		},
+		{
+			text: "reserve dev|db at 2pm",
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db"}, At: "2pm"},
+		},
+		{
+			text: "reserve dev|db for 30m at 14:00",
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db"}, Duration: 30 * time.Minute, At: "14:00"},
+		},
+		{
+			text: "reserve dev|db at 14:00 for 30m",
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db"}, Duration: 30 * time.Minute, At: "14:00"},
+		},
+		{
+			text: "reserve dev|db, dev|api JIRA-123 for 1h",
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db", "dev|api"}, Duration: time.Hour, Ticket: "JIRA-123"},
+		},
+		{
+			text: "reserve dev|db JIRA-123 for 1h at 9am",
+			want: &command.Command{Action: "reserve", Resources: []string{"dev|db"}, Duration: time.Hour, Ticket: "JIRA-123", At: "9am"},
+		},
+		{
+			text: "reserve JIRA-123",
+			want: &command.Command{Action: "reserve", Resources: []string{"JIRA-123"}},
+		},
+		{
+			text: "release dev|db for 1h",
+			want: &command.Command{Action: "release", Resources: []string{"dev|db for 1h"}},
+		},
+		// keywords used as resource names
+		{
+			text: "reserve env",
+			want: &command.Command{Action: "reserve", Resources: []string{"env"}},
+		},
+		{
+			text: "status env",
+			want: &command.Command{Action: "single_status", Args: []string{"env"}},
+		},
+		{
+			text: "clear env staging",
+			want: &command.Command{Action: "clearenv", Args: []string{"staging"}},
+		},
+		{
+			text: "clear `env`",
+			want: &command.Command{Action: "clear", Resources: []string{"env"}},
+		},
+		{
+			text: "clear dev|env",
+			want: &command.Command{Action: "clear", Resources: []string{"dev|env"}},
+		},
+		{
+			text: "remove resource me",
+			want: &command.Command{Action: "removeresource", Args: []string{"me"}},
+		},
+		{
+			text: "remove me from for",
+			want: &command.Command{Action: "removeme", Resources: []string{"for"}},
+		},
+		{
+			text: "settings dev|db default-env none",
+			want: &command.Command{Action: "settings", Args: []string{"dev|db", "default-env", "none"}},
+		},
+	}
+
+	for _, tt := range tests {
+		if tt.want.Flags == nil {
+			tt.want.Flags = map[string]string{}
+		}
+		got, err := command.Parse(tt.text)
+		if err != nil {
+			t.Errorf("Parse(%q) returned %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) returned %+v, expected %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want command.Error
+	}{
+		{
+			text: "make me a sandwich",
+			want: command.Error{Unknown: true, Reason: "unknown command"},
+		},
+		{
+			text: "",
+			want: command.Error{Unknown: true, Reason: "unknown command"},
+		},
+		{
+			text: `label dev|db "in use`,
+			want: command.Error{Reason: "a quote was never closed", Token: `"in use`},
+		},
+		{
+			text: "reserve dev|db --urgent",
+			want: command.Error{Reason: "unknown flag", Token: "--urgent", Name: "reserve"},
+		},
+		{
+			text: "reserve dev|db for soon",
+			want: command.Error{Reason: "durations must be formatted like `30m` or `2h`", Token: "soon", Name: "reserve"},
+		},
+		{
+			text: "reserve dev|db for -1h",
+			want: command.Error{Reason: "durations must be formatted like `30m` or `2h`", Token: "-1h", Name: "reserve"},
+		},
+		{
+			text: "nuke everything",
+			want: command.Error{Reason: "`nuke` doesn't take any arguments", Token: "everything", Name: "nuke"},
+		},
+		{
+			text: "my status please",
+			want: command.Error{Reason: "`my status` doesn't take any arguments", Token: "please", Name: "my status"},
+		},
+		{
+			text: "clear env",
+			want: command.Error{Reason: "missing arguments", Name: "clear env"},
+		},
+		{
+			text: "remove resource dev|db dev|api",
+			want: command.Error{Reason: "too many arguments", Token: "dev|api", Name: "remove resource"},
+		},
+		{
+			text: "kick",
+			want: command.Error{Reason: "you must mention a user", Name: "kick"},
+		},
+		{
+			text: "handoff alice",
+			want: command.Error{Reason: "expected a user mention like `@someone`", Token: "alice", Name: "handoff"},
+		},
+		{
+			text: "forget <@U2> <@U3>",
+			want: command.Error{Reason: "too many arguments", Token: "<@U3>", Name: "forget"},
+		},
+		{
+			text: "reserve <@U2>",
+			want: command.Error{Reason: "expected a resource", Token: "<@U2>", Name: "reserve"},
+		},
+		{
+			text: "release ,",
+			want: command.Error{Reason: "you must specify a resource", Name: "release"},
+		},
+		{
+			text: "reserve for 1h",
+			want: command.Error{Reason: "you must specify a resource", Name: "reserve"},
+		},
+		{
+			text: "watch",
+			want: command.Error{Reason: "you must specify a resource", Name: "watch"},
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := command.Parse(tt.text)
+		perr, ok := err.(*command.Error)
+		if !ok {
+			t.Errorf("Parse(%q) returned %v, expected %+v", tt.text, err, tt.want)
+			continue
+		}
+		got := *perr
+		// usage is checked separately, as it is copied from the grammar
+		got.Usage = ""
+		if got != tt.want {
+			t.Errorf("Parse(%q) returned %+v, expected %+v", tt.text, got, tt.want)
+		}
+		if (perr.Usage == "") != (tt.want.Name == "") {
+			t.Errorf("Parse(%q) returned usage %q for command %q", tt.text, perr.Usage, tt.want.Name)
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := &command.Error{Reason: "too many arguments", Token: "dev|api", Usage: "remove resource <resource>"}
+	want := "too many arguments: `dev|api`. Usage: `remove resource <resource>`"
+	if err.Error() != want {
+		t.Errorf("Error() returned %q, expected %q", err.Error(), want)
+	}
+}
+
+func TestIsName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "reserve", want: true},
+		{name: "remove resource", want: true},
+		{name: "prune --dry-run", want: true},
+		{name: "prune --all", want: false},
+		{name: "remove", want: false},
+		{name: "reserve dev|db", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := command.IsName(tt.name); got != tt.want {
+			t.Errorf("IsName(%q) returned %t, expected %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type argKind int
+
+const (
+	// noArgs commands take nothing after their keywords
+	noArgs argKind = iota
+	// resourceList commands take a comma separated list of resources
+	resourceList
+	// positional commands take between min and max arguments. A max of -1 is unlimited.
+	positional
+	// mention commands take a single user mention
+	mention
+)
+
+// spec describes the grammar of a command
+type spec struct {
+	action   string
+	keywords []string
+	usage    string
+	args     argKind
+	min, max int
+	// duration allows a trailing `for <duration>`
+	duration bool
+	// emoji allows a trailing emoji
+	emoji bool
+	// ticket allows a trailing ticket ID, before or after any duration
+	ticket bool
+	// at allows a trailing `at <time>`, before or after any duration
+	at bool
+	// flags lists the flags the command accepts
+	flags []string
+	// valued lists the flags that take a value, which may follow them after = or a space
+	valued []string
+}
+
+// grammar lists every command. Commands with the same keywords are tried in order.
+var grammar = []*spec{
+	{action: "hello", keywords: []string{"hello"}, usage: "hello", args: positional, max: -1},
+	{action: "create", keywords: []string{"create"}, usage: "create <resource>[, <resource>...] [:emoji:] [--shared]", args: resourceList, emoji: true, flags: []string{"shared"}},
+	{action: "reserve", keywords: []string{"reserve"}, usage: "reserve <resource>[, <resource>...] [TICKET-123] [for <duration>] [at <time>] [--deploy=<id>] [--priority=<n>] [--key=<key>] [--by=<time> [--drop]]", args: resourceList, duration: true, ticket: true, at: true, flags: []string{"by", "deploy", "drop", "key", "priority"}, valued: []string{"by", "deploy", "key", "priority"}},
+	{action: "release", keywords: []string{"release"}, usage: "release <resource>[, <resource>...] [--key=<key>]", args: resourceList, flags: []string{"key"}, valued: []string{"key"}},
+	{action: "clearenv", keywords: []string{"clear", "env"}, usage: "clear env <env>", args: positional, min: 1, max: 1},
+	{action: "clear", keywords: []string{"clear"}, usage: "clear <resource>[, <resource>...]", args: resourceList},
+	{action: "kick", keywords: []string{"kick"}, usage: "kick <@user>", args: mention},
+	{action: "requestresource", keywords: []string{"request-resource"}, usage: "request-resource <name> <env> <reason>", args: positional, min: 3, max: -1},
+	{action: "reportbroken", keywords: []string{"report-broken"}, usage: "report-broken <resource> <details> [--block]", args: positional, min: 2, max: -1, flags: []string{"block"}},
+	{action: "markfixed", keywords: []string{"mark-fixed"}, usage: "mark-fixed <resource> [notes]", args: positional, min: 1, max: -1},
+	{action: "label", keywords: []string{"label"}, usage: "label <resource> <state|clear>", args: positional, min: 2, max: -1},
+	{action: "progress", keywords: []string{"progress"}, usage: "progress <resource> <note|clear>", args: positional, min: 2, max: -1},
+	{action: "offer", keywords: []string{"offer"}, usage: "offer <resource>", args: positional, min: 1, max: 1},
+	{action: "handoff", keywords: []string{"handoff"}, usage: "handoff <@user>", args: mention},
+	{action: "watch", keywords: []string{"watch"}, usage: "watch <resource>[, <resource>...]", args: resourceList},
+	{action: "unwatch", keywords: []string{"unwatch"}, usage: "unwatch <resource>[, <resource>...]", args: resourceList},
+	{action: "removeme", keywords: []string{"remove", "me", "from"}, usage: "remove me from <resource>[, <resource>...]", args: resourceList},
+	{action: "removeresource", keywords: []string{"remove", "resource"}, usage: "remove resource <resource>", args: positional, min: 1, max: 1},
+	{action: "all_status", keywords: []string{"status"}, usage: "status [--all]", args: noArgs, flags: []string{"all"}},
+	{action: "single_status", keywords: []string{"status"}, usage: "status <resource|team:<team>|env:<env>>", args: positional, min: 1, max: 1},
+	{action: "my_status", keywords: []string{"my", "status"}, usage: "my status", args: noArgs},
+	{action: "livestatus", keywords: []string{"live", "status"}, usage: "live status [off]", args: positional, max: 1},
+	{action: "broadcast", keywords: []string{"broadcast"}, usage: "broadcast <env|resource> <message>", args: positional, min: 2, max: -1},
+	{action: "incident", keywords: []string{"incident"}, usage: "incident <severity> <@commander> [title]", args: positional, min: 2, max: -1},
+	{action: "snapshot", keywords: []string{"snapshot"}, usage: "snapshot <resource>", args: positional, min: 1, max: 1},
+	{action: "restore", keywords: []string{"restore"}, usage: "restore <resource> [<new resource>]", args: positional, min: 1, max: 2},
+	{action: "nuke", keywords: []string{"nuke"}, usage: "nuke", args: noArgs},
+	{action: "prune", keywords: []string{"prune"}, usage: "prune [--dry-run]", args: noArgs, flags: []string{"dry-run"}},
+	{action: "help", keywords: []string{"help"}, usage: "help", args: noArgs},
+	{action: "maintenance", keywords: []string{"maintenance"}, usage: "maintenance <resource> <start> <duration> [reason]", args: positional, min: 3, max: -1},
+	{action: "endmaintenance", keywords: []string{"cancel", "maintenance"}, usage: "cancel maintenance <resource>[, <resource>...]", args: resourceList},
+	{action: "health", keywords: []string{"health"}, usage: "health <resource> <url> [interval]", args: positional, min: 2, max: 3},
+	{action: "defaultenv", keywords: []string{"settings", "default-env"}, usage: "settings default-env <env|none>", args: positional, min: 1, max: 1},
+	{action: "milestones", keywords: []string{"settings", "milestones"}, usage: "settings milestones <on|off>", args: positional, min: 1, max: 1},
+	{action: "statusfilter", keywords: []string{"settings", "status-filter"}, usage: "settings status-filter <filter|none>", args: positional, min: 1, max: -1},
+	{action: "settings", keywords: []string{"settings"}, usage: "settings <resource> <setting> <value>", args: positional, min: 3, max: -1},
+	{action: "idetoken", keywords: []string{"ide", "token"}, usage: "ide token", args: noArgs},
+	{action: "usagereport", keywords: []string{"usage", "report"}, usage: "usage report [month]", args: positional, max: 1},
+	{action: "stats", keywords: []string{"stats"}, usage: "stats", args: noArgs},
+	{action: "timeline", keywords: []string{"timeline"}, usage: "timeline <resource>", args: positional, min: 1, max: 1},
+	{action: "heatmap", keywords: []string{"heatmap"}, usage: "heatmap <resource|env> [--image]", args: positional, min: 1, max: 1, flags: []string{"image"}},
+	{action: "capacityreport", keywords: []string{"report", "capacity"}, usage: "report capacity [--csv]", args: noArgs, flags: []string{"csv"}},
+	{action: "syncstatus", keywords: []string{"sync", "status"}, usage: "sync status <on|off>", args: positional, min: 1, max: 1},
+	{action: "serviceaccount", keywords: []string{"service-account"}, usage: "service-account <create|token|delete|list> [name]", args: positional, min: 1, max: 2},
+	{action: "apikey", keywords: []string{"api-key"}, usage: "api-key <create|revoke|list> [name|id] [read|reserve|admin] [requests per minute]", args: positional, min: 1, max: 4},
+	{action: "importresources", keywords: []string{"import", "resources"}, usage: "import resources [--dry-run]", args: noArgs, flags: []string{"dry-run"}},
+	{action: "forget", keywords: []string{"forget"}, usage: "forget <@user>", args: mention},
+	{action: "adminusage", keywords: []string{"admin", "usage"}, usage: "admin usage", args: noArgs},
+	{action: "export", keywords: []string{"export"}, usage: "export <reservations|history>", args: positional, min: 1, max: 1},
+}
+
+// Name returns the keywords of the command with an action, such as `remove resource` for removeresource.
+// An empty string is returned for unknown actions.
+func Name(action string) string {
+	for _, s := range grammar {
+		if s.action == action {
+			return strings.Join(s.keywords, " ")
+		}
+	}
+	return ""
+}
+
+// IsName returns whether a name is the keywords of a command. Flags may follow the keywords, such as
+// `prune --dry-run`, if the command accepts them.
+func IsName(name string) bool {
+	words := strings.Fields(name)
+	for _, s := range grammar {
+		if len(words) < len(s.keywords) || strings.Join(words[:len(s.keywords)], " ") != strings.Join(s.keywords, " ") {
+			continue
+		}
+		ok := true
+		for _, w := range words[len(s.keywords):] {
+			if !strings.HasPrefix(w, "--") || !s.acceptsFlag(strings.TrimPrefix(w, "--")) {
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns whether the message begins with the command's keywords
+func (s *spec) matches(tokens []Token) bool {
+	if len(tokens) < len(s.keywords) {
+		return false
+	}
+	for i, k := range s.keywords {
+		if tokens[i].Kind != Word || strings.ToLower(tokens[i].Text) != k {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *spec) error(reason, token string) *Error {
+	return &Error{
+		Reason: reason,
+		Token:  token,
+		Usage:  s.usage,
+		Name:   strings.Join(s.keywords, " "),
+	}
+}
+
+// parse parses the tokens following the command's keywords
+func (s *spec) parse(tokens []Token) (*Command, error) {
+	cmd := &Command{
+		Action: s.action,
+		Flags:  map[string]string{},
+	}
+
+	// Flags may appear anywhere
+	rest := []Token{}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.Kind != Flag {
+			rest = append(rest, t)
+			continue
+		}
+		if !s.acceptsFlag(t.Text) {
+			return nil, s.error("unknown flag", t.Raw)
+		}
+		value := t.Value
+		if !strings.Contains(t.Raw, "=") && s.takesValue(t.Text) && i+1 < len(tokens) && (tokens[i+1].Kind == Word || tokens[i+1].Kind == Quoted) {
+			i++
+			value = tokens[i].Text
+		}
+		cmd.Flags[t.Text] = value
+	}
+
+	rest = s.trailingTicket(cmd, rest)
+	rest = s.trailingAt(cmd, rest)
+
+	if s.duration && len(rest) >= 2 && isWord(rest[len(rest)-2], "for") {
+		t := rest[len(rest)-1]
+		d, err := time.ParseDuration(t.Text)
+		if err != nil || d <= 0 {
+			return nil, s.error("durations must be formatted like `30m` or `2h`", t.Raw)
+		}
+		cmd.Duration = d
+		rest = rest[:len(rest)-2]
+	}
+
+	if cmd.At == "" {
+		rest = s.trailingAt(cmd, rest)
+	}
+	if cmd.Ticket == "" {
+		rest = s.trailingTicket(cmd, rest)
+	}
+
+	if s.emoji && len(rest) >= 2 {
+		if t := rest[len(rest)-1]; t.Kind == Word && IsEmoji(t.Text) {
+			cmd.Emoji = t.Text
+			rest = rest[:len(rest)-1]
+		}
+	}
+
+	switch s.args {
+	case noArgs:
+		if len(rest) > 0 {
+			return nil, s.error(fmt.Sprintf("`%s` doesn't take any arguments", strings.Join(s.keywords, " ")), rest[0].Raw)
+		}
+
+	case resourceList:
+		resources, err := s.resources(rest)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Resources = resources
+
+	case positional:
+		if len(rest) < s.min {
+			return nil, s.error("missing arguments", "")
+		}
+		if s.max >= 0 && len(rest) > s.max {
+			return nil, s.error("too many arguments", rest[s.max].Raw)
+		}
+		for _, t := range rest {
+			cmd.Args = append(cmd.Args, t.Text)
+			if t.Kind == Mention {
+				cmd.Mentions = append(cmd.Mentions, t.Text)
+			}
+		}
+
+	case mention:
+		if len(rest) == 0 {
+			return nil, s.error("you must mention a user", "")
+		}
+		if rest[0].Kind != Mention {
+			return nil, s.error("expected a user mention like `@someone`", rest[0].Raw)
+		}
+		if len(rest) > 1 {
+			return nil, s.error("too many arguments", rest[1].Raw)
+		}
+		cmd.Mentions = []string{rest[0].Text}
+	}
+
+	return cmd, nil
+}
+
+// resources reads a comma separated list of resources, which may be split across tokens, e.g.
+// `dev|db, dev|api`
+func (s *spec) resources(tokens []Token) ([]string, error) {
+	parts := []string{}
+	for _, t := range tokens {
+		if t.Kind != Word && t.Kind != Quoted {
+			return nil, s.error("expected a resource", t.Raw)
+		}
+		parts = append(parts, t.Text)
+	}
+
+	ret := []string{}
+	for _, r := range strings.Split(strings.Join(parts, " "), ",") {
+		r = strings.Trim(r, " `")
+		if r != "" {
+			ret = append(ret, r)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, s.error("you must specify a resource", "")
+	}
+
+	return ret, nil
+}
+
+// trailingTicket removes a ticket ID from the end of the tokens if the command accepts one. At least
+// one token is left for the command's arguments.
+func (s *spec) trailingTicket(cmd *Command, tokens []Token) []Token {
+	if !s.ticket || len(tokens) < 2 {
+		return tokens
+	}
+	t := tokens[len(tokens)-1]
+	if t.Kind != Word || !IsTicket(t.Text) {
+		return tokens
+	}
+	cmd.Ticket = t.Text
+	return tokens[:len(tokens)-1]
+}
+
+// trailingAt removes an `at <time>` from the end of the tokens if the command can be scheduled. At least
+// one token is left for the command's arguments.
+func (s *spec) trailingAt(cmd *Command, tokens []Token) []Token {
+	if !s.at || len(tokens) < 3 || !isWord(tokens[len(tokens)-2], "at") {
+		return tokens
+	}
+	t := tokens[len(tokens)-1]
+	if t.Kind != Word {
+		return tokens
+	}
+	cmd.At = t.Text
+	return tokens[:len(tokens)-2]
+}
+
+func (s *spec) acceptsFlag(name string) bool {
+	for _, f := range s.flags {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *spec) takesValue(name string) bool {
+	for _, f := range s.valued {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isWord(t Token, text string) bool {
+	return t.Kind == Word && strings.ToLower(t.Text) == text
+}
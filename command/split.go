@@ -0,0 +1,59 @@
+package command
+
+import (
+	"regexp"
+	"strings"
+)
+
+// entity matches the start of an HTML entity at the end of text, such as those Slack escapes &, < and >
+// with, whose semicolons don't end a command
+var entity = regexp.MustCompile(`&(#[0-9]+|[a-z]+)$`)
+
+// Split splits a message into the commands it gives, one per line or separated by semicolons, as in
+// "reserve staging|api; status staging". Mentions of the bot are removed wherever they appear, so it can
+// be addressed before, after or in the middle of a command, along with punctuation left at the start of
+// a line, as in "@reservebot: status". Line breaks and semicolons within quotes don't end a command. If
+// the bot's user ID is empty, mentions are left for Parse, which ignores one at the start of a command.
+func Split(text, botID string) []string {
+	var mention *regexp.Regexp
+	if botID != "" {
+		mention = regexp.MustCompile(`<@` + regexp.QuoteMeta(botID) + `(\|[^>]*)?>`)
+	}
+
+	ret := []string{}
+	for _, line := range lines(text) {
+		if mention != nil {
+			line = mention.ReplaceAllString(line, " ")
+		}
+		line = strings.TrimLeft(strings.TrimSpace(line), ":,")
+		if line = strings.TrimSpace(line); line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret
+}
+
+// lines splits text at line breaks and semicolons outside quotes and Slack's <...> formatting
+func lines(text string) []string {
+	ret := []string{}
+	runes := []rune(text)
+	start := 0
+	var closing rune
+	for i, r := range runes {
+		switch {
+		case closing != 0:
+			// a quote opened with a straight quote may be closed by a curly one
+			if r == closing || closing == '"' && r == '”' {
+				closing = 0
+			}
+		case quotes[r] != 0:
+			closing = quotes[r]
+		case r == '<':
+			closing = '>'
+		case r == '\n' || r == ';' && !entity.MatchString(string(runes[start:i])):
+			ret = append(ret, string(runes[start:i]))
+			start = i + 1
+		}
+	}
+	return append(ret, string(runes[start:]))
+}
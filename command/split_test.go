@@ -0,0 +1,72 @@
+package command_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ameliagapin/reservebot/command"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		text  string
+		botID string
+		want  []string
+	}{
+		{text: "status", botID: "UBOT", want: []string{"status"}},
+		{text: "<@UBOT> status", botID: "UBOT", want: []string{"status"}},
+		{text: "<@UBOT|reservebot>: status", botID: "UBOT", want: []string{"status"}},
+		{text: "status <@UBOT>", botID: "UBOT", want: []string{"status"}},
+		{text: "reserve dev|db <@UBOT> for 1h", botID: "UBOT", want: []string{"reserve dev|db   for 1h"}},
+		{text: "kick <@U2> <@UBOT>", botID: "UBOT", want: []string{"kick <@U2>"}},
+		{
+			text:  "<@UBOT> reserve dev|db; <@UBOT> release dev|api",
+			botID: "UBOT",
+			want:  []string{"reserve dev|db", "release dev|api"},
+		},
+		{
+			text:  "reserve dev|db\nstatus dev|db;;\n",
+			botID: "UBOT",
+			want:  []string{"reserve dev|db", "status dev|db"},
+		},
+		{text: `broadcast dev "down; back soon"`, botID: "UBOT", want: []string{`broadcast dev "down; back soon"`}},
+		{text: "broadcast dev “down; back soon”; status", botID: "UBOT", want: []string{"broadcast dev “down; back soon”", "status"}},
+		{text: "health dev|db <https://example.com/a;b>", botID: "UBOT", want: []string{"health dev|db <https://example.com/a;b>"}},
+		{text: "broadcast dev db &amp; api are down", botID: "UBOT", want: []string{"broadcast dev db &amp; api are down"}},
+		{text: "<@UBOT> status", botID: "", want: []string{"<@UBOT> status"}},
+	}
+
+	for _, tt := range tests {
+		got := command.Split(tt.text, tt.botID)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Split(%q, %q) returned %q, expected %q", tt.text, tt.botID, got, tt.want)
+		}
+	}
+}
+
+func TestSplitParsesMentions(t *testing.T) {
+	tests := []struct {
+		text   string
+		action string
+	}{
+		{text: "<@UBOT> reserve dev|db", action: "reserve"},
+		{text: "reserve dev|db <@UBOT>", action: "reserve"},
+		{text: "reserve <@UBOT> dev|db", action: "reserve"},
+	}
+
+	for _, tt := range tests {
+		lines := command.Split(tt.text, "UBOT")
+		if len(lines) != 1 {
+			t.Errorf("Split(%q) returned %q, expected one command", tt.text, lines)
+			continue
+		}
+		cmd, err := command.Parse(lines[0])
+		if err != nil {
+			t.Errorf("Parse(%q) returned %v", lines[0], err)
+			continue
+		}
+		if cmd.Action != tt.action || !reflect.DeepEqual(cmd.Resources, []string{"dev|db"}) {
+			t.Errorf("Parse(%q) returned %+v, expected %s of dev|db", lines[0], cmd, tt.action)
+		}
+	}
+}
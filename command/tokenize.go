@@ -0,0 +1,161 @@
+package command
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Kind is the type of a token
+type Kind int
+
+const (
+	// Word is a bare word
+	Word Kind = iota
+	// Quoted is a double quoted string, which may contain spaces
+	Quoted
+	// Mention is a Slack user mention such as <@U123>. Text is the user ID.
+	Mention
+	// Link is a Slack formatted link such as <https://example.com|example.com>. Text is the URL.
+	Link
+	// Flag is a flag such as --urgent or --note=value. Text is the name without dashes.
+	Flag
+)
+
+// Token is a single element of a message
+type Token struct {
+	Kind Kind
+	// Text is the meaningful content of the token; see the Kind constants
+	Text string
+	// Value is the value of a flag or the label of a link
+	Value string
+	// Raw is the token as it appeared in the message
+	Raw string
+}
+
+// quotes maps opening quotes to their closing quote. Slack may replace straight quotes with curly ones.
+var quotes = map[rune]rune{
+	'"': '"',
+	'“': '”',
+}
+
+// Tokenize splits a message into tokens. Whitespace separates tokens except within quotes and Slack's
+// <...> formatting.
+func Tokenize(text string) ([]Token, error) {
+	runes := []rune(text)
+	ret := []Token{}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case quotes[r] != 0:
+			end := indexRune(runes, i+1, quotes[r])
+			if end == -1 && r == '"' {
+				end = indexRune(runes, i+1, '”')
+			}
+			if end == -1 {
+				return nil, &Error{Reason: "a quote was never closed", Token: string(runes[i:])}
+			}
+			ret = append(ret, Token{
+				Kind: Quoted,
+				Text: string(runes[i+1 : end]),
+				Raw:  string(runes[i : end+1]),
+			})
+			i = end + 1
+
+		case r == '<':
+			end := indexRune(runes, i+1, '>')
+			if end == -1 {
+				start := i
+				i = wordEnd(runes, i)
+				ret = append(ret, word(string(runes[start:i])))
+				continue
+			}
+			ret = append(ret, formatted(string(runes[i:end+1])))
+			i = end + 1
+
+		default:
+			start := i
+			i = wordEnd(runes, i)
+			raw := string(runes[start:i])
+			if strings.HasPrefix(raw, "--") && len(raw) > 2 {
+				ret = append(ret, flag(raw))
+				continue
+			}
+			ret = append(ret, word(raw))
+		}
+	}
+
+	return ret, nil
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+func wordEnd(runes []rune, from int) int {
+	i := from
+	for i < len(runes) && !unicode.IsSpace(runes[i]) {
+		i++
+	}
+	return i
+}
+
+func word(raw string) Token {
+	return Token{
+		Kind: Word,
+		Text: raw,
+		Raw:  raw,
+	}
+}
+
+func flag(raw string) Token {
+	name := strings.TrimPrefix(raw, "--")
+	value := ""
+	if idx := strings.Index(name, "="); idx != -1 {
+		name, value = name[:idx], name[idx+1:]
+	}
+	return Token{
+		Kind:  Flag,
+		Text:  strings.ToLower(name),
+		Value: value,
+		Raw:   raw,
+	}
+}
+
+// formatted parses Slack's <...> formatting. Anything other than user mentions and links, such as
+// channel references, is kept as a word.
+func formatted(raw string) Token {
+	inner := strings.TrimSuffix(strings.TrimPrefix(raw, "<"), ">")
+	split := strings.SplitN(inner, "|", 2)
+	label := ""
+	if len(split) > 1 {
+		label = split[1]
+	}
+
+	switch {
+	case strings.HasPrefix(inner, "@"):
+		return Token{
+			Kind:  Mention,
+			Text:  strings.TrimPrefix(split[0], "@"),
+			Value: label,
+			Raw:   raw,
+		}
+	case strings.HasPrefix(inner, "http://"), strings.HasPrefix(inner, "https://"), strings.HasPrefix(inner, "mailto:"):
+		return Token{
+			Kind:  Link,
+			Text:  split[0],
+			Value: label,
+			Raw:   raw,
+		}
+	default:
+		return word(raw)
+	}
+}
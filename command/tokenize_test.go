@@ -0,0 +1,112 @@
+package command_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ameliagapin/reservebot/command"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []command.Token
+	}{
+		{
+			text: "reserve  dev|db",
+			want: []command.Token{
+				{Kind: command.Word, Text: "reserve", Raw: "reserve"},
+				{Kind: command.Word, Text: "dev|db", Raw: "dev|db"},
+			},
+		},
+		{
+			text: `maintenance dev|db "the db is down"`,
+			want: []command.Token{
+				{Kind: command.Word, Text: "maintenance", Raw: "maintenance"},
+				{Kind: command.Word, Text: "dev|db", Raw: "dev|db"},
+				{Kind: command.Quoted, Text: "the db is down", Raw: `"the db is down"`},
+			},
+		},
+		{
+			text: "label dev|db “in use”",
+			want: []command.Token{
+				{Kind: command.Word, Text: "label", Raw: "label"},
+				{Kind: command.Word, Text: "dev|db", Raw: "dev|db"},
+				{Kind: command.Quoted, Text: "in use", Raw: "“in use”"},
+			},
+		},
+		{
+			text: `label dev|db "in use”`,
+			want: []command.Token{
+				{Kind: command.Word, Text: "label", Raw: "label"},
+				{Kind: command.Word, Text: "dev|db", Raw: "dev|db"},
+				{Kind: command.Quoted, Text: "in use", Raw: `"in use”`},
+			},
+		},
+		{
+			text: "reserve `dev|db`",
+			want: []command.Token{
+				{Kind: command.Word, Text: "reserve", Raw: "reserve"},
+				{Kind: command.Word, Text: "`dev|db`", Raw: "`dev|db`"},
+			},
+		},
+		{
+			text: "kick <@U123|alice>",
+			want: []command.Token{
+				{Kind: command.Word, Text: "kick", Raw: "kick"},
+				{Kind: command.Mention, Text: "U123", Value: "alice", Raw: "<@U123|alice>"},
+			},
+		},
+		{
+			text: "health dev|db <https://db.example.com/health|db.example.com/health>",
+			want: []command.Token{
+				{Kind: command.Word, Text: "health", Raw: "health"},
+				{Kind: command.Word, Text: "dev|db", Raw: "dev|db"},
+				{Kind: command.Link, Text: "https://db.example.com/health", Value: "db.example.com/health", Raw: "<https://db.example.com/health|db.example.com/health>"},
+			},
+		},
+		{
+			text: "broadcast <#C123|general> <unclosed",
+			want: []command.Token{
+				{Kind: command.Word, Text: "broadcast", Raw: "broadcast"},
+				{Kind: command.Word, Text: "<#C123|general>", Raw: "<#C123|general>"},
+				{Kind: command.Word, Text: "<unclosed", Raw: "<unclosed"},
+			},
+		},
+		{
+			text: "reserve dev|db --Key=a=b --drop --",
+			want: []command.Token{
+				{Kind: command.Word, Text: "reserve", Raw: "reserve"},
+				{Kind: command.Word, Text: "dev|db", Raw: "dev|db"},
+				{Kind: command.Flag, Text: "key", Value: "a=b", Raw: "--Key=a=b"},
+				{Kind: command.Flag, Text: "drop", Raw: "--drop"},
+				{Kind: command.Word, Text: "--", Raw: "--"},
+			},
+		},
+		{
+			text: " \t\n",
+			want: []command.Token{},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := command.Tokenize(tt.text)
+		if err != nil {
+			t.Errorf("Tokenize(%q) returned %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) returned %+v, expected %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeUnclosedQuote(t *testing.T) {
+	for _, text := range []string{`label dev|db "in use`, "label dev|db “in use"} {
+		_, err := command.Tokenize(text)
+		perr, ok := err.(*command.Error)
+		if !ok || perr.Reason != "a quote was never closed" || perr.Token == "" {
+			t.Errorf("Tokenize(%q) returned %v, expected an unclosed quote", text, err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package bench measures the performance of data.Manager implementations. It provides benchmarks for
+// individual operations and a load generator that runs a mix of operations across many resources and
+// users, so that changes to the storage backends can be compared. The benchmarks are run by
+// `go test -bench . ./data/bench`, whose results benchstat can compare, and by cmd/databench along with
+// the load test.
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// Env is the environment all generated resources are created in
+const Env = "bench"
+
+// Factory returns an empty manager for a benchmark run
+type Factory func() (data.Manager, error)
+
+// Config describes the shape of a load test
+type Config struct {
+	Resources int
+	Users     int
+	Ops       int
+	Workers   int
+	Seed      int64
+}
+
+// Result summarizes a load test
+type Result struct {
+	Elapsed time.Duration
+	Ops     map[string]int
+	Errors  map[string]int
+}
+
+func (r *Result) String() string {
+	names := []string{}
+	total := 0
+	for name, n := range r.Ops {
+		names = append(names, name)
+		total += n
+	}
+	sort.Strings(names)
+
+	lines := []string{}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%-12s %8d ops %6d errors", name, r.Ops[name], r.Errors[name]))
+	}
+	rate := float64(total) / r.Elapsed.Seconds()
+	lines = append(lines, fmt.Sprintf("%d ops in %s (%.0f ops/s)", total, r.Elapsed, rate))
+
+	return strings.Join(lines, "\n")
+}
+
+// operation is a single step of generated load. The returned error is counted, not fatal, since
+// many operations are expected to fail, e.g. removing a user that isn't in a queue.
+type operation struct {
+	name   string
+	weight int
+	run    func(m data.Manager, u *models.User, res string) error
+}
+
+var operations = []operation{
+	{"reserve", 40, func(m data.Manager, u *models.User, res string) error {
+		return m.Reserve(u, res, Env)
+	}},
+	{"remove", 30, func(m data.Manager, u *models.User, res string) error {
+		return m.Remove(u, res, Env)
+	}},
+	{"status", 20, func(m data.Manager, u *models.User, res string) error {
+		m.GetQueues()
+		return nil
+	}},
+	{"position", 10, func(m data.Manager, u *models.User, res string) error {
+		_, err := m.GetPosition(u, res, Env)
+		return err
+	}},
+}
+
+// User returns the generated user with the given index
+func User(i int) *models.User {
+	return &models.User{
+		Name: fmt.Sprintf("user%d", i),
+		ID:   fmt.Sprintf("U%06d", i),
+	}
+}
+
+// Resource returns the name of the generated resource with the given index
+func Resource(i int) string {
+	return fmt.Sprintf("resource%d", i)
+}
+
+// Populate creates the given number of resources
+func Populate(m data.Manager, resources int) error {
+	for i := 0; i < resources; i++ {
+		if err := m.Create(Resource(i), Env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load runs a weighted mix of operations against a manager from concurrent workers
+func Load(m data.Manager, cfg Config) (*Result, error) {
+	if cfg.Resources < 1 || cfg.Users < 1 || cfg.Workers < 1 {
+		return nil, errors.New("resources, users and workers must be positive")
+	}
+	if err := Populate(m, cfg.Resources); err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, op := range operations {
+		total += op.weight
+	}
+
+	res := &Result{
+		Ops:    map[string]int{},
+		Errors: map[string]int{},
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for w := 0; w < cfg.Workers; w++ {
+		// each worker has its own source since rand.Rand is not safe for concurrent use
+		rnd := rand.New(rand.NewSource(cfg.Seed + int64(w)))
+		ops := cfg.Ops / cfg.Workers
+		if w < cfg.Ops%cfg.Workers {
+			ops++
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				op := pick(rnd.Intn(total))
+				err := op.run(m, User(rnd.Intn(cfg.Users)), Resource(rnd.Intn(cfg.Resources)))
+
+				lock.Lock()
+				res.Ops[op.name]++
+				if err != nil {
+					res.Errors[op.name]++
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+
+	return res, nil
+}
+
+// pick returns the operation for a number in [0, total weight)
+func pick(n int) operation {
+	for _, op := range operations {
+		if n < op.weight {
+			return op
+		}
+		n -= op.weight
+	}
+	return operations[len(operations)-1]
+}
+
+// Benchmark is a named benchmark of a data.Manager operation
+type Benchmark struct {
+	Name string
+	Func func(b *testing.B)
+}
+
+// Benchmarks returns benchmarks of the common operations. Each benchmark gets a fresh manager from the
+// factory holding the given number of resources.
+func Benchmarks(factory Factory, resources int) []Benchmark {
+	setup := func(b *testing.B) data.Manager {
+		m, err := factory()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := Populate(m, resources); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		return m
+	}
+
+	return []Benchmark{
+		{"Reserve", func(b *testing.B) {
+			m := setup(b)
+			for i := 0; i < b.N; i++ {
+				if err := m.Reserve(User(i), Resource(i%resources), Env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+		{"ReserveRemove", func(b *testing.B) {
+			m := setup(b)
+			for i := 0; i < b.N; i++ {
+				u := User(i)
+				if err := m.Reserve(u, Resource(i%resources), Env); err != nil {
+					b.Fatal(err)
+				}
+				if err := m.Remove(u, Resource(i%resources), Env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+		{"GetQueues", func(b *testing.B) {
+			m := setup(b)
+			for i := 0; i < b.N; i++ {
+				m.GetQueues()
+			}
+		}},
+		{"GetQueueForResource", func(b *testing.B) {
+			m := setup(b)
+			for i := 0; i < b.N; i++ {
+				if _, err := m.GetQueueForResource(Resource(i%resources), Env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+	}
+}
+
+// Run runs each benchmark and returns the results in order
+func Run(benchmarks []Benchmark) []testing.BenchmarkResult {
+	ret := []testing.BenchmarkResult{}
+	for _, bm := range benchmarks {
+		ret = append(ret, testing.Benchmark(bm.Func))
+	}
+	return ret
+}
@@ -0,0 +1,30 @@
+package bench_test
+
+import (
+	"testing"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/data/bench"
+	"github.com/ameliagapin/reservebot/data/managertest"
+)
+
+// resources is how many resources each benchmark's manager holds
+const resources = 100
+
+func BenchmarkMemory(b *testing.B) {
+	run(b, func() (data.Manager, error) {
+		return data.NewMemory(), nil
+	})
+}
+
+func BenchmarkRedis(b *testing.B) {
+	run(b, bench.Factory(managertest.Redis(b)))
+}
+
+// run runs every benchmark against managers from the factory, as sub-benchmarks named after the
+// operations
+func run(b *testing.B, factory bench.Factory) {
+	for _, bm := range bench.Benchmarks(factory, resources) {
+		b.Run(bm.Name, bm.Func)
+	}
+}
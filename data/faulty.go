@@ -0,0 +1,473 @@
+package data
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// FaultConfig chooses the faults a Faulty manager injects
+type FaultConfig struct {
+	// ErrorRate is the probability, from 0 to 1, that an operation returning an error fails with
+	// err.Injected instead of being run
+	ErrorRate float64
+	// Latency is the longest an operation is delayed by. Each is delayed by a random time up to it.
+	Latency time.Duration
+	// Ops names the operations faults are injected into, such as Reserve or GetQueues. If it is
+	// empty, they are injected into every operation.
+	Ops []string
+	// Seed seeds the random choices, so that a run can be repeated
+	Seed int64
+}
+
+// Faulty wraps a Manager and injects latency and errors into its operations, for checking that the bot
+// degrades gracefully when its storage misbehaves. Operations that can't return an error are only
+// delayed.
+type Faulty struct {
+	Manager
+	cfg FaultConfig
+	ops map[string]bool
+
+	// lock guards rand, which isn't safe for concurrent use
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func NewFaulty(m Manager, cfg FaultConfig) *Faulty {
+	ops := map[string]bool{}
+	for _, op := range cfg.Ops {
+		ops[op] = true
+	}
+	return &Faulty{
+		Manager: m,
+		cfg:     cfg,
+		ops:     ops,
+		rand:    rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// delay sleeps for the injected latency of an operation
+func (m *Faulty) delay(op string) {
+	if m.cfg.Latency <= 0 || len(m.ops) > 0 && !m.ops[op] {
+		return
+	}
+	m.lock.Lock()
+	d := time.Duration(m.rand.Int63n(int64(m.cfg.Latency) + 1))
+	m.lock.Unlock()
+	time.Sleep(d)
+}
+
+// fault delays an operation and returns the error it should fail with, if any
+func (m *Faulty) fault(op string) error {
+	m.delay(op)
+	if m.cfg.ErrorRate <= 0 || len(m.ops) > 0 && !m.ops[op] {
+		return nil
+	}
+	m.lock.Lock()
+	failed := m.rand.Float64() < m.cfg.ErrorRate
+	m.lock.Unlock()
+	if failed {
+		return err.Injected
+	}
+	return nil
+}
+
+func (m *Faulty) ClaimIdempotencyKey(key string, ttl time.Duration) (bool, error) {
+	if e := m.fault("ClaimIdempotencyKey"); e != nil {
+		return false, e
+	}
+	return m.Manager.ClaimIdempotencyKey(key, ttl)
+}
+
+func (m *Faulty) ReleaseIdempotencyKey(key string) error {
+	if e := m.fault("ReleaseIdempotencyKey"); e != nil {
+		return e
+	}
+	return m.Manager.ReleaseIdempotencyKey(key)
+}
+
+func (m *Faulty) AddUsage(u *models.Usage) error {
+	if e := m.fault("AddUsage"); e != nil {
+		return e
+	}
+	return m.Manager.AddUsage(u)
+}
+
+func (m *Faulty) AddContention(c *models.Contention) error {
+	if e := m.fault("AddContention"); e != nil {
+		return e
+	}
+	return m.Manager.AddContention(c)
+}
+
+func (m *Faulty) AddHold(name, env string, h *models.Hold) error {
+	if e := m.fault("AddHold"); e != nil {
+		return e
+	}
+	return m.Manager.AddHold(name, env, h)
+}
+
+func (m *Faulty) Create(name, env string) error {
+	if e := m.fault("Create"); e != nil {
+		return e
+	}
+	return m.Manager.Create(name, env)
+}
+
+func (m *Faulty) GetAllUsersInQueues() []*models.User {
+	m.delay("GetAllUsersInQueues")
+	return m.Manager.GetAllUsersInQueues()
+}
+
+func (m *Faulty) GetContention() ([]*models.Contention, error) {
+	if e := m.fault("GetContention"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetContention()
+}
+
+func (m *Faulty) GetPosition(u *models.User, name, env string) (int, error) {
+	if e := m.fault("GetPosition"); e != nil {
+		return 0, e
+	}
+	return m.Manager.GetPosition(u, name, env)
+}
+
+func (m *Faulty) GetQueueForResource(name, env string) (*models.Queue, error) {
+	if e := m.fault("GetQueueForResource"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetQueueForResource(name, env)
+}
+
+func (m *Faulty) GetQueues() []*models.Queue {
+	m.delay("GetQueues")
+	return m.Manager.GetQueues()
+}
+
+func (m *Faulty) GetQueuesForEnv(env string) map[string]*models.Queue {
+	m.delay("GetQueuesForEnv")
+	return m.Manager.GetQueuesForEnv(env)
+}
+
+func (m *Faulty) GetReservation(u *models.User, name, env string) *models.Reservation {
+	m.delay("GetReservation")
+	return m.Manager.GetReservation(u, name, env)
+}
+
+func (m *Faulty) GetReservationForResource(name, env string) (*models.Reservation, error) {
+	if e := m.fault("GetReservationForResource"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetReservationForResource(name, env)
+}
+
+func (m *Faulty) GetResource(name, env string, create bool) *models.Resource {
+	m.delay("GetResource")
+	return m.Manager.GetResource(name, env, create)
+}
+
+func (m *Faulty) GetResources() []*models.Resource {
+	m.delay("GetResources")
+	return m.Manager.GetResources()
+}
+
+func (m *Faulty) GetResourcesForEnv(env string) []*models.Resource {
+	m.delay("GetResourcesForEnv")
+	return m.Manager.GetResourcesForEnv(env)
+}
+
+func (m *Faulty) GetEnvsForName(name string) []string {
+	m.delay("GetEnvsForName")
+	return m.Manager.GetEnvsForName(name)
+}
+
+func (m *Faulty) GetUsage(month string) ([]*models.Usage, error) {
+	if e := m.fault("GetUsage"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetUsage(month)
+}
+
+func (m *Faulty) GetUserToken(userID string) (string, error) {
+	if e := m.fault("GetUserToken"); e != nil {
+		return "", e
+	}
+	return m.Manager.GetUserToken(userID)
+}
+
+func (m *Faulty) GetDefaultEnv(userID string) (string, error) {
+	if e := m.fault("GetDefaultEnv"); e != nil {
+		return "", e
+	}
+	return m.Manager.GetDefaultEnv(userID)
+}
+
+func (m *Faulty) GetStatusFilter(userID string) (string, error) {
+	if e := m.fault("GetStatusFilter"); e != nil {
+		return "", e
+	}
+	return m.Manager.GetStatusFilter(userID)
+}
+
+func (m *Faulty) GetMilestonesOff(userID string) (bool, error) {
+	if e := m.fault("GetMilestonesOff"); e != nil {
+		return false, e
+	}
+	return m.Manager.GetMilestonesOff(userID)
+}
+
+func (m *Faulty) GetHolds(name, env string, since time.Time) ([]*models.Hold, error) {
+	if e := m.fault("GetHolds"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetHolds(name, env, since)
+}
+
+func (m *Faulty) GetServiceAccounts() ([]*models.ServiceAccount, error) {
+	if e := m.fault("GetServiceAccounts"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetServiceAccounts()
+}
+
+func (m *Faulty) GetAPIKeys() ([]*models.APIKey, error) {
+	if e := m.fault("GetAPIKeys"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetAPIKeys()
+}
+
+func (m *Faulty) GetScheduledActions() ([]*models.ScheduledAction, error) {
+	if e := m.fault("GetScheduledActions"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetScheduledActions()
+}
+
+func (m *Faulty) GetLiveStatuses() ([]*models.LiveStatus, error) {
+	if e := m.fault("GetLiveStatuses"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetLiveStatuses()
+}
+
+func (m *Faulty) GetSnapshot(name, env string) (*models.Snapshot, error) {
+	if e := m.fault("GetSnapshot"); e != nil {
+		return nil, e
+	}
+	return m.Manager.GetSnapshot(name, env)
+}
+
+func (m *Faulty) Promote(u *models.User, name, env string) error {
+	if e := m.fault("Promote"); e != nil {
+		return e
+	}
+	return m.Manager.Promote(u, name, env)
+}
+
+func (m *Faulty) Remove(u *models.User, name, env string) error {
+	if e := m.fault("Remove"); e != nil {
+		return e
+	}
+	return m.Manager.Remove(u, name, env)
+}
+
+func (m *Faulty) RemoveEnv(name, env string) error {
+	if e := m.fault("RemoveEnv"); e != nil {
+		return e
+	}
+	return m.Manager.RemoveEnv(name, env)
+}
+
+func (m *Faulty) RemoveResource(name, env string) error {
+	if e := m.fault("RemoveResource"); e != nil {
+		return e
+	}
+	return m.Manager.RemoveResource(name, env)
+}
+
+func (m *Faulty) Requeue(u *models.User, name, env string) error {
+	if e := m.fault("Requeue"); e != nil {
+		return e
+	}
+	return m.Manager.Requeue(u, name, env)
+}
+
+func (m *Faulty) Transfer(from, to *models.User, name, env string) error {
+	if e := m.fault("Transfer"); e != nil {
+		return e
+	}
+	return m.Manager.Transfer(from, to, name, env)
+}
+
+func (m *Faulty) TouchResource(name, env string) error {
+	if e := m.fault("TouchResource"); e != nil {
+		return e
+	}
+	return m.Manager.TouchResource(name, env)
+}
+
+func (m *Faulty) Reserve(u *models.User, name, env string) error {
+	if e := m.fault("Reserve"); e != nil {
+		return e
+	}
+	return m.Manager.Reserve(u, name, env)
+}
+
+func (m *Faulty) UpdateReservation(res *models.Reservation) error {
+	if e := m.fault("UpdateReservation"); e != nil {
+		return e
+	}
+	return m.Manager.UpdateReservation(res)
+}
+
+func (m *Faulty) UpdateResource(r *models.Resource) error {
+	if e := m.fault("UpdateResource"); e != nil {
+		return e
+	}
+	return m.Manager.UpdateResource(r)
+}
+
+func (m *Faulty) SetDefaultEnv(userID, env string) error {
+	if e := m.fault("SetDefaultEnv"); e != nil {
+		return e
+	}
+	return m.Manager.SetDefaultEnv(userID, env)
+}
+
+func (m *Faulty) SetStatusFilter(userID, filter string) error {
+	if e := m.fault("SetStatusFilter"); e != nil {
+		return e
+	}
+	return m.Manager.SetStatusFilter(userID, filter)
+}
+
+func (m *Faulty) SetMilestonesOff(userID string, off bool) error {
+	if e := m.fault("SetMilestonesOff"); e != nil {
+		return e
+	}
+	return m.Manager.SetMilestonesOff(userID, off)
+}
+
+func (m *Faulty) SaveServiceAccount(a *models.ServiceAccount) error {
+	if e := m.fault("SaveServiceAccount"); e != nil {
+		return e
+	}
+	return m.Manager.SaveServiceAccount(a)
+}
+
+func (m *Faulty) DeleteServiceAccount(name string) error {
+	if e := m.fault("DeleteServiceAccount"); e != nil {
+		return e
+	}
+	return m.Manager.DeleteServiceAccount(name)
+}
+
+func (m *Faulty) SaveAPIKey(k *models.APIKey) error {
+	if e := m.fault("SaveAPIKey"); e != nil {
+		return e
+	}
+	return m.Manager.SaveAPIKey(k)
+}
+
+func (m *Faulty) DeleteAPIKey(id string) error {
+	if e := m.fault("DeleteAPIKey"); e != nil {
+		return e
+	}
+	return m.Manager.DeleteAPIKey(id)
+}
+
+func (m *Faulty) SaveScheduledAction(a *models.ScheduledAction) error {
+	if e := m.fault("SaveScheduledAction"); e != nil {
+		return e
+	}
+	return m.Manager.SaveScheduledAction(a)
+}
+
+func (m *Faulty) DeleteScheduledAction(id string) error {
+	if e := m.fault("DeleteScheduledAction"); e != nil {
+		return e
+	}
+	return m.Manager.DeleteScheduledAction(id)
+}
+
+func (m *Faulty) BufferNotification(n *models.BufferedNotification, limit int) (int, error) {
+	if e := m.fault("BufferNotification"); e != nil {
+		return 0, e
+	}
+	return m.Manager.BufferNotification(n, limit)
+}
+
+func (m *Faulty) TakeBufferedNotifications() ([]*models.BufferedNotification, error) {
+	if e := m.fault("TakeBufferedNotifications"); e != nil {
+		return nil, e
+	}
+	return m.Manager.TakeBufferedNotifications()
+}
+
+func (m *Faulty) SaveLiveStatus(s *models.LiveStatus) error {
+	if e := m.fault("SaveLiveStatus"); e != nil {
+		return e
+	}
+	return m.Manager.SaveLiveStatus(s)
+}
+
+func (m *Faulty) DeleteLiveStatus(channel string) error {
+	if e := m.fault("DeleteLiveStatus"); e != nil {
+		return e
+	}
+	return m.Manager.DeleteLiveStatus(channel)
+}
+
+func (m *Faulty) SaveSnapshot(name, env string, s *models.Snapshot) error {
+	if e := m.fault("SaveSnapshot"); e != nil {
+		return e
+	}
+	return m.Manager.SaveSnapshot(name, env, s)
+}
+
+func (m *Faulty) ForgetUser(userID string) (*models.Forgotten, error) {
+	if e := m.fault("ForgetUser"); e != nil {
+		return nil, e
+	}
+	return m.Manager.ForgetUser(userID)
+}
+
+func (m *Faulty) SetUserToken(userID, token string) error {
+	if e := m.fault("SetUserToken"); e != nil {
+		return e
+	}
+	return m.Manager.SetUserToken(userID, token)
+}
+
+func (m *Faulty) ClearQueueForResource(name, env string) error {
+	if e := m.fault("ClearQueueForResource"); e != nil {
+		return e
+	}
+	return m.Manager.ClearQueueForResource(name, env)
+}
+
+func (m *Faulty) Compact(before time.Time) (*models.Compaction, error) {
+	if e := m.fault("Compact"); e != nil {
+		return nil, e
+	}
+	return m.Manager.Compact(before)
+}
+
+func (m *Faulty) Dedupe() ([]*models.Repair, error) {
+	if e := m.fault("Dedupe"); e != nil {
+		return nil, e
+	}
+	return m.Manager.Dedupe()
+}
+
+func (m *Faulty) PruneInactiveResources(hours int) error {
+	if e := m.fault("PruneInactiveResources"); e != nil {
+		return e
+	}
+	return m.Manager.PruneInactiveResources(hours)
+}
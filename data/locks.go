@@ -0,0 +1,21 @@
+package data
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const lockStripes = 64
+
+// keyLocks is a fixed set of mutexes that resource keys are spread across, so writes to the same
+// resource are serialized while writes to unrelated resources rarely wait on each other
+type keyLocks struct {
+	stripes [lockStripes]sync.Mutex
+}
+
+// get returns the mutex for a resource key
+func (l *keyLocks) get(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &l.stripes[h.Sum32()%lockStripes]
+}
@@ -1,10 +1,28 @@
 package data
 
 import (
+	"fmt"
+	"sort"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
 	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
 )
 
 type Manager interface {
+	// ClaimIdempotencyKey records a key for the given time. It returns false if the key was already
+	// claimed, meaning the request it identifies has been handled.
+	ClaimIdempotencyKey(key string, ttl time.Duration) (bool, error)
+	// ReleaseIdempotencyKey forgets a claimed key, so that a request that failed can be retried with it
+	ReleaseIdempotencyKey(key string) error
+	// AddUsage adds to the hours and cost recorded for a user's use of a resource within a month
+	AddUsage(u *models.Usage) error
+	// AddContention adds a sample of how contended a resource was to the hour of the week it was taken in
+	AddContention(c *models.Contention) error
+	// AddHold records a hold of a resource once it ends. Holds that ended more than models.HoldHistory
+	// ago are forgotten.
+	AddHold(name string, env string, h *models.Hold) error
 	Create(name string, env string) error
 	GetAllUsersInQueues() []*models.User
 	GetPosition(u *models.User, name string, env string) (int, error)
@@ -16,10 +34,169 @@ type Manager interface {
 	GetResource(name string, env string, create bool) *models.Resource
 	GetResources() []*models.Resource
 	GetResourcesForEnv(env string) []*models.Resource
+	// GetEnvsForName returns the envs that have a resource with a name, sorted
+	GetEnvsForName(name string) []string
+	// GetUsage returns the usage recorded for a month, formatted with models.UsageMonthFormat, with one
+	// entry for each user and resource
+	GetUsage(month string) ([]*models.Usage, error)
+	// GetContention returns the contention sampled for each resource and hour of the week
+	GetContention() ([]*models.Contention, error)
+	// GetHolds returns the holds of a resource that ended after a time, in the order they ended
+	GetHolds(name string, env string, since time.Time) ([]*models.Hold, error)
+	// GetUserToken returns the Slack token a user granted the bot to act as them, or an empty string if
+	// they haven't
+	GetUserToken(userID string) (string, error)
+	// GetDefaultEnv returns the env a user's resources are in when they don't give one, or an empty
+	// string if they haven't set one
+	GetDefaultEnv(userID string) (string, error)
+	// GetStatusFilter returns the filter a user's status is shown with when they don't give one, or an
+	// empty string if they haven't saved one
+	GetStatusFilter(userID string) (string, error)
+	// GetMilestonesOff returns whether a user chose not to be told about the milestones they reach while
+	// waiting
+	GetMilestonesOff(userID string) (bool, error)
+	// GetServiceAccounts returns every service account, sorted by name
+	GetServiceAccounts() ([]*models.ServiceAccount, error)
+	// GetAPIKeys returns every API key, sorted by ID
+	GetAPIKeys() ([]*models.APIKey, error)
+	// GetLiveStatuses returns every live status message, sorted by channel
+	GetLiveStatuses() ([]*models.LiveStatus, error)
+	// TakeBufferedNotifications returns every buffered DM, oldest first, and empties the buffer
+	TakeBufferedNotifications() ([]*models.BufferedNotification, error)
+	// GetScheduledActions returns every command waiting to be run later, in the order they are due
+	GetScheduledActions() ([]*models.ScheduledAction, error)
+	// GetSnapshot returns the last snapshot taken of a resource's queue, or nil if there is none
+	GetSnapshot(name string, env string) (*models.Snapshot, error)
+	// Promote moves a user's reservation to the front of a resource's queue, making them the holder.
+	// Their reservation will have the time updated.
+	Promote(u *models.User, name string, env string) error
 	Remove(u *models.User, name string, env string) error
 	RemoveEnv(name string, env string) error
 	RemoveResource(name string, env string) error
+	// Requeue moves a user's reservation to the back of a resource's queue. If the user held the
+	// resource, the new holder's reservation will have the time updated.
+	Requeue(u *models.User, name string, env string) error
+	TouchResource(name string, env string) error
+	// Transfer hands a user's reservation for a resource to another user, who takes their place in the
+	// queue. If the other user is already in the queue, they keep whichever place is earlier. A holder's
+	// reservation that is handed on will have the time updated.
+	Transfer(from *models.User, to *models.User, name string, env string) error
 	Reserve(u *models.User, name string, env string) error
+	UpdateReservation(res *models.Reservation) error
+	UpdateResource(r *models.Resource) error
 	ClearQueueForResource(name, env string) error
+	// SetUserToken records the Slack token a user granted the bot to act as them. An empty token forgets
+	// it.
+	SetUserToken(userID, token string) error
+	// SetDefaultEnv records the env a user's resources are in when they don't give one. An empty env
+	// forgets it.
+	SetDefaultEnv(userID, env string) error
+	// SetStatusFilter saves the filter a user's status is shown with when they don't give one. An empty
+	// filter forgets it.
+	SetStatusFilter(userID, filter string) error
+	// SetMilestonesOff records whether a user chose not to be told about the milestones they reach while
+	// waiting
+	SetMilestonesOff(userID string, off bool) error
+	// SaveServiceAccount stores a service account, replacing any with the same name
+	SaveServiceAccount(a *models.ServiceAccount) error
+	// DeleteServiceAccount forgets a service account. It returns e.ServiceAccountDoesNotExist if there is
+	// none with the name.
+	DeleteServiceAccount(name string) error
+	// SaveAPIKey stores an API key, replacing any with the same ID
+	SaveAPIKey(k *models.APIKey) error
+	// DeleteAPIKey revokes an API key. It returns e.APIKeyDoesNotExist if there is none with the ID.
+	DeleteAPIKey(id string) error
+	// SaveLiveStatus stores a live status message, replacing any in the same channel
+	SaveLiveStatus(s *models.LiveStatus) error
+	// DeleteLiveStatus forgets the live status message of a channel. Nothing happens if it has none.
+	DeleteLiveStatus(channel string) error
+	// BufferNotification keeps a DM that couldn't be delivered, to deliver later. Only the newest limit
+	// DMs are kept, and it returns how many older ones were dropped to make room.
+	BufferNotification(n *models.BufferedNotification, limit int) (int, error)
+	// SaveScheduledAction stores a command to run later, replacing any with the same ID
+	SaveScheduledAction(a *models.ScheduledAction) error
+	// DeleteScheduledAction forgets a command that was to run later. It returns
+	// e.ScheduledActionDoesNotExist if there is none with the ID, so that of several instances deleting
+	// it to run it, only one does.
+	DeleteScheduledAction(id string) error
+	// SaveSnapshot stores a snapshot of a resource's queue, replacing any taken of it before. Snapshots
+	// are kept when the resource is removed.
+	SaveSnapshot(name string, env string, s *models.Snapshot) error
+	// ForgetUser deletes what is stored about a user apart from queues and resources: their past holds,
+	// usage, default env, Slack token, places in snapshots, commands waiting to run later and buffered
+	// DMs. It returns what was deleted.
+	ForgetUser(userID string) (*models.Forgotten, error)
+	// Compact deletes the past holds that ended, the usage of months that ended and the snapshots taken
+	// before a time, so that they don't grow without bound. It returns what was deleted.
+	Compact(before time.Time) (*models.Compaction, error)
+	// Dedupe collapses every user who is in a queue more than once, such as after a partial write, into
+	// their earliest place. It returns what was fixed.
+	Dedupe() ([]*models.Repair, error)
 	PruneInactiveResources(hours int) error
 }
+
+// alreadyInQueue returns the error for reserving a resource a user is already in the queue for, telling
+// them where they are in it
+func alreadyInQueue(idx int, r *models.Resource) error {
+	return e.AlreadyInQueue.Withf("you're already %s in the queue for %s", util.Ordinalize(idx+1), r)
+}
+
+// dedupe returns the indexes of the reservations to keep in a queue, keeping only the earliest of each
+// user's, along with a repair for each user who was in it more than once
+func dedupe(reservations []*models.Reservation, resource string) ([]int, []*models.Repair) {
+	kept := []int{}
+	repairs := map[string]*models.Repair{}
+	order := []string{}
+	seen := map[string]bool{}
+	for i, res := range reservations {
+		if !seen[res.User.ID] {
+			seen[res.User.ID] = true
+			kept = append(kept, i)
+			continue
+		}
+		r, ok := repairs[res.User.ID]
+		if !ok {
+			r = &models.Repair{Resource: resource, UserID: res.User.ID}
+			repairs[res.User.ID] = r
+			order = append(order, res.User.ID)
+		}
+		r.Removed++
+	}
+
+	ret := []*models.Repair{}
+	for _, id := range order {
+		ret = append(ret, repairs[id])
+	}
+	return kept, ret
+}
+
+// handOn returns a copy of a reservation for the user it is handed to, as a new reservation. A hold
+// that is handed on starts over.
+func handOn(res *models.Reservation, to *models.User, holding bool) *models.Reservation {
+	c := *res
+	c.ID = models.NewID()
+	c.User = to
+	c.RotationWarned = time.Time{}
+	c.ClaimBy = time.Time{}
+	c.Version++
+	if holding {
+		c.Time = time.Now()
+	}
+	return &c
+}
+
+// contentionField identifies the resource and hour of the week of contention, sorting by the hour of the
+// week first
+func contentionField(c *models.Contention) string {
+	return fmt.Sprintf("%d %02d %s", c.Weekday, c.Hour, c.Resource)
+}
+
+// sortScheduledActions sorts commands to run later by when they are due, then by ID
+func sortScheduledActions(actions []*models.ScheduledAction) {
+	sort.Slice(actions, func(i, j int) bool {
+		if !actions[i].At.Equal(actions[j].At) {
+			return actions[i].At.Before(actions[j].At)
+		}
+		return actions[i].ID < actions[j].ID
+	})
+}
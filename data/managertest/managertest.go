@@ -0,0 +1,1100 @@
+// Package managertest checks that a data.Manager implementation behaves like the others. Every backend
+// should pass Check, so that the bot works the same regardless of where it stores its data.
+package managertest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/data"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// Factory returns an empty manager. It is called once per check so that checks don't interfere.
+type Factory func() (data.Manager, error)
+
+type check struct {
+	name string
+	run  func(m data.Manager) error
+}
+
+var checks = []check{
+	{"queue ordering", checkQueueOrdering},
+	{"duplicate reservation", checkDuplicateReservation},
+	{"promotion on removal", checkPromotion},
+	{"removal errors", checkRemovalErrors},
+	{"clear queue", checkClearQueue},
+	{"env removal", checkRemoveEnv},
+	{"pruning", checkPruning},
+	{"resource versions", checkResourceVersions},
+	{"current resource", checkCurrentResource},
+	{"reservation IDs", checkReservationIDs},
+	{"idempotency keys", checkIdempotencyKeys},
+	{"requeue", checkRequeue},
+	{"promote", checkPromote},
+	{"transfer", checkTransfer},
+	{"usage", checkUsage},
+	{"contention", checkContention},
+	{"user tokens", checkUserTokens},
+	{"snapshots", checkSnapshots},
+	{"envs for names", checkEnvsForName},
+	{"default envs", checkDefaultEnvs},
+	{"status filters", checkStatusFilters},
+	{"milestones off", checkMilestonesOff},
+	{"holds", checkHolds},
+	{"service accounts", checkServiceAccounts},
+	{"API keys", checkAPIKeys},
+	{"live status", checkLiveStatuses},
+	{"notification buffer", checkNotificationBuffer},
+	{"scheduled actions", checkScheduledActions},
+	{"forget user", checkForgetUser},
+	{"compaction", checkCompact},
+	{"dedupe", checkDedupe},
+}
+
+// Check runs every conformance check against fresh managers from the factory. All failures are
+// reported together in the returned error.
+func Check(factory Factory) error {
+	failures := []string{}
+	for _, c := range checks {
+		m, err := factory()
+		if err != nil {
+			return err
+		}
+		if err := c.run(m); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", c.name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d conformance check(s) failed:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+func user(i int) *models.User {
+	return &models.User{
+		Name: fmt.Sprintf("user%d", i),
+		ID:   fmt.Sprintf("U%d", i),
+	}
+}
+
+// expectErr compares errors by identity, since backends return the shared errors from the err package
+func expectErr(op string, got, want error) error {
+	if !errors.Is(got, want) {
+		return fmt.Errorf("%s returned %v, expected %v", op, got, want)
+	}
+	return nil
+}
+
+// expectQueue verifies the users in a queue, in order
+func expectQueue(m data.Manager, name, env string, users ...*models.User) error {
+	q, err := m.GetQueueForResource(name, env)
+	if err != nil {
+		return err
+	}
+	if len(q.Reservations) != len(users) {
+		return fmt.Errorf("queue for %s|%s has %d reservations, expected %d", env, name, len(q.Reservations), len(users))
+	}
+	for i, u := range users {
+		if q.Reservations[i].User.ID != u.ID {
+			return fmt.Errorf("position %d of %s|%s is %s, expected %s", i+1, env, name, q.Reservations[i].User.ID, u.ID)
+		}
+		pos, err := m.GetPosition(u, name, env)
+		if err != nil {
+			return err
+		}
+		if pos != i+1 {
+			return fmt.Errorf("GetPosition for %s returned %d, expected %d", u.ID, pos, i+1)
+		}
+	}
+	return nil
+}
+
+func checkQueueOrdering(m data.Manager) error {
+	for i := 1; i <= 3; i++ {
+		if err := m.Reserve(user(i), "db", "dev"); err != nil {
+			return err
+		}
+	}
+	if err := expectQueue(m, "db", "dev", user(1), user(2), user(3)); err != nil {
+		return err
+	}
+
+	res, err := m.GetReservationForResource("db", "dev")
+	if err != nil {
+		return err
+	}
+	if res == nil || res.User.ID != user(1).ID {
+		return fmt.Errorf("GetReservationForResource did not return the first user")
+	}
+	return nil
+}
+
+func checkDuplicateReservation(m data.Manager) error {
+	if err := m.Reserve(user(1), "db", "dev"); err != nil {
+		return err
+	}
+	if err := expectErr("Reserve", m.Reserve(user(1), "db", "dev"), e.AlreadyInQueue); err != nil {
+		return err
+	}
+	// the same user may hold resources in other envs
+	if err := m.Reserve(user(1), "db", "stage"); err != nil {
+		return err
+	}
+	return expectQueue(m, "db", "dev", user(1))
+}
+
+func checkPromotion(m data.Manager) error {
+	for i := 1; i <= 3; i++ {
+		if err := m.Reserve(user(i), "db", "dev"); err != nil {
+			return err
+		}
+	}
+	before := m.GetReservation(user(2), "db", "dev")
+	if before == nil {
+		return fmt.Errorf("GetReservation returned nil")
+	}
+	// make sure the promotion time can be distinguished from the reservation time
+	time.Sleep(10 * time.Millisecond)
+
+	// removing a waiting user doesn't promote anyone
+	if err := m.Remove(user(3), "db", "dev"); err != nil {
+		return err
+	}
+	if r := m.GetReservation(user(2), "db", "dev"); r == nil || !r.Time.Equal(before.Time) {
+		return fmt.Errorf("reservation time changed when a user behind it was removed")
+	}
+
+	if err := m.Remove(user(1), "db", "dev"); err != nil {
+		return err
+	}
+	after := m.GetReservation(user(2), "db", "dev")
+	if after == nil {
+		return fmt.Errorf("GetReservation returned nil after promotion")
+	}
+	if !after.Time.After(before.Time) {
+		return fmt.Errorf("reservation time was not updated on promotion")
+	}
+	return expectQueue(m, "db", "dev", user(2))
+}
+
+func checkRemovalErrors(m data.Manager) error {
+	if err := expectErr("Remove", m.Remove(user(1), "missing", "dev"), e.ResourceDoesNotExist); err != nil {
+		return err
+	}
+	if err := m.Create("db", "dev"); err != nil {
+		return err
+	}
+	if err := expectErr("Remove", m.Remove(user(1), "db", "dev"), e.NotInQueue); err != nil {
+		return err
+	}
+	_, err := m.GetPosition(user(1), "db", "dev")
+	if err := expectErr("GetPosition", err, e.NotInQueue); err != nil {
+		return err
+	}
+	if err := expectErr("RemoveResource", m.RemoveResource("missing", "dev"), e.ResourceDoesNotExist); err != nil {
+		return err
+	}
+	return nil
+}
+
+func checkClearQueue(m data.Manager) error {
+	for i := 1; i <= 2; i++ {
+		if err := m.Reserve(user(i), "db", "dev"); err != nil {
+			return err
+		}
+	}
+	if err := m.ClearQueueForResource("db", "dev"); err != nil {
+		return err
+	}
+	if err := expectQueue(m, "db", "dev"); err != nil {
+		return err
+	}
+	if m.GetResource("db", "dev", false) == nil {
+		return fmt.Errorf("clearing a queue removed the resource")
+	}
+	return expectErr("ClearQueueForResource", m.ClearQueueForResource("missing", "dev"), e.ResourceDoesNotExist)
+}
+
+func checkRemoveEnv(m data.Manager) error {
+	for i, name := range []string{"db", "api", "web"} {
+		if err := m.Reserve(user(i), name, "dev"); err != nil {
+			return err
+		}
+		if err := m.Reserve(user(i+1), name, "dev"); err != nil {
+			return err
+		}
+	}
+	if err := m.Reserve(user(1), "db", "stage"); err != nil {
+		return err
+	}
+
+	if err := m.RemoveEnv("", "dev"); err != nil {
+		return err
+	}
+	if n := len(m.GetResourcesForEnv("dev")); n != 0 {
+		return fmt.Errorf("%d resources remain in a removed env", n)
+	}
+	for _, u := range m.GetAllUsersInQueues() {
+		if u.ID != user(1).ID {
+			return fmt.Errorf("%s is still queued in a removed env", u.ID)
+		}
+	}
+	if err := expectQueue(m, "db", "stage", user(1)); err != nil {
+		return err
+	}
+	return expectErr("RemoveEnv", m.RemoveEnv("", "dev"), e.EnvDoesNotExist)
+}
+
+func checkPruning(m data.Manager) error {
+	if err := m.Create("idle", "dev"); err != nil {
+		return err
+	}
+	if err := m.Reserve(user(1), "busy", "dev"); err != nil {
+		return err
+	}
+
+	// nothing has been inactive for an hour
+	if err := m.PruneInactiveResources(1); err != nil {
+		return err
+	}
+	if n := len(m.GetResources()); n != 2 {
+		return fmt.Errorf("pruning removed recently active resources, %d remain", n)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := m.PruneInactiveResources(0); err != nil {
+		return err
+	}
+	if m.GetResource("idle", "dev", false) != nil {
+		return fmt.Errorf("an inactive resource with an empty queue was not pruned")
+	}
+	if m.GetResource("busy", "dev", false) == nil {
+		return fmt.Errorf("a resource with reservations was pruned")
+	}
+	return nil
+}
+
+func checkResourceVersions(m data.Manager) error {
+	if err := m.Create("db", "dev"); err != nil {
+		return err
+	}
+	first := m.GetResource("db", "dev", false)
+	second := m.GetResource("db", "dev", false)
+	if first == nil || second == nil {
+		return fmt.Errorf("GetResource returned nil")
+	}
+
+	first.Emoji = ":one:"
+	if err := m.UpdateResource(first); err != nil {
+		return err
+	}
+	second.Emoji = ":two:"
+	if err := expectErr("UpdateResource", m.UpdateResource(second), e.Conflict); err != nil {
+		return err
+	}
+
+	if r := m.GetResource("db", "dev", false); r == nil || r.Emoji != ":one:" {
+		return fmt.Errorf("a conflicting update overwrote the resource")
+	}
+	return nil
+}
+
+// checkCurrentResource checks that reservations carry their resource's settings as they are now, not as
+// they were when the reservation was made
+func checkCurrentResource(m data.Manager) error {
+	if err := m.Reserve(user(1), "db", "dev"); err != nil {
+		return err
+	}
+	r := m.GetResource("db", "dev", false)
+	if r == nil {
+		return fmt.Errorf("GetResource returned nil")
+	}
+	r.Private = true
+	if err := m.UpdateResource(r); err != nil {
+		return err
+	}
+
+	if res := m.GetReservation(user(1), "db", "dev"); res == nil || !res.Resource.Private {
+		return fmt.Errorf("GetReservation returned the resource as it was when reserved")
+	}
+	if res, err := m.GetReservationForResource("db", "dev"); err != nil || res == nil || !res.Resource.Private {
+		return fmt.Errorf("GetReservationForResource returned the resource as it was when reserved")
+	}
+	if q, err := m.GetQueueForResource("db", "dev"); err != nil || len(q.Reservations) != 1 || !q.Reservations[0].Resource.Private {
+		return fmt.Errorf("GetQueueForResource returned the resource as it was when reserved")
+	}
+	for _, q := range m.GetQueues() {
+		for _, res := range q.Reservations {
+			if !res.Resource.Private {
+				return fmt.Errorf("GetQueues returned the resource as it was when reserved")
+			}
+		}
+	}
+	return nil
+}
+
+func checkReservationIDs(m data.Manager) error {
+	for i := 1; i <= 3; i++ {
+		if err := m.Reserve(user(i), "db", "dev"); err != nil {
+			return err
+		}
+	}
+	q, err := m.GetQueueForResource("db", "dev")
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, res := range q.Reservations {
+		if res.ID == "" {
+			return fmt.Errorf("reservation for %s has no ID", res.User.ID)
+		}
+		if seen[res.ID] {
+			return fmt.Errorf("reservation ID %s was reused", res.ID)
+		}
+		seen[res.ID] = true
+	}
+
+	// the ID must survive updates and promotion
+	first := q.Reservations[1].ID
+	if err := m.Remove(user(1), "db", "dev"); err != nil {
+		return err
+	}
+	if res := m.GetReservation(user(2), "db", "dev"); res == nil || res.ID != first {
+		return fmt.Errorf("reservation ID changed when the queue advanced")
+	}
+	return nil
+}
+
+func checkIdempotencyKeys(m data.Manager) error {
+	// keys may outlive the manager in shared storage, so each run uses its own
+	key := "managertest:" + models.NewID()
+
+	ok, err := m.ClaimIdempotencyKey(key, time.Minute)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("ClaimIdempotencyKey refused a new key")
+	}
+
+	ok, err = m.ClaimIdempotencyKey(key, time.Minute)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("ClaimIdempotencyKey accepted a key twice")
+	}
+
+	// a released key can be claimed again, as the request it identified failed
+	if err := m.ReleaseIdempotencyKey(key); err != nil {
+		return err
+	}
+	ok, err = m.ClaimIdempotencyKey(key, time.Minute)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("ClaimIdempotencyKey refused a released key")
+	}
+	return nil
+}
+
+func checkRequeue(m data.Manager) error {
+	for i := 1; i <= 3; i++ {
+		if err := m.Reserve(user(i), "db", "dev"); err != nil {
+			return err
+		}
+	}
+	first := m.GetReservation(user(1), "db", "dev")
+	before := m.GetReservation(user(2), "db", "dev")
+	if first == nil || before == nil {
+		return fmt.Errorf("GetReservation returned nil")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// requeueing the holder promotes the user behind them
+	if err := m.Requeue(user(1), "db", "dev"); err != nil {
+		return err
+	}
+	if err := expectQueue(m, "db", "dev", user(2), user(3), user(1)); err != nil {
+		return err
+	}
+	if r := m.GetReservation(user(2), "db", "dev"); r == nil || !r.Time.After(before.Time) {
+		return fmt.Errorf("reservation time was not updated on promotion")
+	}
+	if r := m.GetReservation(user(1), "db", "dev"); r == nil || r.ID != first.ID {
+		return fmt.Errorf("requeued reservation was not kept")
+	}
+
+	// requeueing a waiting user doesn't promote anyone
+	if err := m.Requeue(user(3), "db", "dev"); err != nil {
+		return err
+	}
+	if err := expectQueue(m, "db", "dev", user(2), user(1), user(3)); err != nil {
+		return err
+	}
+
+	if err := expectErr("Requeue", m.Requeue(user(4), "db", "dev"), e.NotInQueue); err != nil {
+		return err
+	}
+	return expectErr("Requeue", m.Requeue(user(1), "missing", "dev"), e.ResourceDoesNotExist)
+}
+
+func checkPromote(m data.Manager) error {
+	for i := 1; i <= 3; i++ {
+		if err := m.Reserve(user(i), "db", "dev"); err != nil {
+			return err
+		}
+	}
+	before := m.GetReservation(user(3), "db", "dev")
+	if before == nil {
+		return fmt.Errorf("GetReservation returned nil")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// promoting a waiting user puts them in front of the holder
+	if err := m.Promote(user(3), "db", "dev"); err != nil {
+		return err
+	}
+	if err := expectQueue(m, "db", "dev", user(3), user(1), user(2)); err != nil {
+		return err
+	}
+	r := m.GetReservation(user(3), "db", "dev")
+	if r == nil || r.ID != before.ID {
+		return fmt.Errorf("promoted reservation was not kept")
+	}
+	if !r.Time.After(before.Time) {
+		return fmt.Errorf("reservation time was not updated on promotion")
+	}
+
+	// promoting the holder keeps the order
+	if err := m.Promote(user(3), "db", "dev"); err != nil {
+		return err
+	}
+	if err := expectQueue(m, "db", "dev", user(3), user(1), user(2)); err != nil {
+		return err
+	}
+
+	if err := expectErr("Promote", m.Promote(user(4), "db", "dev"), e.NotInQueue); err != nil {
+		return err
+	}
+	return expectErr("Promote", m.Promote(user(1), "missing", "dev"), e.ResourceDoesNotExist)
+}
+
+func checkTransfer(m data.Manager) error {
+	for i := 1; i <= 4; i++ {
+		if err := m.Reserve(user(i), "db", "dev"); err != nil {
+			return err
+		}
+	}
+	before := m.GetReservation(user(1), "db", "dev")
+	if before == nil {
+		return fmt.Errorf("GetReservation returned nil")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// the holder's place is handed to someone new, as a new hold
+	if err := m.Transfer(user(1), user(5), "db", "dev"); err != nil {
+		return err
+	}
+	if err := expectQueue(m, "db", "dev", user(5), user(2), user(3), user(4)); err != nil {
+		return err
+	}
+	r := m.GetReservation(user(5), "db", "dev")
+	if r == nil || r.ID == "" || r.ID == before.ID {
+		return fmt.Errorf("handed on reservation was not given a new ID")
+	}
+	if !r.Time.After(before.Time) {
+		return fmt.Errorf("reservation time was not updated when the hold was handed on")
+	}
+
+	// someone further back moves up to the place they are handed
+	if err := m.Transfer(user(2), user(4), "db", "dev"); err != nil {
+		return err
+	}
+	if err := expectQueue(m, "db", "dev", user(5), user(4), user(3)); err != nil {
+		return err
+	}
+
+	// someone already ahead keeps their place
+	if err := m.Transfer(user(3), user(5), "db", "dev"); err != nil {
+		return err
+	}
+	if err := expectQueue(m, "db", "dev", user(5), user(4)); err != nil {
+		return err
+	}
+
+	if err := expectErr("Transfer", m.Transfer(user(1), user(2), "db", "dev"), e.NotInQueue); err != nil {
+		return err
+	}
+	return expectErr("Transfer", m.Transfer(user(1), user(2), "missing", "dev"), e.ResourceDoesNotExist)
+}
+
+func checkUsage(m data.Manager) error {
+	adds := []*models.Usage{
+		{Month: "2026-10", UserID: "U1", Resource: "dev|db", Hours: 1.5, Cost: 3},
+		{Month: "2026-10", UserID: "U1", Resource: "dev|db", Hours: 0.5, Cost: 1},
+		{Month: "2026-10", UserID: "U2", Resource: "dev|db", Hours: 2},
+		{Month: "2026-09", UserID: "U1", Resource: "dev|db", Hours: 4, Cost: 8},
+	}
+	for _, u := range adds {
+		if err := m.AddUsage(u); err != nil {
+			return err
+		}
+	}
+
+	usage, err := m.GetUsage("2026-10")
+	if err != nil {
+		return err
+	}
+	if len(usage) != 2 {
+		return fmt.Errorf("GetUsage returned %d entries, expected 2", len(usage))
+	}
+	for _, u := range usage {
+		want := map[string][2]float64{"U1": {2, 4}, "U2": {2, 0}}[u.UserID]
+		if u.Month != "2026-10" || u.Resource != "dev|db" || u.Hours != want[0] || u.Cost != want[1] {
+			return fmt.Errorf("GetUsage returned %+v, expected %v hours costing %v", u, want[0], want[1])
+		}
+	}
+
+	usage, err = m.GetUsage("2026-08")
+	if err != nil {
+		return err
+	}
+	if len(usage) != 0 {
+		return fmt.Errorf("GetUsage returned %d entries for a month without usage", len(usage))
+	}
+	return nil
+}
+
+func checkContention(m data.Manager) error {
+	adds := []*models.Contention{
+		{Resource: "dev|db", Weekday: time.Tuesday, Hour: 14, Sampled: 0.5, Contended: 0.5, Waiting: 1},
+		{Resource: "dev|db", Weekday: time.Tuesday, Hour: 14, Sampled: 0.5},
+		{Resource: "dev|db", Weekday: time.Monday, Hour: 9, Sampled: 0.5},
+		{Resource: "dev|api", Weekday: time.Tuesday, Hour: 14, Sampled: 0.5, Contended: 0.5, Waiting: 0.5},
+	}
+	for _, c := range adds {
+		if err := m.AddContention(c); err != nil {
+			return err
+		}
+	}
+
+	contention, err := m.GetContention()
+	if err != nil {
+		return err
+	}
+	if len(contention) != 3 {
+		return fmt.Errorf("GetContention returned %d entries, expected 3", len(contention))
+	}
+	for _, c := range contention {
+		if c.Resource == "dev|db" && c.Weekday == time.Tuesday && c.Hour == 14 {
+			if c.Sampled != 1 || c.Contended != 0.5 || c.Waiting != 1 {
+				return fmt.Errorf("GetContention returned %+v, expected 1h sampled, 0.5h contended and 1 waiting", c)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("GetContention returned no entry for dev|db on Tuesday at 14:00")
+}
+
+func checkUserTokens(m data.Manager) error {
+	if token, err := m.GetUserToken("U1"); err != nil || token != "" {
+		return fmt.Errorf("GetUserToken returned %q, %v for a user without a token", token, err)
+	}
+	if err := m.SetUserToken("U1", "xoxp-1"); err != nil {
+		return err
+	}
+	if token, err := m.GetUserToken("U1"); err != nil || token != "xoxp-1" {
+		return fmt.Errorf("GetUserToken returned %q, %v, expected xoxp-1", token, err)
+	}
+	if err := m.SetUserToken("U1", ""); err != nil {
+		return err
+	}
+	if token, err := m.GetUserToken("U1"); err != nil || token != "" {
+		return fmt.Errorf("GetUserToken returned %q, %v after the token was forgotten", token, err)
+	}
+	return nil
+}
+
+func checkSnapshots(m data.Manager) error {
+	if s, err := m.GetSnapshot("db", "dev"); err != nil || s != nil {
+		return fmt.Errorf("GetSnapshot returned %v, %v for a resource without a snapshot", s, err)
+	}
+	u := &models.User{ID: "U1", Name: "alice"}
+	s := &models.Snapshot{
+		Resource:     "dev|db",
+		Taken:        time.Now(),
+		By:           "U2",
+		Reservations: []*models.Reservation{{ID: "1", User: u, Duration: time.Hour}},
+	}
+	if err := m.SaveSnapshot("db", "dev", s); err != nil {
+		return err
+	}
+	got, err := m.GetSnapshot("db", "dev")
+	if err != nil {
+		return err
+	}
+	if got == nil || got.By != "U2" || len(got.Reservations) != 1 || got.Reservations[0].User.ID != "U1" || got.Reservations[0].Duration != time.Hour {
+		return fmt.Errorf("GetSnapshot returned %+v, expected the saved snapshot", got)
+	}
+	if s, err := m.GetSnapshot("api", "dev"); err != nil || s != nil {
+		return fmt.Errorf("GetSnapshot returned %v, %v for another resource", s, err)
+	}
+	return nil
+}
+
+func checkDefaultEnvs(m data.Manager) error {
+	if env, err := m.GetDefaultEnv("U1"); err != nil || env != "" {
+		return fmt.Errorf("GetDefaultEnv returned %q, %v for a user without a default env", env, err)
+	}
+	if err := m.SetDefaultEnv("U1", "staging"); err != nil {
+		return err
+	}
+	if env, err := m.GetDefaultEnv("U1"); err != nil || env != "staging" {
+		return fmt.Errorf("GetDefaultEnv returned %q, %v, expected staging", env, err)
+	}
+	if err := m.SetDefaultEnv("U1", ""); err != nil {
+		return err
+	}
+	if env, err := m.GetDefaultEnv("U1"); err != nil || env != "" {
+		return fmt.Errorf("GetDefaultEnv returned %q, %v after the default env was forgotten", env, err)
+	}
+	return nil
+}
+
+func checkStatusFilters(m data.Manager) error {
+	if filter, err := m.GetStatusFilter("U1"); err != nil || filter != "" {
+		return fmt.Errorf("GetStatusFilter returned %q, %v for a user without a status filter", filter, err)
+	}
+	if err := m.SetStatusFilter("U1", "team:payments"); err != nil {
+		return err
+	}
+	if filter, err := m.GetStatusFilter("U1"); err != nil || filter != "team:payments" {
+		return fmt.Errorf("GetStatusFilter returned %q, %v, expected team:payments", filter, err)
+	}
+	if err := m.SetStatusFilter("U1", ""); err != nil {
+		return err
+	}
+	if filter, err := m.GetStatusFilter("U1"); err != nil || filter != "" {
+		return fmt.Errorf("GetStatusFilter returned %q, %v after the status filter was forgotten", filter, err)
+	}
+	return nil
+}
+
+func checkMilestonesOff(m data.Manager) error {
+	if off, err := m.GetMilestonesOff("U1"); err != nil || off {
+		return fmt.Errorf("GetMilestonesOff returned %v, %v for a user who didn't turn milestones off", off, err)
+	}
+	if err := m.SetMilestonesOff("U1", true); err != nil {
+		return err
+	}
+	if off, err := m.GetMilestonesOff("U1"); err != nil || !off {
+		return fmt.Errorf("GetMilestonesOff returned %v, %v, expected true", off, err)
+	}
+	if off, err := m.GetMilestonesOff("U2"); err != nil || off {
+		return fmt.Errorf("GetMilestonesOff returned %v, %v for another user", off, err)
+	}
+	if err := m.SetMilestonesOff("U1", false); err != nil {
+		return err
+	}
+	if off, err := m.GetMilestonesOff("U1"); err != nil || off {
+		return fmt.Errorf("GetMilestonesOff returned %v, %v after milestones were turned back on", off, err)
+	}
+	return nil
+}
+
+func checkEnvsForName(m data.Manager) error {
+	for _, key := range [][2]string{{"db", "prod"}, {"db", "dev"}, {"api", "dev"}} {
+		if err := m.Create(key[0], key[1]); err != nil {
+			return err
+		}
+	}
+	if envs := m.GetEnvsForName("db"); strings.Join(envs, ",") != "dev,prod" {
+		return fmt.Errorf("GetEnvsForName returned %v, expected [dev prod]", envs)
+	}
+	if err := m.RemoveResource("db", "prod"); err != nil {
+		return err
+	}
+	if envs := m.GetEnvsForName("db"); strings.Join(envs, ",") != "dev" {
+		return fmt.Errorf("GetEnvsForName returned %v after prod|db was removed, expected [dev]", envs)
+	}
+	if envs := m.GetEnvsForName("web"); len(envs) != 0 {
+		return fmt.Errorf("GetEnvsForName returned %v for a name without resources", envs)
+	}
+	return nil
+}
+
+func checkHolds(m data.Manager) error {
+	now := time.Now().Truncate(time.Second)
+	holds := []*models.Hold{
+		{User: &models.User{ID: "U1"}, Start: now.Add(-5 * time.Hour), End: now.Add(-4 * time.Hour)},
+		{User: &models.User{ID: "U3"}, Start: now.Add(-models.HoldHistory - 2*time.Hour), End: now.Add(-models.HoldHistory - time.Hour)},
+		{User: &models.User{ID: "U2"}, Start: now.Add(-3 * time.Hour), End: now.Add(-time.Hour)},
+	}
+	for _, h := range holds {
+		if err := m.AddHold("db", "dev", h); err != nil {
+			return err
+		}
+	}
+	if err := m.AddHold("api", "dev", &models.Hold{User: &models.User{ID: "U4"}, Start: now.Add(-time.Hour), End: now}); err != nil {
+		return err
+	}
+
+	got, err := m.GetHolds("db", "dev", now.Add(-models.HoldHistory*2))
+	if err != nil {
+		return err
+	}
+	if len(got) != 2 || got[0].User.ID != "U1" || got[1].User.ID != "U2" || !got[1].Start.Equal(holds[2].Start) {
+		return fmt.Errorf("GetHolds returned %d holds, expected those of U1 and U2 in the order they ended", len(got))
+	}
+	got, err = m.GetHolds("db", "dev", now.Add(-2*time.Hour))
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 || got[0].User.ID != "U2" {
+		return fmt.Errorf("GetHolds returned %d holds since 2h ago, expected only that of U2", len(got))
+	}
+	return nil
+}
+
+func checkServiceAccounts(m data.Manager) error {
+	for _, name := range []string{"nightly", "deploy-pipeline"} {
+		if err := m.SaveServiceAccount(&models.ServiceAccount{Name: name, TokenHash: "old", CreatedBy: "U1"}); err != nil {
+			return err
+		}
+	}
+	if err := m.SaveServiceAccount(&models.ServiceAccount{Name: "nightly", TokenHash: "new", CreatedBy: "U1"}); err != nil {
+		return err
+	}
+	accounts, err := m.GetServiceAccounts()
+	if err != nil {
+		return err
+	}
+	if len(accounts) != 2 || accounts[0].Name != "deploy-pipeline" || accounts[1].Name != "nightly" || accounts[1].TokenHash != "new" {
+		return fmt.Errorf("GetServiceAccounts returned %d accounts, expected deploy-pipeline and the replaced nightly", len(accounts))
+	}
+
+	if err := m.DeleteServiceAccount("nightly"); err != nil {
+		return err
+	}
+	if err := m.DeleteServiceAccount("nightly"); !errors.Is(err, e.ServiceAccountDoesNotExist) {
+		return fmt.Errorf("DeleteServiceAccount returned %v for a deleted account, expected ServiceAccountDoesNotExist", err)
+	}
+	accounts, err = m.GetServiceAccounts()
+	if err != nil {
+		return err
+	}
+	if len(accounts) != 1 || accounts[0].Name != "deploy-pipeline" {
+		return fmt.Errorf("GetServiceAccounts returned %d accounts after a deletion, expected deploy-pipeline", len(accounts))
+	}
+	return nil
+}
+
+func checkAPIKeys(m data.Manager) error {
+	for _, id := range []string{"b2", "a1"} {
+		if err := m.SaveAPIKey(&models.APIKey{ID: id, Name: "ci", Scope: models.ScopeReserve, KeyHash: "old", RateLimit: 10}); err != nil {
+			return err
+		}
+	}
+	if err := m.SaveAPIKey(&models.APIKey{ID: "b2", Name: "ci", Scope: models.ScopeRead, KeyHash: "new", RateLimit: 10}); err != nil {
+		return err
+	}
+	keys, err := m.GetAPIKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) != 2 || keys[0].ID != "a1" || keys[1].ID != "b2" || keys[1].Scope != models.ScopeRead || keys[1].RateLimit != 10 {
+		return fmt.Errorf("GetAPIKeys returned %d keys, expected a1 and the replaced b2", len(keys))
+	}
+
+	if err := m.DeleteAPIKey("a1"); err != nil {
+		return err
+	}
+	if err := m.DeleteAPIKey("a1"); !errors.Is(err, e.APIKeyDoesNotExist) {
+		return fmt.Errorf("DeleteAPIKey returned %v for a revoked key, expected APIKeyDoesNotExist", err)
+	}
+	keys, err = m.GetAPIKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) != 1 || keys[0].ID != "b2" {
+		return fmt.Errorf("GetAPIKeys returned %d keys after a revocation, expected b2", len(keys))
+	}
+	return nil
+}
+
+func checkLiveStatuses(m data.Manager) error {
+	for _, channel := range []string{"C2", "C1"} {
+		if err := m.SaveLiveStatus(&models.LiveStatus{Channel: channel, Timestamp: "1.0"}); err != nil {
+			return err
+		}
+	}
+	if err := m.SaveLiveStatus(&models.LiveStatus{Channel: "C2", Timestamp: "2.0"}); err != nil {
+		return err
+	}
+	live, err := m.GetLiveStatuses()
+	if err != nil {
+		return err
+	}
+	if len(live) != 2 || live[0].Channel != "C1" || live[1].Channel != "C2" || live[1].Timestamp != "2.0" {
+		return fmt.Errorf("GetLiveStatuses returned %d messages, expected C1 and the replaced C2", len(live))
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.DeleteLiveStatus("C2"); err != nil {
+			return fmt.Errorf("DeleteLiveStatus returned %v, expected nil whether or not the channel has a message", err)
+		}
+	}
+	live, err = m.GetLiveStatuses()
+	if err != nil {
+		return err
+	}
+	if len(live) != 1 || live[0].Channel != "C1" {
+		return fmt.Errorf("GetLiveStatuses returned %d messages after a deletion, expected C1", len(live))
+	}
+	return nil
+}
+
+func checkNotificationBuffer(m data.Manager) error {
+	for i, msg := range []string{"one", "two", "three"} {
+		dropped, err := m.BufferNotification(&models.BufferedNotification{UserID: "U1", Message: msg}, 2)
+		if err != nil {
+			return err
+		}
+		expected := 0
+		if i == 2 {
+			expected = 1
+		}
+		if dropped != expected {
+			return fmt.Errorf("BufferNotification dropped %d DMs after %d were buffered with a limit of 2, expected %d", dropped, i+1, expected)
+		}
+	}
+	buffered, err := m.TakeBufferedNotifications()
+	if err != nil {
+		return err
+	}
+	if len(buffered) != 2 || buffered[0].Message != "two" || buffered[1].Message != "three" {
+		return fmt.Errorf("TakeBufferedNotifications returned %d DMs, expected the newest two, oldest first", len(buffered))
+	}
+	buffered, err = m.TakeBufferedNotifications()
+	if err != nil {
+		return err
+	}
+	if len(buffered) != 0 {
+		return fmt.Errorf("TakeBufferedNotifications returned %d DMs after they were taken, expected none", len(buffered))
+	}
+	return nil
+}
+
+func checkScheduledActions(m data.Manager) error {
+	now := time.Now().Truncate(time.Second)
+	for i, id := range []string{"later", "sooner"} {
+		a := &models.ScheduledAction{ID: id, UserID: "U1", Text: "reserve dev|db", Resources: []string{"dev|db"}, At: now.Add(time.Duration(2-i) * time.Hour)}
+		if err := m.SaveScheduledAction(a); err != nil {
+			return err
+		}
+	}
+	actions, err := m.GetScheduledActions()
+	if err != nil {
+		return err
+	}
+	if len(actions) != 2 || actions[0].ID != "sooner" || actions[1].ID != "later" || len(actions[0].Resources) != 1 || !actions[0].At.Equal(now.Add(time.Hour)) {
+		return fmt.Errorf("GetScheduledActions returned %d actions, expected sooner then later", len(actions))
+	}
+
+	if err := m.DeleteScheduledAction("sooner"); err != nil {
+		return err
+	}
+	if err := m.DeleteScheduledAction("sooner"); !errors.Is(err, e.ScheduledActionDoesNotExist) {
+		return fmt.Errorf("DeleteScheduledAction returned %v for a deleted action, expected ScheduledActionDoesNotExist", err)
+	}
+	actions, err = m.GetScheduledActions()
+	if err != nil {
+		return err
+	}
+	if len(actions) != 1 || actions[0].ID != "later" {
+		return fmt.Errorf("GetScheduledActions returned %d actions after a deletion, expected later", len(actions))
+	}
+	return nil
+}
+
+func checkForgetUser(m data.Manager) error {
+	now := time.Now().Truncate(time.Second)
+	for _, id := range []string{"U1", "U2"} {
+		if err := m.AddHold("db", "dev", &models.Hold{User: &models.User{ID: id}, Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)}); err != nil {
+			return err
+		}
+		if err := m.AddUsage(&models.Usage{Month: "2026-10", UserID: id, Resource: "dev|db", Hours: 1, Cost: 2}); err != nil {
+			return err
+		}
+	}
+	s := &models.Snapshot{
+		Resource: "dev|db",
+		By:       "U1",
+		Reservations: []*models.Reservation{
+			{ID: "1", User: &models.User{ID: "U1"}},
+			{ID: "2", User: &models.User{ID: "U2"}},
+		},
+	}
+	if err := m.SaveSnapshot("db", "dev", s); err != nil {
+		return err
+	}
+	if err := m.SetDefaultEnv("U1", "staging"); err != nil {
+		return err
+	}
+	if err := m.SetStatusFilter("U1", "env:staging"); err != nil {
+		return err
+	}
+	if err := m.SetMilestonesOff("U1", true); err != nil {
+		return err
+	}
+	if err := m.SetUserToken("U1", "xoxp-1"); err != nil {
+		return err
+	}
+	for _, a := range []*models.ScheduledAction{
+		{ID: "1", UserID: "U1", Text: "reserve dev|db", At: now.Add(time.Hour)},
+		{ID: "2", UserID: "U2", Text: "reserve dev|db", At: now.Add(time.Hour)},
+	} {
+		if err := m.SaveScheduledAction(a); err != nil {
+			return err
+		}
+	}
+	for _, id := range []string{"U1", "U2", "U1"} {
+		if _, err := m.BufferNotification(&models.BufferedNotification{UserID: id, Message: "You're up", Queued: now}, 10); err != nil {
+			return err
+		}
+	}
+
+	f, err := m.ForgetUser("U1")
+	if err != nil {
+		return err
+	}
+	if f.Holds != 1 || f.Usage != 1 || f.Snapshots != 1 || f.Scheduled != 1 || f.Notifications != 2 || !f.DefaultEnv || !f.StatusFilter || !f.MilestonesOff || !f.Token {
+		return fmt.Errorf("ForgetUser returned %+v, expected a hold, usage, a snapshot, a scheduled command, two DMs, a default env, a status filter, milestones off and a token", f)
+	}
+	holds, err := m.GetHolds("db", "dev", now.Add(-time.Hour*24))
+	if err != nil {
+		return err
+	}
+	if len(holds) != 1 || holds[0].User.ID != "U2" {
+		return fmt.Errorf("GetHolds returned %d holds after U1 was forgotten, expected only that of U2", len(holds))
+	}
+	usage, err := m.GetUsage("2026-10")
+	if err != nil {
+		return err
+	}
+	if len(usage) != 1 || usage[0].UserID != "U2" || usage[0].Cost != 2 {
+		return fmt.Errorf("GetUsage returned %d entries after U1 was forgotten, expected only that of U2", len(usage))
+	}
+	got, err := m.GetSnapshot("db", "dev")
+	if err != nil {
+		return err
+	}
+	if got == nil || got.By != "" || len(got.Reservations) != 1 || got.Reservations[0].User.ID != "U2" {
+		return fmt.Errorf("GetSnapshot returned %+v after U1 was forgotten, expected only U2 in it", got)
+	}
+	if env, err := m.GetDefaultEnv("U1"); err != nil || env != "" {
+		return fmt.Errorf("GetDefaultEnv returned %q, %v after U1 was forgotten", env, err)
+	}
+	if filter, err := m.GetStatusFilter("U1"); err != nil || filter != "" {
+		return fmt.Errorf("GetStatusFilter returned %q, %v after U1 was forgotten", filter, err)
+	}
+	if token, err := m.GetUserToken("U1"); err != nil || token != "" {
+		return fmt.Errorf("GetUserToken returned %q, %v after U1 was forgotten", token, err)
+	}
+	actions, err := m.GetScheduledActions()
+	if err != nil {
+		return err
+	}
+	if len(actions) != 1 || actions[0].UserID != "U2" {
+		return fmt.Errorf("GetScheduledActions returned %d actions after U1 was forgotten, expected only that of U2", len(actions))
+	}
+	buffered, err := m.TakeBufferedNotifications()
+	if err != nil {
+		return err
+	}
+	if len(buffered) != 1 || buffered[0].UserID != "U2" {
+		return fmt.Errorf("TakeBufferedNotifications returned %d DMs after U1 was forgotten, expected only that of U2", len(buffered))
+	}
+
+	if f, err := m.ForgetUser("U1"); err != nil || *f != (models.Forgotten{}) {
+		return fmt.Errorf("ForgetUser returned %+v, %v for a user already forgotten", f, err)
+	}
+	return nil
+}
+
+func checkCompact(m data.Manager) error {
+	now := time.Now().Truncate(time.Second)
+	cutoff := now.Add(-10 * 24 * time.Hour)
+	for _, end := range []time.Time{cutoff.Add(-time.Hour), cutoff.Add(time.Hour)} {
+		if err := m.AddHold("db", "dev", &models.Hold{User: &models.User{ID: "U1"}, Start: end.Add(-time.Hour), End: end}); err != nil {
+			return err
+		}
+	}
+	old := cutoff.AddDate(0, -1, 0).Format(models.UsageMonthFormat)
+	for _, month := range []string{old, old, now.Format(models.UsageMonthFormat)} {
+		for _, id := range []string{"U1", "U2"} {
+			if err := m.AddUsage(&models.Usage{Month: month, UserID: id, Resource: "dev|db", Hours: 1}); err != nil {
+				return err
+			}
+		}
+	}
+	for name, taken := range map[string]time.Time{"db": cutoff.Add(-time.Hour), "api": now} {
+		if err := m.SaveSnapshot(name, "dev", &models.Snapshot{Resource: "dev|" + name, Taken: taken}); err != nil {
+			return err
+		}
+	}
+
+	c, err := m.Compact(cutoff)
+	if err != nil {
+		return err
+	}
+	if c.Holds != 1 || c.Usage != 2 || c.Snapshots != 1 {
+		return fmt.Errorf("Compact returned %+v, expected a hold, 2 usage records and a snapshot", c)
+	}
+	holds, err := m.GetHolds("db", "dev", now.Add(-models.HoldHistory))
+	if err != nil {
+		return err
+	}
+	if len(holds) != 1 || !holds[0].End.Equal(cutoff.Add(time.Hour)) {
+		return fmt.Errorf("GetHolds returned %d holds after compaction, expected only the one that ended after the cutoff", len(holds))
+	}
+	if usage, err := m.GetUsage(old); err != nil || len(usage) != 0 {
+		return fmt.Errorf("GetUsage returned %d entries, %v for a compacted month", len(usage), err)
+	}
+	if usage, err := m.GetUsage(now.Format(models.UsageMonthFormat)); err != nil || len(usage) != 2 {
+		return fmt.Errorf("GetUsage returned %d entries, %v for the current month, expected 2", len(usage), err)
+	}
+	if s, err := m.GetSnapshot("db", "dev"); err != nil || s != nil {
+		return fmt.Errorf("GetSnapshot returned %v, %v for a compacted snapshot", s, err)
+	}
+	if s, err := m.GetSnapshot("api", "dev"); err != nil || s == nil {
+		return fmt.Errorf("GetSnapshot returned %v, %v for a snapshot taken after the cutoff", s, err)
+	}
+	return nil
+}
+
+// checkDedupe checks that queues without duplicates are left alone. Duplicates can't be made through the
+// Manager, so only that nothing is wrongly repaired is checked.
+func checkDedupe(m data.Manager) error {
+	for i := 1; i <= 2; i++ {
+		if err := m.Reserve(user(i), "db", "dev"); err != nil {
+			return err
+		}
+	}
+	repairs, err := m.Dedupe()
+	if err != nil {
+		return err
+	}
+	if len(repairs) != 0 {
+		return fmt.Errorf("Dedupe returned %d repairs for queues without duplicates", len(repairs))
+	}
+	return expectQueue(m, "db", "dev", user(1), user(2))
+}
@@ -0,0 +1,57 @@
+package managertest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis returns a factory of managers on the Redis database given by REDIS_ADDRESS, REDIS_PASS and
+// REDIS_DB, database 15 on localhost by default. Everything the bot stores in the database, under keys
+// starting with reservebot, is removed for each manager, so it must not be one the bot uses. The test or
+// benchmark is skipped if Redis can't be reached.
+func Redis(tb testing.TB) Factory {
+	tb.Helper()
+	addr := util.LookupEnvOrString("REDIS_ADDRESS", "localhost:6379")
+	pass := util.LookupEnvOrString("REDIS_PASS", "")
+	db := util.LookupEnvOrInt("REDIS_DB", 15)
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: pass,
+		DB:       db,
+	})
+	defer rdb.Close()
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		tb.Skipf("Redis isn't available at %s: %v", addr, err)
+	}
+
+	return func() (data.Manager, error) {
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: pass,
+			DB:       db,
+		})
+		defer rdb.Close()
+		if err := clearKeys(rdb); err != nil {
+			return nil, err
+		}
+		return data.NewRedis(addr, pass, db), nil
+	}
+}
+
+// clearKeys deletes every key the bot stores, including holds, usage, snapshots, idempotency keys,
+// scheduled actions and buffered DMs, so that nothing leaks from one check into the next
+func clearKeys(rdb *redis.Client) error {
+	ctx := context.Background()
+	iter := rdb.Scan(ctx, 0, "reservebot*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := rdb.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
@@ -7,208 +7,482 @@ import (
 
 	"github.com/ameliagapin/reservebot/err"
 	"github.com/ameliagapin/reservebot/models"
-	log "github.com/sirupsen/logrus"
 )
 
+// Memory keeps each resource and its queue in an entry with its own lock, so operations on one resource
+// don't block operations on others. The map of entries has a separate lock that is only held while
+// entries are looked up, added or removed. An entry lock must never be held while acquiring the map lock.
 type Memory struct {
-	Reservations []*models.Reservation
-	Resources    map[string]*models.Resource
+	entries map[string]*memoryEntry
+	// names indexes the envs that have a resource with each name
+	names map[string]map[string]bool
+	lock  sync.RWMutex
+
+	// keys maps claimed idempotency keys to when they expire
+	keys     map[string]time.Time
+	keysLock sync.Mutex
+
+	// usage maps each month to the usage recorded for it, by user and resource
+	usage     map[string]map[string]*models.Usage
+	usageLock sync.Mutex
+
+	// contention maps each resource and hour of the week to the contention sampled in it
+	contention     map[string]*models.Contention
+	contentionLock sync.Mutex
+
+	// tokens maps user IDs to the Slack tokens they granted
+	tokens     map[string]string
+	tokensLock sync.Mutex
+
+	// envs maps user IDs to the env they use when they don't give one
+	envs     map[string]string
+	envsLock sync.Mutex
+
+	// filters maps user IDs to the filter their status is shown with when they don't give one
+	filters     map[string]string
+	filtersLock sync.Mutex
+
+	// quiet holds the IDs of users who chose not to be told about milestones in line
+	quiet     map[string]bool
+	quietLock sync.Mutex
+
+	// snapshots maps resource keys to the last snapshot of their queue
+	snapshots     map[string]*models.Snapshot
+	snapshotsLock sync.Mutex
+
+	// holds maps resource keys to their recent holds, in the order they ended
+	holds     map[string][]*models.Hold
+	holdsLock sync.Mutex
+
+	// accounts maps names to service accounts
+	accounts     map[string]*models.ServiceAccount
+	accountsLock sync.Mutex
+
+	// apiKeys maps IDs to API keys
+	apiKeys     map[string]*models.APIKey
+	apiKeysLock sync.Mutex
+
+	// live maps channel IDs to their live status messages
+	live     map[string]*models.LiveStatus
+	liveLock sync.Mutex
+
+	// scheduled maps IDs to the commands to run later
+	scheduled     map[string]*models.ScheduledAction
+	scheduledLock sync.Mutex
+
+	// buffered are the DMs waiting to be delivered, oldest first
+	buffered     []*models.BufferedNotification
+	bufferedLock sync.Mutex
+}
 
-	lock sync.Mutex
+type memoryEntry struct {
+	// key, name and env never change, so they can be read without the lock
+	key  string
+	name string
+	env  string
+
+	lock         sync.Mutex
+	resource     *models.Resource
+	reservations []*models.Reservation
+	// removed is set when the entry is deleted, so that anyone who looked it up beforehand knows the
+	// resource no longer exists
+	removed bool
 }
 
 func NewMemory() *Memory {
 	return &Memory{
-		Reservations: []*models.Reservation{},
-		Resources:    map[string]*models.Resource{},
+		entries:    map[string]*memoryEntry{},
+		names:      map[string]map[string]bool{},
+		keys:       map[string]time.Time{},
+		usage:      map[string]map[string]*models.Usage{},
+		contention: map[string]*models.Contention{},
+		tokens:     map[string]string{},
+		snapshots:  map[string]*models.Snapshot{},
+		envs:       map[string]string{},
+		filters:    map[string]string{},
+		quiet:      map[string]bool{},
+		holds:      map[string][]*models.Hold{},
+		accounts:   map[string]*models.ServiceAccount{},
+		apiKeys:    map[string]*models.APIKey{},
+		live:       map[string]*models.LiveStatus{},
+		scheduled:  map[string]*models.ScheduledAction{},
 	}
 }
 
-func (m *Memory) Create(name, env string) error {
-	// GetResource creates the resource if it doesn't exist
-	r := m.GetResource(name, env, true)
-	r.LastActivity = time.Now()
+// NewMemoryFrom returns a Memory holding a copy of the resources and queues of another manager, such as
+// for trying changes out on. Nothing else is copied.
+func NewMemoryFrom(m Manager) *Memory {
+	ret := NewMemory()
+	for _, q := range m.GetQueues() {
+		ent := ret.entry(q.Resource.Name, q.Resource.Env, true)
+		ent.resource = q.Resource.Copy()
+		for _, res := range q.Reservations {
+			c := *res
+			u := *res.User
+			c.User = &u
+			c.Resource = ent.resource
+			ent.reservations = append(ent.reservations, &c)
+		}
+		ent.lock.Unlock()
+	}
+	return ret
+}
 
-	return nil
+// entry returns the locked entry for a resource, creating it if requested. It returns nil if the
+// resource does not exist. The caller must unlock the entry.
+func (m *Memory) entry(name, env string, create bool) *memoryEntry {
+	key := models.ResourceKey(name, env)
+	for {
+		m.lock.RLock()
+		ent, ok := m.entries[key]
+		m.lock.RUnlock()
+
+		if !ok {
+			if !create {
+				return nil
+			}
+			m.lock.Lock()
+			ent, ok = m.entries[key]
+			if !ok {
+				ent = &memoryEntry{
+					key:  key,
+					name: name,
+					env:  env,
+					resource: &models.Resource{
+						Name:         name,
+						Env:          env,
+						LastActivity: time.Now(),
+					},
+				}
+				m.entries[key] = ent
+				if m.names[name] == nil {
+					m.names[name] = map[string]bool{}
+				}
+				m.names[name][env] = true
+			}
+			m.lock.Unlock()
+		}
+
+		ent.lock.Lock()
+		if !ent.removed {
+			return ent
+		}
+		// the entry was removed after it was looked up, so look again
+		ent.lock.Unlock()
+	}
 }
 
-func (m *Memory) Reserve(u *models.User, name, env string) error {
-	r := m.GetResource(name, env, true)
+// sortedEntries returns the entries ordered by key, optionally limited to a single env. The entries
+// are not locked and may be removed before they are used.
+func (m *Memory) sortedEntries(env *string) []*memoryEntry {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 
+	keys := []string{}
+	for k, ent := range m.entries {
+		if env != nil && ent.env != *env {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := []*memoryEntry{}
+	for _, k := range keys {
+		ret = append(ret, m.entries[k])
+	}
+	return ret
+}
+
+// remove deletes the entries that match the condition, which is evaluated with each entry locked
+func (m *Memory) remove(entries []*memoryEntry, cond func(ent *memoryEntry) bool) int {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	// check for existing reservation
-	for _, res := range m.Reservations {
-		if res.User.ID == u.ID {
-			if res.Resource.Key() == r.Key() {
-				return err.AlreadyInQueue
+	removed := 0
+	for _, ent := range entries {
+		ent.lock.Lock()
+		if !ent.removed && cond(ent) {
+			ent.removed = true
+			delete(m.entries, ent.key)
+			delete(m.names[ent.name], ent.env)
+			if len(m.names[ent.name]) == 0 {
+				delete(m.names, ent.name)
 			}
+			removed++
 		}
+		ent.lock.Unlock()
+	}
+	return removed
+}
+
+func (m *Memory) Create(name, env string) error {
+	ent := m.entry(name, env, true)
+	defer ent.lock.Unlock()
+
+	touch(ent.resource)
+
+	return nil
+}
+
+func (m *Memory) Reserve(u *models.User, name, env string) error {
+	ent := m.entry(name, env, true)
+	defer ent.lock.Unlock()
+
+	if ent.resource.ActiveMaintenance(time.Now()) != nil {
+		return err.InMaintenance
+	}
+
+	// check for existing reservation
+	if idx := ent.find(u); idx != -1 {
+		return alreadyInQueue(idx, ent.resource)
 	}
 
+	now := time.Now()
 	res := &models.Reservation{
+		ID:       models.NewID(),
 		User:     u,
-		Resource: r,
-		Time:     time.Now(),
+		Resource: ent.resource,
+		Time:     now,
+		Joined:   now,
 	}
 
-	m.Reservations = append(m.Reservations, res)
-	r.LastActivity = time.Now()
+	ent.reservations = append(ent.reservations, res)
+	touch(ent.resource)
 
 	return nil
 }
 
 func (m *Memory) GetReservation(u *models.User, name, env string) *models.Reservation {
-	r := m.GetResource(name, env, false)
-	if r == nil {
+	ent := m.entry(name, env, false)
+	if ent == nil {
 		return nil
 	}
+	defer ent.lock.Unlock()
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	idx := ent.find(u)
+	if idx == -1 {
+		return nil
+	}
+	return ent.reservation(idx, ent.resource.Copy())
+}
 
-	for _, res := range m.Reservations {
-		if res.User.ID == u.ID {
-			if res.Resource.Key() == r.Key() {
-				return res
-			}
-		}
+// UpdateReservation persists changes to an existing reservation, matched by user and resource.
+// Returns a conflict if the reservation was modified since it was read.
+func (m *Memory) UpdateReservation(res *models.Reservation) error {
+	ent := m.entry(res.Resource.Name, res.Resource.Env, false)
+	if ent == nil {
+		return err.NotInQueue
+	}
+	defer ent.lock.Unlock()
+
+	idx := ent.find(res.User)
+	if idx == -1 {
+		return err.NotInQueue
+	}
+	if ent.reservations[idx].Version != res.Version {
+		return err.Conflict
 	}
+	res.Version++
+	c := *res
+	c.Resource = ent.resource
+	ent.reservations[idx] = &c
+
 	return nil
 }
 
 // Remove removes a user from a resource's queue.
 // If the removal advances the queue, the new resource holder's reservation will have the time updated
 func (m *Memory) Remove(u *models.User, name, env string) error {
-	// minor optimization: if the resource doesn't exist, there's no need to loop through all reservations
-	r := m.GetResource(name, env, false)
-	if r == nil {
+	ent := m.entry(name, env, false)
+	if ent == nil {
 		return err.ResourceDoesNotExist
 	}
+	defer ent.lock.Unlock()
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	idx := ent.find(u)
+	if idx == -1 {
+		return err.NotInQueue
+	}
 
-	idx := -1
-	pos := 0
-	for i, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			pos++
-			if res.User.ID == u.ID {
-				idx = i
-				break
-			}
-		}
+	ent.reservations = append(ent.reservations[:idx], ent.reservations[idx+1:]...)
+
+	// if the user was in pos=1, then removal would move new user into pos=1. This should update the time on their res
+	if idx == 0 && len(ent.reservations) > 0 {
+		ent.reservations[0].Time = time.Now()
+		ent.reservations[0].Version++
 	}
+
+	touch(ent.resource)
+
+	return nil
+}
+
+func (m *Memory) Requeue(u *models.User, name, env string) error {
+	ent := m.entry(name, env, false)
+	if ent == nil {
+		return err.ResourceDoesNotExist
+	}
+	defer ent.lock.Unlock()
+
+	idx := ent.find(u)
 	if idx == -1 {
 		return err.NotInQueue
 	}
 
-	m.Reservations = append(m.Reservations[:idx], m.Reservations[idx+1:]...)
+	res := ent.reservations[idx]
+	res.Joined = time.Now()
+	res.Version++
+	ent.reservations = append(ent.reservations[:idx], ent.reservations[idx+1:]...)
+	ent.reservations = append(ent.reservations, res)
 
-	// if the user was in pos=1, then removal would move new user into pos=1. This should update the time on their res
-	if pos == 1 {
-		for _, res := range m.Reservations {
-			if res.Resource.Key() == r.Key() {
-				res.Time = time.Now()
-				break
-			}
-		}
+	if idx == 0 && len(ent.reservations) > 1 {
+		ent.reservations[0].Time = time.Now()
+		ent.reservations[0].Version++
 	}
 
-	r.LastActivity = time.Now()
+	touch(ent.resource)
 
 	return nil
 }
 
-func (m *Memory) GetPosition(u *models.User, name, env string) (int, error) {
-	r := m.GetResource(name, env, false)
-	if r == nil {
-		return 0, err.ResourceDoesNotExist
+func (m *Memory) Promote(u *models.User, name, env string) error {
+	ent := m.entry(name, env, false)
+	if ent == nil {
+		return err.ResourceDoesNotExist
 	}
+	defer ent.lock.Unlock()
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	idx := ent.find(u)
+	if idx == -1 {
+		return err.NotInQueue
+	}
 
-	pos := 0
-	inQueue := false
+	res := ent.reservations[idx]
+	res.Time = time.Now()
+	res.Version++
+	ent.reservations = append(ent.reservations[:idx], ent.reservations[idx+1:]...)
+	ent.reservations = append([]*models.Reservation{res}, ent.reservations...)
 
-	for _, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			// increment pos first because want to return zero-based index
-			pos++
-			if res.User.ID == u.ID {
-				inQueue = true
-				break
-			}
+	touch(ent.resource)
+
+	return nil
+}
+
+func (m *Memory) Transfer(from, to *models.User, name, env string) error {
+	ent := m.entry(name, env, false)
+	if ent == nil {
+		return err.ResourceDoesNotExist
+	}
+	defer ent.lock.Unlock()
+
+	idx := ent.find(from)
+	if idx == -1 {
+		return err.NotInQueue
+	}
+	if other := ent.find(to); other != -1 {
+		if other < idx {
+			ent.reservations = append(ent.reservations[:idx], ent.reservations[idx+1:]...)
+			touch(ent.resource)
+			return nil
 		}
+		ent.reservations = append(ent.reservations[:other], ent.reservations[other+1:]...)
+	}
+	ent.reservations[idx] = handOn(ent.reservations[idx], to, idx == 0)
+
+	touch(ent.resource)
+
+	return nil
+}
+
+func (m *Memory) GetPosition(u *models.User, name, env string) (int, error) {
+	ent := m.entry(name, env, false)
+	if ent == nil {
+		return 0, err.ResourceDoesNotExist
 	}
-	if !inQueue {
+	defer ent.lock.Unlock()
+
+	idx := ent.find(u)
+	if idx == -1 {
 		return 0, err.NotInQueue
 	}
 
-	return pos, nil
+	// positions are one-based
+	return idx + 1, nil
 }
 
+// GetResource returns a copy of the resource. Changes must be saved with UpdateResource.
 func (m *Memory) GetResource(name, env string, create bool) *models.Resource {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	key := models.ResourceKey(name, env)
-	r, ok := m.Resources[key]
-	if !ok {
-		if create {
-			r = &models.Resource{
-				Name: name,
-				Env:  env,
-			}
-			m.Resources[r.Key()] = r
-		}
+	ent := m.entry(name, env, create)
+	if ent == nil {
+		return nil
 	}
-	return r
+	defer ent.lock.Unlock()
+
+	return ent.resource.Copy()
 }
 
-func (m *Memory) RemoveResource(name, env string) error {
-	r := m.GetResource(name, env, false)
-	if r == nil {
+// UpdateResource persists changes to an existing resource's settings.
+// Returns a conflict if the resource was modified since it was read.
+func (m *Memory) UpdateResource(r *models.Resource) error {
+	ent := m.entry(r.Name, r.Env, false)
+	if ent == nil {
 		return err.ResourceDoesNotExist
 	}
+	defer ent.lock.Unlock()
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	if ent.resource.Version != r.Version {
+		return err.Conflict
+	}
+	r.Version++
+
+	// activity is tracked separately from settings and must not be rolled back by a stale copy
+	stored := r.Copy()
+	stored.LastActivity = ent.resource.LastActivity
+	ent.resource = stored
+	for _, res := range ent.reservations {
+		res.Resource = stored
+	}
 
-	for idx, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			m.Reservations = append(m.Reservations[:idx], m.Reservations[idx+1:]...)
-		}
+	return nil
+}
+
+// TouchResource records activity on a resource so that it isn't pruned
+func (m *Memory) TouchResource(name, env string) error {
+	ent := m.entry(name, env, false)
+	if ent == nil {
+		return err.ResourceDoesNotExist
 	}
+	defer ent.lock.Unlock()
 
-	delete(m.Resources, r.Key())
+	touch(ent.resource)
 
 	return nil
 }
 
-func (m *Memory) RemoveEnv(name, env string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	exists := false
-	for idx, res := range m.Reservations {
-		if res.Resource.Env == env {
-			m.Reservations = append(m.Reservations[:idx], m.Reservations[idx+1:]...)
-			exists = true
-		}
+func (m *Memory) RemoveResource(name, env string) error {
+	m.lock.RLock()
+	ent, ok := m.entries[models.ResourceKey(name, env)]
+	m.lock.RUnlock()
+	if !ok {
+		return err.ResourceDoesNotExist
 	}
 
-	for k, res := range m.Resources {
-		if res.Env == env {
-			delete(m.Resources, k)
-			exists = true
-		}
+	removed := m.remove([]*memoryEntry{ent}, func(*memoryEntry) bool {
+		return true
+	})
+	if removed == 0 {
+		return err.ResourceDoesNotExist
 	}
 
-	if !exists {
+	return nil
+}
+
+func (m *Memory) RemoveEnv(name, env string) error {
+	removed := m.remove(m.sortedEntries(&env), func(*memoryEntry) bool {
+		return true
+	})
+	if removed == 0 {
 		return err.EnvDoesNotExist
 	}
 
@@ -216,118 +490,95 @@ func (m *Memory) RemoveEnv(name, env string) error {
 }
 
 func (m *Memory) GetResources() []*models.Resource {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	keys := []string{}
-	for k, _ := range m.Resources {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
+	return m.resources(nil)
+}
 
+// resources returns copies of the resources, optionally limited to a single env
+func (m *Memory) resources(env *string) []*models.Resource {
 	ret := []*models.Resource{}
-	for _, k := range keys {
-		ret = append(ret, m.Resources[k])
+	for _, ent := range m.sortedEntries(env) {
+		ent.lock.Lock()
+		if !ent.removed {
+			ret = append(ret, ent.resource.Copy())
+		}
+		ent.lock.Unlock()
 	}
-
 	return ret
 }
 
-// Does not implement lock
 func (m *Memory) GetQueues() []*models.Queue {
-	ret := []*models.Queue{}
+	return m.queues(nil)
+}
 
-	resources := m.GetResources()
-	for _, r := range resources {
-		q, _ := m.GetQueueForResource(r.Name, r.Env)
-		ret = append(ret, q)
+// queues builds the queues for all resources, optionally limited to a single env. Each resource is
+// locked only while its own queue is copied.
+func (m *Memory) queues(env *string) []*models.Queue {
+	ret := []*models.Queue{}
+	for _, ent := range m.sortedEntries(env) {
+		ent.lock.Lock()
+		if !ent.removed {
+			ret = append(ret, ent.queue())
+		}
+		ent.lock.Unlock()
 	}
-
 	return ret
 }
 
 func (m *Memory) GetQueueForResource(name, env string) (*models.Queue, error) {
-	// minor optimization
-	r := m.GetResource(name, env, false)
-	if r == nil {
+	ent := m.entry(name, env, false)
+	if ent == nil {
 		return nil, err.ResourceDoesNotExist
 	}
+	defer ent.lock.Unlock()
 
-	ret := &models.Queue{
-		Resource: r,
-	}
-
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	for _, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			ret.Reservations = append(ret.Reservations, res)
-		}
-	}
-
-	return ret, nil
+	return ent.queue(), nil
 }
 
 func (m *Memory) GetReservationForResource(name, env string) (*models.Reservation, error) {
-	// minor optimization
-	r := m.GetResource(name, env, false)
-	if r == nil {
+	ent := m.entry(name, env, false)
+	if ent == nil {
 		return nil, err.ResourceDoesNotExist
 	}
+	defer ent.lock.Unlock()
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	for _, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			return res, nil
-		}
+	if len(ent.reservations) == 0 {
+		return nil, nil
 	}
-
-	return nil, nil
+	return ent.reservation(0, ent.resource.Copy()), nil
 }
 
-// Does not implement lock
 func (m *Memory) GetQueuesForEnv(env string) map[string]*models.Queue {
 	ret := make(map[string]*models.Queue)
-
-	resources := m.GetResourcesForEnv(env)
-	for _, r := range resources {
-		q, _ := m.GetQueueForResource(r.Name, r.Env)
-		ret[r.Name] = q
+	for _, q := range m.queues(&env) {
+		ret[q.Resource.Name] = q
 	}
 
 	return ret
 }
 
 func (m *Memory) GetResourcesForEnv(env string) []*models.Resource {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	return m.resources(&env)
+}
 
-	keys := []string{}
-	for k, r := range m.Resources {
-		if r.Env == env {
-			keys = append(keys, k)
-		}
-	}
-	sort.Strings(keys)
+func (m *Memory) GetEnvsForName(name string) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 
-	ret := []*models.Resource{}
-	for _, k := range keys {
-		ret = append(ret, m.Resources[k])
+	ret := []string{}
+	for env := range m.names[name] {
+		ret = append(ret, env)
 	}
+	sort.Strings(ret)
 	return ret
 }
 
 func (m *Memory) GetAllUsersInQueues() []*models.User {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
 	all := map[string]*models.User{}
 
-	for _, r := range m.Reservations {
-		all[r.User.ID] = r.User
+	for _, q := range m.queues(nil) {
+		for _, r := range q.Reservations {
+			all[r.User.ID] = r.User
+		}
 	}
 
 	ret := []*models.User{}
@@ -339,45 +590,600 @@ func (m *Memory) GetAllUsersInQueues() []*models.User {
 }
 
 func (m *Memory) ClearQueueForResource(name, env string) error {
-	// minor optimization
-	r := m.GetResource(name, env, false)
-	if r == nil {
+	ent := m.entry(name, env, false)
+	if ent == nil {
 		return err.ResourceDoesNotExist
 	}
+	defer ent.lock.Unlock()
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	ent.reservations = nil
+	touch(ent.resource)
+
+	return nil
+}
+
+func (m *Memory) ClaimIdempotencyKey(key string, ttl time.Duration) (bool, error) {
+	m.keysLock.Lock()
+	defer m.keysLock.Unlock()
 
-	filtered := []*models.Reservation{}
-	for _, res := range m.Reservations {
-		if res.Resource.Key() != r.Key() {
-			filtered = append(filtered, res)
+	now := time.Now()
+	for k, expires := range m.keys {
+		if !expires.After(now) {
+			delete(m.keys, k)
 		}
 	}
-	m.Reservations = filtered
-	r.LastActivity = time.Now()
 
+	if _, ok := m.keys[key]; ok {
+		return false, nil
+	}
+	m.keys[key] = now.Add(ttl)
+
+	return true, nil
+}
+
+func (m *Memory) ReleaseIdempotencyKey(key string) error {
+	m.keysLock.Lock()
+	defer m.keysLock.Unlock()
+
+	delete(m.keys, key)
 	return nil
 }
 
-func (m *Memory) PruneInactiveResources(hours int) error {
-	resources := m.GetResources()
-	oldestTime := time.Now().Add(-time.Duration(hours) * time.Hour)
+func (m *Memory) AddUsage(u *models.Usage) error {
+	m.usageLock.Lock()
+	defer m.usageLock.Unlock()
 
-	for _, r := range resources {
-		q, err := m.GetQueueForResource(r.Name, r.Env)
-		if err != nil {
-			log.Errorf("%+v", err)
+	month := m.usage[u.Month]
+	if month == nil {
+		month = map[string]*models.Usage{}
+		m.usage[u.Month] = month
+	}
+	key := u.UserID + " " + u.Resource
+	if month[key] == nil {
+		month[key] = &models.Usage{
+			Month:    u.Month,
+			UserID:   u.UserID,
+			Resource: u.Resource,
 		}
-		if q.HasReservations() {
+	}
+	month[key].Hours += u.Hours
+	month[key].Cost += u.Cost
+
+	return nil
+}
+
+func (m *Memory) GetUsage(month string) ([]*models.Usage, error) {
+	m.usageLock.Lock()
+	defer m.usageLock.Unlock()
+
+	keys := []string{}
+	for k := range m.usage[month] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := []*models.Usage{}
+	for _, k := range keys {
+		u := *m.usage[month][k]
+		ret = append(ret, &u)
+	}
+	return ret, nil
+}
+
+func (m *Memory) AddContention(c *models.Contention) error {
+	m.contentionLock.Lock()
+	defer m.contentionLock.Unlock()
+
+	key := contentionField(c)
+	if m.contention[key] == nil {
+		m.contention[key] = &models.Contention{
+			Resource: c.Resource,
+			Weekday:  c.Weekday,
+			Hour:     c.Hour,
+		}
+	}
+	m.contention[key].Sampled += c.Sampled
+	m.contention[key].Contended += c.Contended
+	m.contention[key].Waiting += c.Waiting
+
+	return nil
+}
+
+func (m *Memory) GetContention() ([]*models.Contention, error) {
+	m.contentionLock.Lock()
+	defer m.contentionLock.Unlock()
+
+	keys := []string{}
+	for k := range m.contention {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := []*models.Contention{}
+	for _, k := range keys {
+		c := *m.contention[k]
+		ret = append(ret, &c)
+	}
+	return ret, nil
+}
+
+func (m *Memory) AddHold(name, env string, h *models.Hold) error {
+	m.holdsLock.Lock()
+	defer m.holdsLock.Unlock()
+
+	key := models.ResourceKey(name, env)
+	oldest := time.Now().Add(-models.HoldHistory)
+	holds := []*models.Hold{}
+	for _, old := range m.holds[key] {
+		if old.End.After(oldest) {
+			holds = append(holds, old)
+		}
+	}
+	c := *h
+	holds = append(holds, &c)
+	sort.SliceStable(holds, func(i, j int) bool {
+		return holds[i].End.Before(holds[j].End)
+	})
+	m.holds[key] = holds
+
+	return nil
+}
+
+func (m *Memory) GetHolds(name, env string, since time.Time) ([]*models.Hold, error) {
+	m.holdsLock.Lock()
+	defer m.holdsLock.Unlock()
+
+	ret := []*models.Hold{}
+	for _, h := range m.holds[models.ResourceKey(name, env)] {
+		if h.End.After(since) {
+			c := *h
+			ret = append(ret, &c)
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memory) GetUserToken(userID string) (string, error) {
+	m.tokensLock.Lock()
+	defer m.tokensLock.Unlock()
+
+	return m.tokens[userID], nil
+}
+
+func (m *Memory) SetUserToken(userID, token string) error {
+	m.tokensLock.Lock()
+	defer m.tokensLock.Unlock()
+
+	if token == "" {
+		delete(m.tokens, userID)
+		return nil
+	}
+	m.tokens[userID] = token
+	return nil
+}
+
+func (m *Memory) GetDefaultEnv(userID string) (string, error) {
+	m.envsLock.Lock()
+	defer m.envsLock.Unlock()
+
+	return m.envs[userID], nil
+}
+
+func (m *Memory) SetDefaultEnv(userID, env string) error {
+	m.envsLock.Lock()
+	defer m.envsLock.Unlock()
+
+	if env == "" {
+		delete(m.envs, userID)
+		return nil
+	}
+	m.envs[userID] = env
+	return nil
+}
+
+func (m *Memory) GetStatusFilter(userID string) (string, error) {
+	m.filtersLock.Lock()
+	defer m.filtersLock.Unlock()
+
+	return m.filters[userID], nil
+}
+
+func (m *Memory) SetStatusFilter(userID, filter string) error {
+	m.filtersLock.Lock()
+	defer m.filtersLock.Unlock()
+
+	if filter == "" {
+		delete(m.filters, userID)
+		return nil
+	}
+	m.filters[userID] = filter
+	return nil
+}
+
+func (m *Memory) GetMilestonesOff(userID string) (bool, error) {
+	m.quietLock.Lock()
+	defer m.quietLock.Unlock()
+
+	return m.quiet[userID], nil
+}
+
+func (m *Memory) SetMilestonesOff(userID string, off bool) error {
+	m.quietLock.Lock()
+	defer m.quietLock.Unlock()
+
+	if !off {
+		delete(m.quiet, userID)
+		return nil
+	}
+	m.quiet[userID] = true
+	return nil
+}
+
+func (m *Memory) GetServiceAccounts() ([]*models.ServiceAccount, error) {
+	m.accountsLock.Lock()
+	defer m.accountsLock.Unlock()
+
+	ret := []*models.ServiceAccount{}
+	for _, a := range m.accounts {
+		c := *a
+		ret = append(ret, &c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+func (m *Memory) SaveServiceAccount(a *models.ServiceAccount) error {
+	m.accountsLock.Lock()
+	defer m.accountsLock.Unlock()
+
+	c := *a
+	m.accounts[a.Name] = &c
+	return nil
+}
+
+func (m *Memory) DeleteServiceAccount(name string) error {
+	m.accountsLock.Lock()
+	defer m.accountsLock.Unlock()
+
+	if _, ok := m.accounts[name]; !ok {
+		return err.ServiceAccountDoesNotExist
+	}
+	delete(m.accounts, name)
+	return nil
+}
+
+func (m *Memory) GetAPIKeys() ([]*models.APIKey, error) {
+	m.apiKeysLock.Lock()
+	defer m.apiKeysLock.Unlock()
+
+	ret := []*models.APIKey{}
+	for _, k := range m.apiKeys {
+		c := *k
+		ret = append(ret, &c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret, nil
+}
+
+func (m *Memory) SaveAPIKey(k *models.APIKey) error {
+	m.apiKeysLock.Lock()
+	defer m.apiKeysLock.Unlock()
+
+	c := *k
+	m.apiKeys[k.ID] = &c
+	return nil
+}
+
+func (m *Memory) DeleteAPIKey(id string) error {
+	m.apiKeysLock.Lock()
+	defer m.apiKeysLock.Unlock()
+
+	if _, ok := m.apiKeys[id]; !ok {
+		return err.APIKeyDoesNotExist
+	}
+	delete(m.apiKeys, id)
+	return nil
+}
+
+func (m *Memory) GetLiveStatuses() ([]*models.LiveStatus, error) {
+	m.liveLock.Lock()
+	defer m.liveLock.Unlock()
+
+	ret := []*models.LiveStatus{}
+	for _, s := range m.live {
+		c := *s
+		ret = append(ret, &c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Channel < ret[j].Channel
+	})
+	return ret, nil
+}
+
+func (m *Memory) SaveLiveStatus(s *models.LiveStatus) error {
+	m.liveLock.Lock()
+	defer m.liveLock.Unlock()
+
+	c := *s
+	m.live[s.Channel] = &c
+	return nil
+}
+
+func (m *Memory) DeleteLiveStatus(channel string) error {
+	m.liveLock.Lock()
+	defer m.liveLock.Unlock()
+
+	delete(m.live, channel)
+	return nil
+}
+
+func (m *Memory) BufferNotification(n *models.BufferedNotification, limit int) (int, error) {
+	m.bufferedLock.Lock()
+	defer m.bufferedLock.Unlock()
+
+	c := *n
+	m.buffered = append(m.buffered, &c)
+	dropped := 0
+	if len(m.buffered) > limit {
+		dropped = len(m.buffered) - limit
+		m.buffered = m.buffered[dropped:]
+	}
+	return dropped, nil
+}
+
+func (m *Memory) TakeBufferedNotifications() ([]*models.BufferedNotification, error) {
+	m.bufferedLock.Lock()
+	defer m.bufferedLock.Unlock()
+
+	ret := m.buffered
+	if ret == nil {
+		ret = []*models.BufferedNotification{}
+	}
+	m.buffered = nil
+	return ret, nil
+}
+
+func (m *Memory) GetScheduledActions() ([]*models.ScheduledAction, error) {
+	m.scheduledLock.Lock()
+	defer m.scheduledLock.Unlock()
+
+	ret := []*models.ScheduledAction{}
+	for _, a := range m.scheduled {
+		c := *a
+		c.Resources = append([]string{}, a.Resources...)
+		ret = append(ret, &c)
+	}
+	sortScheduledActions(ret)
+	return ret, nil
+}
+
+func (m *Memory) SaveScheduledAction(a *models.ScheduledAction) error {
+	m.scheduledLock.Lock()
+	defer m.scheduledLock.Unlock()
+
+	c := *a
+	c.Resources = append([]string{}, a.Resources...)
+	m.scheduled[a.ID] = &c
+	return nil
+}
+
+func (m *Memory) DeleteScheduledAction(id string) error {
+	m.scheduledLock.Lock()
+	defer m.scheduledLock.Unlock()
+
+	if _, ok := m.scheduled[id]; !ok {
+		return err.ScheduledActionDoesNotExist
+	}
+	delete(m.scheduled, id)
+	return nil
+}
+
+func (m *Memory) GetSnapshot(name, env string) (*models.Snapshot, error) {
+	m.snapshotsLock.Lock()
+	defer m.snapshotsLock.Unlock()
+
+	return m.snapshots[models.ResourceKey(name, env)], nil
+}
+
+func (m *Memory) SaveSnapshot(name, env string, s *models.Snapshot) error {
+	m.snapshotsLock.Lock()
+	defer m.snapshotsLock.Unlock()
+
+	m.snapshots[models.ResourceKey(name, env)] = s
+	return nil
+}
+
+func (m *Memory) ForgetUser(userID string) (*models.Forgotten, error) {
+	ret := &models.Forgotten{}
+
+	m.holdsLock.Lock()
+	for key, holds := range m.holds {
+		kept := []*models.Hold{}
+		for _, h := range holds {
+			if h.User != nil && h.User.ID == userID {
+				ret.Holds++
+				continue
+			}
+			kept = append(kept, h)
+		}
+		m.holds[key] = kept
+	}
+	m.holdsLock.Unlock()
+
+	m.usageLock.Lock()
+	for _, month := range m.usage {
+		for key, u := range month {
+			if u.UserID == userID {
+				delete(month, key)
+				ret.Usage++
+			}
+		}
+	}
+	m.usageLock.Unlock()
+
+	m.snapshotsLock.Lock()
+	for key, s := range m.snapshots {
+		if s.Forget(userID) {
+			m.snapshots[key] = s
+			ret.Snapshots++
+		}
+	}
+	m.snapshotsLock.Unlock()
+
+	m.scheduledLock.Lock()
+	for id, a := range m.scheduled {
+		if a.UserID == userID {
+			delete(m.scheduled, id)
+			ret.Scheduled++
+		}
+	}
+	m.scheduledLock.Unlock()
+
+	m.bufferedLock.Lock()
+	buffered := []*models.BufferedNotification{}
+	for _, n := range m.buffered {
+		if n.UserID == userID {
+			ret.Notifications++
 			continue
 		}
-		if r.LastActivity.Before(oldestTime) {
-			err := m.RemoveResource(r.Name, r.Env)
-			if err != nil {
-				log.Errorf("%+v", err)
+		buffered = append(buffered, n)
+	}
+	m.buffered = buffered
+	m.bufferedLock.Unlock()
+
+	m.envsLock.Lock()
+	_, ret.DefaultEnv = m.envs[userID]
+	delete(m.envs, userID)
+	m.envsLock.Unlock()
+
+	m.filtersLock.Lock()
+	_, ret.StatusFilter = m.filters[userID]
+	delete(m.filters, userID)
+	m.filtersLock.Unlock()
+
+	m.quietLock.Lock()
+	ret.MilestonesOff = m.quiet[userID]
+	delete(m.quiet, userID)
+	m.quietLock.Unlock()
+
+	m.tokensLock.Lock()
+	_, ret.Token = m.tokens[userID]
+	delete(m.tokens, userID)
+	m.tokensLock.Unlock()
+
+	return ret, nil
+}
+
+func (m *Memory) Compact(before time.Time) (*models.Compaction, error) {
+	ret := &models.Compaction{}
+
+	m.holdsLock.Lock()
+	for key, holds := range m.holds {
+		kept := []*models.Hold{}
+		for _, h := range holds {
+			if h.End.Before(before) {
+				ret.Holds++
+				continue
+			}
+			kept = append(kept, h)
+		}
+		if len(kept) == 0 {
+			delete(m.holds, key)
+		} else {
+			m.holds[key] = kept
+		}
+	}
+	m.holdsLock.Unlock()
+
+	month := before.Format(models.UsageMonthFormat)
+	m.usageLock.Lock()
+	for key, usage := range m.usage {
+		if key < month {
+			ret.Usage += len(usage)
+			delete(m.usage, key)
+		}
+	}
+	m.usageLock.Unlock()
+
+	m.snapshotsLock.Lock()
+	for key, s := range m.snapshots {
+		if s.Taken.Before(before) {
+			delete(m.snapshots, key)
+			ret.Snapshots++
+		}
+	}
+	m.snapshotsLock.Unlock()
+
+	return ret, nil
+}
+
+func (m *Memory) Dedupe() ([]*models.Repair, error) {
+	ret := []*models.Repair{}
+	for _, ent := range m.sortedEntries(nil) {
+		ent.lock.Lock()
+		if !ent.removed {
+			kept, repairs := dedupe(ent.reservations, ent.resource.String())
+			if len(repairs) > 0 {
+				reservations := []*models.Reservation{}
+				for _, i := range kept {
+					reservations = append(reservations, ent.reservations[i])
+				}
+				ent.reservations = reservations
+				ret = append(ret, repairs...)
 			}
 		}
+		ent.lock.Unlock()
 	}
+	return ret, nil
+}
+
+// PruneInactiveResources removes resources with empty queues that have had no activity in the given
+// number of hours
+func (m *Memory) PruneInactiveResources(hours int) error {
+	oldestTime := time.Now().Add(-time.Duration(hours) * time.Hour)
+
+	m.remove(m.sortedEntries(nil), func(ent *memoryEntry) bool {
+		return len(ent.reservations) == 0 && ent.resource.LastActivity.Before(oldestTime)
+	})
 	return nil
 }
+
+// The following must be called with the entry locked
+
+// find returns the index of a user's reservation in the queue, or -1
+func (ent *memoryEntry) find(u *models.User) int {
+	for i, res := range ent.reservations {
+		if res.User.ID == u.ID {
+			return i
+		}
+	}
+	return -1
+}
+
+// reservation returns a copy of the reservation at an index, referring to the given resource
+func (ent *memoryEntry) reservation(idx int, r *models.Resource) *models.Reservation {
+	c := *ent.reservations[idx]
+	c.Resource = r
+	return &c
+}
+
+// queue returns a copy of the entry's resource and queue
+func (ent *memoryEntry) queue() *models.Queue {
+	q := &models.Queue{
+		Resource: ent.resource.Copy(),
+	}
+	for i := range ent.reservations {
+		q.Reservations = append(q.Reservations, ent.reservation(i, q.Resource))
+	}
+	return q
+}
+
+// touch records activity on a stored resource. Activity is not a setting, so the version is unchanged.
+// Does not implement lock
+func touch(r *models.Resource) {
+	r.LastActivity = time.Now()
+}
@@ -0,0 +1,17 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/data/managertest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	err := managertest.Check(func() (data.Manager, error) {
+		return data.NewMemory(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
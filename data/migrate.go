@@ -0,0 +1,248 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+// Before resources and queues had keys of their own, every resource was kept in one JSON blob and every
+// reservation, for all resources, in another. Instances of the bot from before then may still be running
+// during a rolling deploy, writing to the blobs.
+const (
+	legacyReservationsKey string = "reservebot-reservations"
+	legacyResourcesKey    string = "reservebot-resources"
+)
+
+type legacyReservations struct {
+	Reservations []*models.Reservation `json:"reservations"`
+}
+
+type legacyResources struct {
+	Resources map[string]*models.Resource `json:"resources"`
+}
+
+// errLegacyChanged is returned when the legacy blobs are written to while they are being migrated
+var errLegacyChanged = errors.New("legacy data changed while it was migrated")
+
+// MigrateLegacy moves resources and reservations from the legacy blobs into the per-resource keys. It is
+// run as the bot starts, so that no one has to take the bot down to migrate, and copes with older
+// instances still writing to the blobs. Resources that already have a key of their own are kept as they
+// are, and reservations are added to the end of their queue unless the user is already in it, so
+// migrating again changes nothing. The blobs are only deleted once every resource and reservation in
+// them is found in the new keys, and only if nothing wrote to them in the meantime; otherwise the
+// migration starts over. Nil is returned if there was nothing to migrate.
+func (m *Redis) MigrateLegacy() (*models.Migration, error) {
+	for i := 0; i < maxTxRetries; i++ {
+		ret, err := m.migrateLegacy()
+		if err != errLegacyChanged {
+			return ret, err
+		}
+		log.Warnf("Legacy data changed while it was migrated, starting over")
+	}
+	return nil, e.Conflict
+}
+
+func (m *Redis) migrateLegacy() (*models.Migration, error) {
+	rawResources, err := m.getLegacy(legacyResourcesKey)
+	if err != nil {
+		return nil, err
+	}
+	rawReservations, err := m.getLegacy(legacyReservationsKey)
+	if err != nil {
+		return nil, err
+	}
+	if rawResources == "" && rawReservations == "" {
+		return nil, nil
+	}
+
+	resources := &legacyResources{}
+	if rawResources != "" {
+		if err := m.decode(legacyResourcesKey, rawResources, resources); err != nil {
+			return nil, fmt.Errorf("invalid legacy resources: %v", err)
+		}
+	}
+	reservations := &legacyReservations{}
+	if rawReservations != "" {
+		if err := m.decode(legacyReservationsKey, rawReservations, reservations); err != nil {
+			return nil, fmt.Errorf("invalid legacy reservations: %v", err)
+		}
+	}
+
+	ret := &models.Migration{}
+	for _, r := range resources.Resources {
+		if err := m.migrateResource(r); err != nil {
+			return nil, err
+		}
+		ret.Resources++
+	}
+	for _, res := range reservations.Reservations {
+		if res.Resource == nil || res.User == nil {
+			ret.Skipped++
+			continue
+		}
+		if err := m.migrateReservation(res); err != nil {
+			return nil, err
+		}
+		ret.Reservations++
+	}
+
+	if err := m.verifyMigration(resources, reservations); err != nil {
+		return nil, err
+	}
+
+	// the blobs are deleted only if they still hold what was migrated
+	err = m.transaction(func(tx *redis.Tx) error {
+		for key, raw := range map[string]string{legacyResourcesKey: rawResources, legacyReservationsKey: rawReservations} {
+			str, err := m.getLegacyFrom(tx, key)
+			if err != nil {
+				return err
+			}
+			if str != raw {
+				return errLegacyChanged
+			}
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, legacyResourcesKey, legacyReservationsKey)
+			return nil
+		})
+		return err
+	}, legacyResourcesKey, legacyReservationsKey)
+	if err == e.Conflict {
+		return nil, errLegacyChanged
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// getLegacy reads a legacy blob, returning an empty string if it doesn't exist
+func (m *Redis) getLegacy(key string) (string, error) {
+	return m.getLegacyFrom(m.rdb, key)
+}
+
+func (m *Redis) getLegacyFrom(c redis.Cmdable, key string) (string, error) {
+	str, err := c.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return str, err
+}
+
+// migrateResource gives a legacy resource a key of its own, unless it already has one
+func (m *Redis) migrateResource(r *models.Resource) error {
+	l := m.locks.get(r.Key())
+	l.Lock()
+	defer l.Unlock()
+
+	str, err := m.encode(hashField(resourcesKey, r.Key()), r)
+	if err != nil {
+		return err
+	}
+	_, err = m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSetNX(ctx, resourcesKey, r.Key(), str)
+		return pipe.Incr(ctx, versionKey(r.Key())).Err()
+	})
+	return err
+}
+
+// migrateReservation adds a legacy reservation to the end of its resource's queue, unless the user is
+// already in it. The resource is created if it doesn't exist.
+func (m *Redis) migrateReservation(res *models.Reservation) error {
+	key := res.Resource.Key()
+	l := m.locks.get(key)
+	l.Lock()
+	defer l.Unlock()
+
+	return m.transaction(func(tx *redis.Tx) error {
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+		reservations, _, err := m.getQueue(tx, key)
+		if err != nil {
+			return err
+		}
+		for _, q := range reservations {
+			if q.User.ID == res.User.ID {
+				return nil
+			}
+		}
+
+		c := *res
+		if c.ID == "" {
+			c.ID = models.NewID()
+		}
+		if c.Joined.IsZero() {
+			c.Joined = c.Time
+		}
+		if r != nil {
+			c.Resource = r
+		}
+		str, err := m.marshalReservation(key, &c)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if r == nil {
+				if err := m.setResource(pipe, &models.Resource{Name: res.Resource.Name, Env: res.Resource.Env}); err != nil {
+					return err
+				}
+			}
+			pipe.RPush(ctx, queueKey(key), str)
+			return nil
+		})
+		return err
+	}, versionKey(key), queueKey(key))
+}
+
+// verifyMigration checks that every legacy resource has a key of its own and that every legacy
+// reservation is in its resource's queue
+func (m *Redis) verifyMigration(resources *legacyResources, reservations *legacyReservations) error {
+	migrated, err := m.getAllResources(m.rdb)
+	if err != nil {
+		return err
+	}
+	missing := 0
+	for _, r := range resources.Resources {
+		if migrated[r.Key()] == nil {
+			missing++
+		}
+	}
+
+	queues := map[string][]*models.Reservation{}
+	for _, res := range reservations.Reservations {
+		if res.Resource == nil || res.User == nil {
+			continue
+		}
+		key := res.Resource.Key()
+		if _, ok := queues[key]; !ok {
+			q, _, err := m.getQueue(m.rdb, key)
+			if err != nil {
+				return err
+			}
+			queues[key] = q
+		}
+		found := false
+		for _, q := range queues[key] {
+			if q.User.ID == res.User.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing++
+		}
+	}
+
+	if missing > 0 {
+		return fmt.Errorf("%d legacy resources and reservations weren't found after migrating them, so the legacy data was kept", missing)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package data
+
+import (
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReadOnly wraps a Manager so that nothing is changed. Reads are passed through, while each change is
+// logged as what would have been done and reported as succeeding. It lets a new instance be tried
+// against production data without affecting it.
+type ReadOnly struct {
+	Manager
+}
+
+func NewReadOnly(m Manager) *ReadOnly {
+	return &ReadOnly{
+		Manager: m,
+	}
+}
+
+func (m *ReadOnly) would(format string, args ...interface{}) {
+	log.Infof("Read-only: would "+format, args...)
+}
+
+func (m *ReadOnly) ClaimIdempotencyKey(key string, ttl time.Duration) (bool, error) {
+	// nothing is changed, so handling a request twice is harmless
+	return true, nil
+}
+
+func (m *ReadOnly) ReleaseIdempotencyKey(key string) error {
+	return nil
+}
+
+func (m *ReadOnly) AddUsage(u *models.Usage) error {
+	m.would("add %.2fh of %s by %s to the usage for %s", u.Hours, u.Resource, u.UserID, u.Month)
+	return nil
+}
+
+// AddContention records nothing, without logging as it is sampled regularly rather than requested
+func (m *ReadOnly) AddContention(c *models.Contention) error {
+	return nil
+}
+
+func (m *ReadOnly) AddHold(name, env string, h *models.Hold) error {
+	m.would("record the hold of %s|%s by %s", env, name, h.User.ID)
+	return nil
+}
+
+func (m *ReadOnly) Create(name, env string) error {
+	m.would("create %s|%s", env, name)
+	return nil
+}
+
+// GetResource never creates the resource, as that would change the data
+func (m *ReadOnly) GetResource(name, env string, create bool) *models.Resource {
+	if r := m.Manager.GetResource(name, env, false); r != nil || !create {
+		return r
+	}
+	m.would("create %s|%s", env, name)
+	return &models.Resource{
+		Name:         name,
+		Env:          env,
+		LastActivity: time.Now(),
+	}
+}
+
+func (m *ReadOnly) Promote(u *models.User, name, env string) error {
+	m.would("promote %s to the front of the queue for %s|%s", u.Name, env, name)
+	return nil
+}
+
+func (m *ReadOnly) Remove(u *models.User, name, env string) error {
+	m.would("remove %s from %s|%s", u.Name, env, name)
+	return nil
+}
+
+func (m *ReadOnly) RemoveEnv(name, env string) error {
+	m.would("remove %s from %s", name, env)
+	return nil
+}
+
+func (m *ReadOnly) RemoveResource(name, env string) error {
+	m.would("remove the resource %s|%s", env, name)
+	return nil
+}
+
+func (m *ReadOnly) Requeue(u *models.User, name, env string) error {
+	m.would("move %s to the back of the queue for %s|%s", u.Name, env, name)
+	return nil
+}
+
+func (m *ReadOnly) Transfer(from, to *models.User, name, env string) error {
+	m.would("hand %s's place in the queue for %s|%s to %s", from.Name, env, name, to.Name)
+	return nil
+}
+
+func (m *ReadOnly) TouchResource(name, env string) error {
+	return nil
+}
+
+func (m *ReadOnly) Reserve(u *models.User, name, env string) error {
+	m.would("reserve %s|%s for %s", env, name, u.Name)
+	return nil
+}
+
+func (m *ReadOnly) UpdateReservation(res *models.Reservation) error {
+	m.would("update %s's reservation of %s", res.User.Name, res.Resource)
+	return nil
+}
+
+func (m *ReadOnly) UpdateResource(r *models.Resource) error {
+	m.would("update the resource %s", r)
+	return nil
+}
+
+func (m *ReadOnly) SetUserToken(userID, token string) error {
+	if token == "" {
+		m.would("forget the Slack token of %s", userID)
+		return nil
+	}
+	m.would("store a Slack token for %s", userID)
+	return nil
+}
+
+func (m *ReadOnly) SetDefaultEnv(userID, env string) error {
+	if env == "" {
+		m.would("forget the default env of %s", userID)
+		return nil
+	}
+	m.would("set the default env of %s to %s", userID, env)
+	return nil
+}
+
+func (m *ReadOnly) SetStatusFilter(userID, filter string) error {
+	if filter == "" {
+		m.would("forget the status filter of %s", userID)
+		return nil
+	}
+	m.would("set the status filter of %s to %s", userID, filter)
+	return nil
+}
+
+func (m *ReadOnly) SetMilestonesOff(userID string, off bool) error {
+	if !off {
+		m.would("tell %s about milestones in line", userID)
+		return nil
+	}
+	m.would("stop telling %s about milestones in line", userID)
+	return nil
+}
+
+func (m *ReadOnly) SaveServiceAccount(a *models.ServiceAccount) error {
+	m.would("save the service account %s", a.Name)
+	return nil
+}
+
+func (m *ReadOnly) DeleteServiceAccount(name string) error {
+	m.would("delete the service account %s", name)
+	return nil
+}
+
+func (m *ReadOnly) SaveAPIKey(k *models.APIKey) error {
+	m.would("save the API key %s", k.ID)
+	return nil
+}
+
+func (m *ReadOnly) DeleteAPIKey(id string) error {
+	m.would("revoke the API key %s", id)
+	return nil
+}
+
+func (m *ReadOnly) SaveScheduledAction(a *models.ScheduledAction) error {
+	m.would("schedule `%s` for %s at %s", a.Text, a.UserID, a.At)
+	return nil
+}
+
+func (m *ReadOnly) DeleteScheduledAction(id string) error {
+	m.would("delete the scheduled command %s", id)
+	return nil
+}
+
+func (m *ReadOnly) BufferNotification(n *models.BufferedNotification, limit int) (int, error) {
+	m.would("buffer a DM to %s", n.UserID)
+	return 0, nil
+}
+
+func (m *ReadOnly) TakeBufferedNotifications() ([]*models.BufferedNotification, error) {
+	// the buffer is left for the instance that filled it to deliver
+	return []*models.BufferedNotification{}, nil
+}
+
+func (m *ReadOnly) SaveLiveStatus(s *models.LiveStatus) error {
+	m.would("save the live status message in %s", s.Channel)
+	return nil
+}
+
+func (m *ReadOnly) DeleteLiveStatus(channel string) error {
+	m.would("forget the live status message in %s", channel)
+	return nil
+}
+
+func (m *ReadOnly) SaveSnapshot(name, env string, s *models.Snapshot) error {
+	m.would("snapshot the queue for %s|%s", env, name)
+	return nil
+}
+
+func (m *ReadOnly) ForgetUser(userID string) (*models.Forgotten, error) {
+	m.would("forget %s", userID)
+	return &models.Forgotten{}, nil
+}
+
+func (m *ReadOnly) ClearQueueForResource(name, env string) error {
+	m.would("clear the queue for %s|%s", env, name)
+	return nil
+}
+
+func (m *ReadOnly) Compact(before time.Time) (*models.Compaction, error) {
+	m.would("delete history from before %s", before.Format(time.RFC3339))
+	return &models.Compaction{}, nil
+}
+
+func (m *ReadOnly) Dedupe() ([]*models.Repair, error) {
+	m.would("collapse duplicate queue entries")
+	return []*models.Repair{}, nil
+}
+
+func (m *ReadOnly) PruneInactiveResources(hours int) error {
+	m.would("prune resources inactive for %d hours", hours)
+	return nil
+}
@@ -3,32 +3,74 @@ package data
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"sort"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/ameliagapin/reservebot/err"
+	e "github.com/ameliagapin/reservebot/err"
 	"github.com/ameliagapin/reservebot/models"
 	"github.com/redis/go-redis/v9"
 	log "github.com/sirupsen/logrus"
 )
 
+// Resources are stored as JSON in a single hash keyed by resource key. Each resource's queue is stored
+// as a list of JSON reservations under its own key, so a write only touches the resource it changes.
+// Last activity is kept in its own hash so that recording it doesn't rewrite the resource. Every write
+// to a resource or its activity also bumps a version kept under its own key, which transactions watch
+// instead of the shared hashes, so that writes to one resource don't abort those on another.
 const (
-	reservationsKey string = "reservebot-reservations"
-	resourcesKey    string = "reservebot-resources"
+	resourcesKey     string = "reservebot:resources"
+	activityKey      string = "reservebot:activity"
+	queueKeyPrefix   string = "reservebot:queue:"
+	versionKeyPrefix string = "reservebot:version:"
+
+	idempotencyKeyPrefix string = "reservebot:idempotency:"
+	// usage is kept in two hashes per month, for hours and cost, whose fields are the user ID and
+	// resource separated by a space
+	usageKeyPrefix string = "reservebot:usage:"
+	// contention is kept in three hashes, for hours sampled, contended and waiting, whose fields are the
+	// weekday, hour and resource separated by spaces
+	contentionKey string = "reservebot:contention"
+	// tokens are kept in a hash whose fields are user IDs
+	userTokensKey string = "reservebot:user_tokens"
+	// default envs are kept in a hash whose fields are user IDs
+	defaultEnvsKey string = "reservebot:default_envs"
+	// saved status filters are kept in a hash whose fields are user IDs
+	statusFiltersKey string = "reservebot:status_filters"
+	// users who chose not to be told about milestones in line are kept in a set of their IDs
+	milestonesOffKey string = "reservebot:milestones_off"
+	// snapshots are kept as JSON in a hash whose fields are resource keys
+	snapshotsKey string = "reservebot:snapshots"
+	// service accounts are kept as JSON in a hash whose fields are their names
+	serviceAccountsKey string = "reservebot:service_accounts"
+	// API keys are kept as JSON in a hash whose fields are their IDs
+	apiKeysKey string = "reservebot:api_keys"
+	// live status messages are kept as JSON in a hash whose fields are channel IDs
+	liveStatusKey string = "reservebot:live_status"
+	// scheduled commands are kept as JSON in a hash whose fields are their IDs
+	scheduledKey string = "reservebot:scheduled"
+	// DMs waiting to be delivered are kept as JSON in a list, oldest first
+	bufferedKey string = "reservebot:buffered_notifications"
+	// holds are kept as JSON in a sorted set per resource, scored by when they ended in milliseconds
+	holdsKeyPrefix string = "reservebot:holds:"
+
+	maxTxRetries = 5
 )
 
-type RedisReservations struct {
-	Reservations []*models.Reservation `json:"reservations"`
-}
-
-type RedisResources struct {
-	Resources map[string]*models.Resource `json:"resources"`
-}
+var ctx = context.Background()
 
+// Redis relies on transactions for consistency between clients. Within a process, writes to the same
+// resource are also serialized with a per-key lock so they don't keep invalidating each other's
+// transactions; reads take no locks.
 type Redis struct {
-	rdb  *redis.Client
-	lock sync.Mutex
+	rdb   *redis.Client
+	locks keyLocks
+	// sealer encrypts payloads, if encryption is enabled
+	sealer *Sealer
+	// replica serves reads of status and queues, if one was given
+	replica *replica
 }
 
 func NewRedis(addr, pass string, db int) *Redis {
@@ -45,407 +87,929 @@ func NewRedis(addr, pass string, db int) *Redis {
 	return r
 }
 
-func (m *Redis) Create(name, env string) error {
-	// GetResource creates the resource if it doesn't exist
-	r := m.GetResource(name, env, true)
-	r.LastActivity = time.Now()
+// Encrypt encrypts every payload written from now on with a sealer. Payloads written before are still
+// read, and are encrypted once they are next written.
+func (m *Redis) Encrypt(s *Sealer) {
+	m.sealer = s
+}
 
-	return nil
+// encode serializes a payload for storing in a field, encrypting it if encryption is enabled
+func (m *Redis) encode(field string, v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return m.seal(field, b)
 }
 
-func (m *Redis) Reserve(u *models.User, name, env string) error {
-	r := m.GetResource(name, env, true)
+// decode deserializes a payload stored in a field, decrypting it if it was encrypted
+func (m *Redis) decode(field, str string, v interface{}) error {
+	b, err := m.open(field, str)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	reservations := m.GetRedisReservations()
-	// check for existing reservation
-	for _, res := range reservations {
-		if res.User.ID == u.ID {
-			if res.Resource.Key() == r.Key() {
-				return err.AlreadyInQueue
-			}
-		}
+// seal encrypts a value for storing in a field if encryption is enabled
+func (m *Redis) seal(field string, b []byte) (string, error) {
+	if m.sealer == nil {
+		return string(b), nil
 	}
+	return m.sealer.Seal(b, field)
+}
 
-	res := &models.Reservation{
-		User:     u,
-		Resource: r,
-		Time:     time.Now(),
+// open decrypts a value stored in a field if it was encrypted
+func (m *Redis) open(field, str string) ([]byte, error) {
+	if !sealed(str) {
+		return []byte(str), nil
 	}
+	if m.sealer == nil {
+		return nil, errors.New("stored data is encrypted, but no encryption key was given")
+	}
+	return m.sealer.Open(str, field)
+}
 
-	reservations = append(reservations, res)
-	r.LastActivity = time.Now()
+// hashField names a field of a hash, which encrypted payloads are bound to. Entries of lists and sorted
+// sets are bound to the key of the list.
+func hashField(key, field string) string {
+	return key + " " + field
+}
 
-	m.SetRedisReservations(reservations)
-	return nil
+func queueKey(key string) string {
+	return queueKeyPrefix + key
 }
-func (m *Redis) GetRedisReservations() []*models.Reservation {
-	res := &RedisReservations{}
 
-	str, err := m.rdb.Get(context.Background(), reservationsKey).Result()
-	if err != nil {
-		m.SetRedisReservations([]*models.Reservation{})
+func versionKey(key string) string {
+	return versionKeyPrefix + key
+}
 
-		str, err = m.rdb.Get(context.Background(), reservationsKey).Result()
-		if err != nil {
-			panic(err)
+// transaction runs fn with the given keys watched. If another client modifies a watched key before fn
+// commits its writes, fn is retried from the beginning so that it acts on fresh data.
+func (m *Redis) transaction(fn func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < maxTxRetries; i++ {
+		err := m.rdb.Watch(ctx, fn, keys...)
+		if err != redis.TxFailedErr {
+			m.replica.markWrote()
+			return err
 		}
 	}
-	if err := json.Unmarshal([]byte(str), res); err != nil {
-		panic(err)
-	}
-	return res.Reservations
+	return e.Conflict
 }
 
-func (m *Redis) SetRedisReservations(res []*models.Reservation) []*models.Reservation {
-	reservations := &RedisReservations{
-		Reservations: res,
+// getResource reads a single resource. It returns nil if the resource does not exist.
+func (m *Redis) getResource(c redis.Cmdable, key string) (*models.Resource, error) {
+	str, err := c.HGet(ctx, resourcesKey, key).Result()
+	if err == redis.Nil {
+		return nil, nil
 	}
-
-	b, err := json.Marshal(reservations)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	if err := m.rdb.Set(context.Background(), reservationsKey, string(b), 0).Err(); err != nil {
-		panic(err)
+	r := &models.Resource{}
+	if err := m.decode(hashField(resourcesKey, key), str, r); err != nil {
+		return nil, err
 	}
-	if reservations.Reservations == nil {
-		reservations.Reservations = make([]*models.Reservation, 0)
+
+	activity, err := c.HGet(ctx, activityKey, key).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
 	}
-	return reservations.Reservations
+	setActivity(r, activity)
+
+	return r, nil
 }
 
-func (m *Redis) GetRedisResources() map[string]*models.Resource {
-	res := &RedisResources{}
-	str, err := m.rdb.Get(context.Background(), resourcesKey).Result()
+func (m *Redis) setResource(c redis.Cmdable, r *models.Resource) error {
+	str, err := m.encode(hashField(resourcesKey, r.Key()), r)
 	if err != nil {
-		m.SetRedisResources(map[string]*models.Resource{})
-
-		str, err = m.rdb.Get(context.Background(), resourcesKey).Result()
-		if err != nil {
-			panic(err)
-		}
+		return err
 	}
-	if err := json.Unmarshal([]byte(str), res); err != nil {
-		panic(err)
+	if err := c.Incr(ctx, versionKey(r.Key())).Err(); err != nil {
+		return err
 	}
-	if res.Resources == nil {
-		res.Resources = make(map[string]*models.Resource, 0)
-	}
-	return res.Resources
+	return c.HSet(ctx, resourcesKey, r.Key(), str).Err()
 }
 
-func (m *Redis) SetRedisResources(res map[string]*models.Resource) map[string]*models.Resource {
-	resources := &RedisResources{
-		Resources: res,
+func (m *Redis) getAllResources(c redis.Cmdable) (map[string]*models.Resource, error) {
+	all, err := c.HGetAll(ctx, resourcesKey).Result()
+	if err != nil {
+		return nil, err
 	}
 
-	b, err := json.Marshal(resources)
+	activity, err := c.HGetAll(ctx, activityKey).Result()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	if err := m.rdb.Set(context.Background(), resourcesKey, string(b), 0).Err(); err != nil {
-		panic(err)
+	ret := map[string]*models.Resource{}
+	for k, str := range all {
+		r := &models.Resource{}
+		if err := m.decode(hashField(resourcesKey, k), str, r); err != nil {
+			return nil, err
+		}
+		setActivity(r, activity[k])
+		ret[k] = r
 	}
+	return ret, nil
+}
 
-	return resources.Resources
+// touchResource records activity on a resource
+func touchResource(c redis.Cmdable, key string) error {
+	if err := c.Incr(ctx, versionKey(key)).Err(); err != nil {
+		return err
+	}
+	return c.HSet(ctx, activityKey, key, time.Now().Format(time.RFC3339Nano)).Err()
 }
 
-func (m *Redis) GetReservation(u *models.User, name, env string) *models.Reservation {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+// setActivity applies a stored activity time to a resource. Resources saved before activity was
+// tracked separately keep the time from their JSON.
+func setActivity(r *models.Resource, activity string) {
+	if activity == "" {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, activity)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+	r.LastActivity = t
+}
 
-	r := m.GetResource(name, env, false)
-	if r == nil {
-		return nil
+// getQueue reads the queue for a resource key. The raw stored values are returned alongside the
+// reservations so that individual entries can be addressed in the list.
+func (m *Redis) getQueue(c redis.Cmdable, key string) ([]*models.Reservation, []string, error) {
+	raw, err := c.LRange(ctx, queueKey(key), 0, -1).Result()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	reservations := m.GetRedisReservations()
-	for _, res := range reservations {
-		if res.User.ID == u.ID {
-			if res.Resource.Key() == r.Key() {
-				return res
-			}
+	ret := []*models.Reservation{}
+	for _, str := range raw {
+		res := &models.Reservation{}
+		if err := m.decode(queueKey(key), str, res); err != nil {
+			return nil, nil, err
 		}
+		ret = append(ret, res)
 	}
-	return nil
+	return ret, raw, nil
 }
 
-// Remove removes a user from a resource's queue.
-// If the removal advances the queue, the new resource holder's reservation will have the time updated
-func (m *Redis) Remove(u *models.User, name, env string) error {
-	// minor optimization: if the resource doesn't exist, there's no need to loop through all reservations
-	m.lock.Lock()
-	defer m.lock.Unlock()
+func (m *Redis) marshalReservation(key string, res *models.Reservation) (string, error) {
+	return m.encode(queueKey(key), res)
+}
 
-	r := m.GetResource(name, env, false)
-	if r == nil {
-		return err.ResourceDoesNotExist
+// sortedResources returns resources ordered by key, optionally limited to a single env
+func sortedResources(resources map[string]*models.Resource, env *string) []*models.Resource {
+	keys := []string{}
+	for k, r := range resources {
+		if env != nil && r.Env != *env {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := []*models.Resource{}
+	for _, k := range keys {
+		ret = append(ret, resources[k])
 	}
+	return ret
+}
 
-	reservations := m.GetRedisReservations()
+func (m *Redis) Create(name, env string) error {
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
 
-	idx := -1
-	pos := 0
-	for i, res := range reservations {
-		if res.Resource.Key() == r.Key() {
-			pos++
-			if res.User.ID == u.ID {
-				idx = i
-				break
+	key := models.ResourceKey(name, env)
+	return m.transaction(func(tx *redis.Tx) error {
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if r == nil {
+				err := m.setResource(pipe, &models.Resource{
+					Name: name,
+					Env:  env,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return touchResource(pipe, key)
+		})
+		return err
+	}, versionKey(key))
+}
+
+func (m *Redis) Reserve(u *models.User, name, env string) error {
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
+
+	key := models.ResourceKey(name, env)
+	return m.transaction(func(tx *redis.Tx) error {
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+		created := r == nil
+		if created {
+			r = &models.Resource{
+				Name: name,
+				Env:  env,
 			}
 		}
-	}
-	if idx == -1 {
-		return err.NotInQueue
-	}
 
-	reservations = append(reservations[:idx], reservations[idx+1:]...)
+		if r.ActiveMaintenance(time.Now()) != nil {
+			return e.InMaintenance
+		}
 
-	// if the user was in pos=1, then removal would move new user into pos=1. This should update the time on their res
-	if pos == 1 {
-		for _, res := range reservations {
-			if res.Resource.Key() == r.Key() {
-				res.Time = time.Now()
-				break
+		reservations, _, err := m.getQueue(tx, key)
+		if err != nil {
+			return err
+		}
+		// check for existing reservation
+		for i, res := range reservations {
+			if res.User.ID == u.ID {
+				return alreadyInQueue(i, r)
 			}
 		}
-	}
 
-	r.LastActivity = time.Now()
-	m.SetRedisReservations(reservations)
+		now := time.Now()
+		res := &models.Reservation{
+			ID:       models.NewID(),
+			User:     u,
+			Resource: r,
+			Time:     now,
+			Joined:   now,
+		}
+		str, err := m.marshalReservation(key, res)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if created {
+				if err := m.setResource(pipe, r); err != nil {
+					return err
+				}
+			}
+			pipe.RPush(ctx, queueKey(key), str)
+			return touchResource(pipe, key)
+		})
+		return err
+	}, versionKey(key), queueKey(key))
 }
 
-func (m *Redis) GetPosition(u *models.User, name, env string) (int, error) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	r := m.GetResource(name, env, false)
-	if r == nil {
-		return 0, err.ResourceDoesNotExist
+func (m *Redis) GetReservation(u *models.User, name, env string) *models.Reservation {
+	key := models.ResourceKey(name, env)
+	reservations, err := m.readQueue(key)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return nil
 	}
-
-	pos := 0
-	inQueue := false
-	reservations := m.GetRedisReservations()
 	for _, res := range reservations {
-		if res.Resource.Key() == r.Key() {
-			// increment pos first because want to return zero-based index
-			pos++
-			if res.User.ID == u.ID {
-				inQueue = true
-				break
-			}
+		if res.User.ID != u.ID {
+			continue
 		}
+		r, err := m.readResource(key)
+		if err != nil {
+			log.Errorf("%+v", err)
+			return nil
+		}
+		if r != nil {
+			res.Resource = r
+		}
+		return res
 	}
-	if !inQueue {
-		return 0, err.NotInQueue
-	}
-
-	return pos, nil
+	return nil
 }
 
-func (m *Redis) GetResource(name, env string, create bool) *models.Resource {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+// UpdateReservation persists changes to an existing reservation, matched by user and resource.
+// Returns a conflict if the reservation was modified since it was read.
+func (m *Redis) UpdateReservation(res *models.Reservation) error {
+	l := m.locks.get(res.Resource.Key())
+	l.Lock()
+	defer l.Unlock()
+
+	key := res.Resource.Key()
+	version := res.Version
+	return m.transaction(func(tx *redis.Tx) error {
+		reservations, _, err := m.getQueue(tx, key)
+		if err != nil {
+			return err
+		}
+		for i, r := range reservations {
+			if r.User.ID != res.User.ID {
+				continue
+			}
+			if r.Version != version {
+				return e.Conflict
+			}
 
-	resources := m.GetRedisResources()
-	key := models.ResourceKey(name, env)
-	r, ok := resources[key]
-	if !ok {
-		if create {
-			r = &models.Resource{
-				Name: name,
-				Env:  env,
+			res.Version = version + 1
+			str, err := m.marshalReservation(key, res)
+			if err != nil {
+				return err
 			}
-			resources[r.Key()] = r
-			m.SetRedisResources(resources)
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.LSet(ctx, queueKey(key), int64(i), str)
+				return nil
+			})
+			return err
 		}
-	}
-	return r
+
+		return e.NotInQueue
+	}, queueKey(key))
 }
 
-func (m *Redis) RemoveResource(name, env string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+// Remove removes a user from a resource's queue.
+// If the removal advances the queue, the new resource holder's reservation will have the time updated
+func (m *Redis) Remove(u *models.User, name, env string) error {
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
 
-	r := m.GetResource(name, env, false)
-	if r == nil {
-		return err.ResourceDoesNotExist
-	}
+	key := models.ResourceKey(name, env)
+	return m.transaction(func(tx *redis.Tx) error {
+		// minor optimization: if the resource doesn't exist, there's no need to read the queue
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return e.ResourceDoesNotExist
+		}
 
-	reservations := m.GetRedisReservations()
-	resources := m.GetRedisResources()
+		reservations, raw, err := m.getQueue(tx, key)
+		if err != nil {
+			return err
+		}
 
-	for idx, res := range reservations {
-		if res.Resource.Key() == r.Key() {
-			reservations = append(reservations[:idx], reservations[idx+1:]...)
+		idx := -1
+		for i, res := range reservations {
+			if res.User.ID == u.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return e.NotInQueue
 		}
-	}
 
-	m.SetRedisReservations(reservations)
-	delete(resources, r.Key())
-	m.SetRedisResources(resources)
+		// if the user was in pos=1, then removal would move new user into pos=1. This should update the time on their res
+		next := ""
+		if idx == 0 && len(reservations) > 1 {
+			reservations[1].Time = time.Now()
+			reservations[1].Version++
+			next, err = m.marshalReservation(key, reservations[1])
+			if err != nil {
+				return err
+			}
+		}
 
-	return nil
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LRem(ctx, queueKey(key), 1, raw[idx])
+			if next != "" {
+				pipe.LSet(ctx, queueKey(key), 0, next)
+			}
+			return touchResource(pipe, key)
+		})
+		return err
+	}, versionKey(key), queueKey(key))
 }
 
-func (m *Redis) RemoveEnv(name, env string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+func (m *Redis) Requeue(u *models.User, name, env string) error {
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
+
+	key := models.ResourceKey(name, env)
+	return m.transaction(func(tx *redis.Tx) error {
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return e.ResourceDoesNotExist
+		}
 
-	reservations := m.GetRedisReservations()
-	resources := m.GetRedisResources()
+		reservations, raw, err := m.getQueue(tx, key)
+		if err != nil {
+			return err
+		}
 
-	exists := false
-	for idx, res := range reservations {
-		if res.Resource.Env == env {
-			reservations = append(reservations[:idx], reservations[idx+1:]...)
-			exists = true
+		idx := -1
+		for i, res := range reservations {
+			if res.User.ID == u.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return e.NotInQueue
 		}
-	}
 
-	for k, res := range resources {
-		if res.Env == env {
-			delete(resources, k)
-			exists = true
+		reservations[idx].Joined = time.Now()
+		reservations[idx].Version++
+		moved, err := m.marshalReservation(key, reservations[idx])
+		if err != nil {
+			return err
 		}
-	}
 
-	if !exists {
-		return err.EnvDoesNotExist
-	}
+		// if the user was in pos=1, the user behind them becomes the holder and their time is updated
+		next := ""
+		if idx == 0 && len(reservations) > 1 {
+			reservations[1].Time = time.Now()
+			reservations[1].Version++
+			next, err = m.marshalReservation(key, reservations[1])
+			if err != nil {
+				return err
+			}
+		}
 
-	m.SetRedisReservations(reservations)
-	m.SetRedisResources(resources)
-	return nil
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LRem(ctx, queueKey(key), 1, raw[idx])
+			if next != "" {
+				pipe.LSet(ctx, queueKey(key), 0, next)
+			}
+			pipe.RPush(ctx, queueKey(key), moved)
+			return touchResource(pipe, key)
+		})
+		return err
+	}, versionKey(key), queueKey(key))
 }
 
-func (m *Redis) GetResources() []*models.Resource {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+func (m *Redis) Promote(u *models.User, name, env string) error {
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
 
-	resources := m.GetRedisResources()
+	key := models.ResourceKey(name, env)
+	return m.transaction(func(tx *redis.Tx) error {
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return e.ResourceDoesNotExist
+		}
 
-	keys := []string{}
-	for k, _ := range resources {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
+		reservations, raw, err := m.getQueue(tx, key)
+		if err != nil {
+			return err
+		}
 
-	ret := []*models.Resource{}
-	for _, k := range keys {
-		ret = append(ret, resources[k])
-	}
-	m.SetRedisResources(resources)
+		idx := -1
+		for i, res := range reservations {
+			if res.User.ID == u.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return e.NotInQueue
+		}
 
-	return ret
-}
+		reservations[idx].Time = time.Now()
+		reservations[idx].Version++
+		moved, err := m.marshalReservation(key, reservations[idx])
+		if err != nil {
+			return err
+		}
 
-// Does not implement lock
-func (m *Redis) GetQueues() []*models.Queue {
-	ret := []*models.Queue{}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LRem(ctx, queueKey(key), 1, raw[idx])
+			pipe.LPush(ctx, queueKey(key), moved)
+			return touchResource(pipe, key)
+		})
+		return err
+	}, versionKey(key), queueKey(key))
+}
 
-	resources := m.GetResources()
-	for _, r := range resources {
-		q, _ := m.GetQueueForResource(r.Name, r.Env)
-		ret = append(ret, q)
-	}
+func (m *Redis) Transfer(from, to *models.User, name, env string) error {
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
 
-	return ret
-}
+	key := models.ResourceKey(name, env)
+	return m.transaction(func(tx *redis.Tx) error {
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return e.ResourceDoesNotExist
+		}
 
-func (m *Redis) GetQueueForResource(name, env string) (*models.Queue, error) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	// minor optimization
-	r := m.GetResource(name, env, false)
-	if r == nil {
-		return nil, err.ResourceDoesNotExist
-	}
+		reservations, raw, err := m.getQueue(tx, key)
+		if err != nil {
+			return err
+		}
 
-	ret := &models.Queue{
-		Resource: r,
-	}
+		idx, other := -1, -1
+		for i, res := range reservations {
+			switch res.User.ID {
+			case from.ID:
+				idx = i
+			case to.ID:
+				other = i
+			}
+		}
+		if idx == -1 {
+			return e.NotInQueue
+		}
 
-	reservations := m.GetRedisReservations()
-	for _, res := range reservations {
-		if res.Resource.Key() == r.Key() {
-			ret.Reservations = append(ret.Reservations, res)
+		var handed string
+		if other == -1 || other > idx {
+			handed, err = m.marshalReservation(key, handOn(reservations[idx], to, idx == 0))
+			if err != nil {
+				return err
+			}
 		}
-	}
 
-	return ret, nil
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if handed == "" {
+				// the other user is already ahead
+				pipe.LRem(ctx, queueKey(key), 1, raw[idx])
+				return touchResource(pipe, key)
+			}
+			if other != -1 {
+				pipe.LRem(ctx, queueKey(key), 1, raw[other])
+			}
+			pipe.LSet(ctx, queueKey(key), int64(idx), handed)
+			return touchResource(pipe, key)
+		})
+		return err
+	}, versionKey(key), queueKey(key))
 }
 
-func (m *Redis) GetReservationForResource(name, env string) (*models.Reservation, error) {
-	// minor optimization
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	r := m.GetResource(name, env, false)
+func (m *Redis) GetPosition(u *models.User, name, env string) (int, error) {
+	key := models.ResourceKey(name, env)
+	r, err := m.readResource(key)
+	if err != nil {
+		return 0, err
+	}
 	if r == nil {
-		return nil, err.ResourceDoesNotExist
+		return 0, e.ResourceDoesNotExist
 	}
 
-	reservations := m.GetRedisReservations()
-	for _, res := range reservations {
-		if res.Resource.Key() == r.Key() {
-			return res, nil
+	reservations, err := m.readQueue(key)
+	if err != nil {
+		return 0, err
+	}
+	for i, res := range reservations {
+		if res.User.ID == u.ID {
+			// positions are one-based
+			return i + 1, nil
 		}
 	}
 
-	return nil, nil
+	return 0, e.NotInQueue
 }
 
-// Does not implement lock
-func (m *Redis) GetQueuesForEnv(env string) map[string]*models.Queue {
-	ret := make(map[string]*models.Queue)
-
-	resources := m.GetResourcesForEnv(env)
-	for _, r := range resources {
-		q, _ := m.GetQueueForResource(r.Name, r.Env)
-		ret[r.Name] = q
+func (m *Redis) GetResource(name, env string, create bool) *models.Resource {
+	if !create {
+		r, err := m.readResource(models.ResourceKey(name, env))
+		if err != nil {
+			log.Errorf("%+v", err)
+			return nil
+		}
+		return r
 	}
 
-	return ret
-}
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
 
-func (m *Redis) GetResourcesForEnv(env string) []*models.Resource {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	key := models.ResourceKey(name, env)
+	var ret *models.Resource
+	err := m.transaction(func(tx *redis.Tx) error {
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			r = &models.Resource{
+				Name: name,
+				Env:  env,
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if err := m.setResource(pipe, r); err != nil {
+					return err
+				}
+				return touchResource(pipe, key)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		ret = r
+		return nil
+	}, versionKey(key))
+	if err != nil {
+		log.Errorf("%+v", err)
+		return nil
+	}
+	return ret
+}
 
-	resources := m.GetRedisResources()
+// UpdateResource persists changes to an existing resource's settings.
+// Returns a conflict if the resource was modified since it was read.
+func (m *Redis) UpdateResource(r *models.Resource) error {
+	l := m.locks.get(r.Key())
+	l.Lock()
+	defer l.Unlock()
 
-	keys := []string{}
-	for k, r := range resources {
-		if r.Env == env {
-			keys = append(keys, k)
+	version := r.Version
+	return m.transaction(func(tx *redis.Tx) error {
+		existing, err := m.getResource(tx, r.Key())
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return e.ResourceDoesNotExist
+		}
+		if existing.Version != version {
+			return e.Conflict
+		}
+
+		r.Version = version + 1
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return m.setResource(pipe, r)
+		})
+		return err
+	}, versionKey(r.Key()))
+}
+
+// TouchResource records activity on a resource so that it isn't pruned
+func (m *Redis) TouchResource(name, env string) error {
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
+
+	key := models.ResourceKey(name, env)
+	return m.transaction(func(tx *redis.Tx) error {
+		exists, err := tx.HExists(ctx, resourcesKey, key).Result()
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return e.ResourceDoesNotExist
 		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return touchResource(pipe, key)
+		})
+		return err
+	}, versionKey(key))
+}
+
+func (m *Redis) RemoveResource(name, env string) error {
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
+
+	key := models.ResourceKey(name, env)
+	return m.transaction(func(tx *redis.Tx) error {
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return e.ResourceDoesNotExist
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, resourcesKey, key)
+			pipe.HDel(ctx, activityKey, key)
+			pipe.Del(ctx, queueKey(key), versionKey(key))
+			return nil
+		})
+		return err
+	}, versionKey(key), queueKey(key))
+}
+
+// RemoveEnv watches every resource, since it decides from all of them which to remove. Deleting their
+// versions aborts transactions in flight on each of them.
+func (m *Redis) RemoveEnv(name, env string) error {
+	return m.transaction(func(tx *redis.Tx) error {
+		resources, err := m.getAllResources(tx)
+		if err != nil {
+			return err
+		}
+
+		keys := []string{}
+		for k, r := range resources {
+			if r.Env == env {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			return e.EnvDoesNotExist
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, k := range keys {
+				pipe.HDel(ctx, resourcesKey, k)
+				pipe.HDel(ctx, activityKey, k)
+				pipe.Del(ctx, queueKey(k), versionKey(k))
+			}
+			return nil
+		})
+		return err
+	}, resourcesKey)
+}
+
+func (m *Redis) GetResources() []*models.Resource {
+	resources, err := m.readAllResources()
+	if err != nil {
+		log.Errorf("%+v", err)
+		return []*models.Resource{}
 	}
-	sort.Strings(keys)
 
-	ret := []*models.Resource{}
-	for _, k := range keys {
-		ret = append(ret, resources[k])
+	return sortedResources(resources, nil)
+}
+
+func (m *Redis) GetQueues() []*models.Queue {
+	queues, err := m.queues(nil)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return []*models.Queue{}
+	}
+
+	return queues
+}
+
+// queues builds the queues for all resources, optionally limited to a single env, from the replica if
+// it may be used
+func (m *Redis) queues(env *string) (ret []*models.Queue, err error) {
+	err = m.read(func(c redis.Cmdable) error {
+		ret, err = m.getQueues(c, env)
+		return err
+	})
+	return ret, err
+}
+
+// getQueues builds the queues for all resources, optionally limited to a single env. All of the queue
+// lists are read in a single pipelined round trip.
+func (m *Redis) getQueues(c redis.Cmdable, env *string) ([]*models.Queue, error) {
+	resources, err := m.getAllResources(c)
+	if err != nil {
+		return nil, err
+	}
+	sorted := sortedResources(resources, env)
+
+	cmds := make([]*redis.StringSliceCmd, len(sorted))
+	_, err = c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, r := range sorted {
+			cmds[i] = pipe.LRange(ctx, queueKey(r.Key()), 0, -1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*models.Queue{}
+	for i, r := range sorted {
+		q := &models.Queue{
+			Resource: r,
+		}
+		for _, str := range cmds[i].Val() {
+			res := &models.Reservation{}
+			if err := m.decode(queueKey(r.Key()), str, res); err != nil {
+				return nil, err
+			}
+			res.Resource = r
+			q.Reservations = append(q.Reservations, res)
+		}
+		ret = append(ret, q)
+	}
+
+	return ret, nil
+}
+
+func (m *Redis) GetQueueForResource(name, env string) (*models.Queue, error) {
+	key := models.ResourceKey(name, env)
+	r, err := m.readResource(key)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, e.ResourceDoesNotExist
+	}
+
+	reservations, err := m.readQueue(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &models.Queue{
+		Resource: r,
+	}
+	for _, res := range reservations {
+		res.Resource = r
+	}
+	if len(reservations) > 0 {
+		ret.Reservations = reservations
+	}
+
+	return ret, nil
+}
+
+func (m *Redis) GetReservationForResource(name, env string) (*models.Reservation, error) {
+	key := models.ResourceKey(name, env)
+	r, err := m.readResource(key)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, e.ResourceDoesNotExist
 	}
+
+	var str string
+	err = m.read(func(c redis.Cmdable) error {
+		str, err = c.LIndex(ctx, queueKey(key), 0).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if str == "" {
+		return nil, nil
+	}
+
+	res := &models.Reservation{}
+	if err := m.decode(queueKey(key), str, res); err != nil {
+		return nil, err
+	}
+	res.Resource = r
+	return res, nil
+}
+
+func (m *Redis) GetQueuesForEnv(env string) map[string]*models.Queue {
+	ret := make(map[string]*models.Queue)
+
+	queues, err := m.queues(&env)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return ret
+	}
+	for _, q := range queues {
+		ret[q.Resource.Name] = q
+	}
+
 	return ret
 }
 
-func (m *Redis) GetAllUsersInQueues() []*models.User {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+func (m *Redis) GetResourcesForEnv(env string) []*models.Resource {
+	resources, err := m.readAllResources()
+	if err != nil {
+		log.Errorf("%+v", err)
+		return []*models.Resource{}
+	}
+
+	return sortedResources(resources, &env)
+}
+
+func (m *Redis) GetEnvsForName(name string) []string {
+	resources, err := m.readAllResources()
+	if err != nil {
+		log.Errorf("%+v", err)
+		return []string{}
+	}
 
+	ret := []string{}
+	for _, r := range resources {
+		if r.Name == name {
+			ret = append(ret, r.Env)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (m *Redis) GetAllUsersInQueues() []*models.User {
 	all := map[string]*models.User{}
 
-	reservations := m.GetRedisReservations()
-	for _, r := range reservations {
-		all[r.User.ID] = r.User
+	queues, err := m.queues(nil)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return []*models.User{}
+	}
+	for _, q := range queues {
+		for _, r := range q.Reservations {
+			all[r.User.ID] = r.User
+		}
 	}
 
 	ret := []*models.User{}
@@ -457,45 +1021,735 @@ func (m *Redis) GetAllUsersInQueues() []*models.User {
 }
 
 func (m *Redis) ClearQueueForResource(name, env string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	// minor optimization
-	r := m.GetResource(name, env, false)
-	if r == nil {
-		return err.ResourceDoesNotExist
+	l := m.locks.get(models.ResourceKey(name, env))
+	l.Lock()
+	defer l.Unlock()
+
+	key := models.ResourceKey(name, env)
+	return m.transaction(func(tx *redis.Tx) error {
+		r, err := m.getResource(tx, key)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return e.ResourceDoesNotExist
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, queueKey(key))
+			return touchResource(pipe, key)
+		})
+		return err
+	}, versionKey(key), queueKey(key))
+}
+
+// ClaimIdempotencyKey records a key with SETNX, so that only the first claim succeeds until it expires
+func (m *Redis) ClaimIdempotencyKey(key string, ttl time.Duration) (bool, error) {
+	return m.rdb.SetNX(ctx, idempotencyKeyPrefix+key, 1, ttl).Result()
+}
+
+func (m *Redis) ReleaseIdempotencyKey(key string) error {
+	return m.rdb.Del(ctx, idempotencyKeyPrefix+key).Err()
+}
+
+func (m *Redis) AddUsage(u *models.Usage) error {
+	field := u.UserID + " " + u.Resource
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrByFloat(ctx, usageKeyPrefix+u.Month+":hours", field, u.Hours)
+		pipe.HIncrByFloat(ctx, usageKeyPrefix+u.Month+":cost", field, u.Cost)
+		return nil
+	})
+	return err
+}
+
+func (m *Redis) GetUsage(month string) ([]*models.Usage, error) {
+	hours, err := m.rdb.HGetAll(ctx, usageKeyPrefix+month+":hours").Result()
+	if err != nil {
+		return nil, err
+	}
+	costs, err := m.rdb.HGetAll(ctx, usageKeyPrefix+month+":cost").Result()
+	if err != nil {
+		return nil, err
 	}
 
-	reservations := m.GetRedisReservations()
-	filtered := []*models.Reservation{}
-	for _, res := range reservations {
-		if res.Resource.Key() != r.Key() {
-			filtered = append(filtered, res)
+	fields := []string{}
+	for f := range hours {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	ret := []*models.Usage{}
+	for _, f := range fields {
+		split := strings.SplitN(f, " ", 2)
+		if len(split) != 2 {
+			continue
+		}
+		u := &models.Usage{
+			Month:    month,
+			UserID:   split[0],
+			Resource: split[1],
+		}
+		if u.Hours, err = strconv.ParseFloat(hours[f], 64); err != nil {
+			return nil, err
+		}
+		if c, ok := costs[f]; ok {
+			if u.Cost, err = strconv.ParseFloat(c, 64); err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, u)
+	}
+	return ret, nil
+}
+
+func (m *Redis) AddContention(c *models.Contention) error {
+	field := contentionField(c)
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrByFloat(ctx, contentionKey+":sampled", field, c.Sampled)
+		pipe.HIncrByFloat(ctx, contentionKey+":contended", field, c.Contended)
+		pipe.HIncrByFloat(ctx, contentionKey+":waiting", field, c.Waiting)
+		return nil
+	})
+	return err
+}
+
+func (m *Redis) GetContention() ([]*models.Contention, error) {
+	hashes := map[string]map[string]string{}
+	for _, name := range []string{"sampled", "contended", "waiting"} {
+		h, err := m.rdb.HGetAll(ctx, contentionKey+":"+name).Result()
+		if err != nil {
+			return nil, err
+		}
+		hashes[name] = h
+	}
+
+	fields := []string{}
+	for f := range hashes["sampled"] {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	ret := []*models.Contention{}
+	for _, f := range fields {
+		split := strings.SplitN(f, " ", 3)
+		if len(split) != 3 {
+			continue
+		}
+		weekday, err := strconv.Atoi(split[0])
+		if err != nil {
+			return nil, err
+		}
+		hour, err := strconv.Atoi(split[1])
+		if err != nil {
+			return nil, err
 		}
+		c := &models.Contention{
+			Resource: split[2],
+			Weekday:  time.Weekday(weekday),
+			Hour:     hour,
+		}
+		for name, v := range map[string]*float64{"sampled": &c.Sampled, "contended": &c.Contended, "waiting": &c.Waiting} {
+			if s, ok := hashes[name][f]; ok {
+				if *v, err = strconv.ParseFloat(s, 64); err != nil {
+					return nil, err
+				}
+			}
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+func (m *Redis) AddHold(name, env string, h *models.Hold) error {
+	key := holdsKeyPrefix + models.ResourceKey(name, env)
+	str, err := m.encode(key, h)
+	if err != nil {
+		return err
+	}
+	oldest := time.Now().Add(-models.HoldHistory)
+	_, err = m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(holdScore(h.End)), Member: str})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(holdScore(oldest), 10))
+		return nil
+	})
+	return err
+}
+
+func (m *Redis) GetHolds(name, env string, since time.Time) ([]*models.Hold, error) {
+	key := holdsKeyPrefix + models.ResourceKey(name, env)
+	strs, err := m.rdb.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(holdScore(since), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
 	}
-	reservations = filtered
-	r.LastActivity = time.Now()
-	m.SetRedisReservations(reservations)
 
+	ret := []*models.Hold{}
+	for _, str := range strs {
+		h := &models.Hold{}
+		if err := m.decode(key, str, h); err != nil {
+			return nil, err
+		}
+		ret = append(ret, h)
+	}
+	return ret, nil
+}
+
+func holdScore(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (m *Redis) GetUserToken(userID string) (string, error) {
+	token, err := m.rdb.HGet(ctx, userTokensKey, userID).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	b, err := m.open(hashField(userTokensKey, userID), token)
+	return string(b), err
+}
+
+func (m *Redis) SetUserToken(userID, token string) error {
+	if token == "" {
+		return m.rdb.HDel(ctx, userTokensKey, userID).Err()
+	}
+	str, err := m.seal(hashField(userTokensKey, userID), []byte(token))
+	if err != nil {
+		return err
+	}
+	return m.rdb.HSet(ctx, userTokensKey, userID, str).Err()
+}
+
+func (m *Redis) GetDefaultEnv(userID string) (string, error) {
+	env, err := m.rdb.HGet(ctx, defaultEnvsKey, userID).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return env, err
+}
+
+func (m *Redis) SetDefaultEnv(userID, env string) error {
+	if env == "" {
+		return m.rdb.HDel(ctx, defaultEnvsKey, userID).Err()
+	}
+	return m.rdb.HSet(ctx, defaultEnvsKey, userID, env).Err()
+}
+
+func (m *Redis) GetStatusFilter(userID string) (string, error) {
+	filter, err := m.rdb.HGet(ctx, statusFiltersKey, userID).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return filter, err
+}
+
+func (m *Redis) SetStatusFilter(userID, filter string) error {
+	if filter == "" {
+		return m.rdb.HDel(ctx, statusFiltersKey, userID).Err()
+	}
+	return m.rdb.HSet(ctx, statusFiltersKey, userID, filter).Err()
+}
+
+func (m *Redis) GetMilestonesOff(userID string) (bool, error) {
+	return m.rdb.SIsMember(ctx, milestonesOffKey, userID).Result()
+}
+
+func (m *Redis) SetMilestonesOff(userID string, off bool) error {
+	if !off {
+		return m.rdb.SRem(ctx, milestonesOffKey, userID).Err()
+	}
+	return m.rdb.SAdd(ctx, milestonesOffKey, userID).Err()
+}
+
+func (m *Redis) GetServiceAccounts() ([]*models.ServiceAccount, error) {
+	strs, err := m.rdb.HGetAll(ctx, serviceAccountsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*models.ServiceAccount{}
+	for name, str := range strs {
+		a := &models.ServiceAccount{}
+		if err := m.decode(hashField(serviceAccountsKey, name), str, a); err != nil {
+			return nil, err
+		}
+		ret = append(ret, a)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+func (m *Redis) SaveServiceAccount(a *models.ServiceAccount) error {
+	str, err := m.encode(hashField(serviceAccountsKey, a.Name), a)
+	if err != nil {
+		return err
+	}
+	return m.rdb.HSet(ctx, serviceAccountsKey, a.Name, str).Err()
+}
+
+func (m *Redis) DeleteServiceAccount(name string) error {
+	n, err := m.rdb.HDel(ctx, serviceAccountsKey, name).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return e.ServiceAccountDoesNotExist
+	}
 	return nil
 }
 
-func (m *Redis) PruneInactiveResources(hours int) error {
-	resources := m.GetResources()
-	oldestTime := time.Now().Add(-time.Duration(hours) * time.Hour)
+func (m *Redis) GetAPIKeys() ([]*models.APIKey, error) {
+	strs, err := m.rdb.HGetAll(ctx, apiKeysKey).Result()
+	if err != nil {
+		return nil, err
+	}
 
-	for _, r := range resources {
-		q, err := m.GetQueueForResource(r.Name, r.Env)
+	ret := []*models.APIKey{}
+	for id, str := range strs {
+		k := &models.APIKey{}
+		if err := m.decode(hashField(apiKeysKey, id), str, k); err != nil {
+			return nil, err
+		}
+		ret = append(ret, k)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret, nil
+}
+
+func (m *Redis) SaveAPIKey(k *models.APIKey) error {
+	str, err := m.encode(hashField(apiKeysKey, k.ID), k)
+	if err != nil {
+		return err
+	}
+	return m.rdb.HSet(ctx, apiKeysKey, k.ID, str).Err()
+}
+
+func (m *Redis) DeleteAPIKey(id string) error {
+	n, err := m.rdb.HDel(ctx, apiKeysKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return e.APIKeyDoesNotExist
+	}
+	return nil
+}
+
+func (m *Redis) GetLiveStatuses() ([]*models.LiveStatus, error) {
+	strs, err := m.rdb.HGetAll(ctx, liveStatusKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*models.LiveStatus{}
+	for channel, str := range strs {
+		s := &models.LiveStatus{}
+		if err := m.decode(hashField(liveStatusKey, channel), str, s); err != nil {
+			return nil, err
+		}
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Channel < ret[j].Channel
+	})
+	return ret, nil
+}
+
+func (m *Redis) SaveLiveStatus(s *models.LiveStatus) error {
+	str, err := m.encode(hashField(liveStatusKey, s.Channel), s)
+	if err != nil {
+		return err
+	}
+	return m.rdb.HSet(ctx, liveStatusKey, s.Channel, str).Err()
+}
+
+func (m *Redis) DeleteLiveStatus(channel string) error {
+	return m.rdb.HDel(ctx, liveStatusKey, channel).Err()
+}
+
+func (m *Redis) BufferNotification(n *models.BufferedNotification, limit int) (int, error) {
+	str, err := m.encode(bufferedKey, n)
+	if err != nil {
+		return 0, err
+	}
+	var length *redis.IntCmd
+	_, err = m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		length = pipe.RPush(ctx, bufferedKey, str)
+		pipe.LTrim(ctx, bufferedKey, int64(-limit), -1)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if dropped := int(length.Val()) - limit; dropped > 0 {
+		return dropped, nil
+	}
+	return 0, nil
+}
+
+func (m *Redis) TakeBufferedNotifications() ([]*models.BufferedNotification, error) {
+	var strs *redis.StringSliceCmd
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		strs = pipe.LRange(ctx, bufferedKey, 0, -1)
+		pipe.Del(ctx, bufferedKey)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*models.BufferedNotification{}
+	for _, str := range strs.Val() {
+		n := &models.BufferedNotification{}
+		if err := m.decode(bufferedKey, str, n); err != nil {
+			log.Errorf("Dropping a buffered DM that can't be read: %+v", err)
+			continue
+		}
+		ret = append(ret, n)
+	}
+	return ret, nil
+}
+
+func (m *Redis) GetScheduledActions() ([]*models.ScheduledAction, error) {
+	strs, err := m.rdb.HGetAll(ctx, scheduledKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*models.ScheduledAction{}
+	for id, str := range strs {
+		a := &models.ScheduledAction{}
+		if err := m.decode(hashField(scheduledKey, id), str, a); err != nil {
+			return nil, err
+		}
+		ret = append(ret, a)
+	}
+	sortScheduledActions(ret)
+	return ret, nil
+}
+
+func (m *Redis) SaveScheduledAction(a *models.ScheduledAction) error {
+	str, err := m.encode(hashField(scheduledKey, a.ID), a)
+	if err != nil {
+		return err
+	}
+	return m.rdb.HSet(ctx, scheduledKey, a.ID, str).Err()
+}
+
+func (m *Redis) DeleteScheduledAction(id string) error {
+	n, err := m.rdb.HDel(ctx, scheduledKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return e.ScheduledActionDoesNotExist
+	}
+	return nil
+}
+
+func (m *Redis) GetSnapshot(name, env string) (*models.Snapshot, error) {
+	key := models.ResourceKey(name, env)
+	str, err := m.rdb.HGet(ctx, snapshotsKey, key).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &models.Snapshot{}
+	if err := m.decode(hashField(snapshotsKey, key), str, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (m *Redis) SaveSnapshot(name, env string, s *models.Snapshot) error {
+	key := models.ResourceKey(name, env)
+	str, err := m.encode(hashField(snapshotsKey, key), s)
+	if err != nil {
+		return err
+	}
+	return m.rdb.HSet(ctx, snapshotsKey, key, str).Err()
+}
+
+func (m *Redis) ForgetUser(userID string) (*models.Forgotten, error) {
+	ret := &models.Forgotten{}
+
+	holdKeys, err := m.scan(holdsKeyPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range holdKeys {
+		strs, err := m.rdb.ZRange(ctx, key, 0, -1).Result()
 		if err != nil {
-			log.Errorf("%+v", err)
+			return nil, err
+		}
+		for _, str := range strs {
+			h := &models.Hold{}
+			if err := m.decode(key, str, h); err != nil {
+				return nil, err
+			}
+			if h.User == nil || h.User.ID != userID {
+				continue
+			}
+			if err := m.rdb.ZRem(ctx, key, str).Err(); err != nil {
+				return nil, err
+			}
+			ret.Holds++
+		}
+	}
+
+	usageKeys, err := m.scan(usageKeyPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range usageKeys {
+		fields, err := m.rdb.HKeys(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			if !strings.HasPrefix(f, userID+" ") {
+				continue
+			}
+			if err := m.rdb.HDel(ctx, key, f).Err(); err != nil {
+				return nil, err
+			}
+			// hours and cost are kept in separate hashes, and only hours count as a record
+			if strings.HasSuffix(key, ":hours") {
+				ret.Usage++
+			}
+		}
+	}
+
+	snapshots, err := m.rdb.HGetAll(ctx, snapshotsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	for key, str := range snapshots {
+		s := &models.Snapshot{}
+		if err := m.decode(hashField(snapshotsKey, key), str, s); err != nil {
+			return nil, err
 		}
-		if q.HasReservations() {
+		if !s.Forget(userID) {
 			continue
 		}
-		if r.LastActivity.Before(oldestTime) {
-			err := m.RemoveResource(r.Name, r.Env)
-			if err != nil {
-				log.Errorf("%+v", err)
+		str, err := m.encode(hashField(snapshotsKey, key), s)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.rdb.HSet(ctx, snapshotsKey, key, str).Err(); err != nil {
+			return nil, err
+		}
+		ret.Snapshots++
+	}
+
+	actions, err := m.GetScheduledActions()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range actions {
+		if a.UserID != userID {
+			continue
+		}
+		// another instance may have run the command just now, in which case it isn't counted
+		n, err := m.rdb.HDel(ctx, scheduledKey, a.ID).Result()
+		if err != nil {
+			return nil, err
+		}
+		ret.Scheduled += int(n)
+	}
+
+	buffered, err := m.rdb.LRange(ctx, bufferedKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, str := range buffered {
+		b := &models.BufferedNotification{}
+		if err := m.decode(bufferedKey, str, b); err != nil || b.UserID != userID {
+			continue
+		}
+		// the DMs may have been delivered just now, in which case they aren't counted
+		n, err := m.rdb.LRem(ctx, bufferedKey, 1, str).Result()
+		if err != nil {
+			return nil, err
+		}
+		ret.Notifications += int(n)
+	}
+
+	n, err := m.rdb.HDel(ctx, defaultEnvsKey, userID).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret.DefaultEnv = n > 0
+	if n, err = m.rdb.HDel(ctx, statusFiltersKey, userID).Result(); err != nil {
+		return nil, err
+	}
+	ret.StatusFilter = n > 0
+	if n, err = m.rdb.SRem(ctx, milestonesOffKey, userID).Result(); err != nil {
+		return nil, err
+	}
+	ret.MilestonesOff = n > 0
+	if n, err = m.rdb.HDel(ctx, userTokensKey, userID).Result(); err != nil {
+		return nil, err
+	}
+	ret.Token = n > 0
+
+	return ret, nil
+}
+
+func (m *Redis) Compact(before time.Time) (*models.Compaction, error) {
+	ret := &models.Compaction{}
+
+	holdKeys, err := m.scan(holdsKeyPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range holdKeys {
+		n, err := m.rdb.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(holdScore(before), 10)).Result()
+		if err != nil {
+			return nil, err
+		}
+		ret.Holds += int(n)
+	}
+
+	month := before.Format(models.UsageMonthFormat)
+	usageKeys, err := m.scan(usageKeyPrefix + "*:hours")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range usageKeys {
+		prefix := strings.TrimSuffix(key, ":hours")
+		if strings.TrimPrefix(prefix, usageKeyPrefix) >= month {
+			continue
+		}
+		n, err := m.rdb.HLen(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		if err := m.rdb.Del(ctx, key, prefix+":cost").Err(); err != nil {
+			return nil, err
+		}
+		ret.Usage += int(n)
+	}
+
+	snapshots, err := m.rdb.HGetAll(ctx, snapshotsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	for key, str := range snapshots {
+		s := &models.Snapshot{}
+		if err := m.decode(hashField(snapshotsKey, key), str, s); err != nil {
+			return nil, err
+		}
+		if !s.Taken.Before(before) {
+			continue
+		}
+		if err := m.rdb.HDel(ctx, snapshotsKey, key).Err(); err != nil {
+			return nil, err
+		}
+		ret.Snapshots++
+	}
+
+	return ret, nil
+}
+
+// Dedupe rewrites each queue with a user in it more than once, atomically, without their later entries
+func (m *Redis) Dedupe() ([]*models.Repair, error) {
+	resources, err := m.getAllResources(m.rdb)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*models.Repair{}
+	for _, r := range sortedResources(resources, nil) {
+		key := r.Key()
+		var repairs []*models.Repair
+		err := func() error {
+			l := m.locks.get(key)
+			l.Lock()
+			defer l.Unlock()
+
+			return m.transaction(func(tx *redis.Tx) error {
+				reservations, raw, err := m.getQueue(tx, key)
+				if err != nil {
+					return err
+				}
+				var kept []int
+				kept, repairs = dedupe(reservations, r.String())
+				if len(repairs) == 0 {
+					return nil
+				}
+
+				_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					pipe.Del(ctx, queueKey(key))
+					for _, i := range kept {
+						pipe.RPush(ctx, queueKey(key), raw[i])
+					}
+					return touchResource(pipe, key)
+				})
+				return err
+			}, queueKey(key))
+		}()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, repairs...)
+	}
+	return ret, nil
+}
+
+// scan returns every key matching a pattern, without blocking Redis as KEYS would
+func (m *Redis) scan(pattern string) ([]string, error) {
+	ret := []string{}
+	iter := m.rdb.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		ret = append(ret, iter.Val())
+	}
+	return ret, iter.Err()
+}
+
+// PruneInactiveResources removes resources with empty queues that have had no activity in the given
+// number of hours. Each resource is checked and removed atomically so that activity recorded while
+// pruning is respected.
+func (m *Redis) PruneInactiveResources(hours int) error {
+	resources, err := m.getAllResources(m.rdb)
+	if err != nil {
+		return err
+	}
+	oldestTime := time.Now().Add(-time.Duration(hours) * time.Hour)
+
+	for key := range resources {
+		err := m.transaction(func(tx *redis.Tx) error {
+			r, err := m.getResource(tx, key)
+			if err != nil || r == nil {
+				return err
+			}
+			if !r.LastActivity.Before(oldestTime) {
+				return nil
+			}
+			n, err := tx.LLen(ctx, queueKey(key)).Result()
+			if err != nil || n > 0 {
+				return err
 			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HDel(ctx, resourcesKey, key)
+				pipe.HDel(ctx, activityKey, key)
+				pipe.Del(ctx, versionKey(key))
+				return nil
+			})
+			return err
+		}, versionKey(key), queueKey(key))
+		if err != nil {
+			log.Errorf("%+v", err)
 		}
 	}
 	return nil
@@ -0,0 +1,34 @@
+package data_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/data/managertest"
+)
+
+func TestRedisConformance(t *testing.T) {
+	if err := managertest.Check(managertest.Redis(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisEncryptedConformance(t *testing.T) {
+	sealer, err := data.NewSealer([]string{base64.StdEncoding.EncodeToString(make([]byte, 32))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := managertest.Redis(t)
+	err = managertest.Check(func() (data.Manager, error) {
+		m, err := factory()
+		if err != nil {
+			return nil, err
+		}
+		m.(*data.Redis).Encrypt(sealer)
+		return m, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
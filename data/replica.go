@@ -0,0 +1,178 @@
+package data
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+// heartbeatKey holds the time the primary was last stamped, in nanoseconds, which is read back from the
+// replica to tell how far behind it is
+const heartbeatKey string = "reservebot:replica_heartbeat"
+
+// replica is a read replica of the primary, such as one in the region the bot runs in, that status and
+// queues are read from while it is within its lag bound
+type replica struct {
+	rdb *redis.Client
+	// maxLag is how far behind the primary the replica may be while it is read from
+	maxLag time.Duration
+
+	lock sync.RWMutex
+	// fresh is set while the replica is within maxLag of the primary
+	fresh bool
+	// stamped is the last heartbeat written to the primary
+	stamped int64
+	// wrote is when this process last wrote to the primary. Reads go to the primary until the replica
+	// must have caught up, so that a change shows up in status straight after it is made.
+	wrote time.Time
+}
+
+// ReadFrom serves reads of status and queues from a read replica while it is no more than maxLag behind
+// the primary, checked with a heartbeat written to the primary a few times within maxLag. Writes always go
+// to the primary, and so do reads straight after this process writes, so that changes are seen at once.
+// Reads fall back to the primary while the replica lags, can't be reached or fails a read.
+func (m *Redis) ReadFrom(addr, pass string, maxLag time.Duration) {
+	opts := *m.rdb.Options()
+	m.replica = &replica{
+		rdb: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: pass,
+			DB:       opts.DB,
+		}),
+		maxLag: maxLag,
+	}
+	go m.watchReplica()
+}
+
+// watchReplica checks how far behind the replica is until the process ends
+func (m *Redis) watchReplica() {
+	interval := m.replica.maxLag / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	for {
+		m.checkReplica()
+		time.Sleep(interval)
+	}
+}
+
+// checkReplica reads back the heartbeat from the replica to see how far behind it is, then stamps a new
+// one on the primary. A replica that has the last heartbeat is caught up; otherwise it is behind by at
+// least the age of the one it has.
+func (m *Redis) checkReplica() {
+	r := m.replica
+	fresh, reason := true, ""
+	str, err := r.rdb.Get(ctx, heartbeatKey).Result()
+	switch {
+	case err == redis.Nil:
+		fresh, reason = false, "it has no heartbeat yet"
+	case err != nil:
+		fresh, reason = false, err.Error()
+	default:
+		seen, _ := strconv.ParseInt(str, 10, 64)
+		r.lock.RLock()
+		stamped := r.stamped
+		r.lock.RUnlock()
+		if lag := time.Since(time.Unix(0, seen)); seen != stamped && lag > r.maxLag {
+			fresh, reason = false, "it is "+lag.Round(time.Millisecond).String()+" behind"
+		}
+	}
+
+	now := time.Now().UnixNano()
+	if err := m.rdb.Set(ctx, heartbeatKey, now, 0).Err(); err != nil {
+		log.Errorf("Error stamping the replica heartbeat: %+v", err)
+		now = 0
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if now != 0 {
+		r.stamped = now
+	}
+	if fresh != r.fresh {
+		if fresh {
+			log.Infof("Reading from the Redis replica again")
+		} else {
+			log.Warnf("Reading from the Redis primary, as the replica can't be used: %s", reason)
+		}
+	}
+	r.fresh = fresh
+}
+
+// reader returns the replica if reads may be served from it
+func (r *replica) reader() redis.Cmdable {
+	if r == nil {
+		return nil
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if !r.fresh || time.Since(r.wrote) <= r.maxLag {
+		return nil
+	}
+	return r.rdb
+}
+
+// markWrote records that the primary was written to, so that reads go to it until the replica catches up
+func (r *replica) markWrote() {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.wrote = time.Now()
+}
+
+// markFailed stops reads from the replica until it is found healthy again
+func (r *replica) markFailed(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.fresh {
+		log.Warnf("Reading from the Redis primary, as a read from the replica failed: %+v", err)
+	}
+	r.fresh = false
+}
+
+// read runs fn against the replica if reads may be served from it, or else against the primary. A read
+// that fails on the replica is run again on the primary.
+func (m *Redis) read(fn func(c redis.Cmdable) error) error {
+	if c := m.replica.reader(); c != nil {
+		err := fn(c)
+		if err == nil {
+			return nil
+		}
+		m.replica.markFailed(err)
+	}
+	return fn(m.rdb)
+}
+
+// readResource reads a single resource, from the replica if it may be used. It returns nil if the
+// resource does not exist.
+func (m *Redis) readResource(key string) (ret *models.Resource, err error) {
+	err = m.read(func(c redis.Cmdable) error {
+		ret, err = m.getResource(c, key)
+		return err
+	})
+	return ret, err
+}
+
+// readAllResources reads every resource, from the replica if it may be used
+func (m *Redis) readAllResources() (ret map[string]*models.Resource, err error) {
+	err = m.read(func(c redis.Cmdable) error {
+		ret, err = m.getAllResources(c)
+		return err
+	})
+	return ret, err
+}
+
+// readQueue reads the queue of a resource, from the replica if it may be used
+func (m *Redis) readQueue(key string) (ret []*models.Reservation, err error) {
+	err = m.read(func(c redis.Cmdable) error {
+		ret, _, err = m.getQueue(c, key)
+		return err
+	})
+	return ret, err
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// sealedPrefix starts every encrypted payload, so that payloads stored before encryption was enabled
+	// can still be read
+	sealedPrefix = "sealed:v2:"
+	// unboundPrefix starts payloads encrypted before they were bound to where they are stored. They are
+	// still read, and are bound once they are next written.
+	unboundPrefix = "sealed:v1:"
+)
+
+// Sealer encrypts payloads with AES-GCM before they are stored, for organizations that treat who
+// reserved what as sensitive. Several keys can be given to rotate them: payloads are encrypted with the
+// first and decrypted with whichever key they were encrypted with.
+type Sealer struct {
+	aeads []cipher.AEAD
+}
+
+// NewSealer returns a sealer for keys given in base64, each of 16, 24 or 32 bytes for AES-128, AES-192 or
+// AES-256
+func NewSealer(keys []string) (*Sealer, error) {
+	s := &Sealer{}
+	for i, k := range keys {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+		if err != nil {
+			return nil, fmt.Errorf("key %d isn't base64: %v", i+1, err)
+		}
+		block, err := aes.NewCipher(b)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i+1, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	if len(s.aeads) == 0 {
+		return nil, errors.New("no keys were given")
+	}
+	return s, nil
+}
+
+// Seal encrypts a payload with the first key. The payload is bound to the field it is stored in, so it
+// can't be opened if it is moved to another.
+func (s *Sealer) Seal(b []byte, field string) (string, error) {
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, b, []byte(field))), nil
+}
+
+// Open decrypts a payload sealed with any of the keys for the field it is stored in
+func (s *Sealer) Open(str, field string) ([]byte, error) {
+	data := []byte(field)
+	if strings.HasPrefix(str, unboundPrefix) {
+		data = nil
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimPrefix(str, sealedPrefix), unboundPrefix))
+	if err != nil {
+		return nil, err
+	}
+	for _, aead := range s.aeads {
+		if len(b) < aead.NonceSize() {
+			break
+		}
+		if plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], data); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, errors.New("the payload wasn't encrypted with any of the keys")
+}
+
+// sealed returns if a stored payload is encrypted
+func sealed(str string) bool {
+	return strings.HasPrefix(str, sealedPrefix) || strings.HasPrefix(str, unboundPrefix)
+}
@@ -0,0 +1,165 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// newKey returns a random AES-256 key in base64
+func newKey(t *testing.T) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func newSealer(t *testing.T, keys ...string) *Sealer {
+	s, err := NewSealer(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSealOpen(t *testing.T) {
+	s := newSealer(t, newKey(t))
+	field := hashField(resourcesKey, "dev|db")
+
+	str, err := s.Seal([]byte(`{"name":"db"}`), field)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sealed(str) || strings.Contains(str, "db") {
+		t.Errorf("Seal returned %q, expected an encrypted payload", str)
+	}
+	again, err := s.Seal([]byte(`{"name":"db"}`), field)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == str {
+		t.Errorf("Seal returned %q twice, expected a new nonce each time", str)
+	}
+
+	b, err := s.Open(str, field)
+	if err != nil || string(b) != `{"name":"db"}` {
+		t.Errorf("Open returned %q, %v, expected the sealed payload", b, err)
+	}
+
+	// a payload copied to another resource's field can't be opened there
+	for _, other := range []string{hashField(resourcesKey, "dev|api"), hashField(snapshotsKey, "dev|db"), queueKey("dev|db")} {
+		if b, err := s.Open(str, other); err == nil {
+			t.Errorf("Open(%q) returned %q for a payload sealed for %q, expected an error", other, b, field)
+		}
+	}
+
+	tampered := []byte(str)
+	if i := len(sealedPrefix) + 20; tampered[i] == 'A' {
+		tampered[i] = 'B'
+	} else {
+		tampered[i] = 'A'
+	}
+	if b, err := s.Open(string(tampered), field); err == nil {
+		t.Errorf("Open returned %q for a tampered payload, expected an error", b)
+	}
+	if b, err := s.Open(sealedPrefix+"AAAA", field); err == nil {
+		t.Errorf("Open returned %q for a truncated payload, expected an error", b)
+	}
+}
+
+func TestSealerRotation(t *testing.T) {
+	oldKey, newKey := newKey(t), newKey(t)
+	field := hashField(userTokensKey, "U1")
+
+	str, err := newSealer(t, oldKey).Seal([]byte("token"), field)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := newSealer(t, newKey, oldKey)
+	if b, err := rotated.Open(str, field); err != nil || string(b) != "token" {
+		t.Errorf("Open returned %q, %v for a payload sealed with an old key, expected token", b, err)
+	}
+	resealed, err := rotated.Seal([]byte("token"), field)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := newSealer(t, newKey).Open(resealed, field); err != nil || string(b) != "token" {
+		t.Errorf("Open returned %q, %v, expected payloads to be sealed with the first key", b, err)
+	}
+
+	if b, err := newSealer(t, newKey).Open(str, field); err == nil {
+		t.Errorf("Open returned %q after the key was dropped, expected an error", b)
+	}
+}
+
+func TestSealerUnbound(t *testing.T) {
+	key := newKey(t)
+	raw, _ := base64.StdEncoding.DecodeString(key)
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	str := unboundPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte("token"), nil))
+
+	if b, err := newSealer(t, key).Open(str, hashField(userTokensKey, "U1")); err != nil || string(b) != "token" {
+		t.Errorf("Open returned %q, %v for a payload sealed before payloads were bound, expected token", b, err)
+	}
+}
+
+func TestNewSealer(t *testing.T) {
+	for _, keys := range [][]string{
+		{},
+		{"not base64!"},
+		{base64.StdEncoding.EncodeToString([]byte("too short"))},
+	} {
+		if _, err := NewSealer(keys); err == nil {
+			t.Errorf("NewSealer(%q) returned no error", keys)
+		}
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	field := hashField(resourcesKey, "dev|db")
+	m := &Redis{}
+	v := map[string]string{}
+
+	// payloads stored before encryption was enabled are read as they are
+	if err := m.decode(field, `{"name":"db"}`, &v); err != nil || v["name"] != "db" {
+		t.Errorf("decode returned %v, %v for a plaintext payload, expected it to be read", v, err)
+	}
+
+	m.Encrypt(newSealer(t, newKey(t)))
+	v = map[string]string{}
+	if err := m.decode(field, `{"name":"db"}`, &v); err != nil || v["name"] != "db" {
+		t.Errorf("decode returned %v, %v for a plaintext payload with encryption enabled, expected it to be read", v, err)
+	}
+
+	str, err := m.encode(field, map[string]string{"name": "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sealed(str) {
+		t.Errorf("encode returned %q, expected it to be encrypted", str)
+	}
+	v = map[string]string{}
+	if err := m.decode(field, str, &v); err != nil || v["name"] != "api" {
+		t.Errorf("decode returned %v, %v, expected the encoded payload", v, err)
+	}
+
+	if err := (&Redis{}).decode(field, str, &v); err == nil {
+		t.Errorf("decode returned no error for an encrypted payload without a key")
+	}
+}
@@ -1,12 +1,107 @@
 package err
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
+
+// Error is an error from the catalog below. Its code and name are what operators see in logs, while its
+// message is written for the user who ran into it.
+type Error struct {
+	// Code identifies the error to users and in logs, e.g. RES-004. Codes are never reused.
+	Code string
+	// Name identifies the error to operators, e.g. ALREADY_IN_QUEUE
+	Name string
+	// Message is what users are told, e.g. "you're already in the queue"
+	Message string
+	// Cause is the error that led to this one, if any
+	Cause error
+}
 
 var (
-	AlreadyInQueue        = errors.New("ALREADY_IN_QUEUE")
-	EnvDoesNotExist       = errors.New("ENV_DOES_NOT_EXIST")
-	InvalidResourceFormat = errors.New("INVALID_RESOURCE_FORMAT")
-	NoResourceProvided    = errors.New("NO_RESOURCE_PROVIDED")
-	NotInQueue            = errors.New("NOT_IN_QUEUE")
-	ResourceDoesNotExist  = errors.New("RESOURCE_DOES_NOT_EXIST")
+	ResourceDoesNotExist = &Error{Code: "RES-001", Name: "RESOURCE_DOES_NOT_EXIST", Message: "that resource doesn't exist"}
+	EnvDoesNotExist      = &Error{Code: "RES-002", Name: "ENV_DOES_NOT_EXIST", Message: "that environment doesn't exist"}
+	NotInQueue           = &Error{Code: "RES-003", Name: "NOT_IN_QUEUE", Message: "you aren't in the queue"}
+	AlreadyInQueue       = &Error{Code: "RES-004", Name: "ALREADY_IN_QUEUE", Message: "you're already in the queue"}
+	InMaintenance        = &Error{Code: "RES-005", Name: "IN_MAINTENANCE", Message: "that resource is under maintenance"}
+
+	InvalidResourceFormat = &Error{Code: "REQ-001", Name: "INVALID_RESOURCE_FORMAT", Message: "resources must be formatted as `<env>|<name>`"}
+	NoResourceProvided    = &Error{Code: "REQ-002", Name: "NO_RESOURCE_PROVIDED", Message: "you must specify a resource"}
+	InvalidDuration       = &Error{Code: "REQ-003", Name: "INVALID_DURATION", Message: "durations must be formatted like `30m` or `2h`"}
+	AmbiguousResource     = &Error{Code: "REQ-004", Name: "AMBIGUOUS_RESOURCE", Message: "that resource is in several envs, so give its env like `<env>|<name>`"}
+
+	ServiceAccountDoesNotExist = &Error{Code: "SVC-001", Name: "SERVICE_ACCOUNT_DOES_NOT_EXIST", Message: "that service account doesn't exist"}
+	APIKeyDoesNotExist         = &Error{Code: "SVC-002", Name: "API_KEY_DOES_NOT_EXIST", Message: "that API key doesn't exist"}
+
+	ScheduledActionDoesNotExist = &Error{Code: "SCH-001", Name: "SCHEDULED_ACTION_DOES_NOT_EXIST", Message: "that scheduled command already ran or was cancelled"}
+
+	Conflict = &Error{Code: "SYS-001", Name: "CONFLICT", Message: "someone else changed that at the same time, please try again"}
+	Injected = &Error{Code: "SYS-002", Name: "INJECTED_FAULT", Message: "something went wrong, please try again"}
 )
+
+// Catalog lists every error, in code order
+var Catalog = []*Error{
+	ResourceDoesNotExist,
+	EnvDoesNotExist,
+	NotInQueue,
+	AlreadyInQueue,
+	InMaintenance,
+	InvalidResourceFormat,
+	NoResourceProvided,
+	InvalidDuration,
+	AmbiguousResource,
+	ServiceAccountDoesNotExist,
+	APIKeyDoesNotExist,
+	ScheduledActionDoesNotExist,
+	Conflict,
+	Injected,
+}
+
+// Error returns the operator-facing form of the error, which starts with its code and name so that logs
+// can be searched for them
+func (x *Error) Error() string {
+	s := x.Code + " " + x.Name
+	if x.Cause != nil {
+		s += ": " + x.Cause.Error()
+	}
+	return s
+}
+
+func (x *Error) Unwrap() error {
+	return x.Cause
+}
+
+// Is matches errors by code, so that errors.Is finds a catalog error however it was detailed or wrapped
+func (x *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == x.Code
+}
+
+// Wrap returns a copy of the error caused by another
+func (x *Error) Wrap(cause error) *Error {
+	c := *x
+	c.Cause = cause
+	return &c
+}
+
+// Withf returns a copy of the error with a more specific message for users
+func (x *Error) Withf(format string, args ...interface{}) *Error {
+	c := *x
+	c.Message = fmt.Sprintf(format, args...)
+	return &c
+}
+
+// User returns the user-facing form of the error, e.g. "RES-004: you're already 3rd in the queue for dev|db"
+func (x *Error) User() string {
+	return x.Code + ": " + x.Message
+}
+
+// Message returns what a user should be told about an error. Errors from the catalog are told with their
+// code and message, while any other error is told as is.
+func Message(e error) string {
+	var x *Error
+	if errors.As(e, &x) {
+		return x.User()
+	}
+	return e.Error()
+}
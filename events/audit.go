@@ -0,0 +1,28 @@
+package events
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Audit is a subscriber that records every event in the log
+func Audit(ev Event) {
+	fields := log.Fields{
+		"event": ev.Type,
+	}
+	if ev.Resource != nil {
+		fields["resource"] = ev.Resource.String()
+	}
+	if ev.Reservation != nil {
+		fields["user"] = ev.Reservation.User.ID
+		fields["reservation"] = ev.Reservation.ID
+		fields["position"] = ev.Position
+		if len(ev.Reservation.Checked) > 0 {
+			fields["checked"] = ev.Reservation.Checked
+		}
+	}
+	if ev.Previous != nil {
+		fields["previous_user"] = ev.Previous.User.ID
+	}
+
+	log.WithFields(fields).Info("audit")
+}
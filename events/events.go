@@ -0,0 +1,101 @@
+// Package events lets the side effects of reservation changes, such as notifications, webhooks, metrics
+// and the audit log, be added without touching the code that makes the changes. Changes to the data are
+// published to a Bus as events, which are delivered to every subscriber.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// Type identifies what happened
+type Type string
+
+const (
+	// Reserved is published when a user joins the queue for a resource
+	Reserved Type = "reserved"
+	// Released is published when a reservation leaves a queue, whether it was released, removed,
+	// kicked, expired or cleared
+	Released Type = "released"
+	// QueueAdvanced is published when the next user in the queue becomes the holder of a resource
+	QueueAdvanced Type = "queue_advanced"
+	// Transferred is published when a reservation is handed to another user, who takes its place in the
+	// queue
+	Transferred Type = "transferred"
+	// Labeled is published when the holder of a resource sets or clears the label of its state
+	Labeled Type = "labeled"
+	// Progressed is published when the holder of a resource posts or clears a note on how their work
+	// is going
+	Progressed Type = "progressed"
+	// ResourcePruned is published when an inactive resource is removed by pruning
+	ResourcePruned Type = "resource_pruned"
+)
+
+// Event describes a change to the data
+type Event struct {
+	Type     Type
+	Time     time.Time
+	Resource *models.Resource
+	// Reservation is the reservation that was made or released, or the new holder's reservation when
+	// the queue advances
+	Reservation *models.Reservation
+	// Previous is the reservation that held the resource before the queue advanced, or the reservation
+	// that was handed on
+	Previous *models.Reservation
+	// Position is the one-based position the reservation joined or left the queue at
+	Position int
+}
+
+// EndedHold returns the reservation whose hold on the resource ended with the event, if any. Nobody
+// holds a resource awaiting a draw, so no hold ends while it is drawn for.
+func (ev Event) EndedHold() *models.Reservation {
+	if ev.Resource == nil || ev.Resource.Drawing() {
+		return nil
+	}
+	switch {
+	case ev.Type == Released && ev.Position == 1:
+		return ev.Reservation
+	case ev.Type == QueueAdvanced, ev.Type == Transferred && ev.Position == 1:
+		return ev.Previous
+	}
+	return nil
+}
+
+// Subscriber is called with every event published to a bus. Subscribers are called synchronously, so
+// any that do slow work must hand it off to their own goroutine.
+type Subscriber func(ev Event)
+
+// Bus delivers published events to its subscribers. It is safe for concurrent use.
+type Bus struct {
+	lock        sync.RWMutex
+	subscribers []Subscriber
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe adds a subscriber, which receives events published from then on
+func (b *Bus) Subscribe(s Subscriber) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.subscribers = append(b.subscribers, s)
+}
+
+// Publish delivers an event to every subscriber in the order they subscribed
+func (b *Bus) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.lock.RLock()
+	subscribers := b.subscribers
+	b.lock.RUnlock()
+
+	for _, s := range subscribers {
+		s(ev)
+	}
+}
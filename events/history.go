@@ -0,0 +1,74 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// History is a subscriber that remembers the most recent events. It is kept in memory, so it starts
+// empty whenever the bot restarts.
+type History struct {
+	lock   sync.RWMutex
+	size   int
+	events []Event
+}
+
+// NewHistory returns a history that keeps up to size events
+func NewHistory(size int) *History {
+	return &History{
+		size: size,
+	}
+}
+
+// Handle is the history's subscriber
+func (h *History) Handle(ev Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.events = append(h.events, ev)
+	if len(h.events) > h.size {
+		h.events = append(h.events[:0], h.events[len(h.events)-h.size:]...)
+	}
+}
+
+// Events returns the remembered events, newest first
+func (h *History) Events() []Event {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	ret := make([]Event, 0, len(h.events))
+	for i := len(h.events) - 1; i >= 0; i-- {
+		ret = append(ret, h.events[i])
+	}
+	return ret
+}
+
+// Forget drops the remembered events about a user's reservations, returning how many were dropped
+func (h *History) Forget(userID string) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	kept := h.events[:0]
+	for _, ev := range h.events {
+		if (ev.Reservation != nil && ev.Reservation.User.ID == userID) || (ev.Previous != nil && ev.Previous.User.ID == userID) {
+			continue
+		}
+		kept = append(kept, ev)
+	}
+	n := len(h.events) - len(kept)
+	h.events = kept
+	return n
+}
+
+// Trim drops the remembered events from before a time, returning how many were dropped
+func (h *History) Trim(before time.Time) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	n := 0
+	for n < len(h.events) && h.events[n].Time.Before(before) {
+		n++
+	}
+	h.events = append(h.events[:0], h.events[n:]...)
+	return n
+}
@@ -0,0 +1,239 @@
+package events
+
+import (
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// Manager wraps a data.Manager and publishes an event for each change it makes. The state before and
+// after a change is read separately from the change itself, so under concurrent changes to the same
+// resource an event may describe a neighbouring change slightly differently than it happened.
+type Manager struct {
+	data.Manager
+	bus *Bus
+}
+
+func NewManager(m data.Manager, bus *Bus) *Manager {
+	return &Manager{
+		Manager: m,
+		bus:     bus,
+	}
+}
+
+func (m *Manager) Reserve(u *models.User, name, env string) error {
+	if err := m.Manager.Reserve(u, name, env); err != nil {
+		return err
+	}
+
+	res := m.Manager.GetReservation(u, name, env)
+	pos, err := m.Manager.GetPosition(u, name, env)
+	if res == nil || err != nil {
+		// the reservation was removed again before it could be read
+		return nil
+	}
+	m.bus.Publish(Event{
+		Type:        Reserved,
+		Resource:    res.Resource,
+		Reservation: res,
+		Position:    pos,
+	})
+
+	return nil
+}
+
+func (m *Manager) Remove(u *models.User, name, env string) error {
+	before, _ := m.Manager.GetQueueForResource(name, env)
+	if err := m.Manager.Remove(u, name, env); err != nil {
+		return err
+	}
+	if before == nil {
+		return nil
+	}
+
+	for i, res := range before.Reservations {
+		if res.User.ID != u.ID {
+			continue
+		}
+		m.bus.Publish(Event{
+			Type:        Released,
+			Resource:    before.Resource,
+			Reservation: res,
+			Position:    i + 1,
+		})
+
+		if i == 0 {
+			m.advanced(name, env, res)
+		}
+		break
+	}
+
+	return nil
+}
+
+func (m *Manager) Requeue(u *models.User, name, env string) error {
+	before, _ := m.Manager.GetReservationForResource(name, env)
+	if err := m.Manager.Requeue(u, name, env); err != nil {
+		return err
+	}
+
+	// the queue only advances if the holder moved behind someone
+	if before != nil && before.User.ID == u.ID {
+		if next, _ := m.Manager.GetReservationForResource(name, env); next != nil && next.User.ID != u.ID {
+			m.advanced(name, env, before)
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) Promote(u *models.User, name, env string) error {
+	before, _ := m.Manager.GetReservationForResource(name, env)
+	if err := m.Manager.Promote(u, name, env); err != nil {
+		return err
+	}
+
+	if before != nil && before.User.ID != u.ID {
+		m.advanced(name, env, before)
+	}
+
+	return nil
+}
+
+func (m *Manager) Transfer(from, to *models.User, name, env string) error {
+	before, _ := m.Manager.GetQueueForResource(name, env)
+	if err := m.Manager.Transfer(from, to, name, env); err != nil {
+		return err
+	}
+	if before == nil {
+		return nil
+	}
+
+	idx, other := -1, -1
+	for i, res := range before.Reservations {
+		switch res.User.ID {
+		case from.ID:
+			idx = i
+		case to.ID:
+			other = i
+		}
+	}
+	if idx == -1 {
+		return nil
+	}
+	if other != -1 {
+		// whichever place is later is left
+		left := other
+		if other < idx {
+			left = idx
+		}
+		m.bus.Publish(Event{
+			Type:        Released,
+			Resource:    before.Resource,
+			Reservation: before.Reservations[left],
+			Position:    left + 1,
+		})
+		if left == idx {
+			return nil
+		}
+	}
+
+	res := m.Manager.GetReservation(to, name, env)
+	if res == nil {
+		return nil
+	}
+	m.bus.Publish(Event{
+		Type:        Transferred,
+		Resource:    res.Resource,
+		Reservation: res,
+		Previous:    before.Reservations[idx],
+		Position:    idx + 1,
+	})
+
+	return nil
+}
+
+func (m *Manager) ClearQueueForResource(name, env string) error {
+	before, _ := m.Manager.GetQueueForResource(name, env)
+	if err := m.Manager.ClearQueueForResource(name, env); err != nil {
+		return err
+	}
+	if before == nil {
+		return nil
+	}
+
+	for i, res := range before.Reservations {
+		m.bus.Publish(Event{
+			Type:        Released,
+			Resource:    before.Resource,
+			Reservation: res,
+			Position:    i + 1,
+		})
+	}
+
+	return nil
+}
+
+func (m *Manager) PruneInactiveResources(hours int) error {
+	before := m.Manager.GetResources()
+	if err := m.Manager.PruneInactiveResources(hours); err != nil {
+		return err
+	}
+
+	remaining := map[string]bool{}
+	for _, r := range m.Manager.GetResources() {
+		remaining[r.Key()] = true
+	}
+	for _, r := range before {
+		if !remaining[r.Key()] {
+			m.bus.Publish(Event{
+				Type:     ResourcePruned,
+				Resource: r,
+			})
+		}
+	}
+
+	return nil
+}
+
+// UpdateReservation publishes Labeled when the holder of a resource changes its label, and Progressed when
+// they post or clear a progress note
+func (m *Manager) UpdateReservation(res *models.Reservation) error {
+	before, _ := m.Manager.GetReservationForResource(res.Resource.Name, res.Resource.Env)
+	if err := m.Manager.UpdateReservation(res); err != nil {
+		return err
+	}
+
+	if before != nil && before.User.ID == res.User.ID && before.Label != res.Label {
+		m.bus.Publish(Event{
+			Type:        Labeled,
+			Resource:    before.Resource,
+			Reservation: res,
+			Position:    1,
+		})
+	}
+	if before != nil && before.User.ID == res.User.ID && !before.ProgressAt.Equal(res.ProgressAt) {
+		m.bus.Publish(Event{
+			Type:        Progressed,
+			Resource:    before.Resource,
+			Reservation: res,
+			Position:    1,
+		})
+	}
+
+	return nil
+}
+
+// advanced publishes QueueAdvanced if someone holds the resource after the previous holder left
+func (m *Manager) advanced(name, env string, previous *models.Reservation) {
+	next, err := m.Manager.GetReservationForResource(name, env)
+	if err != nil || next == nil {
+		return
+	}
+	m.bus.Publish(Event{
+		Type:        QueueAdvanced,
+		Resource:    next.Resource,
+		Reservation: next,
+		Previous:    previous,
+		Position:    1,
+	})
+}
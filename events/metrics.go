@@ -0,0 +1,13 @@
+package events
+
+import (
+	"expvar"
+)
+
+// counts holds the number of events of each type, published at /debug/vars
+var counts = expvar.NewMap("reservebot_events")
+
+// Metrics is a subscriber that counts events by type
+func Metrics(ev Event) {
+	counts.Add(string(ev.Type), 1)
+}
@@ -0,0 +1,213 @@
+package events
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/api"
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// streamQueueSize is how many events may wait to be broadcast before new ones are dropped
+	streamQueueSize = 100
+	// streamClientBuffer is how many messages a client may fall behind before it is disconnected
+	streamClientBuffer = 50
+	// streamKeepalive is how often an idle stream sends a comment, so proxies don't close it
+	streamKeepalive = 30 * time.Second
+)
+
+// streamState is the holder and queue length of a resource
+type streamState struct {
+	Resource string `json:"resource"`
+	Holder   string `json:"holder,omitempty"`
+	Waiting  int    `json:"waiting"`
+}
+
+// streamPayload is the JSON sent for each event. It includes the resource's state when the event is
+// sent, so clients don't have to keep their own copy of each queue.
+type streamPayload struct {
+	webhookPayload
+	Holder  string `json:"holder,omitempty"`
+	Waiting int    `json:"waiting"`
+}
+
+// Stream broadcasts events to HTTP clients as server-sent events. Events are broadcast in order by a
+// single worker, and a client that can't keep up is disconnected rather than delaying the others.
+type Stream struct {
+	data  data.Manager
+	queue chan Event
+
+	lock    sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+func NewStream(m data.Manager) *Stream {
+	return &Stream{
+		data:    m,
+		queue:   make(chan Event, streamQueueSize),
+		clients: map[chan []byte]struct{}{},
+	}
+}
+
+// Handle is the stream's subscriber. Events are dropped if the queue is full.
+func (s *Stream) Handle(ev Event) {
+	select {
+	case s.queue <- ev:
+	default:
+		log.Warnf("Stream queue is full, dropping %s event", ev.Type)
+	}
+}
+
+// Run broadcasts queued events. It blocks forever and is intended to be run in its own goroutine.
+func (s *Stream) Run() {
+	for ev := range s.queue {
+		p := streamPayload{
+			webhookPayload: newWebhookPayload(ev),
+		}
+		if ev.Resource != nil {
+			st := s.state(ev.Resource)
+			p.Holder = st.Holder
+			p.Waiting = st.Waiting
+		}
+
+		msg, err := sseMessage(string(ev.Type), p)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		s.broadcast(msg)
+	}
+}
+
+// state returns the current state of a resource. A resource that no longer exists has no holder.
+func (s *Stream) state(r *models.Resource) streamState {
+	st := streamState{
+		Resource: r.String(),
+	}
+	q, err := s.data.GetQueueForResource(r.Name, r.Env)
+	if err != nil {
+		return st
+	}
+	return newStreamState(q)
+}
+
+func newStreamState(q *models.Queue) streamState {
+	st := streamState{
+		Resource: q.Resource.String(),
+	}
+	if len(q.Reservations) > 0 {
+		st.Holder = q.Reservations[0].User.ID
+		st.Waiting = len(q.Reservations) - 1
+	}
+	return st
+}
+
+func (s *Stream) broadcast(msg []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for c := range s.clients {
+		select {
+		case c <- msg:
+		default:
+			// the client is too far behind, so close its stream and let it reconnect
+			delete(s.clients, c)
+			close(c)
+		}
+	}
+}
+
+func (s *Stream) subscribe() chan []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c := make(chan []byte, streamClientBuffer)
+	s.clients[c] = struct{}{}
+	return c
+}
+
+func (s *Stream) unsubscribe(c chan []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		close(c)
+	}
+}
+
+// Handler returns an HTTP handler streaming events as server-sent events. A snapshot event with the
+// state of every resource is sent first. Requests must present the secret in the X-Reservebot-Secret
+// header, or in the token query parameter for browsers, whose EventSource can't set headers.
+func (s *Stream) Handler(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get(api.SecretHeader)
+		if given == "" {
+			given = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		// subscribe before the snapshot is taken so no change is missed in between
+		c := s.subscribe()
+		defer s.unsubscribe(c)
+
+		snapshot := []streamState{}
+		for _, q := range s.data.GetQueues() {
+			snapshot = append(snapshot, newStreamState(q))
+		}
+		msg, err := sseMessage("snapshot", snapshot)
+		if err != nil {
+			log.Errorf("%+v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(msg)
+		flusher.Flush()
+
+		keepalive := time.NewTicker(streamKeepalive)
+		defer keepalive.Stop()
+
+		for {
+			select {
+			case msg, ok := <-c:
+				if !ok {
+					return
+				}
+				w.Write(msg)
+			case <-keepalive.C:
+				w.Write([]byte(": keepalive\n\n"))
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// sseMessage formats a server-sent event with a JSON body
+func sseMessage(event string, v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, body)), nil
+}
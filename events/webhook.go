@@ -0,0 +1,105 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// webhookQueueSize is how many events may wait to be sent before new ones are dropped
+	webhookQueueSize = 100
+	// webhookTimeout limits how long a single delivery may take
+	webhookTimeout = 10 * time.Second
+)
+
+// webhookPayload is the JSON body posted for each event
+type webhookPayload struct {
+	Event         Type      `json:"event"`
+	Time          time.Time `json:"time"`
+	Resource      string    `json:"resource,omitempty"`
+	ReservationID string    `json:"reservation_id,omitempty"`
+	User          string    `json:"user,omitempty"`
+	Position      int       `json:"position,omitempty"`
+	PreviousUser  string    `json:"previous_user,omitempty"`
+	// Label is the state the reservation's holder labeled the resource with, if any
+	Label string `json:"label,omitempty"`
+	// Progress is the last progress note the reservation's holder posted, if any
+	Progress string `json:"progress,omitempty"`
+}
+
+// Webhook posts events as JSON to a URL. Events are sent in order by a single worker, so a slow
+// endpoint never delays the bot.
+type Webhook struct {
+	url    string
+	client *http.Client
+	queue  chan Event
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan Event, webhookQueueSize),
+	}
+}
+
+// Handle is the webhook's subscriber. Events are dropped if the queue is full.
+func (w *Webhook) Handle(ev Event) {
+	select {
+	case w.queue <- ev:
+	default:
+		log.Warnf("Webhook queue is full, dropping %s event", ev.Type)
+	}
+}
+
+// Run delivers queued events. It blocks forever and is intended to be run in its own goroutine.
+func (w *Webhook) Run() {
+	for ev := range w.queue {
+		if err := w.send(ev); err != nil {
+			log.Errorf("Error sending %s webhook: %+v", ev.Type, err)
+		}
+	}
+}
+
+func newWebhookPayload(ev Event) webhookPayload {
+	p := webhookPayload{
+		Event:    ev.Type,
+		Time:     ev.Time,
+		Position: ev.Position,
+	}
+	if ev.Resource != nil {
+		p.Resource = ev.Resource.String()
+	}
+	if ev.Reservation != nil {
+		p.ReservationID = ev.Reservation.ID
+		p.User = ev.Reservation.User.ID
+		p.Label = ev.Reservation.Label
+		p.Progress = ev.Reservation.Progress
+	}
+	if ev.Previous != nil {
+		p.PreviousUser = ev.Previous.User.ID
+	}
+	return p
+}
+
+func (w *Webhook) send(ev Event) error {
+	body, err := json.Marshal(newWebhookPayload(ev))
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
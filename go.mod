@@ -4,8 +4,9 @@ go 1.14
 
 require (
 	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/graphql-go/graphql v0.8.1
 	github.com/pkg/errors v0.8.0 // indirect
-	github.com/redis/go-redis/v9 v9.6.1 // indirect
+	github.com/redis/go-redis/v9 v9.6.1
 	github.com/sirupsen/logrus v1.5.0
 	github.com/slack-go/slack v0.12.1
 )
@@ -0,0 +1,57 @@
+package gql
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ameliagapin/reservebot/api"
+	"github.com/graphql-go/graphql"
+	log "github.com/sirupsen/logrus"
+)
+
+// request is a GraphQL request as sent by standard GraphQL clients
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Handler returns an HTTP handler executing GraphQL queries against the schema. Queries may be sent as
+// a JSON body with POST, or as the query parameter with GET. Requests must present the secret in the
+// X-Reservebot-Secret header.
+func Handler(schema graphql.Schema, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(api.SecretHeader)), []byte(secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		req := &request{}
+		switch r.Method {
+		case http.MethodGet:
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				http.Error(w, "body must be a JSON GraphQL request", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Errorf("%+v", err)
+		}
+	})
+}
@@ -0,0 +1,309 @@
+// Package gql serves a read-only GraphQL API over resources, queues, reservations and recent history,
+// for dashboards that need more than the Slack commands show.
+package gql
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+var userType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id":       &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"name":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"external": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Description: "Set for CI jobs and other users outside Slack"},
+	},
+})
+
+var ticketType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Ticket",
+	Fields: graphql.Fields{
+		"id":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"url":     &graphql.Field{Type: graphql.String},
+		"summary": &graphql.Field{Type: graphql.String},
+	},
+})
+
+var reservationType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Reservation",
+	Fields: graphql.Fields{
+		"id":         &graphql.Field{Type: graphql.ID},
+		"resource":   &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "The resource formatted as env|name"},
+		"user":       &graphql.Field{Type: graphql.NewNonNull(userType)},
+		"position":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "One-based position in the queue. The holder is 1."},
+		"since":      &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime), Description: "When the reservation was made, or when it began holding the resource"},
+		"expires":    &graphql.Field{Type: graphql.DateTime, Description: "When the holder's reservation runs out, if it has a duration"},
+		"deployment": &graphql.Field{Type: graphql.String},
+		"ticket":     &graphql.Field{Type: ticketType},
+	},
+})
+
+var resourceType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Resource",
+	Fields: graphql.Fields{
+		"name":             &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"env":              &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"key":              &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "The resource formatted as env|name"},
+		"emoji":            &graphql.Field{Type: graphql.String},
+		"source":           &graphql.Field{Type: graphql.String, Description: "What registered the resource, e.g. kubernetes. Empty for resources created in Slack."},
+		"unhealthy":        &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"underMaintenance": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"lastActivity":     &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		"holder":           &graphql.Field{Type: reservationType},
+		"queue":            &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(reservationType))), Description: "Every reservation, starting with the holder"},
+		"waiting":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var eventType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Event",
+	Fields: graphql.Fields{
+		"type":          &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "reserved, released, queue_advanced or resource_pruned"},
+		"time":          &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		"resource":      &graphql.Field{Type: graphql.String},
+		"reservationId": &graphql.Field{Type: graphql.ID},
+		"user":          &graphql.Field{Type: userType},
+		"position":      &graphql.Field{Type: graphql.Int},
+		"previousUser":  &graphql.Field{Type: userType},
+		"checked":       &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String)), Description: "The checklist items the holder checked off when releasing the resource"},
+	},
+})
+
+var pageInfoType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PageInfo",
+	Fields: graphql.Fields{
+		"endCursor":   &graphql.Field{Type: graphql.String, Description: "Pass as after to fetch the next page"},
+		"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+	},
+})
+
+// connection returns the type of a page of nodes
+func connection(name string, node *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: name,
+		Fields: graphql.Fields{
+			"nodes":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(node)))},
+			"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"pageInfo":   &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+		},
+	})
+}
+
+// page is a page of nodes, as returned by connection types
+type page struct {
+	Nodes      interface{} `json:"nodes"`
+	TotalCount int         `json:"totalCount"`
+	PageInfo   pageInfo    `json:"pageInfo"`
+}
+
+type pageInfo struct {
+	EndCursor   string `json:"endCursor"`
+	HasNextPage bool   `json:"hasNextPage"`
+}
+
+// pageArgs adds the pagination arguments to a field's arguments
+func pageArgs(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	args["first"] = &graphql.ArgumentConfig{Type: graphql.Int, Description: fmt.Sprintf("Page size, at most %d", maxPageSize), DefaultValue: defaultPageSize}
+	args["after"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "The endCursor of the previous page"}
+	return args
+}
+
+// paginate returns the bounds of the requested page of n nodes. Cursors are opaque offsets.
+func paginate(n int, args map[string]interface{}) (int, int, *pageInfo, error) {
+	first, _ := args["first"].(int)
+	if first <= 0 || first > maxPageSize {
+		return 0, 0, nil, fmt.Errorf("first must be between 1 and %d", maxPageSize)
+	}
+
+	start := 0
+	if after, _ := args["after"].(string); after != "" {
+		b, err := base64.StdEncoding.DecodeString(after)
+		if err != nil || !strings.HasPrefix(string(b), "offset:") {
+			return 0, 0, nil, fmt.Errorf("invalid cursor")
+		}
+		start, err = strconv.Atoi(strings.TrimPrefix(string(b), "offset:"))
+		if err != nil || start < 0 {
+			return 0, 0, nil, fmt.Errorf("invalid cursor")
+		}
+	}
+	if start > n {
+		start = n
+	}
+	end := start + first
+	if end > n {
+		end = n
+	}
+
+	info := &pageInfo{
+		EndCursor:   base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("offset:%d", end))),
+		HasNextPage: end < n,
+	}
+	return start, end, info, nil
+}
+
+// NewSchema returns the schema, reading from the data manager and history
+func NewSchema(m data.Manager, history *events.History) (graphql.Schema, error) {
+	r := &resolver{data: m, history: history}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"resource": &graphql.Field{
+				Type:        resourceType,
+				Description: "A single resource",
+				Args: graphql.FieldConfigArgument{
+					"key": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String), Description: "The resource formatted as env|name"},
+				},
+				Resolve: r.resource,
+			},
+			"resources": &graphql.Field{
+				Type:        graphql.NewNonNull(connection("ResourceConnection", resourceType)),
+				Description: "Resources ordered by env and name",
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"env":      &graphql.ArgumentConfig{Type: graphql.String},
+					"search":   &graphql.ArgumentConfig{Type: graphql.String, Description: "Only resources whose name contains this"},
+					"reserved": &graphql.ArgumentConfig{Type: graphql.Boolean, Description: "Only resources that are or aren't reserved"},
+				}),
+				Resolve: r.resources,
+			},
+			"reservations": &graphql.Field{
+				Type:        graphql.NewNonNull(connection("ReservationConnection", reservationType)),
+				Description: "Reservations ordered by resource and position",
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"env":     &graphql.ArgumentConfig{Type: graphql.String},
+					"user":    &graphql.ArgumentConfig{Type: graphql.ID, Description: "Only reservations by this Slack user ID"},
+					"holding": &graphql.ArgumentConfig{Type: graphql.Boolean, Description: "Only reservations that do or don't hold their resource"},
+				}),
+				Resolve: r.reservations,
+			},
+			"history": &graphql.Field{
+				Type:        graphql.NewNonNull(connection("EventConnection", eventType)),
+				Description: "Recent changes, newest first. History is kept in memory and starts empty when the bot restarts.",
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"type":     &graphql.ArgumentConfig{Type: graphql.String},
+					"resource": &graphql.ArgumentConfig{Type: graphql.String, Description: "The resource formatted as env|name"},
+					"user":     &graphql.ArgumentConfig{Type: graphql.ID},
+					"since":    &graphql.ArgumentConfig{Type: graphql.DateTime},
+				}),
+				Resolve: r.events,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query: query,
+	})
+}
+
+type resolver struct {
+	data    data.Manager
+	history *events.History
+}
+
+func (r *resolver) resource(p graphql.ResolveParams) (interface{}, error) {
+	key, _ := p.Args["key"].(string)
+	for _, q := range r.data.GetQueues() {
+		if q.Resource.String() == key {
+			return newResourceView(q), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *resolver) resources(p graphql.ResolveParams) (interface{}, error) {
+	env, hasEnv := p.Args["env"].(string)
+	search, _ := p.Args["search"].(string)
+	reserved, hasReserved := p.Args["reserved"].(bool)
+
+	views := []*resourceView{}
+	for _, q := range r.data.GetQueues() {
+		if hasEnv && q.Resource.Env != env {
+			continue
+		}
+		if search != "" && !strings.Contains(q.Resource.Name, search) {
+			continue
+		}
+		if hasReserved && q.HasReservations() != reserved {
+			continue
+		}
+		views = append(views, newResourceView(q))
+	}
+
+	start, end, info, err := paginate(len(views), p.Args)
+	if err != nil {
+		return nil, err
+	}
+	return &page{Nodes: views[start:end], TotalCount: len(views), PageInfo: *info}, nil
+}
+
+func (r *resolver) reservations(p graphql.ResolveParams) (interface{}, error) {
+	env, hasEnv := p.Args["env"].(string)
+	user, _ := p.Args["user"].(string)
+	holding, hasHolding := p.Args["holding"].(bool)
+
+	views := []*reservationView{}
+	for _, q := range r.data.GetQueues() {
+		if hasEnv && q.Resource.Env != env {
+			continue
+		}
+		for i, res := range q.Reservations {
+			if user != "" && res.User.ID != user {
+				continue
+			}
+			if hasHolding && (i == 0) != holding {
+				continue
+			}
+			views = append(views, newReservationView(res, q.Resource, i+1))
+		}
+	}
+
+	start, end, info, err := paginate(len(views), p.Args)
+	if err != nil {
+		return nil, err
+	}
+	return &page{Nodes: views[start:end], TotalCount: len(views), PageInfo: *info}, nil
+}
+
+func (r *resolver) events(p graphql.ResolveParams) (interface{}, error) {
+	typ, _ := p.Args["type"].(string)
+	resource, _ := p.Args["resource"].(string)
+	user, _ := p.Args["user"].(string)
+	since, hasSince := p.Args["since"].(time.Time)
+
+	views := []*eventView{}
+	for _, ev := range r.history.Events() {
+		v := newEventView(ev)
+		if typ != "" && v.Type != typ {
+			continue
+		}
+		if resource != "" && v.Resource != resource {
+			continue
+		}
+		if user != "" && (v.User == nil || v.User.ID != user) {
+			continue
+		}
+		if hasSince && v.Time.Before(since) {
+			continue
+		}
+		views = append(views, v)
+	}
+
+	start, end, info, err := paginate(len(views), p.Args)
+	if err != nil {
+		return nil, err
+	}
+	return &page{Nodes: views[start:end], TotalCount: len(views), PageInfo: *info}, nil
+}
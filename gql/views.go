@@ -0,0 +1,135 @@
+package gql
+
+import (
+	"time"
+
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// The views are the shapes returned by the schema. They are built from the models so that the schema
+// doesn't depend on how reservations are stored.
+
+type userView struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	External bool   `json:"external"`
+}
+
+type ticketView struct {
+	ID      string `json:"id"`
+	URL     string `json:"url"`
+	Summary string `json:"summary"`
+}
+
+type reservationView struct {
+	ID         string      `json:"id"`
+	Resource   string      `json:"resource"`
+	User       *userView   `json:"user"`
+	Position   int         `json:"position"`
+	Since      time.Time   `json:"since"`
+	Expires    *time.Time  `json:"expires"`
+	Deployment string      `json:"deployment"`
+	Ticket     *ticketView `json:"ticket"`
+}
+
+type resourceView struct {
+	Name             string             `json:"name"`
+	Env              string             `json:"env"`
+	Key              string             `json:"key"`
+	Emoji            string             `json:"emoji"`
+	Source           string             `json:"source"`
+	Unhealthy        bool               `json:"unhealthy"`
+	UnderMaintenance bool               `json:"underMaintenance"`
+	LastActivity     time.Time          `json:"lastActivity"`
+	Holder           *reservationView   `json:"holder"`
+	Queue            []*reservationView `json:"queue"`
+	Waiting          int                `json:"waiting"`
+}
+
+type eventView struct {
+	Type          string    `json:"type"`
+	Time          time.Time `json:"time"`
+	Resource      string    `json:"resource"`
+	ReservationID string    `json:"reservationId"`
+	User          *userView `json:"user"`
+	Position      int       `json:"position"`
+	PreviousUser  *userView `json:"previousUser"`
+	Checked       []string  `json:"checked"`
+}
+
+func newUserView(u *models.User) *userView {
+	if u == nil {
+		return nil
+	}
+	return &userView{
+		ID:       u.ID,
+		Name:     u.Name,
+		External: u.External,
+	}
+}
+
+func newReservationView(res *models.Reservation, r *models.Resource, pos int) *reservationView {
+	v := &reservationView{
+		ID:         res.ID,
+		Resource:   r.String(),
+		User:       newUserView(res.User),
+		Position:   pos,
+		Since:      res.Time,
+		Deployment: res.Deployment,
+	}
+	if exp := res.Expires(); !exp.IsZero() && pos == 1 {
+		v.Expires = &exp
+	}
+	if t := res.Ticket; t != nil {
+		v.Ticket = &ticketView{
+			ID:      t.ID,
+			URL:     t.URL,
+			Summary: t.Summary,
+		}
+	}
+	return v
+}
+
+func newResourceView(q *models.Queue) *resourceView {
+	r := q.Resource
+	v := &resourceView{
+		Name:             r.Name,
+		Env:              r.Env,
+		Key:              r.String(),
+		Emoji:            r.Emoji,
+		Source:           r.Source,
+		Unhealthy:        r.Unhealthy(),
+		UnderMaintenance: r.ActiveMaintenance(time.Now()) != nil,
+		LastActivity:     r.LastActivity,
+		Queue:            []*reservationView{},
+	}
+	for i, res := range q.Reservations {
+		v.Queue = append(v.Queue, newReservationView(res, r, i+1))
+	}
+	if len(v.Queue) > 0 {
+		v.Holder = v.Queue[0]
+		v.Waiting = len(v.Queue) - 1
+	}
+	return v
+}
+
+func newEventView(ev events.Event) *eventView {
+	v := &eventView{
+		Type:     string(ev.Type),
+		Time:     ev.Time,
+		Position: ev.Position,
+	}
+	if ev.Resource != nil {
+		v.Resource = ev.Resource.String()
+	}
+	if ev.Reservation != nil {
+		v.ReservationID = ev.Reservation.ID
+		v.User = newUserView(ev.Reservation.User)
+		v.Checked = ev.Reservation.Checked
+	}
+	if ev.Previous != nil {
+		v.PreviousUser = newUserView(ev.Previous.User)
+	}
+	return v
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const keepHoldAction = "keep_hold"
+
+// SetAbandonVoting lets people waiting for a resource vote that its holder abandoned it, by reacting with
+// emoji to a message of the bot's that mentions it, such as a status. Once votes of those waiting have
+// voted, the holder is asked by DM if they are still using it, and their reservation is released if they
+// don't say so within grace.
+func (h *Handler) SetAbandonVoting(votes int, emoji string, grace time.Duration) {
+	h.abandonVotes = votes
+	h.abandonEmoji = strings.Trim(emoji, ":")
+	h.abandonGrace = grace
+}
+
+// voteAbandoned adds or takes back a user's vote that the holders of the resources a message mentions
+// abandoned them, when they react to a message of the bot's. A vote only counts for the resources the
+// user is waiting for.
+func (h *Handler) voteAbandoned(userID, reaction, author string, item slackevents.Item, add bool) error {
+	if h.abandonVotes <= 0 || item.Type != "message" || author == "" || author != h.botID {
+		return nil
+	}
+	// reactions with a skin tone are named like thumbsup::skin-tone-2
+	if i := strings.Index(reaction, "::"); i != -1 {
+		reaction = reaction[:i]
+	}
+	if reaction != h.abandonEmoji {
+		return nil
+	}
+	if h.readOnly {
+		log.Infof("Read-only: would count the vote of %s on %s", userID, item.Timestamp)
+		return nil
+	}
+	u, err := h.getUser(userID)
+	if err != nil {
+		return err
+	}
+	text, err := h.messageText(item.Channel, item.Timestamp)
+	if err != nil || text == "" {
+		return err
+	}
+
+	for _, r := range h.mentionedResources(text) {
+		q, err := h.data.GetQueueForResource(r.Name, r.Env)
+		if err != nil || len(q.Reservations) < 2 || q.Reservations[0].User.External {
+			continue
+		}
+		holder := q.Reservations[0]
+		if holder.User.ID == u.ID || !inQueue(u, q) {
+			continue
+		}
+
+		votes, reached := 0, false
+		err = h.updateReservation(holder.User, r.Name, r.Env, func(res *models.Reservation) error {
+			res.AbandonVotes = withVote(res.AbandonVotes, u.ID, add)
+			votes = countVotes(res, q)
+			reached = votes >= h.abandonVotes && res.AbandonBy.IsZero()
+			if reached {
+				res.AbandonBy = time.Now().Add(h.abandonGrace)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		log.Infof("%s voted %s abandoned by %s, %d of %d votes", u.Name, r, holder.User.Name, votes, h.abandonVotes)
+		if reached {
+			h.askIfAbandoned(holder, r, votes, item)
+		}
+	}
+	return nil
+}
+
+// messageText returns the text of a message in a channel, or an empty string if it can't be found, such
+// as a reply in a thread
+func (h *Handler) messageText(channel, ts string) (string, error) {
+	resp, err := h.client.GetConversationHistory(&slack.GetConversationHistoryParameters{
+		ChannelID: channel,
+		Latest:    ts,
+		Inclusive: true,
+		Limit:     1,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Messages) == 0 || resp.Messages[0].Timestamp != ts {
+		return "", nil
+	}
+	return resp.Messages[0].Text, nil
+}
+
+// askIfAbandoned asks a holder voted out by those waiting if they are still using the resource, and lets
+// the voters know in a thread on the message they reacted to
+func (h *Handler) askIfAbandoned(holder *models.Reservation, r *models.Resource, votes int, item slackevents.Item) {
+	grace := durationText(h.abandonGrace)
+	text := fmt.Sprintf(msgNWaitingThinkYouAbandonedX, votes, h.resourceText(r), grace)
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(keepHoldAction, r.String(), slack.NewTextBlockObject(slack.PlainTextType, "I'm still using it", false, false)).WithStyle(slack.StylePrimary),
+		),
+	}
+	if err := h.sendDMBlocks(holder.User, text, blocks...); err != nil {
+		log.Errorf("%+v", err)
+	}
+
+	msg := fmt.Sprintf(msgXVotedAbandonedReleasedInY, h.getUserDisplay(holder.User, false), h.resourceText(r), grace)
+	if _, _, err := h.client.PostMessage(item.Channel, slack.MsgOptionText(msg, false), slack.MsgOptionTS(item.Timestamp)); err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// keepHoldAction handles a click on the button a holder voted out uses to say they are still using the
+// resource, which throws out the votes
+func (h *Handler) keepHoldAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	if h.readOnly {
+		log.Infof("Read-only: would keep %s for %s", action.Value, cb.User.ID)
+		return nil
+	}
+	res, err := h.parseResource(action.Value, "")
+	if err != nil || res == nil {
+		return err
+	}
+	u, err := h.getUser(cb.User.ID)
+	if err != nil {
+		return err
+	}
+
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if err != nil || !q.HasReservations() || q.Reservations[0].User.ID != u.ID {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgTooLateToKeepX, h.resourceText(res)))
+	}
+	err = h.updateReservation(u, res.Name, res.Env, func(res *models.Reservation) error {
+		res.AbandonVotes = nil
+		res.AbandonBy = time.Time{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("%s is still using %s", u.Name, res)
+	return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouKeptX, h.resourceText(res)))
+}
+
+// CheckAbandoned releases the resources of holders voted out by those waiting who didn't say they were
+// still using them in time. The holder is told here, and the next person in line by HandleEvent. If
+// voters took their votes back or left the line in the meantime, so that too few votes are left, the
+// holder keeps the resource.
+func (h *Handler) CheckAbandoned() {
+	if h.readOnly || h.sandbox || h.abandonVotes <= 0 {
+		return
+	}
+	now := time.Now()
+
+	for _, q := range h.data.GetQueues() {
+		r := q.Resource
+		if !q.HasReservations() || r.Drawing() || q.Resetting() {
+			continue
+		}
+		holder := q.Reservations[0]
+		if holder.AbandonBy.IsZero() || now.Before(holder.AbandonBy) {
+			continue
+		}
+
+		if countVotes(holder, q) < h.abandonVotes {
+			err := h.updateReservation(holder.User, r.Name, r.Env, func(res *models.Reservation) error {
+				res.AbandonBy = time.Time{}
+				return nil
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+			continue
+		}
+
+		if err := h.data.Remove(holder.User, r.Name, r.Env); err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		log.Infof("Released %s, as %s abandoned it", r, holder.User.Name)
+
+		h.notify(holder.User, fmt.Sprintf(msgYourHoldOnXWasAbandoned, h.resourceText(r)))
+	}
+}
+
+// abandonText returns the votes that the holder of a resource abandoned it, for status. An empty string
+// is returned if there are none.
+func (h *Handler) abandonText(q *models.Queue, loc *time.Location) string {
+	if h.abandonVotes <= 0 || !q.HasReservations() {
+		return ""
+	}
+	holder := q.Reservations[0]
+	if !holder.AbandonBy.IsZero() {
+		return fmt.Sprintf(" :%s: Voted abandoned, so it will be released at %s unless the holder is still using it.", h.abandonEmoji, holder.AbandonBy.In(loc).Format(hoursTimeFormat))
+	}
+	if n := countVotes(holder, q); n > 0 {
+		return fmt.Sprintf(" :%s: %d of %d votes to release it as abandoned.", h.abandonEmoji, n, h.abandonVotes)
+	}
+	return ""
+}
+
+// withVote adds or takes back a user's vote
+func withVote(votes []string, userID string, add bool) []string {
+	ret := []string{}
+	for _, v := range votes {
+		if v != userID {
+			ret = append(ret, v)
+		}
+	}
+	if add {
+		ret = append(ret, userID)
+	}
+	return ret
+}
+
+// countVotes returns how many votes against a holder are from people still waiting in the queue
+func countVotes(holder *models.Reservation, q *models.Queue) int {
+	n := 0
+	for _, v := range holder.AbandonVotes {
+		for _, res := range q.Reservations[1:] {
+			if res.User.ID == v {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
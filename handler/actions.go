@@ -1,9 +1,10 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ameliagapin/reservebot/data"
 	e "github.com/ameliagapin/reservebot/err"
@@ -15,96 +16,348 @@ import (
 const TICK = "`"
 
 var (
-	actions = map[string]regexp.Regexp{
-		"hello":          *regexp.MustCompile(`hello.+`),
-		"create":         *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\screate\s(.+)`),
-		"reserve":        *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sreserve\s(.+)`),
-		"release":        *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\srelease\s(.+)`),
-		"clear":          *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sclear\s(.+)`),
-		"kick_empty":     *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\skick$`),
-		"kick":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\skick\s\<\@([a-zA-Z0-9]+)\>`),
-		"kick_nonuser":   *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\skick\s(.+)`),
-		"removeme":       *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sremove\sme\sfrom\s(.+)`),
-		"removeresource": *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sremove\sresource\s(.+)`),
-		"all_status":     *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sstatus$`),
-		"single_status":  *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sstatus\s(.+)`),
-		"my_status":      *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\smy\sstatus`),
-		"nuke":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\snuke$`),
-		"prune":          *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sprune$`),
-		"help":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\shelp$`),
-
-		"create_dm":         *regexp.MustCompile(`(?m)^create\s(.+)`),
-		"reserve_dm":        *regexp.MustCompile(`(?m)^reserve\s(.+)`),
-		"release_dm":        *regexp.MustCompile(`(?m)^release\s(.+)`),
-		"clear_dm":          *regexp.MustCompile(`(?m)^clear\s(.+)`),
-		"kick_dm":           *regexp.MustCompile(`(?m)^kick\s\<\@([a-zA-Z0-9]+)\>`),
-		"removeme_dm":       *regexp.MustCompile(`(?m)^remove\sme\sfrom\s(.+)`),
-		"removeresource_dm": *regexp.MustCompile(`(?m)^remove\sresource\s(.+)`),
-		"all_status_dm":     *regexp.MustCompile(`(?m)^status$`),
-		"single_status_dm":  *regexp.MustCompile(`(?m)^status\s(.+)`),
-		"my_status_dm":      *regexp.MustCompile(`(?m)^my\sstatus`),
-		"nuke_dm":           *regexp.MustCompile(`(?m)^nuke$`),
-		"prune_dm":          *regexp.MustCompile(`(?m)^prune$`),
-		"help_dm":           *regexp.MustCompile(`(?m)^help$`),
-	}
-)
-
-var (
+	msgAPIKeyUsage                  = "Usage: `api-key <create <name> <read|reserve|admin> [requests per minute]|revoke <id>|list>`"
+	msgAPIKeyXSentByDM              = "I've sent you API key `%s` in a DM"
+	msgAlreadyHandled               = "I've already handled that request"
 	msgAlreadyInAllQueues           = "Bruh, you are already in all specified queues"
+	msgAskedXToTakeOverY            = "I asked %s to take over %s. I'll let you know when they answer."
+	msgAttachACSVFileToImport       = "Attach a CSV file to the message, in a DM with me, to import resources from it."
+	msgBatchCommandXY               = "*%d.* `%s`"
+	msgBroadcastFromXToYZ           = ":mega: %s to everyone using %s: %s"
+	msgCheckOffBeforeReleasingY     = "Check off everything before releasing %s:"
+	msgCheckOffChecklistForY        = "%s has a checklist. Check it off in the DM I sent you to release it. Until then it stays yours."
+	msgCheckOffEverythingForY       = "Check off everything on the checklist for %s before releasing it"
+	msgClaimItWithinXOrItGoesOn     = "You seem to be away, so claim it within %s or it goes to the next person waiting."
+	msgClearEveryQueueInXConfirm    = "This will clear every queue in `%s`, releasing everyone in line for %s. Everyone in them will be told."
+	msgClearedTheLabelOfY           = "Cleared the label of %s"
+	msgClearedYourProgressOnX       = "Cleared your progress note on %s"
+	msgCommandUsageLineXYZ          = "`%s` %d runs, %d%% failed, %s on average, %s at most"
+	msgCommandUsageSinceX           = "Commands run since %s:"
+	msgConfirmClearingXInDM         = "I DMed you to confirm clearing every queue in `%s`"
+	msgCouldntDownloadX             = "I couldn't download %s."
+	msgCouldntImportXY              = "I couldn't import %s: %s."
+	msgCreatedAPIKeyXYZ             = "Created API key `%s` with the %s scope. The key is `%s`. It is only shown this once."
 	msgCreatedResource              = "Resource is created."
+	msgCreatedServiceAccountXY      = "Created service account `%s`. Its token is `%s`. It is only shown this once."
+	msgDeadlineForYPassed           = "You still don't have %s, which you needed by %s"
+	msgDeadlineForYPassedRemoved    = "You didn't get %s by %s, so I took you out of line"
+	msgDeletedServiceAccountX       = "Deleted service account `%s`"
+	msgDeletedServiceAccountXFromY  = "Deleted service account `%s` and removed it from %s"
+	msgDeploymentXOnYFinishedZ      = "Deployment `%s` on %s finished %s, so %s's reservation has been released"
+	msgDidYouMeanX                  = " Did you mean `%s`?"
+	msgDropNeedsDeadline            = "`--drop` needs a deadline given with `--by`"
+	msgEnvXHasNoResources           = "%s has no resources"
+	msgEnvsMustMatchX               = "envs must match `%s`"
+	msgForgetRetainedX              = "I can't delete these, so they must be handled separately: %s"
+	msgForgotDefaultEnv             = "• Deleted their default env"
+	msgForgotMilestonesOff          = "• Deleted their choice to not be told about milestones in line"
+	msgForgotNotificationsX         = "• Deleted DMs to them waiting to be delivered: %d"
+	msgForgotQueuesX                = "• Took them out of line for %s"
+	msgForgotRecordsWXYZ            = "• Deleted past holds: %d, usage records: %d, places in snapshots: %d, recent events: %d"
+	msgForgotResourcesX             = "• Removed them as a watcher, approver, owner or requester of %s"
+	msgForgotScheduledX             = "• Cancelled commands they had waiting to run later: %d"
+	msgForgotStatusFilter           = "• Deleted their status filter"
+	msgForgotToken                  = "• Deleted the Slack token they granted for status sync"
+	msgForgotX                      = "I have forgotten <@%s>:"
+	msgGrantStatusSyncX             = "To show what you hold in your Slack status, <%s|grant me permission to set it>. I won't replace or clear a status you set yourself."
+	msgHandedYToXForTheIncident     = "I handed %s to %s for the incident"
+	msgHealthCheckRemovedY          = "Health check for `%s` has been removed"
+	msgHealthCheckSetYZ             = "Health of `%s` will be checked at %s every %s"
+	msgICancelledYourXAtY           = "I cancelled putting you in line for %s at %s"
+	msgIDEStatusDisabled            = "The IDE status endpoint isn't enabled"
+	msgIDETokenSentByDM             = "I've sent you your IDE token in a DM"
 	msgIDontKnow                    = "I don't know what happened, but it wasn't good"
+	msgIgnoredXGivenYAgo            = "I ignored `%s`, as it reached me %s after you sent it, which is too late to act on safely. Send it again if you still want it."
+	msgIllPutYouInLineForXAtY       = "I'll put you in line for %s at %s. Release it before then to cancel."
+	msgIllWarnYouIfNotByX           = " I'll warn you if you're unlikely to get it by %s."
+	msgImportedNCreatedNUpdated     = "Imported resources: %d created, %d updated, %d unchanged, %d failed."
+	msgInvalidAPIKeyNameX           = "`%s` isn't a valid name. Use letters, digits, _, . and -"
+	msgInvalidApprovers             = "Approvers must be given as mentions like `@someone @someone-else`, or `none`"
+	msgInvalidChecklist             = "Checklists must list items separated by `;`, like `reset the db; clear feature flags`"
+	msgInvalidConflicts             = "Conflicts must be other resources, such as `staging|perf, staging|api`, or `none`"
+	msgInvalidCost                  = "Costs must be numbers per hour like `2.5`, or `none`"
+	msgInvalidDeadline              = "Deadlines must be future times like `15:00`, `3pm` or `2024-05-01T15:00`"
+	msgInvalidDefaultEnvX           = "`%s` isn't an env. Give the env alone, like `staging`, or `none`."
+	msgInvalidDuration              = "Durations must be formatted like `30m` or `2h`"
+	msgInvalidEmoji                 = "Emoji must be formatted like `:database:`"
+	msgInvalidHealthCheck           = "Health checks must be formatted as `health <resource> <url> [interval]` or `health <resource> off`. The interval must be at least `1m`"
+	msgInvalidHours                 = "Hours must be formatted like `mon-fri 09:00-18:00`, optionally followed by a timezone such as `Europe/Berlin`"
+	msgInvalidIncident              = "Incident must be `on` or `off`"
+	msgInvalidLiveStatus            = "Use `live status` to start a live status here, or `live status off` to stop it"
+	msgInvalidMaintenanceWindow     = "Maintenance must be formatted as `maintenance <resource> <start> <duration> [reason]`, where start is `now` or `YYYY-MM-DDTHH:MM` and duration is like `2h`"
+	msgInvalidMonth                 = "Months must be formatted like `2024-05`"
+	msgInvalidPolicy                = "Policies must be one of %s"
+	msgInvalidOwner                 = "Owners must be given as a mention like `@someone`, or `none`"
+	msgInvalidPrivate               = "Private must be `on` or `off`"
+	msgInvalidPriority              = "Priorities must be whole numbers like `10`"
+	msgInvalidRateLimitX            = "`%s` isn't a number of requests per minute"
+	msgInvalidScheduledTime         = "Times must be future times like `15:00`, `2pm` or `2024-05-01T15:00`"
+	msgInvalidScopeX                = "`%s` isn't a scope. Use `read`, `reserve` or `admin`"
+	msgInvalidServiceAccountX       = "`%s` isn't a valid name. Use letters, digits, _, . and -"
+	msgInvalidSeverityX             = "`%s` isn't a severity. Use a number counting up from 1, the most severe, like `1` or `SEV1`."
+	msgInvalidStatusFilterX         = "`%s` isn't a filter. Filter by `team:<team>` or `env:<env>`, separated by commas, or give `none`."
+	msgInvalidStatusSync            = "Status sync must be `on` or `off`"
+	msgInvalidURL                   = "URLs must start with `http://` or `https://`"
+	msgItCantBeReservedUntilFixed   = "It can't be reserved until it is marked fixed."
+	msgLabelsAreUpToN               = "Labels are up to %d characters"
+	msgLiveStatusNoLongerUpdated    = "_This live status is no longer updated. Use `status` to see the current status._"
+	msgLiveStatusOnlyInChannels     = "A live status can only be kept in a channel"
+	msgLiveStatusStopped            = "I stopped updating the live status in this channel"
+	msgLiveStatusUpdatedX           = "*Live status*, updated <!date^%d^{time}|%s>"
+	msgMaintenanceCancelledY        = "Maintenance for `%s` has been cancelled"
+	msgMaintenanceScheduledYZ       = "Maintenance for `%s` is scheduled %s"
+	msgMaintenanceWarningYZ         = "Heads up: %s is going down for maintenance %s"
+	msgMarkedYFixed                 = "Marked %s fixed after %s"
+	msgMentionTheCommander          = "Mention the incident commander, like `incident SEV1 @alice database down`"
+	msgMilestonesOnOrOff            = "Give `on` or `off`, like `settings milestones off`"
 	msgMustSpecifyResource          = "You must specify a resource"
-	msgMustSpecifyUser              = "You must specify a user to kick"
 	msgMustSpecifyValidResource     = "You must specify a valid resource"
 	msgMustUseReleaseForY           = "You cannot remove yourself from the queue for `%s` because you currently have it. Please use `release` instead."
 	msgMustUseRemoveForY            = "You cannot release `%s` because you do not currently have it. Please use `remove me from` instead."
+	msgNOfMInXAreFreeAgain          = ":white_check_mark: %d of %d resources in `%s` are free again"
+	msgNWaitingThinkYouAbandonedX   = "%d people waiting think you are done with %s. If you are still using it, say so within %s, or it will be released."
+	msgNamesMustMatchX              = "names must match `%s`"
+	msgNamingHintX                  = " Names should be %s."
+	msgNewTokenForXY                = "The new token for service account `%s` is `%s`. The old one no longer works."
+	msgNoAPIKeyX                    = "There is no API key `%s`"
+	msgNoAPIKeys                    = "There are no API keys"
+	msgNoCommandsRun                = "No commands have been run"
+	msgNoContentionSampled          = "No queues have been sampled yet"
+	msgNoHistory                    = "Nothing has changed since I started"
+	msgNoIncidentResourcesToTake    = "There are no incident resources to take"
+	msgNoLiveStatusHere             = "There is no live status in this channel"
+	msgNoQueuesToClearInX           = "Nobody holds or waits for anything in `%s`"
 	msgNoReservations               = "Like Anthony Bourdain :rip:, there are _no reservations_. Lose yourself in the freedom of a world waiting on your next move."
+	msgNoReservationsMatchingX      = "There are no reservations for resources matching `%s`"
+	msgNoResourcesInX               = "There are no resources in `%s`"
+	msgNoServiceAccountX            = "There is no service account named `%s`"
+	msgNoServiceAccounts            = "There are no service accounts"
+	msgNoSnapshotOfY                = "There is no snapshot of the queue for `%s`"
+	msgNoTeamsAreSetUp              = "No teams are set up, so resources can't be filtered by team"
+	msgNoUsageForX                  = "Nothing was held in %s"
+	msgNoWaitsRecorded              = "No waits have been recorded yet"
+	msgNobodyHeldXRecently          = "Nobody has held %s in the last 30 days"
+	msgNobodyHeldXThisWeek          = "Nobody has held %s in the last week"
+	msgNobodyIsWaitingForY          = "Nobody is waiting for %s, so there is nobody to offer it to"
+	msgNobodyToBroadcastToInY       = "Nobody else holds or waits for %s"
+	msgNobodyToRestoreToY           = "Everyone in the snapshot is already in line for %s"
+	msgNotAuthorizedToRunX          = "Error, your user is not authorized to run the command `%s`."
+	msgNothingIsOversubscribed      = "Nothing is oversubscribed."
+	msgNothingToSnapshotForY        = "Nobody holds or waits for %s, so there is nothing to snapshot"
+	msgNothingWouldBePosted         = "I wouldn't post anything."
+	msgObserversCantRunX            = "You are an observer, so you can see status, history and stats but can't run `%s`"
+	msgOfferForYIsNoLongerOpen      = "The offer for `%s` is no longer open"
+	msgOnlyAdminsCanMakePublic      = "Only admins can make a private resource public"
+	msgOnlyApproversCanChange       = "Only approvers and admins can change who approves reservations"
+	msgOnlyNOfMInXAreFree           = ":warning: Only %d of %d resources in `%s` are free, fewer than %d. More may be needed before everyone is blocked."
+	msgOnlyOwnersCanChangeIncident  = "Only the owner of a resource and admins can change whether it is handed over in incidents"
+	msgOnlyOwnersCanChangeLottery   = "Only the owner of a resource and admins can change its lottery"
+	msgOnlyOwnersCanChangeOwner     = "Only the owner of a resource and admins can change its owner"
+	msgOnlyOwnersCanChangePolicy    = "Only the owner of a resource and admins can change its policy"
+	msgOnlyOwnersCanChangeReset     = "Only the owner of a resource and admins can change its reset hook"
+	msgOnlyOwnersCanChangeRotation  = "Only the owner of a resource and admins can change its rotation"
+	msgOnlyOwnersCanRunX            = "Only the owners of these resources and admins can run `%s`"
+	msgOversubscribedX              = "*Oversubscribed:* %s. Someone is often waiting for these, so adding more would cut waits."
+	msgPRXWasYZWasRemoved           = "Pull request %s was %s, so %s has been released and removed"
+	msgParseErrorLineXYZ            = "`%s` %d times: %s"
+	msgParseErrors                  = "Commands people got wrong:"
+	msgPeriodItGoesToADrawAtZ       = ". It goes to a draw at %s."
 	msgPeriodItIsNowFree            = ". It is now free."
+	msgPeriodItIsReserved           = ". It is reserved."
 	msgPeriodXHasItCurrently        = ". %s has it currently."
+	msgPeriodXItIsYours             = ". %s it's all yours. Get weird."
 	msgPeriodXStillHasIt            = ". %s still has it."
+	msgPinLiveStatusYourself        = "I couldn't pin the live status, so pin it yourself to keep it in sight"
+	msgPostedYourProgressOnX        = "Posted your progress on %s. Those waiting will see it in status."
+	msgProgressNotesAreUpToN        = "Progress notes are up to %d characters"
+	msgPruneWouldRemoveNothing      = "Pruning would remove nothing, as every resource is reserved"
+	msgPruneWouldRemoveX            = "Pruning would remove %s"
 	msgQueuesPruned                 = "I have removed all unreserved resources. Hope that's what you wanted. If not, it's too late now. Fool."
+	msgReadOnly                     = "I'm running read-only, so I can only answer questions about status right now"
 	msgRemoveResourceNotFound       = "Resource cannot be removed, it was not found."
 	msgRemoveResourceReserved       = "Resource cannot be removed, it currently has active reservations."
 	msgRemoveResourceSuccess        = "Resource removed."
+	msgReportedYBroken              = "Reported %s broken. Its owner, the admins and everyone in line were told."
 	msgReservedButNotInQueue        = "%s reserved `%s`, but is currently not in the queue"
+	msgRequestWasAlreadyHandled     = "This request was already approved, denied or withdrawn"
+	msgResetOfYFailedZ              = "Resetting %s failed, as %s. It's still yours, so release it again to retry."
 	msgResourceDoesNotExistY        = "Resource `%s` does not exist"
 	msgResourceImproperlyFormatted  = "LOL u serious? Resources must be formatted as `<env>|<name>`. Example: `your_family|mom`"
+	msgRestoredNFromXToY            = "I restored %d reservations from the snapshot of `%s` to %s"
+	msgRevokedAPIKeyX               = "Revoked API key `%s`"
+	msgRunningX                     = "Running `%s`"
+	msgSentLateFromX                = "_I couldn't reach Slack to send this at %s:_"
+	msgSentYourMessageToYN          = "I sent your message to everyone else holding or waiting for %s (%d)"
+	msgServiceAccountUsage          = "Usage: `service-account <create|token|delete|list> [name]`"
+	msgServiceAccountXExists        = "Service account `%s` already exists. Use `service-account token` to give it a new token"
+	msgServiceTokenForXSentByDM     = "I've sent you the token for `%s` in a DM"
+	msgSetUpChannelX                = "Would you like me to set up this channel's resources? I'll create %s."
+	msgSettingUpdatedXYZ            = "Set %s for `%s` to %s"
+	msgSevNIsNotSevereEnough        = "SEV%d isn't severe enough to take incident resources"
+	msgShowingXAllShowsEvery        = "_Showing resources matching `%s`. Use `status --all` to see every resource._"
+	msgStatusShowsEveryResource     = "`status` shows every resource again"
+	msgStatusSyncDisabled           = "Status sync isn't enabled"
+	msgStatusSyncIsOff              = "I won't change your status anymore"
+	msgStatusSyncIsOn               = "Your status will now show what you hold. Use `sync status off` to stop."
+	msgStatusSyncLinkSentByDM       = "I sent you a link to turn on status sync by DM"
+	msgTeamXIsOverBudgetYZ          = "Heads up: @%s has spent %s this month, which is over its budget of %s"
+	msgTheirLatestProgressX         = " Their latest progress: %s."
+	msgThisConfirmationHasExpired   = "This confirmation has expired"
+	msgThisHandoffHasExpired        = "This handoff has expired"
+	msgThisHandoffIsNotForYou       = "This handoff is for someone else"
+	msgTimelineOfXIsPrivate         = "%s is private, so its timeline is only shown in a DM to those in line for it"
+	msgTimelineOfXY                 = "Who held %s over the last week:\n%s"
+	msgTooBusyForX                  = "I was too busy to handle `%s`. Send it again in a moment if you still want it."
+	msgTooLateToClaimY              = "It is too late to claim %s, it went to the next person waiting."
+	msgTooLateToKeepX               = "It is too late to keep %s, it was already released."
+	msgTookSnapshotOfYN             = "I took a snapshot of the queue for %s (%d in line). Use `restore %s` to restore it, or `restore %s <new resource>` to restore it to another resource."
+	msgTryingX                      = "Trying `%s`. Nothing was changed, but this is what would happen:"
+	msgTurnMilestonesOffWith        = "_Send `settings milestones off` to stop these messages._"
+	msgUnknownExportX               = "I can't export `%s`. Try `reservations` or `history`."
+	msgUnknownTicketX               = "I couldn't find the ticket `%s`"
+	msgUnknownSettingX              = "I don't know the setting `%s`. Available settings: %s"
 	msgUknownUser                   = "I'm sorry, I don't know who that is. Do _you_ know that is?"
-	msgXClearedY                    = "%s cleared `%s`"
-	msgXCurrentlyHas                = "%s currently has `%s`"
+	msgUnlikelyToGetYByZ            = "You are unlikely to get %s by %s, as it is expected to be free around %s."
+	msgUtilizationOfXY              = "How much %s was held by hour of the week over the last 30 days, in your timezone:\n%s"
+	msgWelcomeX                     = "Hi everyone! I keep track of who is using shared resources, such as test environments, and who is waiting for them. Mention me followed by a command, like <@%[1]s> `reserve staging|db`, <@%[1]s> `release staging|db` or <@%[1]s> `status`, or DM me the command. Say <@%[1]s> `help` to see everything I can do."
+	msgWouldDMX                     = "I would DM <@%s>:"
+	msgWouldImportNCreatedN         = "Dry run, nothing was imported: %d would be created, %d updated, %d unchanged, %d failed."
+	msgWouldPostInX                 = "I would post in <#%s>:"
+	msgWouldUploadX                 = "I would upload %s"
+	msgXAlreadyExists               = "Resource %s already exists"
+	msgXApprovedYourRequestForY     = "%s approved your request for %s"
+	msgXApprovedYItIsYours          = "%s approved your request for %s. It's all yours. Get weird."
+	msgXApprovedYInMaintenance      = "%s approved your request for %s, but it is under maintenance, so you'll need to reserve it again afterwards"
+	msgXApprovedYYouAreN            = "%s approved your request for %s. You are %s in line"
+	msgXBreaksNamingConventionY     = "`%s` doesn't follow the naming convention: %s."
+	msgXClearedEveryQueueInYZ       = "%s cleared every queue in `%s`, so you no longer hold or wait for %s"
+	msgXClearedProgressOnY          = "%s cleared their progress note on %s"
+	msgXClearedTheLabelOfY          = "%s cleared the label of %s"
+	msgXConflictsWithYHeldByZ       = ":warning: %s conflicts with %s, which %s holds. Check with them before using it."
+	msgXCreatedYYouRequested        = "%s created %s, which you asked for. You own it."
+	msgXDeclinedYourHandoff         = "%s declined to take over from you"
+	msgXDeniedYourRequestForNewY    = "%s denied your request for a new resource, %s"
+	msgXDeniedYourRequestForY       = "%s denied your request for %s"
+	msgXClearedY                    = "%s cleared %s"
+	msgXCurrentlyHas                = "%s currently has %s"
 	msgXHasBeenKickedFromNResources = "%s has been kicked from %d resource(s)"
-	msgXHasBeenRemovedFromY         = "%s has been kicked from `%s`. It's all yours. Get weird."
-	msgXHasBeenRemovedFromYZ        = "%s has been removed from the queue for `%s`%s"
-	msgXHasReleasedYItIsYours       = "%s has released `%s`. It's all yours. Get weird."
-	msgXHasReleasedYZ               = "%s has released `%s`%s"
-	msgXHasRemovedThemselvesFromYZ  = "%s has removed themselves from the queue for `%s`%s"
-	msgXItIsYours                   = "%s it's all yours. Get weird."
-	msgXKickedYouFromY              = "%s kicked you from `%s`"
+	msgXHasBeenRemovedFromYZ        = "%s has been removed from the queue for %s%s"
+	msgXHasNothingToHandOff         = "%s isn't holding or waiting for anything anymore"
+	msgXHasReleasedYZ               = "%s has released %s%s"
+	msgXHasRemovedThemselvesFromYZ  = "%s has removed themselves from the queue for %s%s"
+	msgXHoldOnYExpiredItIsYours     = "%s's hold on %s expired. It's all yours. Get weird."
+	msgXInAnotherWorkspace          = "`%s` belongs to another workspace"
+	msgXIsAnObserverCantHold        = "%s is an observer, so they can't hold resources"
+	msgXIsAwaySoYIsYours            = "%s is away and didn't claim %s, so it is yours."
+	msgXIsInSeveralEnvsY            = "`%s` is in several envs: %s. Which did you mean?"
+	msgXIsUnlikelyToGetYByZ         = "%s is unlikely to get %s by %s, when they need it"
+	msgXIsntATeamY                  = "`%s` isn't a team. The teams are %s."
+	msgXJoinedTheQueueForY          = "%s joined the queue for %s"
+	msgXLabeledYZ                   = "%s labeled %s *%s*"
+	msgXLeftTheQueueForY            = "%s left the queue for %s"
+	msgXMarkedYFixedAfterZ          = ":white_check_mark: %s marked %s fixed after %s"
+	msgXNoLongerHasYItIsYours       = "%s no longer has %s. It's all yours. Get weird."
+	msgXNowHasY                     = "%s now has %s"
+	msgXOffersYTakeItNow            = "%s can give up %s early. Do you want to take it now?"
+	msgXPostedProgressOnYZ          = "%s posted progress on %s: _%s_"
+	msgXReportedYBrokenZ            = ":rotating_light: %s reported %s broken%s."
+	msgXRequestsNewYBecauseZ        = "%s is asking for a new resource, %s: %s"
+	msgXReservedYConflictsWithZ     = ":warning: %s reserved %s, which conflicts with %s that you hold"
+	msgXRestoredYouToYYouAreN       = "%s restored your place in line for %s. You are %s in line."
+	msgXRestoredYouToYYouHoldIt     = "%s restored your place in line for %s. You hold it."
+	msgXTookOverY                   = "%s took over %s from you"
+	msgXTookOverYFromZ              = "%s took over %s from %s"
+	msgXTookYForTheIncidentZ        = ":rotating_light: %s took %s for the incident _%s_. You are first in line to get it back."
+	msgXTookYYouOffered             = "%s took %s, which you offered, so it is no longer yours"
+	msgXTurnOnYIsUpItIsYours        = "%s's turn on %s is up. It's all yours. Get weird."
+	msgXVotedAbandonedReleasedInY   = "Enough people waiting voted that %s abandoned %s, so it will be released in %s unless they are still using it."
+	msgXWantsToHandOffY             = "%s is going away and asks you to take over %s"
+	msgXWasAlreadyCreated           = "%s was already created"
+	msgXWonTheDrawForYYouAreN       = "%s won the draw for %s. You are %s in line"
+	msgXRequestsY                   = "%s would like to reserve %s"
+	msgXKickedYouFromY              = "%s kicked you from %s"
 	msgXNukedQueue                  = "%s nuked the whole thing. Yikes."
-	msgYHasBeenCleared              = "`%s` has been cleared"
-	msgYouAreNInLineForY            = "You are %s in line for `%s`%s"
+	msgXsHoldOnYLooksStale          = "%s has held %s for %s without any activity, so it may be a candidate for release"
+	msgYClosedYourHoldReleased      = "%s has closed for the day, so your hold on it has been released"
+	msgYHasBeenCleared              = "%s has been cleared"
+	msgYIsBrokenZ                   = "%s is reported broken%s. It can't be reserved until it is marked fixed."
+	msgYIsLabeledZ                  = "%s is labeled *%s*"
+	msgYIsNotReportedBroken         = "%s isn't reported broken"
+	msgYIsResetting                 = "%s is resetting… The next person in line gets it once that's done."
+	msgYRequiresApprovalAskedX      = "%s requires approval, so I've asked %s. I'll let you know what they decide."
+	msgYIsClosedYouAreFirstZ        = "%s is closed until %s. You are first in line for when it opens"
+	msgYIsOpenItIsYours             = "%s is open. It's all yours. Get weird."
+	msgYIsYours                     = "%s is all yours. Get weird."
+	msgYIsUnhealthy                 = "`%s` is currently failing its health check and cannot be reserved"
+	msgYIsUnderMaintenanceZ         = "`%s` is under maintenance %s and cannot be reserved"
+	msgYWasPrunedNoLongerWatching   = ":eyes: %s was pruned, so you are no longer watching it"
+	msgYWasReportedBrokenByX        = "%s was already reported broken by %s%s"
+	msgYWasResetAndReleased         = "%s was reset and released"
+	msgYouAreAlreadyWatchingY       = "You are already watching %s"
+	msgYouAreFirstForYOpensZ        = "You are first in line for %s, which opens %s"
+	msgYouApprovedXRequestForY      = "You approved %s's request for %s"
+	msgYouApprovedXYInMaintenance   = "You approved %s's request for %s, but it is under maintenance so it couldn't be reserved"
+	msgYouAreInTheDrawForYAtZ       = "You are entered in the draw for %s at %s, which favors whoever has used it least lately"
+	msgYouAreNInLineForY            = "You are %s in line for %s%s"
+	msgYouAreNextInLineForX         = "You are next in line for %s."
 	msgYouAreNotInLineForY          = "You are not in line for `%s`"
-	msgYouCurrentlyHave             = "You currently have `%s`"
+	msgYouAreNowInTopNForXY         = "You are now in the top %d for %s, %s in line."
+	msgYouAreNowNInLineForYWasZ     = "You're now %s in line for %s (was %s)"
+	msgYouAreNowWatchingY           = "You are now watching %s. I'll DM you whenever its queue changes."
+	msgYouCannotApproveThis         = "You are no longer an approver of this resource"
+	msgYouCannotCreateThis          = "Only admins and the owners of the env's resources can decide this request"
+	msgYouCannotHandOffToYourself   = "You can't hand off to yourself"
+	msgYouCheckedOffAndReleasedY    = "You checked off everything and released %s"
+	msgYouClaimedY                  = "%s is yours."
+	msgYouClearedXY                 = "You cleared every queue in `%s`: %s"
+	msgYouCreatedYForX              = "You created %s for %s"
+	msgYouCurrentlyHave             = "You currently have %s"
+	msgYouCurrentlyHaveForZ         = "You currently have %s for %s"
+	msgYouDeclinedXHandoff          = "You declined to take over from %s"
+	msgYouDeniedXRequestForNewY     = "You denied %s's request for a new resource, %s"
+	msgYouDidNotClearX              = "Cancelled, the queues in `%s` were not cleared"
+	msgYouDidntClaimYYouAreN        = "You didn't claim %s in time, so it went to the next person waiting. You are %s in line."
+	msgYouDontHoldY                 = "You don't hold %s"
+	msgYouHaveNothingToHandOff      = "You aren't holding or waiting for anything you could hand off"
+	msgYouHoldYForTheIncidentZ      = ":rotating_light: You now hold %s for the incident _%s_. Release them once you are done."
+	msgYouJoinXAtY                  = "You join the line for %s at %s"
+	msgYouKeptX                     = "%s is still yours, and the votes were thrown out."
+	msgYouNoLongerHaveADefaultEnv   = "You no longer have a default env"
+	msgYouNoLongerHoldY             = "You no longer hold %s"
+	msgYouOfferedYToN               = "I offered %s to everyone waiting for it (%d). It stays yours until one of them takes it, for up to %s."
+	msgYouStoppedWatchingY          = "You are no longer watching %s"
+	msgYouTookOverXY                = "You took over from %s: %s"
+	msgYouTookYFromX                = "You took %s from %s. It's all yours."
+	msgYouWerentWatchingY           = "You weren't watching %s"
+	msgYouWillBeRemovedAtDeadline   = "I'll take you out of line if you don't have it by then."
+	msgYouWillBeToldMilestones      = "You will be told when you get near the front of a line"
+	msgYouWontBeToldMilestones      = "You will no longer be told when you get near the front of a line"
+	msgYourDefaultEnvIsX            = "Your default env is now `%s`, so resources you give without an env are in `%s`"
+	msgYourHoldOnXWasAbandoned      = "Your hold on %s was released, as those waiting voted it abandoned and you didn't say you were still using it."
+	msgYourHoldOnYExpired           = "Your hold on %s expired and it has been released"
+	msgYourRequestForXWasSentToY    = "your request for %s was sent to %s"
+	msgYourStatusFilterIsX          = "`status` now only shows resources matching `%s`. Use `status --all` to see every resource."
+	msgYourTurnForXIsAboutYAway     = "Your turn with %s is estimated to be %s away, around %s."
+	msgYourTurnOnYEndedN            = "Your turn on %s is up because others were waiting. You are now %s in line"
+	msgYourTurnOnYEndsInZ           = "Others are waiting for %s, so your turn ends in %s and you'll move to the back of the line"
+	msgYourRequestForYIsPending     = "Your request for %s is still awaiting approval"
+	msgYourIDETokenIsX              = "Your IDE token is `%s`. Keep it secret, since it shows what you have reserved to anyone who has it."
+	msgYouDeniedXRequestForY        = "You denied %s's request for %s"
 	msgYouHaveNoReservations        = "You have no reservations"
-	msgYouHaveReleasedY             = "You have released `%s`"
-	msgYouHaveRemovedXFromY         = "You have removed %s from `%s`"
-	msgYouHaveRemovedYourselfFromY  = "You have removed yourself from `%s`"
+	msgYouHaveReleasedY             = "You have released %s"
+	msgYouHaveRemovedXFromY         = "You have removed %s from %s"
+	msgYouHaveRemovedYourselfFromY  = "You have removed yourself from %s"
+	msgYouHaveWithdrawnRequestForY  = "You have withdrawn your request for %s"
+	msgYouWonTheDrawForY            = "You won the draw for %s. It's all yours. Get weird."
 )
 
-func (h *Handler) getAction(text string) string {
-	for a, r := range actions {
-		if r.MatchString(text) {
-			return a
-		}
-	}
-	return ""
-}
-
 func (h *Handler) create(ea *EventAction) error {
 	ev := ea.Event
 
-	matches := h.getMatches(ea.Action, ev.Text)
-	resources, err := h.getResourcesFromCommaList(matches[0])
+	// An emoji may follow the resource list, e.g. `create dev|db :database:`
+	emoji := ea.Command.Emoji
+
+	resources, err := h.getResourcesFromList(ea.Command.Resources, h.defaultEnv(ea.Event.User))
 	if err != nil {
 		h.handleGetResourceError(ea, err)
 		return err
@@ -112,14 +365,34 @@ func (h *Handler) create(ea *EventAction) error {
 
 	//        success := []*models.Resource{}
 	for _, res := range resources {
+		if h.data.GetResource(res.Name, res.Env, false) == nil {
+			if msg := h.misnamed(res); msg != "" {
+				h.errorReply(ea, msg)
+				continue
+			}
+		}
 		err := h.data.Create(res.Name, res.Env)
 		if err != nil {
 			// if the user is already in the queue, we're going to skip returning an error
-			if err != e.AlreadyInQueue {
-				h.errorReply(ev.Channel, err.Error())
+			if !errors.Is(err, e.AlreadyInQueue) {
+				h.errorReply(ea, e.Message(err))
 				continue
 			}
 		} else {
+			// whoever creates a resource owns it
+			_, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+				r.Owner = ev.User
+				if emoji != "" {
+					r.Emoji = emoji
+				}
+				if h.isolateWorkspaces && !ea.Command.HasFlag("shared") {
+					r.Workspace = workspace(ea)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
 			h.reply(ea, msgCreatedResource, false)
 		}
 	}
@@ -132,31 +405,122 @@ func (h *Handler) reserve(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	matches := h.getMatches(ea.Action, ev.Text)
-	resources, err := h.getResourcesFromCommaList(matches[0])
+	duration := ea.Command.Duration
+	resources, err := h.getResourcesFromList(ea.Command.Resources, h.defaultEnv(ea.Event.User))
 	if err != nil {
 		h.handleGetResourceError(ea, err)
 		return err
 	}
 
+	priority, err := parsePriority(ea.Command.Flags["priority"])
+	if err != nil {
+		h.errorReply(ea, e.Message(err))
+		return nil
+	}
+
+	var deadline time.Time
+	if ea.Command.HasFlag("by") {
+		deadline, err = parseDeadline(ea.Command.Flags["by"], time.Now(), u.Location())
+		if err != nil {
+			h.errorReply(ea, e.Message(err))
+			return nil
+		}
+	} else if ea.Command.HasFlag("drop") {
+		h.errorReply(ea, msgDropNeedsDeadline)
+		return nil
+	}
+
+	var ticket *models.Ticket
+	if id := ea.Command.Ticket; id != "" {
+		ticket, err = h.resolveTicket(id)
+		if err != nil {
+			h.errorReply(ea, fmt.Sprintf(msgUnknownTicketX, id))
+			return nil
+		}
+	}
+
+	if ea.Command.At != "" && !ea.scheduled {
+		return h.scheduleReserve(ea, u, resources)
+	}
+
 	success := []*models.Resource{}
+	// joined are the resources the user wasn't already in line for
+	joined := []*models.Resource{}
+	// asked is set if approval was requested for any of the resources, which is replied to separately
+	asked := false
 	for _, res := range resources {
+		if h.blockUnhealthy {
+			r := h.data.GetResource(res.Name, res.Env, false)
+			if r != nil && r.Unhealthy() {
+				h.errorReply(ea, fmt.Sprintf(msgYIsUnhealthy, res))
+				continue
+			}
+		}
+
+		if r := h.data.GetResource(res.Name, res.Env, false); r != nil && r.BlockedAsBroken() {
+			h.errorReply(ea, fmt.Sprintf(msgYIsBrokenZ, h.resourceText(r), brokenDetails(r.Broken)))
+			continue
+		}
+
+		if r := h.data.GetResource(res.Name, res.Env, false); r == nil {
+			// reserving a resource that doesn't exist creates it
+			if msg := h.misnamed(res); msg != "" {
+				h.errorReply(ea, msg)
+				continue
+			}
+		}
+
+		if r := h.data.GetResource(res.Name, res.Env, false); needsApproval(r, u) {
+			if _, err := h.data.GetPosition(u, res.Name, res.Env); !errors.Is(err, e.NotInQueue) {
+				// already in line, so there's nothing to approve
+				continue
+			}
+			h.requestApproval(ea, u, res, duration, priority, ticket, deadline)
+			asked = true
+			continue
+		}
+
 		err := h.data.Reserve(u, res.Name, res.Env)
 		if err != nil {
 			// if the user is already in the queue, we're going to skip returning an error
-			if err != e.AlreadyInQueue {
-				h.errorReply(ev.Channel, err.Error())
+			if errors.Is(err, e.InMaintenance) {
+				r := h.data.GetResource(res.Name, res.Env, false)
+				h.errorReply(ea, fmt.Sprintf(msgYIsUnderMaintenanceZ, res, maintenanceText(r.ActiveMaintenance(time.Now()), u.Location())))
+				continue
+			}
+			if !errors.Is(err, e.AlreadyInQueue) {
+				h.errorReply(ea, e.Message(err))
 				continue
 			}
+		} else {
+			joined = append(joined, res)
+			ea.applied = true
+		}
+		h.setReservationDuration(u, res, duration)
+		if id := ea.Command.Flags["deploy"]; id != "" {
+			h.linkDeployment(u, res, id)
+		}
+		if ticket != nil {
+			h.linkTicket(u, res, ticket)
+		}
+		if priority != 0 {
+			h.setReservationPriority(u, res, priority)
+		}
+		if !deadline.IsZero() {
+			h.setReservationDeadline(u, res, deadline, ea.Command.HasFlag("drop"))
 		}
 		success = append(success, res)
 	}
 
 	if len(success) == 0 {
+		if asked || ea.failed {
+			// each resource was already replied to
+			return nil
+		}
 		return h.reply(ea, msgAlreadyInAllQueues, true)
 	}
 
@@ -165,92 +529,177 @@ func (h *Handler) reserve(ea *EventAction) error {
 		if err != nil {
 			// This case really should never happen here, as we are only looping through our success cases
 			log.Errorf("%+v", err)
-			h.errorReply(ev.Channel, msgIDontKnow)
+			h.errorReply(ea, msgIDontKnow)
 			return err
 		}
 		cu, err := h.data.GetReservationForResource(res.Name, res.Env)
 		if err != nil {
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, e.Message(err))
 			log.Errorf("%+v", err)
 			continue
 		}
+		mine := h.data.GetReservation(u, res.Name, res.Env)
+		if r := h.data.GetResource(res.Name, res.Env, false); r != nil && r.Drawing() && pos > 0 {
+			err = h.reply(ea, fmt.Sprintf(msgYouAreInTheDrawForYAtZ, h.resourceText(res), r.DrawAt.In(u.Location()).Format(drawTimeFormat))+reservationIDText(mine), true)
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+			continue
+		}
 		switch pos {
 		case 0:
 			log.Errorf(msgReservedButNotInQueue, h.getUserDisplay(u, false), res)
 		case 1:
-			msg := fmt.Sprintf(msgYouCurrentlyHave, res)
+			if r := h.data.GetResource(res.Name, res.Env, false); r != nil && r.Closed(time.Now()) {
+				opens := r.Hours.NextOpen(time.Now()).In(u.Location()).Format(hoursTimeFormat)
+				err = h.reply(ea, fmt.Sprintf(msgYIsClosedYouAreFirstZ, h.resourceText(res), opens)+reservationIDText(mine), true)
+				if err != nil {
+					log.Errorf("%+v", err)
+				}
+				continue
+			}
+			msg := fmt.Sprintf(msgYouCurrentlyHave, h.resourceText(res))
+			if cu.Duration > 0 {
+				msg = fmt.Sprintf(msgYouCurrentlyHaveForZ, h.resourceText(res), durationText(cu.Duration))
+			}
 			if ev.ChannelType != "im" {
-				msg = fmt.Sprintf(msgXCurrentlyHas, h.getUserDisplayWithDuration(cu, true), res)
+				msg = fmt.Sprintf(msgXCurrentlyHas, h.getUserDisplayWithDuration(cu, true), h.resourceText(res))
 			}
-			err = h.reply(ea, msg, false)
+			err = h.reply(ea, msg+reservationIDText(mine), false)
 			if err != nil {
 				log.Errorf("%+v", err)
 			}
 		default:
 			c := ""
-			if cu != nil {
+			if cu != nil && (ev.ChannelType == "im" || !cu.Resource.Private) {
 				c = fmt.Sprintf(msgPeriodXHasItCurrently, h.getUserDisplayWithDuration(cu, false))
+				if note := progressNote(cu, time.Now()); note != "" {
+					c += fmt.Sprintf(msgTheirLatestProgressX, note)
+				}
 			}
-			msg := fmt.Sprintf(msgYouAreNInLineForY, util.Ordinalize(pos), res, c)
-			err = h.reply(ea, msg, true)
+			msg := fmt.Sprintf(msgYouAreNInLineForY, util.Ordinalize(pos), h.resourceText(res), c)
+			if !deadline.IsZero() {
+				if c == "" {
+					msg += "."
+				}
+				msg += fmt.Sprintf(msgIllWarnYouIfNotByX, deadline.In(u.Location()).Format(hoursTimeFormat))
+			}
+			err = h.reply(ea, msg+reservationIDText(mine), true)
 			if err != nil {
 				log.Errorf("%+v", err)
 			}
 		}
 	}
 
+	if msg := h.overBudgetText(u, success); msg != "" {
+		if err := h.reply(ea, msg, true); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+	h.warnConflicts(ea, u, joined)
+
 	return nil
 }
 
+// setReservationDuration applies the requested duration to a user's reservation, falling back to the
+// resource's default duration
+func (h *Handler) setReservationDuration(u *models.User, res *models.Resource, duration time.Duration) {
+	if duration == 0 {
+		r := h.data.GetResource(res.Name, res.Env, false)
+		if r == nil {
+			return
+		}
+		duration = r.DefaultDuration
+	}
+	if duration == 0 {
+		return
+	}
+
+	err := h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+		r.Duration = duration
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
 func (h *Handler) release(ea *EventAction) error {
 	ev := ea.Event
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	matches := h.getMatches(ea.Action, ev.Text)
-	resources, err := h.getResourcesFromCommaList(matches[0])
+	resources, err := h.getResourcesFromList(ea.Command.Resources, h.defaultEnv(ea.Event.User))
 	if err != nil {
 		h.handleGetResourceError(ea, err)
 		return err
 	}
 
 	success := []*models.Resource{}
+	// released keeps each released reservation by resource key so its ID can be confirmed
+	released := map[string]*models.Reservation{}
 	for _, res := range resources {
 		r := h.data.GetResource(res.Name, res.Env, false)
 		if r == nil {
-			h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
 			continue
 		}
 
 		pos, err := h.data.GetPosition(u, res.Name, res.Env)
 		if err != nil {
-			if err == e.NotInQueue {
-				h.errorReply(ev.Channel, fmt.Sprintf(msgYouAreNotInLineForY, res))
+			if errors.Is(err, e.NotInQueue) {
+				if r.Request(u.ID) != nil {
+					h.withdraw(ea, u, res)
+					continue
+				}
+				if h.cancelScheduled(ea, u, res) {
+					continue
+				}
+				h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, res))
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, e.Message(err))
 			continue
 		}
 
 		switch pos {
 		case 0:
-			h.errorReply(ev.Channel, fmt.Sprintf(msgYouAreNotInLineForY, res))
+			h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, res))
 			continue
 		case 1:
+			mine := h.data.GetReservation(u, res.Name, res.Env)
+			if mine != nil && len(r.Checklist) > 0 && !r.Drawing() {
+				h.sendChecklist(ea, mine)
+				continue
+			}
+			if mine != nil && !r.Drawing() {
+				resetting, err := h.startReset(mine)
+				if err != nil {
+					h.handleUpdateResourceError(ea, res, err)
+					continue
+				}
+				if resetting {
+					ea.applied = true
+					h.reply(ea, fmt.Sprintf(msgYIsResetting, h.resourceText(r)), false)
+					continue
+				}
+			}
 			err := h.data.Remove(u, res.Name, res.Env)
 			if err != nil {
-				if err == e.NotInQueue {
+				if errors.Is(err, e.NotInQueue) {
 					h.reply(ea, fmt.Sprintf(msgMustUseRemoveForY, res), true)
 					continue
 				}
-				h.errorReply(ev.Channel, err.Error())
+				h.errorReply(ea, e.Message(err))
 				continue
 			}
 			success = append(success, res)
+			released[res.Key()] = mine
+			ea.applied = true
 		default:
 			h.reply(ea, fmt.Sprintf(msgMustUseRemoveForY, res), true)
 			continue
@@ -260,30 +709,30 @@ func (h *Handler) release(ea *EventAction) error {
 	for _, res := range success {
 		cu, err := h.data.GetReservationForResource(res.Name, res.Env)
 		if err != nil {
-			if err == e.ResourceDoesNotExist {
-				h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			if errors.Is(err, e.ResourceDoesNotExist) {
+				h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, e.Message(err))
 			continue
 		}
 
 		if ea.Event.ChannelType == "im" {
 			// Confirm for user
-			msg := fmt.Sprintf(msgYouHaveReleasedY, res)
-			h.reply(ea, msg, false)
-
-			if cu != nil {
-				// Let next user know they are up
-				msg = fmt.Sprintf(msgXHasReleasedYItIsYours, h.getUserDisplay(u, false), res)
-				h.announce(ea, cu.User, msg)
-			}
+			msg := fmt.Sprintf(msgYouHaveReleasedY, h.resourceText(res))
+			h.reply(ea, msg+reservationIDText(released[res.Key()]), false)
+			// the next user is notified by HandleEvent
 		} else {
 			msg := msgPeriodItIsNowFree
-			if cu != nil {
-				msg = fmt.Sprintf(msgXItIsYours, h.getUserDisplay(cu.User, true))
+			if r := h.data.GetResource(res.Name, res.Env, false); cu != nil && r != nil && r.Drawing() {
+				msg = fmt.Sprintf(msgPeriodItGoesToADrawAtZ, r.DrawAt.In(u.Location()).Format(drawTimeFormat))
+			} else if cu != nil && cu.Resource.Private {
+				// the next holder is told by DM
+				msg = msgPeriodItIsReserved
+			} else if cu != nil {
+				msg = fmt.Sprintf(msgPeriodXItIsYours, h.getUserDisplay(cu.User, true))
 			}
-			msg = fmt.Sprintf(msgXHasReleasedYZ, h.getUserDisplay(u, false), res, msg)
+			msg = fmt.Sprintf(msgXHasReleasedYZ, h.getUserDisplay(u, false), h.resourceText(res), msg)
 			h.reply(ea, msg, false)
 		}
 	}
@@ -296,12 +745,11 @@ func (h *Handler) removeme(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	matches := h.getMatches(ea.Action, ev.Text)
-	resources, err := h.getResourcesFromCommaList(matches[0])
+	resources, err := h.getResourcesFromList(ea.Command.Resources, h.defaultEnv(ea.Event.User))
 	if err != nil {
 		h.handleGetResourceError(ea, err)
 		return err
@@ -310,23 +758,23 @@ func (h *Handler) removeme(ea *EventAction) error {
 	for _, res := range resources {
 		r := h.data.GetResource(res.Name, res.Env, false)
 		if r == nil {
-			h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
 			continue
 		}
 
 		pos, err := h.data.GetPosition(u, res.Name, res.Env)
 		if err != nil {
-			if err == e.NotInQueue {
-				h.errorReply(ev.Channel, fmt.Sprintf(msgYouAreNotInLineForY, res))
+			if errors.Is(err, e.NotInQueue) {
+				h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, res))
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, e.Message(err))
 			continue
 		}
 
 		switch pos {
 		case 0:
-			h.errorReply(ev.Channel, fmt.Sprintf(msgYouAreNotInLineForY, res))
+			h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, res))
 			continue
 		case 1:
 			h.reply(ea, fmt.Sprintf(msgMustUseReleaseForY, res), true)
@@ -334,26 +782,28 @@ func (h *Handler) removeme(ea *EventAction) error {
 		default:
 			err = h.data.Remove(u, res.Name, res.Env)
 			if err != nil {
-				h.errorReply(ev.Channel, err.Error())
+				h.errorReply(ea, e.Message(err))
 				continue
 			}
 
 			cu, err := h.data.GetReservationForResource(res.Name, res.Env)
 			if err != nil {
-				h.errorReply(ev.Channel, err.Error())
+				h.errorReply(ea, e.Message(err))
 				continue
 			}
 
 			if ev.ChannelType == "im" {
 				// We will need to confirm to the user
-				h.reply(ea, fmt.Sprintf(msgYouHaveRemovedYourselfFromY, res), false)
+				h.reply(ea, fmt.Sprintf(msgYouHaveRemovedYourselfFromY, h.resourceText(res)), false)
 			} else {
 				// We only need to send one message in channel
 				current := msgPeriodItIsNowFree
-				if cu != nil {
+				if cu != nil && cu.Resource.Private {
+					current = msgPeriodItIsReserved
+				} else if cu != nil {
 					current = fmt.Sprintf(msgPeriodXStillHasIt, h.getUserDisplayWithDuration(cu, false))
 				}
-				msg := fmt.Sprintf(msgXHasRemovedThemselvesFromYZ, h.getUserDisplay(u, true), res, current)
+				msg := fmt.Sprintf(msgXHasRemovedThemselvesFromYZ, h.getUserDisplay(u, true), h.resourceText(res), current)
 				h.reply(ea, msg, false)
 			}
 		}
@@ -367,49 +817,63 @@ func (h *Handler) allStatus(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	userOnly := false
-	switch ea.Action {
-	case "my_status", "my_status_dm":
-		userOnly = true
+	userOnly := ea.Command.Action == "my_status"
+
+	// A filter given with the command is used over the one the user saved, and --all ignores both
+	var filter *statusFilter
+	saved := false
+	if ea.Command.Action == "single_status" {
+		filter, err = h.parseStatusFilter(ea.Command.Args[0])
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			return nil
+		}
+	} else if !userOnly && !ea.Command.HasFlag("all") {
+		filter = h.savedStatusFilter(ev.User)
+		saved = filter != nil
 	}
 
-	all := h.data.GetResources()
+	// All queues are fetched at once rather than per resource
+	all := h.data.GetQueues()
 
-	if len(all) == 0 {
+	if len(all) == 0 && !userOnly {
 		return h.reply(ea, msgNoReservations, false)
 	}
 
 	resp := ""
-	for _, res := range all {
-		if userOnly {
-			// Discarding the err here. Func returns 0 when there's an err so we'll use that as an indication
-			// to just skip
-			pos, _ := h.data.GetPosition(u, res.Name, res.Env)
-			if pos <= 0 {
-				continue
-			}
+	for _, q := range all {
+		if userOnly && !inQueue(u, q) || !h.inWorkspace(ea, q.Resource) {
+			continue
 		}
-		msg, err := h.getCurrentResText(res, false)
-		if err != nil {
-			log.Errorf("%+v", err)
-			h.errorReply(ev.Channel, "")
+		if filter != nil && !filter.matches(q.Resource, h.teams) {
 			continue
 		}
 
-		resp += msg + "\n"
+		resp += h.queueText(q, false, h.reveals(q, u, ev.ChannelType == "im"), u.Location()) + "\n"
+	}
+
+	if userOnly {
+		if scheduled := h.scheduledText(u); scheduled != "" {
+			resp += scheduled + "\n"
+		}
 	}
 
 	if resp == "" {
 		if userOnly {
 			resp = msgYouHaveNoReservations
+		} else if filter != nil {
+			resp = fmt.Sprintf(msgNoReservationsMatchingX, filter) + "\n"
 		} else {
 			resp = msgNoReservations
 		}
 	}
+	if saved {
+		resp += fmt.Sprintf(msgShowingXAllShowsEvery, filter)
+	}
 	// Only address the user if they asked for *their* status
 	h.reply(ea, resp, userOnly)
 
@@ -418,28 +882,28 @@ func (h *Handler) allStatus(ea *EventAction) error {
 
 func (h *Handler) singleStatus(ea *EventAction) error {
 	ev := ea.Event
-	r := h.getMatches(ea.Action, ev.Text)
-
-	if len(r) == 0 {
-		h.errorReply(ev.Channel, msgMustSpecifyResource)
+	res, err := h.parseResource(strings.Trim(ea.Command.Args[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil {
+		// Probably don't need to insult the user for resource formatting here
+		h.errorReply(ea, msgMustSpecifyValidResource)
 		return nil
 	}
 
-	res, err := h.parseResource(r[0])
+	u, err := h.getUser(ev.User)
 	if err != nil {
-		// Probably don't need to insult the user for resource formatting here
-		h.errorReply(ev.Channel, msgMustSpecifyValidResource)
-		return nil
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
 	}
 
-	msg, err := h.getCurrentResText(res, false)
+	msg, err := h.getCurrentResText(res, u, ev.ChannelType == "im", u.Location())
 	if err != nil {
-		if err == e.ResourceDoesNotExist {
-			h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
+		if errors.Is(err, e.ResourceDoesNotExist) {
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
 			return nil
 		}
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -453,47 +917,51 @@ func (h *Handler) clear(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	matches := h.getMatches(ea.Action, ev.Text)
-	resources, err := h.getResourcesFromCommaList(matches[0])
+	resources, err := h.getResourcesFromList(ea.Command.Resources, h.defaultEnv(ea.Event.User))
 	if err != nil {
 		h.handleGetResourceError(ea, err)
 		return err
 	}
 
+	// Clearing several resources is summarized in a single message
+	var cleared []string
 	for _, res := range resources {
 		q, err := h.data.GetQueueForResource(res.Name, res.Env)
 		if err != nil {
-			if err == e.ResourceDoesNotExist {
-				h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			if errors.Is(err, e.ResourceDoesNotExist) {
+				h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, e.Message(err))
 			continue
 		}
 
 		err = h.data.ClearQueueForResource(res.Name, res.Env)
 		if err != nil {
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, e.Message(err))
 			continue
 		}
 
-		msg := fmt.Sprintf(msgYHasBeenCleared, res)
-		h.reply(ea, msg, false)
+		cleared = append(cleared, fmt.Sprintf(msgYHasBeenCleared, h.resourceText(res)))
 
 		// If request was via IM, we need to notify other users
 		if ev.ChannelType == "im" {
 			for _, r := range q.Reservations {
 				if r.User.ID != ev.User {
-					h.announce(ea, r.User, fmt.Sprintf(msgXClearedY, h.getUserDisplay(u, true), res))
+					h.announce(ea, r.User, fmt.Sprintf(msgXClearedY, h.getUserDisplay(u, true), h.resourceText(res)))
 				}
 			}
 		}
 	}
 
+	if len(cleared) > 0 {
+		h.reply(ea, strings.Join(cleared, "\n"), false)
+	}
+
 	return nil
 }
 
@@ -502,36 +970,28 @@ func (h *Handler) kick(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	if !h.HasAdminAccess(u.Name) {
-		h.reply(ea, "Error, your user is not authorized to run the command `kick`.", false)
-		return nil
-	}
-
-	matches := h.getMatches(ea.Action, ev.Text)
-	if len(matches) != 1 {
-		h.reply(ea, msgMustSpecifyUser, true)
-		return nil
-	}
-	uToKick, err := h.getUser(matches[0])
+	uToKick, err := h.getUser(ea.Command.Mentions[0])
 	if err != nil {
 		log.Errorf("%+v", err)
 		h.reply(ea, msgUknownUser, true)
 		return err
 	}
 
+	// Changes are summarized in a single message along with the count
+	var lines []string
 	count := 0
 	for _, res := range h.data.GetResources() {
 		pos, err := h.data.GetPosition(uToKick, res.Name, res.Env)
 		if err != nil {
-			if err == e.NotInQueue {
+			if errors.Is(err, e.NotInQueue) {
 				// this error does not need to be reported to the user
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, e.Message(err))
 			continue
 		}
 		if pos != 1 {
@@ -540,48 +1000,43 @@ func (h *Handler) kick(ea *EventAction) error {
 
 		err = h.data.Remove(uToKick, res.Name, res.Env)
 		if err != nil {
-			if err == e.NotInQueue {
+			if errors.Is(err, e.NotInQueue) {
 				// this error does not need to be reported to the user
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, e.Message(err))
 			continue
 		}
 		count++
 
 		cu, err := h.data.GetReservationForResource(res.Name, res.Env)
 		if err != nil {
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, e.Message(err))
 			continue
 		}
 
 		if ev.ChannelType == "im" {
 			// We will need to confirm to the user
-			h.reply(ea, fmt.Sprintf(msgYouHaveRemovedXFromY, h.getUserDisplay(uToKick, true), res), false)
-
-			// If someone now has the resource, we must alert them
-			if cu != nil {
-				msg := fmt.Sprintf(msgXHasBeenRemovedFromY, h.getUserDisplay(uToKick, false), res)
-				h.announce(ea, cu.User, msg)
-			}
+			lines = append(lines, fmt.Sprintf(msgYouHaveRemovedXFromY, h.getUserDisplay(uToKick, true), h.resourceText(res)))
 
 			// Alert user who was kicked
-			msg := fmt.Sprintf(msgXKickedYouFromY, h.getUserDisplay(u, true), res)
+			msg := fmt.Sprintf(msgXKickedYouFromY, h.getUserDisplay(u, true), h.resourceText(res))
 			h.announce(ea, uToKick, msg)
 		} else {
 			// We only need to send one message in channel
 			current := msgPeriodItIsNowFree
-			if cu != nil {
+			if cu != nil && cu.Resource.Private {
+				current = msgPeriodItIsReserved
+			} else if cu != nil {
 				current = fmt.Sprintf(msgPeriodXHasItCurrently, h.getUserDisplayWithDuration(cu, false))
 			}
 
-			msg := fmt.Sprintf(msgXHasBeenRemovedFromYZ, h.getUserDisplay(u, false), res, current)
-			h.reply(ea, msg, false)
+			lines = append(lines, fmt.Sprintf(msgXHasBeenRemovedFromYZ, h.getUserDisplay(u, false), h.resourceText(res), current))
 		}
 	}
 
-	msg := fmt.Sprintf(msgXHasBeenKickedFromNResources, h.getUserDisplay(uToKick, true), count)
-	h.reply(ea, msg, false)
+	lines = append(lines, fmt.Sprintf(msgXHasBeenKickedFromNResources, h.getUserDisplay(uToKick, true), count))
+	h.reply(ea, strings.Join(lines, "\n"), false)
 
 	// User will need to be alerted
 	return nil
@@ -592,15 +1047,10 @@ func (h *Handler) nuke(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	if !h.HasAdminAccess(u.Name) {
-		h.reply(ea, "Error, your user is not authorized to run the command `nuke`.", false)
-		return nil
-	}
-
 	h.data = data.NewMemory()
 
 	msg := fmt.Sprintf(msgXNukedQueue, h.getUserDisplay(u, true))
@@ -609,40 +1059,35 @@ func (h *Handler) nuke(ea *EventAction) error {
 	return nil
 }
 
+// prune removes every unreserved resource. With --dry-run, the resources that would be removed are only
+// listed.
 func (h *Handler) prune(ea *EventAction) error {
-	ev := ea.Event
-	u, err := h.getUser(ev.User)
-	if err != nil {
-		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
-		return err
-	}
-
-	if !h.HasAdminAccess(u.Name) {
-		h.reply(ea, "Error, your user is not authorized to run the command `prune`.", false)
-		return nil
-	}
+	dryRun := ea.Command.HasFlag("dry-run")
 
-	resources := h.data.GetResources()
-	for _, res := range resources {
-		q, err := h.data.GetQueueForResource(res.Name, res.Env)
-		if err != nil {
-			// this shouldn't happen, but there's nothing to alert the user to
-			log.Errorf("%+v", err)
+	pruned := []string{}
+	for _, q := range h.data.GetQueues() {
+		if q.HasReservations() {
 			continue
 		}
 
-		if q.HasReservations() {
+		res := q.Resource
+		pruned = append(pruned, formatResource(res))
+		if dryRun {
 			continue
 		}
-
-		err = h.data.RemoveResource(res.Name, res.Env)
+		err := h.data.RemoveResource(res.Name, res.Env)
 		if err != nil {
 			log.Errorf("%+v", err)
 			continue
 		}
 	}
 
+	if dryRun {
+		if len(pruned) == 0 {
+			return h.reply(ea, msgPruneWouldRemoveNothing, false)
+		}
+		return h.reply(ea, fmt.Sprintf(msgPruneWouldRemoveX, strings.Join(pruned, ", ")), false)
+	}
 	h.reply(ea, msgQueuesPruned, false)
 
 	return nil
@@ -653,21 +1098,17 @@ func (h *Handler) removeresource(ea *EventAction) error {
 	_, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	matches := h.getMatches(ea.Action, ev.Text)
-
 	var nmenv []string
-	for _, m := range matches {
-		if strings.Contains(m, "|") {
-			nmenv = strings.Split(m, "|")
-		} else {
-			// No env
-			nmenv = append(nmenv, "")
-			nmenv = append(nmenv, m)
-		}
+	if m := strings.Trim(ea.Command.Args[0], "`"); strings.Contains(m, "|") {
+		nmenv = strings.Split(m, "|")
+	} else {
+		// No env, so the user's default env, if they set one
+		nmenv = append(nmenv, h.defaultEnv(ea.Event.User))
+		nmenv = append(nmenv, m)
 	}
 
 	resources := h.data.GetResources()
@@ -711,7 +1152,7 @@ func (h *Handler) help(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -723,25 +1164,98 @@ func (h *Handler) help(ea *EventAction) error {
 	}
 
 	helpText += "When invoking via DM, I will alert other users via DM when necessary. E.g. Releasing a resource will notify the next user that has it.\n\n"
+	if h.isObserver(u) {
+		helpText += "You are an observer, so you can ask for status, history and stats and watch resources, but can't reserve, release or change anything.\n\n"
+	}
 	helpText += "*Commands*\n\n"
 	helpText += "When invoking within a channel, you must @-mention me by adding " + TICK + "@reservebot" + TICK + "to the _beginning_ of your command.\n\n"
 
-	helpText += TICK + "create <resource>" + TICK + "This will create a free resource.\n\n"
-	helpText += TICK + "reserve <resource>" + TICK + " This will reserve a given resource for the user. If the resource is currently reserved, the user will be placed into the queue. The resource should be an alphanumeric string with no spaces. A comma-separted list can be used to reserve multiple resources.\n\n"
+	helpText += TICK + "create <resource> [:emoji:]" + TICK + "This will create a free resource. The optional emoji is shown next to the resource in status and queue messages.\n\n"
+	if h.isolateWorkspaces {
+		helpText += "Resources are kept to the workspace they are created in. Add " + TICK + "--shared" + TICK + " to " + TICK + "create" + TICK + " to share one across the org.\n\n"
+	}
+	helpText += TICK + "request-resource <name> <env> <reason>" + TICK + " This will ask the admins and the owners of the env's resources to create a resource for you, for when you can't create it yourself. You own it once it is created.\n\n"
+	helpText += TICK + "reserve <resource> [for <duration>]" + TICK + " This will reserve a given resource for the user. If the resource is currently reserved, the user will be placed into the queue. The resource should be an alphanumeric string with no spaces. A comma-separted list can be used to reserve multiple resources. If a duration such as " + TICK + "2h" + TICK + " is given, or the resource has a default duration, the resource will be released automatically once the duration has passed. Add " + TICK + "at 2pm" + TICK + " to join the queue at that time instead of now.\n\n"
 	helpText += TICK + "release <resource>" + TICK + " This will release a given resource. This command must be executed by the person who holds the resource. Upon release, the next person waiting in line will be notified that they now have the resource. The resource should be an alphanumeric string with no spaces. A comma-separted list can be used to reserve multiple resources.\n\n"
-	helpText += TICK + "status" + TICK + " This will provide a status of all active resources.\n\n"
+	helpText += TICK + "status [--all]" + TICK + " This will provide a status of all active resources, or only those matching your status filter if you saved one. Use " + TICK + "--all" + TICK + " to see every resource.\n\n"
+	helpText += TICK + "try <command>" + TICK + " This will show what a command would do, such as " + TICK + "try reserve dev|db" + TICK + ", without changing anything.\n\n"
 	helpText += TICK + "my status" + TICK + " This will provide a status of all active and queue reservations for the user.\n\n"
+	helpText += TICK + "live status [off]" + TICK + " This will pin a status of all resources in the channel that I keep up to date as reservations change. Use " + TICK + "live status off" + TICK + " to stop.\n\n"
 	helpText += TICK + "status <resource>" + TICK + " This will provide a status of a given resource.\n\n"
+	helpText += TICK + "status team:<team>" + TICK + " This will provide a status of the resources owned by a team's members. Use " + TICK + "env:<env>" + TICK + " for the resources of an env, and separate several with commas, like " + TICK + "team:payments,env:staging" + TICK + ".\n\n"
 	helpText += TICK + "remove me from <resource>" + TICK + " This will remove the user from the queue for a resource.\n\n"
+	helpText += TICK + "report-broken <resource> <details> [--block]" + TICK + " This will report a resource broken, telling its owner, the admins and everyone in line, and show the report in status until it is marked fixed. With " + TICK + "--block" + TICK + ", it can't be reserved until then.\n\n"
+	helpText += TICK + "mark-fixed <resource> [notes]" + TICK + " This will mark a resource reported broken as fixed, telling whoever reported it and everyone in line.\n\n"
+	helpText += TICK + "label <resource> <state|clear>" + TICK + " This will label a resource you hold with its state, such as deploying, testing or broken, so those waiting know what is going on. It is shown in status until you clear it or your hold ends.\n\n"
+	helpText += TICK + "progress <resource> <note|clear>" + TICK + " This will post a note on how your work with a resource you hold is going, such as " + TICK + "progress dev|db tests running, 10 more minutes" + TICK + ", so those waiting don't have to ask if you are done yet. It is shown in status and to those waiting until you clear it or your hold ends.\n\n"
+	helpText += TICK + "offer <resource>" + TICK + " This will offer a resource you hold to everyone waiting for it. The first to take it gets it right away, and you keep it until someone does.\n\n"
+	helpText += TICK + "watch <resource>" + TICK + " This will DM you about every change to the queue for a resource without joining it. Use " + TICK + "unwatch <resource>" + TICK + " to stop.\n\n"
+	helpText += TICK + "handoff <@user>" + TICK + " This will ask the mentioned teammate to take over everything you hold or are waiting for, such as before going on vacation. They keep your places in line once they accept.\n\n"
 	helpText += TICK + "remove resource <resource>" + TICK + " This will remove an empty resource.\n\n"
 	helpText += TICK + "clear <resource>" + TICK + " This will clear the queue for a given resource and release it.\n\n"
-
-	// if there are no admins specified or there are and the user is in the list then show these options
-	if h.HasAdminAccess(u.Name) {
-		helpText += TICK + "prune <resource>" + TICK + " This will clear all unreserved resources from memory.\n\n"
+	helpText += TICK + "settings <resource> <setting> <value>" + TICK + " This will change a setting for a resource. Available settings: " + strings.Join(resourceSettings, ", ") + ".\n\n"
+	helpText += TICK + "settings default-env <env|none>" + TICK + " This will set the env your resources are in when you don't give one, so you can write " + TICK + "reserve api" + TICK + " for " + TICK + "staging|api" + TICK + ".\n\n"
+	if len(h.milestones) > 0 {
+		helpText += TICK + "settings milestones <on|off>" + TICK + " This will turn off or back on the DMs telling you when you get near the front of a line, such as when you're in the top 3 or your turn is about 15 minutes away.\n\n"
+	}
+	helpText += TICK + "settings status-filter <filter|none>" + TICK + " This will make " + TICK + "status" + TICK + " show only the resources matching a filter such as " + TICK + "team:payments" + TICK + " unless you add " + TICK + "--all" + TICK + ".\n\n"
+	helpText += TICK + "health <resource> <url> [interval]" + TICK + " This will periodically check the URL and show the health of the resource in status. Use " + TICK + "health <resource> off" + TICK + " to stop checking.\n\n"
+	helpText += TICK + "ide token" + TICK + " This will DM you a token for showing your reservations in your editor's status bar.\n\n"
+	helpText += TICK + "usage report [month]" + TICK + " This will report how long each team and user held resources in a month such as " + TICK + "2024-05" + TICK + ", and what it cost for resources with a cost setting. The current month is reported by default.\n\n"
+	helpText += TICK + "stats" + TICK + " This will list how long recent reservations of each resource waited in line before getting it.\n\n"
+	helpText += TICK + "timeline <resource>" + TICK + " This will show who held a resource over the last week.\n\n"
+	helpText += TICK + "heatmap <resource|env> [--image]" + TICK + " This will show how much a resource, or the resources of an env, were held by hour of the week over the last 30 days, to help pick quiet times. With " + TICK + "--image" + TICK + ", it is uploaded as an image.\n\n"
+	helpText += TICK + "sync status <on|off>" + TICK + " This will show the resources you hold in your Slack status, once you grant me permission.\n\n"
+
+	// commands are only listed for users who may run them
+	if h.mayRun(u, "prune") {
+		helpText += TICK + "prune [--dry-run]" + TICK + " This will clear all unreserved resources from memory. With " + TICK + "--dry-run" + TICK + ", the resources that would be cleared are only listed.\n\n"
+	}
+	if h.mayRun(u, "kick") {
 		helpText += TICK + "kick <@user>" + TICK + " This will kick the mentioned user from _all_ resources they are holding. As the user is kicked from each resource, the queue will be advanced to the next user waiting.\n\n"
+	}
+	if h.mayRun(u, "clearenv") {
+		helpText += TICK + "clear env <env>" + TICK + " This will clear the queues of every resource in an env, such as after an env-wide rebuild. You are DMed to confirm first, and everyone in the queues is told.\n\n"
+	}
+	if h.mayRun(u, "broadcast") {
+		helpText += TICK + "broadcast <env|resource> <message>" + TICK + " This will DM the message to everyone holding or waiting for the resource, or any resource in the env, such as a warning that it is going down for maintenance.\n\n"
+	}
+	if h.mayRun(u, "incident") {
+		helpText += TICK + "incident <severity> <@commander> [title]" + TICK + " This will declare an incident. If it is severe enough, resources with the incident setting on are handed to the mentioned commander, and whoever held them is put first in line to get them back.\n\n"
+	}
+	if h.mayRun(u, "snapshot") {
+		helpText += TICK + "snapshot <resource>" + TICK + " This will save the queue for a resource before a risky operation, such as recreating it. Use " + TICK + "restore <resource> [<new resource>]" + TICK + " afterwards to put everyone back in line, in order, for the resource or the one it was recreated as.\n\n"
+	}
+	if h.mayRun(u, "nuke") {
 		helpText += TICK + "nuke" + TICK + " This will clear all reservations and all queues for all resources. This can only be done from a public channel, not a DM. There is no confirmation, so be careful.\n\n"
 	}
+	if h.mayRun(u, "maintenance") {
+		helpText += TICK + "maintenance <resource> <start> <duration> [reason]" + TICK + " This will schedule a maintenance window for a resource. Start is " + TICK + "now" + TICK + " or " + TICK + "YYYY-MM-DDTHH:MM" + TICK + ". The resource cannot be reserved during the window and everyone in its queue will be warned beforehand.\n\n"
+	}
+	if h.mayRun(u, "endmaintenance") {
+		helpText += TICK + "cancel maintenance <resource>" + TICK + " This will cancel all maintenance windows for a resource.\n\n"
+	}
+	if h.mayRun(u, "export") {
+		helpText += TICK + "export <reservations|history>" + TICK + " This will upload the current reservations, or the changes made since I started, as a CSV file.\n\n"
+	}
+	if h.mayRun(u, "serviceaccount") {
+		helpText += TICK + "service-account <create|token|delete|list> [name]" + TICK + " This will manage the service accounts that reserve resources through the API, such as deploy pipelines. Tokens are sent by DM.\n\n"
+	}
+	if h.mayRun(u, "apikey") {
+		helpText += TICK + "api-key <create|revoke|list>" + TICK + " This will manage the keys that call the HTTP API without its secrets. " + TICK + "api-key create <name> <read|reserve|admin> [requests per minute]" + TICK + " DMs you a new key, " + TICK + "api-key revoke <id>" + TICK + " stops one working and " + TICK + "api-key list" + TICK + " lists them.\n\n"
+	}
+	if h.mayRun(u, "import resources") {
+		helpText += TICK + "import resources [--dry-run]" + TICK + " Sent in a DM with a CSV file attached, this will create or update the resources it lists, with columns for name, env, owner, description and capacity, and reply with what became of each row. With " + TICK + "--dry-run" + TICK + ", the rows are only checked.\n\n"
+	}
+	if h.mayRun(u, "forget") {
+		helpText += TICK + "forget <@user>" + TICK + " This will delete everything I keep about someone, including their reservations, past holds, usage and recent events, and report what was deleted.\n\n"
+	}
+	if h.mayRun(u, "adminusage") {
+		helpText += TICK + "admin usage" + TICK + " This will show how often each command has been run since I started, how often it failed and how long it took, and which commands people get wrong.\n\n"
+	}
+	if h.mayRun(u, "capacityreport") {
+		helpText += TICK + "report capacity [--csv]" + TICK + " This will report how contended each resource has been by env, day and hour, and which are oversubscribed. With " + TICK + "--csv" + TICK + ", the samples are uploaded as a CSV file.\n\n"
+	}
 
 	h.reply(ea, helpText, false)
 	return nil
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// adminGroups holds the members of the Slack user groups whose members are admins. Membership is
+// refreshed by SyncAdminGroups, so people gain and lose access without the bot being redeployed.
+type adminGroups struct {
+	lock sync.RWMutex
+	// groups are the configured groups, as handles such as platform-admins or IDs
+	groups  []string
+	members map[string]bool
+}
+
+func newAdminGroups(groups []string) *adminGroups {
+	ret := &adminGroups{
+		members: map[string]bool{},
+	}
+	for _, g := range groups {
+		if g = strings.TrimPrefix(strings.TrimSpace(g), "@"); g != "" {
+			ret.groups = append(ret.groups, g)
+		}
+	}
+	return ret
+}
+
+func (a *adminGroups) isMember(userID string) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	return a.members[userID]
+}
+
+// HasAdminAccess returns if the specified user has access to admin features, either by name or through
+// an admin user group. If no admins or admin groups are defined at runtime, all users will have admin
+// access
+func (h *Handler) HasAdminAccess(u *models.User) bool {
+	if len(h.admins) == 0 && len(h.adminGroups.groups) == 0 {
+		return true
+	}
+	return util.InSlice(h.admins, u.Name) || h.adminGroups.isMember(u.ID)
+}
+
+// SyncAdminGroups refreshes the members of the admin user groups from Slack. If Slack can't be reached,
+// the previous members keep their access.
+func (h *Handler) SyncAdminGroups() error {
+	if len(h.adminGroups.groups) == 0 {
+		return nil
+	}
+
+	groups, err := h.client.GetUserGroups(slack.GetUserGroupsOptionIncludeUsers(true))
+	if err != nil {
+		return err
+	}
+
+	members := map[string]bool{}
+	for _, want := range h.adminGroups.groups {
+		found := false
+		for _, g := range groups {
+			if g.ID != want && g.Handle != want {
+				continue
+			}
+			found = true
+			for _, id := range g.Users {
+				members[id] = true
+			}
+		}
+		if !found {
+			log.Warnf("Admin user group %s was not found", want)
+		}
+	}
+
+	h.adminGroups.lock.Lock()
+	h.adminGroups.members = members
+	h.adminGroups.lock.Unlock()
+
+	return nil
+}
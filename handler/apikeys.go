@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// keyLimiter counts the requests made with each API key in the current minute
+type keyLimiter struct {
+	lock    sync.Mutex
+	windows map[string]*keyWindow
+}
+
+type keyWindow struct {
+	start time.Time
+	count int
+}
+
+func newKeyLimiter() *keyLimiter {
+	return &keyLimiter{windows: map[string]*keyWindow{}}
+}
+
+// allow counts a request made with a key, returning how long until it may make another if it is over
+// its limit
+func (l *keyLimiter) allow(k *models.APIKey, now time.Time) (time.Duration, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	w, ok := l.windows[k.ID]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		w = &keyWindow{start: now}
+		l.windows[k.ID] = w
+	}
+	if w.count >= k.RateLimit {
+		return w.start.Add(time.Minute).Sub(now), false
+	}
+	w.count++
+	return 0, true
+}
+
+// authorizeRequest checks that a request presents an endpoint's secret, or an API key with the scope
+// the endpoint needs, writing an error response if it doesn't. Keys over their rate limit are turned
+// away until the next minute.
+func (h *Handler) authorizeRequest(w http.ResponseWriter, r *http.Request, secret, scope string) bool {
+	if secret != "" && authorized(r, secret) {
+		return true
+	}
+	if _, ok := h.sessionUser(r); ok && scope == models.ScopeRead {
+		// people signed in to the browser pages can read what they could in Slack
+		return true
+	}
+	k := h.apiKeyForToken(givenSecret(r))
+	if k == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if !k.Allows(scope) {
+		http.Error(w, fmt.Sprintf("API key needs the %s scope", scope), http.StatusForbidden)
+		return false
+	}
+	if wait, ok := h.keyLimits.allow(k, time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// apiKeyForToken returns the API key a token belongs to, or nil if it belongs to none. Tokens are the
+// key's ID and its secret, separated by a dot.
+func (h *Handler) apiKeyForToken(token string) *models.APIKey {
+	fields := strings.SplitN(token, ".", 2)
+	if len(fields) != 2 {
+		return nil
+	}
+	keys, err := h.data.GetAPIKeys()
+	if err != nil {
+		log.Errorf("%+v", err)
+		return nil
+	}
+	for _, k := range keys {
+		if k.ID == fields[0] && subtle.ConstantTimeCompare([]byte(tokenHash(token)), []byte(k.KeyHash)) == 1 {
+			return k
+		}
+	}
+	return nil
+}
+
+// apiKey manages the API keys that let callers of the HTTP API in without the endpoints' secrets. Keys
+// are only ever sent to the admin by DM.
+func (h *Handler) apiKey(ea *EventAction) error {
+	args := ea.Command.Args
+	switch strings.ToLower(args[0]) {
+	case "list":
+		return h.listAPIKeys(ea)
+	case "create":
+		if len(args) < 3 {
+			break
+		}
+		return h.createAPIKey(ea, args[1], strings.ToLower(args[2]), args[3:])
+	case "revoke":
+		if len(args) != 2 {
+			break
+		}
+		return h.revokeAPIKey(ea, args[1])
+	}
+	h.errorReply(ea, msgAPIKeyUsage)
+	return nil
+}
+
+func (h *Handler) createAPIKey(ea *EventAction, name, scope string, rest []string) error {
+	if !automationIdentity.MatchString(name) {
+		h.errorReply(ea, fmt.Sprintf(msgInvalidAPIKeyNameX, name))
+		return nil
+	}
+	if !models.ValidScope(scope) {
+		h.errorReply(ea, fmt.Sprintf(msgInvalidScopeX, scope))
+		return nil
+	}
+	limit := models.DefaultRateLimit
+	if len(rest) > 0 {
+		n, err := strconv.Atoi(rest[0])
+		if err != nil || n < 1 {
+			h.errorReply(ea, fmt.Sprintf(msgInvalidRateLimitX, rest[0]))
+			return nil
+		}
+		limit = n
+	}
+
+	id, secret := make([]byte, 4), make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	k := &models.APIKey{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Scope:     scope,
+		RateLimit: limit,
+		Created:   time.Now(),
+		CreatedBy: ea.Event.User,
+	}
+	token := k.ID + "." + hex.EncodeToString(secret)
+	k.KeyHash = tokenHash(token)
+	if err := h.data.SaveAPIKey(k); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	log.Infof("%s created API key %s (%s) with the %s scope", ea.Event.User, k.ID, k.Name, k.Scope)
+
+	msg := fmt.Sprintf(msgCreatedAPIKeyXYZ, k.ID, k.Scope, token)
+	if ea.Event.ChannelType == "im" {
+		return h.reply(ea, msg, false)
+	}
+	h.notify(&models.User{ID: ea.Event.User}, msg)
+	return h.reply(ea, fmt.Sprintf(msgAPIKeyXSentByDM, k.ID), true)
+}
+
+func (h *Handler) revokeAPIKey(ea *EventAction, id string) error {
+	err := h.data.DeleteAPIKey(id)
+	if errors.Is(err, e.APIKeyDoesNotExist) {
+		h.errorReply(ea, fmt.Sprintf(msgNoAPIKeyX, id))
+		return nil
+	}
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	log.Infof("%s revoked API key %s", ea.Event.User, id)
+	return h.reply(ea, fmt.Sprintf(msgRevokedAPIKeyX, id), false)
+}
+
+// listAPIKeys lists every API key with its scope, limit and who made it, but never the key itself
+func (h *Handler) listAPIKeys(ea *EventAction) error {
+	keys, err := h.data.GetAPIKeys()
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if len(keys) == 0 {
+		return h.reply(ea, msgNoAPIKeys, false)
+	}
+
+	lines := []string{}
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("`%s` *%s*, %s, %d requests a minute, created by <@%s> on %s", k.ID, k.Name, k.Scope, k.RateLimit, k.CreatedBy, k.Created.Format("Jan 2 2006")))
+	}
+	return h.reply(ea, strings.Join(lines, "\n"), false)
+}
@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	approveAction = "approval_approve"
+	denyAction    = "approval_deny"
+)
+
+// errNotApprover is returned when someone who isn't an approver of a resource decides a request for it
+var errNotApprover = errors.New("not an approver")
+
+// setApprovers sets who must approve reservations of a resource from the users mentioned in the value.
+// Once a resource has approvers, only they and admins may change them.
+func setApprovers(r *models.Resource, value string, mentions []string, allowed bool) (string, error) {
+	if len(r.Approvers) > 0 && !allowed {
+		return "", errors.New(msgOnlyApproversCanChange)
+	}
+	if value == "none" {
+		r.Approvers = nil
+		return "none", nil
+	}
+	if len(mentions) == 0 {
+		return "", errors.New(msgInvalidApprovers)
+	}
+
+	r.Approvers = nil
+	display := []string{}
+	for _, id := range mentions {
+		if r.IsApprover(id) {
+			continue
+		}
+		r.Approvers = append(r.Approvers, id)
+		display = append(display, fmt.Sprintf("<@%s>", id))
+	}
+	return strings.Join(display, ", "), nil
+}
+
+// needsApproval returns if a user must ask for approval to reserve a resource. Approvers don't need to
+// ask themselves.
+func needsApproval(r *models.Resource, u *models.User) bool {
+	return r != nil && len(r.Approvers) > 0 && !r.IsApprover(u.ID)
+}
+
+// requestApproval asks the approvers of a resource to approve a user's reservation of it. The request
+// remembers how the user asked to reserve it, so the reservation is made the same way on approval.
+func (h *Handler) requestApproval(ea *EventAction, u *models.User, res *models.Resource, duration time.Duration, priority int, ticket *models.Ticket, deadline time.Time) {
+	req := &models.ApprovalRequest{
+		ID:             models.NewID(),
+		User:           u,
+		Time:           time.Now(),
+		Duration:       duration,
+		Priority:       priority,
+		Deployment:     ea.Command.Flags["deploy"],
+		Ticket:         ticket,
+		Deadline:       deadline,
+		DropAtDeadline: ea.Command.HasFlag("drop"),
+	}
+
+	pending := false
+	r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		if r.Request(u.ID) != nil {
+			pending = true
+			return nil
+		}
+		r.Requests = append(r.Requests, req)
+		return nil
+	})
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return
+	}
+	if pending {
+		h.reply(ea, fmt.Sprintf(msgYourRequestForYIsPending, h.resourceText(r)), true)
+		return
+	}
+	ea.applied = true
+
+	text := fmt.Sprintf(msgXRequestsY, h.getUserDisplay(u, false)+ticketText(&models.Reservation{Ticket: ticket}), h.resourceText(r))
+	if duration > 0 {
+		text += " for " + durationText(duration)
+	}
+	approvers := []string{}
+	for _, id := range r.Approvers {
+		approvers = append(approvers, fmt.Sprintf("<@%s>", id))
+		value := r.String() + " " + req.ID
+		blocks := []slack.Block{
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+			slack.NewActionBlock("",
+				slack.NewButtonBlockElement(approveAction, value, slack.NewTextBlockObject(slack.PlainTextType, "Approve", false, false)).WithStyle(slack.StylePrimary),
+				slack.NewButtonBlockElement(denyAction, value, slack.NewTextBlockObject(slack.PlainTextType, "Deny", false, false)).WithStyle(slack.StyleDanger),
+			),
+		}
+		if err := h.sendDMBlocks(&models.User{ID: id}, text, blocks...); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+
+	msg := fmt.Sprintf(msgYRequiresApprovalAskedX, h.resourceText(r), strings.Join(approvers, ", "))
+	h.reply(ea, msg, true)
+}
+
+// withdraw removes a user's request awaiting approval, when they release a resource they only asked for
+func (h *Handler) withdraw(ea *EventAction, u *models.User, res *models.Resource) {
+	_, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		removeRequest(r, func(req *models.ApprovalRequest) bool { return req.User.ID == u.ID })
+		return nil
+	})
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return
+	}
+	ea.applied = true
+	h.reply(ea, fmt.Sprintf(msgYouHaveWithdrawnRequestForY, h.resourceText(res)), true)
+}
+
+// removeRequest removes the first request that matches from a resource, returning it
+func removeRequest(r *models.Resource, match func(req *models.ApprovalRequest) bool) *models.ApprovalRequest {
+	for i, req := range r.Requests {
+		if match(req) {
+			r.Requests = append(r.Requests[:i], r.Requests[i+1:]...)
+			return req
+		}
+	}
+	return nil
+}
+
+// approvalAction handles a click on an approval request's buttons. The request is taken off the
+// resource, so whichever approver decides first wins, and the message is updated with the outcome.
+func (h *Handler) approvalAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	if h.readOnly {
+		log.Infof("Read-only: would %s %s for %s", strings.TrimPrefix(action.ActionID, "approval_"), action.Value, cb.User.ID)
+		return nil
+	}
+	fields := strings.Fields(action.Value)
+	if len(fields) != 2 {
+		return nil
+	}
+	res, err := h.parseResource(fields[0], "")
+	if err != nil || res == nil {
+		return err
+	}
+	approver, err := h.getUser(cb.User.ID)
+	if err != nil {
+		return err
+	}
+
+	var req *models.ApprovalRequest
+	r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		if !r.IsApprover(approver.ID) {
+			return errNotApprover
+		}
+		req = removeRequest(r, func(req *models.ApprovalRequest) bool { return req.ID == fields[1] })
+		return nil
+	})
+	if err == errNotApprover || errors.Is(err, e.ResourceDoesNotExist) {
+		return h.updateApprovalMessage(cb, msgYouCannotApproveThis)
+	}
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return h.updateApprovalMessage(cb, msgRequestWasAlreadyHandled)
+	}
+
+	if action.ActionID == denyAction {
+		log.Infof("%s denied %s's request for %s", approver.Name, req.User.Name, r)
+		h.notify(req.User, fmt.Sprintf(msgXDeniedYourRequestForY, h.getUserDisplay(approver, false), h.resourceText(r)))
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouDeniedXRequestForY, h.getUserDisplay(req.User, false), h.resourceText(r)))
+	}
+
+	if err := h.activateRequest(r, req); err != nil {
+		if !errors.Is(err, e.InMaintenance) {
+			return err
+		}
+		h.notify(req.User, fmt.Sprintf(msgXApprovedYInMaintenance, h.getUserDisplay(approver, false), h.resourceText(r)))
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouApprovedXYInMaintenance, h.getUserDisplay(req.User, false), h.resourceText(r)))
+	}
+	log.Infof("%s approved %s's request for %s", approver.Name, req.User.Name, r)
+
+	msg := fmt.Sprintf(msgXApprovedYourRequestForY, h.getUserDisplay(approver, false), h.resourceText(r))
+	pos, err := h.data.GetPosition(req.User, r.Name, r.Env)
+	if err == nil && pos > 1 {
+		msg = fmt.Sprintf(msgXApprovedYYouAreN, h.getUserDisplay(approver, false), h.resourceText(r), util.Ordinalize(pos))
+	} else if r := h.data.GetResource(r.Name, r.Env, false); err == nil && r != nil && !r.Closed(time.Now()) && !r.Drawing() {
+		// otherwise they are told when it opens or who won the draw
+		msg = fmt.Sprintf(msgXApprovedYItIsYours, h.getUserDisplay(approver, false), h.resourceText(r))
+	}
+	h.notify(req.User, msg)
+	return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouApprovedXRequestForY, h.getUserDisplay(req.User, false), h.resourceText(r)))
+}
+
+// activateRequest makes the reservation an approved request was for
+func (h *Handler) activateRequest(r *models.Resource, req *models.ApprovalRequest) error {
+	if err := h.data.Reserve(req.User, r.Name, r.Env); err != nil && !errors.Is(err, e.AlreadyInQueue) {
+		return err
+	}
+	h.setReservationDuration(req.User, r, req.Duration)
+	if req.Priority != 0 {
+		h.setReservationPriority(req.User, r, req.Priority)
+	}
+	if req.Deployment != "" {
+		h.linkDeployment(req.User, r, req.Deployment)
+	}
+	if req.Ticket != nil {
+		h.linkTicket(req.User, r, req.Ticket)
+	}
+	if !req.Deadline.IsZero() {
+		h.setReservationDeadline(req.User, r, req.Deadline, req.DropAtDeadline)
+	}
+	return nil
+}
+
+// updateApprovalMessage replaces the buttons of the clicked approval request with the outcome
+func (h *Handler) updateApprovalMessage(cb slack.InteractionCallback, msg string) error {
+	_, _, _, err := h.client.UpdateMessage(cb.Channel.ID, cb.Message.Timestamp, slack.MsgOptionText(msg, false), slack.MsgOptionBlocks())
+	return err
+}
+
+// isApprovalAction returns if a block action belongs to an approval request
+func isApprovalAction(action *slack.BlockAction) bool {
+	return action.ActionID == approveAction || action.ActionID == denyAction
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/api"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// automationIdentity is what an automation may call itself
+var automationIdentity = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// automationUser returns the external user that holds reservations for an automation
+func automationUser(identity string) *models.User {
+	return &models.User{
+		ID:       "automation:" + identity,
+		Name:     identity,
+		External: true,
+	}
+}
+
+// AutomationWebhook returns an HTTP handler that lets automations started by monitoring and alerting
+// systems, such as an autoscaler or a nightly perf run, hold resources in the same queues as people.
+// POST /reserve joins the queue for a resource on behalf of an automation, which holds it under its
+// identity; calling it again is harmless. POST /release leaves the queue once the run completes. Requests
+// must present the secret in the X-Reservebot-Secret header, or the token of a service account, which
+// holds resources as itself. The secret may be empty to only allow service accounts.
+func (h *Handler) AutomationWebhook(secret string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reserve", func(w http.ResponseWriter, r *http.Request) {
+		req, u, res, ok := h.automationRequest(w, r, secret)
+		if ok {
+			h.automationReserve(w, req, u, res)
+		}
+	})
+	mux.HandleFunc("/release", func(w http.ResponseWriter, r *http.Request) {
+		_, u, res, ok := h.automationRequest(w, r, secret)
+		if ok {
+			h.automationRelease(w, u, res)
+		}
+	})
+	return mux
+}
+
+// automationRequest authorizes and decodes a request, returning who it is made for and writing an error
+// response if it is invalid
+func (h *Handler) automationRequest(w http.ResponseWriter, r *http.Request, secret string) (*api.AutomationRequest, *models.User, *models.Resource, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, nil, nil, false
+	}
+	account := h.serviceAccountForToken(givenSecret(r))
+	if account == nil && !h.authorizeRequest(w, r, secret, models.ScopeReserve) {
+		return nil, nil, nil, false
+	}
+
+	req := &api.AutomationRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Resource == "" {
+		http.Error(w, "body must be JSON with a resource", http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+	var u *models.User
+	if account != nil {
+		u = account.User()
+	} else if automationIdentity.MatchString(req.Identity) {
+		u = automationUser(req.Identity)
+	} else {
+		http.Error(w, "identity must be letters, digits, _, . and -", http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+	res, err := h.parseResource(req.Resource, "")
+	if err != nil || res == nil || res.Name == "" {
+		http.Error(w, "resource must be formatted as env|name", http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+	if h.data.GetResource(res.Name, res.Env, false) == nil {
+		http.Error(w, fmt.Sprintf("%s does not exist", res), http.StatusNotFound)
+		return nil, nil, nil, false
+	}
+
+	return req, u, res, true
+}
+
+func (h *Handler) automationReserve(w http.ResponseWriter, req *api.AutomationRequest, u *models.User, res *models.Resource) {
+	var limit time.Duration
+	if req.For != "" {
+		d, err := time.ParseDuration(req.For)
+		if err != nil || d <= 0 {
+			http.Error(w, "for must be a duration such as 4h", http.StatusBadRequest)
+			return
+		}
+		limit = d
+	}
+
+	if h.blockUnhealthy {
+		r := h.data.GetResource(res.Name, res.Env, false)
+		if r != nil && r.Unhealthy() {
+			http.Error(w, fmt.Sprintf("%s is failing its health check", res), http.StatusConflict)
+			return
+		}
+	}
+	if r := h.data.GetResource(res.Name, res.Env, false); r != nil && r.BlockedAsBroken() {
+		http.Error(w, fmt.Sprintf("%s is reported broken", res), http.StatusConflict)
+		return
+	}
+
+	// automations can't ask for approval, so they can only keep places they had before approvers were
+	// set
+	if r := h.data.GetResource(res.Name, res.Env, false); needsApproval(r, u) {
+		if _, err := h.data.GetPosition(u, res.Name, res.Env); errors.Is(err, e.NotInQueue) {
+			http.Error(w, fmt.Sprintf("%s requires approval", res), http.StatusForbidden)
+			return
+		}
+	}
+
+	err := h.data.Reserve(u, res.Name, res.Env)
+	switch {
+	case err == nil:
+		err := h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+			r.Job = &models.Job{
+				Provider: "automation",
+				ID:       req.Run,
+				URL:      req.URL,
+				Reason:   strings.TrimSpace(req.Reason),
+			}
+			r.Duration = limit
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+		log.Infof("Automation %s joined the queue for %s", u.Name, res)
+	case errors.Is(err, e.AlreadyInQueue):
+	case errors.Is(err, e.InMaintenance):
+		http.Error(w, fmt.Sprintf("%s is under maintenance", res), http.StatusConflict)
+		return
+	default:
+		log.Errorf("%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pos, err := h.data.GetPosition(u, res.Name, res.Env)
+	if err != nil {
+		log.Errorf("%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	waiting := false
+	if r := h.data.GetResource(res.Name, res.Env, false); r != nil {
+		waiting = r.Closed(time.Now()) || r.Drawing()
+	}
+	resp := &api.AutomationReserveResponse{
+		Position: pos,
+		Holding:  pos == 1 && !waiting,
+	}
+	if mine := h.data.GetReservation(u, res.Name, res.Env); mine != nil {
+		resp.ReservationID = mine.ID
+	}
+	writeJSON(w, resp)
+}
+
+func (h *Handler) automationRelease(w http.ResponseWriter, u *models.User, res *models.Resource) {
+	err := h.data.Remove(u, res.Name, res.Env)
+	switch {
+	case err == nil:
+		log.Infof("Automation %s released %s", u.Name, res)
+		writeJSON(w, &api.AutomationReleaseResponse{Released: true})
+	case errors.Is(err, e.NotInQueue):
+		// releasing twice is harmless, so that retried runs don't fail
+		writeJSON(w, &api.AutomationReleaseResponse{Released: false})
+	case errors.Is(err, e.ResourceDoesNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Errorf("%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
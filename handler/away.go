@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const claimTurnAction = "claim_turn"
+
+// SetAwayClaimWindow checks whether people are away in Slack when a contended resource is handed to them.
+// Those who are have the window to claim it, after which it goes to the next person waiting who isn't
+// away, and they go second in line.
+func (h *Handler) SetAwayClaimWindow(window time.Duration) {
+	h.awayClaim = window
+}
+
+// away returns if a user is away in Slack. Users whose presence can't be had are taken to be active.
+func (h *Handler) away(u *models.User) bool {
+	if u.External {
+		return false
+	}
+	p, err := h.client.GetUserPresence(u.ID)
+	if err != nil {
+		log.Debugf("Error getting the presence of %s: %+v", u.ID, err)
+		return false
+	}
+	return p.Presence == "away"
+}
+
+// offerClaim tells the new holder of a resource that it is theirs, asking them to claim it if they are
+// away and others are waiting. It returns false if they weren't asked, in which case they should be told
+// as usual.
+func (h *Handler) offerClaim(res *models.Reservation, r *models.Resource, msg string) bool {
+	if h.awayClaim <= 0 || res.User.External || res.Incident != "" {
+		return false
+	}
+	q, err := h.data.GetQueueForResource(r.Name, r.Env)
+	if err != nil || len(q.Reservations) < 2 || q.Reservations[0].User.ID != res.User.ID || !h.away(res.User) {
+		return false
+	}
+
+	claimBy := time.Now().Add(h.awayClaim)
+	err = h.updateReservation(res.User, r.Name, r.Env, func(res *models.Reservation) error {
+		res.ClaimBy = claimBy
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+		return false
+	}
+	log.Infof("%s is away, giving them until %s to claim %s", res.User.Name, claimBy, r)
+
+	text := msg + " " + fmt.Sprintf(msgClaimItWithinXOrItGoesOn, durationText(h.awayClaim))
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(claimTurnAction, r.String(), slack.NewTextBlockObject(slack.PlainTextType, "Claim it", false, false)).WithStyle(slack.StylePrimary),
+		),
+	}
+	if err := h.sendDMBlocks(res.User, text, blocks...); err != nil {
+		log.Errorf("%+v", err)
+	}
+	return true
+}
+
+// claimAction handles a click on the button to claim a resource handed over while away
+func (h *Handler) claimAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	if h.readOnly {
+		log.Infof("Read-only: would claim %s for %s", action.Value, cb.User.ID)
+		return nil
+	}
+	res, err := h.parseResource(action.Value, "")
+	if err != nil || res == nil {
+		return err
+	}
+	u, err := h.getUser(cb.User.ID)
+	if err != nil {
+		return err
+	}
+
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if err != nil || !q.HasReservations() || q.Reservations[0].User.ID != u.ID {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgTooLateToClaimY, h.resourceText(res)))
+	}
+	if !q.Reservations[0].ClaimBy.IsZero() {
+		err := h.updateReservation(u, res.Name, res.Env, func(res *models.Reservation) error {
+			res.ClaimBy = time.Time{}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		log.Infof("%s claimed %s", u.Name, res)
+	}
+	return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouClaimedY, h.resourceText(res)))
+}
+
+// CheckClaims hands on resources that weren't claimed in time by holders who were away when they got
+// them. The resource goes to whoever the policy chooses among those waiting who aren't away, and the
+// holder goes second in line, so that they get it back next. Holders who are back keep the resource, and
+// so do holders whose whole line is away.
+func (h *Handler) CheckClaims() {
+	if h.readOnly || h.sandbox {
+		return
+	}
+	now := time.Now()
+
+	for _, q := range h.data.GetQueues() {
+		r := q.Resource
+		if !q.HasReservations() || r.Drawing() || q.Resetting() {
+			continue
+		}
+		holder := q.Reservations[0]
+		if holder.ClaimBy.IsZero() || now.Before(holder.ClaimBy) {
+			continue
+		}
+
+		line := []*models.Reservation{}
+		if h.away(holder.User) {
+			for _, res := range q.Reservations[1:] {
+				if !h.away(res.User) {
+					line = append(line, res)
+				}
+			}
+		}
+		var next *models.Reservation
+		if len(line) > 0 {
+			next = h.policyFor(r).Next(r, line)
+		}
+		if next == nil {
+			// they are back, or nobody waiting is around to use it either, so it stays theirs
+			err := h.updateReservation(holder.User, r.Name, r.Env, func(res *models.Reservation) error {
+				res.ClaimBy = time.Time{}
+				return nil
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+			continue
+		}
+
+		// the promotion puts the holder second, and whoever it skips to is told here
+		h.skipping.Store(r.Key(), true)
+		err := h.data.Promote(next.User, r.Name, r.Env)
+		h.skipping.Delete(r.Key())
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		err = h.updateReservation(holder.User, r.Name, r.Env, func(res *models.Reservation) error {
+			res.ClaimBy = time.Time{}
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+		log.Infof("%s didn't claim %s in time, skipped to %s", holder.User.Name, r, next.User.Name)
+
+		h.notify(next.User, fmt.Sprintf(msgXIsAwaySoYIsYours, h.getUserDisplay(holder.User, false), h.resourceText(r)))
+		h.notify(holder.User, fmt.Sprintf(msgYouDidntClaimYYouAreN, h.resourceText(r), util.Ordinalize(2)))
+	}
+}
+
+// claimText returns a note that the holder of a resource has yet to claim it, for status. An empty string
+// is returned if they don't need to.
+func claimText(q *models.Queue, loc *time.Location) string {
+	if !q.HasReservations() || q.Reservations[0].ClaimBy.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(" :hourglass: Not claimed yet, so it goes to the next person around at %s.", q.Reservations[0].ClaimBy.In(loc).Format(hoursTimeFormat))
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// batch collects the replies to a message giving several commands, so that they are posted together as
+// one message with a section for each command, in the order the commands were given
+type batch struct {
+	// sections are the replies collected so far, each headed by its command
+	sections []string
+	// header heads the replies to the command being handled. It is added before the first of them.
+	header string
+}
+
+// next starts collecting the replies to the next command of the message
+func (b *batch) next(line int, text string) {
+	b.header = fmt.Sprintf(msgBatchCommandXY, line+1, text)
+}
+
+// add collects a reply to the command being handled
+func (b *batch) add(text string) {
+	if b.header != "" {
+		if len(b.sections) > 0 {
+			// a blank line sets each command's replies apart
+			b.sections = append(b.sections, "")
+		}
+		b.sections = append(b.sections, b.header)
+		b.header = ""
+	}
+	b.sections = append(b.sections, text)
+}
+
+// batched collects a reply to a command given with others. Replies laid out with blocks, such as those
+// with buttons, can't be combined, so they are posted on their own after the replies collected before
+// them.
+func (h *Handler) batched(ea *EventAction, options []slack.MsgOption) error {
+	_, values, err := slack.UnsafeApplyMsgOptions("", ea.Event.Channel, "", options...)
+	if err != nil {
+		return err
+	}
+	if values.Get("blocks") == "" {
+		ea.batch.add(values.Get("text"))
+		return nil
+	}
+	if err := h.postBatch(ea); err != nil {
+		return err
+	}
+	alone := *ea
+	alone.batch = nil
+	return h.post(&alone, options...)
+}
+
+// postBatch posts the replies collected for a message giving several commands, if there are any
+func (h *Handler) postBatch(ea *EventAction) error {
+	b := ea.batch
+	if len(b.sections) == 0 {
+		return nil
+	}
+	text := strings.Join(b.sections, "\n")
+	b.sections = nil
+
+	alone := *ea
+	alone.batch = nil
+	return h.post(&alone, slack.MsgOptionText(text, false))
+}
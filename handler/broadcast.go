@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// broadcast DMs a message to everyone holding or waiting for a resource, or any resource in an env, such
+// as a warning that the env is going down for maintenance. A filter without a `|` is an env, unless the
+// env has no resources and resources may be given without one.
+func (h *Handler) broadcast(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	filter := strings.Trim(ea.Command.Args[0], "`")
+	text := strings.TrimSpace(ea.Command.Rest(1))
+
+	var queues []*models.Queue
+	target := fmt.Sprintf("`%s`", filter)
+	if !strings.Contains(filter, "|") && (h.reqEnv || len(h.data.GetResourcesForEnv(filter)) > 0) {
+		for _, q := range h.data.GetQueuesForEnv(filter) {
+			queues = append(queues, q)
+		}
+	} else {
+		res, err := h.parseResource(filter, h.defaultEnv(ea.Event.User))
+		if err != nil || res == nil {
+			h.handleGetResourceError(ea, err)
+			return err
+		}
+		q, err := h.data.GetQueueForResource(res.Name, res.Env)
+		if errors.Is(err, e.ResourceDoesNotExist) {
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			return nil
+		}
+		if err != nil {
+			h.errorReply(ea, e.Message(err))
+			return err
+		}
+		queues = append(queues, q)
+		target = h.resourceText(q.Resource)
+	}
+
+	sent := map[string]bool{}
+	for _, q := range queues {
+		for _, res := range q.Reservations {
+			if res.User.ID == u.ID || res.User.External || sent[res.User.ID] {
+				continue
+			}
+			sent[res.User.ID] = true
+			h.notify(res.User, fmt.Sprintf(msgBroadcastFromXToYZ, h.getUserDisplay(u, false), target, text))
+		}
+	}
+	if len(sent) == 0 {
+		return h.reply(ea, fmt.Sprintf(msgNobodyToBroadcastToInY, target), true)
+	}
+	log.Infof("%s broadcast to %d users of %s", u.Name, len(sent), filter)
+
+	return h.reply(ea, fmt.Sprintf(msgSentYourMessageToYN, target, len(sent)), true)
+}
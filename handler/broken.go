@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// errNotBroken is returned when a resource that isn't reported broken is marked fixed
+var errNotBroken = errors.New("not reported broken")
+
+// SetBrokenChannel posts reports of broken resources, and their fixes, to a channel, such as the one the
+// resources' owners triage in
+func (h *Handler) SetBrokenChannel(channel string) {
+	h.brokenChannel = channel
+}
+
+// reportBroken flags a resource as broken, for when something is wrong with it that its holder can't fix,
+// such as a stuck deploy or a corrupted database. The resource's owner, the members of the admin groups
+// and everyone in line are told, and the report is shown in status until the resource is marked fixed.
+// With --block, the resource can't be reserved until then.
+func (h *Handler) reportBroken(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	res, err := h.parseResource(strings.Trim(ea.Command.Args[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return nil
+	}
+	details := strings.TrimSpace(ea.Command.Rest(1))
+
+	var already *models.BrokenReport
+	report := &models.BrokenReport{
+		Reporter: u.ID,
+		Details:  details,
+		Reported: time.Now(),
+		Blocking: ea.Command.HasFlag("block"),
+	}
+	r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		already = r.Broken
+		if already != nil {
+			return nil
+		}
+		r.Broken = report
+		return nil
+	})
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+	if already != nil {
+		reporter, err := h.getUser(already.Reporter)
+		if err != nil {
+			reporter = &models.User{ID: already.Reporter}
+		}
+		return h.reply(ea, fmt.Sprintf(msgYWasReportedBrokenByX, h.resourceText(r), h.getUserDisplay(reporter, false), brokenDetails(already)), true)
+	}
+	log.Infof("%s reported %s broken: %s", u.Name, r, details)
+
+	text := fmt.Sprintf(msgXReportedYBrokenZ, h.getUserDisplay(u, false), h.resourceText(r), brokenDetails(report))
+	if report.Blocking {
+		text += " " + msgItCantBeReservedUntilFixed
+	}
+	h.tellAboutBroken(r, u.ID, text)
+
+	reply := fmt.Sprintf(msgReportedYBroken, h.resourceText(r))
+	if report.Blocking {
+		reply += " " + msgItCantBeReservedUntilFixed
+	}
+	return h.reply(ea, reply, true)
+}
+
+// markFixed resolves the report that a resource is broken, telling whoever reported it and everyone in
+// line, and lets the resource be reserved again if the report stopped it
+func (h *Handler) markFixed(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	res, err := h.parseResource(strings.Trim(ea.Command.Args[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return nil
+	}
+	notes := strings.TrimSpace(ea.Command.Rest(1))
+
+	var report *models.BrokenReport
+	r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		if r.Broken == nil {
+			return errNotBroken
+		}
+		report = r.Broken
+		r.Broken = nil
+		return nil
+	})
+	if err == errNotBroken {
+		h.errorReply(ea, fmt.Sprintf(msgYIsNotReportedBroken, h.resourceText(res)))
+		return nil
+	}
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+	took := durationText(time.Since(report.Reported))
+	log.Infof("%s marked %s fixed after %s", u.Name, r, took)
+
+	text := fmt.Sprintf(msgXMarkedYFixedAfterZ, h.getUserDisplay(u, false), h.resourceText(r), took)
+	if notes != "" {
+		text += fmt.Sprintf(": %s", notes)
+	}
+	h.tellAboutBroken(r, u.ID, text, report.Reporter)
+
+	return h.reply(ea, fmt.Sprintf(msgMarkedYFixed, h.resourceText(r), took), true)
+}
+
+// tellAboutBroken sends news about a broken resource to the broken channel, if there is one, and in a DM
+// to the resource's owner, the members of the admin groups, everyone in line and anyone else given,
+// except whoever brought the news. Who is in line for private resources isn't posted to the channel, but
+// whether they are broken is.
+func (h *Handler) tellAboutBroken(r *models.Resource, from, text string, others ...string) {
+	ids := map[string]bool{}
+	if r.Owner != "" {
+		ids[r.Owner] = true
+	}
+	h.adminGroups.lock.RLock()
+	for id := range h.adminGroups.members {
+		ids[id] = true
+	}
+	h.adminGroups.lock.RUnlock()
+	if q, err := h.data.GetQueueForResource(r.Name, r.Env); err == nil {
+		for _, res := range q.Reservations {
+			if !res.User.External {
+				ids[res.User.ID] = true
+			}
+		}
+	}
+	for _, id := range others {
+		ids[id] = true
+	}
+	delete(ids, from)
+
+	sorted := []string{}
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	for _, id := range sorted {
+		u, err := h.getUser(id)
+		if err != nil {
+			u = &models.User{ID: id}
+		}
+		h.notify(u, text)
+	}
+
+	if h.brokenChannel == "" {
+		return
+	}
+	if h.readOnly {
+		log.Infof("Read-only: would post to %s: %s", h.brokenChannel, text)
+		return
+	}
+	if _, _, err := h.client.PostMessage(h.brokenChannel, slack.MsgOptionText(text, false)); err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// brokenText returns a note that a resource was reported broken, for status. An empty string is returned
+// if it wasn't.
+func (h *Handler) brokenText(r *models.Resource, loc *time.Location) string {
+	if r.Broken == nil {
+		return ""
+	}
+	reporter, err := h.getUser(r.Broken.Reporter)
+	if err != nil {
+		reporter = &models.User{ID: r.Broken.Reporter}
+	}
+	msg := fmt.Sprintf(" :rotating_light: Reported broken by %s at %s%s.", h.getUserDisplay(reporter, false), r.Broken.Reported.In(loc).Format(hoursTimeFormat), brokenDetails(r.Broken))
+	if r.Broken.Blocking {
+		msg += " " + msgItCantBeReservedUntilFixed
+	}
+	return msg
+}
+
+// brokenDetails returns the details of a report to follow what was reported
+func brokenDetails(report *models.BrokenReport) string {
+	if report.Details == "" {
+		return ""
+	}
+	return fmt.Sprintf(": %s", report.Details)
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// oversubscribedShare is the share of the sampled time someone must have been waiting for a resource
+	// for it to be reported as oversubscribed
+	oversubscribedShare = 0.25
+	// oversubscribedDepth is how many people must have been waiting for a resource on average for it to
+	// be reported as oversubscribed
+	oversubscribedDepth = 1.0
+)
+
+// SampleContention records how many people are waiting for each resource, as having lasted for the
+// interval since the last sample. Nobody holds a resource awaiting a draw, so everyone in line is waiting.
+func (h *Handler) SampleContention(interval time.Duration) {
+	now := time.Now().UTC()
+	for _, q := range h.data.GetQueues() {
+		waiting := len(q.Reservations)
+		if waiting > 0 && !q.Resource.Drawing() {
+			waiting--
+		}
+		c := &models.Contention{
+			Resource: q.Resource.String(),
+			Weekday:  now.Weekday(),
+			Hour:     now.Hour(),
+			Sampled:  interval.Hours(),
+		}
+		if waiting > 0 {
+			c.Contended = interval.Hours()
+			c.Waiting = float64(waiting) * interval.Hours()
+		}
+		if err := h.data.AddContention(c); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+}
+
+// contentionTotal sums contention
+type contentionTotal struct {
+	sampled   float64
+	contended float64
+	waiting   float64
+}
+
+func (t *contentionTotal) add(c *models.Contention) {
+	t.sampled += c.Sampled
+	t.contended += c.Contended
+	t.waiting += c.Waiting
+}
+
+// share returns the share of the sampled time someone was waiting
+func (t *contentionTotal) share() float64 {
+	if t.sampled == 0 {
+		return 0
+	}
+	return t.contended / t.sampled
+}
+
+// depth returns how many people were waiting on average
+func (t *contentionTotal) depth() float64 {
+	if t.sampled == 0 {
+		return 0
+	}
+	return t.waiting / t.sampled
+}
+
+func (t *contentionTotal) oversubscribed() bool {
+	return t.share() >= oversubscribedShare || t.depth() >= oversubscribedDepth
+}
+
+// localHour converts the UTC hour of the week contention was sampled in to a timezone
+func localHour(c *models.Contention, loc *time.Location) (time.Weekday, int) {
+	// 2023-01-01 was a Sunday
+	t := time.Date(2023, time.January, 1+int(c.Weekday), c.Hour, 0, 0, 0, time.UTC).In(loc)
+	return t.Weekday(), t.Hour()
+}
+
+// contentionEnv returns the env of the resource contention was sampled for
+func contentionEnv(c *models.Contention) string {
+	if i := strings.Index(c.Resource, "|"); i != -1 {
+		return c.Resource[:i]
+	}
+	return ""
+}
+
+// capacityReport analyzes how contended each resource has been, by env, and suggests which are
+// oversubscribed. It is laid out with blocks, or uploaded as a CSV file with --csv. Hours are shown in
+// the timezone of whoever asked.
+func (h *Handler) capacityReport(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	contention, err := h.data.GetContention()
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, msgIDontKnow)
+		return err
+	}
+	if len(contention) == 0 {
+		return h.reply(ea, msgNoContentionSampled, false)
+	}
+
+	loc := u.Location()
+	if ea.Command.HasFlag("csv") {
+		return h.capacityCSV(ea, contention, loc)
+	}
+
+	envs := map[string]bool{}
+	resources := map[string]*contentionTotal{}
+	days := map[string]map[time.Weekday]*contentionTotal{}
+	hours := map[string]map[int]*contentionTotal{}
+	for _, c := range contention {
+		env := contentionEnv(c)
+		if !envs[env] {
+			envs[env] = true
+			days[env] = map[time.Weekday]*contentionTotal{}
+			hours[env] = map[int]*contentionTotal{}
+		}
+		if resources[c.Resource] == nil {
+			resources[c.Resource] = &contentionTotal{}
+		}
+		resources[c.Resource].add(c)
+
+		day, hour := localHour(c, loc)
+		if days[env][day] == nil {
+			days[env][day] = &contentionTotal{}
+		}
+		days[env][day].add(c)
+		if hours[env][hour] == nil {
+			hours[env][hour] = &contentionTotal{}
+		}
+		hours[env][hour].add(c)
+	}
+
+	names := []string{}
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	envNames := []string{}
+	for env := range envs {
+		envNames = append(envNames, env)
+	}
+	sort.Strings(envNames)
+
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Capacity report", false, false)),
+	}
+	oversubscribed := []string{}
+	for _, env := range envNames {
+		title := "No env"
+		if env != "" {
+			title = env
+		}
+		lines := []string{fmt.Sprintf("*%s*", title)}
+
+		byDay := []string{}
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if t := days[env][d]; t != nil {
+				byDay = append(byDay, fmt.Sprintf("%s %.1f", d.String()[:3], t.depth()))
+			}
+		}
+		lines = append(lines, "Waiting by day: "+strings.Join(byDay, " · "))
+		byHour := []string{}
+		for hour := 0; hour < 24; hour++ {
+			if t := hours[env][hour]; t != nil && t.depth() > 0 {
+				byHour = append(byHour, fmt.Sprintf("%02d:00 %.1f", hour, t.depth()))
+			}
+		}
+		if len(byHour) > 0 {
+			lines = append(lines, "Waiting by hour: "+strings.Join(byHour, " · "))
+		}
+
+		for _, name := range names {
+			t := resources[name]
+			if contentionEnv(&models.Contention{Resource: name}) != env {
+				continue
+			}
+			line := fmt.Sprintf("• `%s`: someone waiting %.1fh of %.1fh sampled (%.0f%%), %.1f waiting on average", name, t.contended, t.sampled, t.share()*100, t.depth())
+			if t.oversubscribed() {
+				line += " :warning:"
+				oversubscribed = append(oversubscribed, fmt.Sprintf("`%s`", name))
+			}
+			lines = append(lines, line)
+		}
+		blocks = append(blocks,
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, strings.Join(lines, "\n"), false, false), nil, nil),
+			slack.NewDividerBlock(),
+		)
+	}
+
+	summary := msgNothingIsOversubscribed
+	if len(oversubscribed) > 0 {
+		summary = fmt.Sprintf(msgOversubscribedX, strings.Join(oversubscribed, ", "))
+	}
+	blocks = append(blocks,
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, summary, false, false), nil, nil),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("Waiting is the average number of people in line behind the holder. Hours are in %s.", loc), false, false)),
+	)
+
+	return h.post(ea, slack.MsgOptionText(summary, false), slack.MsgOptionBlocks(blocks...))
+}
+
+// capacityCSV uploads the contention of each resource and hour of the week as a CSV file
+func (h *Handler) capacityCSV(ea *EventAction, contention []*models.Contention, loc *time.Location) error {
+	sort.SliceStable(contention, func(i, j int) bool {
+		return contention[i].Resource < contention[j].Resource
+	})
+
+	rows := [][]string{{"env", "resource", "weekday", "hour", "sampled_hours", "contended_hours", "average_waiting"}}
+	for _, c := range contention {
+		t := &contentionTotal{}
+		t.add(c)
+		day, hour := localHour(c, loc)
+		rows = append(rows, []string{
+			contentionEnv(c),
+			c.Resource,
+			day.String(),
+			fmt.Sprintf("%02d:00", hour),
+			fmt.Sprintf("%.2f", t.sampled),
+			fmt.Sprintf("%.2f", t.contended),
+			fmt.Sprintf("%.2f", t.depth()),
+		})
+	}
+	return h.uploadCSV(ea, "capacity.csv", fmt.Sprintf("Capacity report (hours in %s)", loc), rows)
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// catalogMarker starts a list of resources in a channel's topic or in the title of one of its
+	// bookmarks, e.g. `reservebot: dev|db, dev|api`
+	catalogMarker = "reservebot:"
+	// catalogSourcePrefix marks resources declared in a channel, followed by the channel's ID
+	catalogSourcePrefix = "channel:"
+)
+
+// catalogEntry is a resource declared in a channel
+type catalogEntry struct {
+	res *models.Resource
+	// url is the link of the bookmark declaring the resource, if it was declared alone by one
+	url string
+}
+
+// SyncChannelCatalogs keeps the resources declared in channels in step with their declarations, so that
+// teams can manage their resources where they already document their environments. A channel declares
+// resources in its topic, or in the titles of its bookmarks, after `reservebot:`, separated by commas,
+// e.g. `reservebot: dev|db, dev|api`. A bookmark declaring one resource sets its URL to the bookmark's link.
+// Declared resources are created, and resources a channel declared are removed once it no longer does,
+// but not while anyone is in line for them. Resources created in other ways are never taken over or
+// removed.
+func (h *Handler) SyncChannelCatalogs(channels []string) error {
+	failed := []string{}
+	for _, channel := range channels {
+		if channel = strings.TrimSpace(channel); channel == "" {
+			continue
+		}
+		if err := h.syncChannelCatalog(channel); err != nil {
+			log.Errorf("Error syncing the resources declared in %s: %+v", channel, err)
+			failed = append(failed, channel)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("syncing the resources declared in %s failed", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (h *Handler) syncChannelCatalog(channel string) error {
+	entries, err := h.channelCatalog(channel)
+	if err != nil {
+		return err
+	}
+	source := catalogSourcePrefix + channel
+
+	declared := map[string]bool{}
+	for _, ent := range entries {
+		res := ent.res
+		declared[res.Key()] = true
+		r := h.data.GetResource(res.Name, res.Env, false)
+		if r != nil && r.Source != source {
+			continue
+		}
+		if r == nil {
+			if msg := h.misnamed(res); msg != "" {
+				log.Warnf("%s declares %s, which doesn't follow the naming convention", channel, res)
+				continue
+			}
+			if h.readOnly {
+				log.Infof("Read-only: would create %s, declared in %s", res, channel)
+				continue
+			}
+			if err := h.data.Create(res.Name, res.Env); err != nil {
+				log.Errorf("Error creating %s: %+v", res, err)
+				continue
+			}
+			log.Infof("Created %s, declared in %s", res, channel)
+		} else if ent.url == "" || r.URL == ent.url || h.readOnly {
+			continue
+		}
+		_, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+			r.Source = source
+			if ent.url != "" {
+				r.URL = ent.url
+			}
+			return nil
+		})
+		if err != nil {
+			log.Errorf("Error updating %s: %+v", res, err)
+		}
+	}
+
+	for _, q := range h.data.GetQueues() {
+		r := q.Resource
+		if r.Source != source || declared[r.Key()] {
+			continue
+		}
+		if q.HasReservations() {
+			log.Infof("%s is no longer declared in %s but is still reserved, keeping it until it is released", r, channel)
+			continue
+		}
+		if h.readOnly {
+			log.Infof("Read-only: would remove %s, no longer declared in %s", r, channel)
+			continue
+		}
+		if err := h.data.RemoveResource(r.Name, r.Env); err != nil && !errors.Is(err, e.ResourceDoesNotExist) {
+			log.Errorf("Error removing %s: %+v", r, err)
+			continue
+		}
+		log.Infof("Removed %s, no longer declared in %s", r, channel)
+	}
+	return nil
+}
+
+// channelCatalog returns the resources declared in a channel's topic and bookmarks
+func (h *Handler) channelCatalog(channel string) ([]*catalogEntry, error) {
+	info, err := h.client.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channel})
+	if err != nil {
+		return nil, err
+	}
+	bookmarks, err := h.client.ListBookmarks(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*catalogEntry{}
+	seen := map[string]bool{}
+	add := func(list []string, url string) {
+		if len(list) != 1 {
+			url = ""
+		}
+		for _, text := range list {
+			res, err := h.parseResource(text, "")
+			if err != nil || res == nil || res.Name == "" {
+				log.Warnf("%s declares %q, which isn't a resource", channel, text)
+				continue
+			}
+			if seen[res.Key()] {
+				continue
+			}
+			seen[res.Key()] = true
+			ret = append(ret, &catalogEntry{res: res, url: url})
+		}
+	}
+	// bookmarks go first, so that a resource declared by one gets its link
+	for _, b := range bookmarks {
+		add(catalogList(b.Title), b.Link)
+	}
+	add(catalogList(info.Topic.Value), "")
+	return ret, nil
+}
+
+// catalogList returns the resources listed after the marker in text, up to the end of the line or a
+// semicolon. Nothing is returned if text has no marker.
+func catalogList(text string) []string {
+	i := strings.Index(strings.ToLower(text), catalogMarker)
+	if i == -1 {
+		return nil
+	}
+	list := text[i+len(catalogMarker):]
+	if end := strings.IndexAny(list, ";\n"); end != -1 {
+		list = list[:end]
+	}
+
+	ret := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), "`"); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
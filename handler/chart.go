@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ameliagapin/reservebot/models"
+)
+
+const (
+	// chartMinQueue is the fewest reservations a queue needs for its status to include a chart
+	chartMinQueue = 3
+	// chartWidth is the most characters a bar of a chart takes
+	chartWidth = 20
+)
+
+// chartRow is a reservation in a chart, with how long it has been held and how long it will be held, if
+// known
+type chartRow struct {
+	name  string
+	held  time.Duration
+	left  time.Duration
+	label string
+}
+
+// queueChart draws a long queue as a chart of bars, one per reservation, scaled to the length of each
+// hold: the part of the current hold already used is solid and the rest of each hold is shaded.
+// Reservations without a limit get a single mark. It is empty for short queues and queues whose holders
+// aren't revealed.
+func (h *Handler) queueChart(q *models.Queue, reveal bool) string {
+	if !reveal || len(q.Reservations) < chartMinQueue || q.Resource.Drawing() || q.Resetting() {
+		return ""
+	}
+
+	now := time.Now()
+	rows := []*chartRow{}
+	var longest time.Duration
+	width := 0
+	for i, res := range q.Reservations {
+		row := &chartRow{name: h.userName(res.User)}
+		d := res.Duration
+		if d <= 0 {
+			d = q.Resource.DefaultDuration
+		}
+		if i == 0 {
+			row.held = now.Sub(res.Time)
+			row.label = fmt.Sprintf("held %s", durationText(row.held))
+			if d > 0 {
+				if row.left = d - row.held; row.left < 0 {
+					row.left = 0
+				}
+				row.label += fmt.Sprintf(", %s left", durationText(row.left))
+			}
+		} else {
+			row.left = d
+			row.label = "no limit"
+			if d > 0 {
+				row.label = fmt.Sprintf("%s hold", durationText(d))
+			}
+			if turn, ok := estimatedTurn(q, i, now); ok {
+				row.label += fmt.Sprintf(", starts in ~%s", durationText(turn.Sub(now)))
+			}
+		}
+		if row.held+row.left > longest {
+			longest = row.held + row.left
+		}
+		if n := utf8.RuneCountInString(row.name); n > width {
+			width = n
+		}
+		rows = append(rows, row)
+	}
+
+	lines := []string{}
+	for _, row := range rows {
+		held, left := bar(row.held, longest), bar(row.left, longest)
+		if held+left == 0 {
+			left = 1
+		}
+		pad := strings.Repeat(" ", width-utf8.RuneCountInString(row.name))
+		lines = append(lines, fmt.Sprintf("%s%s %s%s %s", row.name, pad, strings.Repeat("█", held), strings.Repeat("░", left), row.label))
+	}
+	return "\n```" + strings.Join(lines, "\n") + "```"
+}
+
+// bar returns the characters of a bar for a duration, out of chartWidth for the longest. Durations
+// under a minute aren't drawn.
+func bar(d, longest time.Duration) int {
+	if d < time.Minute || longest <= 0 {
+		return 0
+	}
+	n := int(int64(d) * chartWidth / int64(longest))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	checklistBlock         = "checklist"
+	checklistCheckAction   = "checklist_check"
+	checklistReleaseAction = "checklist_release"
+)
+
+// errChecklistStale is returned when a checklist is completed for a reservation that no longer holds the
+// resource
+var errChecklistStale = errors.New("checklist stale")
+
+// setChecklist sets what the holder must check off before releasing the resource, separated by
+// semicolons
+func setChecklist(r *models.Resource, value string) (string, error) {
+	if value == "none" {
+		r.Checklist = nil
+		return "none", nil
+	}
+	items := []string{}
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return "", errors.New(msgInvalidChecklist)
+	}
+	r.Checklist = items
+	return strings.Join(items, "; "), nil
+}
+
+// sendChecklist sends the holder of a resource with a checklist a DM to check it off. The resource is only
+// released once they have checked off every item, so the next person in line doesn't get it before then.
+func (h *Handler) sendChecklist(ea *EventAction, res *models.Reservation) {
+	r := res.Resource
+	text := fmt.Sprintf(msgCheckOffBeforeReleasingY, h.resourceText(r))
+	options := []*slack.OptionBlockObject{}
+	for i, item := range r.Checklist {
+		options = append(options, slack.NewOptionBlockObject(strconv.Itoa(i), slack.NewTextBlockObject(slack.PlainTextType, item, false, false), nil))
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock(checklistBlock,
+			slack.NewCheckboxGroupsBlockElement(checklistCheckAction, options...),
+		),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(checklistReleaseAction, r.String()+" "+res.ID, slack.NewTextBlockObject(slack.PlainTextType, "Release", false, false)).WithStyle(slack.StylePrimary),
+		),
+	}
+	if err := h.sendDMBlocks(res.User, text, blocks...); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, msgIDontKnow)
+		return
+	}
+	h.reply(ea, fmt.Sprintf(msgCheckOffChecklistForY, h.resourceText(r)), true)
+}
+
+// checklistAction handles a click on a checklist. Checking items off only changes the message. Releasing
+// records what was checked off on the reservation, so the release in the history says so, and releases
+// the resource if everything was.
+func (h *Handler) checklistAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	if action.ActionID == checklistCheckAction {
+		return nil
+	}
+	if h.readOnly {
+		log.Infof("Read-only: would release %s after its checklist for %s", action.Value, cb.User.ID)
+		return nil
+	}
+	fields := strings.Fields(action.Value)
+	if len(fields) != 2 {
+		return nil
+	}
+	res, err := h.parseResource(fields[0], "")
+	if err != nil || res == nil {
+		return err
+	}
+	u, err := h.getUser(cb.User.ID)
+	if err != nil {
+		return err
+	}
+
+	checked := map[int]bool{}
+	if cb.BlockActionState != nil {
+		for _, opt := range cb.BlockActionState.Values[checklistBlock][checklistCheckAction].SelectedOptions {
+			if i, err := strconv.Atoi(opt.Value); err == nil {
+				checked[i] = true
+			}
+		}
+	}
+
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if err != nil && !errors.Is(err, e.ResourceDoesNotExist) {
+		return err
+	}
+	if q == nil || !q.HasReservations() || q.Resource.Drawing() || q.Reservations[0].ID != fields[1] || q.Reservations[0].User.ID != u.ID {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouNoLongerHoldY, h.resourceText(res)))
+	}
+	items := []string{}
+	for i, item := range q.Resource.Checklist {
+		if !checked[i] {
+			h.notify(u, fmt.Sprintf(msgCheckOffEverythingForY, h.resourceText(q.Resource)))
+			return nil
+		}
+		items = append(items, item)
+	}
+
+	err = h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+		if r.ID != fields[1] {
+			return errChecklistStale
+		}
+		r.Checked = items
+		return nil
+	})
+	if err == errChecklistStale || errors.Is(err, e.NotInQueue) {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouNoLongerHoldY, h.resourceText(res)))
+	}
+	if err != nil {
+		return err
+	}
+	q.Reservations[0].Checked = items
+	resetting, err := h.startReset(q.Reservations[0])
+	if err != nil {
+		return err
+	}
+	if resetting {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgYIsResetting, h.resourceText(q.Resource)))
+	}
+	if err := h.data.Remove(u, res.Name, res.Env); err != nil {
+		if errors.Is(err, e.NotInQueue) || errors.Is(err, e.ResourceDoesNotExist) {
+			return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouNoLongerHoldY, h.resourceText(res)))
+		}
+		return err
+	}
+	log.Infof("%s checked off %d items and released %s", u.Name, len(items), res)
+
+	// the next user is notified by HandleEvent
+	return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouCheckedOffAndReleasedY, h.resourceText(q.Resource))+reservationIDText(q.Reservations[0]))
+}
+
+// isChecklistAction returns if a block action belongs to a checklist
+func isChecklistAction(action *slack.BlockAction) bool {
+	return action.ActionID == checklistCheckAction || action.ActionID == checklistReleaseAction
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/command"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	confirmClearEnvAction = "clearenv_confirm"
+	cancelClearEnvAction  = "clearenv_cancel"
+	// clearEnvExpiry is how long an admin has to confirm clearing an env
+	clearEnvExpiry = 10 * time.Minute
+)
+
+// clearEnv asks the admin who sent it to confirm clearing the queues of every resource in an env, such as
+// after an env-wide rebuild. The confirmation is sent as a DM so nobody else can click it.
+func (h *Handler) clearEnv(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	env := ea.Command.Args[0]
+
+	queues := h.envQueuesToClear(env)
+	if len(queues) == 0 {
+		return h.reply(ea, fmt.Sprintf(msgNoQueuesToClearInX, env), false)
+	}
+
+	text := fmt.Sprintf(msgClearEveryQueueInXConfirm, env, h.clearEnvText(queues))
+	value := fmt.Sprintf("%s %s %d", u.ID, env, time.Now().Unix())
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(confirmClearEnvAction, value, slack.NewTextBlockObject(slack.PlainTextType, fmt.Sprintf("Clear %d queues", len(queues)), false, false)).WithStyle(slack.StyleDanger),
+			slack.NewButtonBlockElement(cancelClearEnvAction, value, slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false)),
+		),
+	}
+	if err := h.sendDMBlocks(u, text, blocks...); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, msgIDontKnow)
+		return err
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgConfirmClearingXInDM, env), true)
+}
+
+// envQueuesToClear returns the queues in an env that anyone holds or waits for, by resource name
+func (h *Handler) envQueuesToClear(env string) []*models.Queue {
+	queues := []*models.Queue{}
+	for _, q := range h.data.GetQueuesForEnv(env) {
+		if q.HasReservations() {
+			queues = append(queues, q)
+		}
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Resource.Name < queues[j].Resource.Name
+	})
+	return queues
+}
+
+// clearEnvText describes the queues that would be cleared
+func (h *Handler) clearEnvText(queues []*models.Queue) string {
+	ret := []string{}
+	for _, q := range queues {
+		ret = append(ret, fmt.Sprintf("%s (%d in line)", h.resourceText(q.Resource), len(q.Reservations)))
+	}
+	return strings.Join(ret, ", ")
+}
+
+// clearEnvAction handles a click on the confirmation of clearing an env. Confirming clears the queues
+// that are in use at that time, and tells everyone who was in them.
+func (h *Handler) clearEnvAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	if h.readOnly {
+		log.Infof("Read-only: would %s clearing env %s for %s", strings.TrimPrefix(action.ActionID, "clearenv_"), action.Value, cb.User.ID)
+		return nil
+	}
+	fields := strings.Fields(action.Value)
+	if len(fields) != 3 || cb.User.ID != fields[0] {
+		return nil
+	}
+	env := fields[1]
+	if action.ActionID == cancelClearEnvAction {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouDidNotClearX, env))
+	}
+	asked, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || time.Since(time.Unix(asked, 0)) > clearEnvExpiry {
+		return h.updateApprovalMessage(cb, msgThisConfirmationHasExpired)
+	}
+
+	u, err := h.getUser(cb.User.ID)
+	if err != nil {
+		return err
+	}
+	// they may have lost admin access since asking
+	if !h.mayRun(u, "clearenv") {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgNotAuthorizedToRunX, command.Name("clearenv")))
+	}
+
+	cleared := []*models.Queue{}
+	affected := map[string]*models.User{}
+	lost := map[string][]string{}
+	for _, q := range h.envQueuesToClear(env) {
+		r := q.Resource
+		err := h.data.ClearQueueForResource(r.Name, r.Env)
+		if errors.Is(err, e.ResourceDoesNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		cleared = append(cleared, q)
+		for _, res := range q.Reservations {
+			if res.User.ID == u.ID {
+				continue
+			}
+			affected[res.User.ID] = res.User
+			lost[res.User.ID] = append(lost[res.User.ID], h.resourceText(r))
+		}
+	}
+	if len(cleared) == 0 {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgNoQueuesToClearInX, env))
+	}
+	log.Infof("%s cleared %d queues in %s", u.Name, len(cleared), env)
+
+	for id, user := range affected {
+		h.notify(user, fmt.Sprintf(msgXClearedEveryQueueInYZ, h.getUserDisplay(u, false), env, strings.Join(lost[id], ", ")))
+	}
+	return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouClearedXY, env, h.clearEnvText(cleared)))
+}
+
+// isClearEnvAction returns if a block action belongs to the confirmation of clearing an env
+func isClearEnvAction(action *slack.BlockAction) bool {
+	return action.ActionID == confirmClearEnvAction || action.ActionID == cancelClearEnvAction
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/command"
+)
+
+// unknownCommand is what messages that aren't any command are counted under
+const unknownCommand = "unknown"
+
+// runs, failures, latencies and parse errors count commands by name, published at /debug/vars. Latency
+// is the total in milliseconds, so that dividing it by the runs gives the average.
+var (
+	runs        = expvar.NewMap("reservebot_commands")
+	failures    = expvar.NewMap("reservebot_command_errors")
+	latencies   = expvar.NewMap("reservebot_command_ms")
+	parseErrors = expvar.NewMap("reservebot_parse_errors")
+)
+
+// commandStats counts how often each command is run, fails and can't be parsed, and how long it takes,
+// so that operators know which features are used and where people get stuck. They are kept in memory,
+// so they start empty whenever the bot restarts.
+type commandStats struct {
+	lock     sync.Mutex
+	since    time.Time
+	commands map[string]*commandStat
+}
+
+type commandStat struct {
+	runs     int
+	failures int
+	total    time.Duration
+	slowest  time.Duration
+	// parseErrors counts the times the command couldn't be parsed, by reason
+	parseErrors map[string]int
+}
+
+func newCommandStats() *commandStats {
+	return &commandStats{
+		since:    time.Now(),
+		commands: map[string]*commandStat{},
+	}
+}
+
+// stat returns the stats of a command, adding them if they are new. The lock must be held.
+func (s *commandStats) stat(name string) *commandStat {
+	st, ok := s.commands[name]
+	if !ok {
+		st = &commandStat{parseErrors: map[string]int{}}
+		s.commands[name] = st
+	}
+	return st
+}
+
+// ran records that a command was run, whether it failed and how long it took
+func (s *commandStats) ran(name string, failed bool, took time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	st := s.stat(name)
+	st.runs++
+	st.total += took
+	if took > st.slowest {
+		st.slowest = took
+	}
+	runs.Add(name, 1)
+	latencies.Add(name, took.Milliseconds())
+	if failed {
+		st.failures++
+		failures.Add(name, 1)
+	}
+}
+
+// misparsed records that a message couldn't be parsed as a command
+func (s *commandStats) misparsed(perr *command.Error) {
+	name := perr.Name
+	if perr.Unknown || name == "" {
+		name = unknownCommand
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stat(name).parseErrors[perr.Reason]++
+	parseErrors.Add(name, 1)
+}
+
+// text summarizes the stats, most used commands first, followed by those people most often get wrong
+func (s *commandStats) text() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	names := []string{}
+	for name, st := range s.commands {
+		if st.runs > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := s.commands[names[i]], s.commands[names[j]]
+		if a.runs != b.runs {
+			return a.runs > b.runs
+		}
+		return names[i] < names[j]
+	})
+
+	lines := []string{fmt.Sprintf(msgCommandUsageSinceX, s.since.Format("Jan 2 15:04 MST"))}
+	for _, name := range names {
+		st := s.commands[name]
+		avg := st.total / time.Duration(st.runs)
+		lines = append(lines, fmt.Sprintf(msgCommandUsageLineXYZ, name, st.runs, 100*st.failures/st.runs, durationMs(avg), durationMs(st.slowest)))
+	}
+	if len(names) == 0 {
+		lines = append(lines, msgNoCommandsRun)
+	}
+
+	type misparse struct {
+		name    string
+		count   int
+		reasons []string
+	}
+	misparses := []misparse{}
+	for name, st := range s.commands {
+		m := misparse{name: name}
+		for reason, n := range st.parseErrors {
+			m.count += n
+			m.reasons = append(m.reasons, fmt.Sprintf("%s (%d)", reason, n))
+		}
+		if m.count > 0 {
+			sort.Strings(m.reasons)
+			misparses = append(misparses, m)
+		}
+	}
+	sort.Slice(misparses, func(i, j int) bool {
+		if misparses[i].count != misparses[j].count {
+			return misparses[i].count > misparses[j].count
+		}
+		return misparses[i].name < misparses[j].name
+	})
+	if len(misparses) > 0 {
+		lines = append(lines, "", msgParseErrors)
+		for _, m := range misparses {
+			lines = append(lines, fmt.Sprintf(msgParseErrorLineXYZ, m.name, m.count, strings.Join(m.reasons, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// durationMs formats a duration in whole milliseconds, e.g. 42ms
+func durationMs(d time.Duration) string {
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
+
+// adminUsage replies with how often each command has been run, how often it failed and how long it took,
+// and where people got commands wrong
+func (h *Handler) adminUsage(ea *EventAction) error {
+	return h.reply(ea, h.commands.text(), false)
+}
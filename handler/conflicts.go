@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+)
+
+// setConflicts sets the resources that can't safely be used at the same time as a resource, such as a
+// load test rig and the env it loads. The conflict goes both ways, so it only needs to be set on one of
+// them.
+func (h *Handler) setConflicts(r *models.Resource, value, defaultEnv string) (string, error) {
+	if value == "none" {
+		r.Conflicts = nil
+		return "none", nil
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), "`"); item != "" {
+			list = append(list, item)
+		}
+	}
+	others, err := h.getResourcesFromList(list, defaultEnv)
+	if err != nil || len(others) == 0 {
+		return "", errors.New(msgInvalidConflicts)
+	}
+
+	r.Conflicts = nil
+	display := []string{}
+	for _, other := range others {
+		if other.Key() == r.Key() || util.InSlice(r.Conflicts, other.Key()) {
+			continue
+		}
+		if h.data.GetResource(other.Name, other.Env, false) == nil {
+			return "", fmt.Errorf(msgResourceDoesNotExistY, other)
+		}
+		r.Conflicts = append(r.Conflicts, other.Key())
+		display = append(display, fmt.Sprintf("`%s`", other))
+	}
+	if len(r.Conflicts) == 0 {
+		return "", errors.New(msgInvalidConflicts)
+	}
+	return strings.Join(display, ", "), nil
+}
+
+// conflicting returns the resources that conflict with a resource, whichever of them the conflict was
+// set on
+func (h *Handler) conflicting(r *models.Resource) []*models.Resource {
+	ret := []*models.Resource{}
+	for _, other := range h.data.GetResources() {
+		if other.Key() == r.Key() {
+			continue
+		}
+		if util.InSlice(r.Conflicts, other.Key()) || util.InSlice(other.Conflicts, r.Key()) {
+			ret = append(ret, other)
+		}
+	}
+	return ret
+}
+
+// warnConflicts warns a user who just reserved resources that conflict with resources held by others,
+// and lets those holders know, so that they can sort out who goes first. Who holds a private resource
+// is only named to those who may see it.
+func (h *Handler) warnConflicts(ea *EventAction, u *models.User, reserved []*models.Resource) {
+	for _, res := range reserved {
+		r := h.data.GetResource(res.Name, res.Env, false)
+		if r == nil {
+			continue
+		}
+		for _, other := range h.conflicting(r) {
+			q, err := h.data.GetQueueForResource(other.Name, other.Env)
+			if err != nil || !q.HasReservations() || q.Resource.Drawing() || q.Resetting() {
+				continue
+			}
+			holder := q.Reservations[0]
+			if holder.User.ID == u.ID {
+				continue
+			}
+
+			who := "someone"
+			if h.reveals(q, u, ea.Event.ChannelType == "im") {
+				who = h.getUserDisplay(holder.User, false)
+			}
+			h.reply(ea, fmt.Sprintf(msgXConflictsWithYHeldByZ, h.resourceText(r), h.resourceText(other), who), true)
+			h.notify(holder.User, fmt.Sprintf(msgXReservedYConflictsWithZ, h.getUserDisplay(u, false), h.resourceText(r), h.resourceText(other)))
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// deadlineClockFormats are the times of day a deadline can be given as, for today or, once they have
+// passed, tomorrow
+var deadlineClockFormats = []string{"15:04", "3pm", "3:04pm"}
+
+// SetDeadlineChannel also posts the warnings sent to users who are unlikely to get a resource by their
+// deadline to a channel, such as one for admins
+func (h *Handler) SetDeadlineChannel(channel string) {
+	h.deadlineChannel = channel
+}
+
+// parseDeadline parses the time a user needs a resource by, given as a time of day or as a date and time
+// in their timezone
+func parseDeadline(value string, now time.Time, loc *time.Location) (time.Time, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if t, err := time.ParseInLocation(maintenanceTimeFormat, value, loc); err == nil {
+		if !t.After(now) {
+			return time.Time{}, errors.New(msgInvalidDeadline)
+		}
+		return t, nil
+	}
+
+	local := now.In(loc)
+	for _, f := range deadlineClockFormats {
+		c, err := time.Parse(f, value)
+		if err != nil {
+			continue
+		}
+		t := time.Date(local.Year(), local.Month(), local.Day(), c.Hour(), c.Minute(), 0, 0, loc)
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, errors.New(msgInvalidDeadline)
+}
+
+// setReservationDeadline attaches the time a user needs a resource by to their reservation
+func (h *Handler) setReservationDeadline(u *models.User, res *models.Resource, deadline time.Time, drop bool) {
+	err := h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+		r.Deadline = deadline
+		r.DropAtDeadline = drop
+		r.DeadlineWarned = false
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// estimatedTurn estimates when the reservation at an index of a queue gets the resource, from how long
+// the holds ahead of it last. Holds without a duration are assumed to last the resource's default
+// duration. If any has neither, the estimate falls back to the resource's average wait, and false is
+// returned if none has been recorded either.
+func estimatedTurn(q *models.Queue, idx int, now time.Time) (time.Time, bool) {
+	turn := now
+	for i, res := range q.Reservations[:idx] {
+		d := res.Duration
+		if d <= 0 {
+			d = q.Resource.DefaultDuration
+		}
+		if d <= 0 {
+			return averageTurn(q.Resource, q.Reservations[idx], now)
+		}
+		if i == 0 && !q.Resource.Drawing() {
+			// the holder has already had part of their hold
+			if left := res.Time.Add(d).Sub(now); left > 0 {
+				turn = turn.Add(left)
+			}
+			continue
+		}
+		turn = turn.Add(d)
+	}
+	return turn, true
+}
+
+// averageTurn estimates when a reservation gets a resource from how long reservations of it usually wait
+func averageTurn(r *models.Resource, res *models.Reservation, now time.Time) (time.Time, bool) {
+	if len(r.Waits) == 0 || res.Joined.IsZero() {
+		return time.Time{}, false
+	}
+	turn := res.Joined.Add(r.AverageWait())
+	if turn.Before(now) {
+		turn = now
+	}
+	return turn, true
+}
+
+// CheckDeadlines warns users waiting for a resource when they are unlikely to get it by the deadline
+// they gave, once, and takes those who asked out of line when the deadline passes. Warnings are also
+// posted to the deadline channel, if there is one.
+func (h *Handler) CheckDeadlines() {
+	now := time.Now()
+
+	for _, q := range h.data.GetQueues() {
+		r := q.Resource
+		for idx, res := range q.Reservations {
+			if res.Deadline.IsZero() || idx == 0 && !r.Drawing() {
+				continue
+			}
+			loc := h.userLocation(res.User.ID)
+			by := res.Deadline.In(loc).Format(hoursTimeFormat)
+
+			if !now.Before(res.Deadline) && res.DropAtDeadline {
+				err := h.data.Remove(res.User, r.Name, r.Env)
+				if errors.Is(err, e.NotInQueue) {
+					continue
+				}
+				if err != nil {
+					log.Errorf("%+v", err)
+					continue
+				}
+				log.Infof("Removed %s from %s, as their deadline passed", res.User.Name, r)
+				h.notify(res.User, fmt.Sprintf(msgDeadlineForYPassedRemoved, h.resourceText(r), by))
+				continue
+			}
+			if res.DeadlineWarned {
+				continue
+			}
+
+			msg := ""
+			if !now.Before(res.Deadline) {
+				msg = fmt.Sprintf(msgDeadlineForYPassed, h.resourceText(r), by)
+			} else if turn, ok := estimatedTurn(q, idx, now); ok && turn.After(res.Deadline) {
+				msg = fmt.Sprintf(msgUnlikelyToGetYByZ, h.resourceText(r), by, turn.In(loc).Format(hoursTimeFormat))
+				if res.DropAtDeadline {
+					msg += " " + msgYouWillBeRemovedAtDeadline
+				}
+			}
+			if msg == "" {
+				continue
+			}
+
+			err := h.updateReservation(res.User, r.Name, r.Env, func(res *models.Reservation) error {
+				res.DeadlineWarned = true
+				return nil
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+			h.notify(res.User, msg)
+
+			if h.deadlineChannel == "" || r.Private {
+				continue
+			}
+			alert := fmt.Sprintf(msgXIsUnlikelyToGetYByZ, h.getUserDisplay(res.User, false), h.resourceText(r), res.Deadline.Format(hoursTimeFormat))
+			if h.readOnly {
+				log.Infof("Read-only: would post to %s: %s", h.deadlineChannel, alert)
+				continue
+			}
+			if _, _, err := h.client.PostMessage(h.deadlineChannel, slack.MsgOptionText(alert, false)); err != nil {
+				log.Errorf("%+v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	e "github.com/ameliagapin/reservebot/err"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultEnv returns the env a user's resources are in when they don't give one, or an empty string if
+// they haven't set one
+func (h *Handler) defaultEnv(userID string) string {
+	env, err := h.data.GetDefaultEnv(userID)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return ""
+	}
+	return env
+}
+
+// setDefaultEnv sets the env the user's resources are in when they don't give one, so that they can
+// write `reserve api` for `staging|api`, even if resources must have an env. `none` forgets it.
+func (h *Handler) setDefaultEnv(ea *EventAction) error {
+	env := strings.Trim(ea.Command.Args[0], "`")
+	if env == "none" {
+		env = ""
+	} else if strings.Contains(env, "|") {
+		h.errorReply(ea, fmt.Sprintf(msgInvalidDefaultEnvX, env))
+		return nil
+	} else if len(h.data.GetResourcesForEnv(env)) == 0 {
+		h.errorReply(ea, fmt.Sprintf(msgNoResourcesInX, env))
+		return nil
+	}
+
+	if err := h.data.SetDefaultEnv(ea.Event.User, env); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if env == "" {
+		return h.reply(ea, msgYouNoLongerHaveADefaultEnv, true)
+	}
+	return h.reply(ea, fmt.Sprintf(msgYourDefaultEnvIsX, env, env), true)
+}
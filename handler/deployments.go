@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ameliagapin/reservebot/api"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// linkDeployment records the deployment a user's reservation is held for
+func (h *Handler) linkDeployment(u *models.User, res *models.Resource, id string) {
+	err := h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+		r.Deployment = id
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// DeploymentWebhook returns an HTTP handler that is called when a deployment finishes. Reservations
+// linked to the deployment are released, and everyone in their queues is told the outcome. Requests
+// must present the secret in the X-Reservebot-Secret header.
+func (h *Handler) DeploymentWebhook(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !h.authorizeRequest(w, r, secret, models.ScopeReserve) {
+			return
+		}
+
+		req := &api.DeploymentRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Deployment == "" {
+			http.Error(w, "body must be JSON with a deployment", http.StatusBadRequest)
+			return
+		}
+
+		released := h.FinishDeployment(req.Deployment, req.Status)
+
+		writeJSON(w, &api.DeploymentResponse{Released: released})
+	})
+}
+
+// FinishDeployment releases every reservation linked to a deployment and returns how many were
+// released. Reporting the same deployment again releases nothing, so retried webhooks are harmless.
+func (h *Handler) FinishDeployment(id, status string) int {
+	outcome := "successfully"
+	if status != api.DeploymentStatusSuccess {
+		outcome = fmt.Sprintf("with status `%s`", status)
+	}
+
+	released := 0
+	for _, q := range h.data.GetQueues() {
+		for _, res := range q.Reservations {
+			if res.Deployment != id {
+				continue
+			}
+
+			err := h.data.Remove(res.User, q.Resource.Name, q.Resource.Env)
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+			released++
+			log.Infof("Deployment %s finished, released %s for %s", id, q.Resource, res.User.Name)
+
+			// the next user also hears it's their turn from HandleEvent
+			msg := fmt.Sprintf(msgDeploymentXOnYFinishedZ, id, formatResource(q.Resource), outcome, h.getUserDisplay(res.User, false))
+			for _, waiting := range q.Reservations {
+				h.notify(waiting.User, msg)
+			}
+		}
+	}
+
+	return released
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// SetWorkspaceIsolation keeps the resources created in each workspace of an Enterprise Grid org to that
+// workspace, so that people in other workspaces can't see or reserve them. Resources created with
+// --shared, or before isolation was turned on, are shared across the org.
+func (h *Handler) SetWorkspaceIsolation() {
+	h.isolateWorkspaces = true
+}
+
+// workspace returns the ID of the workspace a command was given in, if it is known
+func workspace(ea *EventAction) string {
+	return ea.Event.SourceTeam
+}
+
+// inWorkspace returns if a resource may be used from the workspace a command was given in
+func (h *Handler) inWorkspace(ea *EventAction, r *models.Resource) bool {
+	if !h.isolateWorkspaces || r == nil || r.Workspace == "" {
+		return true
+	}
+	ws := workspace(ea)
+	return ws == "" || ws == r.Workspace
+}
+
+// foreignResource returns the first resource named by a command that is kept to another workspace, or
+// nil if there is none
+func (h *Handler) foreignResource(ea *EventAction) *models.Resource {
+	if !h.isolateWorkspaces || workspace(ea) == "" {
+		return nil
+	}
+	env := h.defaultEnv(ea.Event.User)
+	for _, text := range append(ea.Command.Resources, ea.Command.Args...) {
+		res, err := h.parseResource(strings.Trim(text, "`"), env)
+		if err != nil || res == nil || res.Name == "" {
+			continue
+		}
+		if r := h.data.GetResource(res.Name, res.Env, false); r != nil && !h.inWorkspace(ea, r) {
+			return r
+		}
+	}
+	return nil
+}
+
+// canonicalUser replaces the ID of the user who gave a command with the ID they are known by across an
+// Enterprise Grid org, so that their reservations are theirs whichever workspace they use
+func (h *Handler) canonicalUser(ev *slackevents.MessageEvent) {
+	if u, err := h.getUser(ev.User); err == nil {
+		ev.User = u.ID
+	}
+}
+
+// canonicalIDs returns the IDs users are known by across an Enterprise Grid org
+func (h *Handler) canonicalIDs(ids []string) []string {
+	ret := []string{}
+	for _, id := range ids {
+		if u, err := h.getUser(id); err == nil {
+			id = u.ID
+		}
+		ret = append(ret, id)
+	}
+	return ret
+}
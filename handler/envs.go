@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/command"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const chooseEnvAction = "choose_env"
+
+// ambiguousResource is the cause of an AmbiguousResource error, naming the envs the resource could be in
+type ambiguousResource struct {
+	name string
+	envs []string
+}
+
+func (a *ambiguousResource) Error() string {
+	return fmt.Sprintf("%s is in %s", a.name, strings.Join(a.envs, ", "))
+}
+
+// qualified returns the resource given with each of the envs it could be in
+func (a *ambiguousResource) qualified() []string {
+	ret := []string{}
+	for _, env := range a.envs {
+		ret = append(ret, env+"|"+a.name)
+	}
+	return ret
+}
+
+// inferEnv returns the resource with a name given without an env, if there is one with that name in
+// exactly one env
+func (h *Handler) inferEnv(name string) (*models.Resource, error) {
+	envs := h.data.GetEnvsForName(name)
+	switch len(envs) {
+	case 0:
+		return nil, e.InvalidResourceFormat
+	case 1:
+		return &models.Resource{
+			Name: name,
+			Env:  envs[0],
+		}, nil
+	default:
+		return nil, e.AmbiguousResource.Wrap(&ambiguousResource{name: name, envs: envs})
+	}
+}
+
+// chooseEnv asks which env a resource given without one is in, with a button for each that reruns the
+// command with the env filled in. Only whoever gave the command can choose.
+func (h *Handler) chooseEnv(ea *EventAction, amb *ambiguousResource) {
+	buttons := []slack.BlockElement{}
+	for i, env := range amb.envs {
+		text, ok := qualify(ea, amb.name, env)
+		if !ok {
+			h.errorReply(ea, e.Message(e.AmbiguousResource))
+			return
+		}
+		value := strings.Join([]string{ea.Event.User, channelType(ea), text}, " ")
+		buttons = append(buttons, slack.NewButtonBlockElement(fmt.Sprintf("%s_%d", chooseEnvAction, i), value, slack.NewTextBlockObject(slack.PlainTextType, amb.qualified()[i], false, false)))
+	}
+
+	msg := fmt.Sprintf(msgXIsInSeveralEnvsY, amb.name, envsText(amb.envs))
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, msg, false, false), nil, nil),
+		slack.NewActionBlock("", buttons...),
+	}
+	if err := h.post(ea, slack.MsgOptionText(msg, false), slack.MsgOptionBlocks(blocks...)); err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// envsText lists envs for a message
+func envsText(envs []string) string {
+	ret := []string{}
+	for _, env := range envs {
+		ret = append(ret, fmt.Sprintf("`%s`", env))
+	}
+	return strings.Join(ret, ", ")
+}
+
+// qualify returns the text of a command with the first mention of a resource name after its keywords
+// given with an env. It returns false if the name isn't there.
+func qualify(ea *EventAction, name, env string) (string, bool) {
+	text := ea.Event.Text
+	skip := len(strings.Fields(command.Name(ea.Command.Action)))
+	for i, span := range regexp.MustCompile(`\S+`).FindAllStringIndex(text, -1) {
+		word := text[span[0]:span[1]]
+		if i < skip {
+			continue
+		}
+		trimmed := strings.Trim(word, "`,")
+		if trimmed != name {
+			continue
+		}
+		at := span[0] + strings.Index(word, name)
+		return text[:at] + env + "|" + text[at:], true
+	}
+	return "", false
+}
+
+// chooseEnvAction handles a click on one of the envs offered by chooseEnv by rerunning the command with
+// it, as if whoever gave the command had given it that way
+func (h *Handler) chooseEnvAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	fields := strings.SplitN(action.Value, " ", 3)
+	if len(fields) != 3 || cb.User.ID != fields[0] {
+		return nil
+	}
+	if err := h.updateApprovalMessage(cb, fmt.Sprintf(msgRunningX, fields[2])); err != nil {
+		log.Errorf("%+v", err)
+	}
+
+	ea := &EventAction{
+		Event: &slackevents.MessageEvent{
+			User:            cb.User.ID,
+			Channel:         cb.Channel.ID,
+			ChannelType:     strings.TrimPrefix(fields[1], "-"),
+			Text:            fields[2],
+			ThreadTimeStamp: cb.Message.ThreadTimestamp,
+			SourceTeam:      cb.Team.ID,
+			// each click has its own timestamp, so a redelivered click isn't handled twice
+			TimeStamp: action.ActionTs,
+		},
+	}
+	return h.handleCommand(ea)
+}
+
+// channelType returns the type of channel a command was given in, for the value of a button, which
+// can't hold an empty field
+func channelType(ea *EventAction) string {
+	if ea.Event.ChannelType == "" {
+		return "-"
+	}
+	return ea.Event.ChannelType
+}
+
+// isChooseEnvAction returns if a block action chooses the env of a resource
+func isChooseEnvAction(action *slack.BlockAction) bool {
+	// the buttons of a block need their own IDs
+	return strings.HasPrefix(action.ActionID, chooseEnvAction+"_")
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// HandleEvent is the handler's event subscriber. It lets the new holder of a resource know it's their
+// turn, however the previous holder left the queue. Resources with a lottery go to a draw instead.
+func (h *Handler) HandleEvent(ev events.Event) {
+	h.usage.Handle(ev)
+	h.recordUsage(ev)
+	h.recordHold(ev)
+	h.syncStatuses(ev)
+	h.notifyWatchers(ev)
+	h.notifyPositions(ev)
+	h.milestonesFor(ev)
+	h.checkFreeAlert(ev)
+	h.refreshLiveStatuses(ev)
+	if _, ok := h.preempting.Load(ev.Resource.Key()); ok {
+		// the commander and whoever they displaced are told by DeclareIncident
+		return
+	}
+	if _, ok := h.restoring.Load(ev.Resource.Key()); ok {
+		// whoever is restored is told by restore
+		return
+	}
+
+	freed := ev.Type == events.QueueAdvanced || ev.Type == events.Reserved && ev.Position == 1
+	if freed && ev.Resource.Drawing() {
+		// the winner is told by DrawLotteries
+		return
+	}
+	if freed && ev.Resource.Lottery > 0 {
+		entrants := []*models.Reservation{}
+		if ev.Type == events.QueueAdvanced {
+			// whoever reserved is told by the reply, but those already waiting need to know
+			if q, err := h.data.GetQueueForResource(ev.Resource.Name, ev.Resource.Env); err == nil {
+				entrants = q.Reservations
+			}
+		}
+		h.startDraw(ev.Resource, entrants)
+		return
+	}
+
+	if ev.Type != events.QueueAdvanced {
+		if freed {
+			h.recordWait(ev.Resource, ev.Reservation, ev.Time)
+		}
+		return
+	}
+	if _, ok := h.offering.Load(ev.Resource.Key()); ok {
+		// whoever took the offer is told by offerAction
+		h.recordWait(ev.Resource, ev.Reservation, ev.Time)
+		return
+	}
+	if _, ok := h.skipping.Load(ev.Resource.Key()); ok {
+		// whoever it was handed to is told by CheckClaims
+		h.recordWait(ev.Resource, ev.Reservation, ev.Time)
+		return
+	}
+	if prev, ok := h.advancing.Load(ev.Resource.Key()); ok {
+		ev.Previous = prev.(*models.Reservation)
+	} else if h.advance(ev) {
+		return
+	}
+	h.recordWait(ev.Resource, ev.Reservation, ev.Time)
+
+	if ev.Resource.Closed(ev.Time) {
+		// the resource isn't theirs until it opens, when CheckOfficeHours lets them know
+		opens := ev.Resource.Hours.NextOpen(ev.Time).In(h.userLocation(ev.Reservation.User.ID)).Format(hoursTimeFormat)
+		h.notify(ev.Reservation.User, fmt.Sprintf(msgYouAreFirstForYOpensZ, h.resourceText(ev.Resource), opens))
+		return
+	}
+
+	msg := fmt.Sprintf(msgYIsYours, h.resourceText(ev.Resource))
+	if prev := ev.Previous; prev != nil {
+		if prev.Expired(ev.Time) {
+			msg = fmt.Sprintf(msgXHoldOnYExpiredItIsYours, h.getUserDisplay(prev.User, false), h.resourceText(ev.Resource))
+		} else if _, err := h.data.GetPosition(prev.User, ev.Resource.Name, ev.Resource.Env); err == nil {
+			// the previous holder is still in line, so their turn was rotated
+			msg = fmt.Sprintf(msgXTurnOnYIsUpItIsYours, h.getUserDisplay(prev.User, false), h.resourceText(ev.Resource))
+		} else {
+			msg = fmt.Sprintf(msgXNoLongerHasYItIsYours, h.getUserDisplay(prev.User, false), h.resourceText(ev.Resource))
+		}
+	}
+	if h.offerClaim(ev.Reservation, ev.Resource, msg) {
+		return
+	}
+	h.notify(ev.Reservation.User, msg)
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExpireReservations releases resources whose holder has exceeded their reservation duration. The
+// expired holder is notified here, and the next user in the queue by HandleEvent. Resources outside
+// their office hours or awaiting a draw have no holder, so their reservations don't expire. Nor do those of
+// holders who released a resource that is being reset.
+func (h *Handler) ExpireReservations() {
+	now := time.Now()
+
+	for _, q := range h.data.GetQueues() {
+		if !q.HasReservations() || !q.Reservations[0].Expired(now) || q.Resource.Closed(now) || q.Resource.Drawing() || q.Resetting() {
+			continue
+		}
+
+		holder := q.Reservations[0]
+		err := h.data.Remove(holder.User, q.Resource.Name, q.Resource.Env)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		log.Infof("Reservation of %s by %s expired", q.Resource, holder.User.Name)
+
+		h.notify(holder.User, fmt.Sprintf(msgYourHoldOnYExpired, h.resourceText(q.Resource)))
+	}
+}
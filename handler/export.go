@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/events"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// SetHistory gives the handler the recent events, so that they can be exported
+func (h *Handler) SetHistory(history *events.History) {
+	h.history = history
+}
+
+// export uploads the current reservations or the recent history as a CSV file, for analyzing in a
+// spreadsheet
+func (h *Handler) export(ea *EventAction) error {
+	switch strings.ToLower(ea.Command.Args[0]) {
+	case "reservations":
+		return h.exportReservations(ea)
+	case "history":
+		return h.exportHistory(ea)
+	}
+	h.errorReply(ea, fmt.Sprintf(msgUnknownExportX, ea.Command.Args[0]))
+	return nil
+}
+
+// exportReservations uploads every reservation in every queue, holders first
+func (h *Handler) exportReservations(ea *EventAction) error {
+	rows := [][]string{{"env", "resource", "position", "user_id", "user", "joined", "holding_since", "expires", "ticket", "job", "reservation_id"}}
+	for _, q := range h.data.GetQueues() {
+		for i, res := range q.Reservations {
+			joined, holding, expires, ticket, job := "", "", "", "", ""
+			if !res.Joined.IsZero() {
+				joined = csvTime(res.Joined)
+			}
+			if i == 0 {
+				holding = csvTime(res.Time)
+				if exp := res.Expires(); !exp.IsZero() {
+					expires = csvTime(exp)
+				}
+			}
+			if res.Ticket != nil {
+				ticket = res.Ticket.ID
+			}
+			if res.Job != nil {
+				job = res.Job.ID
+			}
+			rows = append(rows, []string{q.Resource.Env, q.Resource.String(), fmt.Sprint(i + 1), res.User.ID, h.userName(res.User), joined, holding, expires, ticket, job, res.ID})
+		}
+	}
+	if len(rows) == 1 {
+		return h.reply(ea, msgNoReservations, false)
+	}
+	return h.uploadCSV(ea, "reservations.csv", "Reservations", rows)
+}
+
+// exportHistory uploads the recent events, oldest first. History is kept in memory, so it only goes back
+// to when the bot started.
+func (h *Handler) exportHistory(ea *EventAction) error {
+	if h.history == nil {
+		return h.reply(ea, msgNoHistory, false)
+	}
+	evs := h.history.Events()
+	if len(evs) == 0 {
+		return h.reply(ea, msgNoHistory, false)
+	}
+
+	rows := [][]string{{"time", "event", "env", "resource", "reservation_id", "user_id", "user", "position", "previous_user_id", "checked"}}
+	for i := len(evs) - 1; i >= 0; i-- {
+		ev := evs[i]
+		row := []string{csvTime(ev.Time), string(ev.Type), "", "", "", "", "", "", "", ""}
+		if ev.Resource != nil {
+			row[2], row[3] = ev.Resource.Env, ev.Resource.String()
+		}
+		if res := ev.Reservation; res != nil {
+			row[4], row[5], row[6] = res.ID, res.User.ID, h.userName(res.User)
+			row[9] = strings.Join(res.Checked, "; ")
+		}
+		if ev.Position > 0 {
+			row[7] = fmt.Sprint(ev.Position)
+		}
+		if ev.Previous != nil {
+			row[8] = ev.Previous.User.ID
+		}
+		rows = append(rows, row)
+	}
+	return h.uploadCSV(ea, "history.csv", "History", rows)
+}
+
+func csvTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// uploadCSV uploads rows as a CSV file to the channel a command was sent in
+func (h *Handler) uploadCSV(ea *EventAction, filename, title string, rows [][]string) error {
+	b := &strings.Builder{}
+	w := csv.NewWriter(b)
+	if err := w.WriteAll(rows); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, msgIDontKnow)
+		return err
+	}
+
+	_, err := h.client.UploadFile(slack.FileUploadParameters{
+		Content:         b.String(),
+		Filetype:        "csv",
+		Filename:        filename,
+		Title:           title,
+		Channels:        []string{ea.Event.Channel},
+		ThreadTimestamp: ea.Event.ThreadTimeStamp,
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, msgIDontKnow)
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/api"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// forgottenElsewhere is what forgetting a user can't delete, since it has already left the bot
+var forgottenElsewhere = []string{
+	"audit entries already written to the log",
+	"events already sent to webhooks and event streams",
+	"messages already posted in Slack",
+}
+
+// forget deletes everything kept about a user, for when they ask to be forgotten, and replies with a
+// report of what was deleted
+func (h *Handler) forget(ea *EventAction) error {
+	userID := ea.Command.Mentions[0]
+	report, err := h.ForgetUser(userID)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	log.Infof("%s had the bot forget %s", ea.Event.User, userID)
+	return h.reply(ea, forgetReportText(report), false)
+}
+
+// ForgetUser deletes everything kept about a user: their places in line and votes against holders, their
+// part in resources as a watcher, approver, owner or requester, their past holds and usage, their places
+// in snapshots, the commands they had waiting to run later, the DMs to them waiting to be delivered, the recent events about them, their default env, their status filter, their choice about
+// milestones in line and their Slack token. They are taken out of queues first, so that the holds
+// recorded as they leave are deleted too. It returns a report of what was deleted.
+func (h *Handler) ForgetUser(userID string) (*api.ForgetResponse, error) {
+	u := &models.User{ID: userID}
+	ret := &api.ForgetResponse{
+		User:      userID,
+		Queues:    []string{},
+		Resources: []string{},
+		Retained:  forgottenElsewhere,
+	}
+
+	for _, q := range h.data.GetQueues() {
+		if _, err := h.data.GetPosition(u, q.Resource.Name, q.Resource.Env); err != nil {
+			continue
+		}
+		if err := h.data.Remove(u, q.Resource.Name, q.Resource.Env); err != nil && !errors.Is(err, e.NotInQueue) {
+			return nil, err
+		}
+		ret.Queues = append(ret.Queues, q.Resource.String())
+	}
+
+	for _, q := range h.data.GetQueues() {
+		if !q.HasReservations() || len(withVote(q.Reservations[0].AbandonVotes, userID, false)) == len(q.Reservations[0].AbandonVotes) {
+			continue
+		}
+		err := h.updateReservation(q.Reservations[0].User, q.Resource.Name, q.Resource.Env, func(res *models.Reservation) error {
+			res.AbandonVotes = withVote(res.AbandonVotes, userID, false)
+			return nil
+		})
+		if err != nil && !errors.Is(err, e.NotInQueue) {
+			return nil, err
+		}
+	}
+
+	for _, res := range h.data.GetResources() {
+		if !involves(res, userID) {
+			continue
+		}
+		_, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+			forgetInResource(r, userID)
+			return nil
+		})
+		if err != nil && !errors.Is(err, e.ResourceDoesNotExist) {
+			return nil, err
+		}
+		ret.Resources = append(ret.Resources, res.String())
+	}
+
+	f, err := h.data.ForgetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	ret.Holds = f.Holds
+	ret.Usage = f.Usage
+	ret.Snapshots = f.Snapshots
+	ret.Scheduled = f.Scheduled
+	ret.Notifications = f.Notifications
+	ret.DefaultEnv = f.DefaultEnv
+	ret.StatusFilter = f.StatusFilter
+	ret.MilestonesOff = f.MilestonesOff
+	ret.Token = f.Token
+
+	if h.history != nil {
+		ret.Events = h.history.Forget(userID)
+	}
+	if h.usage != nil {
+		h.usage.Forget(userID)
+	}
+	h.users.forget(userID)
+
+	return ret, nil
+}
+
+// involves returns if a user has a part in a resource other than a place in its queue
+func involves(r *models.Resource, userID string) bool {
+	if r.Owner == userID || util.InSlice(r.Watchers, userID) || util.InSlice(r.Approvers, userID) {
+		return true
+	}
+	if r.Offer != nil && r.Offer.UserID == userID {
+		return true
+	}
+	for _, req := range r.Requests {
+		if req.User != nil && req.User.ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetInResource takes a user out of every part they have in a resource other than its queue
+func forgetInResource(r *models.Resource, userID string) {
+	if r.Owner == userID {
+		r.Owner = ""
+	}
+	r.Watchers = without(r.Watchers, userID)
+	r.Approvers = without(r.Approvers, userID)
+	if r.Offer != nil && r.Offer.UserID == userID {
+		r.Offer = nil
+	}
+	requests := []*models.ApprovalRequest{}
+	for _, req := range r.Requests {
+		if req.User == nil || req.User.ID != userID {
+			requests = append(requests, req)
+		}
+	}
+	r.Requests = requests
+}
+
+func without(ids []string, id string) []string {
+	ret := []string{}
+	for _, i := range ids {
+		if i != id {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// forgetReportText describes what was deleted about a user
+func forgetReportText(r *api.ForgetResponse) string {
+	lines := []string{fmt.Sprintf(msgForgotX, r.User)}
+	if len(r.Queues) > 0 {
+		lines = append(lines, fmt.Sprintf(msgForgotQueuesX, strings.Join(r.Queues, ", ")))
+	}
+	if len(r.Resources) > 0 {
+		lines = append(lines, fmt.Sprintf(msgForgotResourcesX, strings.Join(r.Resources, ", ")))
+	}
+	lines = append(lines, fmt.Sprintf(msgForgotRecordsWXYZ, r.Holds, r.Usage, r.Snapshots, r.Events))
+	if r.Scheduled > 0 {
+		lines = append(lines, fmt.Sprintf(msgForgotScheduledX, r.Scheduled))
+	}
+	if r.Notifications > 0 {
+		lines = append(lines, fmt.Sprintf(msgForgotNotificationsX, r.Notifications))
+	}
+	if r.DefaultEnv {
+		lines = append(lines, msgForgotDefaultEnv)
+	}
+	if r.StatusFilter {
+		lines = append(lines, msgForgotStatusFilter)
+	}
+	if r.MilestonesOff {
+		lines = append(lines, msgForgotMilestonesOff)
+	}
+	if r.Token {
+		lines = append(lines, msgForgotToken)
+	}
+	lines = append(lines, fmt.Sprintf(msgForgetRetainedX, strings.Join(r.Retained, ", ")))
+	return strings.Join(lines, "\n")
+}
+
+// ForgetWebhook returns an HTTP handler that deletes everything kept about a user, like the forget
+// command. It is only called with admin API keys.
+func (h *Handler) ForgetWebhook() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !h.authorizeRequest(w, r, "", models.ScopeAdmin) {
+			return
+		}
+
+		req := &api.ForgetRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.User == "" {
+			http.Error(w, "body must be JSON with a user", http.StatusBadRequest)
+			return
+		}
+		report, err := h.ForgetUser(req.User)
+		if err != nil {
+			log.Errorf("%+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("An API key had the bot forget %s", req.User)
+		writeJSON(w, report)
+	})
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/events"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// freeAlert warns a channel when fewer than a number of an env's resources are free
+type freeAlert struct {
+	min     int
+	channel string
+	// alerted is set once the channel was warned, until enough resources are free again
+	alerted bool
+}
+
+// freeAlerts holds the alert of each env that has one
+type freeAlerts struct {
+	lock   sync.Mutex
+	alerts map[string]*freeAlert
+}
+
+func newFreeAlerts() *freeAlerts {
+	return &freeAlerts{
+		alerts: map[string]*freeAlert{},
+	}
+}
+
+// SetFreeAlerts configures alerts from a comma separated list of envs, each with the fewest free
+// resources it should have and the channel to warn when it has fewer, e.g. `staging=2:#platform`
+func (h *Handler) SetFreeAlerts(spec string) error {
+	alerts := map[string]*freeAlert{}
+	for _, a := range strings.Split(spec, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		split := strings.SplitN(a, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("invalid free alert %s, expected env=min:channel", a)
+		}
+		env := strings.TrimSpace(split[0])
+		threshold := strings.SplitN(split[1], ":", 2)
+		if len(threshold) != 2 || strings.TrimSpace(threshold[1]) == "" {
+			return fmt.Errorf("invalid free alert %s, expected env=min:channel", a)
+		}
+		min, err := strconv.Atoi(strings.TrimSpace(threshold[0]))
+		if err != nil || min <= 0 {
+			return fmt.Errorf("invalid minimum for free alert %s: %s", env, threshold[0])
+		}
+		alerts[env] = &freeAlert{
+			min:     min,
+			channel: strings.TrimSpace(threshold[1]),
+		}
+	}
+
+	h.freeAlerts.lock.Lock()
+	h.freeAlerts.alerts = alerts
+	h.freeAlerts.lock.Unlock()
+
+	return nil
+}
+
+// checkFreeAlert warns the channel of the env a change was made in if fewer of its resources are free
+// than it should have, and tells it once enough are free again. Resources under maintenance, outside
+// their office hours or failing their health check don't count as free.
+func (h *Handler) checkFreeAlert(ev events.Event) {
+	if ev.Resource == nil {
+		return
+	}
+	env := ev.Resource.Env
+	h.freeAlerts.lock.Lock()
+	a := h.freeAlerts.alerts[env]
+	h.freeAlerts.lock.Unlock()
+	if a == nil {
+		return
+	}
+
+	now := time.Now()
+	free, total := 0, 0
+	for _, q := range h.data.GetQueuesForEnv(env) {
+		total++
+		r := q.Resource
+		if !q.HasReservations() && r.ActiveMaintenance(now) == nil && !r.Closed(now) && !r.Unhealthy() && r.Broken == nil {
+			free++
+		}
+	}
+
+	msg := ""
+	h.freeAlerts.lock.Lock()
+	switch {
+	case free < a.min && !a.alerted:
+		a.alerted = true
+		msg = fmt.Sprintf(msgOnlyNOfMInXAreFree, free, total, env, a.min)
+	case free >= a.min && a.alerted:
+		a.alerted = false
+		msg = fmt.Sprintf(msgNOfMInXAreFreeAgain, free, total, env)
+	}
+	h.freeAlerts.lock.Unlock()
+	if msg == "" {
+		return
+	}
+
+	if h.readOnly {
+		log.Infof("Read-only: would post to %s: %s", a.channel, msg)
+		return
+	}
+	if _, _, err := h.client.PostMessage(a.channel, slack.MsgOptionText(msg, false)); err != nil {
+		log.Errorf("Error posting the free alert for %s: %+v", env, err)
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// githubSource marks resources that were created for pull requests
+	githubSource = "github"
+	// previewName is the name of the resource created for each pull request, in the env pr-<number>
+	previewName = "preview"
+)
+
+// githubPullRequestEvent is the part of GitHub's pull_request webhook payload that is used
+type githubPullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+	} `json:"pull_request"`
+}
+
+// GitHubWebhook returns an HTTP handler for GitHub's pull_request webhook. Opening a pull request creates
+// a `pr-<number>|preview` resource for its ephemeral environment, and closing it releases everyone holding
+// or waiting for the resource, telling them why, and removes it. Requests must be signed with the secret,
+// as GitHub does when the webhook is given one.
+func (h *Handler) GitHubWebhook(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "unreadable body", http.StatusBadRequest)
+			return
+		}
+		if !githubSigned(body, r.Header.Get("X-Hub-Signature-256"), secret) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-GitHub-Event") != "pull_request" {
+			// GitHub sends a ping when the webhook is added, and may be sending other events too
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		ev := &githubPullRequestEvent{}
+		if err := json.Unmarshal(body, ev); err != nil || ev.Number == 0 {
+			http.Error(w, "body must be a pull_request event", http.StatusBadRequest)
+			return
+		}
+
+		env := fmt.Sprintf("pr-%d", ev.Number)
+		switch ev.Action {
+		case "opened", "reopened":
+			err = h.openPreview(env)
+		case "closed":
+			err = h.closePreview(env, ev)
+		}
+		if err != nil {
+			log.Errorf("%+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// githubSigned returns if a body was signed with the secret, as given in the X-Hub-Signature-256 header
+func githubSigned(body []byte, signature, secret string) bool {
+	given, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// openPreview creates the resource for a pull request's preview environment, unless it exists
+func (h *Handler) openPreview(env string) error {
+	if h.data.GetResource(previewName, env, false) != nil {
+		return nil
+	}
+	if err := h.data.Create(previewName, env); err != nil {
+		return err
+	}
+	_, err := h.updateResource(previewName, env, func(r *models.Resource) error {
+		r.Source = githubSource
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Created %s|%s for its pull request", env, previewName)
+	return nil
+}
+
+// closePreview removes the resource for a pull request's preview environment, after releasing everyone
+// holding or waiting for it and telling them why. Resources that weren't created for the pull request are
+// left alone.
+func (h *Handler) closePreview(env string, ev *githubPullRequestEvent) error {
+	q, err := h.data.GetQueueForResource(previewName, env)
+	if errors.Is(err, e.ResourceDoesNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if q.Resource.Source != githubSource {
+		return nil
+	}
+
+	if q.HasReservations() {
+		outcome := "closed"
+		if ev.PullRequest.Merged {
+			outcome = "merged"
+		}
+		pr := fmt.Sprintf("<%s|#%d>", ev.PullRequest.HTMLURL, ev.Number)
+		if ev.PullRequest.HTMLURL == "" {
+			pr = fmt.Sprintf("#%d", ev.Number)
+		}
+		if err := h.data.ClearQueueForResource(previewName, env); err != nil {
+			return err
+		}
+		msg := fmt.Sprintf(msgPRXWasYZWasRemoved, pr, outcome, formatResource(q.Resource))
+		for _, res := range q.Reservations {
+			h.notify(res.User, msg)
+		}
+	}
+
+	if err := h.data.RemoveResource(previewName, env); err != nil && !errors.Is(err, e.ResourceDoesNotExist) {
+		return err
+	}
+	log.Infof("Removed %s, as its pull request was closed", q.Resource)
+	return nil
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/api"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// gitlabUser returns the external user that holds reservations for a job
+func gitlabUser(req *api.GitLabJobRequest) *models.User {
+	return &models.User{
+		ID:       "gitlab:job:" + req.JobID,
+		Name:     strings.TrimSpace(fmt.Sprintf("%s job %s", req.Project, req.JobID)),
+		External: true,
+	}
+}
+
+// GitLabBridge returns an HTTP handler that lets GitLab CI jobs reserve resources like a lock service,
+// so pipelines wait in the same queues as people. POST /acquire joins the queue for a resource; calling
+// it again is harmless, so jobs poll it until the response says the resource is acquired. POST /release
+// leaves the queue. Requests must present the secret in the X-Reservebot-Secret header.
+func (h *Handler) GitLabBridge(secret string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/acquire", func(w http.ResponseWriter, r *http.Request) {
+		req, res, ok := h.gitlabRequest(w, r, secret)
+		if ok {
+			h.gitlabAcquire(w, req, res)
+		}
+	})
+	mux.HandleFunc("/release", func(w http.ResponseWriter, r *http.Request) {
+		req, res, ok := h.gitlabRequest(w, r, secret)
+		if ok {
+			h.gitlabRelease(w, req, res)
+		}
+	})
+	return mux
+}
+
+// gitlabRequest authorizes and decodes a request, writing an error response if it is invalid
+func (h *Handler) gitlabRequest(w http.ResponseWriter, r *http.Request, secret string) (*api.GitLabJobRequest, *models.Resource, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+	if !h.authorizeRequest(w, r, secret, models.ScopeReserve) {
+		return nil, nil, false
+	}
+
+	req := &api.GitLabJobRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.JobID == "" {
+		http.Error(w, "body must be JSON with a resource and job_id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	res, err := h.parseResource(req.Resource, "")
+	if err != nil || res == nil || res.Name == "" {
+		http.Error(w, "resource must be formatted as env|name", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	return req, res, true
+}
+
+func (h *Handler) gitlabAcquire(w http.ResponseWriter, req *api.GitLabJobRequest, res *models.Resource) {
+	u := gitlabUser(req)
+
+	if h.blockUnhealthy {
+		r := h.data.GetResource(res.Name, res.Env, false)
+		if r != nil && r.Unhealthy() {
+			http.Error(w, fmt.Sprintf("%s is failing its health check", res), http.StatusConflict)
+			return
+		}
+	}
+	if r := h.data.GetResource(res.Name, res.Env, false); r != nil && r.BlockedAsBroken() {
+		http.Error(w, fmt.Sprintf("%s is reported broken", res), http.StatusConflict)
+		return
+	}
+
+	// jobs can't ask for approval, so they can only keep places they had before approvers were set
+	if r := h.data.GetResource(res.Name, res.Env, false); needsApproval(r, u) {
+		if _, err := h.data.GetPosition(u, res.Name, res.Env); errors.Is(err, e.NotInQueue) {
+			http.Error(w, fmt.Sprintf("%s requires approval", res), http.StatusForbidden)
+			return
+		}
+	}
+
+	err := h.data.Reserve(u, res.Name, res.Env)
+	switch {
+	case err == nil:
+		h.linkJob(u, res, gitlabJob(req))
+		log.Infof("GitLab job %s joined the queue for %s", req.JobID, res)
+	case errors.Is(err, e.AlreadyInQueue):
+	case errors.Is(err, e.InMaintenance):
+		http.Error(w, fmt.Sprintf("%s is under maintenance", res), http.StatusConflict)
+		return
+	default:
+		log.Errorf("%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pos, err := h.data.GetPosition(u, res.Name, res.Env)
+	if err != nil {
+		log.Errorf("%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	waiting := false
+	if r := h.data.GetResource(res.Name, res.Env, false); r != nil {
+		waiting = r.Closed(time.Now()) || r.Drawing()
+	}
+	resp := &api.GitLabAcquireResponse{
+		Position: pos,
+		// outside office hours, the job waits for the resource to open, and during a draw for the
+		// result
+		Acquired: pos == 1 && !waiting,
+	}
+	if mine := h.data.GetReservation(u, res.Name, res.Env); mine != nil {
+		resp.ReservationID = mine.ID
+	}
+	writeJSON(w, resp)
+}
+
+func (h *Handler) gitlabRelease(w http.ResponseWriter, req *api.GitLabJobRequest, res *models.Resource) {
+	err := h.data.Remove(gitlabUser(req), res.Name, res.Env)
+	switch {
+	case err == nil:
+		log.Infof("GitLab job %s released %s", req.JobID, res)
+		writeJSON(w, &api.GitLabReleaseResponse{Released: true})
+	case errors.Is(err, e.NotInQueue):
+		// releasing twice is harmless, so that retried jobs don't fail
+		writeJSON(w, &api.GitLabReleaseResponse{Released: false})
+	case errors.Is(err, e.ResourceDoesNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Errorf("%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func gitlabJob(req *api.GitLabJobRequest) *models.Job {
+	return &models.Job{
+		Provider: "gitlab",
+		ID:       req.JobID,
+		URL:      req.JobURL,
+		Project:  req.Project,
+		Ref:      req.Ref,
+		User:     req.User,
+	}
+}
+
+// linkJob records the job a reservation is held by
+func (h *Handler) linkJob(u *models.User, res *models.Resource, job *models.Job) {
+	err := h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+		r.Job = job
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// jobText describes the CI job holding a reservation, to follow its name
+func jobText(res *models.Reservation) string {
+	j := res.Job
+	if j == nil {
+		return ""
+	}
+
+	ret := ""
+	if j.Reason != "" {
+		ret += fmt.Sprintf(" for _%s_", j.Reason)
+	}
+	if j.Ref != "" {
+		ret += fmt.Sprintf(" on `%s`", j.Ref)
+	}
+	if j.User != "" {
+		ret += fmt.Sprintf(" for %s", j.User)
+	}
+	if j.URL != "" {
+		ret += fmt.Sprintf(" <%s|view job>", j.URL)
+	}
+	return ret
+}
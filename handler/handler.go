@@ -1,46 +1,176 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ameliagapin/reservebot/command"
 	"github.com/ameliagapin/reservebot/data"
 	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/events"
 	"github.com/ameliagapin/reservebot/models"
-	"github.com/ameliagapin/reservebot/util"
+	"github.com/ameliagapin/reservebot/policy"
+	"github.com/ameliagapin/reservebot/tickets"
 	log "github.com/sirupsen/logrus"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 )
 
-type Handler struct {
-	client *slack.Client
-	data   data.Manager
+// maxUpdateAttempts is how many times a read-modify-write is attempted when it conflicts with a
+// concurrent change
+const maxUpdateAttempts = 3
+
+// idempotencyTTL is how long a request's idempotency key is remembered. Slack gives up retrying events
+// within minutes, but clients supplying their own keys may retry for longer.
+const idempotencyTTL = 24 * time.Hour
 
-	reqEnv bool
-	admins []string
+type Handler struct {
+	client   SlackClient
+	data     data.Manager
+	users    *userCache
+	notifier *notifier
+	tickets  *tickets.Resolver
+	usage    *policy.Usage
+
+	reqEnv         bool
+	admins         []string
+	adminGroups    *adminGroups
+	observers      []string
+	teams          *teams
+	freeAlerts     *freeAlerts
+	blockUnhealthy bool
+	// readOnly is set if only status questions are answered, for trying an instance against production
+	readOnly bool
+	// permissions are the levels required to run commands, by command name, if they were configured
+	permissions map[string]string
+	// ideSecret signs personal tokens for the IDE status endpoint, if it is enabled
+	ideSecret string
+	// statusSync sets the Slack status of users who opted in, if it is enabled
+	statusSync *statusSync
+	// deadlineChannel is where warnings about missed deadlines are also posted, if set
+	deadlineChannel string
+	// positions remembers where waiters were in line, if they are told when it changes
+	positions *positions
+	// staleAfter is how long a hold may go without activity before it is flagged as stale, if set
+	staleAfter time.Duration
+	// staleChannel is where stale holds are posted, if set
+	staleChannel string
+	// brokenChannel is where reports of broken resources are also posted, if set
+	brokenChannel string
+	// awayClaim is how long holders who are away when they get a contended resource have to claim it, if
+	// they are checked for
+	awayClaim time.Duration
+	// abandonVotes is how many of those waiting must vote a hold abandoned to have it released, if they
+	// can vote
+	abandonVotes int
+	// abandonEmoji is the reaction that votes a hold abandoned
+	abandonEmoji string
+	// abandonGrace is how long holders voted out have to say they are still using the resource
+	abandonGrace time.Duration
+	// milestones are the points in line waiters are told they reached, if any
+	milestones []milestone
+	// history is the recent events, if they are kept
+	history *events.History
+	// advancing holds the previous holder of each resource whose policy is promoting someone
+	advancing sync.Map
+	// offering holds the resources being handed to whoever took their holder's offer
+	offering sync.Map
+	// skipping holds the resources being handed past holders who didn't claim them while away
+	skipping sync.Map
+	// preempting holds the resources being handed to an incident commander
+	preempting sync.Map
+	// restoring holds the resources whose queue is being restored from a snapshot
+	restoring sync.Map
+	// lastHolds holds the ID and start of the last hold recorded for each resource
+	lastHolds sync.Map
+	// commands counts how each command is used
+	commands *commandStats
+	// keyLimits counts the requests made with each API key, to hold them to their rate limits
+	keyLimits *keyLimiter
+	// oidc signs users in to the browser pages, if it is enabled
+	oidc *oidcProvider
+	// incidentSeverity is the least severe incident that pre-empts incident resources, if set
+	incidentSeverity int
+	// isolateWorkspaces keeps resources to the Enterprise Grid workspaces they were created in
+	isolateWorkspaces bool
+	// naming is the convention new resources must be named by, if there is one
+	naming *namingPolicy
+	// channelResources are the names of the resources offered to channels the bot is invited to, if any
+	channelResources []string
+	// live keeps the live status messages pinned in channels up to date
+	live *liveStatuses
+	// sandbox is set on the handlers commands are tried out with, which work on a copy of the data
+	sandbox bool
+	// botID is the bot's own user ID, so that mentions of it can be told apart from mentions of others
+	botID string
+	// intake holds the events from Slack waiting to be handled, if they are queued
+	intake chan slackevents.EventsAPIEvent
+	// maxEventAge is how long after a command is given it may still be handled, if it is limited
+	maxEventAge time.Duration
+	// dmBuffer is how many DMs are kept while Slack can't be reached, if any are
+	dmBuffer int
 }
 
 type EventAction struct {
-	Event  *slackevents.MessageEvent
-	Action string
+	Event   *slackevents.MessageEvent
+	Command *command.Command
+	// Line is the index of the command among those given in the message
+	Line int
+	// batch collects the replies to the command when the message gives several
+	batch *batch
+	// failed is set once an error is replied, so that the command is counted as failing
+	failed bool
+	// scheduled is set on commands run by the scheduler, which were accepted when they were scheduled
+	scheduled bool
+	// claimed is the idempotency key claimed for the command, if any
+	claimed string
+	// applied is set once the command changed something, so that its idempotency key is kept even if
+	// another part of it failed
+	applied bool
 }
 
-func New(client *slack.Client, data data.Manager, reqEnv bool, admins []string) *Handler {
+func New(client SlackClient, data data.Manager, tickets *tickets.Resolver, reqEnv bool, admins, adminGroups []string, blockUnhealthy bool) *Handler {
 	return &Handler{
-		client: client,
-		data:   data,
-		reqEnv: reqEnv,
-		admins: admins,
+		client:         client,
+		data:           data,
+		users:          newUserCache(userCacheTTL),
+		notifier:       newNotifier(),
+		tickets:        tickets,
+		usage:          policy.NewUsage(policy.UsageWindow),
+		reqEnv:         reqEnv,
+		admins:         admins,
+		adminGroups:    newAdminGroups(adminGroups),
+		teams:          newTeams(),
+		freeAlerts:     newFreeAlerts(),
+		keyLimits:      newKeyLimiter(),
+		commands:       newCommandStats(),
+		live:           newLiveStatuses(),
+		blockUnhealthy: blockUnhealthy,
 	}
 }
 
+// SetBotUserID gives the handler the bot's own user ID. Until it is set, the bot must be mentioned at the
+// start of a command.
+func (h *Handler) SetBotUserID(id string) {
+	h.botID = id
+}
+
 func (h *Handler) CallbackEvent(event slackevents.EventsAPIEvent) error {
 	// First, we normalize the incoming event
 	var ea *EventAction
 	innerEvent := event.InnerEvent
 	switch ev := innerEvent.Data.(type) {
+	case *slackevents.LinkSharedEvent:
+		return h.unfurl(ev)
+	case *slackevents.MemberJoinedChannelEvent:
+		return h.joinedChannel(ev)
+	case *slackevents.ReactionAddedEvent:
+		return h.voteAbandoned(ev.User, ev.Reaction, ev.ItemUser, ev.Item, true)
+	case *slackevents.ReactionRemovedEvent:
+		return h.voteAbandoned(ev.User, ev.Reaction, ev.ItemUser, ev.Item, false)
 	case *slackevents.AppMentionEvent:
 		ea = &EventAction{
 			Event: &slackevents.MessageEvent{
@@ -65,43 +195,243 @@ func (h *Handler) CallbackEvent(event slackevents.EventsAPIEvent) error {
 	if ea == nil {
 		return nil
 	}
+	if ea.Event.SourceTeam == "" {
+		// org-wide installs of the app hear from every workspace in an Enterprise Grid org
+		ea.Event.SourceTeam = event.TeamID
+	}
+	h.canonicalUser(ea.Event)
+
+	// A message may give several commands, one per line or separated by semicolons, which are handled in
+	// turn and answered together
+	lines := command.Split(ea.Event.Text, h.botID)
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	if len(lines) > 1 {
+		ea.batch = &batch{}
+	}
+	var ret error
+	for i, line := range lines {
+		ev := *ea.Event
+		ev.Text = line
+		if ea.batch != nil {
+			ea.batch.next(i, line)
+		}
+		if err := h.handleCommand(&EventAction{Event: &ev, Line: i, batch: ea.batch}); err != nil {
+			ret = err
+		}
+	}
+	if ea.batch != nil {
+		if err := h.postBatch(ea); err != nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+// handleCommand handles a single command from a message
+func (h *Handler) handleCommand(ea *EventAction) (err error) {
+	if text, ok := sandboxed(ea.Event.Text); ok {
+		return h.try(ea, text)
+	}
+	start := time.Now()
+	cmd, err := command.Parse(ea.Event.Text)
+	if err != nil {
+		perr, ok := err.(*command.Error)
+		if ok {
+			h.commands.misparsed(perr)
+		}
+		if ok && perr.Unknown {
+			return h.reply(ea, "I'm sorry, I don't know what to do with that request", false)
+		}
+		h.errorReply(ea, capitalize(err.Error()))
+		return nil
+	}
+	ea.Command = cmd
+	defer func() {
+		h.commands.ran(command.Name(cmd.Action), ea.failed || err != nil, time.Since(start))
+	}()
+	cmd.Mentions = h.canonicalIDs(cmd.Mentions)
 
-	// Now we determine what to do with it
-	ea.Action = h.getAction(ea.Event.Text)
-	switch ea.Action {
+	if !h.authorize(ea) {
+		return nil
+	}
+	if r := h.foreignResource(ea); r != nil {
+		h.errorReply(ea, fmt.Sprintf(msgXInAnotherWorkspace, r))
+		return nil
+	}
+	if !h.claimRequest(ea) {
+		if cmd.Flags["key"] == "" {
+			// Slack redelivered a message that was already answered
+			log.Infof("Ignoring `%s` from %s, which was redelivered", command.Name(cmd.Action), ea.Event.User)
+			return nil
+		}
+		return h.reply(ea, msgAlreadyHandled, false)
+	}
+	defer func() {
+		h.settleRequest(ea, err)
+	}()
+
+	switch cmd.Action {
 	case "hello":
 		return h.sayHello(ea)
-	case "create", "create_dm":
+	case "create":
 		return h.create(ea)
-	case "reserve", "reserve_dm":
+	case "requestresource":
+		return h.requestResource(ea)
+	case "reserve":
 		return h.reserve(ea)
-	case "release", "release_dm":
+	case "release":
 		return h.release(ea)
-	case "removeme", "removeme_dm":
+	case "removeme":
 		return h.removeme(ea)
-	case "removeresource", "removeresource_dm":
+	case "watch":
+		return h.watch(ea)
+	case "unwatch":
+		return h.unwatch(ea)
+	case "removeresource":
 		return h.removeresource(ea)
-	case "clear", "clear_dm":
+	case "clear":
 		return h.clear(ea)
-	case "kick", "kick_empty", "kick_nonuser", "kick_dm":
+	case "clearenv":
+		return h.clearEnv(ea)
+	case "kick":
 		return h.kick(ea)
+	case "handoff":
+		return h.handoff(ea)
+	case "reportbroken":
+		return h.reportBroken(ea)
+	case "markfixed":
+		return h.markFixed(ea)
+	case "label":
+		return h.label(ea)
+	case "progress":
+		return h.progress(ea)
+	case "offer":
+		return h.offer(ea)
+	case "broadcast":
+		return h.broadcast(ea)
+	case "incident":
+		return h.incident(ea)
+	case "snapshot":
+		return h.snapshot(ea)
+	case "restore":
+		return h.restore(ea)
 	case "nuke":
+		if ea.Event.ChannelType == "im" {
+			return h.reply(ea, "You must perform a nuke action from a public channel", false)
+		}
 		return h.nuke(ea)
-	case "nuke_dm":
-		return h.reply(ea, "You must perform a nuke action from a public channel", false)
-	case "all_status", "all_status_dm", "my_status", "my_status_dm":
+	case "all_status", "my_status":
 		return h.allStatus(ea)
-	case "single_status", "single_status_dm":
+	case "livestatus":
+		return h.liveStatus(ea)
+	case "single_status":
+		if isStatusFilter(ea.Command.Args[0]) {
+			return h.allStatus(ea)
+		}
 		return h.singleStatus(ea)
-	case "prune", "prune_dm":
+	case "prune":
 		return h.prune(ea)
-	case "help", "help_dm":
+	case "help":
 		return h.help(ea)
+	case "maintenance":
+		return h.maintenance(ea)
+	case "endmaintenance":
+		return h.endMaintenance(ea)
+	case "health":
+		return h.health(ea)
+	case "defaultenv":
+		return h.setDefaultEnv(ea)
+	case "statusfilter":
+		return h.setStatusFilter(ea)
+	case "milestones":
+		return h.setMilestones(ea)
+	case "settings":
+		return h.settings(ea)
+	case "idetoken":
+		return h.ideTokenCommand(ea)
+	case "usagereport":
+		return h.usageReport(ea)
+	case "stats":
+		return h.stats(ea)
+	case "timeline":
+		return h.timeline(ea)
+	case "heatmap":
+		return h.heatmap(ea)
+	case "capacityreport":
+		return h.capacityReport(ea)
+	case "export":
+		return h.export(ea)
+	case "serviceaccount":
+		return h.serviceAccount(ea)
+	case "apikey":
+		return h.apiKey(ea)
+	case "forget":
+		return h.forget(ea)
+	case "importresources":
+		return h.importFile(ea)
+	case "adminusage":
+		return h.adminUsage(ea)
+	case "syncstatus":
+		return h.syncStatusCommand(ea)
 	default:
 		return h.reply(ea, "I'm sorry, I don't know what to do with that request", false)
 	}
 }
 
+// claimRequest records that a request that changes reservations is being handled, returning false if it
+// was handled before. Requests are identified by the --key flag if given, otherwise by the Slack message,
+// so a redelivered event is also caught. If the key can't be recorded the request is handled anyway. The
+// key is released by settleRequest if the request fails, so that it can be retried.
+func (h *Handler) claimRequest(ea *EventAction) bool {
+	switch ea.Command.Action {
+	case "reserve", "release":
+	default:
+		return true
+	}
+	if ea.scheduled {
+		// the scheduled command was claimed when it was deleted to run it
+		return true
+	}
+
+	key := ea.Command.Flags["key"]
+	if key == "" {
+		if ea.Event.TimeStamp == "" {
+			return true
+		}
+		key = ea.Event.Channel + ":" + ea.Event.TimeStamp
+		if ea.Line > 0 {
+			key += fmt.Sprintf(":%d", ea.Line)
+		}
+	}
+	// Keys are scoped to the user and action so one client's key can't block another's request
+	key = strings.Join([]string{ea.Event.User, ea.Command.Action, key}, ":")
+
+	ok, err := h.data.ClaimIdempotencyKey(key, idempotencyTTL)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return true
+	}
+	if ok {
+		ea.claimed = key
+	}
+	return ok
+}
+
+// settleRequest releases the idempotency key claimed for a request if it failed without changing
+// anything, so that retrying it with the same key isn't mistaken for a duplicate. A request that failed
+// for only some of its resources keeps its key, as retrying it would apply the rest twice.
+func (h *Handler) settleRequest(ea *EventAction, err error) {
+	if ea.claimed == "" || ea.applied || err == nil && !ea.failed {
+		return
+	}
+	if err := h.data.ReleaseIdempotencyKey(ea.claimed); err != nil {
+		log.Errorf("%+v", err)
+	}
+	ea.claimed = ""
+}
+
 func (h *Handler) shouldHandle(ev *slackevents.MessageEvent) bool {
 	if ev.BotID != "" {
 		return false
@@ -118,29 +448,54 @@ func (h *Handler) sayHello(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	h.client.PostMessage(ev.Channel, slack.MsgOptionText("Hello"+u.Name+".", false))
+	h.post(ea, slack.MsgOptionText("Hello"+u.Name+".", false))
 	return nil
 }
 
-func (h *Handler) getCurrentResText(resource *models.Resource, mention bool) (string, error) {
+// getCurrentResText describes the current state of a resource's queue to a user
+func (h *Handler) getCurrentResText(resource *models.Resource, u *models.User, im bool, loc *time.Location) (string, error) {
 	q, err := h.data.GetQueueForResource(resource.Name, resource.Env)
 	if err != nil {
 		return "", err
 	}
 
+	reveal := h.reveals(q, u, im)
+	text := h.queueText(q, false, reveal, loc)
+	if q.Resource.Description != "" {
+		text += "\n_" + q.Resource.Description + "_"
+	}
+	return text + h.queueChart(q, reveal), nil
+}
+
+// queueText describes the current state of a queue. Who holds and waits for it is only shown if reveal
+// is set.
+func (h *Handler) queueText(q *models.Queue, mention, reveal bool, loc *time.Location) string {
 	msg := ""
 	queue := []string{}
-
-	switch len(q.Reservations) {
-	case 0:
-		msg = fmt.Sprintf("`%s` is free", resource)
-	case 1:
+	text := formatResource(q.Resource)
+	closed := q.Resource.Closed(time.Now())
+	drawing := q.Resource.Drawing()
+	resetting := q.Resetting()
+
+	switch {
+	case !reveal:
+		msg = privateText(q, loc)
+	case drawing:
+		msg = h.drawText(q, loc)
+	case resetting:
+		msg = h.resetText(q, mention)
+	case len(q.Reservations) == 0:
+		msg = fmt.Sprintf("%s is free", text)
+	case len(q.Reservations) == 1:
 		user := h.getUserDisplayWithDuration(q.Reservations[0], mention)
-		msg = fmt.Sprintf("`%s` is currently reserved by %s", resource, user)
+		msg = fmt.Sprintf("%s is currently reserved by %s", text, user)
+		if closed {
+			msg = fmt.Sprintf("%s will be held by %s when it opens", text, user)
+		}
 	default:
 		verb := "is"
 		for _, next := range q.Reservations[1:] {
@@ -150,68 +505,155 @@ func (h *Handler) getCurrentResText(resource *models.Resource, mention bool) (st
 			verb = "are"
 		}
 		user := h.getUserDisplayWithDuration(q.Reservations[0], mention)
-		msg = fmt.Sprintf("`%s` is currently reserved by %s. %s %s waiting.", resource, user, strings.Join(queue, ", "), verb)
+		msg = fmt.Sprintf("%s is currently reserved by %s. %s %s waiting.", text, user, strings.Join(queue, ", "), verb)
+		if closed {
+			msg = fmt.Sprintf("%s will be held by %s when it opens. %s %s waiting.", text, user, strings.Join(queue, ", "), verb)
+		}
 	}
 
-	return msg, nil
+	if q.HasReservations() && q.Reservations[0].Duration > 0 && !closed && !drawing && !resetting {
+		msg += fmt.Sprintf(" Hold expires in %s.", durationText(time.Until(q.Reservations[0].Expires())))
+	}
+	msg += labelText(q)
+	if reveal {
+		msg += progressText(q)
+	}
+	msg += h.brokenText(q.Resource, loc)
+	msg += h.abandonText(q, loc)
+	if reveal {
+		msg += h.staleText(q)
+		msg += claimText(q, loc)
+	}
+	if q.Resource.DefaultDuration > 0 {
+		msg += fmt.Sprintf(" Default hold is %s.", durationText(q.Resource.DefaultDuration))
+	}
+	msg += waitText(q.Resource)
+	p := h.policyFor(q.Resource)
+	if turn := p.Turn(q.Resource); turn > 0 {
+		msg += fmt.Sprintf(" Turns are limited to %s while others wait.", durationText(turn))
+	}
+	if q.Resource.Policy != "" {
+		msg += fmt.Sprintf(" Next up is %s.", p.Description())
+	}
+	if len(q.Resource.Approvers) > 0 {
+		msg += " Reservations need approval."
+		if n := len(q.Resource.Requests); n > 0 {
+			msg += fmt.Sprintf(" %d awaiting approval.", n)
+		}
+	}
+	if q.Resource.Lottery > 0 && !drawing {
+		msg += fmt.Sprintf(" Holders are drawn %s after it frees up.", durationText(q.Resource.Lottery))
+	}
+
+	msg += hoursText(q.Resource, loc)
+
+	if w := q.Resource.NextMaintenance(time.Now()); w != nil {
+		msg += fmt.Sprintf(" :construction: Maintenance %s", maintenanceText(w, loc))
+	}
+
+	return healthText(q.Resource) + msg
+}
+
+// inQueue returns whether a user holds or is waiting for the resource of a queue
+func inQueue(u *models.User, q *models.Queue) bool {
+	for _, res := range q.Reservations {
+		if res.User.ID == u.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceText renders a resource for messages, prefixed with its emoji if it has one
+func (h *Handler) resourceText(res *models.Resource) string {
+	if r := h.data.GetResource(res.Name, res.Env, false); r != nil {
+		return formatResource(r)
+	}
+	return formatResource(res)
+}
+
+// formatResource renders a stored resource without looking it up again
+func formatResource(r *models.Resource) string {
+	text := fmt.Sprintf("`%s`", r)
+	if r.Emoji != "" {
+		text = fmt.Sprintf("%s %s", r.Emoji, text)
+	}
+	return text
+}
+
+// reservationIDText renders a reservation's ID to follow a message about it. Reservations made before
+// IDs were introduced have none, so nothing is shown.
+func reservationIDText(res *models.Reservation) string {
+	if res == nil || res.ID == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (reservation `%s`)", res.ID)
 }
 
 func (h *Handler) getUserDisplay(user *models.User, mention bool) string {
-	ret := fmt.Sprintf("*%s*", user.Name)
-	if mention {
+	ret := fmt.Sprintf("*%s*", h.userName(user))
+	if mention && !user.External {
 		ret = fmt.Sprintf("<@%s>", user.ID)
 	}
-	return ret
+	return serviceText(user) + ret
 }
 
 func (h *Handler) getUserDisplayWithDuration(reservation *models.Reservation, mention bool) string {
 	user := reservation.User
 	dur := getDuration(reservation.Time)
 
-	ret := fmt.Sprintf("*%s* (%s)", user.Name, dur)
-	if mention {
+	ret := fmt.Sprintf("*%s*%s (%s)", h.userName(user), runText(reservation), dur)
+	if mention && !user.External {
 		ret = fmt.Sprintf("<@%s> (%s)", user.ID, dur)
 	}
-	return ret
+	return serviceText(user) + ret + ticketText(reservation) + jobText(reservation) + incidentText(reservation)
+}
+
+// serviceText marks service accounts apart from people, to precede their names
+func serviceText(user *models.User) string {
+	if user.Service {
+		return ":robot_face: "
+	}
+	return ""
+}
+
+// runText returns the run of an automation holding a reservation, such as its pipeline number, to follow
+// its name
+func runText(res *models.Reservation) string {
+	if res.Job == nil || res.Job.Provider != "automation" || res.Job.ID == "" {
+		return ""
+	}
+	return " #" + res.Job.ID
 }
 
 func getDuration(t time.Time) string {
-	duration := time.Since(t).Round(time.Minute)
+	return durationText(time.Since(t))
+}
+
+// durationText formats a duration rounded to the minute, e.g. 1h30m
+func durationText(d time.Duration) string {
+	duration := d.Round(time.Minute)
 
 	if duration < 1 {
 		return "0m"
 	}
 
-	d := duration.String()
+	s := duration.String()
 
-	return d[:len(d)-2]
+	return s[:len(s)-2]
 }
 
-// getMatches retrieves all capture group values from a given text for regex action
-func (h *Handler) getMatches(action, text string) []string {
-	ret := []string{}
-	r := actions[action]
-	matches := r.FindStringSubmatch(text)
-	if len(matches) > 1 {
-		for _, m := range matches[1:] {
-			ret = append(ret, m)
-		}
-	}
-	return ret
-}
-
-func (h *Handler) getResourcesFromCommaList(text string) ([]*models.Resource, error) {
+// getResourcesFromList parses a list of resources. Resources without an env are in the default env, if
+// one is given.
+func (h *Handler) getResourcesFromList(list []string, defaultEnv string) ([]*models.Resource, error) {
 	ret := []*models.Resource{}
-	split := strings.Split(text, ",")
-	for _, s := range split {
-		if len(s) > 0 {
-			r, err := h.parseResource(strings.Trim(s, " `"))
-			if err != nil {
-				return nil, err
-			}
-			if r != nil {
-				ret = append(ret, r)
-			}
+	for _, s := range list {
+		r, err := h.parseResource(s, defaultEnv)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			ret = append(ret, r)
 		}
 	}
 	if len(ret) == 0 {
@@ -221,12 +663,20 @@ func (h *Handler) getResourcesFromCommaList(text string) ([]*models.Resource, er
 	return ret, nil
 }
 
-func (h *Handler) parseResource(text string) (*models.Resource, error) {
+// parseResource parses a resource formatted as env|name. A resource without an env is in the default
+// env, if one is given.
+func (h *Handler) parseResource(text, defaultEnv string) (*models.Resource, error) {
 	split := strings.Split(text, "|")
 	switch len(split) {
 	case 1:
+		if defaultEnv != "" {
+			return &models.Resource{
+				Name: split[0],
+				Env:  defaultEnv,
+			}, nil
+		}
 		if h.reqEnv {
-			return nil, e.InvalidResourceFormat
+			return h.inferEnv(split[0])
 		}
 		return &models.Resource{
 			Name: split[0],
@@ -242,35 +692,144 @@ func (h *Handler) parseResource(text string) (*models.Resource, error) {
 }
 
 func (h *Handler) getUser(uid string) (*models.User, error) {
+	if u := h.users.get(uid); u != nil {
+		return u, nil
+	}
+
 	u, err := h.client.GetUserInfo(uid)
 	if err != nil {
 		return nil, err
 	}
-	return &models.User{
-		Name: u.Name,
-		ID:   u.ID,
-	}, nil
+	user := &models.User{
+		Name:        u.Name,
+		ID:          u.ID,
+		DisplayName: u.Profile.DisplayName,
+		Email:       u.Profile.Email,
+		TZ:          u.TZ,
+	}
+	if u.Enterprise.ID != "" {
+		// users of an Enterprise Grid org are known by the same ID in every workspace
+		user.ID = u.Enterprise.ID
+	}
+	h.users.set(uid, user)
+	if uid != user.ID {
+		h.users.set(user.ID, user)
+	}
+
+	return user, nil
+}
+
+// userName returns the freshest name for a user, preferring their Slack display name over the name
+// stored with their reservation
+func (h *Handler) userName(user *models.User) string {
+	if user.External {
+		return user.Name
+	}
+	if u, err := h.getUser(user.ID); err == nil {
+		user = u
+	}
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return user.Name
+}
+
+// userLocation returns the timezone of the given user id
+func (h *Handler) userLocation(uid string) *time.Location {
+	u, err := h.getUser(uid)
+	if err != nil {
+		return time.Local
+	}
+	return u.Location()
+}
+
+// updateResource applies fn to a fresh copy of a resource and saves it. If the resource is modified
+// concurrently, the update is retried against the newer copy.
+func (h *Handler) updateResource(name, env string, fn func(r *models.Resource) error) (*models.Resource, error) {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		r := h.data.GetResource(name, env, false)
+		if r == nil {
+			return nil, e.ResourceDoesNotExist
+		}
+		if err := fn(r); err != nil {
+			return nil, err
+		}
+
+		err := h.data.UpdateResource(r)
+		if !errors.Is(err, e.Conflict) {
+			return r, err
+		}
+	}
+	return nil, e.Conflict
+}
+
+// updateReservation applies fn to a fresh copy of a user's reservation and saves it. If the
+// reservation is modified concurrently, the update is retried against the newer copy.
+func (h *Handler) updateReservation(u *models.User, name, env string, fn func(r *models.Reservation) error) error {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		r := h.data.GetReservation(u, name, env)
+		if r == nil {
+			return e.NotInQueue
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+
+		err := h.data.UpdateReservation(r)
+		if !errors.Is(err, e.Conflict) {
+			return err
+		}
+	}
+	return e.Conflict
+}
+
+func (h *Handler) handleUpdateResourceError(ea *EventAction, res *models.Resource, err error) {
+	if errors.Is(err, e.ResourceDoesNotExist) {
+		h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+		return
+	}
+	log.Errorf("%+v", err)
+	h.errorReply(ea, e.Message(err))
 }
 
 func (h *Handler) handleGetResourceError(ea *EventAction, err error) {
+	var amb *ambiguousResource
+	if errors.As(err, &amb) {
+		h.chooseEnv(ea, amb)
+		return
+	}
 	msg := msgMustSpecifyResource
-	if err == e.InvalidResourceFormat {
+	if errors.Is(err, e.InvalidResourceFormat) {
 		msg = msgResourceImproperlyFormatted
 	}
-	h.errorReply(ea.Event.Channel, msg)
+	h.errorReply(ea, msg)
 }
 
-func (h *Handler) errorReply(channel, msg string) {
+func (h *Handler) errorReply(ea *EventAction, msg string) {
+	ea.failed = true
 	if msg == "" {
 		msg = msgIDontKnow
 	}
-	h.client.PostMessage(channel, slack.MsgOptionText(msg, false))
+	h.post(ea, slack.MsgOptionText(msg, false))
+}
+
+// post posts a message where a command was given, in its thread if it was given in one. Replies to a
+// command given with others are collected to be posted together.
+func (h *Handler) post(ea *EventAction, options ...slack.MsgOption) error {
+	if ea.batch != nil {
+		return h.batched(ea, options)
+	}
+	if ea.Event.ThreadTimeStamp != "" {
+		options = append(options, slack.MsgOptionTS(ea.Event.ThreadTimeStamp))
+	}
+	_, _, err := h.client.PostMessage(ea.Event.Channel, options...)
+	return err
 }
 
 func (h *Handler) reply(ea *EventAction, msg string, address bool) error {
 	// If message is in DM or does not start with addressing a user, capitalize the first letter
 	if !address || ea.Event.ChannelType == "im" {
-		msg = fmt.Sprintf("%s%s", strings.ToUpper(msg[:1]), msg[1:])
+		msg = capitalize(msg)
 	}
 
 	if ea.Event.ChannelType != "im" {
@@ -283,20 +842,47 @@ func (h *Handler) reply(ea *EventAction, msg string, address bool) error {
 		}
 	}
 
-	_, _, err := h.client.PostMessage(ea.Event.Channel, slack.MsgOptionText(msg, false))
-	return err
+	return h.post(ea, slack.MsgOptionText(msg, false))
+}
+
+func capitalize(msg string) string {
+	if msg == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s%s", strings.ToUpper(msg[:1]), msg[1:])
 }
 
 func (h *Handler) announce(ea *EventAction, user *models.User, msg string) error {
 	if user != nil {
-		return h.sendDM(user, msg)
+		h.notify(user, msg)
+		return nil
 	}
 
-	_, _, err := h.client.PostMessage(ea.Event.Channel, slack.MsgOptionText(msg, false))
+	return h.post(ea, slack.MsgOptionText(msg, false))
+}
+
+// sendDMBlocks sends a DM laid out with blocks right away. msg is shown in notifications.
+func (h *Handler) sendDMBlocks(user *models.User, msg string, blocks ...slack.Block) error {
+	if h.readOnly {
+		log.Infof("Read-only: would send a DM to %s: %s", user.ID, msg)
+		return nil
+	}
+	params := &slack.OpenConversationParameters{
+		Users: []string{user.ID},
+	}
+	c, _, _, err := h.client.OpenConversation(params)
+	if err != nil {
+		return err
+	}
+	_, _, err = h.client.PostMessage(c.ID, slack.MsgOptionText(msg, false), slack.MsgOptionBlocks(blocks...))
 	return err
 }
 
 func (h *Handler) sendDM(user *models.User, msg string) error {
+	if h.readOnly {
+		log.Infof("Read-only: would send a DM to %s: %s", user.ID, msg)
+		return nil
+	}
 	params := &slack.OpenConversationParameters{
 		Users: []string{user.ID},
 	}
@@ -307,9 +893,3 @@ func (h *Handler) sendDM(user *models.User, msg string) error {
 	_, _, err = h.client.PostMessage(c.ID, slack.MsgOptionText(msg, false))
 	return err
 }
-
-// HasAdminAccess returns if the specified user has access to admin features. If no admins are defined
-// at runtime, all users will have admin access
-func (h *Handler) HasAdminAccess(user string) bool {
-	return len(h.admins) == 0 || util.InSlice(h.admins, user)
-}
@@ -0,0 +1,197 @@
+package handler_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/handler"
+	"github.com/ameliagapin/reservebot/handler/slacktest"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const botID = "UBOT"
+
+// fixture is a handler with memory storage and a fake Slack client, with users U1, U2 and U3 named
+// alice, bob and carol
+type fixture struct {
+	t      *testing.T
+	h      *handler.Handler
+	client *slacktest.Client
+	// ts counts the messages sent, to give each its own timestamp
+	ts int
+}
+
+func newFixture(t *testing.T) *fixture {
+	client := slacktest.New()
+	for i, name := range []string{"alice", "bob", "carol"} {
+		client.AddUser(fmt.Sprintf("U%d", i+1), name, name, "UTC")
+	}
+	client.AddChannel("C1", "dev")
+
+	bus := events.NewBus()
+	h := handler.New(client, events.NewManager(data.NewMemory(), bus), nil, false, nil, nil, false)
+	h.SetBotUserID(botID)
+	bus.Subscribe(h.HandleEvent)
+	return &fixture{t: t, h: h, client: client}
+}
+
+// dm sends the bot a direct message from a user and returns what the bot posted in reply, along with any
+// DMs it sent. Earlier messages are forgotten.
+func (f *fixture) dm(user, text string) []slacktest.Message {
+	f.t.Helper()
+	f.ts++
+	return f.send(&slackevents.MessageEvent{
+		Type:        "message",
+		User:        user,
+		Text:        text,
+		Channel:     slacktest.DMChannel(user),
+		ChannelType: "im",
+		TimeStamp:   fmt.Sprintf("%d.000000", f.ts),
+	})
+}
+
+// mention sends a message mentioning the bot in a channel, like dm
+func (f *fixture) mention(user, channel, text string) []slacktest.Message {
+	f.t.Helper()
+	f.ts++
+	return f.send(&slackevents.AppMentionEvent{
+		Type:      "app_mention",
+		User:      user,
+		Text:      fmt.Sprintf("<@%s> %s", botID, text),
+		Channel:   channel,
+		TimeStamp: fmt.Sprintf("%d.000000", f.ts),
+	})
+}
+
+func (f *fixture) send(data interface{}) []slacktest.Message {
+	f.t.Helper()
+	f.client.Reset()
+	ev := slackevents.EventsAPIEvent{
+		Type:       slackevents.CallbackEvent,
+		InnerEvent: slackevents.EventsAPIInnerEvent{Data: data},
+	}
+	if err := f.h.CallbackEvent(ev); err != nil {
+		f.t.Fatalf("CallbackEvent returned %v", err)
+	}
+	f.h.FlushNotifications()
+	return f.client.Messages()
+}
+
+// expect fails the test unless the messages are exactly those posted to each channel, in order. Each
+// expected message need only be a prefix of the one posted.
+func (f *fixture) expect(got []slacktest.Message, want ...slacktest.Message) {
+	f.t.Helper()
+	if len(got) != len(want) {
+		f.t.Fatalf("Expected %d messages, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i].Channel != want[i].Channel || !strings.HasPrefix(got[i].Text, want[i].Text) {
+			f.t.Errorf("Expected message %d to be %+v, got %+v", i+1, want[i], got[i])
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	f := newFixture(t)
+
+	f.expect(f.dm("U1", "reserve dev|db"),
+		slacktest.Message{Channel: "DU1", Text: "You currently have `dev|db`"},
+	)
+	f.expect(f.dm("U2", "reserve dev|db for 1h"),
+		slacktest.Message{Channel: "DU2", Text: "You are 2nd in line for `dev|db`. *alice* (0m) has it currently."},
+	)
+	f.expect(f.dm("U2", "reserve dev|db"),
+		slacktest.Message{Channel: "DU2", Text: "You are 2nd in line for `dev|db`"},
+	)
+	f.expect(f.mention("U3", "C1", "reserve dev|db"),
+		slacktest.Message{Channel: "C1", Text: "<@U3> You are 3rd in line for `dev|db`. *alice* (0m) has it currently."},
+	)
+}
+
+func TestRelease(t *testing.T) {
+	f := newFixture(t)
+	f.dm("U1", "reserve dev|db")
+
+	f.expect(f.dm("U1", "release dev|db"),
+		slacktest.Message{Channel: "DU1", Text: "You have released `dev|db`"},
+	)
+	f.expect(f.dm("U1", "release dev|db"),
+		slacktest.Message{Channel: "DU1", Text: "You are not in line for `dev|db`"},
+	)
+	f.expect(f.dm("U1", "release dev|nothing"),
+		slacktest.Message{Channel: "DU1", Text: "Resource `dev|nothing` does not exist"},
+	)
+}
+
+func TestQueueAdvance(t *testing.T) {
+	f := newFixture(t)
+	f.dm("U1", "reserve dev|db")
+	f.dm("U2", "reserve dev|db")
+	f.dm("U3", "reserve dev|db")
+
+	f.expect(f.dm("U1", "release dev|db"),
+		slacktest.Message{Channel: "DU1", Text: "You have released `dev|db`"},
+		slacktest.Message{Channel: "DU2", Text: "*alice* no longer has `dev|db`. It's all yours. Get weird."},
+	)
+	f.expect(f.mention("U2", "C1", "release dev|db"),
+		slacktest.Message{Channel: "C1", Text: "*bob* has released `dev|db`. <@U3> it's all yours. Get weird."},
+		slacktest.Message{Channel: "DU3", Text: "*bob* no longer has `dev|db`. It's all yours. Get weird."},
+	)
+	f.expect(f.dm("U3", "release dev|db"),
+		slacktest.Message{Channel: "DU3", Text: "You have released `dev|db`"},
+	)
+}
+
+func TestRedelivery(t *testing.T) {
+	f := newFixture(t)
+	ev := &slackevents.MessageEvent{
+		Type:        "message",
+		User:        "U1",
+		Text:        "reserve dev|db",
+		Channel:     "DU1",
+		ChannelType: "im",
+		TimeStamp:   "100.000000",
+	}
+	f.expect(f.send(ev),
+		slacktest.Message{Channel: "DU1", Text: "You currently have `dev|db`"},
+	)
+	// Slack retries events it doesn't think were answered, which are ignored without a word
+	f.expect(f.send(ev))
+
+	f.expect(f.dm("U1", "release dev|db --key 1"),
+		slacktest.Message{Channel: "DU1", Text: "You have released `dev|db`"},
+	)
+	f.expect(f.dm("U1", "release dev|db --key 1"),
+		slacktest.Message{Channel: "DU1", Text: "I've already handled that request"},
+	)
+}
+
+func TestUnqueuedEvents(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		f := newFixture(t)
+		f.h.SetEventIntake(size, time.Minute)
+		// without an intake, events are handled at once and HandleEvents has nothing to do
+		f.h.HandleEvents()
+
+		f.client.Reset()
+		f.h.QueueEvent(slackevents.EventsAPIEvent{
+			Type: slackevents.CallbackEvent,
+			InnerEvent: slackevents.EventsAPIInnerEvent{Data: &slackevents.MessageEvent{
+				Type:        "message",
+				User:        "U1",
+				Text:        "reserve dev|db",
+				Channel:     "DU1",
+				ChannelType: "im",
+				TimeStamp:   "100.000000",
+			}},
+		})
+		f.h.FlushNotifications()
+		f.expect(f.client.Messages(),
+			slacktest.Message{Channel: "DU1", Text: "You currently have `dev|db`"},
+		)
+	}
+}
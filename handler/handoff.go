@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	acceptHandoffAction  = "handoff_accept"
+	declineHandoffAction = "handoff_decline"
+	// handoffExpiry is how long a teammate has to answer a handoff
+	handoffExpiry = 24 * time.Hour
+)
+
+// handoff asks a teammate to take over everything the caller holds or is waiting for, such as before
+// going on vacation. Nothing changes hands until the teammate accepts. Everything needed to carry out the
+// handoff is kept in the buttons sent to them, so the request survives restarts.
+func (h *Handler) handoff(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	to, err := h.getUser(ea.Command.Mentions[0])
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.reply(ea, msgUknownUser, true)
+		return err
+	}
+	if to.ID == u.ID {
+		h.errorReply(ea, msgYouCannotHandOffToYourself)
+		return nil
+	}
+	if h.isObserver(to) {
+		h.errorReply(ea, fmt.Sprintf(msgXIsAnObserverCantHold, h.getUserDisplay(to, false)))
+		return nil
+	}
+
+	places := h.handoffPlaces(u, to)
+	if len(places) == 0 {
+		return h.reply(ea, msgYouHaveNothingToHandOff, true)
+	}
+
+	text := fmt.Sprintf(msgXWantsToHandOffY, h.getUserDisplay(u, false), h.handoffText(places))
+	value := fmt.Sprintf("%s %s %d", u.ID, to.ID, time.Now().Unix())
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(acceptHandoffAction, value, slack.NewTextBlockObject(slack.PlainTextType, "Take over", false, false)).WithStyle(slack.StylePrimary),
+			slack.NewButtonBlockElement(declineHandoffAction, value, slack.NewTextBlockObject(slack.PlainTextType, "Decline", false, false)),
+		),
+	}
+	if err := h.sendDMBlocks(to, text, blocks...); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, msgIDontKnow)
+		return err
+	}
+	log.Infof("%s asked %s to take over %d reservations", u.Name, to.Name, len(places))
+
+	return h.reply(ea, fmt.Sprintf(msgAskedXToTakeOverY, h.getUserDisplay(to, false), h.handoffText(places)), true)
+}
+
+// handoffPlace is a place in line that would be handed to a teammate
+type handoffPlace struct {
+	queue *models.Queue
+	// idx is the index of the place in the queue
+	idx int
+}
+
+// handoffPlaces returns each place in line a user would hand to a teammate. Places the teammate is already
+// ahead of, for resources they would need approval for, or for resources being reset after the user
+// released them, are kept.
+func (h *Handler) handoffPlaces(from, to *models.User) []*handoffPlace {
+	places := []*handoffPlace{}
+	for _, q := range h.data.GetQueues() {
+		idx, other := -1, -1
+		for i, res := range q.Reservations {
+			switch res.User.ID {
+			case from.ID:
+				idx = i
+			case to.ID:
+				other = i
+			}
+		}
+		if idx == -1 || idx == 0 && q.Resetting() || other != -1 && other < idx || needsApproval(q.Resource, to) {
+			continue
+		}
+		places = append(places, &handoffPlace{queue: q, idx: idx})
+	}
+	return places
+}
+
+// handoffText describes places in line
+func (h *Handler) handoffText(places []*handoffPlace) string {
+	ret := []string{}
+	for _, p := range places {
+		r := p.queue.Resource
+		if p.idx == 0 && !r.Drawing() {
+			ret = append(ret, fmt.Sprintf("%s (holding)", h.resourceText(r)))
+		} else {
+			ret = append(ret, fmt.Sprintf("%s (%s in line)", h.resourceText(r), util.Ordinalize(p.idx+1)))
+		}
+	}
+	return strings.Join(ret, ", ")
+}
+
+// handoffAction handles a click on a handoff's buttons. Accepting hands over whatever the user who asked
+// still holds or waits for at that time.
+func (h *Handler) handoffAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	if h.readOnly {
+		log.Infof("Read-only: would %s handoff %s for %s", strings.TrimPrefix(action.ActionID, "handoff_"), action.Value, cb.User.ID)
+		return nil
+	}
+	fields := strings.Fields(action.Value)
+	if len(fields) != 3 {
+		return nil
+	}
+	if cb.User.ID != fields[1] {
+		return h.updateApprovalMessage(cb, msgThisHandoffIsNotForYou)
+	}
+	asked, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || time.Since(time.Unix(asked, 0)) > handoffExpiry {
+		return h.updateApprovalMessage(cb, msgThisHandoffHasExpired)
+	}
+
+	from, err := h.getUser(fields[0])
+	if err != nil {
+		return err
+	}
+	to, err := h.getUser(fields[1])
+	if err != nil {
+		return err
+	}
+
+	if action.ActionID == declineHandoffAction {
+		log.Infof("%s declined to take over from %s", to.Name, from.Name)
+		h.notify(from, fmt.Sprintf(msgXDeclinedYourHandoff, h.getUserDisplay(to, false)))
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouDeclinedXHandoff, h.getUserDisplay(from, false)))
+	}
+
+	handed := []*handoffPlace{}
+	for _, p := range h.handoffPlaces(from, to) {
+		err := h.data.Transfer(from, to, p.queue.Resource.Name, p.queue.Resource.Env)
+		if errors.Is(err, e.NotInQueue) || errors.Is(err, e.ResourceDoesNotExist) {
+			// they left the queue in the meantime
+			continue
+		}
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		handed = append(handed, p)
+	}
+	if len(handed) == 0 {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgXHasNothingToHandOff, h.getUserDisplay(from, false)))
+	}
+	log.Infof("%s took over %d reservations from %s", to.Name, len(handed), from.Name)
+
+	h.notify(from, fmt.Sprintf(msgXTookOverY, h.getUserDisplay(to, false), h.handoffText(handed)))
+	return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouTookOverXY, h.getUserDisplay(from, false), h.handoffText(handed)))
+}
+
+// isHandoffAction returns if a block action belongs to a handoff
+func isHandoffAction(action *slack.BlockAction) bool {
+	return action.ActionID == acceptHandoffAction || action.ActionID == declineHandoffAction
+}
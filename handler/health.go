@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultHealthInterval = 5 * time.Minute
+
+var healthClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+func (h *Handler) health(ea *EventAction) error {
+	matches := ea.Command.Args
+
+	res, err := h.parseResource(strings.Trim(matches[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return nil
+	}
+
+	if matches[1] == "off" {
+		err := h.setHealthCheck(res, nil)
+		if err != nil {
+			h.handleUpdateResourceError(ea, res, err)
+			return nil
+		}
+		return h.reply(ea, fmt.Sprintf(msgHealthCheckRemovedY, res), false)
+	}
+
+	url := matches[1]
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		h.errorReply(ea, msgInvalidHealthCheck)
+		return nil
+	}
+
+	interval := defaultHealthInterval
+	if len(matches) > 2 {
+		interval, err = time.ParseDuration(matches[2])
+		if err != nil || interval < time.Minute {
+			h.errorReply(ea, msgInvalidHealthCheck)
+			return nil
+		}
+	}
+
+	check := &models.HealthCheck{
+		URL:      url,
+		Interval: interval,
+	}
+	if err := h.setHealthCheck(res, check); err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgHealthCheckSetYZ, res, url, interval), false)
+}
+
+func (h *Handler) setHealthCheck(res *models.Resource, check *models.HealthCheck) error {
+	_, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		r.HealthCheck = check
+		return nil
+	})
+	return err
+}
+
+// CheckHealth runs the health checks for all resources that are due. A resource is healthy when its
+// URL responds with a 2xx status code.
+func (h *Handler) CheckHealth() {
+	now := time.Now()
+
+	for _, r := range h.data.GetResources() {
+		if r.HealthCheck == nil || !r.HealthCheck.Due(now) {
+			continue
+		}
+
+		url := r.HealthCheck.URL
+		healthy := checkURL(url)
+		if r.HealthCheck.Checked() && healthy != r.HealthCheck.Healthy {
+			log.Infof("Health of %s changed, healthy: %t", r, healthy)
+		}
+
+		_, err := h.updateResource(r.Name, r.Env, func(r *models.Resource) error {
+			// the check may have been removed or replaced while it was running
+			if r.HealthCheck == nil || r.HealthCheck.URL != url {
+				return nil
+			}
+			r.HealthCheck.Healthy = healthy
+			r.HealthCheck.LastCheck = now
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+}
+
+func checkURL(url string) bool {
+	resp, err := healthClient.Get(url)
+	if err != nil {
+		log.Debugf("Health check for %s failed: %+v", url, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func healthText(r *models.Resource) string {
+	if r.HealthCheck == nil || !r.HealthCheck.Checked() {
+		return ""
+	}
+	if r.HealthCheck.Healthy {
+		return ":large_green_circle: "
+	}
+	return ":red_circle: "
+}
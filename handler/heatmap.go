@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// heatmapShades are the marks for how much of an hour resources were held, from not at all to the
+// whole hour
+var heatmapShades = []string{"·", "░", "▒", "▓", "█"}
+
+// heatmapDays are the rows of a heatmap, starting with the working week
+var heatmapDays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+// heatmapGrid is the share of each hour of the week, by weekday and hour, that resources were held
+type heatmapGrid [7][24]float64
+
+// heatmap shows how much a resource, or the resources of an env, were held in each hour of the week over
+// the holds remembered, in the user's timezone, so that teams can pick quiet times for their work. Only
+// how much resources were held is shown, not by whom, so private resources are included. With --image, it
+// is uploaded as an SVG image.
+func (h *Handler) heatmap(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	filter := strings.Trim(ea.Command.Args[0], "`")
+
+	queues := []*models.Queue{}
+	target := fmt.Sprintf("`%s`", filter)
+	if !strings.Contains(filter, "|") && (h.reqEnv || len(h.data.GetResourcesForEnv(filter)) > 0) {
+		for _, q := range h.data.GetQueuesForEnv(filter) {
+			queues = append(queues, q)
+		}
+		if len(queues) == 0 {
+			h.errorReply(ea, fmt.Sprintf(msgEnvXHasNoResources, target))
+			return nil
+		}
+	} else {
+		res, err := h.parseResource(filter, h.defaultEnv(ea.Event.User))
+		if err != nil || res == nil {
+			h.handleGetResourceError(ea, err)
+			return err
+		}
+		q, err := h.data.GetQueueForResource(res.Name, res.Env)
+		if errors.Is(err, e.ResourceDoesNotExist) {
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			return nil
+		}
+		if err != nil {
+			h.errorReply(ea, e.Message(err))
+			return err
+		}
+		queues = append(queues, q)
+		target = h.resourceText(q.Resource)
+	}
+
+	loc := u.Location()
+	now := time.Now().In(loc)
+	grid, held, err := h.heatmapGrid(queues, now.Add(-models.HoldHistory), now)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if !held {
+		return h.reply(ea, fmt.Sprintf(msgNobodyHeldXRecently, target), false)
+	}
+
+	if ea.Command.HasFlag("image") {
+		_, err := h.client.UploadFile(slack.FileUploadParameters{
+			Content:         heatmapSVG(grid, strings.Trim(target, "`")),
+			Filetype:        "svg",
+			Filename:        "heatmap.svg",
+			Title:           fmt.Sprintf("Utilization of %s (hours in %s)", strings.Trim(target, "`"), loc),
+			Channels:        []string{ea.Event.Channel},
+			ThreadTimestamp: ea.Event.ThreadTimeStamp,
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			h.errorReply(ea, msgIDontKnow)
+		}
+		return err
+	}
+	return h.reply(ea, fmt.Sprintf(msgUtilizationOfXY, target, heatmapText(grid)), false)
+}
+
+// heatmapGrid adds up how much of each hour of the week the resources were held from a time until now, as
+// a share of the hours that passed, in now's timezone. It also returns whether they were held at all.
+func (h *Handler) heatmapGrid(queues []*models.Queue, from, now time.Time) (*heatmapGrid, bool, error) {
+	var heldHours, totalHours heatmapGrid
+	start := from.Truncate(time.Hour)
+	held := false
+	for _, q := range queues {
+		holds, err := h.timelineHolds(q, start, now)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, hold := range holds {
+			held = true
+			for slot := hold.Start.In(now.Location()).Truncate(time.Hour); slot.Before(hold.End); slot = slot.Add(time.Hour) {
+				overlap := minTime(hold.End, slot.Add(time.Hour)).Sub(maxTime(hold.Start, slot))
+				if overlap > 0 {
+					heldHours[heatmapRow(slot.Weekday())][slot.Hour()] += overlap.Hours()
+				}
+			}
+		}
+		for slot := start; slot.Before(now); slot = slot.Add(time.Hour) {
+			totalHours[heatmapRow(slot.Weekday())][slot.Hour()] += minTime(now, slot.Add(time.Hour)).Sub(slot).Hours()
+		}
+	}
+
+	grid := &heatmapGrid{}
+	for day := range grid {
+		for hour := range grid[day] {
+			if totalHours[day][hour] > 0 {
+				grid[day][hour] = heldHours[day][hour] / totalHours[day][hour]
+			}
+		}
+	}
+	return grid, held, nil
+}
+
+// heatmapRow returns the row of a weekday in a heatmap
+func heatmapRow(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+// heatmapText draws a heatmap as a row of hours per weekday, shaded by how much they were held, and
+// points out the quietest working hours
+func heatmapText(grid *heatmapGrid) string {
+	lines := []string{"    0     6     12    18"}
+	for i, day := range heatmapDays {
+		line := day.String()[:3] + " "
+		for _, share := range grid[i] {
+			line += heatmapShades[heatmapShade(share)]
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", fmt.Sprintf("%s none  %s <25%%  %s <50%%  %s <75%%  %s 75%%+", heatmapShades[0], heatmapShades[1], heatmapShades[2], heatmapShades[3], heatmapShades[4]))
+	text := "```" + strings.Join(lines, "\n") + "```"
+
+	// the quietest hour of the working week, from 9 to 5 on weekdays, for whoever is looking for a slot
+	quietDay, quietHour := 0, 9
+	for day := 0; day < 5; day++ {
+		for hour := 9; hour < 17; hour++ {
+			if grid[day][hour] < grid[quietDay][quietHour] {
+				quietDay, quietHour = day, hour
+			}
+		}
+	}
+	return text + fmt.Sprintf("\nThe quietest working hour is %s %02d:00, held %.0f%% of the time.", heatmapDays[quietDay].String()[:3], quietHour, grid[quietDay][quietHour]*100)
+}
+
+func heatmapShade(share float64) int {
+	switch {
+	case share <= 0:
+		return 0
+	case share < 0.25:
+		return 1
+	case share < 0.5:
+		return 2
+	case share < 0.75:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// heatmapSVG draws a heatmap as an SVG image, with a square per hour of the week shaded by how much it
+// was held
+func heatmapSVG(grid *heatmapGrid, title string) string {
+	const (
+		labelWidth = 48
+		cell       = 28
+		header     = 40
+	)
+	width := labelWidth + cell*24
+	height := header + cell*7
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`, width, height)
+	fmt.Fprintf(&b, `<title>%s</title>`, escapeXML(title))
+	fmt.Fprintf(&b, `<text x="4" y="16">%s</text>`, escapeXML(title))
+	for hour := 0; hour < 24; hour += 3 {
+		fmt.Fprintf(&b, `<text x="%d" y="34">%02d</text>`, labelWidth+cell*hour+4, hour)
+	}
+	for i, day := range heatmapDays {
+		y := header + cell*i
+		fmt.Fprintf(&b, `<text x="4" y="%d">%s</text>`, y+18, day.String()[:3])
+		for hour, share := range grid[i] {
+			// from white when free to the timeline's blue when always held
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="rgb(%d,%d,%d)" stroke="#eee"><title>%s %02d:00 held %.0f%%</title></rect>`,
+				labelWidth+cell*hour, y, cell, cell,
+				255-int(share*(255-74)), 255-int(share*(255-144)), 255-int(share*(255-217)),
+				day.String()[:3], hour, share*100)
+		}
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
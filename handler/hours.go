@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const hoursTimeFormat = "Mon 15:04 MST"
+
+// setHours sets a resource's office hours from a value like `mon-fri 09:00-18:00 [timezone]`. Without a
+// timezone, the hours are in the given location, which is the timezone of the user changing them.
+func setHours(r *models.Resource, value string, loc *time.Location) (string, error) {
+	if value == "none" {
+		r.Hours = nil
+		return "none", nil
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) != 2 && len(fields) != 3 {
+		return "", errors.New(msgInvalidHours)
+	}
+	if len(fields) == 3 {
+		var err error
+		if loc, err = time.LoadLocation(fields[2]); err != nil {
+			return "", errors.New(msgInvalidHours)
+		}
+	}
+	hours, err := models.ParseOfficeHours(fields[0], fields[1], loc)
+	if err != nil {
+		return "", errors.New(msgInvalidHours)
+	}
+	// keep whether the resource was open, so that CheckOfficeHours opens or closes it if the new hours
+	// differ. A resource without hours was open.
+	hours.Open = r.Hours == nil || r.Hours.Open
+
+	r.Hours = hours
+	return hours.String(), nil
+}
+
+// hoursText describes when a resource with office hours next opens or closes, in the given timezone
+func hoursText(r *models.Resource, loc *time.Location) string {
+	if r.Hours == nil {
+		return ""
+	}
+	now := time.Now()
+	if r.Closed(now) {
+		return fmt.Sprintf(" :crescent_moon: Closed until %s.", r.Hours.NextOpen(now).In(loc).Format(hoursTimeFormat))
+	}
+	return fmt.Sprintf(" Open until %s.", r.Hours.NextClose(now).In(loc).Format(hoursTimeFormat))
+}
+
+// CheckOfficeHours opens and closes resources with office hours. When a resource closes, its holder's
+// reservation is released and the next user in line waits for it to open. A user who reserved the
+// resource after it closed never held it, so keeps their place. When it opens, the holder's reservation
+// starts, so any duration is counted from then.
+func (h *Handler) CheckOfficeHours() {
+	now := time.Now()
+
+	for _, resource := range h.data.GetResources() {
+		if resource.Hours == nil || resource.Hours.IsOpen(now) == resource.Hours.Open {
+			continue
+		}
+
+		open := false
+		r, err := h.updateResource(resource.Name, resource.Env, func(r *models.Resource) error {
+			if r.Hours == nil {
+				return nil
+			}
+			open = r.Hours.IsOpen(now)
+			r.Hours.Open = open
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+
+		holder, err := h.data.GetReservationForResource(r.Name, r.Env)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		if holder == nil {
+			continue
+		}
+
+		if open {
+			if r.Drawing() {
+				// DrawLotteries gives the resource to the winner now that it's open
+				continue
+			}
+			err := h.updateReservation(holder.User, r.Name, r.Env, func(res *models.Reservation) error {
+				res.Time = now
+				return nil
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+			h.notify(holder.User, fmt.Sprintf(msgYIsOpenItIsYours, h.resourceText(r)))
+			continue
+		}
+
+		if !holder.Time.Before(r.Hours.LastClose(now)) {
+			continue
+		}
+		if err := h.data.Remove(holder.User, r.Name, r.Env); err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		log.Infof("Reservation of %s by %s released at closing", r, holder.User.Name)
+		h.notify(holder.User, fmt.Sprintf(msgYClosedYourHoldReleased, h.resourceText(r)))
+	}
+}
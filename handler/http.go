@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ameliagapin/reservebot/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// authorized returns whether a request presents the secret, either in the secret header or as the
+// password of basic auth for clients that can't set headers
+func authorized(r *http.Request, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(givenSecret(r)), []byte(secret)) == 1
+}
+
+// givenSecret returns the secret a request presents, in the secret header or as the password of basic auth
+func givenSecret(r *http.Request) string {
+	given := r.Header.Get(api.SecretHeader)
+	if _, password, ok := r.BasicAuth(); ok && given == "" {
+		given = password
+	}
+	return given
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("%+v", err)
+	}
+}
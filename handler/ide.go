@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/api"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// IDEStatus returns an HTTP handler summarizing the calling user's reservations for an editor's status
+// bar. Each user authenticates with a personal token from the `ide token` command, which is derived
+// from the secret so that no tokens need to be stored. Changing the secret revokes every token.
+func (h *Handler) IDEStatus(secret string) http.Handler {
+	h.ideSecret = secret
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		uid, ok := h.ideTokenUser(r.Header.Get(api.SecretHeader))
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		writeJSON(w, h.ideStatus(uid))
+	})
+}
+
+// ideToken returns a user's personal token for the IDE status endpoint
+func (h *Handler) ideToken(uid string) string {
+	mac := hmac.New(sha256.New, []byte(h.ideSecret))
+	mac.Write([]byte("ide:" + uid))
+	return uid + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ideTokenUser returns the user a personal token belongs to, if it is valid
+func (h *Handler) ideTokenUser(token string) (string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i <= 0 {
+		return "", false
+	}
+	uid := token[:i]
+	return uid, hmac.Equal([]byte(token), []byte(h.ideToken(uid)))
+}
+
+func (h *Handler) ideStatus(uid string) *api.IDEStatusResponse {
+	resp := &api.IDEStatusResponse{
+		User:    uid,
+		Holding: []api.IDEHold{},
+		Waiting: []api.IDEWait{},
+	}
+
+	holds := []string{}
+	waits := []string{}
+	for _, q := range h.data.GetQueues() {
+		for i, res := range q.Reservations {
+			if res.User.ID != uid {
+				continue
+			}
+			if i == 0 {
+				hold := api.IDEHold{
+					Resource: q.Resource.String(),
+					Waiting:  len(q.Reservations) - 1,
+				}
+				if exp := res.Expires(); !exp.IsZero() {
+					hold.Expires = &exp
+				}
+				resp.Holding = append(resp.Holding, hold)
+
+				if hold.Waiting > 0 {
+					holds = append(holds, fmt.Sprintf("%s (%d waiting)", q.Resource.Name, hold.Waiting))
+				} else {
+					holds = append(holds, q.Resource.Name)
+				}
+				continue
+			}
+
+			resp.Waiting = append(resp.Waiting, api.IDEWait{
+				Resource: q.Resource.String(),
+				Position: i + 1,
+				Holder:   h.userName(q.Reservations[0].User),
+			})
+			waits = append(waits, fmt.Sprintf("#%d for %s", i+1, q.Resource.Name))
+		}
+	}
+
+	switch {
+	case len(holds) > 0 && len(waits) > 0:
+		resp.Summary = "You hold " + strings.Join(holds, ", ") + "; " + strings.Join(waits, ", ")
+	case len(holds) > 0:
+		resp.Summary = "You hold " + strings.Join(holds, ", ")
+	case len(waits) > 0:
+		resp.Summary = "You're " + strings.Join(waits, ", ")
+	default:
+		resp.Summary = "No reservations"
+	}
+
+	return resp
+}
+
+func (h *Handler) ideTokenCommand(ea *EventAction) error {
+	if h.ideSecret == "" {
+		return h.reply(ea, msgIDEStatusDisabled, false)
+	}
+
+	u := &models.User{ID: ea.Event.User}
+	msg := fmt.Sprintf(msgYourIDETokenIsX, h.ideToken(u.ID))
+	if ea.Event.ChannelType == "im" {
+		return h.reply(ea, msg, false)
+	}
+
+	// tokens are only ever sent privately
+	h.notify(u, msg)
+	return h.reply(ea, msgIDETokenSentByDM, true)
+}
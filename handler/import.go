@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/api"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// importMaxRows is the most rows an import may have
+const importMaxRows = 1000
+
+// importColumns are the columns an import may have
+var importColumns = map[string]bool{"name": true, "env": true, "owner": true, "description": true, "capacity": true}
+
+// importResources sets up resources in bulk from the contents of a CSV file, for teams moving onto the bot
+// with many resources at once. The header names the columns, in any order: name, and env, owner,
+// description and capacity if they are given. Owners are Slack mentions, user IDs or email addresses.
+// Capacity may only be 1, as resources are held by one person at a time. Resources that don't exist are
+// created, and those that do have their owner and description updated from the cells that aren't blank.
+// Rows that fail don't stop the others. With dryRun, the rows are only checked. Resources created while
+// workspaces are isolated are kept to ws.
+func (h *Handler) importResources(text string, dryRun bool, ws string) (*api.ImportResponse, error) {
+	reader := csv.NewReader(strings.NewReader(strings.TrimPrefix(text, "\ufeff")))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("the file isn't a CSV file: %v", err)
+	}
+	if len(records) < 2 {
+		return nil, errors.New("the file needs a header and at least one row")
+	}
+	if len(records)-1 > importMaxRows {
+		return nil, fmt.Errorf("the file has %d rows, but at most %d may be imported at once", len(records)-1, importMaxRows)
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !importColumns[name] {
+			return nil, fmt.Errorf("the header has an unknown column %q. The columns are name, env, owner, description and capacity", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("the header needs a name column")
+	}
+	if _, ok := columns["env"]; !ok && h.reqEnv {
+		return nil, errors.New("the header needs an env column")
+	}
+
+	resp := &api.ImportResponse{DryRun: dryRun || h.readOnly, Rows: []api.ImportRow{}}
+	seen := map[string]int{}
+	for i, record := range records[1:] {
+		cell := func(column string) string {
+			if c, ok := columns[column]; ok && c < len(record) {
+				return strings.TrimSpace(record[c])
+			}
+			return ""
+		}
+		row := api.ImportRow{Row: i + 1}
+		res, result, err := h.importRow(cell, seen, i+1, resp.DryRun, ws)
+		if res != nil {
+			row.Resource = res.String()
+		}
+		if err != nil {
+			result = api.ImportFailed
+			row.Error = err.Error()
+		}
+		row.Result = result
+		switch result {
+		case api.ImportCreated:
+			resp.Created++
+		case api.ImportUpdated:
+			resp.Updated++
+		case api.ImportUnchanged:
+			resp.Unchanged++
+		default:
+			resp.Failed++
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	return resp, nil
+}
+
+// importRow checks and imports a row, returning its resource and what became of it. seen holds the rows
+// that have named each resource so far.
+func (h *Handler) importRow(cell func(string) string, seen map[string]int, row int, dryRun bool, ws string) (*models.Resource, string, error) {
+	name, env := strings.Trim(cell("name"), "`"), strings.Trim(cell("env"), "`")
+	if name == "" {
+		return nil, "", errors.New("the name is blank")
+	}
+	if strings.ContainsAny(name, "| ") || strings.ContainsAny(env, "| ") {
+		return nil, "", errors.New("names and envs can't have spaces or |")
+	}
+	if env == "" && h.reqEnv {
+		return nil, "", errors.New("the env is blank")
+	}
+	res := &models.Resource{Name: name, Env: env}
+	if first, ok := seen[res.Key()]; ok {
+		return res, "", fmt.Errorf("row %d already imports it", first)
+	}
+	seen[res.Key()] = row
+
+	if c := cell("capacity"); c != "" && c != "1" {
+		return res, "", errors.New("the capacity can only be 1, as resources are held by one person at a time")
+	}
+	owner := ""
+	if o := cell("owner"); o != "" {
+		id, err := h.importOwner(o)
+		if err != nil {
+			return res, "", err
+		}
+		owner = id
+	}
+	description := cell("description")
+
+	existing := h.data.GetResource(name, env, false)
+	if existing == nil {
+		if msg := h.misnamed(res); msg != "" {
+			return res, "", errors.New(msg)
+		}
+	} else {
+		if h.isolateWorkspaces && ws != "" && existing.Workspace != "" && existing.Workspace != ws {
+			return res, "", errors.New("it belongs to another workspace")
+		}
+		if (owner == "" || owner == existing.Owner) && (description == "" || description == existing.Description) {
+			return res, api.ImportUnchanged, nil
+		}
+	}
+
+	result := api.ImportUpdated
+	if existing == nil {
+		result = api.ImportCreated
+	}
+	if dryRun {
+		return res, result, nil
+	}
+	if existing == nil {
+		if err := h.data.Create(name, env); err != nil {
+			return res, "", err
+		}
+	}
+	_, err := h.updateResource(name, env, func(r *models.Resource) error {
+		if owner != "" {
+			r.Owner = owner
+		}
+		if description != "" {
+			r.Description = description
+		}
+		if existing == nil && h.isolateWorkspaces {
+			r.Workspace = ws
+		}
+		return nil
+	})
+	if err != nil {
+		return res, "", err
+	}
+	log.Infof("Imported %s: %s", res, result)
+	return res, result, nil
+}
+
+// importOwner returns the ID of the owner given in an import, as a Slack mention, a user ID or an email
+// address
+func (h *Handler) importOwner(owner string) (string, error) {
+	if strings.Contains(owner, "@") && !strings.HasPrefix(owner, "<@") {
+		u, err := h.client.GetUserByEmail(owner)
+		if err != nil {
+			return "", fmt.Errorf("no one in Slack has the email address %s", owner)
+		}
+		return u.ID, nil
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(owner, "<@"), ">")
+	if i := strings.Index(id, "|"); i != -1 {
+		id = id[:i]
+	}
+	if _, err := h.getUser(id); err != nil {
+		return "", fmt.Errorf("the owner %s isn't a Slack user", owner)
+	}
+	return id, nil
+}
+
+// importFile imports resources from a CSV file shared in a DM, replying with what became of each row
+func (h *Handler) importFile(ea *EventAction) error {
+	if len(ea.Event.Files) == 0 {
+		h.errorReply(ea, msgAttachACSVFileToImport)
+		return nil
+	}
+	file := ea.Event.Files[0]
+	var buf bytes.Buffer
+	if err := h.client.GetFile(file.URLPrivateDownload, &buf); err != nil {
+		log.Errorf("Error downloading %s: %+v", file.Name, err)
+		h.errorReply(ea, fmt.Sprintf(msgCouldntDownloadX, file.Name))
+		return err
+	}
+
+	resp, err := h.importResources(buf.String(), ea.Command.HasFlag("dry-run"), workspace(ea))
+	if err != nil {
+		h.errorReply(ea, fmt.Sprintf(msgCouldntImportXY, file.Name, err))
+		return nil
+	}
+	log.Infof("%s imported %s: %d created, %d updated, %d unchanged, %d failed", ea.Event.User, file.Name, resp.Created, resp.Updated, resp.Unchanged, resp.Failed)
+	return h.reply(ea, importText(resp), false)
+}
+
+// importText summarizes an import, with a line for each row that changed or failed
+func importText(resp *api.ImportResponse) string {
+	text := fmt.Sprintf(msgImportedNCreatedNUpdated, resp.Created, resp.Updated, resp.Unchanged, resp.Failed)
+	if resp.DryRun {
+		text = fmt.Sprintf(msgWouldImportNCreatedN, resp.Created, resp.Updated, resp.Unchanged, resp.Failed)
+	}
+	for _, row := range resp.Rows {
+		switch row.Result {
+		case api.ImportUnchanged:
+			continue
+		case api.ImportFailed:
+			text += fmt.Sprintf("\n:x: Row %d", row.Row)
+			if row.Resource != "" {
+				text += fmt.Sprintf(" `%s`", row.Resource)
+			}
+			text += ": " + row.Error
+		default:
+			text += fmt.Sprintf("\n:white_check_mark: Row %d `%s`: %s", row.Row, row.Resource, row.Result)
+		}
+	}
+	return text
+}
+
+// ImportWebhook imports resources in bulk from a CSV file, like the `import resources` command. It only
+// takes admin API keys.
+func (h *Handler) ImportWebhook() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !h.authorizeRequest(w, r, "", models.ScopeAdmin) {
+			return
+		}
+
+		req := &api.ImportRequest{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 10<<20)).Decode(req); err != nil || req.CSV == "" {
+			http.Error(w, "body must be JSON with a csv", http.StatusBadRequest)
+			return
+		}
+		resp, err := h.importResources(req.CSV, req.DryRun, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Infof("An API key imported resources: %d created, %d updated, %d unchanged, %d failed", resp.Created, resp.Updated, resp.Unchanged, resp.Failed)
+		writeJSON(w, resp)
+	})
+}
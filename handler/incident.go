@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/api"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultIncidentSeverity is the least severe incident that pre-empts incident resources, unless another
+// is set
+const defaultIncidentSeverity = 2
+
+// SetIncidentSeverity sets the least severe incident that pre-empts incident resources. Severities count
+// up from 1, the most severe.
+func (h *Handler) SetIncidentSeverity(severity int) {
+	h.incidentSeverity = severity
+}
+
+// severeEnough returns if an incident of a severity pre-empts incident resources
+func (h *Handler) severeEnough(severity int) bool {
+	least := h.incidentSeverity
+	if least <= 0 {
+		least = defaultIncidentSeverity
+	}
+	return severity >= 1 && severity <= least
+}
+
+func setIncident(r *models.Resource, value string, allowed bool) (string, error) {
+	if !allowed {
+		return "", errors.New(msgOnlyOwnersCanChangeIncident)
+	}
+	switch value {
+	case "on":
+		r.Incident = true
+	case "off":
+		r.Incident = false
+	default:
+		return "", errors.New(msgInvalidIncident)
+	}
+	return value, nil
+}
+
+// parseSeverity parses a severity such as `1`, `sev1`, `SEV-1` or `P1`
+func parseSeverity(text string) (int, error) {
+	text = strings.ToLower(text)
+	for _, prefix := range []string{"sev", "p"} {
+		if strings.HasPrefix(text, prefix) {
+			text = strings.TrimPrefix(strings.TrimPrefix(text, prefix), "-")
+			break
+		}
+	}
+	severity, err := strconv.Atoi(text)
+	if err != nil || severity < 1 {
+		return 0, fmt.Errorf("invalid severity %s", text)
+	}
+	return severity, nil
+}
+
+// incident declares an incident and hands the incident resources to its commander, if it is severe
+// enough
+func (h *Handler) incident(ea *EventAction) error {
+	severity, err := parseSeverity(ea.Command.Args[0])
+	if err != nil {
+		h.errorReply(ea, fmt.Sprintf(msgInvalidSeverityX, ea.Command.Args[0]))
+		return nil
+	}
+	if len(ea.Command.Mentions) == 0 || ea.Command.Mentions[0] != ea.Command.Args[1] {
+		h.errorReply(ea, msgMentionTheCommander)
+		return nil
+	}
+	commander, err := h.getUser(ea.Command.Args[1])
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.reply(ea, msgUknownUser, true)
+		return err
+	}
+	if !h.severeEnough(severity) {
+		return h.reply(ea, fmt.Sprintf(msgSevNIsNotSevereEnough, severity), true)
+	}
+
+	taken := h.DeclareIncident(severity, commander, strings.TrimSpace(ea.Command.Rest(2)))
+	if len(taken) == 0 {
+		return h.reply(ea, msgNoIncidentResourcesToTake, true)
+	}
+	return h.reply(ea, fmt.Sprintf(msgHandedYToXForTheIncident, h.resourcesText(taken), h.getUserDisplay(commander, false)), true)
+}
+
+// resourcesText lists resources for a message
+func (h *Handler) resourcesText(resources []*models.Resource) string {
+	ret := []string{}
+	for _, r := range resources {
+		ret = append(ret, h.resourceText(r))
+	}
+	return strings.Join(ret, ", ")
+}
+
+// IncidentWebhook returns an HTTP handler that declares incidents reported by an incident management
+// tool. Requests must present the secret in the X-Reservebot-Secret header.
+func (h *Handler) IncidentWebhook(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !h.authorizeRequest(w, r, secret, models.ScopeAdmin) {
+			return
+		}
+
+		req := &api.IncidentRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Severity < 1 || req.Commander == "" {
+			http.Error(w, "body must be JSON with a severity and a commander", http.StatusBadRequest)
+			return
+		}
+		commander, err := h.getUser(req.Commander)
+		if err != nil {
+			http.Error(w, "unknown commander", http.StatusBadRequest)
+			return
+		}
+
+		ret := &api.IncidentResponse{Preempted: []string{}}
+		if h.severeEnough(req.Severity) {
+			for _, res := range h.DeclareIncident(req.Severity, commander, strings.TrimSpace(req.Title)) {
+				ret.Preempted = append(ret.Preempted, res.String())
+			}
+		}
+		writeJSON(w, ret)
+	})
+}
+
+// DeclareIncident hands every incident resource to the commander of an incident and returns those that
+// changed hands. Whoever held each one is moved to the front of the line behind the commander, and told.
+// Resources being reset aren't taken, since their holder already released them, and neither are those
+// held for another incident.
+func (h *Handler) DeclareIncident(severity int, commander *models.User, title string) []*models.Resource {
+	name := title
+	if name == "" {
+		name = fmt.Sprintf("SEV%d", severity)
+	}
+	log.Infof("SEV%d incident %q declared with %s as commander", severity, title, commander.Name)
+
+	taken := []*models.Resource{}
+	for _, r := range h.data.GetResources() {
+		if !r.Incident {
+			continue
+		}
+		displaced, ok := h.preempt(r, commander, name)
+		if !ok {
+			continue
+		}
+		taken = append(taken, r)
+		if displaced != nil {
+			h.notify(displaced.User, fmt.Sprintf(msgXTookYForTheIncidentZ, h.getUserDisplay(commander, false), h.resourceText(r), name))
+		}
+	}
+	if len(taken) > 0 {
+		h.notify(commander, fmt.Sprintf(msgYouHoldYForTheIncidentZ, h.resourcesText(taken), name))
+	}
+	return taken
+}
+
+// preempt hands a resource to an incident commander and returns whoever held it, if anyone, and if it
+// was handed over. The hold lasts until the commander releases it.
+func (h *Handler) preempt(r *models.Resource, commander *models.User, incident string) (*models.Reservation, bool) {
+	// the commander and whoever held the resource are told by DeclareIncident
+	h.preempting.Store(r.Key(), true)
+	defer h.preempting.Delete(r.Key())
+
+	q, err := h.data.GetQueueForResource(r.Name, r.Env)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return nil, false
+	}
+	if q.Resetting() {
+		log.Infof("Not taking %s for the incident, it is being reset", r)
+		return nil, false
+	}
+	var displaced *models.Reservation
+	if q.HasReservations() && !r.Drawing() {
+		displaced = q.Reservations[0]
+	}
+	if displaced != nil && displaced.User.ID != commander.ID && displaced.Incident != "" {
+		log.Infof("Not taking %s for the incident, it is held for %s", r, displaced.Incident)
+		return nil, false
+	}
+	handed := displaced == nil || displaced.User.ID != commander.ID
+
+	if !inQueue(commander, q) {
+		if err := h.data.Reserve(commander, r.Name, r.Env); err != nil {
+			log.Errorf("%+v", err)
+			return nil, false
+		}
+	}
+	if r.Drawing() {
+		_, err := h.updateResource(r.Name, r.Env, func(r *models.Resource) error {
+			r.DrawAt = time.Time{}
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			return nil, false
+		}
+	}
+	if handed {
+		if err := h.data.Promote(commander, r.Name, r.Env); err != nil {
+			log.Errorf("%+v", err)
+			return nil, false
+		}
+	}
+	err = h.updateReservation(commander, r.Name, r.Env, func(res *models.Reservation) error {
+		res.Incident = incident
+		res.Duration = 0
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+	if !handed {
+		return nil, false
+	}
+	if displaced != nil {
+		log.Infof("Took %s from %s for the incident", r, displaced.User.Name)
+	}
+	return displaced, true
+}
+
+// incidentText describes the incident a reservation was made for, if any
+func incidentText(res *models.Reservation) string {
+	if res.Incident == "" {
+		return ""
+	}
+	return fmt.Sprintf(" :rotating_light: for the incident _%s_", res.Incident)
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/command"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack/slackevents"
+)
+
+var (
+	// intakeCounts holds the number of events queued, handled and dropped as too old or because the
+	// queue was full, published at /debug/vars
+	intakeCounts = expvar.NewMap("reservebot_intake")
+	// intakeDepth is the number of events waiting to be handled
+	intakeDepth = expvar.NewInt("reservebot_intake_depth")
+)
+
+// SetEventIntake queues events from Slack to be handled in the order they came by HandleEvents, so that
+// a burst, such as the backlog Slack sends after a reconnect, doesn't hold up receiving more. Up to size
+// events may wait, after which more are dropped. Commands given more than maxAge before they are handled
+// are dropped rather than acted on late, and whoever gave them is told. A size below 1 leaves events to
+// be handled as they arrive, however old they are.
+func (h *Handler) SetEventIntake(size int, maxAge time.Duration) {
+	h.maxEventAge = maxAge
+	if size < 1 {
+		h.intake = nil
+		return
+	}
+	h.intake = make(chan slackevents.EventsAPIEvent, size)
+}
+
+// QueueEvent queues an event to be handled by HandleEvents. Without an intake, the event is handled at
+// once.
+func (h *Handler) QueueEvent(event slackevents.EventsAPIEvent) {
+	if h.intake == nil {
+		if err := h.CallbackEvent(event); err != nil {
+			log.Errorf("%+v", err)
+		}
+		return
+	}
+	select {
+	case h.intake <- event:
+		intakeCounts.Add("queued", 1)
+		intakeDepth.Set(int64(len(h.intake)))
+	default:
+		intakeCounts.Add("dropped_full", 1)
+		log.Warnf("Dropped an event, as %d are already waiting to be handled", cap(h.intake))
+		h.tellDropped(event, func(text string) string {
+			return fmt.Sprintf(msgTooBusyForX, text)
+		})
+	}
+}
+
+// HandleEvents handles queued events in turn. It blocks forever and is intended to be run in its own
+// goroutine. Without an intake it returns at once.
+func (h *Handler) HandleEvents() {
+	if h.intake == nil {
+		return
+	}
+	for event := range h.intake {
+		intakeDepth.Set(int64(len(h.intake)))
+		if age, ok := eventAge(event); ok && h.maxEventAge > 0 && age > h.maxEventAge {
+			intakeCounts.Add("dropped_stale", 1)
+			log.Warnf("Dropped a command given %s ago", age.Round(time.Second))
+			h.tellDropped(event, func(text string) string {
+				return fmt.Sprintf(msgIgnoredXGivenYAgo, text, durationText(age))
+			})
+			continue
+		}
+		intakeCounts.Add("handled", 1)
+		if err := h.CallbackEvent(event); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+}
+
+// tellDropped lets whoever gave a command know it was dropped, with the message msg makes for the
+// command. Events that aren't commands to the bot are dropped silently.
+func (h *Handler) tellDropped(event slackevents.EventsAPIEvent, msg func(text string) string) {
+	var user, text string
+	switch ev := event.InnerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		user, text = ev.User, ev.Text
+	case *slackevents.MessageEvent:
+		if !h.shouldHandle(ev) {
+			return
+		}
+		user, text = ev.User, ev.Text
+	default:
+		return
+	}
+	text = strings.Join(command.Split(text, h.botID), "; ")
+	if user == "" || text == "" {
+		return
+	}
+	u, err := h.getUser(user)
+	if err != nil {
+		u = &models.User{ID: user}
+	}
+	h.notify(u, msg(text))
+}
+
+// eventAge returns how long ago the command in an event was given. It returns false for events that
+// aren't commands.
+func eventAge(event slackevents.EventsAPIEvent) (time.Duration, bool) {
+	var ts string
+	switch ev := event.InnerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		ts = ev.TimeStamp
+	case *slackevents.MessageEvent:
+		ts = ev.TimeStamp
+	default:
+		return 0, false
+	}
+	// message timestamps are seconds since the epoch, with the microseconds after a dot
+	secs, err := strconv.ParseFloat(ts, 64)
+	if err != nil || secs <= 0 {
+		return 0, false
+	}
+	return time.Since(time.Unix(0, int64(secs*float64(time.Second)))), true
+}
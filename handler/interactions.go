@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/command"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	// ReserveShortcutID is the callback ID of the "Reserve mentioned resource..." message shortcut,
+	// which must be used when the shortcut is created in the Slack app's settings
+	ReserveShortcutID = "reserve_from_message"
+
+	reserveModalID        = "reserve_modal"
+	reserveResourcesBlock = "resources"
+	reserveDurationBlock  = "duration"
+)
+
+// Interaction handles a Slack interaction, such as a shortcut, the submission of a modal or the loading
+// of a select's options. The returned payload, if any, must be sent as the acknowledgement of the
+// interaction.
+func (h *Handler) Interaction(cb slack.InteractionCallback) (interface{}, error) {
+	if u, err := h.getUser(cb.User.ID); err == nil {
+		cb.User.ID = u.ID
+	}
+	switch {
+	case cb.Type == slack.InteractionTypeMessageAction && cb.CallbackID == ReserveShortcutID:
+		return nil, h.openReserveModal(cb)
+	case cb.Type == slack.InteractionTypeViewSubmission && cb.View.CallbackID == reserveModalID:
+		return h.submitReserveModal(cb)
+	case cb.Type == slack.InteractionTypeBlockSuggestion:
+		return h.suggest(cb), nil
+	case cb.Type == slack.InteractionTypeBlockActions:
+		for _, action := range cb.ActionCallback.BlockActions {
+			var err error
+			if isApprovalAction(action) {
+				err = h.approvalAction(cb, action)
+			} else if isResourceRequestAction(action) {
+				err = h.resourceRequestAction(cb, action)
+			} else if isHandoffAction(action) {
+				err = h.handoffAction(cb, action)
+			} else if action.ActionID == takeOfferAction {
+				err = h.offerAction(cb, action)
+			} else if action.ActionID == claimTurnAction {
+				err = h.claimAction(cb, action)
+			} else if action.ActionID == keepHoldAction {
+				err = h.keepHoldAction(cb, action)
+			} else if isChecklistAction(action) {
+				err = h.checklistAction(cb, action)
+			} else if isClearEnvAction(action) {
+				err = h.clearEnvAction(cb, action)
+			} else if isChooseEnvAction(action) {
+				err = h.chooseEnvAction(cb, action)
+			} else if action.ActionID == setUpChannelAction {
+				err = h.setUpChannelAction(cb, action)
+			} else {
+				err = h.unfurlAction(cb, action)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// openReserveModal opens a modal for reserving the resources mentioned in a message. The modal
+// remembers the channel of the message so the reservation is announced where it was asked about.
+func (h *Handler) openReserveModal(cb slack.InteractionCallback) error {
+	// the options are loaded as the resources are typed, by suggest
+	resourcesInput := slack.NewOptionsMultiSelectBlockElement(slack.MultiOptTypeExternal, slack.NewTextBlockObject(slack.PlainTextType, "Start typing an env or name", false, false), reserveResourcesBlock)
+	for _, r := range h.mentionedResources(cb.Message.Text) {
+		resourcesInput.InitialOptions = append(resourcesInput.InitialOptions, resourceOption(r))
+	}
+	minQueryLength := 0
+	resourcesInput.MinQueryLength = &minQueryLength
+	durationInput := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject(slack.PlainTextType, "2h", false, false), reserveDurationBlock)
+	duration := slack.NewInputBlock(reserveDurationBlock, slack.NewTextBlockObject(slack.PlainTextType, "For", false, false), slack.NewTextBlockObject(slack.PlainTextType, "Leave empty to use the resource's default duration", false, false), durationInput)
+	duration.Optional = true
+
+	modal := slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      reserveModalID,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, "Reserve", false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Reserve", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		PrivateMetadata: cb.Channel.ID,
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewInputBlock(reserveResourcesBlock, slack.NewTextBlockObject(slack.PlainTextType, "Resources", false, false), nil, resourcesInput),
+				duration,
+			},
+		},
+	}
+
+	_, err := h.client.OpenView(cb.TriggerID, modal)
+	return err
+}
+
+// submitReserveModal reserves the resources entered in the modal. Invalid input is reported on the
+// modal so the user can correct it.
+func (h *Handler) submitReserveModal(cb slack.InteractionCallback) (interface{}, error) {
+	values := map[string]string{}
+	if cb.View.State != nil {
+		for block, actions := range cb.View.State.Values {
+			values[block] = strings.TrimSpace(actions[block].Value)
+			if selected := actions[block].SelectedOptions; len(selected) > 0 {
+				options := []string{}
+				for _, o := range selected {
+					options = append(options, o.Value)
+				}
+				values[block] = strings.Join(options, ",")
+			}
+		}
+	}
+
+	cmd := &command.Command{
+		Action: "reserve",
+		Flags:  map[string]string{},
+	}
+	for _, r := range strings.Split(values[reserveResourcesBlock], ",") {
+		if r = strings.Trim(r, " `"); r != "" {
+			cmd.Resources = append(cmd.Resources, r)
+		}
+	}
+	if _, err := h.getResourcesFromList(cmd.Resources, h.defaultEnv(cb.User.ID)); err != nil {
+		// modal errors are plain text, so they can't use the formatting of the usual messages
+		msg := "You must specify a valid resource"
+		var amb *ambiguousResource
+		if errors.As(err, &amb) {
+			msg = fmt.Sprintf("%s is in several envs, so give it as one of %s", amb.name, strings.Join(amb.qualified(), ", "))
+		} else if errors.Is(err, e.InvalidResourceFormat) {
+			msg = "Resources must be formatted as env|name"
+		}
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{reserveResourcesBlock: msg}), nil
+	}
+	if v := values[reserveDurationBlock]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return slack.NewErrorsViewSubmissionResponse(map[string]string{reserveDurationBlock: "Durations must be formatted like 30m or 2h"}), nil
+		}
+		cmd.Duration = d
+	}
+
+	ea := &EventAction{
+		Event: &slackevents.MessageEvent{
+			User:       cb.User.ID,
+			Channel:    cb.View.PrivateMetadata,
+			SourceTeam: cb.Team.ID,
+			// the view identifies the submission, so a redelivered submission isn't handled twice
+			TimeStamp: cb.View.ID,
+		},
+		Command: cmd,
+	}
+	if ea.Event.Channel == "" {
+		// without a channel, confirm the reservation by DM
+		c, _, _, err := h.client.OpenConversation(&slack.OpenConversationParameters{Users: []string{cb.User.ID}})
+		if err != nil {
+			return nil, err
+		}
+		ea.Event.Channel = c.ID
+		ea.Event.ChannelType = "im"
+	}
+
+	if r := h.foreignResource(ea); r != nil {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{reserveResourcesBlock: fmt.Sprintf(msgXInAnotherWorkspace, r)}), nil
+	}
+	if !h.authorize(ea) || !h.claimRequest(ea) {
+		return nil, nil
+	}
+	err := h.reserve(ea)
+	h.settleRequest(ea, err)
+	return nil, err
+}
+
+// mentionedResources returns the existing resources mentioned in a message, either as env|name or by
+// name alone
+func (h *Handler) mentionedResources(text string) []*models.Resource {
+	ret := []*models.Resource{}
+	for _, r := range h.data.GetResources() {
+		if mentions(text, r) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+func mentions(text string, r *models.Resource) bool {
+	for _, s := range []string{r.String(), r.Name} {
+		if s == "" {
+			continue
+		}
+		re, err := regexp.Compile(fmt.Sprintf(`(?i)(^|[^\w|-])%s($|[^\w|-])`, regexp.QuoteMeta(s)))
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// labelMaxLength is the most characters a label may have
+const labelMaxLength = 40
+
+// label lets the holder of a resource say what state it is in, such as deploying, testing or broken, so
+// that those waiting can tell active work from a stuck deploy. The label is shown in status and sent with
+// webhooks. `clear` removes it, and it goes when the hold ends.
+func (h *Handler) label(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	res, err := h.parseResource(strings.Trim(ea.Command.Args[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return nil
+	}
+	label := strings.Trim(strings.TrimSpace(ea.Command.Rest(1)), "`*_~")
+	if strings.EqualFold(label, "clear") {
+		label = ""
+	}
+	if utf8.RuneCountInString(label) > labelMaxLength {
+		h.errorReply(ea, fmt.Sprintf(msgLabelsAreUpToN, labelMaxLength))
+		return nil
+	}
+
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+	if !q.HasReservations() || q.Resource.Drawing() || q.Resetting() || q.Reservations[0].User.ID != u.ID {
+		h.errorReply(ea, fmt.Sprintf(msgYouDontHoldY, h.resourceText(res)))
+		return nil
+	}
+	err = h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+		r.Label = label
+		return nil
+	})
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+
+	if label == "" {
+		log.Infof("%s cleared the label of %s", u.Name, res)
+		return h.reply(ea, fmt.Sprintf(msgClearedTheLabelOfY, h.resourceText(q.Resource)), true)
+	}
+	log.Infof("%s labeled %s %s", u.Name, res, label)
+	return h.reply(ea, fmt.Sprintf(msgYIsLabeledZ, h.resourceText(q.Resource), label), true)
+}
+
+// labelText returns the label of the holder of a resource, for status. An empty string is returned if
+// there is none.
+func labelText(q *models.Queue) string {
+	if !q.HasReservations() || q.Reservations[0].Label == "" || q.Resource.Drawing() || q.Resetting() {
+		return ""
+	}
+	return fmt.Sprintf(" :label: *%s*.", q.Reservations[0].Label)
+}
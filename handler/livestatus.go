@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// liveStatusDelay is how long changes are gathered for before live status messages are updated, so that
+// a burst of them, such as a release handing a resource on, updates each message once
+const liveStatusDelay = 2 * time.Second
+
+// liveStatusGone are the errors Slack gives when a live status message can no longer be updated, because
+// it or its channel was deleted or the bot was removed from the channel
+var liveStatusGone = []string{"message_not_found", "channel_not_found", "cant_update_message", "is_archived", "not_in_channel"}
+
+// liveStatuses keeps the live status messages pinned in channels up to date
+type liveStatuses struct {
+	lock sync.Mutex
+	// pending is set while an update is waiting for changes to be gathered
+	pending bool
+
+	// updating is held while messages are updated, so that an older status never overwrites a newer one
+	updating sync.Mutex
+	// texts are what each channel's message was last set to, so that it is only updated when it changes
+	texts map[string]string
+}
+
+func newLiveStatuses() *liveStatuses {
+	return &liveStatuses{
+		texts: map[string]string{},
+	}
+}
+
+// liveStatus posts a message in the channel with the status of every resource and pins it, and the
+// message is then updated in place whenever reservations change, so that no one has to keep asking for
+// the status. A channel has one live status message: asking again replaces it with a new one, such as
+// when it has scrolled out of sight, and `live status off` stops updating it.
+func (h *Handler) liveStatus(ea *EventAction) error {
+	if ea.Event.ChannelType == "im" {
+		h.errorReply(ea, msgLiveStatusOnlyInChannels)
+		return nil
+	}
+	channel := ea.Event.Channel
+	existing, err := h.liveStatusIn(channel)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	off := false
+	if len(ea.Command.Args) > 0 {
+		if strings.ToLower(ea.Command.Args[0]) != "off" {
+			h.errorReply(ea, msgInvalidLiveStatus)
+			return nil
+		}
+		off = true
+	}
+	if off && existing == nil {
+		return h.reply(ea, msgNoLiveStatusHere, false)
+	}
+
+	if existing != nil {
+		if err := h.data.DeleteLiveStatus(channel); err != nil {
+			log.Errorf("%+v", err)
+			h.errorReply(ea, "")
+			return err
+		}
+		h.stopLiveStatus(existing)
+	}
+	if off {
+		log.Infof("%s stopped the live status in %s", ea.Event.User, channel)
+		return h.reply(ea, msgLiveStatusStopped, false)
+	}
+
+	h.live.updating.Lock()
+	defer h.live.updating.Unlock()
+
+	text := h.liveStatusText(workspace(ea), time.Now())
+	_, ts, err := h.client.PostMessage(channel, slack.MsgOptionText(text, false))
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	s := &models.LiveStatus{
+		Channel:   channel,
+		Timestamp: ts,
+		Workspace: workspace(ea),
+		Created:   time.Now(),
+	}
+	if err := h.data.SaveLiveStatus(s); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	h.live.texts[channel] = text
+	log.Infof("%s started a live status in %s", ea.Event.User, channel)
+
+	if err := h.client.AddPin(channel, slack.NewRefToMessage(channel, ts)); err != nil {
+		log.Errorf("Error pinning the live status in %s: %+v", channel, err)
+		return h.reply(ea, msgPinLiveStatusYourself, false)
+	}
+	return nil
+}
+
+// liveStatusIn returns the live status message of a channel, or nil if it has none
+func (h *Handler) liveStatusIn(channel string) (*models.LiveStatus, error) {
+	live, err := h.data.GetLiveStatuses()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range live {
+		if s.Channel == channel {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+// stopLiveStatus unpins a live status message that is no longer updated and says so in it, so that no
+// one is misled by it
+func (h *Handler) stopLiveStatus(s *models.LiveStatus) {
+	h.live.updating.Lock()
+	defer h.live.updating.Unlock()
+
+	delete(h.live.texts, s.Channel)
+	if err := h.client.RemovePin(s.Channel, slack.NewRefToMessage(s.Channel, s.Timestamp)); err != nil {
+		log.Debugf("Error unpinning the live status in %s: %+v", s.Channel, err)
+	}
+	if _, _, _, err := h.client.UpdateMessage(s.Channel, s.Timestamp, slack.MsgOptionText(msgLiveStatusNoLongerUpdated, false)); err != nil {
+		log.Debugf("Error updating the live status in %s: %+v", s.Channel, err)
+	}
+}
+
+// liveStatusText returns the text of a live status message for a workspace. Who is in private queues is
+// never shown, since everyone in the channel can read it.
+func (h *Handler) liveStatusText(ws string, now time.Time) string {
+	lines := []string{fmt.Sprintf(msgLiveStatusUpdatedX, now.Unix(), now.UTC().Format("15:04 MST"))}
+	shown := 0
+	for _, q := range h.data.GetQueues() {
+		if h.isolateWorkspaces && ws != "" && q.Resource.Workspace != "" && q.Resource.Workspace != ws {
+			continue
+		}
+		lines = append(lines, h.queueText(q, false, !q.Resource.Private, time.Local))
+		shown++
+	}
+	if shown == 0 {
+		lines = append(lines, msgNoReservations)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// refreshLiveStatuses updates the live status messages shortly after an event, gathering the events
+// that follow it so that they are all shown by one update
+func (h *Handler) refreshLiveStatuses(ev events.Event) {
+	if h.sandbox || h.readOnly {
+		return
+	}
+	h.live.lock.Lock()
+	defer h.live.lock.Unlock()
+
+	if h.live.pending {
+		return
+	}
+	h.live.pending = true
+	time.AfterFunc(liveStatusDelay, func() {
+		h.live.lock.Lock()
+		h.live.pending = false
+		h.live.lock.Unlock()
+
+		h.UpdateLiveStatuses()
+	})
+}
+
+// UpdateLiveStatuses brings every live status message up to date. It is run after reservations change,
+// and should also be run every minute to keep how long resources have been held current. Messages whose
+// status hasn't changed are left alone, and messages that can no longer be updated are forgotten.
+func (h *Handler) UpdateLiveStatuses() {
+	if h.readOnly {
+		return
+	}
+	live, err := h.data.GetLiveStatuses()
+	if err != nil {
+		log.Errorf("Error getting live statuses: %+v", err)
+		return
+	}
+
+	h.live.updating.Lock()
+	defer h.live.updating.Unlock()
+
+	now := time.Now()
+	for _, s := range live {
+		text := h.liveStatusText(s.Workspace, now)
+		if stripLiveStatusTime(text) == stripLiveStatusTime(h.live.texts[s.Channel]) {
+			continue
+		}
+		_, _, _, err := h.client.UpdateMessage(s.Channel, s.Timestamp, slack.MsgOptionText(text, false))
+		if err != nil && liveStatusIsGone(err) {
+			log.Infof("The live status in %s can no longer be updated, forgetting it: %v", s.Channel, err)
+			if err := h.data.DeleteLiveStatus(s.Channel); err != nil {
+				log.Errorf("%+v", err)
+			}
+			delete(h.live.texts, s.Channel)
+			continue
+		}
+		if err != nil {
+			log.Errorf("Error updating the live status in %s: %+v", s.Channel, err)
+			continue
+		}
+		h.live.texts[s.Channel] = text
+	}
+}
+
+// stripLiveStatusTime returns the text of a live status message without when it was updated, so that
+// messages aren't updated for the time alone
+func stripLiveStatusTime(text string) string {
+	if i := strings.Index(text, "\n"); i >= 0 {
+		return text[i:]
+	}
+	return ""
+}
+
+func liveStatusIsGone(err error) bool {
+	for _, gone := range liveStatusGone {
+		if strings.Contains(err.Error(), gone) {
+			return true
+		}
+	}
+	return false
+}
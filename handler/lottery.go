@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/policy"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+const drawTimeFormat = "15:04 MST"
+
+func setLottery(r *models.Resource, value string, allowed bool) (string, error) {
+	if !allowed {
+		return "", errors.New(msgOnlyOwnersCanChangeLottery)
+	}
+	if value == "none" {
+		r.Lottery = 0
+		return "none", nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return "", errors.New(msgInvalidDuration)
+	}
+	r.Lottery = d
+	return durationText(d), nil
+}
+
+// startDraw pools the users in line for a lottery resource that has become free, so that its holder
+// is drawn from them and anyone who reserves it before the draw
+func (h *Handler) startDraw(r *models.Resource, entrants []*models.Reservation) {
+	r, err := h.updateResource(r.Name, r.Env, func(r *models.Resource) error {
+		if r.Drawing() {
+			return nil
+		}
+		r.DrawAt = time.Now().Add(r.Lottery)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+
+	for _, res := range entrants {
+		at := r.DrawAt.In(h.userLocation(res.User.ID)).Format(drawTimeFormat)
+		h.notify(res.User, fmt.Sprintf(msgYouAreInTheDrawForYAtZ, h.resourceText(r), at))
+	}
+}
+
+// DrawLotteries holds the draws that are due. The winner is drawn with the lottery policy, whatever
+// policy the resource follows otherwise, and moved to the front of the queue. Everyone else keeps their
+// place behind them. A resource that is closed is drawn for when it opens.
+func (h *Handler) DrawLotteries() {
+	now := time.Now()
+
+	for _, q := range h.data.GetQueues() {
+		r := q.Resource
+		if !r.Drawing() || now.Before(r.DrawAt) || r.Closed(now) {
+			continue
+		}
+
+		var winner *models.Reservation
+		if q.HasReservations() {
+			winner = policy.NewLottery(h.usage).Next(r, q.Reservations)
+			if err := h.data.Promote(winner.User, r.Name, r.Env); err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+			log.Infof("%s won the draw for %s among %d", winner.User.Name, r, len(q.Reservations))
+		}
+
+		_, err := h.updateResource(r.Name, r.Env, func(r *models.Resource) error {
+			r.DrawAt = time.Time{}
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		if winner == nil {
+			continue
+		}
+
+		h.recordWait(r, winner, now)
+		h.notify(winner.User, fmt.Sprintf(msgYouWonTheDrawForY, h.resourceText(r)))
+		pos := 1
+		for _, res := range q.Reservations {
+			if res.User.ID == winner.User.ID {
+				continue
+			}
+			pos++
+			h.notify(res.User, fmt.Sprintf(msgXWonTheDrawForYYouAreN, h.getUserDisplay(winner.User, false), h.resourceText(r), util.Ordinalize(pos)))
+		}
+	}
+}
+
+// drawText describes a pending draw, in the given timezone
+func (h *Handler) drawText(q *models.Queue, loc *time.Location) string {
+	at := q.Resource.DrawAt.In(loc).Format(drawTimeFormat)
+	entrants := []string{}
+	for _, res := range q.Reservations {
+		entrants = append(entrants, h.getUserDisplay(res.User, false))
+	}
+
+	switch len(entrants) {
+	case 0:
+		return fmt.Sprintf("%s is free", formatResource(q.Resource))
+	case 1:
+		return fmt.Sprintf("%s goes to a draw at %s. %s is entered.", formatResource(q.Resource), at, entrants[0])
+	}
+	return fmt.Sprintf("%s goes to a draw at %s. %s are entered.", formatResource(q.Resource), at, strings.Join(entrants, ", "))
+}
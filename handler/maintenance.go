@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const maintenanceTimeFormat = "2006-01-02T15:04"
+
+func (h *Handler) maintenance(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	matches := ea.Command.Args
+
+	res, err := h.parseResource(strings.Trim(matches[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return nil
+	}
+
+	start := time.Now()
+	if matches[1] != "now" {
+		start, err = time.ParseInLocation(maintenanceTimeFormat, matches[1], u.Location())
+		if err != nil {
+			h.errorReply(ea, msgInvalidMaintenanceWindow)
+			return nil
+		}
+	}
+	dur, err := time.ParseDuration(matches[2])
+	if err != nil || dur <= 0 {
+		h.errorReply(ea, msgInvalidMaintenanceWindow)
+		return nil
+	}
+
+	w := &models.MaintenanceWindow{
+		Start: start,
+		End:   start.Add(dur),
+	}
+	w.Reason = strings.TrimSpace(ea.Command.Rest(3))
+
+	r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		r.Maintenance = append(r.Maintenance, w)
+		return nil
+	})
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgMaintenanceScheduledYZ, r, maintenanceText(w, u.Location())), false)
+}
+
+func (h *Handler) endMaintenance(ea *EventAction) error {
+	resources, err := h.getResourcesFromList(ea.Command.Resources, h.defaultEnv(ea.Event.User))
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	for _, res := range resources {
+		_, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+			r.Maintenance = nil
+			return nil
+		})
+		if err != nil {
+			h.handleUpdateResourceError(ea, res, err)
+			continue
+		}
+
+		h.reply(ea, fmt.Sprintf(msgMaintenanceCancelledY, res), false)
+	}
+
+	return nil
+}
+
+// WarnMaintenance notifies everyone in the queue of a resource whose maintenance window starts within
+// the given lead time. Each window is only warned about once. Windows that have ended are discarded.
+func (h *Handler) WarnMaintenance(lead time.Duration) {
+	now := time.Now()
+
+	for _, resource := range h.data.GetResources() {
+		if len(resource.Maintenance) == 0 {
+			continue
+		}
+
+		// Mark the windows as warned before sending anything so that a failed save can't cause
+		// duplicate warnings
+		var due []*models.MaintenanceWindow
+		r, err := h.updateResource(resource.Name, resource.Env, func(r *models.Resource) error {
+			due = nil
+			r.ExpireMaintenance(now)
+			for _, w := range r.Maintenance {
+				if w.Warned || w.Start.After(now.Add(lead)) {
+					continue
+				}
+				w.Warned = true
+				due = append(due, w)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		q, err := h.data.GetQueueForResource(r.Name, r.Env)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+
+		for _, w := range due {
+			for _, res := range q.Reservations {
+				msg := fmt.Sprintf(msgMaintenanceWarningYZ, h.resourceText(r), maintenanceText(w, h.userLocation(res.User.ID)))
+				h.notify(res.User, msg)
+			}
+		}
+	}
+}
+
+// maintenanceText describes a maintenance window in the given timezone
+func maintenanceText(w *models.MaintenanceWindow, loc *time.Location) string {
+	if w == nil {
+		return ""
+	}
+
+	format := "Mon Jan 2 15:04 MST"
+	msg := fmt.Sprintf("from %s until %s", w.Start.In(loc).Format(format), w.End.In(loc).Format(format))
+	if w.Reason != "" {
+		msg += fmt.Sprintf(" (%s)", w.Reason)
+	}
+	return msg
+}
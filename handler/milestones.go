@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// milestone is a point waiters are told they reached in line: a place, such as 3rd or better, or an
+// estimated wait, such as 15 minutes or less
+type milestone struct {
+	place int
+	wait  time.Duration
+}
+
+// String names the milestone as it is recorded on reservations
+func (m milestone) String() string {
+	if m.place > 0 {
+		return "top " + strconv.Itoa(m.place)
+	}
+	return m.wait.String()
+}
+
+// SetMilestones tells waiters when they reach milestones in line, given as a comma separated list of
+// places and estimated waits, e.g. `3,15m` for when they are 3rd in line or better and when their turn is
+// estimated to be 15 minutes away or less. Waits are estimated as for deadlines. Users can turn the
+// messages off with `settings milestones off`.
+func (h *Handler) SetMilestones(spec string) error {
+	milestones := []milestone{}
+	for _, m := range strings.Split(spec, ",") {
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+		if place, err := strconv.Atoi(m); err == nil {
+			if place < 2 {
+				return fmt.Errorf("invalid milestone %s: places start at 2, as 1st holds the resource", m)
+			}
+			milestones = append(milestones, milestone{place: place})
+			continue
+		}
+		wait, err := time.ParseDuration(m)
+		if err != nil || wait <= 0 {
+			return fmt.Errorf("invalid milestone %s: give a place in line such as 3 or a wait such as 15m", m)
+		}
+		milestones = append(milestones, milestone{wait: wait})
+	}
+	h.milestones = milestones
+	return nil
+}
+
+// milestonesFor checks the milestones of those waiting for the resource of an event, so that they hear
+// about changes in line straight away
+func (h *Handler) milestonesFor(ev events.Event) {
+	if len(h.milestones) == 0 || ev.Resource == nil {
+		return
+	}
+	q, err := h.data.GetQueueForResource(ev.Resource.Name, ev.Resource.Env)
+	if err != nil {
+		return
+	}
+	h.checkMilestones(q, time.Now())
+}
+
+// CheckMilestones checks the milestones of everyone waiting, as estimated waits shorten over time
+func (h *Handler) CheckMilestones() {
+	if len(h.milestones) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, q := range h.data.GetQueues() {
+		h.checkMilestones(q, now)
+	}
+}
+
+// checkMilestones tells those waiting for a resource about the milestones they reached since they were
+// last checked. Those already reached when someone joined the line are only noted, as the reply to their
+// reservation says where they are. Nobody is told while the resource is drawn for or closed, as places
+// and estimates don't hold then.
+func (h *Handler) checkMilestones(q *models.Queue, now time.Time) {
+	if h.readOnly || h.sandbox || q.Resource.Drawing() || q.Resource.Closed(now) {
+		return
+	}
+	for idx, res := range q.Reservations {
+		if idx == 0 || res.User.External {
+			continue
+		}
+		reached := []milestone{}
+		turn, estimated := estimatedTurn(q, idx, now)
+		for _, m := range h.milestones {
+			if (m.place > 0 && idx+1 <= m.place) || (m.wait > 0 && estimated && turn.Sub(now) <= m.wait) {
+				reached = append(reached, m)
+			}
+		}
+
+		fresh := []milestone{}
+		for _, m := range reached {
+			if !util.InSlice(res.Milestones, m.String()) {
+				fresh = append(fresh, m)
+			}
+		}
+		if res.MilestonesNoted && len(fresh) == 0 {
+			continue
+		}
+
+		noted := res.MilestonesNoted
+		err := h.updateReservation(res.User, q.Resource.Name, q.Resource.Env, func(r *models.Reservation) error {
+			milestones := append([]string{}, r.Milestones...)
+			for _, m := range fresh {
+				milestones = append(milestones, m.String())
+			}
+			r.Milestones = milestones
+			r.MilestonesNoted = true
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		if !noted {
+			continue
+		}
+		if off, err := h.data.GetMilestonesOff(res.User.ID); err != nil || off {
+			continue
+		}
+		h.notify(res.User, h.milestoneText(q.Resource, idx+1, fresh, turn, res.User.ID))
+	}
+}
+
+// milestoneText tells a user about the milestones they reached, the closest place and the shortest wait
+// first
+func (h *Handler) milestoneText(r *models.Resource, place int, reached []milestone, turn time.Time, userID string) string {
+	var top, soon *milestone
+	for i, m := range reached {
+		if m.place > 0 && (top == nil || m.place < top.place) {
+			top = &reached[i]
+		}
+		if m.wait > 0 && (soon == nil || m.wait < soon.wait) {
+			soon = &reached[i]
+		}
+	}
+
+	lines := []string{}
+	if top != nil {
+		if place == 2 {
+			lines = append(lines, fmt.Sprintf(msgYouAreNextInLineForX, h.resourceText(r)))
+		} else {
+			lines = append(lines, fmt.Sprintf(msgYouAreNowInTopNForXY, top.place, h.resourceText(r), util.Ordinalize(place)))
+		}
+	}
+	if soon != nil {
+		at := turn.In(h.userLocation(userID)).Format(hoursTimeFormat)
+		lines = append(lines, fmt.Sprintf(msgYourTurnForXIsAboutYAway, h.resourceText(r), durationText(time.Until(turn)), at))
+	}
+	lines = append(lines, msgTurnMilestonesOffWith)
+	return strings.Join(lines, " ")
+}
+
+// setMilestones turns the messages about milestones in line on or off for the user
+func (h *Handler) setMilestones(ea *EventAction) error {
+	var off bool
+	switch strings.ToLower(ea.Command.Args[0]) {
+	case "on":
+	case "off":
+		off = true
+	default:
+		h.errorReply(ea, msgMilestonesOnOrOff)
+		return nil
+	}
+
+	if err := h.data.SetMilestonesOff(ea.Event.User, off); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if off {
+		return h.reply(ea, msgYouWontBeToldMilestones, true)
+	}
+	return h.reply(ea, msgYouWillBeToldMilestones, true)
+}
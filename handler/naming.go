@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/models"
+)
+
+var (
+	// separators are the runs of characters that are turned into dashes when suggesting a name
+	separators = regexp.MustCompile(`[\s_.]+`)
+	// unsuggested are the characters dropped when suggesting a name
+	unsuggested = regexp.MustCompile(`[^a-z0-9-]`)
+)
+
+// namingPolicy is the convention new resources must be named by, to keep the catalog from sprawling
+type namingPolicy struct {
+	// name and env must match the whole of a new resource's name and env, if set
+	name, env *regexp.Regexp
+	// namePattern and envPattern are the patterns as they were configured, for showing to people
+	namePattern, envPattern string
+	// hint describes the convention to people who break it, such as `team-purpose-number, like payments-db-1`
+	hint string
+}
+
+// SetNamingPolicy makes new resources follow a naming convention: their names and envs must match the
+// patterns, which are regular expressions that must match the whole name. An empty pattern allows any
+// name. The hint is shown to people whose names don't follow the convention. Resources that already
+// exist keep working whatever their names.
+func (h *Handler) SetNamingPolicy(namePattern, envPattern, hint string) error {
+	p := &namingPolicy{
+		namePattern: strings.TrimSpace(namePattern),
+		envPattern:  strings.TrimSpace(envPattern),
+		hint:        strings.TrimSpace(hint),
+	}
+	var err error
+	if p.name, err = wholePattern(namePattern); err != nil {
+		return fmt.Errorf("invalid resource name pattern: %v", err)
+	}
+	if p.env, err = wholePattern(envPattern); err != nil {
+		return fmt.Errorf("invalid env pattern: %v", err)
+	}
+	if p.name == nil && p.env == nil {
+		return nil
+	}
+	h.naming = p
+	return nil
+}
+
+// wholePattern compiles a pattern that must match the whole of a string, or returns nil if it is empty
+func wholePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern = strings.TrimSpace(pattern); pattern == "" {
+		return nil, nil
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// misnamed returns why a new resource doesn't follow the naming convention, with a name that would if
+// one can be suggested, or an empty string if it does
+func (h *Handler) misnamed(res *models.Resource) string {
+	p := h.naming
+	if p == nil {
+		return ""
+	}
+	nameOK := p.name == nil || p.name.MatchString(res.Name)
+	envOK := p.env == nil || res.Env == "" || p.env.MatchString(res.Env)
+	if nameOK && envOK {
+		return ""
+	}
+
+	rules := []string{}
+	suggested := &models.Resource{Name: res.Name, Env: res.Env}
+	if !nameOK {
+		rules = append(rules, fmt.Sprintf(msgNamesMustMatchX, p.namePattern))
+		suggested.Name = suggestName(res.Name, p.name)
+	}
+	if !envOK {
+		rules = append(rules, fmt.Sprintf(msgEnvsMustMatchX, p.envPattern))
+		suggested.Env = suggestName(res.Env, p.env)
+	}
+	msg := fmt.Sprintf(msgXBreaksNamingConventionY, res, strings.Join(rules, " and "))
+	if p.hint != "" {
+		msg += fmt.Sprintf(msgNamingHintX, p.hint)
+	}
+	if suggested.Name != "" && (suggested.Env != "" || res.Env == "") {
+		msg += fmt.Sprintf(msgDidYouMeanX, suggested)
+	}
+	return msg
+}
+
+// suggestName returns a name close to one that matches a pattern, by lowercasing it, turning spaces,
+// underscores and dots into dashes and dropping anything else that isn't a letter, digit or dash. An
+// empty string is returned if that doesn't match either.
+func suggestName(name string, pattern *regexp.Regexp) string {
+	s := strings.ToLower(name)
+	s = separators.ReplaceAllString(s, "-")
+	s = unsuggested.ReplaceAllString(s, "")
+	s = strings.Trim(s, "-")
+	if s == "" || !pattern.MatchString(s) {
+		return ""
+	}
+	return s
+}
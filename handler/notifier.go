@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// notifyCoalesceDelay is how long the notifier waits after the first queued message so that
+	// messages to the same user can be combined into a single DM
+	notifyCoalesceDelay = 2 * time.Second
+	// notifySendInterval is the minimum time between DMs, which keeps bursts under Slack's rate limits
+	notifySendInterval = time.Second
+	// notifyMaxAttempts is how many times a DM is attempted when Slack reports it is rate limited
+	notifyMaxAttempts = 3
+)
+
+// notifier queues DMs and delivers them from a single worker. Messages queued to the same user
+// before a flush are sent as one DM.
+type notifier struct {
+	mu      sync.Mutex
+	users   map[string]*models.User
+	pending map[string][]string
+	order   []string
+	wake    chan struct{}
+	// offline is set while the connection to Slack is down, so that DMs are buffered rather than sent
+	offline bool
+	// buffered is set once a DM is buffered, until the buffer is delivered
+	buffered bool
+}
+
+func newNotifier() *notifier {
+	return &notifier{
+		users:   map[string]*models.User{},
+		pending: map[string][]string{},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// add queues a message for a user
+func (n *notifier) add(user *models.User, msg string) {
+	n.mu.Lock()
+	if _, ok := n.pending[user.ID]; !ok {
+		n.order = append(n.order, user.ID)
+		n.users[user.ID] = user
+	}
+	n.pending[user.ID] = append(n.pending[user.ID], msg)
+	n.mu.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take removes and returns everything queued, in the order users were first queued
+func (n *notifier) take() ([]*models.User, map[string][]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	users := make([]*models.User, 0, len(n.order))
+	for _, id := range n.order {
+		users = append(users, n.users[id])
+	}
+	pending := n.pending
+
+	n.users = map[string]*models.User{}
+	n.pending = map[string][]string{}
+	n.order = nil
+
+	return users, pending
+}
+
+// notify queues a DM to a user. It is delivered by SendNotifications. External users can't be sent
+// DMs, so messages to them are dropped.
+func (h *Handler) notify(user *models.User, msg string) {
+	if user.External {
+		return
+	}
+	if h.readOnly {
+		log.Infof("Read-only: would notify %s: %s", user.ID, msg)
+		return
+	}
+	h.notifier.add(user, msg)
+}
+
+// SetNotificationBuffer keeps up to limit DMs that can't be delivered while Slack is out of reach, such as
+// those sent by expiring holds while the connection is down, and delivers them once it is back. Older
+// DMs are dropped to make room.
+func (h *Handler) SetNotificationBuffer(limit int) {
+	h.dmBuffer = limit
+}
+
+// SetConnected records whether the connection to Slack is up. DMs are buffered while it is down, and the
+// buffer is delivered once it is back.
+func (h *Handler) SetConnected(connected bool) {
+	h.notifier.mu.Lock()
+	h.notifier.offline = !connected
+	h.notifier.mu.Unlock()
+
+	if connected && h.dmBuffer > 0 {
+		go h.deliverBuffered()
+	}
+}
+
+// SendNotifications delivers queued DMs. It blocks forever and is intended to be run in its own
+// goroutine.
+func (h *Handler) SendNotifications() {
+	for range h.notifier.wake {
+		time.Sleep(notifyCoalesceDelay)
+		h.FlushNotifications()
+	}
+}
+
+// FlushNotifications immediately delivers everything that is queued
+func (h *Handler) FlushNotifications() {
+	users, pending := h.notifier.take()
+	sent := false
+	for i, user := range users {
+		msg := strings.Join(pending[user.ID], "\n")
+		if h.offline() && h.bufferNotification(user, msg, time.Now()) {
+			continue
+		}
+		if i > 0 {
+			time.Sleep(notifySendInterval)
+		}
+		err := h.sendDMWithRetry(user, msg)
+		if err != nil && unreachable(err) && h.bufferNotification(user, msg, time.Now()) {
+			log.Warnf("Buffered a DM to %s, as Slack can't be reached: %+v", user.Name, err)
+			continue
+		}
+		if err != nil {
+			log.Errorf("Error notifying %s: %+v", user.Name, err)
+			continue
+		}
+		sent = true
+	}
+
+	// Slack is reachable again, so anything buffered while it wasn't can go out
+	h.notifier.mu.Lock()
+	buffered := h.notifier.buffered
+	h.notifier.mu.Unlock()
+	if sent && buffered {
+		h.deliverBuffered()
+	}
+}
+
+// offline returns if the connection to Slack is down
+func (h *Handler) offline() bool {
+	h.notifier.mu.Lock()
+	defer h.notifier.mu.Unlock()
+	return h.notifier.offline
+}
+
+// bufferNotification keeps a DM to deliver once Slack can be reached. It returns false if the DM
+// couldn't be kept, such as when there is no buffer.
+func (h *Handler) bufferNotification(user *models.User, msg string, queued time.Time) bool {
+	if h.dmBuffer <= 0 {
+		return false
+	}
+	dropped, err := h.data.BufferNotification(&models.BufferedNotification{UserID: user.ID, Message: msg, Queued: queued}, h.dmBuffer)
+	if err != nil {
+		log.Errorf("Error buffering a DM to %s: %+v", user.Name, err)
+		return false
+	}
+	if dropped > 0 {
+		log.Warnf("Dropped the %d oldest buffered DMs, as %d are already buffered", dropped, h.dmBuffer)
+	}
+
+	h.notifier.mu.Lock()
+	h.notifier.buffered = true
+	h.notifier.mu.Unlock()
+	return true
+}
+
+// deliverBuffered delivers the DMs buffered while Slack couldn't be reached, oldest first, each noting
+// when it was meant to be sent. DMs that still can't be delivered are buffered again.
+func (h *Handler) deliverBuffered() {
+	h.notifier.mu.Lock()
+	h.notifier.buffered = false
+	h.notifier.mu.Unlock()
+
+	buffered, err := h.data.TakeBufferedNotifications()
+	if err != nil {
+		log.Errorf("Error getting the buffered DMs: %+v", err)
+		return
+	}
+	if len(buffered) > 0 {
+		log.Infof("Delivering %d DMs buffered while Slack couldn't be reached", len(buffered))
+	}
+	for i, n := range buffered {
+		u, err := h.getUser(n.UserID)
+		if err != nil {
+			u = &models.User{ID: n.UserID}
+		}
+		if h.offline() {
+			h.bufferNotification(u, n.Message, n.Queued)
+			continue
+		}
+		if i > 0 {
+			time.Sleep(notifySendInterval)
+		}
+		msg := fmt.Sprintf(msgSentLateFromX, n.Queued.In(u.Location()).Format(hoursTimeFormat)) + "\n" + n.Message
+		err = h.sendDMWithRetry(u, msg)
+		if err != nil && unreachable(err) {
+			h.bufferNotification(u, n.Message, n.Queued)
+			continue
+		}
+		if err != nil {
+			log.Errorf("Error notifying %s: %+v", u.Name, err)
+		}
+	}
+}
+
+// unreachable returns if an error is from failing to reach Slack, rather than from Slack refusing a
+// request
+func unreachable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var status slack.StatusCodeError
+	return errors.As(err, &status) && status.Code >= 500
+}
+
+func (h *Handler) sendDMWithRetry(user *models.User, msg string) error {
+	var err error
+	for i := 0; i < notifyMaxAttempts; i++ {
+		err = h.sendDM(user, msg)
+		rateLimited, ok := err.(*slack.RateLimitedError)
+		if !ok {
+			return err
+		}
+		log.Warnf("Rate limited by Slack, retrying in %s", rateLimited.RetryAfter)
+		time.Sleep(rateLimited.RetryAfter)
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/ameliagapin/reservebot/command"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// observerActions are the actions of the commands observers may run, on top of the queries answered in
+// read-only mode. They only read or change what the observer is shown, never who holds what.
+var observerActions = map[string]bool{
+	"timeline":     true,
+	"heatmap":      true,
+	"watch":        true,
+	"unwatch":      true,
+	"livestatus":   true,
+	"defaultenv":   true,
+	"statusfilter": true,
+	"idetoken":     true,
+}
+
+// SetObservers makes the named users observers, such as PMs and support staff who need to see what is
+// going on but shouldn't hold resources. Observers can ask for status, history and stats, watch
+// resources and use the browser pages, but can't reserve, release or change anything. Admins are never
+// held back as observers.
+func (h *Handler) SetObservers(names []string) {
+	h.observers = names
+}
+
+// isObserver returns if a user is an observer who isn't also an admin
+func (h *Handler) isObserver(u *models.User) bool {
+	if !util.InSlice(h.observers, u.Name) {
+		return false
+	}
+	return !util.InSlice(h.admins, u.Name) && !h.adminGroups.isMember(u.ID)
+}
+
+// observerMay returns if observers may run a command with an action
+func observerMay(action string) bool {
+	return queries[action] || observerActions[action]
+}
+
+// allowedObserver returns if the user who sent a command may run it, replying if they are an observer
+// and may not. If the user can't be looked up, the command is refused, as they might be an observer.
+func (h *Handler) allowedObserver(ea *EventAction) bool {
+	if len(h.observers) == 0 || observerMay(ea.Command.Action) {
+		return true
+	}
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return false
+	}
+	if !h.isObserver(u) {
+		return true
+	}
+
+	log.Infof("Refused `%s` from %s, who is an observer", command.Name(ea.Command.Action), u.Name)
+	ea.failed = true
+	h.reply(ea, fmt.Sprintf(msgObserversCantRunX, command.Name(ea.Command.Action)), true)
+	return false
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	takeOfferAction = "offer_take"
+	// offerExpiry is how long an offer stands if nobody takes it
+	offerExpiry = time.Hour
+)
+
+// errOfferClosed is returned when someone takes an offer that lapsed or was already taken
+var errOfferClosed = errors.New("offer closed")
+
+// offer lets the holder of a resource give it up early. Everyone waiting for it is asked if they want
+// it now, and the first to take it gets it right away. Until someone does, the holder keeps it.
+func (h *Handler) offer(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	res, err := h.parseResource(strings.Trim(ea.Command.Args[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return nil
+	}
+
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+	if !q.HasReservations() || q.Resource.Drawing() || q.Resetting() || q.Reservations[0].User.ID != u.ID {
+		h.errorReply(ea, fmt.Sprintf(msgYouDontHoldY, h.resourceText(res)))
+		return nil
+	}
+	if len(q.Reservations) == 1 {
+		return h.reply(ea, fmt.Sprintf(msgNobodyIsWaitingForY, h.resourceText(res)), true)
+	}
+
+	offer := &models.Offer{
+		ID:     models.NewID(),
+		UserID: u.ID,
+		Time:   time.Now(),
+	}
+	r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		r.Offer = offer
+		return nil
+	})
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+
+	text := fmt.Sprintf(msgXOffersYTakeItNow, h.getUserDisplay(u, false), h.resourceText(r))
+	value := r.String() + " " + offer.ID
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(takeOfferAction, value, slack.NewTextBlockObject(slack.PlainTextType, "Take it now", false, false)).WithStyle(slack.StylePrimary),
+		),
+	}
+	for _, waiting := range q.Reservations[1:] {
+		if err := h.sendDMBlocks(waiting.User, text, blocks...); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+	log.Infof("%s offered %s to %d waiting", u.Name, r, len(q.Reservations)-1)
+
+	return h.reply(ea, fmt.Sprintf(msgYouOfferedYToN, h.resourceText(r), len(q.Reservations)-1, durationText(offerExpiry)), true)
+}
+
+// offerAction handles a click on an offer's button. The offer is taken off the resource, so whoever
+// takes it first gets it. The holder leaves the queue, and the taker is put in front.
+func (h *Handler) offerAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	if h.readOnly {
+		log.Infof("Read-only: would take offer %s for %s", action.Value, cb.User.ID)
+		return nil
+	}
+	fields := strings.Fields(action.Value)
+	if len(fields) != 2 {
+		return nil
+	}
+	res, err := h.parseResource(fields[0], "")
+	if err != nil || res == nil {
+		return err
+	}
+	taker, err := h.getUser(cb.User.ID)
+	if err != nil {
+		return err
+	}
+
+	var holder *models.Reservation
+	now := time.Now()
+	r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		q, err := h.data.GetQueueForResource(r.Name, r.Env)
+		if err != nil {
+			return err
+		}
+		if r.Offer == nil || r.Offer.ID != fields[1] || !inQueue(taker, q) || !r.Offer.Open(q.Reservations[0], offerExpiry, now) {
+			return errOfferClosed
+		}
+		holder = q.Reservations[0]
+		r.Offer = nil
+		return nil
+	})
+	if err == errOfferClosed || errors.Is(err, e.ResourceDoesNotExist) {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgOfferForYIsNoLongerOpen, res))
+	}
+	if err != nil {
+		return err
+	}
+
+	// the promotion ends the holder's hold, so their usage is recorded, but the policy doesn't decide who
+	// gets it and the taker is told here
+	h.offering.Store(r.Key(), true)
+	err = h.data.Promote(taker, r.Name, r.Env)
+	h.offering.Delete(r.Key())
+	if err != nil {
+		return err
+	}
+	if err := h.data.Remove(holder.User, r.Name, r.Env); err != nil && !errors.Is(err, e.NotInQueue) {
+		log.Errorf("%+v", err)
+	}
+	log.Infof("%s took %s, offered by %s", taker.Name, r, holder.User.Name)
+
+	h.notify(holder.User, fmt.Sprintf(msgXTookYYouOffered, h.getUserDisplay(taker, false), h.resourceText(r)))
+	return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouTookYFromX, h.resourceText(r), h.getUserDisplay(holder.User, false)))
+}
@@ -0,0 +1,405 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// sessionCookie holds the Slack user signed in to the browser pages
+	sessionCookie = "reservebot_session"
+	// sessionLength is how long a sign in lasts
+	sessionLength = 12 * time.Hour
+	// loginCookie holds the state of a sign in while the user is at the provider
+	loginCookie = "reservebot_login"
+	// loginLength is how long a user has to sign in at the provider
+	loginLength = 10 * time.Minute
+)
+
+// oidcProvider signs users in with an OpenID Connect provider, such as Okta or Google
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	authURL      string
+	tokenURL     string
+	jwksURL      string
+	// keys are the provider's signing keys, by ID, fetched again when a token is signed with another
+	keys     map[string]*rsa.PublicKey
+	keysLock sync.Mutex
+}
+
+// loginState is kept in a cookie while a user signs in, to check that the provider returns to the
+// browser that started
+type loginState struct {
+	State string `json:"state"`
+	Nonce string `json:"nonce"`
+	// Next is the page to return to once signed in
+	Next string `json:"next"`
+}
+
+// OIDC enables signing in to the browser pages, such as /me, with an OpenID Connect provider. Users are
+// matched to Slack users by the email address the provider verified, so the pages show the same
+// reservations as Slack. The handler this returns serves /login, /callback and /logout, and the provider
+// must return to redirectURL, which is the URL of /callback.
+func (h *Handler) OIDC(issuer, clientID, clientSecret, redirectURL string) (http.Handler, error) {
+	p := &oidcProvider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		keys:         map[string]*rsa.PublicKey{},
+	}
+	discovery := struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}{}
+	if err := getJSON(p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("error discovering %s: %v", p.issuer, err)
+	}
+	if discovery.AuthURL == "" || discovery.TokenURL == "" || discovery.JWKSURL == "" {
+		return nil, fmt.Errorf("%s isn't an OpenID Connect provider", p.issuer)
+	}
+	p.issuer, p.authURL, p.tokenURL, p.jwksURL = discovery.Issuer, discovery.AuthURL, discovery.TokenURL, discovery.JWKSURL
+	h.oidc = p
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", h.login)
+	mux.HandleFunc("/callback", h.loginCallback)
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		h.setCookie(w, sessionCookie, "", -1)
+		fmt.Fprintln(w, "You're signed out.")
+	})
+	return mux, nil
+}
+
+// login sends the user to the provider to sign in, returning to the page given by next afterwards
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		// only pages of the bot itself are returned to
+		next = "/me"
+	}
+	state := &loginState{State: randomHex(16), Nonce: randomHex(16), Next: next}
+	b, _ := json.Marshal(state)
+	h.setCookie(w, loginCookie, base64.RawURLEncoding.EncodeToString(b), loginLength)
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", h.oidc.clientID)
+	q.Set("redirect_uri", h.oidc.redirectURL)
+	q.Set("scope", "openid email")
+	q.Set("state", state.State)
+	q.Set("nonce", state.Nonce)
+	http.Redirect(w, r, h.oidc.authURL+"?"+q.Encode(), http.StatusFound)
+}
+
+// loginCallback signs in the user the provider returned with, as the Slack user with their email
+func (h *Handler) loginCallback(w http.ResponseWriter, r *http.Request) {
+	state := &loginState{}
+	c, err := r.Cookie(loginCookie)
+	if err == nil {
+		var b []byte
+		if b, err = base64.RawURLEncoding.DecodeString(c.Value); err == nil {
+			err = json.Unmarshal(b, state)
+		}
+	}
+	if err != nil || state.State == "" || r.URL.Query().Get("state") != state.State {
+		http.Error(w, "Your sign in expired, please try again.", http.StatusBadRequest)
+		return
+	}
+	h.setCookie(w, loginCookie, "", -1)
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		http.Error(w, fmt.Sprintf("You weren't signed in: %s", msg), http.StatusForbidden)
+		return
+	}
+
+	email, err := h.oidc.exchange(r.URL.Query().Get("code"), state.Nonce)
+	if err != nil {
+		log.Errorf("%+v", err)
+		http.Error(w, "Your sign in couldn't be verified, please try again.", http.StatusBadGateway)
+		return
+	}
+	u, err := h.client.GetUserByEmail(email)
+	if err != nil {
+		log.Warnf("No Slack user has the email %s: %v", email, err)
+		http.Error(w, fmt.Sprintf("No Slack user has the email %s.", email), http.StatusForbidden)
+		return
+	}
+	log.Infof("%s signed in as %s", u.ID, email)
+
+	h.setCookie(w, sessionCookie, h.session(u.ID, time.Now().Add(sessionLength)), sessionLength)
+	http.Redirect(w, r, state.Next, http.StatusFound)
+}
+
+// exchange trades the code the provider returned for an ID token, returning the verified email address
+// it was issued for
+func (p *oidcProvider) exchange(code, nonce string) (string, error) {
+	if code == "" {
+		return "", errors.New("no code was returned")
+	}
+	resp, err := http.PostForm(p.tokenURL, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	token := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return p.verify(token.IDToken, nonce, time.Now())
+}
+
+// verify checks that an ID token was signed by the provider for this client and nonce and hasn't
+// expired, returning the email address it was issued for if the provider verified it
+func (p *oidcProvider) verify(raw, nonce string, now time.Time) (string, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return "", errors.New("the ID token is malformed")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "RS256" {
+		return "", fmt.Errorf("the ID token is signed with %s, not RS256", header.Alg)
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return "", fmt.Errorf("the ID token's signature is invalid: %v", err)
+	}
+
+	claims := struct {
+		Issuer   string          `json:"iss"`
+		Audience json.RawMessage `json:"aud"`
+		Expires  int64           `json:"exp"`
+		Nonce    string          `json:"nonce"`
+		Email    string          `json:"email"`
+		Verified interface{}     `json:"email_verified"`
+	}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	audience := []string{}
+	if err := json.Unmarshal(claims.Audience, &audience); err != nil {
+		audience = []string{""}
+		json.Unmarshal(claims.Audience, &audience[0])
+	}
+	switch {
+	case claims.Issuer != p.issuer:
+		return "", fmt.Errorf("the ID token was issued by %s", claims.Issuer)
+	case !util.InSlice(audience, p.clientID):
+		return "", errors.New("the ID token was issued for another client")
+	case !now.Before(time.Unix(claims.Expires, 0)):
+		return "", errors.New("the ID token has expired")
+	case claims.Nonce != nonce:
+		return "", errors.New("the ID token is for another sign in")
+	case claims.Email == "":
+		return "", errors.New("the ID token has no email address")
+	case claims.Verified != true && claims.Verified != "true":
+		// some providers send the claim as a string, and tokens without it can't be trusted
+		return "", fmt.Errorf("%s isn't verified", claims.Email)
+	}
+	return claims.Email, nil
+}
+
+// key returns the provider's signing key with an ID, fetching its keys again if it isn't known, as
+// providers rotate them
+func (p *oidcProvider) key(id string) (*rsa.PublicKey, error) {
+	p.keysLock.Lock()
+	defer p.keysLock.Unlock()
+
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+	if err := getJSON(p.jwksURL, &jwks); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys = keys
+	key, ok := keys[id]
+	if !ok {
+		return nil, fmt.Errorf("the provider has no signing key %s", id)
+	}
+	return key, nil
+}
+
+// session returns the value of a session cookie for a user, signed so that nobody can sign in as
+// someone else
+func (h *Handler) session(uid string, expires time.Time) string {
+	value := uid + "." + strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(h.oidc.clientSecret))
+	mac.Write([]byte("session:" + value))
+	return value + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sessionUser returns the Slack user a request is signed in as, if any
+func (h *Handler) sessionUser(r *http.Request) (string, bool) {
+	if h.oidc == nil {
+		return "", false
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	fields := strings.Split(c.Value, ".")
+	if len(fields) != 3 {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(unix, 0)) {
+		return "", false
+	}
+	return fields[0], hmac.Equal([]byte(c.Value), []byte(h.session(fields[0], time.Unix(unix, 0))))
+}
+
+// setCookie sets a cookie for the bot's pages, or removes it if maxAge is negative. Cookies are only sent
+// over HTTPS if the bot is served over it.
+func (h *Handler) setCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.oidc.redirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// myReservationsPage lists what the signed in user holds and waits for
+var myReservationsPage = template.Must(template.New("me").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Your reservations</title></head>
+<body style="font-family: sans-serif">
+<h1>Your reservations</h1>
+<p>{{.Summary}}</p>
+{{if .Holding}}<h2>Holding</h2><table>
+<tr><th align="left">Resource</th><th align="left">Waiting</th><th align="left">Expires</th></tr>
+{{range .Holding}}<tr><td><code>{{.Resource}}</code></td><td>{{.Waiting}}</td><td>{{if .Expires}}{{.Expires.Format "Jan 2 15:04 MST"}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Waiting}}<h2>Waiting</h2><table>
+<tr><th align="left">Resource</th><th align="left">Position</th><th align="left">Held by</th></tr>
+{{range .Waiting}}<tr><td><code>{{.Resource}}</code></td><td>{{.Position}}</td><td>{{.Holder}}</td></tr>
+{{end}}</table>{{end}}
+<p><a href="/auth/logout">Sign out</a></p>
+</body></html>
+`))
+
+// MyReservations returns an HTTP handler showing the signed in user what they hold and wait for, the same
+// as `my status` in Slack. Users who aren't signed in are sent to sign in first. It is served as JSON to
+// requests that accept it.
+func (h *Handler) MyReservations() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, ok := h.sessionUser(r)
+		if !ok {
+			http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		status := h.ideStatus(uid)
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			writeJSON(w, status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := myReservationsPage.Execute(w, status); err != nil {
+			log.Errorf("%+v", err)
+		}
+	})
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// decodeSegment decodes a segment of a JSON web token
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://id.example.com"
+	testClientID = "reservebot"
+	testNonce    = "nonce"
+)
+
+// newTestProvider returns a provider that trusts a generated signing key with the ID "k1", which is
+// returned to sign tokens with
+func newTestProvider(t *testing.T) (*oidcProvider, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &oidcProvider{
+		issuer:       testIssuer,
+		clientID:     testClientID,
+		clientSecret: "secret",
+		keys:         map[string]*rsa.PublicKey{"k1": &key.PublicKey},
+	}
+	return p, key
+}
+
+// signToken returns an ID token with the claims, signed with RS256 unless the header says otherwise
+func signToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	h := map[string]interface{}{"alg": "RS256", "kid": "k1"}
+	for k, v := range header {
+		h[k] = v
+	}
+	seg := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := seg(h) + "." + seg(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// validClaims returns the claims of a token the test provider accepts, with changes
+func validClaims(now time.Time, changes map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"iss":            testIssuer,
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          testNonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	return c
+}
+
+func TestVerify(t *testing.T) {
+	p, key := newTestProvider(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		token  string
+		nonce  string
+		accept bool
+	}{
+		{name: "valid", token: signToken(t, key, nil, validClaims(now, nil)), accept: true},
+		{name: "audience list", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"aud": []string{"other", testClientID}})), accept: true},
+		{name: "verified as a string", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"email_verified": "true"})), accept: true},
+		{name: "HS256", token: signToken(t, key, map[string]interface{}{"alg": "HS256"}, validClaims(now, nil))},
+		{name: "no algorithm", token: signToken(t, key, map[string]interface{}{"alg": "none"}, validClaims(now, nil))},
+		{name: "unknown key", token: signToken(t, key, map[string]interface{}{"kid": "k2"}, validClaims(now, nil))},
+		{name: "signed by another key", token: signToken(t, other, nil, validClaims(now, nil))},
+		{name: "other issuer", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"iss": "https://evil.example.com"}))},
+		{name: "other audience", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"aud": "other"}))},
+		{name: "other audiences", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"aud": []string{"other"}}))},
+		{name: "other nonce", token: signToken(t, key, nil, validClaims(now, nil)), nonce: "another"},
+		{name: "expired", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"exp": now.Add(-time.Second).Unix()}))},
+		{name: "expiring now", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"exp": now.Unix()}))},
+		{name: "no expiry", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"exp": nil}))},
+		{name: "no email", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"email": nil}))},
+		{name: "unverified", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"email_verified": false}))},
+		{name: "unverified as a string", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"email_verified": "false"}))},
+		{name: "verification missing", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"email_verified": nil}))},
+		{name: "verified as a number", token: signToken(t, key, nil, validClaims(now, map[string]interface{}{"email_verified": 1}))},
+		{name: "malformed", token: "not.a-token"},
+	}
+
+	for _, tt := range tests {
+		nonce := tt.nonce
+		if nonce == "" {
+			nonce = testNonce
+		}
+		email, err := p.verify(tt.token, nonce, now)
+		if tt.accept && (err != nil || email != "alice@example.com") {
+			t.Errorf("%s: verify returned %q, %v, expected alice@example.com", tt.name, email, err)
+		}
+		if !tt.accept && err == nil {
+			t.Errorf("%s: verify returned %q, expected an error", tt.name, email)
+		}
+	}
+}
+
+func TestVerifyTamperedClaims(t *testing.T) {
+	p, key := newTestProvider(t)
+	now := time.Now()
+	token := signToken(t, key, nil, validClaims(now, map[string]interface{}{"email": "mallory@example.com", "email_verified": false}))
+
+	// swap in claims saying the email is verified, keeping the signature
+	parts := strings.Split(token, ".")
+	b, _ := json.Marshal(validClaims(now, map[string]interface{}{"email": "mallory@example.com"}))
+	parts[1] = base64.RawURLEncoding.EncodeToString(b)
+	if email, err := p.verify(strings.Join(parts, "."), testNonce, now); err == nil {
+		t.Errorf("verify returned %q for a token with tampered claims", email)
+	}
+}
+
+// requestWithSession returns a request carrying a session cookie
+func requestWithSession(value string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/me", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+	return r
+}
+
+func TestSessionUser(t *testing.T) {
+	p, _ := newTestProvider(t)
+	h := &Handler{oidc: p}
+	expires := time.Now().Add(time.Hour)
+	valid := h.session("U1", expires)
+
+	if uid, ok := h.sessionUser(requestWithSession(valid)); !ok || uid != "U1" {
+		t.Errorf("sessionUser returned %q, %t for a valid session, expected U1", uid, ok)
+	}
+
+	fields := strings.Split(valid, ".")
+	other := &Handler{oidc: &oidcProvider{clientSecret: "another secret"}}
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "other user", value: "U2." + fields[1] + "." + fields[2]},
+		{name: "extended", value: fields[0] + "." + strconv.FormatInt(expires.Add(48*time.Hour).Unix(), 10) + "." + fields[2]},
+		{name: "tampered signature", value: fields[0] + "." + fields[1] + "." + strings.Repeat("0", len(fields[2]))},
+		{name: "no signature", value: fields[0] + "." + fields[1]},
+		{name: "extra field", value: valid + ".x"},
+		{name: "signed with another secret", value: other.session("U1", expires)},
+		{name: "expired", value: h.session("U1", time.Now().Add(-time.Second))},
+		{name: "empty", value: ""},
+	}
+	for _, tt := range tests {
+		if uid, ok := h.sessionUser(requestWithSession(tt.value)); ok {
+			t.Errorf("%s: sessionUser returned %q for a tampered session", tt.name, uid)
+		}
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/me", nil)
+	if uid, ok := h.sessionUser(r); ok {
+		t.Errorf("sessionUser returned %q without a session", uid)
+	}
+	if uid, ok := (&Handler{}).sessionUser(requestWithSession(valid)); ok {
+		t.Errorf("sessionUser returned %q with sign in disabled", uid)
+	}
+}
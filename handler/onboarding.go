@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const setUpChannelAction = "set_up_channel"
+
+// SetChannelResources offers every channel the bot is invited to a set of resources, such as db and api,
+// created in an env named after the channel
+func (h *Handler) SetChannelResources(names []string) {
+	ret := []string{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			ret = append(ret, name)
+		}
+	}
+	h.channelResources = ret
+}
+
+// joinedChannel welcomes a channel the bot was invited to with how to use it, then offers to set up the
+// channel's resources, if there are any to offer. Anyone in the channel can accept.
+func (h *Handler) joinedChannel(ev *slackevents.MemberJoinedChannelEvent) error {
+	if h.botID == "" || ev.User != h.botID {
+		return nil
+	}
+	log.Infof("Invited to %s by %s", ev.Channel, ev.Inviter)
+	if _, _, err := h.client.PostMessage(ev.Channel, slack.MsgOptionText(fmt.Sprintf(msgWelcomeX, h.botID), false)); err != nil {
+		return err
+	}
+	if len(h.channelResources) == 0 {
+		return nil
+	}
+
+	ch, err := h.client.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: ev.Channel})
+	if err != nil {
+		log.Errorf("%+v", err)
+		return nil
+	}
+	resources := []string{}
+	for _, name := range h.channelResources {
+		resources = append(resources, ch.Name+"|"+name)
+	}
+	text := "create " + strings.Join(resources, ", ")
+
+	msg := fmt.Sprintf(msgSetUpChannelX, "`"+strings.Join(resources, "`, `")+"`")
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, msg, false, false), nil, nil),
+		slack.NewActionBlock("", slack.NewButtonBlockElement(setUpChannelAction, text, slack.NewTextBlockObject(slack.PlainTextType, "Set up", false, false)).WithStyle(slack.StylePrimary)),
+	}
+	_, _, err = h.client.PostMessage(ev.Channel, slack.MsgOptionText(msg, false), slack.MsgOptionBlocks(blocks...))
+	return err
+}
+
+// setUpChannelAction handles a click on the offer to set up a channel's resources by creating them, as
+// if whoever clicked had asked to. They own the resources, and need permission to create them.
+func (h *Handler) setUpChannelAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	if err := h.updateApprovalMessage(cb, fmt.Sprintf(msgRunningX, action.Value)); err != nil {
+		log.Errorf("%+v", err)
+	}
+
+	ea := &EventAction{
+		Event: &slackevents.MessageEvent{
+			User:       cb.User.ID,
+			Channel:    cb.Channel.ID,
+			Text:       action.Value,
+			SourceTeam: cb.Team.ID,
+			// each click has its own timestamp, so a redelivered click isn't handled twice
+			TimeStamp: action.ActionTs,
+		},
+	}
+	return h.handleCommand(ea)
+}
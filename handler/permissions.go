@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/command"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// The permission levels a command can require
+const (
+	// permOpen commands can be run by anyone
+	permOpen = "open"
+	// permOwner commands can be run by admins and by the owner of every resource they name. Commands
+	// that don't name resources are admin-only.
+	permOwner = "owner"
+	// permAdmin commands can only be run by admins
+	permAdmin = "admin"
+)
+
+// defaultPermissions are the levels of commands that aren't open unless configured otherwise, by
+// command name
+var defaultPermissions = map[string]string{
+	"kick":               permAdmin,
+	"clear env":          permAdmin,
+	"nuke":               permAdmin,
+	"broadcast":          permAdmin,
+	"incident":           permAdmin,
+	"snapshot":           permAdmin,
+	"restore":            permAdmin,
+	"prune":              permAdmin,
+	"maintenance":        permAdmin,
+	"mark-fixed":         permOwner,
+	"health":             permOwner,
+	"cancel maintenance": permAdmin,
+	"report capacity":    permAdmin,
+	"export":             permAdmin,
+	"service-account":    permAdmin,
+	"api-key":            permAdmin,
+	"forget":             permAdmin,
+	"import resources":   permAdmin,
+	"admin usage":        permAdmin,
+}
+
+// LoadPermissions configures which commands are open, owner-only or admin-only from a JSON file mapping
+// command names to levels, e.g. `{"remove resource": "admin", "prune --dry-run": "open"}`. A command
+// name may be followed by one of its flags to set the level of running it with that flag. Commands that
+// aren't listed keep their default level.
+func (h *Handler) LoadPermissions(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config := map[string]string{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("invalid permissions file %s: %v", path, err)
+	}
+
+	perms := map[string]string{}
+	for name, level := range defaultPermissions {
+		perms[name] = level
+	}
+	for name, level := range config {
+		name = strings.Join(strings.Fields(strings.ToLower(name)), " ")
+		if !command.IsName(name) {
+			return fmt.Errorf("unknown command in permissions file: %s", name)
+		}
+		level = strings.ToLower(level)
+		if level != permOpen && level != permOwner && level != permAdmin {
+			return fmt.Errorf("invalid permission for %s: %s", name, level)
+		}
+		perms[name] = level
+	}
+	h.permissions = perms
+
+	names := []string{}
+	for name, level := range perms {
+		names = append(names, fmt.Sprintf("%s=%s", name, level))
+	}
+	sort.Strings(names)
+	log.Infof("Command permissions: %s", strings.Join(names, ", "))
+
+	return nil
+}
+
+// permission returns the level required to run a command. A level configured for one of the flags it
+// was given takes precedence over the level of the command.
+func (h *Handler) permission(cmd *command.Command) string {
+	perms := h.permissions
+	if perms == nil {
+		perms = defaultPermissions
+	}
+
+	name := command.Name(cmd.Action)
+	flags := []string{}
+	for f := range cmd.Flags {
+		flags = append(flags, f)
+	}
+	sort.Strings(flags)
+	for _, f := range flags {
+		if level, ok := perms[name+" --"+f]; ok {
+			return level
+		}
+	}
+	if level, ok := perms[name]; ok {
+		return level
+	}
+	return permOpen
+}
+
+// mayRun returns if a user could run a command with an action on at least some resources, for deciding
+// which commands to tell them about
+func (h *Handler) mayRun(u *models.User, action string) bool {
+	if !observerMay(action) && h.isObserver(u) {
+		return false
+	}
+	return h.permission(&command.Command{Action: action}) != permAdmin || h.HasAdminAccess(u)
+}
+
+// authorize returns if the user who sent a command may run it, replying with why not if they can't.
+// Every command is authorized here before it is run, including in read-only mode. Observers may only run
+// the commands that read.
+func (h *Handler) authorize(ea *EventAction) bool {
+	if !h.allowedReadOnly(ea) || !h.allowedObserver(ea) {
+		return false
+	}
+
+	level := h.permission(ea.Command)
+	if level == permOpen {
+		return true
+	}
+
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return false
+	}
+	if h.HasAdminAccess(u) {
+		return true
+	}
+
+	name := command.Name(ea.Command.Action)
+	if level == permOwner {
+		if resources := h.commandResources(ea.Command, h.defaultEnv(ea.Event.User)); len(resources) > 0 {
+			owned := true
+			for _, res := range resources {
+				if r := h.data.GetResource(res.Name, res.Env, false); r == nil || r.Owner != u.ID {
+					owned = false
+				}
+			}
+			if owned {
+				return true
+			}
+			ea.failed = true
+			h.reply(ea, fmt.Sprintf(msgOnlyOwnersCanRunX, name), true)
+			return false
+		}
+	}
+
+	ea.failed = true
+	h.reply(ea, fmt.Sprintf(msgNotAuthorizedToRunX, name), false)
+	return false
+}
+
+// commandResources returns the resources a command names, either as its resource list or as its first
+// argument, with those without an env in the default env. Nil is returned if it names none.
+func (h *Handler) commandResources(cmd *command.Command, defaultEnv string) []*models.Resource {
+	list := cmd.Resources
+	if len(list) == 0 && len(cmd.Args) > 0 {
+		list = []string{strings.Trim(cmd.Args[0], "`")}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	resources, err := h.getResourcesFromList(list, defaultEnv)
+	if err != nil {
+		return nil
+	}
+	return resources
+}
+
+func setOwner(r *models.Resource, value string, mentions []string, allowed bool) (string, error) {
+	if !allowed {
+		return "", errors.New(msgOnlyOwnersCanChangeOwner)
+	}
+	if value == "none" {
+		r.Owner = ""
+		return "none", nil
+	}
+	if len(mentions) != 1 {
+		return "", errors.New(msgInvalidOwner)
+	}
+	r.Owner = mentions[0]
+	return fmt.Sprintf("<@%s>", r.Owner), nil
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/policy"
+	log "github.com/sirupsen/logrus"
+)
+
+func setPolicy(r *models.Resource, value string, allowed bool) (string, error) {
+	if !allowed {
+		return "", errors.New(msgOnlyOwnersCanChangePolicy)
+	}
+	value = strings.ToLower(value)
+	if !policy.Valid(value) {
+		return "", fmt.Errorf(msgInvalidPolicy, strings.Join(policy.Names, ", "))
+	}
+	r.Policy = value
+	if value == policy.FIFO {
+		r.Policy = ""
+	}
+	return value, nil
+}
+
+// parsePriority parses the value of the --priority flag, where higher goes first
+func parsePriority(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	p, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New(msgInvalidPriority)
+	}
+	return p, nil
+}
+
+// setReservationPriority applies the requested priority to a user's reservation
+func (h *Handler) setReservationPriority(u *models.User, res *models.Resource, priority int) {
+	err := h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+		r.Priority = priority
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+func (h *Handler) policyFor(r *models.Resource) policy.Policy {
+	return policy.For(r, h.usage)
+}
+
+// advance applies a resource's policy when its queue advances. The data layer always hands the resource
+// to whoever is first in line, so if the policy chooses someone else, they are promoted in front. It
+// returns if it promoted someone, in which case the new holder is notified about the promotion.
+func (h *Handler) advance(ev events.Event) bool {
+	q, err := h.data.GetQueueForResource(ev.Resource.Name, ev.Resource.Env)
+	if err != nil || !q.HasReservations() {
+		return false
+	}
+	// a holder whose turn is up is still in line, but doesn't get it straight back
+	line := []*models.Reservation{}
+	for _, res := range q.Reservations {
+		if ev.Previous == nil || res.User.ID != ev.Previous.User.ID {
+			line = append(line, res)
+		}
+	}
+	next := h.policyFor(q.Resource).Next(q.Resource, line)
+	if next == nil || next.User.ID == q.Reservations[0].User.ID {
+		return false
+	}
+
+	// the promotion is an advance from whoever held the resource before, not from the user it was
+	// handed to in the meantime
+	h.advancing.Store(ev.Resource.Key(), ev.Previous)
+	defer h.advancing.Delete(ev.Resource.Key())
+
+	if err := h.data.Promote(next.User, ev.Resource.Name, ev.Resource.Env); err != nil {
+		log.Errorf("%+v", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/util"
+)
+
+// positions remembers where everyone was in line for each resource, so that waiters can be told when
+// their place changes
+type positions struct {
+	lock sync.Mutex
+	// queues maps each resource's key to the one-based position of each user in line for it, by ID
+	queues map[string]map[string]int
+}
+
+// SetPositionUpdates tells waiters whenever their place in line changes, not just when they get the
+// resource. Positions are taken from the queues as they are now, and are only kept in memory, so
+// nobody is told about changes made while the bot was down.
+func (h *Handler) SetPositionUpdates() {
+	p := &positions{
+		queues: map[string]map[string]int{},
+	}
+	for _, q := range h.data.GetQueues() {
+		line := map[string]int{}
+		for i, res := range q.Reservations {
+			line[res.User.ID] = i + 1
+		}
+		p.queues[q.Resource.Key()] = line
+	}
+	h.positions = p
+}
+
+// notifyPositions tells everyone waiting for a resource whose place in line changed what it is now.
+// The new holder is told by HandleEvent and whoever made the change knows about it, so neither is told
+// here. Nobody is told while the resource is drawn for, as places don't count then.
+func (h *Handler) notifyPositions(ev events.Event) {
+	if h.positions == nil || ev.Resource == nil {
+		return
+	}
+	key := ev.Resource.Key()
+	line := map[string]int{}
+	q, err := h.data.GetQueueForResource(ev.Resource.Name, ev.Resource.Env)
+	if err == nil {
+		for i, res := range q.Reservations {
+			line[res.User.ID] = i + 1
+		}
+	}
+
+	h.positions.lock.Lock()
+	before := h.positions.queues[key]
+	if err == nil {
+		h.positions.queues[key] = line
+	} else {
+		delete(h.positions.queues, key)
+	}
+	h.positions.lock.Unlock()
+	if err != nil || q.Resource.Drawing() {
+		return
+	}
+
+	for i, res := range q.Reservations {
+		pos := i + 1
+		was, ok := before[res.User.ID]
+		if pos == 1 || !ok || was == pos || ev.Reservation != nil && ev.Reservation.User.ID == res.User.ID {
+			continue
+		}
+		h.notify(res.User, fmt.Sprintf(msgYouAreNowNInLineForYWasZ, util.Ordinalize(pos), h.resourceText(q.Resource), util.Ordinalize(was)))
+	}
+}
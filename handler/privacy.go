@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+)
+
+func setPrivate(r *models.Resource, value string, allowed bool) (string, error) {
+	switch value {
+	case "on":
+		r.Private = true
+	case "off":
+		if r.Private && !allowed {
+			return "", errors.New(msgOnlyAdminsCanMakePublic)
+		}
+		r.Private = false
+	default:
+		return "", errors.New(msgInvalidPrivate)
+	}
+	return value, nil
+}
+
+// reveals returns if who holds and waits for a queue may be shown to a user. Anyone can see it for
+// resources that aren't private. For private ones, only admins and the people in line can, and only in
+// a DM, since everyone in a channel sees the reply.
+func (h *Handler) reveals(q *models.Queue, u *models.User, im bool) bool {
+	if !q.Resource.Private {
+		return true
+	}
+	return im && u != nil && (inQueue(u, q) || h.HasAdminAccess(u))
+}
+
+// privateText describes the state of a private queue without saying who is in it
+func privateText(q *models.Queue, loc *time.Location) string {
+	text := formatResource(q.Resource)
+	waiting := len(q.Reservations) - 1
+
+	switch {
+	case q.Resource.Drawing():
+		at := q.Resource.DrawAt.In(loc).Format(drawTimeFormat)
+		return fmt.Sprintf("%s goes to a draw at %s, %d entered.", text, at, len(q.Reservations))
+	case q.Resetting():
+		return fmt.Sprintf("%s is resetting…, %d waiting.", text, waiting)
+	case waiting < 0:
+		return fmt.Sprintf("%s is free", text)
+	}
+
+	msg := fmt.Sprintf("%s is reserved", text)
+	if q.Resource.Closed(time.Now()) {
+		msg = fmt.Sprintf("%s will be held when it opens", text)
+	}
+	if waiting > 0 {
+		msg += fmt.Sprintf(", %d waiting", waiting)
+	}
+	return msg + "."
+}
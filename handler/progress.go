@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// progressMaxLength is the most characters a progress note may have
+const progressMaxLength = 200
+
+// progress lets the holder of a resource post a note on how their work with it is going, such as "tests
+// running, 10 more minutes", so that those waiting don't have to ask if they are done yet. The last note
+// is shown in status and live status, to those waiting when they check where they are in line, and to
+// watchers. `clear` removes it, and it goes when the hold ends.
+func (h *Handler) progress(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	res, err := h.parseResource(strings.Trim(ea.Command.Args[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return nil
+	}
+	note := strings.Trim(strings.TrimSpace(ea.Command.Rest(1)), "`*_~")
+	if strings.EqualFold(note, "clear") {
+		note = ""
+	}
+	if utf8.RuneCountInString(note) > progressMaxLength {
+		h.errorReply(ea, fmt.Sprintf(msgProgressNotesAreUpToN, progressMaxLength))
+		return nil
+	}
+
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+	if !q.HasReservations() || q.Resource.Drawing() || q.Resetting() || q.Reservations[0].User.ID != u.ID {
+		h.errorReply(ea, fmt.Sprintf(msgYouDontHoldY, h.resourceText(res)))
+		return nil
+	}
+	err = h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+		r.Progress = note
+		r.ProgressAt = time.Now()
+		if note == "" {
+			r.ProgressAt = time.Time{}
+		}
+		return nil
+	})
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+
+	if note == "" {
+		log.Infof("%s cleared their progress on %s", u.Name, res)
+		return h.reply(ea, fmt.Sprintf(msgClearedYourProgressOnX, h.resourceText(q.Resource)), true)
+	}
+	log.Infof("%s posted progress on %s: %s", u.Name, res, note)
+	return h.reply(ea, fmt.Sprintf(msgPostedYourProgressOnX, h.resourceText(q.Resource)), true)
+}
+
+// progressNote renders the last progress note of a reservation with how long ago it was posted. An empty
+// string is returned if there is none.
+func progressNote(res *models.Reservation, now time.Time) string {
+	if res == nil || res.Progress == "" {
+		return ""
+	}
+	ago := "just now"
+	if d := now.Sub(res.ProgressAt); d >= time.Minute {
+		ago = durationText(d) + " ago"
+	}
+	return fmt.Sprintf("_%s_ (%s)", res.Progress, ago)
+}
+
+// progressText returns the last progress note of the holder of a resource, for status. An empty string is
+// returned if there is none.
+func progressText(q *models.Queue) string {
+	if !q.HasReservations() || q.Resource.Drawing() || q.Resetting() {
+		return ""
+	}
+	if note := progressNote(q.Reservations[0], time.Now()); note != "" {
+		return fmt.Sprintf(" :memo: %s.", note)
+	}
+	return ""
+}
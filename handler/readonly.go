@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/ameliagapin/reservebot/command"
+	log "github.com/sirupsen/logrus"
+)
+
+// queries are the actions of the commands that only read, which are still answered in read-only mode
+var queries = map[string]bool{
+	"hello":          true,
+	"all_status":     true,
+	"my_status":      true,
+	"single_status":  true,
+	"help":           true,
+	"usagereport":    true,
+	"stats":          true,
+	"capacityreport": true,
+	"export":         true,
+}
+
+// SetReadOnly puts the handler in read-only mode, for trying a new instance against production data.
+// Status questions are still answered, but other commands are only logged, and nobody is notified.
+// The data should be wrapped with data.ReadOnly so that background jobs don't change it either.
+func (h *Handler) SetReadOnly() {
+	h.readOnly = true
+}
+
+// allowedReadOnly returns if a command may run in read-only mode, logging what would have been run and
+// replying if it may not
+func (h *Handler) allowedReadOnly(ea *EventAction) bool {
+	if !h.readOnly || queries[ea.Command.Action] {
+		return true
+	}
+
+	args := ea.Command.Resources
+	if len(args) == 0 {
+		args = ea.Command.Args
+	}
+	log.Infof("Read-only: would run `%s` for %s", strings.TrimSpace(command.Name(ea.Command.Action)+" "+strings.Join(args, ", ")), ea.Event.User)
+	ea.failed = true
+	h.reply(ea, msgReadOnly, false)
+	return false
+}
@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/api"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// resetTimeout is how long a reset may take before it is considered failed
+const resetTimeout = 30 * time.Minute
+
+var resetClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// errNoPendingReset is returned when a reset is finished that isn't pending
+var errNoPendingReset = errors.New("no pending reset")
+
+func setReset(r *models.Resource, value string, allowed bool) (string, error) {
+	if !allowed {
+		return "", errors.New(msgOnlyOwnersCanChangeReset)
+	}
+	if value == "none" {
+		r.Reset = nil
+		return "none", nil
+	}
+	// Slack wraps links in angle brackets, optionally followed by the text shown for them
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+	if i := strings.Index(value, "|"); i >= 0 {
+		value = value[:i]
+	}
+	if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		return "", errors.New(msgInvalidURL)
+	}
+	if r.Reset == nil {
+		r.Reset = &models.ResetHook{}
+	}
+	r.Reset.URL = value
+	return value, nil
+}
+
+// startReset starts resetting a resource its holder released, if it has a reset hook, and returns if it
+// did. The holder keeps the resource until the reset is done. Whether it has a hook is read from the
+// resource as it is now, as the reservation's copy may predate the hook being set.
+func (h *Handler) startReset(res *models.Reservation) (bool, error) {
+	if current := h.data.GetResource(res.Resource.Name, res.Resource.Env, false); current == nil || current.Reset == nil {
+		return false, nil
+	}
+	started := false
+	r, err := h.updateResource(res.Resource.Name, res.Resource.Env, func(r *models.Resource) error {
+		started = false
+		if r.Reset == nil || r.Reset.Pending() && r.Reset.Reservation == res.ID {
+			return nil
+		}
+		r.Reset.Started = time.Now()
+		r.Reset.Reservation = res.ID
+		started = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if !started {
+		return r.Reset != nil, nil
+	}
+	log.Infof("%s released %s, resetting it", res.User.Name, r)
+
+	if h.sandbox {
+		// trying a command out never calls out, so the reset stays pending
+		return true, nil
+	}
+	go h.callResetHook(r, res)
+	return true, nil
+}
+
+// callResetHook posts to a resource's reset hook. The reset is done once the hook answers, unless it
+// answers 202 Accepted, in which case it reports the outcome to the reset webhook.
+func (h *Handler) callResetHook(r *models.Resource, res *models.Reservation) {
+	body, err := json.Marshal(&api.ResetHookRequest{
+		Resource:      r.String(),
+		ReservationID: res.ID,
+		User:          res.User.ID,
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+
+	status := api.ResetStatusSuccess
+	resp, err := resetClient.Post(r.Reset.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Error calling the reset hook of %s: %+v", r, err)
+		status = "the hook couldn't be reached"
+	} else {
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusAccepted:
+			log.Infof("Reset of %s accepted, waiting for its outcome", r)
+			return
+		case resp.StatusCode < 200 || resp.StatusCode >= 300:
+			status = fmt.Sprintf("the hook answered %s", resp.Status)
+		}
+	}
+
+	h.FinishReset(r.Name, r.Env, res.ID, status)
+}
+
+// ResetWebhook returns an HTTP handler that is called when a reset finishes, for reset hooks that report
+// their outcome later. Requests must present the secret in the X-Reservebot-Secret header.
+func (h *Handler) ResetWebhook(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !h.authorizeRequest(w, r, secret, models.ScopeReserve) {
+			return
+		}
+
+		req := &api.ResetRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Resource == "" {
+			http.Error(w, "body must be JSON with a resource", http.StatusBadRequest)
+			return
+		}
+		res, err := h.parseResource(req.Resource, "")
+		if err != nil || res == nil {
+			http.Error(w, "invalid resource", http.StatusBadRequest)
+			return
+		}
+
+		finished := h.FinishReset(res.Name, res.Env, req.ReservationID, req.Status)
+
+		writeJSON(w, &api.ResetResponse{Finished: finished})
+	})
+}
+
+// FinishReset ends the pending reset of a resource and returns if there was one. If the reset succeeded,
+// the user who released the resource leaves the queue and the next person gets it. Otherwise they keep
+// it and are told, so they can release it again to retry. Reporting the same reset again does nothing.
+func (h *Handler) FinishReset(name, env, reservationID, status string) bool {
+	id := ""
+	r, err := h.updateResource(name, env, func(r *models.Resource) error {
+		if !r.Resetting() || reservationID != "" && r.Reset.Reservation != reservationID {
+			return errNoPendingReset
+		}
+		id = r.Reset.Reservation
+		r.Reset.Started = time.Time{}
+		r.Reset.Reservation = ""
+		return nil
+	})
+	if err != nil {
+		if err != errNoPendingReset {
+			log.Errorf("%+v", err)
+		}
+		return false
+	}
+
+	q, err := h.data.GetQueueForResource(name, env)
+	if err != nil || !q.HasReservations() || q.Reservations[0].ID != id {
+		// whoever released it left the queue in the meantime, e.g. because they were kicked
+		return true
+	}
+	holder := q.Reservations[0]
+
+	if status != api.ResetStatusSuccess {
+		log.Infof("Reset of %s failed: %s", r, status)
+		h.notify(holder.User, fmt.Sprintf(msgResetOfYFailedZ, h.resourceText(r), status))
+		return true
+	}
+
+	if err := h.data.Remove(holder.User, name, env); err != nil {
+		log.Errorf("%+v", err)
+		return true
+	}
+	log.Infof("Reset of %s succeeded, released it for %s", r, holder.User.Name)
+
+	// the next user is notified by HandleEvent
+	h.notify(holder.User, fmt.Sprintf(msgYWasResetAndReleased, h.resourceText(r)))
+	return true
+}
+
+// CheckResets fails the resets that have taken too long, so that whoever released the resource can
+// retry
+func (h *Handler) CheckResets() {
+	now := time.Now()
+
+	for _, r := range h.data.GetResources() {
+		if !r.Resetting() || now.Sub(r.Reset.Started) < resetTimeout {
+			continue
+		}
+		h.FinishReset(r.Name, r.Env, r.Reset.Reservation, fmt.Sprintf("it took longer than %s", durationText(resetTimeout)))
+	}
+}
+
+// resetText describes a queue whose resource is being reset
+func (h *Handler) resetText(q *models.Queue, mention bool) string {
+	text := fmt.Sprintf("%s is resetting… after %s released it.", formatResource(q.Resource), h.getUserDisplay(q.Reservations[0].User, mention))
+	switch waiting := len(q.Reservations) - 1; {
+	case waiting == 1:
+		text += fmt.Sprintf(" %s is waiting.", h.getUserDisplayWithDuration(q.Reservations[1], false))
+	case waiting > 1:
+		queue := []string{}
+		for _, next := range q.Reservations[1:] {
+			queue = append(queue, h.getUserDisplayWithDuration(next, false))
+		}
+		text += fmt.Sprintf(" %s are waiting.", strings.Join(queue, ", "))
+	}
+	return text
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	approveResourceAction = "resource_request_approve"
+	denyResourceAction    = "resource_request_deny"
+)
+
+// requestResource asks for a new resource to be created, for people who can't create resources
+// themselves. The owners of the env's resources and the members of the admin groups are sent a DM with
+// buttons to approve or deny it, or, if there is no one to DM, the buttons are posted where it was asked.
+// Everything needed to create the resource is kept in the buttons, so the request survives restarts.
+func (h *Handler) requestResource(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	name, env := strings.Trim(ea.Command.Args[0], "`"), strings.Trim(ea.Command.Args[1], "`")
+	reason := strings.TrimSpace(ea.Command.Rest(2))
+	if strings.Contains(name, "|") || strings.Contains(env, "|") {
+		h.errorReply(ea, msgResourceImproperlyFormatted)
+		return nil
+	}
+	res := &models.Resource{Name: name, Env: env}
+	if h.data.GetResource(name, env, false) != nil {
+		h.errorReply(ea, fmt.Sprintf(msgXAlreadyExists, h.resourceText(res)))
+		return nil
+	}
+	if msg := h.misnamed(res); msg != "" {
+		h.errorReply(ea, msg)
+		return nil
+	}
+
+	text := fmt.Sprintf(msgXRequestsNewYBecauseZ, h.getUserDisplay(u, false), h.resourceText(res), reason)
+	value := strings.TrimSpace(fmt.Sprintf("%s %s %s", u.ID, res, workspace(ea)))
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(approveResourceAction, value, slack.NewTextBlockObject(slack.PlainTextType, "Create", false, false)).WithStyle(slack.StylePrimary),
+			slack.NewButtonBlockElement(denyResourceAction, value, slack.NewTextBlockObject(slack.PlainTextType, "Deny", false, false)).WithStyle(slack.StyleDanger),
+		),
+	}
+
+	approvers := h.resourceApprovers(env, u.ID)
+	if len(approvers) == 0 {
+		if err := h.post(ea, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(blocks...)); err != nil {
+			log.Errorf("%+v", err)
+			h.errorReply(ea, "")
+			return err
+		}
+		log.Infof("%s requested %s", u.Name, res)
+		return nil
+	}
+
+	asked := []string{}
+	for _, id := range approvers {
+		if err := h.sendDMBlocks(&models.User{ID: id}, text, blocks...); err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		asked = append(asked, fmt.Sprintf("<@%s>", id))
+	}
+	if len(asked) == 0 {
+		h.errorReply(ea, msgIDontKnow)
+		return nil
+	}
+	log.Infof("%s requested %s", u.Name, res)
+
+	return h.reply(ea, fmt.Sprintf(msgYourRequestForXWasSentToY, h.resourceText(res), strings.Join(asked, ", ")), true)
+}
+
+// resourceApprovers returns who may approve a new resource in an env, other than whoever asked for it:
+// the owners of the env's resources and the members of the admin groups. Admins configured by name
+// can't be sent a DM, since only their names are known.
+func (h *Handler) resourceApprovers(env, requester string) []string {
+	ids := map[string]bool{}
+	for _, r := range h.data.GetResourcesForEnv(env) {
+		if r.Owner != "" {
+			ids[r.Owner] = true
+		}
+	}
+	h.adminGroups.lock.RLock()
+	for id := range h.adminGroups.members {
+		ids[id] = true
+	}
+	h.adminGroups.lock.RUnlock()
+	delete(ids, requester)
+
+	ret := []string{}
+	for id := range ids {
+		ret = append(ret, id)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// mayApproveResource returns if a user may approve a new resource in an env, as an admin or as the owner
+// of one of the env's resources
+func (h *Handler) mayApproveResource(u *models.User, env string) bool {
+	if h.HasAdminAccess(u) {
+		return true
+	}
+	for _, r := range h.data.GetResourcesForEnv(env) {
+		if r.Owner == u.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceRequestAction handles a click on a resource request's buttons. Approving creates the resource,
+// owned by whoever asked for it. Whoever decides first wins: once the resource exists, the request is
+// treated as handled.
+func (h *Handler) resourceRequestAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	if h.readOnly {
+		log.Infof("Read-only: would %s resource request %s for %s", strings.TrimPrefix(action.ActionID, "resource_request_"), action.Value, cb.User.ID)
+		return nil
+	}
+	fields := strings.Fields(action.Value)
+	if len(fields) < 2 {
+		return nil
+	}
+	res, err := h.parseResource(fields[1], "")
+	if err != nil || res == nil {
+		return err
+	}
+	approver, err := h.getUser(cb.User.ID)
+	if err != nil {
+		return err
+	}
+	if approver.ID == fields[0] || !h.mayApproveResource(approver, res.Env) {
+		// the buttons may have been posted in a channel, so they are left for someone who may decide
+		h.notify(approver, msgYouCannotCreateThis)
+		return nil
+	}
+	if h.data.GetResource(res.Name, res.Env, false) != nil {
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgXWasAlreadyCreated, h.resourceText(res)))
+	}
+	requester, err := h.getUser(fields[0])
+	if err != nil {
+		return err
+	}
+
+	if action.ActionID == denyResourceAction {
+		log.Infof("%s denied %s's request for new resource %s", approver.Name, requester.Name, res)
+		h.notify(requester, fmt.Sprintf(msgXDeniedYourRequestForNewY, h.getUserDisplay(approver, false), h.resourceText(res)))
+		return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouDeniedXRequestForNewY, h.getUserDisplay(requester, false), h.resourceText(res)))
+	}
+
+	if err := h.data.Create(res.Name, res.Env); err != nil {
+		return err
+	}
+	r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		r.Owner = requester.ID
+		if h.isolateWorkspaces && len(fields) > 2 {
+			r.Workspace = fields[2]
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+		r = res
+	}
+	log.Infof("%s approved %s's request for new resource %s", approver.Name, requester.Name, r)
+
+	h.notify(requester, fmt.Sprintf(msgXCreatedYYouRequested, h.getUserDisplay(approver, false), h.resourceText(r)))
+	return h.updateApprovalMessage(cb, fmt.Sprintf(msgYouCreatedYForX, h.resourceText(r), h.getUserDisplay(requester, false)))
+}
+
+// isResourceRequestAction returns if a block action belongs to a resource request
+func isResourceRequestAction(action *slack.BlockAction) bool {
+	return action.ActionID == approveResourceAction || action.ActionID == denyResourceAction
+}
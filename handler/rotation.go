@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// rotationWarning is how long a holder is warned before their turn ends
+const rotationWarning = 10 * time.Minute
+
+func setRotation(r *models.Resource, value string, allowed bool) (string, error) {
+	if !allowed {
+		return "", errors.New(msgOnlyOwnersCanChangeRotation)
+	}
+	if value == "none" {
+		r.Rotation = 0
+		return "none", nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return "", errors.New(msgInvalidDuration)
+	}
+	r.Rotation = d
+	return durationText(d), nil
+}
+
+// RotateReservations enforces the turns of resources whose policy limits them. Once a holder has had a
+// resource for a turn while others are waiting, they are moved to the back of the queue and the policy
+// chooses who gets it next. Holders are warned beforehand, and always get at least the warning period
+// after being warned. CI jobs and Terraform can't be warned and would fail if interrupted, so they are
+// never rotated, and neither are incident commanders.
+func (h *Handler) RotateReservations() {
+	now := time.Now()
+
+	for _, q := range h.data.GetQueues() {
+		r := q.Resource
+		turn := h.policyFor(r).Turn(r)
+		if turn <= 0 || len(q.Reservations) < 2 || r.Closed(now) || r.Drawing() || q.Resetting() {
+			continue
+		}
+		holder := q.Reservations[0]
+		if holder.User.External || holder.Incident != "" {
+			continue
+		}
+
+		due := holder.Time.Add(turn)
+		// a warning from an earlier turn doesn't count
+		warned := holder.RotationWarned.After(holder.Time)
+
+		if !warned {
+			if now.Before(due.Add(-rotationWarning)) {
+				continue
+			}
+			err := h.updateReservation(holder.User, r.Name, r.Env, func(res *models.Reservation) error {
+				res.RotationWarned = now
+				return nil
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+
+			ends := due
+			if min := now.Add(rotationWarning); ends.Before(min) {
+				ends = min
+			}
+			h.notify(holder.User, fmt.Sprintf(msgYourTurnOnYEndsInZ, h.resourceText(r), durationText(ends.Sub(now))))
+			continue
+		}
+
+		if now.Before(due) || now.Before(holder.RotationWarned.Add(rotationWarning)) {
+			continue
+		}
+		if err := h.data.Requeue(holder.User, r.Name, r.Env); err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		if holder.Label != "" || holder.Progress != "" {
+			// the label and progress note were for their turn, and would be shown again when they get the
+			// resource back
+			err := h.updateReservation(holder.User, r.Name, r.Env, func(res *models.Reservation) error {
+				res.Label = ""
+				res.Progress = ""
+				res.ProgressAt = time.Time{}
+				return nil
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+		}
+		log.Infof("Rotated %s away from %s", r, holder.User.Name)
+
+		h.notify(holder.User, fmt.Sprintf(msgYourTurnOnYEndedN, h.resourceText(r), util.Ordinalize(len(q.Reservations))))
+	}
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/events"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// tryPrefix starts a command that is only tried out, e.g. `try reserve dev|db`
+const tryPrefix = "try"
+
+// sandboxDMPrefix starts the channel IDs the sandbox gives DMs, followed by the user's ID
+const sandboxDMPrefix = "sandbox-dm:"
+
+// sandboxed returns the command to try out if a message starts with the try prefix
+func sandboxed(text string) (string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 || strings.ToLower(fields[0]) != tryPrefix {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimSpace(text)[len(fields[0]):]), true
+}
+
+// sandboxClient is the Slack client of a sandbox. It looks users and channels up in Slack, but records
+// what would be posted instead of posting it.
+type sandboxClient struct {
+	SlackClient
+
+	lock  sync.Mutex
+	posts []sandboxPost
+}
+
+type sandboxPost struct {
+	channel string
+	text    string
+}
+
+func (c *sandboxClient) record(channelID string, options ...slack.MsgOption) error {
+	_, values, err := slack.UnsafeApplyMsgOptions("", channelID, "", options...)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.posts = append(c.posts, sandboxPost{channel: channelID, text: values.Get("text")})
+	return nil
+}
+
+func (c *sandboxClient) AddPin(channel string, item slack.ItemRef) error {
+	return nil
+}
+
+func (c *sandboxClient) RemovePin(channel string, item slack.ItemRef) error {
+	return nil
+}
+
+func (c *sandboxClient) OpenConversation(params *slack.OpenConversationParameters) (*slack.Channel, bool, bool, error) {
+	ch := &slack.Channel{}
+	ch.ID = sandboxDMPrefix + strings.Join(params.Users, ",")
+	return ch, false, false, nil
+}
+
+func (c *sandboxClient) OpenView(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error) {
+	return &slack.ViewResponse{}, nil
+}
+
+func (c *sandboxClient) PostMessage(channelID string, options ...slack.MsgOption) (string, string, error) {
+	return channelID, "", c.record(channelID, options...)
+}
+
+func (c *sandboxClient) UnfurlMessage(channelID, timestamp string, unfurls map[string]slack.Attachment, options ...slack.MsgOption) (string, string, string, error) {
+	return channelID, timestamp, "", nil
+}
+
+func (c *sandboxClient) UpdateMessage(channelID, timestamp string, options ...slack.MsgOption) (string, string, string, error) {
+	return channelID, timestamp, "", c.record(channelID, options...)
+}
+
+func (c *sandboxClient) UploadFile(params slack.FileUploadParameters) (*slack.File, error) {
+	return &slack.File{}, c.record(strings.Join(params.Channels, ","), slack.MsgOptionText(fmt.Sprintf(msgWouldUploadX, params.Filename), false))
+}
+
+// newSandbox returns a handler with the same configuration that works on a copy of the resources and
+// queues, with the given user's default env and status filter, and only records what it would post to
+// Slack. Reset hooks aren't called, and nothing is recorded in the history or the usage stats.
+func (h *Handler) newSandbox(userID string) (*Handler, *sandboxClient) {
+	client := &sandboxClient{SlackClient: h.client}
+	clone := data.NewMemoryFrom(h.data)
+	if env, err := h.data.GetDefaultEnv(userID); err == nil && env != "" {
+		if err := clone.SetDefaultEnv(userID, env); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+	if filter, err := h.data.GetStatusFilter(userID); err == nil && filter != "" {
+		if err := clone.SetStatusFilter(userID, filter); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+	bus := events.NewBus()
+
+	sb := New(client, events.NewManager(clone, bus), h.tickets, h.reqEnv, h.admins, nil, h.blockUnhealthy)
+	bus.Subscribe(sb.HandleEvent)
+	sb.sandbox = true
+	sb.users = h.users
+	sb.adminGroups = h.adminGroups
+	sb.teams = h.teams
+	sb.permissions = h.permissions
+	sb.deadlineChannel = h.deadlineChannel
+	sb.staleAfter = h.staleAfter
+	sb.staleChannel = h.staleChannel
+	sb.incidentSeverity = h.incidentSeverity
+	sb.isolateWorkspaces = h.isolateWorkspaces
+	sb.naming = h.naming
+	sb.channelResources = h.channelResources
+	sb.botID = h.botID
+	return sb, client
+}
+
+// try runs a command in a sandbox and replies with what would have happened, without changing anything,
+// such as for people learning how the bot works. Replies are shown as they would be, and messages that
+// would be sent to others are listed with who they would go to.
+func (h *Handler) try(ea *EventAction, text string) error {
+	sb, client := h.newSandbox(ea.Event.User)
+	ev := *ea.Event
+	ev.Text = text
+	if err := sb.handleCommand(&EventAction{Event: &ev, Line: ea.Line}); err != nil {
+		log.Debugf("Trying `%s` for %s failed: %+v", text, ea.Event.User, err)
+	}
+	sb.FlushNotifications()
+
+	lines := []string{fmt.Sprintf(msgTryingX, text)}
+	for _, p := range client.posts {
+		switch {
+		case p.channel == ea.Event.Channel:
+			lines = append(lines, quote(p.text))
+		case strings.HasPrefix(p.channel, sandboxDMPrefix):
+			lines = append(lines, fmt.Sprintf(msgWouldDMX, strings.TrimPrefix(p.channel, sandboxDMPrefix)), quote(p.text))
+		default:
+			lines = append(lines, fmt.Sprintf(msgWouldPostInX, p.channel), quote(p.text))
+		}
+	}
+	if len(client.posts) == 0 {
+		lines = append(lines, msgNothingWouldBePosted)
+	}
+	return h.reply(ea, strings.Join(lines, "\n"), false)
+}
+
+// quote formats text as a Slack block quote
+func quote(text string) string {
+	return "> " + strings.Replace(text, "\n", "\n> ", -1)
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// scheduleReserve puts a reservation off until a time, for people who want to join a queue later
+// without being there to do it, such as while they are in meetings. The command is run as they gave it
+// when the time comes, and answered where they gave it. Releasing any of its resources before then
+// cancels it.
+func (h *Handler) scheduleReserve(ea *EventAction, u *models.User, resources []*models.Resource) error {
+	now := time.Now()
+	at, err := parseDeadline(ea.Command.At, now, u.Location())
+	if err != nil {
+		h.errorReply(ea, msgInvalidScheduledTime)
+		return nil
+	}
+
+	a := &models.ScheduledAction{
+		ID:          models.NewID(),
+		UserID:      u.ID,
+		Text:        ea.Event.Text,
+		Channel:     ea.Event.Channel,
+		ChannelType: ea.Event.ChannelType,
+		Thread:      ea.Event.ThreadTimeStamp,
+		Workspace:   workspace(ea),
+		At:          at,
+		Created:     now,
+	}
+	for _, res := range resources {
+		a.Resources = append(a.Resources, res.String())
+	}
+	if err := h.data.SaveScheduledAction(a); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	log.Infof("%s scheduled `%s` for %s", u.Name, a.Text, at)
+
+	return h.reply(ea, fmt.Sprintf(msgIllPutYouInLineForXAtY, h.resourcesText(resources), at.In(u.Location()).Format(hoursTimeFormat)), true)
+}
+
+// cancelScheduled cancels a user's scheduled commands that name a resource, returning whether there were
+// any. The whole command is cancelled, even if it names other resources too.
+func (h *Handler) cancelScheduled(ea *EventAction, u *models.User, res *models.Resource) bool {
+	actions, err := h.data.GetScheduledActions()
+	if err != nil {
+		log.Errorf("%+v", err)
+		return false
+	}
+	cancelled := false
+	for _, a := range actions {
+		if a.UserID != u.ID || !util.InSlice(a.Resources, res.String()) {
+			continue
+		}
+		if err := h.data.DeleteScheduledAction(a.ID); err != nil {
+			if !errors.Is(err, e.ScheduledActionDoesNotExist) {
+				log.Errorf("%+v", err)
+			}
+			continue
+		}
+		log.Infof("%s cancelled their scheduled `%s`", u.Name, a.Text)
+		h.reply(ea, fmt.Sprintf(msgICancelledYourXAtY, h.scheduledResourcesText(a), a.At.In(u.Location()).Format(hoursTimeFormat)), true)
+		cancelled = true
+		ea.applied = true
+	}
+	return cancelled
+}
+
+// scheduledText lists a user's scheduled commands, for their status. An empty string is returned if they
+// have none.
+func (h *Handler) scheduledText(u *models.User) string {
+	actions, err := h.data.GetScheduledActions()
+	if err != nil {
+		log.Errorf("%+v", err)
+		return ""
+	}
+	lines := []string{}
+	for _, a := range actions {
+		if a.UserID == u.ID {
+			lines = append(lines, fmt.Sprintf(msgYouJoinXAtY, h.scheduledResourcesText(a), a.At.In(u.Location()).Format(hoursTimeFormat)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (h *Handler) scheduledResourcesText(a *models.ScheduledAction) string {
+	resources := []*models.Resource{}
+	for _, text := range a.Resources {
+		if res, err := h.parseResource(text, ""); err == nil && res != nil {
+			resources = append(resources, res)
+		}
+	}
+	return h.resourcesText(resources)
+}
+
+// RunScheduledActions runs the commands that are due, as the users who scheduled them. Each command is
+// deleted before it runs, so that it runs once even if several instances of the bot share the data.
+func (h *Handler) RunScheduledActions() {
+	if h.readOnly || h.sandbox {
+		return
+	}
+	actions, err := h.data.GetScheduledActions()
+	if err != nil {
+		log.Errorf("Error getting scheduled commands: %+v", err)
+		return
+	}
+	now := time.Now()
+	for _, a := range actions {
+		if a.At.After(now) {
+			break
+		}
+		if err := h.data.DeleteScheduledAction(a.ID); err != nil {
+			if !errors.Is(err, e.ScheduledActionDoesNotExist) {
+				log.Errorf("%+v", err)
+			}
+			continue
+		}
+		log.Infof("Running %s's scheduled `%s`", a.UserID, a.Text)
+		ea := &EventAction{
+			Event: &slackevents.MessageEvent{
+				User:            a.UserID,
+				Text:            a.Text,
+				Channel:         a.Channel,
+				ChannelType:     a.ChannelType,
+				ThreadTimeStamp: a.Thread,
+				SourceTeam:      a.Workspace,
+			},
+			scheduled: true,
+		}
+		if err := h.handleCommand(ea); err != nil {
+			log.Errorf("Error running %s's scheduled `%s`: %+v", a.UserID, a.Text, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// serviceAccount manages the service accounts that hold reservations through the API, such as deploy
+// pipelines and cron tasks. Tokens are only ever sent to the admin by DM.
+func (h *Handler) serviceAccount(ea *EventAction) error {
+	args := ea.Command.Args
+	sub := strings.ToLower(args[0])
+	if sub == "list" {
+		return h.listServiceAccounts(ea)
+	}
+	if len(args) < 2 {
+		h.errorReply(ea, msgServiceAccountUsage)
+		return nil
+	}
+
+	name := args[1]
+	if !automationIdentity.MatchString(name) {
+		h.errorReply(ea, fmt.Sprintf(msgInvalidServiceAccountX, name))
+		return nil
+	}
+	account, err := h.findServiceAccount(name)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	switch sub {
+	case "create":
+		if account != nil {
+			h.errorReply(ea, fmt.Sprintf(msgServiceAccountXExists, name))
+			return nil
+		}
+		account = &models.ServiceAccount{
+			Name:      name,
+			Created:   time.Now(),
+			CreatedBy: ea.Event.User,
+		}
+		return h.issueServiceToken(ea, account, msgCreatedServiceAccountXY)
+	case "token":
+		if account == nil {
+			h.errorReply(ea, fmt.Sprintf(msgNoServiceAccountX, name))
+			return nil
+		}
+		return h.issueServiceToken(ea, account, msgNewTokenForXY)
+	case "delete":
+		if account == nil {
+			h.errorReply(ea, fmt.Sprintf(msgNoServiceAccountX, name))
+			return nil
+		}
+		return h.deleteServiceAccount(ea, account)
+	}
+	h.errorReply(ea, msgServiceAccountUsage)
+	return nil
+}
+
+// issueServiceToken gives an account a new token, replacing any it had, and DMs it to the admin
+func (h *Handler) issueServiceToken(ea *EventAction, account *models.ServiceAccount, format string) error {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	token := hex.EncodeToString(b)
+	account.TokenHash = tokenHash(token)
+	if err := h.data.SaveServiceAccount(account); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	log.Infof("%s issued a token for service account %s", ea.Event.User, account.Name)
+
+	msg := fmt.Sprintf(format, account.Name, token)
+	if ea.Event.ChannelType == "im" {
+		return h.reply(ea, msg, false)
+	}
+	h.notify(&models.User{ID: ea.Event.User}, msg)
+	return h.reply(ea, fmt.Sprintf(msgServiceTokenForXSentByDM, account.Name), true)
+}
+
+// deleteServiceAccount removes an account from every queue it is in, then deletes it, so that its token
+// stops working
+func (h *Handler) deleteServiceAccount(ea *EventAction, account *models.ServiceAccount) error {
+	u := account.User()
+	left := []string{}
+	for _, q := range h.data.GetQueues() {
+		if _, err := h.data.GetPosition(u, q.Resource.Name, q.Resource.Env); err != nil {
+			continue
+		}
+		if err := h.data.Remove(u, q.Resource.Name, q.Resource.Env); err != nil && !errors.Is(err, e.NotInQueue) {
+			log.Errorf("%+v", err)
+			continue
+		}
+		left = append(left, h.resourceText(q.Resource))
+	}
+	if err := h.data.DeleteServiceAccount(account.Name); err != nil && !errors.Is(err, e.ServiceAccountDoesNotExist) {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	log.Infof("%s deleted service account %s", ea.Event.User, account.Name)
+
+	if len(left) == 0 {
+		return h.reply(ea, fmt.Sprintf(msgDeletedServiceAccountX, account.Name), false)
+	}
+	return h.reply(ea, fmt.Sprintf(msgDeletedServiceAccountXFromY, account.Name, strings.Join(left, ", ")), false)
+}
+
+// listServiceAccounts lists every service account with who registered it and what it is in line for
+func (h *Handler) listServiceAccounts(ea *EventAction) error {
+	accounts, err := h.data.GetServiceAccounts()
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if len(accounts) == 0 {
+		return h.reply(ea, msgNoServiceAccounts, false)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Name < accounts[j].Name
+	})
+
+	lines := []string{}
+	for _, account := range accounts {
+		u := account.User()
+		line := fmt.Sprintf("%s, created by <@%s> on %s", h.getUserDisplay(u, false), account.CreatedBy, account.Created.Format("Jan 2 2006"))
+		holds := []string{}
+		for _, q := range h.data.GetQueues() {
+			if pos, err := h.data.GetPosition(u, q.Resource.Name, q.Resource.Env); err == nil {
+				if pos == 1 {
+					holds = append(holds, fmt.Sprintf("holds %s", h.resourceText(q.Resource)))
+				} else {
+					holds = append(holds, fmt.Sprintf("is #%d for %s", pos, h.resourceText(q.Resource)))
+				}
+			}
+		}
+		if len(holds) > 0 {
+			line += ", " + strings.Join(holds, ", ")
+		}
+		lines = append(lines, line)
+	}
+	return h.reply(ea, strings.Join(lines, "\n"), false)
+}
+
+// findServiceAccount returns the service account with a name, or nil if there isn't one
+func (h *Handler) findServiceAccount(name string) (*models.ServiceAccount, error) {
+	accounts, err := h.data.GetServiceAccounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		if account.Name == name {
+			return account, nil
+		}
+	}
+	return nil, nil
+}
+
+// serviceAccountForToken returns the service account a token belongs to, or nil if it belongs to none
+func (h *Handler) serviceAccountForToken(token string) *models.ServiceAccount {
+	if token == "" {
+		return nil
+	}
+	accounts, err := h.data.GetServiceAccounts()
+	if err != nil {
+		log.Errorf("%+v", err)
+		return nil
+	}
+	hash := []byte(tokenHash(token))
+	for _, account := range accounts {
+		if subtle.ConstantTimeCompare(hash, []byte(account.TokenHash)) == 1 {
+			return account
+		}
+	}
+	return nil
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/command"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// resourceSettings lists the settings that can be changed with the settings command
+var resourceSettings = []string{"approvers", "checklist", "conflicts", "cost", "duration", "emoji", "hours", "incident", "lottery", "owner", "policy", "private", "reset", "rotation", "url"}
+
+func (h *Handler) settings(ea *EventAction) error {
+	ev := ea.Event
+
+	matches := ea.Command.Args
+	res, err := h.parseResource(strings.Trim(matches[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return nil
+	}
+
+	setting := strings.ToLower(matches[1])
+	value := strings.TrimSpace(ea.Command.Rest(2))
+
+	var set func(r *models.Resource, value string) (string, error)
+	switch setting {
+	case "approvers":
+		u, err := h.getUser(ev.User)
+		if err != nil {
+			h.errorReply(ea, "")
+			return err
+		}
+		mentions := ea.Command.Mentions
+		set = func(r *models.Resource, value string) (string, error) {
+			return setApprovers(r, value, mentions, r.IsApprover(u.ID) || h.HasAdminAccess(u))
+		}
+	case "checklist":
+		set = setChecklist
+	case "conflicts":
+		env := h.defaultEnv(ev.User)
+		set = func(r *models.Resource, value string) (string, error) {
+			return h.setConflicts(r, value, env)
+		}
+	case "cost":
+		set = setCost
+	case "duration":
+		set = setDefaultDuration
+	case "emoji":
+		set = setEmoji
+	case "hours":
+		loc := h.userLocation(ev.User)
+		set = func(r *models.Resource, value string) (string, error) {
+			return setHours(r, value, loc)
+		}
+	case "incident":
+		u, err := h.getUser(ev.User)
+		if err != nil {
+			h.errorReply(ea, "")
+			return err
+		}
+		set = func(r *models.Resource, value string) (string, error) {
+			return setIncident(r, value, r.Owner == u.ID || h.HasAdminAccess(u))
+		}
+	case "lottery":
+		u, err := h.getUser(ev.User)
+		if err != nil {
+			h.errorReply(ea, "")
+			return err
+		}
+		set = func(r *models.Resource, value string) (string, error) {
+			return setLottery(r, value, r.Owner == u.ID || h.HasAdminAccess(u))
+		}
+	case "owner":
+		u, err := h.getUser(ev.User)
+		if err != nil {
+			h.errorReply(ea, "")
+			return err
+		}
+		mentions := ea.Command.Mentions
+		set = func(r *models.Resource, value string) (string, error) {
+			return setOwner(r, value, mentions, r.Owner == u.ID || h.HasAdminAccess(u))
+		}
+	case "policy":
+		u, err := h.getUser(ev.User)
+		if err != nil {
+			h.errorReply(ea, "")
+			return err
+		}
+		set = func(r *models.Resource, value string) (string, error) {
+			return setPolicy(r, value, r.Owner == u.ID || h.HasAdminAccess(u))
+		}
+	case "private":
+		u, err := h.getUser(ev.User)
+		if err != nil {
+			h.errorReply(ea, "")
+			return err
+		}
+		allowed := h.HasAdminAccess(u)
+		set = func(r *models.Resource, value string) (string, error) {
+			return setPrivate(r, value, allowed)
+		}
+	case "reset":
+		u, err := h.getUser(ev.User)
+		if err != nil {
+			h.errorReply(ea, "")
+			return err
+		}
+		set = func(r *models.Resource, value string) (string, error) {
+			return setReset(r, value, r.Owner == u.ID || h.HasAdminAccess(u))
+		}
+	case "rotation":
+		u, err := h.getUser(ev.User)
+		if err != nil {
+			h.errorReply(ea, "")
+			return err
+		}
+		set = func(r *models.Resource, value string) (string, error) {
+			return setRotation(r, value, r.Owner == u.ID || h.HasAdminAccess(u))
+		}
+	case "url":
+		set = setURL
+	default:
+		h.errorReply(ea, fmt.Sprintf(msgUnknownSettingX, setting, strings.Join(resourceSettings, ", ")))
+		return nil
+	}
+
+	var display string
+	_, err = h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+		display, err = set(r, value)
+		return err
+	})
+	if err != nil {
+		h.handleUpdateResourceError(ea, res, err)
+		return nil
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgSettingUpdatedXYZ, setting, res, display), false)
+}
+
+func setEmoji(r *models.Resource, value string) (string, error) {
+	if value == "none" {
+		r.Emoji = ""
+		return "none", nil
+	}
+	if !command.IsEmoji(value) {
+		return "", errors.New(msgInvalidEmoji)
+	}
+	r.Emoji = value
+	return value, nil
+}
+
+func setURL(r *models.Resource, value string) (string, error) {
+	if value == "none" {
+		r.URL = ""
+		return "none", nil
+	}
+	// Slack wraps links in angle brackets, optionally followed by the text shown for them
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+	if i := strings.Index(value, "|"); i >= 0 {
+		value = value[:i]
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New(msgInvalidURL)
+	}
+	r.URL = value
+	return value, nil
+}
+
+func setDefaultDuration(r *models.Resource, value string) (string, error) {
+	if value == "none" {
+		r.DefaultDuration = 0
+		return "none", nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return "", errors.New(msgInvalidDuration)
+	}
+	r.DefaultDuration = d
+	return durationText(d), nil
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"io"
+
+	"github.com/slack-go/slack"
+)
+
+// SlackClient is the part of the Slack API used by the handler. *slack.Client implements it, and
+// slacktest.Client provides a recording fake for exercising commands without a workspace.
+type SlackClient interface {
+	AddPin(channel string, item slack.ItemRef) error
+	GetFile(downloadURL string, writer io.Writer) error
+	GetConversationHistory(params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error)
+	GetConversationInfo(input *slack.GetConversationInfoInput) (*slack.Channel, error)
+	GetUserInfo(user string) (*slack.User, error)
+	GetUserPresence(user string) (*slack.UserPresence, error)
+	ListBookmarks(channelID string) ([]slack.Bookmark, error)
+	GetUserByEmail(email string) (*slack.User, error)
+	GetUserGroups(options ...slack.GetUserGroupsOption) ([]slack.UserGroup, error)
+	OpenConversation(params *slack.OpenConversationParameters) (*slack.Channel, bool, bool, error)
+	OpenView(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error)
+	PostMessage(channelID string, options ...slack.MsgOption) (string, string, error)
+	RemovePin(channel string, item slack.ItemRef) error
+	UnfurlMessage(channelID, timestamp string, unfurls map[string]slack.Attachment, options ...slack.MsgOption) (string, string, string, error)
+	UpdateMessage(channelID, timestamp string, options ...slack.MsgOption) (string, string, string, error)
+	UploadFile(params slack.FileUploadParameters) (*slack.File, error)
+}
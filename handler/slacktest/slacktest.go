@@ -0,0 +1,442 @@
+// Package slacktest provides a fake Slack client that records the messages the handler sends, so
+// command flows can be exercised without a Slack workspace.
+package slacktest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/ameliagapin/reservebot/handler"
+	"github.com/slack-go/slack"
+)
+
+var _ handler.SlackClient = (*Client)(nil)
+
+// Message is a message posted to a channel or DM
+type Message struct {
+	Channel string
+	Text    string
+	// Thread is the timestamp of the message replied to in a thread, if any
+	Thread string
+}
+
+// Unfurl is a set of link previews added to a message
+type Unfurl struct {
+	Channel   string
+	Timestamp string
+	Unfurls   map[string]slack.Attachment
+}
+
+// Client is a fake implementation of handler.SlackClient. It is safe for concurrent use.
+type Client struct {
+	lock     sync.Mutex
+	users    map[string]*slack.User
+	channels map[string]string
+	topics   map[string]string
+	marks    map[string][]slack.Bookmark
+	groups   []slack.UserGroup
+	messages []Message
+	views    []slack.ModalViewRequest
+	unfurls  []Unfurl
+	files    []slack.FileUploadParameters
+	shared   map[string]string
+	pins     map[string]map[string]bool
+	away     map[string]bool
+	ts       int
+	// history holds the text of every message posted, by channel and timestamp
+	history map[string]string
+}
+
+func New() *Client {
+	return &Client{
+		users:    map[string]*slack.User{},
+		channels: map[string]string{},
+		topics:   map[string]string{},
+		marks:    map[string][]slack.Bookmark{},
+		shared:   map[string]string{},
+		pins:     map[string]map[string]bool{},
+		away:     map[string]bool{},
+		history:  map[string]string{},
+	}
+}
+
+// AddUser registers a user that GetUserInfo can return
+func (c *Client) AddUser(id, name, displayName, tz string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	u := &slack.User{
+		ID:   id,
+		Name: name,
+		TZ:   tz,
+	}
+	u.Profile.DisplayName = displayName
+	c.users[id] = u
+}
+
+// SetUserEmail sets the email address of a user added with AddUser, for GetUserByEmail
+func (c *Client) SetUserEmail(id, email string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if u, ok := c.users[id]; ok {
+		u.Profile.Email = email
+	}
+}
+
+// SetEnterpriseID makes a user added with AddUser a user of an Enterprise Grid org, known across it by
+// another ID, which GetUserInfo also accepts
+func (c *Client) SetEnterpriseID(id, enterpriseID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if u, ok := c.users[id]; ok {
+		u.Enterprise.ID = enterpriseID
+		c.users[enterpriseID] = u
+	}
+}
+
+// SetAway sets whether GetUserPresence reports a user as away. Users are active unless set away.
+func (c *Client) SetAway(id string, away bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.away[id] = away
+}
+
+// AddChannel registers a channel that GetConversationInfo can return
+func (c *Client) AddChannel(id, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.channels[id] = name
+}
+
+// SetTopic sets the topic of a channel added with AddChannel
+func (c *Client) SetTopic(id, topic string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.topics[id] = topic
+}
+
+// AddBookmark adds a bookmark to a channel, for ListBookmarks
+func (c *Client) AddBookmark(channel, title, link string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.marks[channel] = append(c.marks[channel], slack.Bookmark{
+		ID:        fmt.Sprintf("Bk%d", len(c.marks[channel])+1),
+		ChannelID: channel,
+		Title:     title,
+		Link:      link,
+		Type:      "link",
+	})
+}
+
+// ShareFile makes the content of a file shared with the bot downloadable from a URL with GetFile
+func (c *Client) ShareFile(url, content string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.shared[url] = content
+}
+
+// AddUserGroup registers a user group that GetUserGroups returns
+func (c *Client) AddUserGroup(id, handle string, members ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.groups = append(c.groups, slack.UserGroup{
+		ID:     id,
+		Handle: handle,
+		Users:  members,
+	})
+}
+
+// DMChannel returns the ID of the DM channel that OpenConversation returns for a user
+func DMChannel(userID string) string {
+	return "D" + userID
+}
+
+func (c *Client) GetConversationInfo(input *slack.GetConversationInfoInput) (*slack.Channel, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	name, ok := c.channels[input.ChannelID]
+	if !ok {
+		return nil, errors.New("channel_not_found")
+	}
+	ch := &slack.Channel{}
+	ch.ID = input.ChannelID
+	ch.Name = name
+	ch.Topic.Value = c.topics[input.ChannelID]
+	return ch, nil
+}
+
+func (c *Client) GetFile(downloadURL string, writer io.Writer) error {
+	c.lock.Lock()
+	content, ok := c.shared[downloadURL]
+	c.lock.Unlock()
+
+	if !ok {
+		return errors.New("file_not_found")
+	}
+	_, err := io.WriteString(writer, content)
+	return err
+}
+
+func (c *Client) ListBookmarks(channelID string) ([]slack.Bookmark, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.channels[channelID]; !ok {
+		return nil, errors.New("channel_not_found")
+	}
+	ret := make([]slack.Bookmark, len(c.marks[channelID]))
+	copy(ret, c.marks[channelID])
+	return ret, nil
+}
+
+func (c *Client) GetUserInfo(user string) (*slack.User, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	u, ok := c.users[user]
+	if !ok {
+		return nil, errors.New("user_not_found")
+	}
+	ret := *u
+	return &ret, nil
+}
+
+func (c *Client) GetUserPresence(user string) (*slack.UserPresence, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := &slack.UserPresence{Presence: "active", Online: true}
+	if c.away[user] {
+		ret.Presence = "away"
+		ret.Online = false
+	}
+	return ret, nil
+}
+
+func (c *Client) GetUserByEmail(email string) (*slack.User, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, u := range c.users {
+		if u.Profile.Email != "" && strings.EqualFold(u.Profile.Email, email) {
+			ret := *u
+			return &ret, nil
+		}
+	}
+	return nil, errors.New("users_not_found")
+}
+
+func (c *Client) GetUserGroups(options ...slack.GetUserGroupsOption) ([]slack.UserGroup, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := make([]slack.UserGroup, len(c.groups))
+	copy(ret, c.groups)
+	return ret, nil
+}
+
+func (c *Client) OpenConversation(params *slack.OpenConversationParameters) (*slack.Channel, bool, bool, error) {
+	if len(params.Users) != 1 {
+		return nil, false, false, errors.New("fake only supports DMs with a single user")
+	}
+
+	ch := &slack.Channel{}
+	ch.ID = DMChannel(params.Users[0])
+	return ch, false, false, nil
+}
+
+func (c *Client) OpenView(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.views = append(c.views, view)
+	resp := &slack.ViewResponse{}
+	resp.ID = fmt.Sprintf("V%d", len(c.views))
+	return resp, nil
+}
+
+func (c *Client) AddPin(channel string, item slack.ItemRef) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.pins[channel] == nil {
+		c.pins[channel] = map[string]bool{}
+	}
+	c.pins[channel][item.Timestamp] = true
+	return nil
+}
+
+func (c *Client) RemovePin(channel string, item slack.ItemRef) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.pins[channel][item.Timestamp] {
+		return errors.New("not_pinned")
+	}
+	delete(c.pins[channel], item.Timestamp)
+	return nil
+}
+
+// Pins returns the timestamps of the messages pinned in a channel, in no particular order
+func (c *Client) Pins(channel string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := []string{}
+	for ts := range c.pins[channel] {
+		ret = append(ret, ts)
+	}
+	return ret
+}
+
+func (c *Client) PostMessage(channelID string, options ...slack.MsgOption) (string, string, error) {
+	_, values, err := slack.UnsafeApplyMsgOptions("", channelID, "", options...)
+	if err != nil {
+		return "", "", err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.messages = append(c.messages, Message{
+		Channel: channelID,
+		Text:    values.Get("text"),
+		Thread:  values.Get("thread_ts"),
+	})
+	c.ts++
+	ts := fmt.Sprintf("%d.000000", c.ts)
+	c.history[channelID+"/"+ts] = values.Get("text")
+	return channelID, ts, nil
+}
+
+// GetConversationHistory returns the message posted with the latest timestamp, if it was posted
+func (c *Client) GetConversationHistory(params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := &slack.GetConversationHistoryResponse{}
+	if text, ok := c.history[params.ChannelID+"/"+params.Latest]; ok {
+		msg := slack.Message{}
+		msg.Timestamp = params.Latest
+		msg.Text = text
+		ret.Messages = append(ret.Messages, msg)
+	}
+	return ret, nil
+}
+
+// UpdateMessage records the new text of a message as if it were posted again, so updates show up in
+// Messages in the order they were made
+func (c *Client) UpdateMessage(channelID, timestamp string, options ...slack.MsgOption) (string, string, string, error) {
+	_, values, err := slack.UnsafeApplyMsgOptions("", channelID, "", options...)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.messages = append(c.messages, Message{
+		Channel: channelID,
+		Text:    values.Get("text"),
+	})
+	c.history[channelID+"/"+timestamp] = values.Get("text")
+	return channelID, timestamp, values.Get("text"), nil
+}
+
+// Messages returns every message posted so far, in order
+func (c *Client) Messages() []Message {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := make([]Message, len(c.messages))
+	copy(ret, c.messages)
+	return ret
+}
+
+func (c *Client) UnfurlMessage(channelID, timestamp string, unfurls map[string]slack.Attachment, options ...slack.MsgOption) (string, string, string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.unfurls = append(c.unfurls, Unfurl{
+		Channel:   channelID,
+		Timestamp: timestamp,
+		Unfurls:   unfurls,
+	})
+	return channelID, timestamp, "", nil
+}
+
+// Unfurls returns every set of link previews added so far, in order
+func (c *Client) Unfurls() []Unfurl {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := make([]Unfurl, len(c.unfurls))
+	copy(ret, c.unfurls)
+	return ret
+}
+
+func (c *Client) UploadFile(params slack.FileUploadParameters) (*slack.File, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.files = append(c.files, params)
+	f := &slack.File{}
+	f.ID = fmt.Sprintf("F%d", len(c.files))
+	f.Name = params.Filename
+	f.Title = params.Title
+	return f, nil
+}
+
+// Files returns every file uploaded so far, in order
+func (c *Client) Files() []slack.FileUploadParameters {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := make([]slack.FileUploadParameters, len(c.files))
+	copy(ret, c.files)
+	return ret
+}
+
+// Views returns every modal opened so far, in order
+func (c *Client) Views() []slack.ModalViewRequest {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := make([]slack.ModalViewRequest, len(c.views))
+	copy(ret, c.views)
+	return ret
+}
+
+// MessagesTo returns the text of every message posted to a channel, in order
+func (c *Client) MessagesTo(channel string) []string {
+	ret := []string{}
+	for _, m := range c.Messages() {
+		if m.Channel == channel {
+			ret = append(ret, m.Text)
+		}
+	}
+	return ret
+}
+
+// Reset forgets all recorded messages
+func (c *Client) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.messages = nil
+	c.views = nil
+	c.unfurls = nil
+	c.files = nil
+}
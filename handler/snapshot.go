@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// snapshot takes a snapshot of a resource's queue before a risky operation, such as recreating the
+// resource, so that it can be restored afterwards
+func (h *Handler) snapshot(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	res, err := h.parseResource(strings.Trim(ea.Command.Args[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if errors.Is(err, e.ResourceDoesNotExist) {
+		h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+		return nil
+	}
+	if err != nil {
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if !q.HasReservations() {
+		return h.reply(ea, fmt.Sprintf(msgNothingToSnapshotForY, h.resourceText(q.Resource)), true)
+	}
+
+	s := &models.Snapshot{
+		Resource:     q.Resource.String(),
+		Taken:        time.Now(),
+		By:           u.ID,
+		Reservations: q.Reservations,
+	}
+	if err := h.data.SaveSnapshot(res.Name, res.Env, s); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	log.Infof("%s took a snapshot of the queue for %s", u.Name, q.Resource)
+
+	return h.reply(ea, fmt.Sprintf(msgTookSnapshotOfYN, h.resourceText(q.Resource), len(q.Reservations), q.Resource, q.Resource), true)
+}
+
+// restore puts everyone in the last snapshot of a resource's queue back in line, in order, for the
+// resource or another one it was recreated as. Everyone already in line keeps their place ahead of them.
+// CI jobs and Terraform aren't restored, since whatever they were doing is over.
+func (h *Handler) restore(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	from, err := h.parseResource(strings.Trim(ea.Command.Args[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || from == nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+	to := from
+	if len(ea.Command.Args) > 1 {
+		to, err = h.parseResource(strings.Trim(ea.Command.Args[1], "`"), h.defaultEnv(ea.Event.User))
+		if err != nil || to == nil {
+			h.handleGetResourceError(ea, err)
+			return err
+		}
+	}
+
+	s, err := h.data.GetSnapshot(from.Name, from.Env)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if s == nil {
+		h.errorReply(ea, fmt.Sprintf(msgNoSnapshotOfY, from))
+		return nil
+	}
+	r := h.data.GetResource(to.Name, to.Env, false)
+	if r == nil {
+		h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, to))
+		return nil
+	}
+
+	// whoever is restored is told below
+	h.restoring.Store(r.Key(), true)
+	restored := []*models.Reservation{}
+	for _, res := range s.Reservations {
+		if res.User.External {
+			continue
+		}
+		err := h.data.Reserve(res.User, r.Name, r.Env)
+		if errors.Is(err, e.AlreadyInQueue) {
+			continue
+		}
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		err = h.updateReservation(res.User, r.Name, r.Env, func(c *models.Reservation) error {
+			c.Duration = res.Duration
+			c.Ticket = res.Ticket
+			c.Priority = res.Priority
+			c.Deadline = res.Deadline
+			c.DropAtDeadline = res.DropAtDeadline
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+		restored = append(restored, res)
+	}
+	h.restoring.Delete(r.Key())
+	if len(restored) == 0 {
+		return h.reply(ea, fmt.Sprintf(msgNobodyToRestoreToY, h.resourceText(r)), true)
+	}
+	log.Infof("%s restored %d reservations from the snapshot of %s to %s", u.Name, len(restored), s.Resource, r)
+
+	for _, res := range restored {
+		if res.User.ID == u.ID {
+			continue
+		}
+		pos, err := h.data.GetPosition(res.User, r.Name, r.Env)
+		if err != nil {
+			continue
+		}
+		if pos == 1 {
+			h.notify(res.User, fmt.Sprintf(msgXRestoredYouToYYouHoldIt, h.getUserDisplay(u, false), h.resourceText(r)))
+		} else {
+			h.notify(res.User, fmt.Sprintf(msgXRestoredYouToYYouAreN, h.getUserDisplay(u, false), h.resourceText(r), util.Ordinalize(pos)))
+		}
+	}
+	return h.reply(ea, fmt.Sprintf(msgRestoredNFromXToY, len(restored), s.Resource, h.resourceText(r)), true)
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/api"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// SetStaleness flags holds that go without activity for a time as stale. They are shown as stale in
+// status, and posted to the channel, if there is one, as candidates for release.
+func (h *Handler) SetStaleness(after time.Duration, channel string) {
+	h.staleAfter = after
+	h.staleChannel = channel
+}
+
+// ActivityWebhook returns an HTTP handler that records activity on resources reported by integrations,
+// such as deploys or SSH logins. Requests must present the secret in the X-Reservebot-Secret header.
+func (h *Handler) ActivityWebhook(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !h.authorizeRequest(w, r, secret, models.ScopeReserve) {
+			return
+		}
+
+		req := &api.ActivityRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Resource == "" {
+			http.Error(w, "body must be JSON with a resource", http.StatusBadRequest)
+			return
+		}
+		res, err := h.parseResource(req.Resource, "")
+		if err != nil || res == nil {
+			http.Error(w, "invalid resource", http.StatusBadRequest)
+			return
+		}
+
+		holder, err := h.RecordActivity(res.Name, res.Env, strings.TrimSpace(req.Source))
+		if errors.Is(err, e.ResourceDoesNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Errorf("%+v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		ret := &api.ActivityResponse{}
+		if holder != nil {
+			ret.Holder = holder.User.ID
+		}
+		writeJSON(w, ret)
+	})
+}
+
+// RecordActivity records activity on a resource and returns the reservation holding it, if any. A hold
+// that was flagged as stale no longer is.
+func (h *Handler) RecordActivity(name, env, source string) (*models.Reservation, error) {
+	r, err := h.updateResource(name, env, func(r *models.Resource) error {
+		r.Signaled = time.Now()
+		r.SignalSource = source
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Activity on %s from %s", r, source)
+
+	holder, err := h.data.GetReservationForResource(name, env)
+	if err != nil || holder == nil || r.Drawing() {
+		return nil, err
+	}
+	if holder.StaleFlagged {
+		err := h.updateReservation(holder.User, name, env, func(res *models.Reservation) error {
+			res.StaleFlagged = false
+			return nil
+		})
+		if err != nil && !errors.Is(err, e.NotInQueue) {
+			log.Errorf("%+v", err)
+		}
+	}
+	return holder, nil
+}
+
+// idleFor returns how long the holder of a queue has gone without activity on the resource, counted
+// from when they got it. Holds are only considered stale once the staleness is set, and never while
+// nobody holds the resource, or while a CI job, Terraform or an incident commander does.
+func (h *Handler) idleFor(q *models.Queue, now time.Time) (time.Duration, bool) {
+	if h.staleAfter <= 0 || !q.HasReservations() {
+		return 0, false
+	}
+	r := q.Resource
+	holder := q.Reservations[0]
+	if r.Drawing() || r.Closed(now) || q.Resetting() || holder.User.External || holder.Incident != "" {
+		return 0, false
+	}
+	last := holder.Time
+	if r.Signaled.After(last) {
+		last = r.Signaled
+	}
+	idle := now.Sub(last)
+	return idle, idle >= h.staleAfter
+}
+
+// staleText describes a stale hold for status
+func (h *Handler) staleText(q *models.Queue) string {
+	idle, stale := h.idleFor(q, time.Now())
+	if !stale {
+		return ""
+	}
+	return fmt.Sprintf(" :zzz: No activity for %s, so the hold may be stale.", durationText(idle))
+}
+
+// CheckStale flags holds that have gone without activity for too long as stale, once, and posts them to
+// the stale channel, if there is one, as candidates for release. Private resources aren't posted.
+func (h *Handler) CheckStale() {
+	now := time.Now()
+
+	for _, q := range h.data.GetQueues() {
+		idle, stale := h.idleFor(q, now)
+		if !stale || q.Reservations[0].StaleFlagged {
+			continue
+		}
+		r := q.Resource
+		holder := q.Reservations[0]
+
+		err := h.updateReservation(holder.User, r.Name, r.Env, func(res *models.Reservation) error {
+			res.StaleFlagged = true
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		log.Infof("Hold of %s by %s is stale, no activity for %s", r, holder.User.Name, idle)
+
+		if h.staleChannel == "" || r.Private {
+			continue
+		}
+		msg := fmt.Sprintf(msgXsHoldOnYLooksStale, h.getUserDisplay(holder.User, false), h.resourceText(r), durationText(idle))
+		if h.readOnly {
+			log.Infof("Read-only: would post to %s: %s", h.staleChannel, msg)
+			continue
+		}
+		if _, _, err := h.client.PostMessage(h.staleChannel, slack.MsgOptionText(msg, false)); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+}
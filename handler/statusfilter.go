@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// statusFilter picks the resources `status` shows, so that people in large orgs sharing one bot only see
+// those relevant to them. A resource is shown if it matches any of the filter's teams or envs. A resource
+// belongs to the team its owner is a member of, so resources without an owner match no team.
+type statusFilter struct {
+	teams []string
+	envs  []string
+}
+
+// isStatusFilter returns whether the argument to `status` is a filter rather than a resource
+func isStatusFilter(arg string) bool {
+	arg = strings.ToLower(strings.Trim(arg, "`"))
+	return strings.HasPrefix(arg, "team:") || strings.HasPrefix(arg, "env:")
+}
+
+// parseStatusFilter parses a comma separated list of terms, each `team:<team>` or `env:<env>`
+func (h *Handler) parseStatusFilter(text string) (*statusFilter, error) {
+	f := &statusFilter{}
+	for _, term := range strings.Split(strings.Trim(text, "`"), ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		split := strings.SplitN(term, ":", 2)
+		if len(split) != 2 || split[1] == "" {
+			return nil, fmt.Errorf(msgInvalidStatusFilterX, term)
+		}
+		switch strings.ToLower(split[0]) {
+		case "team":
+			teams := h.teams.list()
+			if len(teams) == 0 {
+				return nil, errors.New(msgNoTeamsAreSetUp)
+			}
+			team := h.teams.find(strings.TrimPrefix(split[1], "@"))
+			if team == "" {
+				return nil, fmt.Errorf(msgXIsntATeamY, split[1], strings.Join(teams, ", "))
+			}
+			f.teams = append(f.teams, team)
+		case "env":
+			if len(h.data.GetResourcesForEnv(split[1])) == 0 {
+				return nil, fmt.Errorf(msgNoResourcesInX, split[1])
+			}
+			f.envs = append(f.envs, split[1])
+		default:
+			return nil, fmt.Errorf(msgInvalidStatusFilterX, term)
+		}
+	}
+	if len(f.teams) == 0 && len(f.envs) == 0 {
+		return nil, fmt.Errorf(msgInvalidStatusFilterX, text)
+	}
+	return f, nil
+}
+
+// String formats the filter the way it is given, e.g. `team:payments,env:staging`
+func (f *statusFilter) String() string {
+	terms := []string{}
+	for _, team := range f.teams {
+		terms = append(terms, "team:"+team)
+	}
+	for _, env := range f.envs {
+		terms = append(terms, "env:"+env)
+	}
+	return strings.Join(terms, ",")
+}
+
+// matches returns whether a resource is shown by the filter
+func (f *statusFilter) matches(r *models.Resource, t *teams) bool {
+	for _, env := range f.envs {
+		if r.Env == env {
+			return true
+		}
+	}
+	if len(f.teams) == 0 || r.Owner == "" {
+		return false
+	}
+	team := t.of(r.Owner)
+	for _, want := range f.teams {
+		if team == want {
+			return true
+		}
+	}
+	return false
+}
+
+// savedStatusFilter returns the filter a user saved for their status, or nil if they haven't saved one
+// or it no longer applies, such as when its team was removed
+func (h *Handler) savedStatusFilter(userID string) *statusFilter {
+	text, err := h.data.GetStatusFilter(userID)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return nil
+	}
+	if text == "" {
+		return nil
+	}
+	f, err := h.parseStatusFilter(text)
+	if err != nil {
+		log.Warnf("Ignoring the status filter %s of %s: %s", text, userID, err)
+		return nil
+	}
+	return f
+}
+
+// setStatusFilter saves the filter the user's status is shown with when they don't give one, such as
+// `team:payments`. `none` forgets it.
+func (h *Handler) setStatusFilter(ea *EventAction) error {
+	text := strings.Join(ea.Command.Args, ",")
+	saved := ""
+	if strings.Trim(text, "`") != "none" {
+		f, err := h.parseStatusFilter(text)
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			return nil
+		}
+		saved = f.String()
+	}
+
+	if err := h.data.SetStatusFilter(ea.Event.User, saved); err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if saved == "" {
+		return h.reply(ea, msgStatusShowsEveryResource, true)
+	}
+	return h.reply(ea, fmt.Sprintf(msgYourStatusFilterIsX, saved), true)
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// How the status page shows who holds resources
+const (
+	// statusPageNamesHidden shows that resources are held, but not by whom
+	statusPageNamesHidden = "hidden"
+	// statusPageNamesInitials shows the initials of whoever holds resources
+	statusPageNamesInitials = "initials"
+	// statusPageNamesFull shows the names of whoever holds resources
+	statusPageNamesFull = "full"
+)
+
+// statusPageConfig configures the public status page
+type statusPageConfig struct {
+	// Title is shown at the top of the page
+	Title string
+	// Envs limits the page to some envs. All envs are shown if it is empty.
+	Envs []string
+	// Names is how holders are shown: hidden, initials or full
+	Names string
+	// Theme is light or dark
+	Theme string
+	// Stylesheet is the URL of a stylesheet applied after the theme, if set
+	Stylesheet string
+	// Refresh is how often the page reloads itself
+	Refresh time.Duration
+}
+
+// statusPage is what the status page shows
+type statusPage struct {
+	Title      string           `json:"title"`
+	Theme      string           `json:"-"`
+	Stylesheet string           `json:"-"`
+	Refresh    int              `json:"-"`
+	Updated    time.Time        `json:"updated"`
+	Envs       []*statusPageEnv `json:"envs"`
+}
+
+type statusPageEnv struct {
+	Name      string                `json:"name"`
+	Free      int                   `json:"free"`
+	Resources []*statusPageResource `json:"resources"`
+}
+
+type statusPageResource struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// Holder is who holds the resource, as the page is configured to show them, if anyone does
+	Holder  string `json:"holder,omitempty"`
+	Since   string `json:"since,omitempty"`
+	Waiting int    `json:"waiting"`
+	// Label is the state the holder labeled the resource with, if any
+	Label string `json:"label,omitempty"`
+}
+
+// statusPageTemplate lays the envs out as cards, large enough to read across a room
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="{{.Refresh}}">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; font-size: 1.4em; }
+body.light { background: #fafafa; color: #222; }
+body.dark { background: #111; color: #eee; }
+.envs { display: flex; flex-wrap: wrap; gap: 1.5em; }
+.env { border-radius: 8px; padding: 1em 1.5em; min-width: 16em; }
+.light .env { background: #fff; box-shadow: 0 1px 4px #0002; }
+.dark .env { background: #222; }
+.env h2 { margin-top: 0; }
+.resource { display: flex; justify-content: space-between; gap: 1em; padding: .3em 0; }
+.state { font-weight: bold; }
+.free .state { color: #2e9e44; }
+.held .state, .broken .state { color: #d9363e; }
+.resetting .state, .maintenance .state { color: #d98e04; }
+.detail { opacity: .7; }
+.label { font-style: italic; }
+footer { margin-top: 2em; opacity: .6; font-size: .7em; }
+</style>
+{{if .Stylesheet}}<link rel="stylesheet" href="{{.Stylesheet}}">{{end}}
+</head>
+<body class="{{.Theme}}">
+<h1>{{.Title}}</h1>
+<div class="envs">
+{{range .Envs}}<div class="env"><h2>{{if .Name}}{{.Name}}{{else}}Resources{{end}} <span class="detail">{{.Free}} free</span></h2>
+{{range .Resources}}<div class="resource {{.State}}"><span>{{.Name}}</span><span><span class="state">{{.State}}</span>{{if .Holder}} {{.Holder}}{{end}}{{if .Label}} <span class="label">{{.Label}}</span>{{end}}{{if .Since}} <span class="detail">{{.Since}}{{if .Waiting}}, {{.Waiting}} waiting{{end}}</span>{{end}}</span></div>
+{{end}}</div>
+{{else}}<p>There are no resources.</p>
+{{end}}</div>
+<footer>Updated {{.Updated.Format "15:04:05 MST"}}</footer>
+</body></html>
+`))
+
+// StatusPage returns an HTTP handler serving a read-only page of which resources are free and held, for
+// showing on a TV in the office. It needs no secret, so it never shows more about holders than names
+// allows, hidden, initials or full, and never shows anything about who holds private resources. Only the
+// given envs are shown, or all of them if none are. The page reloads itself every refresh, and is served
+// as JSON to requests that accept it.
+func (h *Handler) StatusPage(title string, envs []string, names, theme, stylesheet string, refresh time.Duration) (http.Handler, error) {
+	switch names {
+	case statusPageNamesHidden, statusPageNamesInitials, statusPageNamesFull:
+	default:
+		return nil, fmt.Errorf("status page names must be %s, %s or %s, not %q", statusPageNamesHidden, statusPageNamesInitials, statusPageNamesFull, names)
+	}
+	shown := []string{}
+	for _, env := range envs {
+		if env = strings.TrimSpace(env); env != "" {
+			shown = append(shown, env)
+		}
+	}
+	config := statusPageConfig{Title: title, Envs: shown, Names: names, Theme: theme, Stylesheet: stylesheet, Refresh: refresh}
+	if config.Title == "" {
+		config.Title = "Environments"
+	}
+	if config.Theme != "dark" {
+		config.Theme = "light"
+	}
+	if config.Refresh <= 0 {
+		config.Refresh = 30 * time.Second
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		page := h.statusPage(config, time.Now())
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			writeJSON(w, page)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusPageTemplate.Execute(w, page); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}), nil
+}
+
+// statusPage builds what the status page shows at a time
+func (h *Handler) statusPage(config statusPageConfig, now time.Time) *statusPage {
+	ret := &statusPage{
+		Title:      config.Title,
+		Theme:      config.Theme,
+		Stylesheet: config.Stylesheet,
+		Refresh:    int(config.Refresh.Seconds()),
+		Updated:    now,
+		Envs:       []*statusPageEnv{},
+	}
+
+	envs := map[string]*statusPageEnv{}
+	for _, q := range h.data.GetQueues() {
+		if len(config.Envs) > 0 && !util.InSlice(config.Envs, q.Resource.Env) {
+			continue
+		}
+		env, ok := envs[q.Resource.Env]
+		if !ok {
+			env = &statusPageEnv{Name: q.Resource.Env, Resources: []*statusPageResource{}}
+			envs[q.Resource.Env] = env
+			ret.Envs = append(ret.Envs, env)
+		}
+		res := h.statusPageResource(config, q, now)
+		if res.State == "free" {
+			env.Free++
+		}
+		env.Resources = append(env.Resources, res)
+	}
+	sort.Slice(ret.Envs, func(i, j int) bool {
+		return ret.Envs[i].Name < ret.Envs[j].Name
+	})
+	return ret
+}
+
+func (h *Handler) statusPageResource(config statusPageConfig, q *models.Queue, now time.Time) *statusPageResource {
+	r := q.Resource
+	ret := &statusPageResource{Name: r.Name, State: "free"}
+	switch {
+	case r.ActiveMaintenance(now) != nil:
+		ret.State = "maintenance"
+	case r.Broken != nil:
+		ret.State = "broken"
+	case q.Resetting():
+		ret.State = "resetting"
+	case q.HasReservations() && !r.Drawing():
+		ret.State = "held"
+		holder := q.Reservations[0]
+		ret.Since = durationText(now.Sub(holder.Time))
+		ret.Waiting = len(q.Reservations) - 1
+		ret.Label = holder.Label
+		if !r.Private {
+			ret.Holder = h.statusPageHolder(config.Names, holder.User)
+		}
+	}
+	return ret
+}
+
+// statusPageHolder shows a holder as the status page is configured to
+func (h *Handler) statusPageHolder(names string, u *models.User) string {
+	if names != statusPageNamesInitials && names != statusPageNamesFull {
+		return ""
+	}
+	name := u.Name
+	if !u.External {
+		if cached, err := h.getUser(u.ID); err == nil && cached.DisplayName != "" {
+			name = cached.DisplayName
+		}
+	}
+	if names == statusPageNamesFull {
+		return name
+	}
+	initials := ""
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == ' ' || r == '.' || r == '-' || r == '_' }) {
+		initials += strings.ToUpper(string([]rune(word)[0]))
+	}
+	return initials
+}
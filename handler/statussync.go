@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// statusScopes are the user scopes needed to read and set a user's Slack status
+	statusScopes = "users.profile:read,users.profile:write"
+	// statusPrefix starts every status the bot sets, so that it only ever clears its own
+	statusPrefix = "holding "
+	// defaultStatusEmoji is shown for resources without an emoji
+	defaultStatusEmoji = ":lock:"
+	// maxStatusLength is the longest status Slack accepts
+	maxStatusLength = 100
+)
+
+// StatusClient reads and sets the Slack status of the user whose token it was made with
+type StatusClient interface {
+	GetUserProfile(params *slack.GetUserProfileParameters) (*slack.UserProfile, error)
+	SetUserCustomStatus(statusText, statusEmoji string, statusExpiration int64) error
+}
+
+// statusSync sets the Slack status of users who opted in to what they hold
+type statusSync struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	// client returns a client acting as the user who granted a token
+	client func(token string) StatusClient
+	// lock serializes updates, so that each sets a status from the latest data
+	lock sync.Mutex
+}
+
+// StatusSync enables setting the Slack status of users who opt in with `sync status on` to what they
+// hold. Users grant the bot permission through Slack's OAuth flow, which returns to the handler this
+// returns at redirectURL.
+func (h *Handler) StatusSync(clientID, clientSecret, redirectURL string) http.Handler {
+	h.statusSync = &statusSync{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		client: func(token string) StatusClient {
+			return slack.New(token)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, ok := h.statusStateUser(r.URL.Query().Get("state"))
+		if !ok {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			// the user declined
+			fmt.Fprintln(w, "Your status won't be changed.")
+			return
+		}
+
+		resp, err := slack.GetOAuthV2Response(http.DefaultClient, clientID, clientSecret, code, redirectURL)
+		if err != nil {
+			log.Errorf("%+v", err)
+			http.Error(w, "Slack didn't grant permission, please try again.", http.StatusBadGateway)
+			return
+		}
+		if resp.AuthedUser.ID != uid || resp.AuthedUser.AccessToken == "" {
+			http.Error(w, "Permission must be granted by the user who asked for it.", http.StatusForbidden)
+			return
+		}
+		if err := h.data.SetUserToken(uid, resp.AuthedUser.AccessToken); err != nil {
+			log.Errorf("%+v", err)
+			http.Error(w, "Something went wrong, please try again.", http.StatusInternalServerError)
+			return
+		}
+		log.Infof("%s turned on status sync", uid)
+
+		h.notify(&models.User{ID: uid}, msgStatusSyncIsOn)
+		h.syncStatus(uid)
+		fmt.Fprintln(w, "Done! You can close this page.")
+	})
+}
+
+// statusState returns the OAuth state identifying the user who asked to sync their status, signed so
+// that nobody can grant a token on someone else's behalf
+func (h *Handler) statusState(uid string) string {
+	mac := hmac.New(sha256.New, []byte(h.statusSync.clientSecret))
+	mac.Write([]byte("status:" + uid))
+	return uid + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// statusStateUser returns the user an OAuth state belongs to, if it is valid
+func (h *Handler) statusStateUser(state string) (string, bool) {
+	i := strings.LastIndex(state, ".")
+	if i <= 0 {
+		return "", false
+	}
+	uid := state[:i]
+	return uid, hmac.Equal([]byte(state), []byte(h.statusState(uid)))
+}
+
+// syncStatusCommand turns syncing a user's status on, by sending them a link to grant permission, or off
+func (h *Handler) syncStatusCommand(ea *EventAction) error {
+	if h.statusSync == nil {
+		return h.reply(ea, msgStatusSyncDisabled, false)
+	}
+	u := &models.User{ID: ea.Event.User}
+
+	switch strings.ToLower(ea.Command.Args[0]) {
+	case "on":
+		q := url.Values{}
+		q.Set("client_id", h.statusSync.clientID)
+		q.Set("user_scope", statusScopes)
+		q.Set("redirect_uri", h.statusSync.redirectURL)
+		q.Set("state", h.statusState(u.ID))
+		h.notify(u, fmt.Sprintf(msgGrantStatusSyncX, "https://slack.com/oauth/v2/authorize?"+q.Encode()))
+		if ea.Event.ChannelType == "im" {
+			return nil
+		}
+		return h.reply(ea, msgStatusSyncLinkSentByDM, true)
+	case "off":
+		// the bot's status is cleared while it can still be
+		h.statusSync.lock.Lock()
+		h.setStatus(u.ID, "", "")
+		h.statusSync.lock.Unlock()
+		if err := h.data.SetUserToken(u.ID, ""); err != nil {
+			log.Errorf("%+v", err)
+			h.errorReply(ea, msgIDontKnow)
+			return err
+		}
+		log.Infof("%s turned off status sync", u.ID)
+		return h.reply(ea, msgStatusSyncIsOff, true)
+	}
+	h.errorReply(ea, msgInvalidStatusSync)
+	return nil
+}
+
+// syncStatuses updates the status of the users whose holds began or ended with an event
+func (h *Handler) syncStatuses(ev events.Event) {
+	if h.statusSync == nil {
+		return
+	}
+	for _, res := range []*models.Reservation{ev.Reservation, ev.Previous} {
+		if res != nil {
+			go h.syncStatus(res.User.ID)
+		}
+	}
+}
+
+// syncStatus sets a user's status to the resources they hold, or clears it if they hold none, if they
+// opted in
+func (h *Handler) syncStatus(uid string) {
+	// what is held is read under the lock, so that an update never overwrites a later one
+	h.statusSync.lock.Lock()
+	defer h.statusSync.lock.Unlock()
+
+	held := []*models.Resource{}
+	for _, q := range h.data.GetQueues() {
+		if q.HasReservations() && q.Reservations[0].User.ID == uid && !q.Resource.Drawing() {
+			held = append(held, q.Resource)
+		}
+	}
+	if len(held) == 0 {
+		h.setStatus(uid, "", "")
+		return
+	}
+
+	names := []string{}
+	for _, r := range held {
+		names = append(names, r.String())
+	}
+	text := statusPrefix + strings.Join(names, ", ")
+	if len(text) > maxStatusLength {
+		text = text[:maxStatusLength-3] + "..."
+	}
+	emoji := held[0].Emoji
+	if emoji == "" {
+		emoji = defaultStatusEmoji
+	}
+	h.setStatus(uid, text, emoji)
+}
+
+// setStatus sets a user's status, or clears it if text is empty, if they opted in. A status the user set
+// themselves is never replaced or cleared. The status sync lock must be held.
+func (h *Handler) setStatus(uid, text, emoji string) {
+	token, err := h.data.GetUserToken(uid)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+	if token == "" {
+		return
+	}
+
+	client := h.statusSync.client(token)
+	profile, err := client.GetUserProfile(&slack.GetUserProfileParameters{UserID: uid})
+	if err != nil {
+		log.Errorf("Error getting the status of %s: %+v", uid, err)
+		return
+	}
+	if profile.StatusText != "" && !strings.HasPrefix(profile.StatusText, statusPrefix) {
+		return
+	}
+	if profile.StatusText == text {
+		return
+	}
+
+	if h.readOnly {
+		log.Infof("Read-only: would set the status of %s to %q", uid, text)
+		return
+	}
+	if err := client.SetUserCustomStatus(text, emoji, 0); err != nil {
+		log.Errorf("Error setting the status of %s: %+v", uid, err)
+	}
+}
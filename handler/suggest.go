@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/slack-go/slack"
+)
+
+// maxSuggestions is the most options Slack shows in a select
+const maxSuggestions = 100
+
+// suggest returns the options of a select whose options are loaded as they are typed, such as the
+// resources of the reserve modal. Resources are grouped by env and match what is typed in either their
+// env or name, so typing an env lists its resources.
+func (h *Handler) suggest(cb slack.InteractionCallback) interface{} {
+	if cb.ActionID != reserveResourcesBlock {
+		return &slack.OptionsResponse{Options: []*slack.OptionBlockObject{}}
+	}
+
+	query := strings.ToLower(strings.TrimSpace(cb.Value))
+	resources := []*models.Resource{}
+	for _, r := range h.data.GetResources() {
+		if strings.Contains(strings.ToLower(r.String()), query) {
+			resources = append(resources, r)
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].String() < resources[j].String()
+	})
+	if len(resources) > maxSuggestions {
+		resources = resources[:maxSuggestions]
+	}
+
+	groups := []*slack.OptionGroupBlockObject{}
+	var group *slack.OptionGroupBlockObject
+	for i, r := range resources {
+		if i == 0 || r.Env != resources[i-1].Env {
+			label := r.Env
+			if label == "" {
+				label = "No env"
+			}
+			group = slack.NewOptionGroupBlockElement(slack.NewTextBlockObject(slack.PlainTextType, label, false, false))
+			groups = append(groups, group)
+		}
+		group.Options = append(group.Options, resourceOption(r))
+	}
+	return &slack.OptionGroupsResponse{OptionGroups: groups}
+}
+
+// resourceOption returns the option of a select for a resource
+func resourceOption(r *models.Resource) *slack.OptionBlockObject {
+	return slack.NewOptionBlockObject(r.String(), slack.NewTextBlockObject(slack.PlainTextType, r.String(), false, false), nil)
+}
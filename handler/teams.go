@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// teams holds the Slack user groups that usage is reported by, with their monthly budgets. Membership is
+// refreshed by SyncTeams, like admin groups.
+type teams struct {
+	lock sync.RWMutex
+	// handles are the configured groups, as handles such as backend or IDs, in the order given
+	handles []string
+	// budgets are the soft monthly cost budgets of the teams that have one
+	budgets map[string]float64
+	// members maps each user ID to the first team they are a member of
+	members map[string]string
+}
+
+func newTeams() *teams {
+	return &teams{
+		budgets: map[string]float64{},
+		members: map[string]string{},
+	}
+}
+
+// of returns the team a user belongs to, or an empty string if they aren't in any
+func (t *teams) of(userID string) string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.members[userID]
+}
+
+// budget returns the monthly budget of a team, or 0 if it has none
+func (t *teams) budget(team string) float64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.budgets[team]
+}
+
+// find returns the configured team with a handle or ID, ignoring case, or an empty string if there is none
+func (t *teams) find(name string) string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	for _, handle := range t.handles {
+		if strings.EqualFold(handle, name) {
+			return handle
+		}
+	}
+	return ""
+}
+
+// list returns the configured teams, in the order given
+func (t *teams) list() []string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return append([]string{}, t.handles...)
+}
+
+// SetTeams configures the teams from a comma separated list of user group handles or IDs, each
+// optionally followed by its monthly budget, e.g. `backend=500,frontend`
+func (h *Handler) SetTeams(spec string) error {
+	handles := []string{}
+	budgets := map[string]float64{}
+	for _, t := range strings.Split(spec, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		split := strings.SplitN(t, "=", 2)
+		handle := strings.TrimPrefix(strings.TrimSpace(split[0]), "@")
+		if len(split) == 2 {
+			b, err := strconv.ParseFloat(strings.TrimSpace(split[1]), 64)
+			if err != nil || b <= 0 {
+				return fmt.Errorf("invalid budget for team %s: %s", handle, split[1])
+			}
+			budgets[handle] = b
+		}
+		handles = append(handles, handle)
+	}
+
+	h.teams.lock.Lock()
+	h.teams.handles = handles
+	h.teams.budgets = budgets
+	h.teams.lock.Unlock()
+
+	return nil
+}
+
+// SyncTeams refreshes the members of the teams from Slack. If Slack can't be reached, the previous
+// members are kept.
+func (h *Handler) SyncTeams() error {
+	h.teams.lock.RLock()
+	handles := h.teams.handles
+	h.teams.lock.RUnlock()
+	if len(handles) == 0 {
+		return nil
+	}
+
+	groups, err := h.client.GetUserGroups(slack.GetUserGroupsOptionIncludeUsers(true))
+	if err != nil {
+		return err
+	}
+
+	members := map[string]string{}
+	for _, want := range handles {
+		found := false
+		for _, g := range groups {
+			if g.ID != want && g.Handle != want {
+				continue
+			}
+			found = true
+			for _, id := range g.Users {
+				if _, ok := members[id]; !ok {
+					members[id] = want
+				}
+			}
+		}
+		if !found {
+			log.Warnf("Team user group %s was not found", want)
+		}
+	}
+
+	h.teams.lock.Lock()
+	h.teams.members = members
+	h.teams.lock.Unlock()
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/api"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// terraformUser returns the external user that holds the reservation for a lock
+func terraformUser(l *api.TerraformLockInfo) *models.User {
+	op := strings.ToLower(strings.TrimPrefix(l.Operation, "OperationType"))
+	return &models.User{
+		ID:       "terraform:" + l.ID,
+		Name:     strings.TrimSpace("terraform " + op),
+		External: true,
+	}
+}
+
+// TerraformLock returns an HTTP handler implementing the locking part of Terraform's http state backend,
+// so running Terraform against an environment reserves the matching resource. The resource is given in
+// the path as /<env>/<name>, or /<name> if envs aren't required. Locking fails while anyone else holds
+// or is waiting for the resource rather than joining the queue, as Terraform retries on its own. The
+// secret must be given as the backend's password.
+func (h *Handler) TerraformLock(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.authorizeRequest(w, r, secret, models.ScopeReserve) {
+			return
+		}
+
+		res, err := h.parseResource(strings.Join(strings.Split(strings.Trim(r.URL.Path, "/"), "/"), "|"), "")
+		if err != nil || res == nil || res.Name == "" {
+			http.Error(w, "path must be /<env>/<name>", http.StatusBadRequest)
+			return
+		}
+
+		lock := &api.TerraformLockInfo{}
+		if err := json.NewDecoder(r.Body).Decode(lock); err != nil || lock.ID == "" {
+			http.Error(w, "body must be Terraform lock info", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case "LOCK", http.MethodPost:
+			h.terraformLock(w, res, lock)
+		case "UNLOCK", http.MethodDelete:
+			h.terraformUnlock(w, res, lock)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (h *Handler) terraformLock(w http.ResponseWriter, res *models.Resource, lock *api.TerraformLockInfo) {
+	u := terraformUser(lock)
+
+	if h.blockUnhealthy {
+		r := h.data.GetResource(res.Name, res.Env, false)
+		if r != nil && r.Unhealthy() {
+			h.terraformLocked(w, &api.TerraformLockInfo{Info: fmt.Sprintf("%s is failing its health check", res)})
+			return
+		}
+	}
+	if r := h.data.GetResource(res.Name, res.Env, false); r != nil && r.BlockedAsBroken() {
+		h.terraformLocked(w, &api.TerraformLockInfo{Info: fmt.Sprintf("%s is reported broken", res)})
+		return
+	}
+
+	if r := h.data.GetResource(res.Name, res.Env, false); r != nil && r.Closed(time.Now()) {
+		h.terraformLocked(w, &api.TerraformLockInfo{Info: fmt.Sprintf("%s is closed until %s", res, r.Hours.NextOpen(time.Now()).Format(hoursTimeFormat))})
+		return
+	}
+	if r := h.data.GetResource(res.Name, res.Env, false); needsApproval(r, u) {
+		if _, err := h.data.GetPosition(u, res.Name, res.Env); errors.Is(err, e.NotInQueue) {
+			h.terraformLocked(w, &api.TerraformLockInfo{Info: fmt.Sprintf("%s requires approval", res)})
+			return
+		}
+	}
+	if r := h.data.GetResource(res.Name, res.Env, false); r != nil && r.Drawing() {
+		h.terraformLocked(w, &api.TerraformLockInfo{Info: fmt.Sprintf("%s goes to a draw at %s", res, r.DrawAt.Format(drawTimeFormat))})
+		return
+	}
+
+	holder, err := h.data.GetReservationForResource(res.Name, res.Env)
+	if err != nil && !errors.Is(err, e.ResourceDoesNotExist) {
+		log.Errorf("%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if holder != nil {
+		if holder.User.ID == u.ID {
+			// Terraform is retrying a lock it already has
+			return
+		}
+		h.terraformLocked(w, h.terraformLockHeldBy(holder))
+		return
+	}
+
+	err = h.data.Reserve(u, res.Name, res.Env)
+	if errors.Is(err, e.InMaintenance) {
+		h.terraformLocked(w, &api.TerraformLockInfo{Info: fmt.Sprintf("%s is under maintenance", res)})
+		return
+	}
+	if err != nil {
+		log.Errorf("%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// someone else may have reserved the resource since it was checked
+	if pos, err := h.data.GetPosition(u, res.Name, res.Env); err != nil || pos != 1 {
+		h.data.Remove(u, res.Name, res.Env)
+		holder, _ := h.data.GetReservationForResource(res.Name, res.Env)
+		h.terraformLocked(w, h.terraformLockHeldBy(holder))
+		return
+	}
+
+	h.linkJob(u, res, &models.Job{
+		Provider: "terraform",
+		ID:       lock.ID,
+		Project:  lock.Path,
+		User:     lock.Who,
+	})
+	log.Infof("Terraform locked %s for %s", res, lock.Who)
+}
+
+func (h *Handler) terraformUnlock(w http.ResponseWriter, res *models.Resource, lock *api.TerraformLockInfo) {
+	err := h.data.Remove(terraformUser(lock), res.Name, res.Env)
+	switch {
+	case err == nil:
+		log.Infof("Terraform unlocked %s for %s", res, lock.Who)
+	case errors.Is(err, e.NotInQueue), errors.Is(err, e.ResourceDoesNotExist):
+		// unlocking twice is harmless
+	default:
+		log.Errorf("%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// terraformLockHeldBy describes the reservation holding a resource as a Terraform lock
+func (h *Handler) terraformLockHeldBy(holder *models.Reservation) *api.TerraformLockInfo {
+	if holder == nil {
+		return &api.TerraformLockInfo{Info: "the resource is reserved"}
+	}
+	who := h.userName(holder.User)
+	if holder.Job != nil && holder.Job.User != "" {
+		who = holder.Job.User
+	}
+	return &api.TerraformLockInfo{
+		ID:      holder.ID,
+		Who:     who,
+		Info:    "reserved with reservebot",
+		Created: holder.Time,
+	}
+}
+
+// terraformLocked refuses a lock, describing who holds it
+func (h *Handler) terraformLocked(w http.ResponseWriter, current *api.TerraformLockInfo) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusLocked)
+	if err := json.NewEncoder(w).Encode(current); err != nil {
+		log.Errorf("%+v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/tickets"
+	log "github.com/sirupsen/logrus"
+)
+
+// resolveTicket expands a ticket ID given with a command. A ticket the issue tracker doesn't know is
+// rejected, but if the tracker can't be reached the ID is used as given.
+func (h *Handler) resolveTicket(id string) (*models.Ticket, error) {
+	t, err := h.tickets.Resolve(id)
+	if err == tickets.NotFound {
+		return nil, err
+	}
+	if err != nil {
+		log.Errorf("Error resolving ticket %s: %+v", id, err)
+		return &models.Ticket{ID: id}, nil
+	}
+	return t, nil
+}
+
+// linkTicket records the ticket a user's reservation is held for
+func (h *Handler) linkTicket(u *models.User, res *models.Resource, t *models.Ticket) {
+	err := h.updateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+		r.Ticket = t
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// ticketText describes the ticket a reservation is linked to, to follow the user's name
+func ticketText(res *models.Reservation) string {
+	t := res.Ticket
+	if t == nil {
+		return ""
+	}
+
+	link := fmt.Sprintf("`%s`", t.ID)
+	if t.URL != "" {
+		link = fmt.Sprintf("<%s|%s>", t.URL, t.ID)
+	}
+	if t.Summary != "" {
+		return fmt.Sprintf(" working on %s _%s_", link, t.Summary)
+	}
+	return fmt.Sprintf(" working on %s", link)
+}
@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ameliagapin/reservebot/api"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// timelineDays is how many days a timeline covers, unless another number is asked for
+	timelineDays = 7
+	// timelineSlot is how long each character of a timeline in Slack covers
+	timelineSlot = 4 * time.Hour
+)
+
+// recordHold records a hold once it ends, for timelines. Releasing a resource ends its hold in both the
+// release and the queue advancing, so it is only recorded once.
+func (h *Handler) recordHold(ev events.Event) {
+	res := ev.EndedHold()
+	if res == nil || res.Time.IsZero() {
+		return
+	}
+	if last, ok := h.lastHolds.Load(ev.Resource.Key()); ok && last == res.ID+res.Time.String() {
+		return
+	}
+	if _, ok := h.advancing.Load(ev.Resource.Key()); ok {
+		// the resource was handed on by a policy before its first holder had a chance to use it
+		return
+	}
+	hold := &models.Hold{
+		User:   res.User,
+		Start:  res.Time,
+		End:    ev.Time,
+		Joined: res.Joined,
+	}
+	if err := h.data.AddHold(ev.Resource.Name, ev.Resource.Env, hold); err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+	h.lastHolds.Store(ev.Resource.Key(), res.ID+res.Time.String())
+}
+
+// timelineHolds returns the holds of a resource since a time, including the current one, which ends now,
+// in the order they started
+func (h *Handler) timelineHolds(q *models.Queue, since, now time.Time) ([]*api.TimelineHold, error) {
+	holds, err := h.data.GetHolds(q.Resource.Name, q.Resource.Env, since)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*api.TimelineHold{}
+	for _, hold := range holds {
+		ret = append(ret, &api.TimelineHold{
+			User:  hold.User.ID,
+			Name:  h.userName(hold.User),
+			Start: hold.Start,
+			End:   hold.End,
+		})
+	}
+	if q.HasReservations() && !q.Resource.Drawing() && !q.Resetting() {
+		res := q.Reservations[0]
+		ret = append(ret, &api.TimelineHold{
+			User:    res.User.ID,
+			Name:    h.userName(res.User),
+			Start:   res.Time,
+			End:     now,
+			Current: true,
+		})
+	}
+	for _, hold := range ret {
+		if hold.Start.Before(since) {
+			hold.Start = since
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Start.Before(ret[j].Start)
+	})
+	return ret, nil
+}
+
+// timeline shows who held a resource over the last week, as a row per holder with a mark for every
+// four hours they held it in, in the user's timezone
+func (h *Handler) timeline(ea *EventAction) error {
+	u, err := h.getUser(ea.Event.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	res, err := h.parseResource(strings.Trim(ea.Command.Args[0], "`"), h.defaultEnv(ea.Event.User))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if errors.Is(err, e.ResourceDoesNotExist) {
+		h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+		return nil
+	}
+	if err != nil {
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if !h.reveals(q, u, ea.Event.ChannelType == "im") {
+		h.errorReply(ea, fmt.Sprintf(msgTimelineOfXIsPrivate, h.resourceText(q.Resource)))
+		return nil
+	}
+
+	now := time.Now().In(u.Location())
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := midnight.AddDate(0, 0, 1-timelineDays)
+	holds, err := h.timelineHolds(q, from, now)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, e.Message(err))
+		return err
+	}
+	if len(holds) == 0 {
+		return h.reply(ea, fmt.Sprintf(msgNobodyHeldXThisWeek, h.resourceText(q.Resource)), false)
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgTimelineOfXY, h.resourceText(q.Resource), timelineText(holds, from)), false)
+}
+
+// timelineText draws holds as a row per holder, in the order they first held the resource, under a row
+// of days
+func timelineText(holds []*api.TimelineHold, from time.Time) string {
+	slots := timelineDays * int(24*time.Hour/timelineSlot)
+	perDay := int(24 * time.Hour / timelineSlot)
+
+	users := []string{}
+	names := map[string]string{}
+	rows := map[string][]bool{}
+	width := 0
+	for _, hold := range holds {
+		row, ok := rows[hold.User]
+		if !ok {
+			row = make([]bool, slots)
+			rows[hold.User] = row
+			users = append(users, hold.User)
+			names[hold.User] = hold.Name
+			if n := utf8.RuneCountInString(hold.Name); n > width {
+				width = n
+			}
+		}
+		for i := range row {
+			start := from.Add(time.Duration(i) * timelineSlot)
+			if hold.Start.Before(start.Add(timelineSlot)) && hold.End.After(start) {
+				row[i] = true
+			}
+		}
+	}
+
+	lines := []string{}
+	days := strings.Repeat(" ", width+1)
+	for d := 0; d < timelineDays; d++ {
+		days += fmt.Sprintf("%-*s", perDay, from.AddDate(0, 0, d).Format("Mon")[:2])
+	}
+	lines = append(lines, strings.TrimRight(days, " "))
+	for _, user := range users {
+		name := names[user]
+		line := name + strings.Repeat(" ", width-utf8.RuneCountInString(name)+1)
+		for _, held := range rows[user] {
+			if held {
+				line += "█"
+			} else {
+				line += "·"
+			}
+		}
+		lines = append(lines, line)
+	}
+	return "```" + strings.Join(lines, "\n") + "```"
+}
+
+// Timeline returns an HTTP handler listing the holds of a resource over the last week, or the number
+// of days given by the days query parameter, up to as long as holds are kept. The resource is given in
+// the path as /<env>/<name>, or /<name> if envs aren't required. With format=svg the holds are drawn as
+// an image, with a row per holder, for browsers and dashboards. Requests must present the secret.
+func (h *Handler) Timeline(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !h.authorizeRequest(w, r, secret, models.ScopeRead) {
+			return
+		}
+
+		res, err := h.parseResource(strings.Join(strings.Split(strings.Trim(r.URL.Path, "/"), "/"), "|"), "")
+		if err != nil || res == nil || res.Name == "" {
+			http.Error(w, "path must be /<env>/<name>", http.StatusBadRequest)
+			return
+		}
+		days := timelineDays
+		if v := r.URL.Query().Get("days"); v != "" {
+			days, err = strconv.Atoi(v)
+			if err != nil || days < 1 || time.Duration(days)*24*time.Hour > models.HoldHistory {
+				http.Error(w, fmt.Sprintf("days must be from 1 to %d", int(models.HoldHistory/(24*time.Hour))), http.StatusBadRequest)
+				return
+			}
+		}
+		q, err := h.data.GetQueueForResource(res.Name, res.Env)
+		if errors.Is(err, e.ResourceDoesNotExist) {
+			http.Error(w, "resource does not exist", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Errorf("%+v", err)
+			http.Error(w, e.Message(err), http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now().UTC()
+		from := now.AddDate(0, 0, -days)
+		holds, err := h.timelineHolds(q, from, now)
+		if err != nil {
+			log.Errorf("%+v", err)
+			http.Error(w, e.Message(err), http.StatusInternalServerError)
+			return
+		}
+
+		ret := &api.TimelineResponse{
+			Resource: q.Resource.String(),
+			From:     from,
+			To:       now,
+			Holds:    []api.TimelineHold{},
+		}
+		for _, hold := range holds {
+			ret.Holds = append(ret.Holds, *hold)
+		}
+		if r.URL.Query().Get("format") == "svg" {
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(timelineSVG(ret)))
+			return
+		}
+		writeJSON(w, ret)
+	})
+}
+
+// timelineSVG draws a timeline as an SVG image, with a row per holder in the order they first held the
+// resource and a line at each midnight, in UTC
+func timelineSVG(t *api.TimelineResponse) string {
+	const (
+		labelWidth = 160
+		chartWidth = 840
+		rowHeight  = 24
+		header     = 24
+	)
+
+	rows := map[string]int{}
+	names := []string{}
+	for _, hold := range t.Holds {
+		if _, ok := rows[hold.User]; !ok {
+			rows[hold.User] = len(names)
+			names = append(names, hold.Name)
+		}
+	}
+	height := header + rowHeight*len(names)
+	span := t.To.Sub(t.From)
+	x := func(at time.Time) float64 {
+		return labelWidth + float64(chartWidth)*float64(at.Sub(t.From))/float64(span)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`, labelWidth+chartWidth, height)
+	fmt.Fprintf(&b, `<title>%s</title>`, escapeXML(t.Resource))
+	for day := time.Date(t.From.Year(), t.From.Month(), t.From.Day()+1, 0, 0, 0, 0, time.UTC); day.Before(t.To); day = day.AddDate(0, 0, 1) {
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="0" x2="%.1f" y2="%d" stroke="#ddd"/>`, x(day), x(day), height)
+		fmt.Fprintf(&b, `<text x="%.1f" y="16">%s</text>`, x(day)+4, day.Format("Mon 2"))
+	}
+	for i, name := range names {
+		fmt.Fprintf(&b, `<text x="4" y="%d">%s</text>`, header+rowHeight*i+16, escapeXML(name))
+	}
+	for _, hold := range t.Holds {
+		color := "#4a90d9"
+		if hold.Current {
+			color = "#e8a33d"
+		}
+		width := x(hold.End) - x(hold.Start)
+		if width < 1 {
+			width = 1
+		}
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s"><title>%s %s to %s</title></rect>`,
+			x(hold.Start), header+rowHeight*rows[hold.User]+4, width, rowHeight-8, color,
+			escapeXML(hold.Name), hold.Start.Format(time.RFC3339), hold.End.Format(time.RFC3339))
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/command"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	unfurlReserveAction = "unfurl_reserve"
+	unfurlReleaseAction = "unfurl_release"
+)
+
+// unfurl shows the state of the resources whose URLs were linked in a message, with buttons to reserve
+// or release them
+func (h *Handler) unfurl(ev *slackevents.LinkSharedEvent) error {
+	resources := h.data.GetResources()
+
+	unfurls := map[string]slack.Attachment{}
+	for _, link := range ev.Links {
+		r := resourceForURL(resources, link.URL)
+		if r == nil {
+			continue
+		}
+		q, err := h.data.GetQueueForResource(r.Name, r.Env)
+		if err != nil {
+			continue
+		}
+		unfurls[link.URL] = h.unfurlAttachment(q)
+	}
+	if len(unfurls) == 0 {
+		return nil
+	}
+
+	_, _, _, err := h.client.UnfurlMessage(ev.Channel, ev.MessageTimeStamp, unfurls)
+	return err
+}
+
+func (h *Handler) unfurlAttachment(q *models.Queue) slack.Attachment {
+	// unfurls are seen by everyone in the channel
+	text := h.queueText(q, false, !q.Resource.Private, time.Local)
+	key := q.Resource.String()
+
+	return slack.Attachment{
+		Fallback: text,
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+				slack.NewActionBlock("",
+					slack.NewButtonBlockElement(unfurlReserveAction, key, slack.NewTextBlockObject(slack.PlainTextType, "Reserve", false, false)),
+					slack.NewButtonBlockElement(unfurlReleaseAction, key, slack.NewTextBlockObject(slack.PlainTextType, "Release", false, false)),
+				),
+			},
+		},
+	}
+}
+
+// unfurlAction handles a click on an unfurl's buttons by running the command it stands for. Replies
+// are posted in the channel of the unfurl.
+func (h *Handler) unfurlAction(cb slack.InteractionCallback, action *slack.BlockAction) error {
+	cmd := &command.Command{
+		Resources: []string{action.Value},
+		Flags:     map[string]string{},
+	}
+	switch action.ActionID {
+	case unfurlReserveAction:
+		cmd.Action = "reserve"
+	case unfurlReleaseAction:
+		cmd.Action = "release"
+	default:
+		return nil
+	}
+
+	ea := &EventAction{
+		Event: &slackevents.MessageEvent{
+			User:       cb.User.ID,
+			Channel:    cb.Channel.ID,
+			SourceTeam: cb.Team.ID,
+			// each click has its own timestamp, so a redelivered click isn't handled twice
+			TimeStamp: action.ActionTs,
+		},
+		Command: cmd,
+	}
+	if !h.authorize(ea) || h.foreignResource(ea) != nil || !h.claimRequest(ea) {
+		return nil
+	}
+
+	var err error
+	if cmd.Action == "reserve" {
+		err = h.reserve(ea)
+	} else {
+		err = h.release(ea)
+	}
+	h.settleRequest(ea, err)
+	return err
+}
+
+// resourceForURL returns the resource whose URL is the link or a parent of it
+func resourceForURL(resources []*models.Resource, link string) *models.Resource {
+	l := normalizeURL(link)
+	for _, r := range resources {
+		if r.URL == "" {
+			continue
+		}
+		u := normalizeURL(r.URL)
+		if l == u || strings.HasPrefix(l, u+"/") || strings.HasPrefix(l, u+"?") || strings.HasPrefix(l, u+"#") {
+			return r
+		}
+	}
+	return nil
+}
+
+// normalizeURL lowercases the scheme and host of a URL and drops any trailing slash, so links to the
+// same page compare equal
+func normalizeURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return strings.TrimSuffix(u.String(), "/")
+}
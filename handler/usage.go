@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// recordUsage records how long a hold lasted and what it cost once it ends. Holds count towards the
+// month they end in. The user is warned if the hold put their team over its budget.
+func (h *Handler) recordUsage(ev events.Event) {
+	res := ev.EndedHold()
+	if res == nil {
+		return
+	}
+	if _, ok := h.advancing.Load(ev.Resource.Key()); ok {
+		// the resource was handed on by a policy before its first holder had a chance to use it
+		return
+	}
+	hours := ev.Time.Sub(res.Time).Hours()
+	if hours <= 0 {
+		return
+	}
+
+	u := &models.Usage{
+		Month:    ev.Time.Format(models.UsageMonthFormat),
+		UserID:   res.User.ID,
+		Resource: ev.Resource.String(),
+		Hours:    hours,
+		Cost:     hours * ev.Resource.Cost,
+	}
+
+	team := h.teams.of(u.UserID)
+	budget := h.teams.budget(team)
+	before := 0.0
+	if budget > 0 && u.Cost > 0 {
+		var err error
+		if before, err = h.teamCost(u.Month, team); err != nil {
+			log.Errorf("%+v", err)
+			budget = 0
+		}
+	}
+
+	if err := h.data.AddUsage(u); err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+
+	if budget > 0 && before < budget && before+u.Cost >= budget {
+		h.notify(res.User, fmt.Sprintf(msgTeamXIsOverBudgetYZ, team, costText(before+u.Cost), costText(budget)))
+	}
+}
+
+// teamCost returns what a team's usage cost in a month
+func (h *Handler) teamCost(month, team string) (float64, error) {
+	usage, err := h.data.GetUsage(month)
+	if err != nil {
+		return 0, err
+	}
+	ret := 0.0
+	for _, u := range usage {
+		if h.teams.of(u.UserID) == team {
+			ret += u.Cost
+		}
+	}
+	return ret, nil
+}
+
+// overBudgetText warns a user reserving resources that cost something when their team is over its
+// budget for the month, or returns an empty string
+func (h *Handler) overBudgetText(u *models.User, resources []*models.Resource) string {
+	costs := false
+	for _, res := range resources {
+		if r := h.data.GetResource(res.Name, res.Env, false); r != nil && r.Cost > 0 {
+			costs = true
+		}
+	}
+	team := h.teams.of(u.ID)
+	budget := h.teams.budget(team)
+	if !costs || budget <= 0 {
+		return ""
+	}
+
+	spent, err := h.teamCost(time.Now().Format(models.UsageMonthFormat), team)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return ""
+	}
+	if spent < budget {
+		return ""
+	}
+	return fmt.Sprintf(msgTeamXIsOverBudgetYZ, team, costText(spent), costText(budget))
+}
+
+func costText(cost float64) string {
+	return fmt.Sprintf("%.2f", cost)
+}
+
+// usageTotal sums hours and cost
+type usageTotal struct {
+	hours float64
+	cost  float64
+}
+
+func (t *usageTotal) add(u *models.Usage) {
+	t.hours += u.Hours
+	t.cost += u.Cost
+}
+
+func (t *usageTotal) String() string {
+	if t.cost == 0 {
+		return fmt.Sprintf("%.1fh", t.hours)
+	}
+	return fmt.Sprintf("%.1fh costing %s", t.hours, costText(t.cost))
+}
+
+// sortedKeys returns the keys of totals, most expensive first, then longest held
+func sortedKeys(totals map[string]*usageTotal) []string {
+	keys := []string{}
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := totals[keys[i]], totals[keys[j]]
+		if a.cost != b.cost {
+			return a.cost > b.cost
+		}
+		if a.hours != b.hours {
+			return a.hours > b.hours
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// usageReport summarizes the usage of a month by team, user and resource. Without a month, the current
+// month is reported.
+func (h *Handler) usageReport(ea *EventAction) error {
+	month := time.Now().Format(models.UsageMonthFormat)
+	if len(ea.Command.Args) > 0 {
+		t, err := time.Parse(models.UsageMonthFormat, ea.Command.Args[0])
+		if err != nil {
+			h.errorReply(ea, msgInvalidMonth)
+			return nil
+		}
+		month = t.Format(models.UsageMonthFormat)
+	}
+
+	usage, err := h.data.GetUsage(month)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, msgIDontKnow)
+		return err
+	}
+	if len(usage) == 0 {
+		return h.reply(ea, fmt.Sprintf(msgNoUsageForX, month), false)
+	}
+
+	teamTotals := map[string]*usageTotal{}
+	userTotals := map[string]map[string]*usageTotal{}
+	resourceTotals := map[string]*usageTotal{}
+	for _, u := range usage {
+		team := h.teams.of(u.UserID)
+		if teamTotals[team] == nil {
+			teamTotals[team] = &usageTotal{}
+			userTotals[team] = map[string]*usageTotal{}
+		}
+		if userTotals[team][u.UserID] == nil {
+			userTotals[team][u.UserID] = &usageTotal{}
+		}
+		if resourceTotals[u.Resource] == nil {
+			resourceTotals[u.Resource] = &usageTotal{}
+		}
+		teamTotals[team].add(u)
+		userTotals[team][u.UserID].add(u)
+		resourceTotals[u.Resource].add(u)
+	}
+
+	lines := []string{fmt.Sprintf("*Usage for %s*", month)}
+	for _, team := range sortedKeys(teamTotals) {
+		line := "No team: " + teamTotals[team].String()
+		if team != "" {
+			line = fmt.Sprintf("@%s: %s", team, teamTotals[team])
+		}
+		if budget := h.teams.budget(team); budget > 0 {
+			line += fmt.Sprintf(" of its %s budget (%.0f%%)", costText(budget), teamTotals[team].cost/budget*100)
+			if teamTotals[team].cost >= budget {
+				line += " :warning:"
+			}
+		}
+		lines = append(lines, line)
+		for _, id := range sortedKeys(userTotals[team]) {
+			name := h.userName(&models.User{ID: id, Name: id})
+			lines = append(lines, fmt.Sprintf("    • %s: %s", name, userTotals[team][id]))
+		}
+	}
+
+	lines = append(lines, "*By resource*")
+	for _, res := range sortedKeys(resourceTotals) {
+		lines = append(lines, fmt.Sprintf("`%s`: %s", res, resourceTotals[res]))
+	}
+
+	return h.reply(ea, strings.Join(lines, "\n"), false)
+}
+
+func setCost(r *models.Resource, value string) (string, error) {
+	if value == "none" {
+		r.Cost = 0
+		return "none", nil
+	}
+	var cost float64
+	if _, err := fmt.Sscanf(value, "%g", &cost); err != nil || cost < 0 {
+		return "", errors.New(msgInvalidCost)
+	}
+	r.Cost = cost
+	return costText(cost) + " per hour", nil
+}
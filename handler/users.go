@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+)
+
+const userCacheTTL = time.Hour
+
+// userCache holds Slack profile data so that names and timezones stay fresh without calling the
+// Slack API for every message. Entries are refreshed from Slack once they are older than the TTL.
+type userCache struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	entries map[string]*userCacheEntry
+}
+
+type userCacheEntry struct {
+	user    *models.User
+	expires time.Time
+}
+
+func newUserCache(ttl time.Duration) *userCache {
+	return &userCache{
+		ttl:     ttl,
+		entries: map[string]*userCacheEntry{},
+	}
+}
+
+// get returns a copy of the cached user, or nil if the user is not cached or has expired
+func (c *userCache) get(id string) *models.User {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok || time.Now().After(e.expires) {
+		return nil
+	}
+	u := *e.user
+	return &u
+}
+
+// set caches a user under an ID, which may be another of their IDs, such as the ID their workspace
+// in an Enterprise Grid org knows them by
+func (c *userCache) set(id string, u *models.User) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cached := *u
+	c.entries[id] = &userCacheEntry{
+		user:    &cached,
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+func (c *userCache) forget(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, id)
+}
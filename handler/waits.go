@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// recordWait records how long a reservation waited in line once it gets a resource, for the averages
+// shown in status and stats. Reservations made before join times were recorded are skipped.
+func (h *Handler) recordWait(r *models.Resource, res *models.Reservation, at time.Time) {
+	if res == nil || res.Joined.IsZero() {
+		return
+	}
+	wait := at.Sub(res.Joined)
+	if wait < 0 {
+		wait = 0
+	}
+	_, err := h.updateResource(r.Name, r.Env, func(r *models.Resource) error {
+		r.AddWait(wait)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// waitText describes how long reservations of a resource have waited, or returns an empty string if none
+// have been recorded
+func waitText(r *models.Resource) string {
+	if len(r.Waits) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" Average wait is %s.", durationText(r.AverageWait()))
+}
+
+// stats lists how long the recent reservations of each resource waited, longest average first, to show
+// where more resources are needed
+func (h *Handler) stats(ea *EventAction) error {
+	resources := []*models.Resource{}
+	for _, r := range h.data.GetResources() {
+		if len(r.Waits) > 0 {
+			resources = append(resources, r)
+		}
+	}
+	if len(resources) == 0 {
+		return h.reply(ea, msgNoWaitsRecorded, false)
+	}
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].AverageWait() > resources[j].AverageWait()
+	})
+
+	msg := fmt.Sprintf("*Waits for the last %d reservations of each resource*\n", models.MaxWaits)
+	for _, r := range resources {
+		var longest time.Duration
+		for _, d := range r.Waits {
+			if d > longest {
+				longest = d
+			}
+		}
+		msg += fmt.Sprintf("• %s: %s on average, %s at most, over %d reservations\n", formatResource(r), durationText(r.AverageWait()), durationText(longest), len(r.Waits))
+	}
+	return h.reply(ea, msg, false)
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// watch tells the user about every change to the queues of resources, without them joining the queues
+func (h *Handler) watch(ea *EventAction) error {
+	resources, err := h.getResourcesFromList(ea.Command.Resources, h.defaultEnv(ea.Event.User))
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	for _, res := range resources {
+		already := false
+		r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+			already = r.IsWatcher(ea.Event.User)
+			if !already {
+				r.Watchers = append(r.Watchers, ea.Event.User)
+			}
+			return nil
+		})
+		if err != nil {
+			h.handleUpdateResourceError(ea, res, err)
+			continue
+		}
+
+		msg := msgYouAreNowWatchingY
+		if already {
+			msg = msgYouAreAlreadyWatchingY
+		}
+		h.reply(ea, fmt.Sprintf(msg, h.resourceText(r)), true)
+	}
+
+	return nil
+}
+
+// unwatch stops telling the user about changes to resources
+func (h *Handler) unwatch(ea *EventAction) error {
+	resources, err := h.getResourcesFromList(ea.Command.Resources, h.defaultEnv(ea.Event.User))
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	for _, res := range resources {
+		watching := false
+		r, err := h.updateResource(res.Name, res.Env, func(r *models.Resource) error {
+			watching = false
+			watchers := []string{}
+			for _, id := range r.Watchers {
+				if id == ea.Event.User {
+					watching = true
+					continue
+				}
+				watchers = append(watchers, id)
+			}
+			r.Watchers = watchers
+			return nil
+		})
+		if err != nil {
+			h.handleUpdateResourceError(ea, res, err)
+			continue
+		}
+
+		msg := msgYouStoppedWatchingY
+		if !watching {
+			msg = msgYouWerentWatchingY
+		}
+		h.reply(ea, fmt.Sprintf(msg, h.resourceText(r)), true)
+	}
+
+	return nil
+}
+
+// notifyWatchers tells the watchers of a resource what changed and how its queue looks now. Watchers
+// aren't told about their own changes, and who is in line for a private resource is only shown to those
+// who may see it.
+func (h *Handler) notifyWatchers(ev events.Event) {
+	if ev.Resource == nil {
+		return
+	}
+	q := &models.Queue{Resource: ev.Resource}
+	if ev.Type != events.ResourcePruned {
+		var err error
+		if q, err = h.data.GetQueueForResource(ev.Resource.Name, ev.Resource.Env); err != nil {
+			return
+		}
+	}
+
+	for _, id := range q.Resource.Watchers {
+		if ev.Reservation != nil && ev.Reservation.User.ID == id {
+			continue
+		}
+		u, err := h.getUser(id)
+		if err != nil {
+			log.Errorf("%+v", err)
+			u = &models.User{ID: id}
+		}
+		h.notify(u, h.watchText(ev, q, h.reveals(q, u, true), u.Location()))
+	}
+}
+
+// watchText describes a change to a watched resource
+func (h *Handler) watchText(ev events.Event, q *models.Queue, reveal bool, loc *time.Location) string {
+	who := func(res *models.Reservation) string {
+		if !reveal || res == nil {
+			return "Someone"
+		}
+		return h.getUserDisplay(res.User, false)
+	}
+
+	text := formatResource(q.Resource)
+	change := ""
+	switch ev.Type {
+	case events.Reserved:
+		change = fmt.Sprintf(msgXJoinedTheQueueForY, who(ev.Reservation), text)
+	case events.Released:
+		change = fmt.Sprintf(msgXLeftTheQueueForY, who(ev.Reservation), text)
+	case events.QueueAdvanced:
+		change = fmt.Sprintf(msgXNowHasY, who(ev.Reservation), text)
+	case events.Transferred:
+		change = fmt.Sprintf(msgXTookOverYFromZ, who(ev.Reservation), text, who(ev.Previous))
+	case events.Labeled:
+		change = fmt.Sprintf(msgXLabeledYZ, who(ev.Reservation), text, ev.Reservation.Label)
+		if ev.Reservation.Label == "" {
+			change = fmt.Sprintf(msgXClearedTheLabelOfY, who(ev.Reservation), text)
+		}
+	case events.Progressed:
+		change = fmt.Sprintf(msgXPostedProgressOnYZ, who(ev.Reservation), text, ev.Reservation.Progress)
+		if ev.Reservation.Progress == "" {
+			change = fmt.Sprintf(msgXClearedProgressOnY, who(ev.Reservation), text)
+		}
+	case events.ResourcePruned:
+		return fmt.Sprintf(msgYWasPrunedNoLongerWatching, text)
+	}
+	return fmt.Sprintf(":eyes: %s. %s", change, h.queueText(q, false, reveal, loc))
+}
@@ -0,0 +1,102 @@
+// Package kube registers Kubernetes namespaces or custom resources as reservebot resources, so the
+// catalog follows the environments that actually exist. It talks to the Kubernetes API directly using
+// the pod's service account.
+package kube
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// Client lists objects from the Kubernetes API
+type Client struct {
+	host   string
+	token  string
+	client *http.Client
+}
+
+// InCluster returns a client authenticated as the service account of the pod it runs in
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountDir + "token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in the service account CA")
+	}
+
+	return &Client{
+		host:  "https://" + net.JoinHostPort(host, port),
+		token: strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	}, nil
+}
+
+type object struct {
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+}
+
+type objectList struct {
+	Items []object `json:"items"`
+}
+
+// list returns the objects at an API path, such as /api/v1/namespaces, that match a label selector
+func (c *Client) list(path, selector string) ([]object, error) {
+	u := c.host + path
+	if selector != "" {
+		u += "?labelSelector=" + url.QueryEscape(selector)
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing %s returned %s", path, resp.Status)
+	}
+
+	l := &objectList{}
+	if err := json.NewDecoder(resp.Body).Decode(l); err != nil {
+		return nil, err
+	}
+	return l.Items, nil
+}
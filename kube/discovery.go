@@ -0,0 +1,115 @@
+package kube
+
+import (
+	"errors"
+
+	"github.com/ameliagapin/reservebot/data"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Source marks resources that were registered by discovery
+	Source = "kubernetes"
+	// EnvLabel on an object overrides the env its resource is registered in
+	EnvLabel = "reservebot/env"
+
+	// maxUpdateAttempts is how many times marking a resource is attempted when it conflicts with a
+	// concurrent change
+	maxUpdateAttempts = 3
+)
+
+// Config describes which objects become resources
+type Config struct {
+	// Path is the API path listing the objects, e.g. /api/v1/namespaces for namespaces or
+	// /apis/example.com/v1/environments for a custom resource
+	Path string
+	// LabelSelector limits which objects are registered, e.g. reservebot=enabled
+	LabelSelector string
+	// Env is the env resources are registered in, unless the object has the EnvLabel
+	Env string
+}
+
+// Discoverer keeps the resources in a data.Manager in step with the objects in a cluster
+type Discoverer struct {
+	client *Client
+	config Config
+	data   data.Manager
+}
+
+func NewDiscoverer(client *Client, config Config, m data.Manager) *Discoverer {
+	return &Discoverer{
+		client: client,
+		config: config,
+		data:   m,
+	}
+}
+
+// Sync registers a resource for every matching object and removes discovered resources whose object is
+// gone. Resources created in Slack are never removed, and discovered resources are kept until their
+// queue is empty.
+func (d *Discoverer) Sync() error {
+	objects, err := d.client.list(d.config.Path, d.config.LabelSelector)
+	if err != nil {
+		return err
+	}
+
+	found := map[string]bool{}
+	for _, o := range objects {
+		env := d.config.Env
+		if l := o.Metadata.Labels[EnvLabel]; l != "" {
+			env = l
+		}
+		name := o.Metadata.Name
+		found[models.ResourceKey(name, env)] = true
+
+		if d.data.GetResource(name, env, false) != nil {
+			continue
+		}
+		if err := d.register(name, env); err != nil {
+			log.Errorf("Error registering %s|%s: %+v", env, name, err)
+			continue
+		}
+		log.Infof("Discovered %s|%s", env, name)
+	}
+
+	for _, q := range d.data.GetQueues() {
+		r := q.Resource
+		if r.Source != Source || found[r.Key()] {
+			continue
+		}
+		if q.HasReservations() {
+			log.Infof("%s is gone from the cluster but still reserved, keeping it until it is released", r)
+			continue
+		}
+		if err := d.data.RemoveResource(r.Name, r.Env); err != nil && !errors.Is(err, e.ResourceDoesNotExist) {
+			log.Errorf("Error removing %s: %+v", r, err)
+			continue
+		}
+		log.Infof("Removed %s, which is gone from the cluster", r)
+	}
+
+	return nil
+}
+
+// register creates a resource and marks it as discovered
+func (d *Discoverer) register(name, env string) error {
+	if err := d.data.Create(name, env); err != nil {
+		return err
+	}
+
+	var err error
+	for i := 0; i < maxUpdateAttempts; i++ {
+		r := d.data.GetResource(name, env, false)
+		if r == nil {
+			return e.ResourceDoesNotExist
+		}
+		r.Source = Source
+		err = d.data.UpdateResource(r)
+		if !errors.Is(err, e.Conflict) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// The scopes of API keys, from least to most access. Each scope allows what the ones before it do.
+const (
+	// ScopeRead keys can read timelines and status
+	ScopeRead = "read"
+	// ScopeReserve keys can also reserve and release resources, such as from CI
+	ScopeReserve = "reserve"
+	// ScopeAdmin keys can call every endpoint, including those that hand resources to others
+	ScopeAdmin = "admin"
+)
+
+// DefaultRateLimit is how many requests an API key may make a minute unless it is given a limit
+const DefaultRateLimit = 60
+
+var scopes = []string{ScopeRead, ScopeReserve, ScopeAdmin}
+
+// APIKey lets a caller of the HTTP API in without an endpoint's secret, with access limited to a scope
+type APIKey struct {
+	ID string
+	// Name describes what the key is for
+	Name  string
+	Scope string
+	// KeyHash is the SHA-256 of the key, hex encoded. The key itself is only shown when it is made.
+	KeyHash string
+	// RateLimit is the most requests the key may make a minute
+	RateLimit int
+	Created   time.Time
+	// CreatedBy is the ID of the admin who made the key
+	CreatedBy string
+}
+
+// Allows returns if the key's scope includes another
+func (k *APIKey) Allows(scope string) bool {
+	return scopeRank(k.Scope) >= scopeRank(scope) && scopeRank(scope) >= 0
+}
+
+// ValidScope returns if a scope is one an API key can have
+func ValidScope(scope string) bool {
+	return scopeRank(scope) >= 0
+}
+
+func scopeRank(scope string) int {
+	for i, s := range scopes {
+		if s == scope {
+			return i
+		}
+	}
+	return -1
+}
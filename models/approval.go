@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// ApprovalRequest is a request to reserve a resource that requires approval. It is kept on the resource
+// until an approver approves it, when it becomes a reservation, or denies it.
+type ApprovalRequest struct {
+	ID       string
+	User     *User
+	Time     time.Time
+	Duration time.Duration
+	Priority int
+	// Deployment and Ticket are linked to the reservation once it is made
+	Deployment string
+	Ticket     *Ticket
+	// Deadline and DropAtDeadline are set on the reservation once it is made
+	Deadline       time.Time
+	DropAtDeadline bool
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// BrokenReport is a report that a resource is broken, kept until it is marked fixed
+type BrokenReport struct {
+	// Reporter is the ID of the user who reported it
+	Reporter string
+	// Details are what the reporter said is wrong
+	Details  string
+	Reported time.Time
+	// Blocking stops the resource from being reserved until it is fixed
+	Blocking bool
+}
@@ -0,0 +1,11 @@
+package models
+
+// Compaction counts what was deleted from storage for being older than the retention period
+type Compaction struct {
+	// Holds are past holds that ended before the cutoff
+	Holds int
+	// Usage is the usage recorded for months that ended before the cutoff, by user and resource
+	Usage int
+	// Snapshots are the snapshots taken before the cutoff
+	Snapshots int
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Contention is how contended a resource was during one hour of the week, in UTC, summed over every week
+// its queue was sampled. Each is measured in hours, so samples taken at different intervals add up.
+type Contention struct {
+	// Resource is formatted as env|name
+	Resource string
+	Weekday  time.Weekday
+	// Hour is the hour of the day, from 0 to 23
+	Hour int
+	// Sampled is how long the queue was sampled for
+	Sampled float64
+	// Contended is how long someone was waiting for the resource
+	Contended float64
+	// Waiting is the number of people waiting multiplied by how long they were waiting, so dividing it
+	// by Sampled gives the average depth of the queue
+	Waiting float64
+}
@@ -0,0 +1,23 @@
+package models
+
+// Forgotten counts what was deleted from storage about a user who asked to be forgotten
+type Forgotten struct {
+	// Holds are past holds recorded for timelines
+	Holds int
+	// Usage is the usage recorded for reports, by month and resource
+	Usage int
+	// Snapshots are the snapshots of queues the user was taken out of
+	Snapshots int
+	// Scheduled are the commands the user had waiting to run later
+	Scheduled int
+	// Notifications are the DMs to the user waiting to be delivered
+	Notifications int
+	// DefaultEnv is set if the user's default env was deleted
+	DefaultEnv bool
+	// StatusFilter is set if the user's saved status filter was deleted
+	StatusFilter bool
+	// MilestonesOff is set if the user's choice not to be told about milestones in line was deleted
+	MilestonesOff bool
+	// Token is set if the Slack token the user granted for status sync was deleted
+	Token bool
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+type HealthCheck struct {
+	URL       string
+	Interval  time.Duration
+	Healthy   bool
+	LastCheck time.Time
+}
+
+// Checked returns if the health check has run at least once
+func (c *HealthCheck) Checked() bool {
+	return !c.LastCheck.IsZero()
+}
+
+// Due returns if the health check should be run at the given time
+func (c *HealthCheck) Due(t time.Time) bool {
+	return !c.LastCheck.Add(c.Interval).After(t)
+}
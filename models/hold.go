@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// HoldHistory is how long holds are remembered after they end
+const HoldHistory = 30 * 24 * time.Hour
+
+// Hold is a past hold of a resource, from when its holder got it until they gave it up
+type Hold struct {
+	User  *User
+	Start time.Time
+	End   time.Time
+	// Joined is when the holder joined the queue for the resource, so how long they waited is Start less
+	// Joined. It is zero for holds recorded before it was.
+	Joined time.Time
+}
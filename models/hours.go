@@ -0,0 +1,193 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// OfficeHours limits a resource to being held during certain hours of certain days
+type OfficeHours struct {
+	// Days lists the days the resource is open
+	Days []time.Weekday
+	// Start and End are the minutes after midnight the resource opens and closes. Hours can't span
+	// midnight.
+	Start int
+	End   int
+	// TZ is the timezone the hours are in
+	TZ string
+	// Open records whether the resource was open when last checked, so opening and closing are only
+	// handled once
+	Open bool
+}
+
+// ParseOfficeHours parses hours formatted like `mon-fri 09:00-18:00`. Days may also be listed like
+// `mon,wed,fri`, or given as `daily`.
+func ParseOfficeHours(days, hours string, loc *time.Location) (*OfficeHours, error) {
+	o := &OfficeHours{
+		TZ: loc.String(),
+	}
+
+	if strings.ToLower(days) == "daily" {
+		days = "sun-sat"
+	}
+	seen := map[time.Weekday]bool{}
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("invalid days %q", part)
+		}
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return nil, fmt.Errorf("invalid day %q", bounds[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			seen[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if seen[d] {
+			o.Days = append(o.Days, d)
+		}
+	}
+
+	bounds := strings.Split(hours, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid hours %q", hours)
+	}
+	var err error
+	if o.Start, err = parseClock(bounds[0]); err != nil {
+		return nil, err
+	}
+	if o.End, err = parseClock(bounds[1]); err != nil {
+		return nil, err
+	}
+	if o.End <= o.Start {
+		return nil, fmt.Errorf("hours must end after they start")
+	}
+
+	return o, nil
+}
+
+// parseClock returns the minutes after midnight of a time like 09:30
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Location returns the timezone of the hours
+func (o *OfficeHours) Location() *time.Location {
+	loc, err := time.LoadLocation(o.TZ)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// IsOpen returns whether the given time is within the hours
+func (o *OfficeHours) IsOpen(t time.Time) bool {
+	t = t.In(o.Location())
+	if !o.openOn(t.Weekday()) {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	return m >= o.Start && m < o.End
+}
+
+// NextOpen returns when the hours next begin after the given time. If it is open at the given time,
+// the time is returned.
+func (o *OfficeHours) NextOpen(t time.Time) time.Time {
+	if o.IsOpen(t) {
+		return t
+	}
+	return o.next(t, o.Start)
+}
+
+// NextClose returns when the hours next end after the given time
+func (o *OfficeHours) NextClose(t time.Time) time.Time {
+	return o.next(t, o.End)
+}
+
+// LastClose returns when the hours last ended at or before the given time
+func (o *OfficeHours) LastClose(t time.Time) time.Time {
+	t = t.In(o.Location())
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, -i)
+		c := time.Date(day.Year(), day.Month(), day.Day(), o.End/60, o.End%60, 0, 0, t.Location())
+		if o.openOn(c.Weekday()) && !c.After(t) {
+			return c
+		}
+	}
+	return time.Time{}
+}
+
+// next returns the first time after t that is the given minutes after midnight on an open day
+func (o *OfficeHours) next(t time.Time, minutes int) time.Time {
+	t = t.In(o.Location())
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		c := time.Date(day.Year(), day.Month(), day.Day(), minutes/60, minutes%60, 0, 0, t.Location())
+		if o.openOn(c.Weekday()) && c.After(t) {
+			return c
+		}
+	}
+	return time.Time{}
+}
+
+func (o *OfficeHours) openOn(d time.Weekday) bool {
+	for _, day := range o.Days {
+		if day == d {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the hours like `Mon-Fri 09:00-18:00 Europe/Berlin`
+func (o *OfficeHours) String() string {
+	names := []string{}
+	if len(o.Days) == 7 {
+		names = append(names, "Daily")
+	}
+	for i := 0; i < len(o.Days) && len(names) == 0; {
+		// collapse consecutive days into ranges
+		j := i
+		for j+1 < len(o.Days) && o.Days[j+1] == o.Days[j]+1 {
+			j++
+		}
+		first := o.Days[i].String()[:3]
+		switch {
+		case j == i:
+			names = append(names, first)
+		case j == i+1:
+			names = append(names, first, o.Days[j].String()[:3])
+		default:
+			names = append(names, first+"-"+o.Days[j].String()[:3])
+		}
+		i = j + 1
+	}
+
+	return fmt.Sprintf("%s %02d:%02d-%02d:%02d %s", strings.Join(names, ","), o.Start/60, o.Start%60, o.End/60, o.End%60, o.TZ)
+}
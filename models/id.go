@@ -0,0 +1,16 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// NewID returns a random identifier suitable for showing to users
+func NewID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails if the OS can't provide randomness, which leaves nothing to fall back on
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
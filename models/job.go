@@ -0,0 +1,15 @@
+package models
+
+// Job is a CI job that holds a reservation
+type Job struct {
+	// Provider is the CI system, e.g. gitlab
+	Provider string
+	ID       string
+	URL      string
+	Project  string
+	Ref      string
+	// User is the person who triggered the pipeline, as the CI system knows them
+	User string
+	// Reason describes what the job is doing, for jobs that aren't part of a pipeline
+	Reason string
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// LiveStatus is a message pinned in a channel that the bot keeps up to date with the status of every
+// resource, so that no one has to ask for it
+type LiveStatus struct {
+	Channel string
+	// Timestamp identifies the message in the channel
+	Timestamp string
+	// Workspace is the Enterprise Grid workspace the message was asked for in, if any, which limits it to
+	// the resources that may be used there
+	Workspace string
+	Created   time.Time
+}
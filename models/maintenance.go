@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+type MaintenanceWindow struct {
+	Start  time.Time
+	End    time.Time
+	Reason string
+	Warned bool
+}
+
+// Active returns if the window covers the given time
+func (w *MaintenanceWindow) Active(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
@@ -0,0 +1,11 @@
+package models
+
+// Migration counts what was moved from the legacy storage format
+type Migration struct {
+	// Resources are the resources that were in the legacy format
+	Resources int
+	// Reservations are the reservations that were in the legacy format
+	Reservations int
+	// Skipped are the legacy reservations that were missing their user or resource, so were dropped
+	Skipped int
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// BufferedNotification is a DM that couldn't be delivered while Slack was out of reach, kept to be
+// delivered once it is back
+type BufferedNotification struct {
+	UserID  string
+	Message string
+	// Queued is when the DM was first meant to be delivered
+	Queued time.Time
+}
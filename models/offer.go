@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// Offer is a holder's offer to give up a resource early to whoever waiting for it takes it first
+type Offer struct {
+	ID string
+	// UserID is the ID of the holder who made the offer
+	UserID string
+	Time   time.Time
+}
+
+// Open returns if the offer still stands for the current holder's reservation at the given time. An
+// offer lapses when its holder no longer holds the resource, or once it is older than the expiry.
+func (o *Offer) Open(holder *Reservation, expiry time.Duration, t time.Time) bool {
+	return o != nil && holder != nil && holder.User.ID == o.UserID && !o.Time.Before(holder.Time) && t.Sub(o.Time) < expiry
+}
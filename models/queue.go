@@ -8,3 +8,9 @@ type Queue struct {
 func (q *Queue) HasReservations() bool {
 	return len(q.Reservations) > 0
 }
+
+// Resetting returns if the resource is being reset after its holder released it. They keep it until the
+// reset is done.
+func (q *Queue) Resetting() bool {
+	return q.Resource.Resetting() && q.HasReservations() && q.Reservations[0].ID == q.Resource.Reset.Reservation
+}
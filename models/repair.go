@@ -0,0 +1,11 @@
+package models
+
+// Repair is a fix made to a queue that was found to be inconsistent
+type Repair struct {
+	// Resource is the resource whose queue was fixed, as env|name
+	Resource string
+	// UserID is the user who was in the queue more than once
+	UserID string
+	// Removed is how many of the user's entries were removed, keeping their earliest place
+	Removed int
+}
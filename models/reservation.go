@@ -5,7 +5,81 @@ import (
 )
 
 type Reservation struct {
+	// ID uniquely identifies the reservation. Reservations made before IDs were introduced have none.
+	ID       string
 	User     *User
 	Resource *Resource
+	// Time is when the reservation joined the queue, updated to when it got the resource once it does
 	Time     time.Time
+	Duration time.Duration
+	// Joined is when the reservation joined the queue, or was last moved to the back of it. It is zero
+	// for reservations made before it was recorded.
+	Joined time.Time
+	// Deployment identifies a deployment the reservation is held for. The reservation is released
+	// when the deployment is reported finished.
+	Deployment string
+	// Ticket is the work the reservation is held for, if the user linked one
+	Ticket *Ticket
+	// Job is the CI job holding the reservation, if it was made through the API by a pipeline
+	Job *Job
+	// Label is the state the holder says the resource is in, such as deploying, testing or broken
+	Label string
+	// Progress is the last note the holder posted on how their work with the resource is going, such as
+	// "tests running, 10 more minutes"
+	Progress string
+	// ProgressAt is when the holder posted their last progress note
+	ProgressAt time.Time
+	// Priority orders the reservation among others for resources with the priority policy, where higher
+	// goes first
+	Priority int
+	// Deadline is when the user needs the resource by, if they gave a time while waiting for it
+	Deadline time.Time
+	// DropAtDeadline removes the reservation from the queue if the deadline passes before it gets the
+	// resource
+	DropAtDeadline bool
+	// DeadlineWarned is set once the user was warned that they are unlikely to get the resource by the
+	// deadline
+	DeadlineWarned bool
+	// Checked are the items of the resource's checklist the holder checked off when releasing it
+	Checked []string
+	// StaleFlagged is set once the hold was flagged as stale, until activity is reported on the resource
+	StaleFlagged bool
+	// Incident is the incident the reservation was made for, if it pre-empted the holder of the resource.
+	// Such holds are never rotated or flagged as stale.
+	Incident string
+	// RotationWarned is when the holder was warned that their turn is ending because others are
+	// waiting
+	RotationWarned time.Time
+	// ClaimBy is when the holder has to claim the resource by, if they were away when it was handed to
+	// them. It is zero once they claim it.
+	ClaimBy time.Time
+	// AbandonVotes are the users waiting in line who reacted to a status message to say the holder
+	// abandoned the resource
+	AbandonVotes []string
+	// AbandonBy is when the reservation is released unless the holder says they are still using the
+	// resource, once enough of those waiting voted it abandoned. It is zero otherwise.
+	AbandonBy time.Time
+	// Milestones are the milestones in line the user was told they reached while waiting, such as `top 3`
+	// or `15m`
+	Milestones []string
+	// MilestonesNoted is set once the milestones the user had already reached when they joined the line
+	// were noted, so that they are only told about those they reach later
+	MilestonesNoted bool
+
+	// Version is incremented on every write so that concurrent modifications can be detected
+	Version int64
+}
+
+// Expires returns when the reservation ends once it holds the resource. The zero time is returned if
+// the reservation has no duration.
+func (r *Reservation) Expires() time.Time {
+	if r.Duration <= 0 {
+		return time.Time{}
+	}
+	return r.Time.Add(r.Duration)
+}
+
+// Expired returns if the reservation has a duration that has run out by the given time
+func (r *Reservation) Expired(t time.Time) bool {
+	return r.Duration > 0 && !r.Expires().After(t)
 }
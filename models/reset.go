@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// ResetHook resets a resource when its holder releases it, such as by triggering a job that restores its
+// database. The next person in line only gets the resource once the reset succeeded.
+type ResetHook struct {
+	// URL is posted to when the holder releases the resource
+	URL string
+	// Started is when the pending reset started, or zero if there is none
+	Started time.Time
+	// Reservation is the ID of the released reservation the pending reset is for
+	Reservation string
+}
+
+// Pending returns if a reset was started and hasn't finished
+func (h *ResetHook) Pending() bool {
+	return !h.Started.IsZero()
+}
@@ -9,8 +9,79 @@ type Resource struct {
 	Name         string
 	Env          string
 	LastActivity time.Time
+	Maintenance  []*MaintenanceWindow
+	HealthCheck  *HealthCheck
+	Emoji        string
+	// Hours limits when the resource can be held, if set
+	Hours *OfficeHours
+	// Description says what the resource is, if it was given one
+	Description string
+	// URL links to the resource, such as its dashboard. Links to it are unfurled in Slack.
+	URL string
+	// Signaled is when an integration last reported activity on the resource, such as a deploy or an
+	// SSH login
+	Signaled time.Time
+	// SignalSource is what reported the last activity, if it said
+	SignalSource string
+	// Source records what registered the resource, e.g. kubernetes. It is empty for resources created
+	// in Slack.
+	Source string
+	// Workspace is the ID of the Enterprise Grid workspace the resource is kept to, if resources are kept
+	// to the workspaces they were created in. It is empty for resources shared across the org.
+	Workspace string
+
+	// DefaultDuration is applied to reservations that do not specify a duration
+	DefaultDuration time.Duration
+	// Rotation limits how long a user may hold the resource while others are waiting, after which
+	// they are moved to the back of the queue
+	Rotation time.Duration
+	// Approvers are the IDs of the users who must approve reservations of the resource, if any
+	Approvers []string
+	// Requests are the reservations awaiting approval
+	Requests []*ApprovalRequest
+	// Offer is the holder's offer to give the resource up early, if they made one
+	Offer *Offer
+	// Reset is called when the holder releases the resource, if set
+	Reset *ResetHook
+	// Conflicts are the keys of the resources that can't safely be used at the same time as this one, such
+	// as a load test rig and the env it loads. A conflict set on either resource applies to both.
+	Conflicts []string
+	// Checklist is what the holder must check off before releasing the resource, such as resetting its
+	// database, if anything
+	Checklist []string
+	// Policy names the policy deciding who gets the resource next. It is empty for FIFO.
+	Policy string
+	// Lottery pools the reservations made within this long of the resource becoming free, then draws
+	// its holder from them instead of giving it to whoever was first
+	Lottery time.Duration
+	// DrawAt is when the pending draw for the resource is held, if there is one
+	DrawAt time.Time
+	// Cost is what holding the resource costs per hour, counted against the holder's team budget
+	Cost float64
+	// Private hides who holds and waits for the resource from everyone but admins and the people in line
+	Private bool
+	// Incident marks the resource as needed for incidents. Declaring a severe enough incident hands it to
+	// the incident commander.
+	Incident bool
+	// Owner is the ID of the user who owns the resource, usually whoever created it. Commands can be
+	// restricted to owners and admins.
+	Owner string
+	// Watchers are the IDs of the users who are told about every change to the resource's queue without
+	// being in it
+	Watchers []string
+	// Broken is the report that the resource is broken, until it is marked fixed
+	Broken *BrokenReport
+	// Waits are how long the most recent reservations waited in line before they got the resource,
+	// oldest first. Up to MaxWaits are kept.
+	Waits []time.Duration
+
+	// Version is incremented on every write so that concurrent modifications can be detected
+	Version int64
 }
 
+// MaxWaits is how many of the most recent waits a resource keeps for its average
+const MaxWaits = 50
+
 func ResourceKey(name, env string) string {
 	return fmt.Sprintf("%s_%s", env, name)
 }
@@ -25,3 +96,157 @@ func (r *Resource) String() string {
 	}
 	return r.Name
 }
+
+// Copy returns a deep copy of the resource
+func (r *Resource) Copy() *Resource {
+	c := *r
+	c.Maintenance = nil
+	for _, w := range r.Maintenance {
+		window := *w
+		c.Maintenance = append(c.Maintenance, &window)
+	}
+	if r.HealthCheck != nil {
+		check := *r.HealthCheck
+		c.HealthCheck = &check
+	}
+	c.Approvers = append([]string(nil), r.Approvers...)
+	c.Watchers = append([]string(nil), r.Watchers...)
+	c.Checklist = append([]string(nil), r.Checklist...)
+	c.Conflicts = append([]string(nil), r.Conflicts...)
+	c.Waits = append([]time.Duration(nil), r.Waits...)
+	c.Requests = nil
+	for _, req := range r.Requests {
+		request := *req
+		c.Requests = append(c.Requests, &request)
+	}
+	if r.Reset != nil {
+		reset := *r.Reset
+		c.Reset = &reset
+	}
+	if r.Offer != nil {
+		offer := *r.Offer
+		c.Offer = &offer
+	}
+	if r.Broken != nil {
+		broken := *r.Broken
+		c.Broken = &broken
+	}
+	if r.Hours != nil {
+		hours := *r.Hours
+		hours.Days = append([]time.Weekday(nil), r.Hours.Days...)
+		c.Hours = &hours
+	}
+	return &c
+}
+
+// AddWait records how long a reservation waited for the resource, forgetting the oldest wait once more
+// than MaxWaits are kept
+func (r *Resource) AddWait(d time.Duration) {
+	r.Waits = append(r.Waits, d)
+	if len(r.Waits) > MaxWaits {
+		r.Waits = r.Waits[len(r.Waits)-MaxWaits:]
+	}
+}
+
+// AverageWait returns the average of the recorded waits, or 0 if there are none
+func (r *Resource) AverageWait() time.Duration {
+	if len(r.Waits) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range r.Waits {
+		total += d
+	}
+	return total / time.Duration(len(r.Waits))
+}
+
+// Closed returns if the resource has office hours that don't cover the given time
+func (r *Resource) Closed(t time.Time) bool {
+	return r.Hours != nil && !r.Hours.IsOpen(t)
+}
+
+// IsApprover returns if the user may approve reservations of the resource
+func (r *Resource) IsApprover(userID string) bool {
+	for _, id := range r.Approvers {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWatcher returns if the user watches the resource
+func (r *Resource) IsWatcher(userID string) bool {
+	for _, id := range r.Watchers {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Request returns the user's request awaiting approval, if any
+func (r *Resource) Request(userID string) *ApprovalRequest {
+	for _, req := range r.Requests {
+		if req.User.ID == userID {
+			return req
+		}
+	}
+	return nil
+}
+
+// Drawing returns if the holder of the resource is yet to be drawn. Until then, nobody holds it.
+func (r *Resource) Drawing() bool {
+	return !r.DrawAt.IsZero()
+}
+
+// Resetting returns if a reset of the resource is pending
+func (r *Resource) Resetting() bool {
+	return r.Reset != nil && r.Reset.Pending()
+}
+
+// Unhealthy returns if the resource has a health check that last reported a failure
+func (r *Resource) Unhealthy() bool {
+	return r.HealthCheck != nil && r.HealthCheck.Checked() && !r.HealthCheck.Healthy
+}
+
+// BlockedAsBroken returns if the resource was reported broken by a report that stops it from being
+// reserved
+func (r *Resource) BlockedAsBroken() bool {
+	return r.Broken != nil && r.Broken.Blocking
+}
+
+// ActiveMaintenance returns the maintenance window in effect at the given time, if any
+func (r *Resource) ActiveMaintenance(t time.Time) *MaintenanceWindow {
+	for _, w := range r.Maintenance {
+		if w.Active(t) {
+			return w
+		}
+	}
+	return nil
+}
+
+// NextMaintenance returns the earliest maintenance window that has not yet ended
+func (r *Resource) NextMaintenance(t time.Time) *MaintenanceWindow {
+	var next *MaintenanceWindow
+	for _, w := range r.Maintenance {
+		if !w.End.After(t) {
+			continue
+		}
+		if next == nil || w.Start.Before(next.Start) {
+			next = w
+		}
+	}
+	return next
+}
+
+// ExpireMaintenance drops maintenance windows that ended before the given time
+func (r *Resource) ExpireMaintenance(t time.Time) {
+	windows := []*MaintenanceWindow{}
+	for _, w := range r.Maintenance {
+		if w.End.After(t) {
+			windows = append(windows, w)
+		}
+	}
+	r.Maintenance = windows
+}
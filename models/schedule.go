@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// ScheduledAction is a command to run as a user at a later time, such as joining a queue while they are
+// in meetings
+type ScheduledAction struct {
+	ID     string
+	UserID string
+	// Text is the command as the user gave it
+	Text string
+	// Resources are the resources the command names, as env|name, so that it can be cancelled by any of
+	// them
+	Resources []string
+	// Channel, ChannelType, Thread and Workspace are where the command was given, which is where it is
+	// answered when it runs
+	Channel     string
+	ChannelType string
+	Thread      string
+	Workspace   string
+	// At is when the command runs
+	At      time.Time
+	Created time.Time
+}
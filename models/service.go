@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// ServiceAccountPrefix starts the user IDs of service accounts
+const ServiceAccountPrefix = "service:"
+
+// ServiceAccount is a non-human holder of reservations, such as a deploy pipeline or a cron task,
+// registered by an admin. It reserves resources through the API with its own token.
+type ServiceAccount struct {
+	Name string
+	// TokenHash is the SHA-256 of the account's token, hex encoded. The token itself is only shown when
+	// it is made.
+	TokenHash string
+	Created   time.Time
+	// CreatedBy is the ID of the admin who registered the account
+	CreatedBy string
+}
+
+// User returns the user that holds the account's reservations
+func (a *ServiceAccount) User() *User {
+	return &User{
+		ID:       ServiceAccountPrefix + a.Name,
+		Name:     a.Name,
+		External: true,
+		Service:  true,
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// Snapshot is a copy of a resource's queue, taken before a risky operation so that it can be restored
+// afterwards, possibly to a resource recreated under a new name
+type Snapshot struct {
+	// Resource is the resource the queue was taken from, formatted as env|name
+	Resource string
+	// Taken is when the snapshot was taken
+	Taken time.Time
+	// By is the ID of the user who took it
+	By string
+	// Reservations are the holder and everyone waiting, in order
+	Reservations []*Reservation
+}
+
+// Forget takes a user out of the snapshot, returning whether they were in it
+func (s *Snapshot) Forget(userID string) bool {
+	found := false
+	kept := []*Reservation{}
+	for _, r := range s.Reservations {
+		if r.User != nil && r.User.ID == userID {
+			found = true
+			continue
+		}
+		kept = append(kept, r)
+	}
+	s.Reservations = kept
+	if s.By == userID {
+		s.By = ""
+		found = true
+	}
+	return found
+}
@@ -0,0 +1,10 @@
+package models
+
+// Ticket is a ticket in an issue tracker that a reservation is linked to
+type Ticket struct {
+	ID string
+	// URL links to the ticket, if a URL pattern or issue tracker is configured
+	URL string
+	// Summary is the ticket's title, if it was looked up in the issue tracker
+	Summary string
+}
@@ -0,0 +1,15 @@
+package models
+
+// UsageMonthFormat formats the month that usage is recorded for
+const UsageMonthFormat = "2006-01"
+
+// Usage is how long a user held a resource within a month, and what it cost
+type Usage struct {
+	// Month is formatted with UsageMonthFormat
+	Month  string
+	UserID string
+	// Resource is formatted as env|name
+	Resource string
+	Hours    float64
+	Cost     float64
+}
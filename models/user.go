@@ -1,6 +1,31 @@
 package models
 
+import (
+	"time"
+)
+
 type User struct {
 	Name string
 	ID   string
+	// External users, such as CI jobs, are not Slack users. They can't be mentioned or sent DMs.
+	External bool
+	// Service is set for the external users of service accounts
+	Service bool
+
+	// Profile data is refreshed from Slack and is not persisted with reservations
+	DisplayName string `json:"-"`
+	Email       string `json:"-"`
+	TZ          string `json:"-"`
+}
+
+// Location returns the user's timezone, falling back to the local timezone if it is unknown
+func (u *User) Location() *time.Location {
+	if u.TZ == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(u.TZ)
+	if err != nil {
+		return time.Local
+	}
+	return loc
 }
@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// lottery gives the resource to someone in line at random. A user's chance is weighted by how little
+// they held the resource within the usage window: someone who held it for an hour is half as likely to
+// win as someone who didn't hold it at all.
+type lottery struct {
+	fifo
+	usage *Usage
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+// NewLottery returns the lottery policy, which draws are held with regardless of the resource's policy
+func NewLottery(usage *Usage) Policy {
+	return &lottery{
+		usage: usage,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (p *lottery) Next(r *models.Resource, line []*models.Reservation) *models.Reservation {
+	if len(line) == 0 {
+		return nil
+	}
+
+	weights := make([]float64, len(line))
+	total := 0.0
+	for i, res := range line {
+		weights[i] = 1 / (1 + p.usage.Held(res.User.ID, r.Key()).Hours())
+		total += weights[i]
+	}
+
+	p.lock.Lock()
+	n := p.rand.Float64() * total
+	p.lock.Unlock()
+
+	for i, w := range weights {
+		if n < w {
+			return line[i]
+		}
+		n -= w
+	}
+	return line[len(line)-1]
+}
+
+func (*lottery) Description() string {
+	return "a draw favoring whoever has used it least lately"
+}
@@ -0,0 +1,141 @@
+// Package policy decides the order in which the users waiting for a resource get it. Each resource
+// follows the policy chosen in its settings, or FIFO if none was chosen.
+package policy
+
+import (
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// Names of the policies, as used in settings
+const (
+	FIFO       = "fifo"
+	FairShare  = "fair-share"
+	Lottery    = "lottery"
+	Priority   = "priority"
+	RoundRobin = "round-robin"
+)
+
+// Names lists the policies a resource can follow
+var Names = []string{FIFO, FairShare, Lottery, Priority, RoundRobin}
+
+// DefaultTurn is how long turns last on round-robin resources that don't set a rotation
+const DefaultTurn = time.Hour
+
+// Policy decides who gets a resource next
+type Policy interface {
+	// Next chooses who holds the resource once it frees up, from those in line in the order they
+	// reserved it
+	Next(r *models.Resource, line []*models.Reservation) *models.Reservation
+	// Turn is how long a holder may keep the resource while others are waiting, or 0 for as long as
+	// they like
+	Turn(r *models.Resource) time.Duration
+	// Description explains how the next holder is chosen, for status
+	Description() string
+}
+
+// For returns the policy a resource follows. Policies that favor those who used the resource least
+// consult usage.
+func For(r *models.Resource, usage *Usage) Policy {
+	switch r.Policy {
+	case FairShare:
+		return fairShare{usage: usage}
+	case Lottery:
+		return NewLottery(usage)
+	case Priority:
+		return priority{}
+	case RoundRobin:
+		return roundRobin{}
+	}
+	return fifo{}
+}
+
+// Valid returns if name is a known policy
+func Valid(name string) bool {
+	for _, n := range Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fifo gives the resource to whoever has waited longest
+type fifo struct{}
+
+func (fifo) Next(r *models.Resource, line []*models.Reservation) *models.Reservation {
+	if len(line) == 0 {
+		return nil
+	}
+	return line[0]
+}
+
+func (fifo) Turn(r *models.Resource) time.Duration {
+	return r.Rotation
+}
+
+func (fifo) Description() string {
+	return "whoever has waited longest"
+}
+
+// priority gives the resource to whoever reserved it with the highest priority, then whoever has
+// waited longest
+type priority struct {
+	fifo
+}
+
+func (priority) Next(r *models.Resource, line []*models.Reservation) *models.Reservation {
+	var ret *models.Reservation
+	for _, res := range line {
+		if ret == nil || res.Priority > ret.Priority {
+			ret = res
+		}
+	}
+	return ret
+}
+
+func (priority) Description() string {
+	return "highest priority first"
+}
+
+// fairShare gives the resource to whoever held it least within the usage window, then whoever has
+// waited longest
+type fairShare struct {
+	fifo
+	usage *Usage
+}
+
+func (p fairShare) Next(r *models.Resource, line []*models.Reservation) *models.Reservation {
+	var ret *models.Reservation
+	var least time.Duration
+	for _, res := range line {
+		held := p.usage.Held(res.User.ID, r.Key())
+		if ret == nil || held < least {
+			ret = res
+			least = held
+		}
+	}
+	return ret
+}
+
+func (fairShare) Description() string {
+	return "whoever has used it least lately"
+}
+
+// roundRobin takes turns: the holder goes to the back of the line once their turn is up, and the
+// resource goes to whoever has waited longest
+type roundRobin struct {
+	fifo
+}
+
+func (roundRobin) Turn(r *models.Resource) time.Duration {
+	if r.Rotation > 0 {
+		return r.Rotation
+	}
+	return DefaultTurn
+}
+
+func (roundRobin) Description() string {
+	return "whoever has waited longest, taking turns"
+}
@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/events"
+)
+
+// UsageWindow is how far back holds count against a user
+const UsageWindow = 7 * 24 * time.Hour
+
+// hold is a finished turn holding a resource
+type hold struct {
+	user     string
+	ended    time.Time
+	duration time.Duration
+}
+
+// Usage remembers how long users recently held each resource, so that policies can favor those who
+// have used it least. It is kept in memory, so it starts empty whenever the bot restarts.
+type Usage struct {
+	lock   sync.Mutex
+	window time.Duration
+	holds  map[string][]hold
+}
+
+// NewUsage returns usage that remembers holds that ended within the window
+func NewUsage(window time.Duration) *Usage {
+	return &Usage{
+		window: window,
+		holds:  map[string][]hold{},
+	}
+}
+
+// Handle is the usage's subscriber. It notes the end of a turn when a holder leaves the front of a
+// queue.
+func (u *Usage) Handle(ev events.Event) {
+	res := ev.EndedHold()
+	if res == nil {
+		return
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	key := ev.Resource.Key()
+	u.holds[key] = append(u.prune(key, ev.Time), hold{
+		user:     res.User.ID,
+		ended:    ev.Time,
+		duration: ev.Time.Sub(res.Time),
+	})
+}
+
+// Held returns how long a user held a resource within the window
+func (u *Usage) Held(userID, key string) time.Duration {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	var ret time.Duration
+	for _, h := range u.prune(key, time.Now()) {
+		if h.user == userID {
+			ret += h.duration
+		}
+	}
+	return ret
+}
+
+// Forget drops the holds of a user, so that they no longer count against them
+func (u *Usage) Forget(userID string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	for key, holds := range u.holds {
+		kept := []hold{}
+		for _, h := range holds {
+			if h.user != userID {
+				kept = append(kept, h)
+			}
+		}
+		u.holds[key] = kept
+	}
+}
+
+// prune drops the holds of a resource that ended before the window. The lock must be held.
+func (u *Usage) prune(key string, now time.Time) []hold {
+	holds := u.holds[key]
+	for len(holds) > 0 && now.Sub(holds[0].ended) > u.window {
+		holds = holds[1:]
+	}
+	u.holds[key] = holds
+	return holds
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/handler"
+	"github.com/ameliagapin/reservebot/handler/slacktest"
+	"github.com/ameliagapin/reservebot/util"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// maxPayloadSize is the largest Events API payload that can be replayed
+const maxPayloadSize = 1024 * 1024
+
+// mentionPattern matches the user mentions in a message
+var mentionPattern = regexp.MustCompile(`<@(\w+)(\|[^>]*)?>`)
+
+// recorder appends Events API payloads to a file, one per line, so that they can be replayed
+type recorder struct {
+	lock sync.Mutex
+	f    *os.File
+}
+
+func newRecorder(path string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{f: f}, nil
+}
+
+func (r *recorder) record(payload json.RawMessage) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, err := r.f.Write(append(append([]byte{}, payload...), '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "Error recording event: %+v\n", err)
+	}
+}
+
+// replay feeds recorded Events API payloads through the handler, printing each message and what the bot
+// replied, including DMs. Nothing is sent to Slack: the users in the events are made up as they are
+// seen, and background jobs such as expiring holds don't run. The backend and handler are configured by
+// the same flags as the bot, so replaying against Redis changes its data.
+func replay(path string) error {
+	if path == "" {
+		return errors.New("usage: reservebot [flags] replay <events.jsonl>")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var d data.Manager
+	d = data.NewMemory()
+	if useRedis {
+		d = data.NewRedis(redisAddr, redisPass, redisDB)
+	}
+	bus := events.NewBus()
+	d = events.NewManager(d, bus)
+
+	client := slacktest.New()
+	h := handler.New(client, d, nil, reqResourceEnv, util.ParseAdmins(admins), nil, blockUnhealthy)
+	bus.Subscribe(h.HandleEvent)
+	if permissions != "" {
+		if err := h.LoadPermissions(permissions); err != nil {
+			return err
+		}
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxPayloadSize)
+	line, replayed, failed := 0, 0, 0
+	seen := map[string]bool{}
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		ev, err := slackevents.ParseEvent(json.RawMessage(scanner.Bytes()), slackevents.OptionNoVerifyToken())
+		if err != nil {
+			fmt.Printf("! line %d: %+v\n", line, err)
+			failed++
+			continue
+		}
+
+		user, channel, text := "", "", ""
+		switch inner := ev.InnerEvent.Data.(type) {
+		case *slackevents.AppMentionEvent:
+			user, channel, text = inner.User, inner.Channel, inner.Text
+		case *slackevents.MessageEvent:
+			user, channel, text = inner.User, inner.Channel, inner.Text
+		case *slackevents.LinkSharedEvent:
+			user, channel = inner.User, inner.Channel
+		}
+		ids := []string{user}
+		for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+			ids = append(ids, m[1])
+		}
+		for _, id := range ids {
+			if id != "" && !seen[id] {
+				// users are named by their IDs, as their profiles aren't recorded
+				client.AddUser(id, id, id, "UTC")
+				seen[id] = true
+			}
+		}
+
+		fmt.Printf("> %d %s in %s: %s\n", line, user, channel, text)
+		if err := h.CallbackEvent(ev); err != nil {
+			fmt.Printf("! line %d: %+v\n", line, err)
+			failed++
+		}
+		h.FlushNotifications()
+		for _, m := range client.Messages() {
+			fmt.Printf("< %s: %s\n", m.Channel, m.Text)
+		}
+		for _, u := range client.Unfurls() {
+			for link, a := range u.Unfurls {
+				fmt.Printf("< %s: unfurled %s as %s\n", u.Channel, link, a.Fallback)
+			}
+		}
+		client.Reset()
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Replayed %d events, %d failed\n", replayed, failed)
+	return nil
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	_ "expvar"
 	"flag"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"time"
 
+	httpapi "github.com/ameliagapin/reservebot/api"
 	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/events"
+	"github.com/ameliagapin/reservebot/gql"
 	"github.com/ameliagapin/reservebot/handler"
+	"github.com/ameliagapin/reservebot/kube"
+	"github.com/ameliagapin/reservebot/tickets"
 	"github.com/ameliagapin/reservebot/util"
 	log "github.com/sirupsen/logrus"
 	"github.com/slack-go/slack"
@@ -22,14 +31,91 @@ var (
 	listenPort     int
 	debug          bool
 	admins         string
+	adminGroups    string
+	adminSync      int
+	observers      string
+	teams          string
+	freeAlerts     string
+	deadlineChan   string
+	permissions    string
+	readOnly       bool
+	isolateWs      bool
+	recordPath     string
+	eventQueue     int
+	dmBuffer       int
+	maxEventAge    int
+	faultRate      float64
+	faultLatency   int
+	faultOps       string
 	reqResourceEnv bool
 	pruneEnabled   bool
 	pruneInterval  int
 	pruneExpire    int
+	retentionDays  int
+	integrityMins  int
 	redisAddr      string
 	redisPass      string
 	redisDB        int
+	replicaAddr    string
+	replicaLag     int
+	stateKeys      string
+	stateKeysCmd   string
 	useRedis       bool
+	maintWarning   int
+	blockUnhealthy bool
+	webhookURL     string
+	deploySecret   string
+	resetSecret    string
+	incidentSecret string
+	incidentSev    int
+	activitySecret string
+	staleAfter     int
+	staleChan      string
+	brokenChan     string
+	positionNotify bool
+	awayClaim      int
+	abandonVotes   int
+	abandonEmoji   string
+	abandonGrace   int
+	milestones     string
+	chanResources  string
+	namePattern    string
+	envPattern     string
+	namingHint     string
+	gitlabSecret   string
+	autoSecret     string
+	githubSecret   string
+	slackClientID  string
+	slackSecret    string
+	statusSyncURL  string
+	tfSecret       string
+	graphqlSecret  string
+	streamSecret   string
+	ideSecret      string
+	timelineSecret string
+	statusPage     bool
+	statusTitle    string
+	statusEnvs     string
+	statusNames    string
+	statusTheme    string
+	statusCSS      string
+	statusRefresh  int
+	apiKeys        bool
+	oidcIssuer     string
+	oidcClientID   string
+	oidcSecret     string
+	oidcRedirect   string
+	ticketURL      string
+	jiraURL        string
+	jiraUser       string
+	jiraToken      string
+	k8sDiscovery   bool
+	k8sPath        string
+	k8sSelector    string
+	k8sEnv         string
+	k8sInterval    int
+	catalogChans   string
+	catalogSecs    int
 )
 
 func main() {
@@ -43,19 +129,135 @@ func main() {
 
 	flag.StringVar(&admins, "admins", util.LookupEnvOrString("SLACK_ADMINS", ""), "Turn on administrative commands for specific admins, comma separated list")
 
+	flag.StringVar(&adminGroups, "admin-groups", util.LookupEnvOrString("SLACK_ADMIN_GROUPS", ""), "Turn on administrative commands for members of Slack user groups, comma separated list of handles or IDs")
+	flag.IntVar(&adminSync, "admin-sync-interval", util.LookupEnvOrInt("ADMIN_SYNC_INTERVAL", 10), "Admin user group refresh interval in minutes")
+	flag.StringVar(&observers, "observers", util.LookupEnvOrString("SLACK_OBSERVERS", ""), "Users who can see status, history and stats but can't reserve, release or change anything, comma separated list")
+
+	flag.StringVar(&permissions, "permissions", util.LookupEnvOrString("PERMISSIONS_FILE", ""), "JSON file configuring which commands are open, owner-only or admin-only")
+
+	flag.StringVar(&teams, "teams", util.LookupEnvOrString("TEAMS", ""), "Report usage by Slack user group, comma separated list of handles or IDs, each optionally with a monthly budget like backend=500")
+
+	flag.StringVar(&freeAlerts, "free-alerts", util.LookupEnvOrString("FREE_ALERTS", ""), "Warn a channel when fewer than a number of an env's resources are free, comma separated list like staging=2:#platform")
+
+	flag.StringVar(&deadlineChan, "deadline-channel", util.LookupEnvOrString("DEADLINE_CHANNEL", ""), "Also post warnings about users who are unlikely to get a resource by their deadline to this channel")
+
+	flag.BoolVar(&isolateWs, "workspace-isolation", util.LookupEnvOrBool("WORKSPACE_ISOLATION", false), "Keep resources to the Enterprise Grid workspaces they are created in, unless they are created with --shared")
+	flag.BoolVar(&readOnly, "read-only", util.LookupEnvOrBool("READ_ONLY", false), "Answer status questions and log what would be changed, without changing anything or sending notifications")
+
+	flag.IntVar(&dmBuffer, "notification-buffer", util.LookupEnvOrInt("NOTIFICATION_BUFFER", 500), "How many DMs to keep while Slack can't be reached, to deliver once it is back. 0 drops them.")
+	flag.IntVar(&eventQueue, "event-queue-size", util.LookupEnvOrInt("EVENT_QUEUE_SIZE", 1000), "How many events from Slack may wait to be handled before more are dropped. With 0, events are handled as they arrive, without a queue.")
+	flag.IntVar(&maxEventAge, "max-event-age", util.LookupEnvOrInt("MAX_EVENT_AGE", 10), "Minutes after a command is given that it may still be handled, such as when Slack sends a backlog after a reconnect. 0 handles commands however old they are.")
+	flag.StringVar(&recordPath, "record-events", util.LookupEnvOrString("RECORD_EVENTS", ""), "Append every Events API payload received to this file, for replaying with the replay command")
+
 	flag.BoolVar(&reqResourceEnv, "require-resource-env", util.LookupEnvOrBool("REQUIRE_RESOURCE_ENV", true), "Require resource reservation to include environment")
 
 	flag.BoolVar(&pruneEnabled, "prune-enabled", util.LookupEnvOrBool("PRUNE_ENABLED", true), "Enable pruning available resources automatically")
 	flag.IntVar(&pruneInterval, "prune-interval", util.LookupEnvOrInt("PRUNE_INTERVAL", 1), "Automatic pruning interval in hours")
 	flag.IntVar(&pruneExpire, "prune-expire", util.LookupEnvOrInt("PRUNE_EXPIRE", 168), "Automatic prune expiration time in hours")
+	flag.IntVar(&retentionDays, "retention-days", util.LookupEnvOrInt("RETENTION_DAYS", 0), "Days to keep past holds, usage, snapshots and recent events for, deleting older ones every hour. 0 keeps them for good.")
+
+	flag.IntVar(&integrityMins, "integrity-interval", util.LookupEnvOrInt("INTEGRITY_INTERVAL", 60), "Minutes between checks for users who are in a queue more than once, which collapse them into their earliest place. 0 turns the checks off.")
+
+	flag.IntVar(&maintWarning, "maintenance-warning", util.LookupEnvOrInt("MAINTENANCE_WARNING", 30), "Minutes before a maintenance window to warn users in the queue")
+
+	flag.BoolVar(&blockUnhealthy, "block-unhealthy", util.LookupEnvOrBool("BLOCK_UNHEALTHY", false), "Prevent reserving resources that are failing their health check")
+	flag.StringVar(&namePattern, "resource-name-pattern", util.LookupEnvOrString("RESOURCE_NAME_PATTERN", ""), "Regular expression the whole name of every new resource must match, e.g. [a-z]+-[a-z]+-[0-9]+")
+	flag.StringVar(&envPattern, "env-name-pattern", util.LookupEnvOrString("ENV_NAME_PATTERN", ""), "Regular expression the whole env of every new resource must match")
+	flag.StringVar(&namingHint, "naming-hint", util.LookupEnvOrString("NAMING_HINT", ""), "Describes the naming convention to people who break it, e.g. team-purpose-number, like payments-db-1")
+	flag.StringVar(&chanResources, "channel-resources", util.LookupEnvOrString("CHANNEL_RESOURCES", ""), "Comma separated resources, e.g. db,api, that channels the bot is invited to are offered, created in an env named after the channel")
+	flag.BoolVar(&positionNotify, "position-updates", util.LookupEnvOrBool("POSITION_UPDATES", false), "Tell waiters whenever their place in line changes, not just when they get the resource")
+	flag.IntVar(&abandonVotes, "abandon-votes", util.LookupEnvOrInt("ABANDON_VOTES", 0), "Votes from people waiting that release a hold as abandoned, if its holder doesn't say they are still using it; 0 turns voting off")
+	flag.StringVar(&abandonEmoji, "abandon-emoji", util.LookupEnvOrString("ABANDON_EMOJI", "wastebasket"), "Reaction to the bot's messages that votes the holders of the resources they mention abandoned them")
+	flag.IntVar(&abandonGrace, "abandon-grace", util.LookupEnvOrInt("ABANDON_GRACE", 15), "Minutes holders voted out have to say they are still using the resource")
+	flag.StringVar(&milestones, "milestones", util.LookupEnvOrString("MILESTONES", "3,15m"), "Comma separated places in line and estimated waits, e.g. 3,15m, that waiters are told when they reach; empty tells them nothing")
+	flag.IntVar(&awayClaim, "away-claim-window", util.LookupEnvOrInt("AWAY_CLAIM_WINDOW", 0), "Minutes people who are away in Slack when a contended resource is handed to them have to claim it before it goes to the next person waiting who isn't; 0 hands it to them regardless")
+
+	flag.StringVar(&webhookURL, "webhook-url", util.LookupEnvOrString("WEBHOOK_URL", ""), "URL to post reservation events to as JSON")
+
+	flag.StringVar(&deploySecret, "deploy-webhook-secret", util.LookupEnvOrString("DEPLOY_WEBHOOK_SECRET", ""), "Enable the /deployments webhook, which must be called with this secret")
+	flag.StringVar(&activitySecret, "activity-secret", util.LookupEnvOrString("ACTIVITY_SECRET", ""), "Enable the /activity webhook for reporting activity on resources, which must be called with this secret, and flag holds without activity as stale")
+	flag.IntVar(&staleAfter, "stale-after", util.LookupEnvOrInt("STALE_AFTER", 24), "Hours a hold may go without activity before it is flagged as stale")
+	flag.StringVar(&staleChan, "stale-channel", util.LookupEnvOrString("STALE_CHANNEL", ""), "Post holds flagged as stale to this channel")
+	flag.StringVar(&brokenChan, "broken-channel", util.LookupEnvOrString("BROKEN_CHANNEL", ""), "Post reports of broken resources, and their fixes, to this channel")
+	flag.StringVar(&incidentSecret, "incident-secret", util.LookupEnvOrString("INCIDENT_SECRET", ""), "Enable the /incidents webhook for declaring incidents, which must be called with this secret")
+	flag.IntVar(&incidentSev, "incident-severity", util.LookupEnvOrInt("INCIDENT_SEVERITY", 2), "Least severe incident, counting up from 1, that hands incident resources to the incident commander")
+	flag.StringVar(&resetSecret, "reset-webhook-secret", util.LookupEnvOrString("RESET_WEBHOOK_SECRET", ""), "Enable the /resets webhook for reset hooks that report their outcome later, which must be called with this secret")
+
+	flag.StringVar(&gitlabSecret, "gitlab-secret", util.LookupEnvOrString("GITLAB_SECRET", ""), "Enable the /gitlab API for CI jobs, which must be called with this secret")
+	flag.StringVar(&autoSecret, "automation-secret", util.LookupEnvOrString("AUTOMATION_SECRET", ""), "Enable the /automation webhooks for reserving resources on behalf of automations, which must be called with this secret")
+
+	flag.StringVar(&githubSecret, "github-webhook-secret", util.LookupEnvOrString("GITHUB_WEBHOOK_SECRET", ""), "Enable the /github webhook, which creates and removes a resource for each pull request and must be signed with this secret")
+
+	flag.StringVar(&slackClientID, "slack-client-id", util.LookupEnvOrString("SLACK_CLIENT_ID", ""), "Client ID of the Slack app, for users to grant it permission to set their status")
+	flag.StringVar(&slackSecret, "slack-client-secret", util.LookupEnvOrString("SLACK_CLIENT_SECRET", ""), "Client secret of the Slack app")
+	flag.StringVar(&statusSyncURL, "status-sync-url", util.LookupEnvOrString("STATUS_SYNC_URL", ""), "Enable syncing the Slack status of users who opt in with what they hold. This public URL of the listen port, such as https://reservebot.example.com/slack/status, must be a redirect URL of the Slack app.")
+
+	flag.StringVar(&tfSecret, "terraform-secret", util.LookupEnvOrString("TERRAFORM_SECRET", ""), "Enable the /terraform lock endpoint for Terraform's http backend, which must be called with this password")
+
+	flag.StringVar(&graphqlSecret, "graphql-secret", util.LookupEnvOrString("GRAPHQL_SECRET", ""), "Enable the /graphql API for dashboards, which must be called with this secret")
+
+	flag.StringVar(&streamSecret, "stream-secret", util.LookupEnvOrString("STREAM_SECRET", ""), "Enable the /events stream of reservation changes, which must be called with this secret")
+
+	flag.StringVar(&ideSecret, "ide-secret", util.LookupEnvOrString("IDE_SECRET", ""), "Enable the /ide/status endpoint for editor extensions, signing the personal tokens it accepts with this secret")
+
+	flag.StringVar(&timelineSecret, "timeline-secret", util.LookupEnvOrString("TIMELINE_SECRET", ""), "Enable the /timeline endpoint listing or drawing who held each resource, which must be called with this secret")
+
+	flag.BoolVar(&statusPage, "status-page", util.LookupEnvOrBool("STATUS_PAGE", false), "Serve a read-only /board page of which resources are free and held, for office TVs, which needs no secret")
+	flag.StringVar(&statusTitle, "status-page-title", util.LookupEnvOrString("STATUS_PAGE_TITLE", "Environments"), "Title of the status page")
+	flag.StringVar(&statusEnvs, "status-page-envs", util.LookupEnvOrString("STATUS_PAGE_ENVS", ""), "Only show these envs on the status page, comma separated list")
+	flag.StringVar(&statusNames, "status-page-names", util.LookupEnvOrString("STATUS_PAGE_NAMES", "hidden"), "How the status page shows who holds resources: hidden, initials or full")
+	flag.StringVar(&statusTheme, "status-page-theme", util.LookupEnvOrString("STATUS_PAGE_THEME", "light"), "Theme of the status page: light or dark")
+	flag.StringVar(&statusCSS, "status-page-stylesheet", util.LookupEnvOrString("STATUS_PAGE_STYLESHEET", ""), "URL of a stylesheet applied to the status page after its theme")
+	flag.IntVar(&statusRefresh, "status-page-refresh", util.LookupEnvOrInt("STATUS_PAGE_REFRESH", 30), "Seconds between reloads of the status page")
+	flag.StringVar(&oidcIssuer, "oidc-issuer", util.LookupEnvOrString("OIDC_ISSUER", ""), "Enable signing in to the /me page with this OpenID Connect provider, such as https://accounts.google.com or an Okta org's URL")
+	flag.StringVar(&oidcClientID, "oidc-client-id", util.LookupEnvOrString("OIDC_CLIENT_ID", ""), "Client ID of the bot at the OpenID Connect provider")
+	flag.StringVar(&oidcSecret, "oidc-client-secret", util.LookupEnvOrString("OIDC_CLIENT_SECRET", ""), "Client secret of the bot at the OpenID Connect provider, which also signs sessions")
+	flag.StringVar(&oidcRedirect, "oidc-redirect-url", util.LookupEnvOrString("OIDC_REDIRECT_URL", ""), "Public URL of /auth/callback on the listen port, such as https://reservebot.example.com/auth/callback, which must be a redirect URL of the client")
+	flag.BoolVar(&apiKeys, "api-keys", util.LookupEnvOrBool("API_KEYS", false), "Enable every webhook and endpoint that takes a secret, even if its secret isn't set, for callers with API keys made by admins")
+
+	flag.StringVar(&ticketURL, "ticket-url", util.LookupEnvOrString("TICKET_URL", ""), "Link ticket IDs using this URL, with %s replaced by the ID")
+	flag.StringVar(&jiraURL, "jira-url", util.LookupEnvOrString("JIRA_URL", ""), "Validate ticket IDs with the Jira instance at this URL")
+	flag.StringVar(&jiraUser, "jira-user", util.LookupEnvOrString("JIRA_USER", ""), "Jira user for validating tickets")
+	flag.StringVar(&jiraToken, "jira-token", util.LookupEnvOrString("JIRA_TOKEN", ""), "Jira API token for validating tickets")
+
+	flag.BoolVar(&k8sDiscovery, "k8s-discovery", util.LookupEnvOrBool("K8S_DISCOVERY", false), "Register Kubernetes objects in the cluster the bot runs in as resources")
+	flag.StringVar(&k8sPath, "k8s-path", util.LookupEnvOrString("K8S_PATH", "/api/v1/namespaces"), "Kubernetes API path listing the objects to register")
+	flag.StringVar(&k8sSelector, "k8s-selector", util.LookupEnvOrString("K8S_SELECTOR", ""), "Label selector for the Kubernetes objects to register")
+	flag.StringVar(&k8sEnv, "k8s-env", util.LookupEnvOrString("K8S_ENV", "k8s"), "Environment for registered Kubernetes objects without a reservebot/env label")
+	flag.IntVar(&k8sInterval, "k8s-interval", util.LookupEnvOrInt("K8S_INTERVAL", 60), "Kubernetes discovery interval in seconds")
+	flag.StringVar(&catalogChans, "catalog-channels", util.LookupEnvOrString("CATALOG_CHANNELS", ""), "Comma-separated IDs of channels whose topic and bookmarks declare resources after `reservebot:`, which are created and removed to match")
+	flag.IntVar(&catalogSecs, "catalog-interval", util.LookupEnvOrInt("CATALOG_INTERVAL", 300), "How often, in seconds, the resources declared in channels are synced")
 
 	flag.StringVar(&redisAddr, "redis-address", util.LookupEnvOrString("REDIS_ADDRESS", "localhost:6379"), "Redis Database Address")
 	flag.StringVar(&redisPass, "redis-pw", util.LookupEnvOrString("REDIS_PASS", ""), "Redis Database Password")
 	flag.IntVar(&redisDB, "redis-database", util.LookupEnvOrInt("REDIS_DB", 0), "Redis Database")
+	flag.StringVar(&replicaAddr, "redis-replica-address", util.LookupEnvOrString("REDIS_REPLICA_ADDRESS", ""), "Read status and queues from this Redis read replica, such as one in the bot's region, while it is within the max lag of the primary")
+	flag.IntVar(&replicaLag, "redis-replica-max-lag", util.LookupEnvOrInt("REDIS_REPLICA_MAX_LAG", 5), "Seconds the Redis replica may be behind the primary before reads go to the primary")
 	flag.BoolVar(&useRedis, "use-redis", util.LookupEnvOrBool("USE_REDIS", false), "Activate redis db")
+	flag.StringVar(&stateKeys, "state-encryption-keys", util.LookupEnvOrString("STATE_ENCRYPTION_KEYS", ""), "Encrypt what is stored in Redis with AES-GCM, using these comma separated base64 keys of 16, 24 or 32 bytes. The first encrypts, and all decrypt, so keys can be rotated.")
+	flag.StringVar(&stateKeysCmd, "state-encryption-keys-command", util.LookupEnvOrString("STATE_ENCRYPTION_KEYS_COMMAND", ""), "Shell command printing the state encryption keys, such as one decrypting them with a KMS, instead of giving them")
+
+	flag.Float64Var(&faultRate, "fault-rate", util.LookupEnvOrFloat("FAULT_RATE", 0), "Probability from 0 to 1 of failing each data operation, for testing in staging")
+	flag.IntVar(&faultLatency, "fault-latency", util.LookupEnvOrInt("FAULT_LATENCY", 0), "Most milliseconds to delay each data operation by, for testing in staging")
+	flag.StringVar(&faultOps, "fault-ops", util.LookupEnvOrString("FAULT_OPS", ""), "Data operations to inject faults into, comma separated list such as Reserve,Remove. All operations if empty")
 
 	flag.Parse()
 
+	if flag.Arg(0) == "replay" {
+		if err := replay(flag.Arg(1)); err != nil {
+			log.Errorf("%+v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "simulate" {
+		if err := simulate(flag.Args()[1:]); err != nil {
+			log.Errorf("%+v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Make sure required vars are set
 	if token == "" {
 		log.Error("Slack token is required")
@@ -75,8 +277,83 @@ func main() {
 	d = data.NewMemory()
 	if useRedis {
 		log.Infof("Redis Enabled")
-		d = data.NewRedis(redisAddr, redisPass, redisDB)
+		r := data.NewRedis(redisAddr, redisPass, redisDB)
+		if stateKeys != "" || stateKeysCmd != "" {
+			sealer, err := stateSealer(stateKeys, stateKeysCmd)
+			if err != nil {
+				log.Fatalf("Error loading the state encryption keys: %+v", err)
+			}
+			log.Infof("Encrypting stored state.")
+			r.Encrypt(sealer)
+		}
+		if replicaAddr != "" && readOnly {
+			log.Infof("Read-only: not reading from the Redis replica, as checking its lag writes to the primary")
+		} else if replicaAddr != "" {
+			log.Infof("Reading from the Redis replica at %s while it is within %ds of the primary", replicaAddr, replicaLag)
+			r.ReadFrom(replicaAddr, redisPass, time.Duration(replicaLag)*time.Second)
+		}
+		if readOnly {
+			log.Infof("Read-only: not migrating any legacy data")
+		} else if m, err := r.MigrateLegacy(); err != nil {
+			log.Fatalf("Error migrating legacy data: %+v", err)
+		} else if m != nil {
+			log.Infof("Migrated %d resources and %d reservations from the legacy format, skipping %d invalid reservations", m.Resources, m.Reservations, m.Skipped)
+		}
+		d = r
+	}
+
+	if faultRate > 0 || faultLatency > 0 {
+		log.Warnf("Injecting faults into the data layer. Never do this in production.")
+		d = data.NewFaulty(d, data.FaultConfig{
+			ErrorRate: faultRate,
+			Latency:   time.Duration(faultLatency) * time.Millisecond,
+			Ops:       util.ParseAdmins(faultOps),
+			Seed:      time.Now().UnixNano(),
+		})
+	}
+
+	// Changes to reservations are published to the bus, whose subscribers handle the side effects
+	bus := events.NewBus()
+	bus.Subscribe(events.Audit)
+	bus.Subscribe(events.Metrics)
+	if webhookURL != "" {
+		log.Infof("Posting events to webhook")
+		webhook := events.NewWebhook(webhookURL)
+		bus.Subscribe(webhook.Handle)
+		go webhook.Run()
+	}
+	d = events.NewManager(d, bus)
+	if readOnly {
+		// changes are logged before they could reach the bus, so no events are published either
+		log.Infof("Read-only mode is enabled. Nothing will be changed.")
+		d = data.NewReadOnly(d)
+	}
+
+	// Dashboards can query recent changes, which are kept in memory
+	history := events.NewHistory(1000)
+	bus.Subscribe(history.Handle)
+	if k8sDiscovery {
+		client, err := kube.InCluster()
+		if err != nil {
+			log.Errorf("Error starting Kubernetes discovery: %+v", err)
+			return
+		}
+		log.Infof("Kubernetes discovery is enabled.")
+		discoverer := kube.NewDiscoverer(client, kube.Config{
+			Path:          k8sPath,
+			LabelSelector: k8sSelector,
+			Env:           k8sEnv,
+		}, d)
+		go func() {
+			for {
+				if err := discoverer.Sync(); err != nil {
+					log.Errorf("Error discovering Kubernetes resources: %+v", err)
+				}
+				time.Sleep(time.Duration(k8sInterval) * time.Second)
+			}
+		}()
 	}
+
 	if pruneEnabled {
 		// Prune inactive resources
 		log.Infof("Automatic Pruning is enabled.")
@@ -95,7 +372,311 @@ func main() {
 		log.Infof("Automatic pruning is disabled.")
 	}
 
-	handler := handler.New(api, d, reqResourceEnv, util.ParseAdmins(admins))
+	if retentionDays > 0 {
+		// Delete history older than the retention period, so that it doesn't grow without bound
+		log.Infof("Keeping history for %d days.", retentionDays)
+		go func() {
+			for {
+				before := time.Now().AddDate(0, 0, -retentionDays)
+				c, err := d.Compact(before)
+				if err != nil {
+					log.Errorf("Error compacting history: %+v", err)
+				} else {
+					trimmed := history.Trim(before)
+					log.Infof("Compacted history, deleting %d holds, %d usage records, %d snapshots and %d recent events", c.Holds, c.Usage, c.Snapshots, trimmed)
+				}
+				time.Sleep(time.Hour)
+			}
+		}()
+	}
+
+	if integrityMins > 0 {
+		// Collapse duplicate queue entries, which a partial write can leave behind
+		go func() {
+			for {
+				repairs, err := d.Dedupe()
+				if err != nil {
+					log.Errorf("Error checking queues for duplicates: %+v", err)
+				}
+				for _, r := range repairs {
+					log.Warnf("Removed %d duplicate entries for %s from the queue for %s", r.Removed, r.UserID, r.Resource)
+				}
+				time.Sleep(time.Duration(integrityMins) * time.Minute)
+			}
+		}()
+	}
+
+	resolver := tickets.NewResolver(tickets.Config{
+		URLPattern: ticketURL,
+		JiraURL:    jiraURL,
+		JiraUser:   jiraUser,
+		JiraToken:  jiraToken,
+	})
+
+	handler := handler.New(api, d, resolver, reqResourceEnv, util.ParseAdmins(admins), util.ParseAdmins(adminGroups), blockUnhealthy)
+	bus.Subscribe(handler.HandleEvent)
+	handler.SetHistory(history)
+	handler.SetObservers(util.ParseAdmins(observers))
+	if readOnly {
+		handler.SetReadOnly()
+	}
+
+	if permissions != "" {
+		if err := handler.LoadPermissions(permissions); err != nil {
+			log.Fatalf("Error loading permissions: %+v", err)
+		}
+	}
+	if err := handler.SetTeams(teams); err != nil {
+		log.Fatalf("Invalid teams: %+v", err)
+	}
+	if err := handler.SetFreeAlerts(freeAlerts); err != nil {
+		log.Fatalf("Invalid free alerts: %+v", err)
+	}
+	if err := handler.SetNamingPolicy(namePattern, envPattern, namingHint); err != nil {
+		log.Fatalf("Invalid naming convention: %+v", err)
+	}
+	handler.SetDeadlineChannel(deadlineChan)
+	handler.SetBrokenChannel(brokenChan)
+	handler.SetIncidentSeverity(incidentSev)
+	if positionNotify {
+		handler.SetPositionUpdates()
+	}
+	handler.SetAwayClaimWindow(time.Duration(awayClaim) * time.Minute)
+	if err := handler.SetMilestones(milestones); err != nil {
+		log.Fatalf("Invalid milestones: %+v", err)
+	}
+	handler.SetAbandonVoting(abandonVotes, abandonEmoji, time.Duration(abandonGrace)*time.Minute)
+	handler.SetChannelResources(util.ParseAdmins(chanResources))
+
+	// The bot's own user ID tells mentions of it apart from mentions of others, wherever they are
+	if auth, err := api.AuthTest(); err != nil {
+		log.Errorf("Error looking up the bot's user ID, it must be mentioned at the start of commands: %+v", err)
+	} else {
+		handler.SetBotUserID(auth.UserID)
+		if auth.EnterpriseID != "" {
+			log.Infof("Installed in Enterprise Grid org %s.", auth.EnterpriseID)
+		}
+	}
+	if isolateWs {
+		log.Infof("Resources are kept to the workspaces they are created in.")
+		handler.SetWorkspaceIsolation()
+	}
+
+	// Keep admin and team user group membership current
+	if err := handler.SyncAdminGroups(); err != nil {
+		log.Errorf("Error syncing admin groups: %+v", err)
+	}
+	if err := handler.SyncTeams(); err != nil {
+		log.Errorf("Error syncing teams: %+v", err)
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(adminSync) * time.Minute)
+			if err := handler.SyncAdminGroups(); err != nil {
+				log.Errorf("Error syncing admin groups: %+v", err)
+			}
+			if err := handler.SyncTeams(); err != nil {
+				log.Errorf("Error syncing teams: %+v", err)
+			}
+		}
+	}()
+
+	if deploySecret != "" || apiKeys {
+		log.Infof("Deployment webhook enabled.")
+		http.Handle("/deployments", handler.DeploymentWebhook(deploySecret))
+	}
+	if activitySecret != "" || apiKeys {
+		log.Infof("Activity webhook enabled.")
+		handler.SetStaleness(time.Duration(staleAfter)*time.Hour, staleChan)
+		http.Handle("/activity", handler.ActivityWebhook(activitySecret))
+	}
+	if incidentSecret != "" || apiKeys {
+		log.Infof("Incident webhook enabled.")
+		http.Handle("/incidents", handler.IncidentWebhook(incidentSecret))
+	}
+	if resetSecret != "" || apiKeys {
+		log.Infof("Reset webhook enabled.")
+		http.Handle("/resets", handler.ResetWebhook(resetSecret))
+	}
+	if gitlabSecret != "" || apiKeys {
+		log.Infof("GitLab CI API enabled.")
+		http.Handle("/gitlab/", http.StripPrefix("/gitlab", handler.GitLabBridge(gitlabSecret)))
+	}
+
+	if autoSecret != "" {
+		log.Infof("Automation webhooks enabled.")
+	}
+	// service accounts call the automation webhooks with their own tokens, even without the secret
+	http.Handle("/automation/", http.StripPrefix("/automation", handler.AutomationWebhook(autoSecret)))
+	if githubSecret != "" {
+		log.Infof("GitHub webhook enabled.")
+		http.Handle("/github", handler.GitHubWebhook(githubSecret))
+	}
+	if statusSyncURL != "" {
+		u, err := url.Parse(statusSyncURL)
+		if err != nil || slackClientID == "" || slackSecret == "" {
+			log.Fatalf("Status sync needs a valid URL, a Slack client ID and a client secret")
+		}
+		log.Infof("Status sync enabled.")
+		http.Handle(u.Path, handler.StatusSync(slackClientID, slackSecret, statusSyncURL))
+	}
+	if tfSecret != "" || apiKeys {
+		log.Infof("Terraform lock endpoint enabled.")
+		http.Handle("/terraform/", http.StripPrefix("/terraform", handler.TerraformLock(tfSecret)))
+	}
+
+	if ideSecret != "" {
+		log.Infof("IDE status endpoint enabled.")
+		http.Handle("/ide/status", handler.IDEStatus(ideSecret))
+	}
+
+	if timelineSecret != "" || apiKeys {
+		log.Infof("Timeline endpoint enabled.")
+		http.Handle("/timeline/", http.StripPrefix("/timeline", handler.Timeline(timelineSecret)))
+	}
+
+	if statusPage {
+		board, err := handler.StatusPage(statusTitle, util.ParseAdmins(statusEnvs), statusNames, statusTheme, statusCSS, time.Duration(statusRefresh)*time.Second)
+		if err != nil {
+			log.Fatalf("Error enabling the status page: %+v", err)
+		}
+		log.Infof("Status page enabled.")
+		http.Handle("/board", board)
+	}
+
+	if apiKeys {
+		log.Infof("Forget endpoint enabled.")
+		http.Handle("/forget", handler.ForgetWebhook())
+		log.Infof("Import endpoint enabled.")
+		http.Handle("/import", handler.ImportWebhook())
+	}
+
+	if oidcIssuer != "" {
+		u, err := url.Parse(oidcRedirect)
+		if err != nil || u.Path != "/auth/callback" || oidcClientID == "" || oidcSecret == "" {
+			log.Fatalf("OIDC sign in needs a client ID, a client secret and a redirect URL ending in /auth/callback")
+		}
+		auth, err := handler.OIDC(oidcIssuer, oidcClientID, oidcSecret, oidcRedirect)
+		if err != nil {
+			log.Fatalf("Error enabling OIDC sign in: %+v", err)
+		}
+		log.Infof("OIDC sign in enabled.")
+		http.Handle("/auth/", http.StripPrefix("/auth", auth))
+		http.Handle("/me", handler.MyReservations())
+	}
+
+	http.Handle("/openapi.json", httpapi.SpecHandler())
+	if graphqlSecret != "" {
+		schema, err := gql.NewSchema(d, history)
+		if err != nil {
+			log.Errorf("Error building GraphQL schema: %+v", err)
+			return
+		}
+		log.Infof("GraphQL API enabled.")
+		http.Handle("/graphql", gql.Handler(schema, graphqlSecret))
+	}
+	if streamSecret != "" {
+		stream := events.NewStream(d)
+		bus.Subscribe(stream.Handle)
+		go stream.Run()
+		log.Infof("Event stream enabled.")
+		http.Handle("/events", stream.Handler(streamSecret))
+	}
+
+	// Serve event metrics at /debug/vars, along with the API and any enabled webhooks
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", listenPort), nil); err != nil {
+			log.Errorf("Error serving HTTP: %+v", err)
+		}
+	}()
+
+	// Deliver queued DMs without exceeding Slack's rate limits, keeping those that can't be delivered
+	// while Slack is out of reach
+	handler.SetNotificationBuffer(dmBuffer)
+	go handler.SendNotifications()
+
+	// Handle events from Slack in the order they came, dropping commands that are too old to act on. With
+	// no queue, each is handled as it arrives.
+	if eventQueue < 0 {
+		log.Fatalf("Invalid event queue size %d: it must be 0 or more", eventQueue)
+	}
+	if eventQueue == 0 {
+		log.Infof("Handling events from Slack as they arrive, without a queue.")
+	}
+	handler.SetEventIntake(eventQueue, time.Duration(maxEventAge)*time.Minute)
+	go handler.HandleEvents()
+
+	// Warn users of upcoming maintenance windows
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			handler.WarnMaintenance(time.Duration(maintWarning) * time.Minute)
+		}
+	}()
+
+	// Open and close resources with office hours, hold draws that are due, release reservations that
+	// have run past their duration, rotate resources among the users waiting for them, warn those who
+	// are unlikely to get a resource by their deadline, fail resets that are taking too long, flag stale
+	// holds, hand on resources that holders who were away didn't claim, release holds voted abandoned,
+	// tell waiters whose turn is getting close, run scheduled commands that are due, and keep how long
+	// resources have been held current in live status messages
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			handler.CheckOfficeHours()
+			handler.DrawLotteries()
+			handler.ExpireReservations()
+			handler.RotateReservations()
+			handler.CheckDeadlines()
+			handler.CheckResets()
+			handler.CheckStale()
+			handler.CheckClaims()
+			handler.CheckAbandoned()
+			handler.CheckMilestones()
+			handler.RunScheduledActions()
+			handler.UpdateLiveStatuses()
+		}
+	}()
+
+	if catalogChans != "" {
+		// Keep the resources declared in channels in step with their topics and bookmarks
+		log.Infof("Channel catalogs are enabled.")
+		go func() {
+			for {
+				if err := handler.SyncChannelCatalogs(util.ParseAdmins(catalogChans)); err != nil {
+					log.Errorf("%+v", err)
+				}
+				time.Sleep(time.Duration(catalogSecs) * time.Second)
+			}
+		}()
+	}
+
+	// Sample how many people are waiting for each resource, for the capacity report
+	go func() {
+		for {
+			time.Sleep(10 * time.Minute)
+			handler.SampleContention(10 * time.Minute)
+		}
+	}()
+
+	// Run resource health checks as they come due
+	go func() {
+		for {
+			time.Sleep(15 * time.Second)
+			handler.CheckHealth()
+		}
+	}()
+
+	var rec *recorder
+	if recordPath != "" {
+		r, err := newRecorder(recordPath)
+		if err != nil {
+			log.Errorf("Error opening %s for recording events: %+v", recordPath, err)
+			return
+		}
+		rec = r
+		log.Infof("Recording events to %s", recordPath)
+	}
 
 	client := socketmode.New(
 		api,
@@ -107,10 +688,16 @@ func main() {
 			switch evt.Type {
 			case socketmode.EventTypeConnecting:
 				log.Info("Connecting to Slack with Socket Mode...")
+				handler.SetConnected(false)
 			case socketmode.EventTypeConnectionError:
 				log.Info("Connection failed. Retrying later...")
+				handler.SetConnected(false)
+			case socketmode.EventTypeDisconnect:
+				log.Info("Slack asked to reconnect.")
+				handler.SetConnected(false)
 			case socketmode.EventTypeConnected:
 				log.Info("Connected to Slack with Socket Mode.")
+				handler.SetConnected(true)
 			case socketmode.EventTypeEventsAPI:
 				eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
 				if !ok {
@@ -120,10 +707,27 @@ func main() {
 
 				fmt.Printf("Event received: %+v\n", eventsAPIEvent)
 				client.Ack(*evt.Request)
+				if rec != nil {
+					rec.record(evt.Request.Payload)
+				}
+
+				handler.QueueEvent(eventsAPIEvent)
+			case socketmode.EventTypeInteractive:
+				callback, ok := evt.Data.(slack.InteractionCallback)
+				if !ok {
+					fmt.Printf("Ignored %+v\n", evt)
+					continue
+				}
 
-				if err := handler.CallbackEvent(eventsAPIEvent); err != nil {
+				payload, err := handler.Interaction(callback)
+				if err != nil {
 					log.Errorf("%+v", err)
 				}
+				if payload != nil {
+					client.Ack(*evt.Request, payload)
+				} else {
+					client.Ack(*evt.Request)
+				}
 			default:
 				fmt.Fprintf(os.Stderr, "Unexpected event type received: %s\n", evt.Type)
 			}
@@ -133,3 +737,15 @@ func main() {
 	client.Run()
 
 }
+
+// stateSealer returns a sealer for the state encryption keys, which are printed by command if one is given
+func stateSealer(keys, command string) (*data.Sealer, error) {
+	if command != "" {
+		out, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			return nil, fmt.Errorf("error running %s: %v", command, err)
+		}
+		keys = string(out)
+	}
+	return data.NewSealer(util.ParseAdmins(keys))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/simulation"
+	"github.com/ameliagapin/reservebot/util"
+)
+
+// simulate replays the holds remembered by the backend against a hypothetical configuration, such as
+// more resources in an env or a cap on how long resources may be held, and prints how long people would
+// have waited compared with the current configuration. Each hold is replayed as someone asking for the
+// resource when they joined its queue and holding it for as long as they did. Envs given more resources
+// are replayed as pools whose resources anyone could have used, in both configurations, since extra
+// resources only help those who can use any of them. Other resources keep their own queues.
+func simulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	add := fs.String("add", "", "Resources to add to envs, comma separated list such as staging=2,dev=1")
+	maxHold := fs.Duration("max-hold", 0, "How long a resource may be held while others are waiting, such as 2h, after which the holder goes to the back of the line")
+	days := fs.Int("days", int(models.HoldHistory/(24*time.Hour)), "Days of holds to replay")
+	env := fs.String("env", "", "Only replay the holds of this env")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	extra, err := parseAdded(*add)
+	if err != nil {
+		return err
+	}
+	if len(extra) == 0 && *maxHold <= 0 {
+		return errors.New("usage: reservebot [flags] simulate [-add env=n,...] [-max-hold duration] [-days n] [-env env]")
+	}
+
+	var d data.Manager
+	d = data.NewMemory()
+	if useRedis {
+		d = data.NewRedis(redisAddr, redisPass, redisDB)
+	}
+
+	since := time.Now().Add(-time.Duration(*days) * 24 * time.Hour)
+	requests := []simulation.Request{}
+	baseline := simulation.Config{Size: map[string]int{}}
+	whatIf := simulation.Config{Size: map[string]int{}, MaxHold: *maxHold}
+	unjoined := 0
+	for _, r := range d.GetResources() {
+		if *env != "" && r.Env != *env {
+			continue
+		}
+		pool := r.Key()
+		if _, ok := extra[r.Env]; ok {
+			pool = "env " + r.Env
+		}
+		baseline.Size[pool]++
+		whatIf.Size[pool]++
+
+		holds, err := d.GetHolds(r.Name, r.Env, since)
+		if err != nil {
+			return err
+		}
+		for _, h := range holds {
+			arrived := h.Joined
+			if arrived.IsZero() || arrived.After(h.Start) {
+				// how long they waited wasn't recorded, so they are taken to have got it at once
+				arrived = h.Start
+				unjoined++
+			}
+			requests = append(requests, simulation.Request{Pool: pool, Arrived: arrived, Duration: h.End.Sub(h.Start)})
+		}
+	}
+	for e, n := range extra {
+		if baseline.Size["env "+e] == 0 {
+			return fmt.Errorf("there are no resources in %s", e)
+		}
+		whatIf.Size["env "+e] += n
+	}
+	if len(requests) == 0 {
+		return fmt.Errorf("no holds were remembered in the last %d days", *days)
+	}
+
+	before := simulation.Run(requests, baseline)
+	after := simulation.Run(requests, whatIf)
+
+	fmt.Printf("Replayed %d holds from the last %d days", len(requests), *days)
+	if unjoined > 0 {
+		fmt.Printf(", %d of them without a record of when their holders joined the queue, which are taken to have not waited", unjoined)
+	}
+	fmt.Println(".")
+	fmt.Println()
+
+	pools := []string{}
+	for pool, s := range before.Pools {
+		if s.Waited > 0 || after.Pools[pool].Waited > 0 || whatIf.Size[pool] != baseline.Size[pool] {
+			pools = append(pools, pool)
+		}
+	}
+	sort.Strings(pools)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tresources\trequests\twaited\taverage wait\t90% waited under\tlongest wait")
+	row := func(name string, sizeBefore, sizeAfter int, b, a *simulation.Stats) {
+		size := strconv.Itoa(sizeBefore)
+		if sizeAfter != sizeBefore {
+			size += " → " + strconv.Itoa(sizeAfter)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", name, size, b.Requests,
+			change(strconv.Itoa(b.Waited), strconv.Itoa(a.Waited)),
+			change(waitText(b.Average), waitText(a.Average)),
+			change(waitText(b.P90), waitText(a.P90)),
+			change(waitText(b.Max), waitText(a.Max)))
+	}
+	for _, pool := range pools {
+		row(pool, baseline.Size[pool], whatIf.Size[pool], before.Pools[pool], after.Pools[pool])
+	}
+	row("overall", total(baseline.Size), total(whatIf.Size), before.Overall, after.Overall)
+	return w.Flush()
+}
+
+// parseAdded parses the resources to add to envs, such as staging=2,dev=1
+func parseAdded(text string) (map[string]int, error) {
+	ret := map[string]int{}
+	for _, item := range util.ParseAdmins(text) {
+		split := strings.SplitN(item, "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("%q should be an env and how many resources to add, such as staging=2", item)
+		}
+		n, err := strconv.Atoi(split[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%q should add at least one resource", item)
+		}
+		ret[strings.TrimSpace(split[0])] += n
+	}
+	return ret, nil
+}
+
+// change shows a value before and after, or only once if it didn't change
+func change(before, after string) string {
+	if before == after {
+		return before
+	}
+	return before + " → " + after
+}
+
+// waitText shows a wait to the minute
+func waitText(d time.Duration) string {
+	if d < time.Minute {
+		return "0m"
+	}
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
+
+func total(sizes map[string]int) int {
+	ret := 0
+	for _, n := range sizes {
+		ret += n
+	}
+	return ret
+}
@@ -0,0 +1,187 @@
+// Package simulation replays past demand for resources against other configurations, such as more
+// resources or a cap on how long they may be held, to estimate how long people would have waited.
+package simulation
+
+import (
+	"sort"
+	"time"
+)
+
+// Request is a past hold replayed as demand: someone asked for a resource at Arrived and held it for
+// Duration once they got it
+type Request struct {
+	// Pool is the resources that can serve the request, such as a single resource or a whole env
+	Pool     string
+	Arrived  time.Time
+	Duration time.Duration
+}
+
+// Config is a configuration to replay demand against
+type Config struct {
+	// Size is how many interchangeable resources each pool has. Pools that aren't listed have one.
+	Size map[string]int
+	// MaxHold is how long a resource may be held while others are waiting, after which the holder goes
+	// to the back of the line with what they have left to do, like rotation. Zero holds for as long as
+	// needed.
+	MaxHold time.Duration
+}
+
+// Stats sums up how long requests waited
+type Stats struct {
+	Requests int
+	// Waited is how many requests waited at all
+	Waited  int
+	Average time.Duration
+	// P90 is the wait that 90% of requests waited no longer than
+	P90 time.Duration
+	Max time.Duration
+}
+
+// Result is how long requests waited in each pool and overall
+type Result struct {
+	Pools   map[string]*Stats
+	Overall *Stats
+}
+
+// job is a request being replayed
+type job struct {
+	req Request
+	// left is how long the job has yet to hold a resource for
+	left time.Duration
+	// queued is when the job last joined the line
+	queued time.Time
+	wait   time.Duration
+}
+
+// slot is a resource of a pool, held by a job until a time, or free if nil
+type slot struct {
+	job   *job
+	until time.Time
+}
+
+// Run replays requests against a configuration. Each pool serves its line in the order requests arrive,
+// handing each free resource to the longest waiting request.
+func Run(requests []Request, cfg Config) *Result {
+	pools := map[string][]*job{}
+	for _, r := range requests {
+		pools[r.Pool] = append(pools[r.Pool], &job{req: r, left: r.Duration})
+	}
+
+	ret := &Result{Pools: map[string]*Stats{}}
+	all := []time.Duration{}
+	for pool, jobs := range pools {
+		size := cfg.Size[pool]
+		if size < 1 {
+			size = 1
+		}
+		waits := runPool(jobs, size, cfg.MaxHold)
+		ret.Pools[pool] = stats(waits)
+		all = append(all, waits...)
+	}
+	ret.Overall = stats(all)
+	return ret
+}
+
+// runPool replays the jobs of a pool with a number of resources, returning how long each waited in all
+func runPool(jobs []*job, size int, maxHold time.Duration) []time.Duration {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].req.Arrived.Before(jobs[j].req.Arrived)
+	})
+
+	slots := make([]*slot, size)
+	line := []*job{}
+	next := 0
+	turn := func(j *job) time.Duration {
+		if maxHold > 0 && j.left > maxHold {
+			return maxHold
+		}
+		return j.left
+	}
+
+	for next < len(jobs) || len(line) > 0 || busy(slots) {
+		// move to the next arrival or the next turn ending, whichever is first
+		now := time.Time{}
+		if next < len(jobs) {
+			now = jobs[next].req.Arrived
+		}
+		for _, s := range slots {
+			if s != nil && (now.IsZero() || s.until.Before(now)) {
+				now = s.until
+			}
+		}
+
+		for next < len(jobs) && !jobs[next].req.Arrived.After(now) {
+			jobs[next].queued = jobs[next].req.Arrived
+			line = append(line, jobs[next])
+			next++
+		}
+		for i, s := range slots {
+			if s == nil || s.until.After(now) {
+				continue
+			}
+			if s.job.left <= 0 {
+				slots[i] = nil
+				continue
+			}
+			if len(line) == 0 {
+				// nobody is waiting, so the holder keeps it for another turn
+				s.until = now.Add(turn(s.job))
+				s.job.left -= turn(s.job)
+				continue
+			}
+			s.job.queued = now
+			line = append(line, s.job)
+			slots[i] = nil
+		}
+		for i, s := range slots {
+			if s != nil || len(line) == 0 {
+				continue
+			}
+			j := line[0]
+			line = line[1:]
+			j.wait += now.Sub(j.queued)
+			d := turn(j)
+			j.left -= d
+			slots[i] = &slot{job: j, until: now.Add(d)}
+		}
+	}
+
+	waits := make([]time.Duration, len(jobs))
+	for i, j := range jobs {
+		waits[i] = j.wait
+	}
+	return waits
+}
+
+// busy returns if any resource of a pool is held
+func busy(slots []*slot) bool {
+	for _, s := range slots {
+		if s != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// stats sums up waits
+func stats(waits []time.Duration) *Stats {
+	ret := &Stats{Requests: len(waits)}
+	if len(waits) == 0 {
+		return ret
+	}
+	sorted := append([]time.Duration{}, waits...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	var total time.Duration
+	for _, w := range sorted {
+		total += w
+		if w > 0 {
+			ret.Waited++
+		}
+	}
+	ret.Average = total / time.Duration(len(sorted))
+	ret.P90 = sorted[(len(sorted)*9+9)/10-1]
+	ret.Max = sorted[len(sorted)-1]
+	return ret
+}
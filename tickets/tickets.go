@@ -0,0 +1,104 @@
+// Package tickets links reservations to tickets in an issue tracker. Ticket IDs can be expanded to links
+// with a URL pattern, or validated and expanded with their summary using the Jira API.
+package tickets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// NotFound is returned when the issue tracker doesn't know a ticket
+var NotFound = errors.New("ticket not found")
+
+// Config configures how ticket IDs are resolved. Every field is optional.
+type Config struct {
+	// URLPattern builds a link from a ticket ID, with %s replaced by the ID. It is ignored when Jira
+	// is configured.
+	URLPattern string
+	// JiraURL is the base URL of a Jira instance, e.g. https://example.atlassian.net
+	JiraURL string
+	// JiraUser and JiraToken authenticate with Jira using basic auth
+	JiraUser  string
+	JiraToken string
+}
+
+// Resolver expands ticket IDs. A nil Resolver returns tickets with only their ID.
+type Resolver struct {
+	config Config
+	client *http.Client
+}
+
+func NewResolver(config Config) *Resolver {
+	config.JiraURL = strings.TrimSuffix(config.JiraURL, "/")
+	return &Resolver{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Resolve expands a ticket ID. It returns NotFound if Jira is configured and doesn't know the ticket.
+func (r *Resolver) Resolve(id string) (*models.Ticket, error) {
+	t := &models.Ticket{ID: id}
+	if r == nil {
+		return t, nil
+	}
+
+	if r.config.JiraURL == "" {
+		if r.config.URLPattern != "" {
+			t.URL = fmt.Sprintf(r.config.URLPattern, id)
+		}
+		return t, nil
+	}
+
+	summary, err := r.jiraSummary(id)
+	if err != nil {
+		return nil, err
+	}
+	t.URL = fmt.Sprintf("%s/browse/%s", r.config.JiraURL, id)
+	t.Summary = summary
+	return t, nil
+}
+
+type jiraIssue struct {
+	Fields struct {
+		Summary string `json:"summary"`
+	} `json:"fields"`
+}
+
+func (r *Resolver) jiraSummary(id string) (string, error) {
+	u := fmt.Sprintf("%s/rest/api/2/issue/%s?fields=summary", r.config.JiraURL, url.PathEscape(id))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	if r.config.JiraUser != "" {
+		req.SetBasicAuth(r.config.JiraUser, r.config.JiraToken)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", NotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("jira returned %s for %s", resp.Status, id)
+	}
+
+	issue := &jiraIssue{}
+	if err := json.NewDecoder(resp.Body).Decode(issue); err != nil {
+		return "", err
+	}
+	return issue.Fields.Summary, nil
+}
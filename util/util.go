@@ -49,6 +49,14 @@ func LookupEnvOrInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func LookupEnvOrFloat(key string, defaultVal float64) float64 {
+	if val, ok := os.LookupEnv(key); ok {
+		v, _ := strconv.ParseFloat(val, 64)
+		return v
+	}
+	return defaultVal
+}
+
 func LookupEnvOrBool(key string, defaultVal bool) bool {
 	if val, ok := os.LookupEnv(key); ok {
 		if val == "true" {